@@ -34,8 +34,15 @@ func init() {
 	server.RegisterModule(&server.Module{
 		Name: MODULE_NAME,
 		PostInit: func() {
+			configureErrorReporting()
+			configureMethodStats()
+			configureAttachmentOCR()
 			err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
 				h.Group().NewSet(env).ReloadGroups()
+				h.Model().NewSet(env).ReflectModels()
+				h.Model().NewSet(env).CreateIndexes()
+				seedISOCurrencies(env)
+				startSetupWizard(env)
 			})
 			if err != nil {
 				log.Panic("Error while initializing", "error", err)
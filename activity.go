@@ -0,0 +1,184 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// ActivityStates is the selection of the states of an activity relative to its deadline
+var ActivityStates = types.Selection{
+	"overdue": "Overdue",
+	"today":   "Today",
+	"planned": "Planned",
+}
+
+// userToday returns the current date in the timezone of the given user
+func userToday(user m.UserSet) dates.Date {
+	dt, err := dates.Now().WithTimezone(user.TZ())
+	if err != nil {
+		return dates.Today()
+	}
+	return dates.ParseDate(dt.Format("2006-01-02"))
+}
+
+var fields_Activity = map[string]models.FieldDefinition{
+	"Summary": fields.Char{Required: true},
+	"Note":    fields.Text{},
+	"DateDeadline": fields.Date{String: "Due Date", Required: true, Index: true,
+		OnChangeWarning: h.Activity().Methods().OnchangeUserWarning(),
+		Default: func(env models.Environment) interface{} {
+			return dates.Today()
+		}},
+	"User": fields.Many2One{String: "Assigned To", RelationModel: h.User(), Required: true, Index: true,
+		OnDelete: models.Cascade, OnChangeWarning: h.Activity().Methods().OnchangeUserWarning(),
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		}},
+	"ResModel": fields.Char{String: "Related Model", Index: true},
+	"ResID":    fields.Integer{String: "Related Record ID", Index: true},
+	"ResName": fields.Char{String: "Related Record", Compute: h.Activity().Methods().ComputeResName(),
+		Depends: []string{"ResModel", "ResID"}},
+	"State": fields.Selection{Selection: ActivityStates, Compute: h.Activity().Methods().ComputeState(),
+		Depends: []string{"DateDeadline"}},
+}
+
+// ComputeResName returns the display name of the record of this activity
+func activity_ComputeResName(rs m.ActivitySet) m.ActivityData {
+	return h.Activity().NewData().SetResName(ReferencedRecordName(rs.Env(), rs.ResModel(), rs.ResID()))
+}
+
+// ComputeState returns whether this activity is overdue, due today or planned
+// in the timezone of its assigned user.
+func activity_ComputeState(rs m.ActivitySet) m.ActivityData {
+	today := userToday(rs.User())
+	state := "planned"
+	switch {
+	case rs.DateDeadline().Lower(today):
+		state = "overdue"
+	case rs.DateDeadline().Equal(today):
+		state = "today"
+	}
+	return h.Activity().NewData().SetState(state)
+}
+
+// ActionDone marks these activities as done by deleting them
+func activity_ActionDone(rs m.ActivitySet) {
+	rs.Unlink()
+}
+
+// activityReminderLine is an activity in the data of the reminder email template
+type activityReminderLine struct {
+	Summary  string
+	Deadline string
+	Record   string
+}
+
+// activityReminderData is the data of the activity reminder email template
+type activityReminderData struct {
+	UserName    string
+	UserEmail   string
+	Today       string
+	OutOfOffice string
+	Overdue     []activityReminderLine
+	DueToday    []activityReminderLine
+}
+
+// SendReminders sends to each user an email listing their activities that
+// are due today or overdue, dates being evaluated in the user's timezone.
+// Users who disabled activity reminders in their preferences or who are out
+// of office are skipped, and reminders of users who delegated their
+// notifications are sent to their delegate, with the user's out of office
+// notice if any.
+//
+// This is the entry point of the activity reminder cron job.
+func activity_SendReminders(rs m.ActivitySet) {
+	template := h.MailTemplate().NewSet(rs.Env()).GetRecord("base_mail_template_activity_reminder")
+	// Deadlines are compared in each user's timezone, which can be one day ahead of UTC
+	limit := dates.Today().AddDate(0, 0, 1)
+	users := h.User().NewSet(rs.Env())
+	for _, activity := range h.Activity().Search(rs.Env(), q.Activity().DateDeadline().LowerOrEqual(limit)).Records() {
+		users = users.Union(activity.User())
+	}
+	for _, user := range users.Records() {
+		if !user.ActivityReminder() {
+			continue
+		}
+		today := userToday(user)
+		recipient := user.DelegateFor(DelegationScopeNotifications, today)
+		if (recipient.Equals(user) && user.IsOutOfOfficeOn(today)) || recipient.Email() == "" {
+			continue
+		}
+		activities := h.Activity().Search(rs.Env(),
+			q.Activity().User().Equals(user).And().DateDeadline().LowerOrEqual(today)).
+			OrderBy("DateDeadline", "ID")
+		if activities.IsEmpty() {
+			continue
+		}
+		data := activityReminderData{
+			UserName:    user.Name(),
+			UserEmail:   recipient.Partner().EmailFormatted(),
+			Today:       today.String(),
+			OutOfOffice: user.OutOfOfficeNotice(today),
+		}
+		for _, activity := range activities.Records() {
+			line := activityReminderLine{
+				Summary:  activity.Summary(),
+				Deadline: activity.DateDeadline().String(),
+				Record:   activity.ResName(),
+			}
+			if activity.DateDeadline().Lower(today) {
+				data.Overdue = append(data.Overdue, line)
+			} else {
+				data.DueToday = append(data.DueToday, line)
+			}
+		}
+		if err := template.ForPartner(recipient.Partner()).SendMail(data); err != nil {
+			log.Warn("Unable to send activity reminder", "user", user.ID(), "error", err)
+		}
+	}
+}
+
+// ICSEvent returns the deadline of this activity as an all-day VEVENT
+// component in iCalendar format.
+func activity_ICSEvent(rs m.ActivitySet) string {
+	rs.EnsureOne()
+	var res strings.Builder
+	res.WriteString(ICSLine("BEGIN", "VEVENT"))
+	res.WriteString(ICSLine("UID", fmt.Sprintf("activity-%d@hexya", rs.ID())))
+	res.WriteString(ICSLine("DTSTAMP", dates.Now().UTC().Format("20060102T150405Z")))
+	res.WriteString(ICSLine("DTSTART;VALUE=DATE", rs.DateDeadline().Format("20060102")))
+	res.WriteString(ICSLine("DTEND;VALUE=DATE", rs.DateDeadline().AddDate(0, 0, 1).Format("20060102")))
+	summary := rs.Summary()
+	if rs.ResName() != "" {
+		summary = fmt.Sprintf("%s (%s)", summary, rs.ResName())
+	}
+	res.WriteString(ICSLine("SUMMARY", ICSText(summary)))
+	if rs.Note() != "" {
+		res.WriteString(ICSLine("DESCRIPTION", ICSText(rs.Note())))
+	}
+	res.WriteString(ICSLine("END", "VEVENT"))
+	return res.String()
+}
+
+func init() {
+	models.NewModel("Activity")
+	h.Activity().SetDefaultOrder("DateDeadline", "ID")
+	h.Activity().AddFields(fields_Activity)
+	h.Activity().InheritModel(h.KanbanMixin())
+	h.Activity().NewMethod("ComputeResName", activity_ComputeResName)
+	h.Activity().NewMethod("ComputeState", activity_ComputeState)
+	h.Activity().NewMethod("ActionDone", activity_ActionDone)
+	h.Activity().NewMethod("SendReminders", activity_SendReminders)
+	h.Activity().NewMethod("ICSEvent", activity_ICSEvent)
+}
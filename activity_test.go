@@ -0,0 +1,91 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestActivityReminders(t *testing.T) {
+	Convey("Testing activity reminders", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			sender := new(testMailSender)
+			previous := SetMailSender(sender)
+			defer SetMailSender(previous)
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			user := h.User().Create(env, h.User().NewData().
+				SetName("Reminded User").
+				SetLogin("reminded_user").
+				SetEmail("reminded@example.com").
+				SetTZ("UTC"))
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Activity Partner"))
+			today := dates.Today()
+			overdue := h.Activity().Create(env, h.Activity().NewData().
+				SetSummary("Call back").
+				SetUser(user).
+				SetDateDeadline(today.AddDate(0, 0, -2)).
+				SetResModel("Partner").
+				SetResID(partner.ID()))
+			h.Activity().Create(env, h.Activity().NewData().
+				SetSummary("Send quotation").
+				SetUser(user).
+				SetDateDeadline(today))
+			h.Activity().Create(env, h.Activity().NewData().
+				SetSummary("Plan meeting").
+				SetUser(user).
+				SetDateDeadline(today.AddDate(0, 0, 5)))
+			Convey("Activities have a state and a record name", func() {
+				So(overdue.State(), ShouldEqual, "overdue")
+				So(overdue.ResName(), ShouldEqual, "Activity Partner")
+			})
+			Convey("Users receive their due and overdue activities", func() {
+				h.Activity().NewSet(env).SendReminders()
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].To, ShouldHaveLength, 1)
+				So(sender.sent[0].To[0], ShouldContainSubstring, "reminded@example.com")
+				So(sender.sent[0].Body, ShouldContainSubstring, "Call back")
+				So(sender.sent[0].Body, ShouldContainSubstring, "Activity Partner")
+				So(sender.sent[0].Body, ShouldContainSubstring, "Send quotation")
+				So(strings.Contains(sender.sent[0].Body, "Plan meeting"), ShouldBeFalse)
+			})
+			Convey("Delegates receive the reminders of absent users with their notice", func() {
+				deputy := h.User().Create(env, h.User().NewData().
+					SetName("Deputy User").
+					SetLogin("reminded_deputy").
+					SetEmail("deputy@example.com"))
+				h.Delegation().Create(env, h.Delegation().NewData().
+					SetFromUser(user).
+					SetToUser(deputy).
+					SetScope(DelegationScopeNotifications))
+				user.Write(h.User().NewData().
+					SetOutOfOfficeFrom(today).
+					SetOutOfOfficeMessage("Back on Monday"))
+				h.Activity().NewSet(env).SendReminders()
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].To[0], ShouldContainSubstring, "deputy@example.com")
+				So(sender.sent[0].Body, ShouldContainSubstring, "Reminded User is out of office.")
+				So(sender.sent[0].Body, ShouldContainSubstring, "Back on Monday")
+			})
+			Convey("Users can opt out of reminders", func() {
+				user.SetActivityReminder(false)
+				h.Activity().NewSet(env).SendReminders()
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(sender.sent, ShouldBeEmpty)
+			})
+			Convey("Done activities are deleted", func() {
+				overdue.ActionDone()
+				So(h.Activity().NewSet(env).SearchAll().Len(), ShouldEqual, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
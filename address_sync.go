@@ -0,0 +1,75 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/tools/typesutils"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Policies of the synchronisation of contact addresses with their relatives
+const (
+	// AddressSyncAlways overwrites the address of contacts
+	AddressSyncAlways = "always"
+	// AddressSyncEmpty only sets the address of contacts that have none
+	AddressSyncEmpty = "empty"
+	// AddressSyncNever never changes the address of contacts
+	AddressSyncNever = "never"
+)
+
+// AddressSyncPolicies is the selection of the address synchronisation policies
+var AddressSyncPolicies = types.Selection{
+	AddressSyncAlways: "Always Synchronize",
+	AddressSyncEmpty:  "Only Empty Addresses",
+	AddressSyncNever:  "Never Synchronize",
+}
+
+// AddressSyncPolicy returns the address synchronisation policy of this partner,
+// as set on the partner's company or on the current user's company.
+func partner_AddressSyncPolicy(rs m.PartnerSet) string {
+	company := rs.Company()
+	if company.IsEmpty() {
+		company = h.User().NewSet(rs.Env()).CurrentUser().Company()
+	}
+	if company.AddressSyncPolicy() == "" {
+		return AddressSyncAlways
+	}
+	return company.AddressSyncPolicy()
+}
+
+// HasAddress returns true if at least one of the address fields of this partner is set
+func partner_HasAddress(rs m.PartnerSet) bool {
+	rs.EnsureOne()
+	for _, addrField := range rs.AddressFields() {
+		if !typesutils.IsZero(rs.Get(addrField)) {
+			return true
+		}
+	}
+	return false
+}
+
+// AcceptsAddressSync returns true if the address of this partner may be
+// overwritten when the address of its relatives changes, according to its
+// KeepAddress flag and to its address synchronisation policy.
+func partner_AcceptsAddressSync(rs m.PartnerSet) bool {
+	rs.EnsureOne()
+	if rs.KeepAddress() {
+		return false
+	}
+	switch rs.AddressSyncPolicy() {
+	case AddressSyncNever:
+		return false
+	case AddressSyncEmpty:
+		return !rs.HasAddress()
+	}
+	return true
+}
+
+func init() {
+	h.Partner().NewMethod("AddressSyncPolicy", partner_AddressSyncPolicy)
+	h.Partner().NewMethod("HasAddress", partner_HasAddress)
+	h.Partner().NewMethod("AcceptsAddressSync", partner_AcceptsAddressSync)
+}
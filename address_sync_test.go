@@ -0,0 +1,61 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAddressSync(t *testing.T) {
+	Convey("Testing address synchronisation policies", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Create(env, h.Company().NewData().SetName("Sync Company"))
+			parent := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Parent Company").
+				SetIsCompany(true).
+				SetCompany(company).
+				SetCity("Lyon"))
+			contact := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Contact").
+				SetParent(parent).
+				SetCompany(company))
+			other := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Other Contact").
+				SetParent(parent).
+				SetCompany(company))
+			So(contact.City(), ShouldEqual, "Lyon")
+			Convey("Addresses are always synchronised by default", func() {
+				So(contact.AddressSyncPolicy(), ShouldEqual, AddressSyncAlways)
+				parent.SetCity("Paris")
+				So(contact.City(), ShouldEqual, "Paris")
+				So(other.City(), ShouldEqual, "Paris")
+			})
+			Convey("Contacts keeping their address are not synchronised", func() {
+				contact.SetKeepAddress(true)
+				contact.SetCity("Marseille")
+				parent.SetCity("Paris")
+				So(contact.City(), ShouldEqual, "Marseille")
+				So(other.City(), ShouldEqual, "Paris")
+			})
+			Convey("Only empty addresses are synchronised with the 'empty' policy", func() {
+				company.SetAddressSyncPolicy(AddressSyncEmpty)
+				other.WithoutSync().Write(h.Partner().NewData().SetCity(""))
+				parent.SetCity("Paris")
+				So(contact.City(), ShouldEqual, "Lyon")
+				So(other.City(), ShouldEqual, "Paris")
+			})
+			Convey("Addresses are never synchronised with the 'never' policy", func() {
+				company.SetAddressSyncPolicy(AddressSyncNever)
+				parent.SetCity("Paris")
+				So(contact.City(), ShouldEqual, "Lyon")
+				So(other.City(), ShouldEqual, "Lyon")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,339 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Address validation statuses of partners
+const (
+	AddressUnverified = "unverified"
+	AddressValid      = "valid"
+	AddressInvalid    = "invalid"
+	AddressError      = "error"
+)
+
+// AddressValidationStatuses is the selection of the address validation statuses of partners
+var AddressValidationStatuses = types.Selection{
+	AddressUnverified: "Not Verified",
+	AddressValid:      "Verified",
+	AddressInvalid:    "Invalid",
+	AddressError:      "Verification Failed",
+}
+
+var addressValidators = struct {
+	sync.RWMutex
+	validators map[string]basetypes.AddressValidator
+	labels     types.Selection
+}{
+	validators: make(map[string]basetypes.AddressValidator),
+	labels:     make(types.Selection),
+}
+
+// RegisterAddressValidator registers the given AddressValidator under the
+// given name, so that it can be selected in the company settings.
+// It panics if a validator is already registered with this name.
+func RegisterAddressValidator(name, label string, validator basetypes.AddressValidator) {
+	addressValidators.Lock()
+	defer addressValidators.Unlock()
+	if _, exists := addressValidators.validators[name]; exists {
+		log.Panic("Address validator already registered", "name", name)
+	}
+	addressValidators.validators[name] = validator
+	addressValidators.labels[name] = label
+}
+
+// GetAddressValidator returns the AddressValidator registered with the given name.
+func GetAddressValidator(name string) (basetypes.AddressValidator, bool) {
+	addressValidators.RLock()
+	defer addressValidators.RUnlock()
+	validator, ok := addressValidators.validators[name]
+	return validator, ok
+}
+
+// AddressValidatorsSelection returns the selection of the registered address validators
+func AddressValidatorsSelection() types.Selection {
+	addressValidators.RLock()
+	defer addressValidators.RUnlock()
+	res := make(types.Selection)
+	for name, label := range addressValidators.labels {
+		res[name] = label
+	}
+	return res
+}
+
+// NoneAddressValidator does not check anything and always reports
+// addresses as unverified. It is the default validator.
+type NoneAddressValidator struct{}
+
+// Validate returns the given address untouched
+func (NoneAddressValidator) Validate(_ models.Environment, address basetypes.AddressData) (basetypes.AddressValidationResult, error) {
+	return basetypes.AddressValidationResult{Address: address}, nil
+}
+
+// ZipPatterns are the zip code formats per country code used by the StrictAddressValidator
+var ZipPatterns = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"LU": regexp.MustCompile(`^\d{4}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"PT": regexp.MustCompile(`^\d{4}-\d{3}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+// StrictAddressValidator normalizes spacing and case of addresses and
+// checks that mandatory parts are present and that the zip code matches
+// the format of the country, if known.
+type StrictAddressValidator struct{}
+
+// normalizeSpaces trims the given string and collapses inner spaces
+func normalizeSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// Validate checks the given address against ZipPatterns
+func (StrictAddressValidator) Validate(_ models.Environment, address basetypes.AddressData) (basetypes.AddressValidationResult, error) {
+	address.Street = normalizeSpaces(address.Street)
+	address.Street2 = normalizeSpaces(address.Street2)
+	address.City = normalizeSpaces(address.City)
+	address.Zip = strings.ToUpper(normalizeSpaces(address.Zip))
+	res := basetypes.AddressValidationResult{Address: address}
+	switch {
+	case address.Street == "":
+		res.Message = "street is missing"
+	case address.City == "":
+		res.Message = "city is missing"
+	case address.CountryCode == "":
+		res.Message = "country is missing"
+	default:
+		pattern, ok := ZipPatterns[address.CountryCode]
+		if ok && !pattern.MatchString(address.Zip) {
+			res.Message = fmt.Sprintf("'%s' is not a valid zip code for %s", address.Zip, address.CountryName)
+			break
+		}
+		res.Valid = true
+	}
+	return res, nil
+}
+
+// ProviderAddressValidator validates addresses through an external HTTP API.
+//
+// The address is POSTed as JSON to the URL set in the
+// 'base.address_validation.url' config parameter, with the API key of the
+// 'base.address_validation.api_key' parameter as Bearer token. The API
+// must answer with the JSON encoded normalized address and 'valid' and
+// 'message' keys.
+type ProviderAddressValidator struct {
+	Client *http.Client
+}
+
+// addressProviderResponse is the expected response of address validation APIs
+type addressProviderResponse struct {
+	Valid   bool   `json:"valid"`
+	Message string `json:"message"`
+	Street  string `json:"street"`
+	Street2 string `json:"street2"`
+	Zip     string `json:"zip"`
+	City    string `json:"city"`
+}
+
+// Validate sends the address to the API and returns its answer
+func (p ProviderAddressValidator) Validate(env models.Environment, address basetypes.AddressData) (basetypes.AddressValidationResult, error) {
+	params := h.ConfigParameter().NewSet(env).AsSuperUser("read address validation API settings")
+	url := params.GetParam("base.address_validation.url", "")
+	if url == "" {
+		return basetypes.AddressValidationResult{}, errors.New("address validation API URL is not configured")
+	}
+	body, err := json.Marshal(map[string]string{
+		"street":       address.Street,
+		"street2":      address.Street2,
+		"zip":          address.Zip,
+		"city":         address.City,
+		"state_code":   address.StateCode,
+		"country_code": address.CountryCode,
+	})
+	if err != nil {
+		return basetypes.AddressValidationResult{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return basetypes.AddressValidationResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := params.GetParam("base.address_validation.api_key", ""); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return basetypes.AddressValidationResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return basetypes.AddressValidationResult{}, fmt.Errorf("address validation API returned status %d", resp.StatusCode)
+	}
+	var data addressProviderResponse
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return basetypes.AddressValidationResult{}, err
+	}
+	// Keep the parts of the address that the API did not return
+	if data.Street != "" {
+		address.Street = data.Street
+	}
+	if data.Street2 != "" {
+		address.Street2 = data.Street2
+	}
+	if data.Zip != "" {
+		address.Zip = data.Zip
+	}
+	if data.City != "" {
+		address.City = data.City
+	}
+	return basetypes.AddressValidationResult{Address: address, Valid: data.Valid, Message: data.Message}, nil
+}
+
+// AddressValidator returns the AddressValidator to use for this partner,
+// as set on the partner's company or on the current user's company.
+// It returns nil if address validation is disabled.
+func partner_AddressValidator(rs m.PartnerSet) basetypes.AddressValidator {
+	company := rs.Company()
+	if company.IsEmpty() {
+		company = h.User().NewSet(rs.Env()).CurrentUser().Company()
+	}
+	name := company.AddressValidator()
+	if name == "" || name == "none" {
+		return nil
+	}
+	validator, ok := GetAddressValidator(name)
+	if !ok {
+		log.Warn("Unknown address validator", "company", company.ID(), "validator", name)
+		return nil
+	}
+	return validator
+}
+
+// ValidateAddress validates the address of the partners of this recordset with
+// the validator of their company. The validation status is written on each
+// partner, as well as the normalized address parts of valid addresses.
+//
+// Validators may call external services, so this method should not be called
+// inside a user transaction. Use EnqueueAddressValidation instead.
+func partner_ValidateAddress(rs m.PartnerSet) {
+	for _, partner := range rs.Records() {
+		validator := partner.AddressValidator()
+		if validator == nil {
+			continue
+		}
+		address := basetypes.AddressData{
+			Street:      partner.Street(),
+			Street2:     partner.Street2(),
+			City:        partner.City(),
+			Zip:         partner.Zip(),
+			StateCode:   partner.State().Code(),
+			StateName:   partner.State().Name(),
+			CountryCode: partner.Country().Code(),
+			CountryName: partner.Country().Name(),
+		}
+		data := h.Partner().NewData()
+		result, err := validator.Validate(rs.Env(), address)
+		switch {
+		case err != nil:
+			log.Warn("Error while validating address", "partner", partner.ID(), "error", err)
+			data.SetAddressValidationStatus(AddressError).
+				SetAddressValidationMessage(err.Error())
+		case !result.Valid:
+			data.SetAddressValidationStatus(AddressInvalid).
+				SetAddressValidationMessage(result.Message)
+		default:
+			// Normalized parts left empty by the validator must not erase
+			// the address of the partner.
+			if result.Address.Street != "" {
+				data.SetStreet(result.Address.Street)
+			}
+			if result.Address.Street2 != "" {
+				data.SetStreet2(result.Address.Street2)
+			}
+			if result.Address.Zip != "" {
+				data.SetZip(result.Address.Zip)
+			}
+			if result.Address.City != "" {
+				data.SetCity(result.Address.City)
+			}
+			data.SetAddressValidationStatus(AddressValid).
+				SetAddressValidationMessage(result.Message)
+		}
+		partner.WithContext(ContextKeySkipAddressValidation, true).Write(data)
+	}
+}
+
+// EnqueueAddressValidation resets the validation status of the partners of this
+// recordset that have an address validator and queues the validation of their
+// address, so that it runs outside of the current transaction.
+func partner_EnqueueAddressValidation(rs m.PartnerSet) {
+	toValidate := h.Partner().NewSet(rs.Env())
+	for _, partner := range rs.Records() {
+		if partner.AddressValidator() == nil {
+			continue
+		}
+		toValidate = toValidate.Union(partner)
+	}
+	if toValidate.IsEmpty() {
+		return
+	}
+	toValidate.WithContext(ContextKeySkipAddressValidation, true).Write(h.Partner().NewData().
+		SetAddressValidationStatus(AddressUnverified).
+		SetAddressValidationMessage(""))
+	toValidate.AsSuperUser("queue address validation").
+		Enqueue(rs.T("Validate partner addresses"), h.Partner().Methods().ValidateAddress())
+}
+
+// addressChanged returns true if the given data modify the address of a partner
+func addressChanged(rs m.PartnerSet, vals m.PartnerData) bool {
+	if ContextGetBool(rs.Env(), ContextKeySkipAddressValidation) {
+		return false
+	}
+	for _, addrField := range rs.AddressFields() {
+		if vals.Has(addrField) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	RegisterAddressValidator("none", "No Validation", NoneAddressValidator{})
+	RegisterAddressValidator("strict", "Strict Format Check", StrictAddressValidator{})
+	RegisterAddressValidator("provider", "External Provider API", ProviderAddressValidator{})
+
+	h.Partner().NewMethod("AddressValidator", partner_AddressValidator)
+	h.Partner().NewMethod("ValidateAddress", partner_ValidateAddress)
+	h.Partner().NewMethod("EnqueueAddressValidation", partner_EnqueueAddressValidation)
+}
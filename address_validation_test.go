@@ -0,0 +1,68 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAddressValidation(t *testing.T) {
+	Convey("Testing address validation", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+			company := h.User().NewSet(env).CurrentUser().Company()
+			Convey("Addresses are not verified by default", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Unverified").
+					SetCity("Paris"))
+				So(partner.AddressValidationStatus(), ShouldEqual, AddressUnverified)
+			})
+			Convey("Address changes queue the validation", func() {
+				company.SetAddressValidator("strict")
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Queued").
+					SetCity("Paris"))
+				So(partner.AddressValidationStatus(), ShouldEqual, AddressUnverified)
+				job := h.QueueJob().Search(env, q.QueueJob().Model().Equals("Partner").
+					And().Method().Equals("ValidateAddress"))
+				So(job.IsNotEmpty(), ShouldBeTrue)
+			})
+			Convey("Strict validation normalizes and checks addresses", func() {
+				company.SetAddressValidator("strict")
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Strict").
+					SetStreet("  1   rue de la Paix ").
+					SetZip("75002").
+					SetCity("Paris ").
+					SetCountry(france))
+				partner.ValidateAddress()
+				So(partner.AddressValidationStatus(), ShouldEqual, AddressValid)
+				So(partner.Street(), ShouldEqual, "1 rue de la Paix")
+				So(partner.City(), ShouldEqual, "Paris")
+				partner.SetZip("7500")
+				So(partner.AddressValidationStatus(), ShouldEqual, AddressUnverified)
+				partner.ValidateAddress()
+				So(partner.AddressValidationStatus(), ShouldEqual, AddressInvalid)
+				So(partner.AddressValidationMessage(), ShouldNotBeBlank)
+				So(partner.Zip(), ShouldEqual, "7500")
+			})
+			Convey("Provider validation without configuration reports an error", func() {
+				company.SetAddressValidator("provider")
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Provider").
+					SetStreet("1 rue de la Paix").
+					SetCity("Paris"))
+				partner.ValidateAddress()
+				So(partner.AddressValidationStatus(), ShouldEqual, AddressError)
+				So(partner.Street(), ShouldEqual, "1 rue de la Paix")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// aliasNameRegex is the pattern that alias names must match
+var aliasNameRegex = regexp.MustCompile(`^[a-z0-9!#$%&'*+/=?^_{|}~-]+(\.[a-z0-9!#$%&'*+/=?^_{|}~-]+)*$`)
+
+// An AliasHandler processes an incoming email sent to an alias of its model.
+// It typically creates a record of the alias model with the alias default values.
+type AliasHandler func(alias m.AliasSet, msg *mail.Message) error
+
+var aliasHandlers = struct {
+	sync.RWMutex
+	handlers map[string]AliasHandler
+}{
+	handlers: make(map[string]AliasHandler),
+}
+
+// RegisterAliasHandler registers the handler of incoming emails sent to the
+// aliases of the given model. It panics if a handler is already registered
+// for this model.
+func RegisterAliasHandler(model string, handler AliasHandler) {
+	aliasHandlers.Lock()
+	defer aliasHandlers.Unlock()
+	if _, exists := aliasHandlers.handlers[model]; exists {
+		log.Panic("Alias handler already registered", "model", model)
+	}
+	aliasHandlers.handlers[model] = handler
+}
+
+// GetAliasHandler returns the handler registered for the given model
+func GetAliasHandler(model string) (AliasHandler, bool) {
+	aliasHandlers.RLock()
+	defer aliasHandlers.RUnlock()
+	handler, ok := aliasHandlers.handlers[model]
+	return handler, ok
+}
+
+var fields_Alias = map[string]models.FieldDefinition{
+	"Name": fields.Char{String: "Alias Name", Required: true, Index: true,
+		Constraint: h.Alias().Methods().CheckName(),
+		Help:       "Local part of the email address, e.g. 'sales' for sales@example.com"},
+	"Model": fields.Char{String: "Target Model", Required: true, Constraint: h.Alias().Methods().CheckModel(),
+		Help: "Model whose handler processes the emails sent to this alias, e.g. a lead or ticket model"},
+	"Defaults": fields.Text{String: "Default Values", Default: models.DefaultValue("{}"),
+		Constraint: h.Alias().Methods().CheckDefaults(),
+		Help:       "JSON object of the default values of the records created from incoming emails"},
+	"User": fields.Many2One{String: "Owner", RelationModel: h.User(),
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		},
+		Help: "User on behalf of whom the records are created"},
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+	"Email":  fields.Char{Compute: h.Alias().Methods().ComputeEmail(), Depends: []string{"Name", "Company.AliasDomain"}},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckName checks that the alias name is a valid email local part
+// which is not used as catchall alias.
+func alias_CheckName(rs m.AliasSet) {
+	for _, alias := range rs.Records() {
+		if !aliasNameRegex.MatchString(alias.Name()) {
+			log.Panic(rs.T("Invalid alias name '%s': it must be a valid lowercase email local part.", alias.Name()))
+		}
+		if alias.Name() == alias.Company().CatchallAlias() {
+			log.Panic(rs.T("'%s' is the catchall alias of the company.", alias.Name()))
+		}
+	}
+}
+
+// CheckModel checks that the target model exists
+func alias_CheckModel(rs m.AliasSet) {
+	for _, alias := range rs.Records() {
+		if _, exists := models.Registry.Get(alias.Model()); !exists {
+			log.Panic(rs.T("Unknown model '%s'", alias.Model()))
+		}
+	}
+}
+
+// CheckDefaults checks that the default values are a valid JSON object
+func alias_CheckDefaults(rs m.AliasSet) {
+	for _, alias := range rs.Records() {
+		var defaults map[string]interface{}
+		if err := json.Unmarshal([]byte(alias.Defaults()), &defaults); err != nil {
+			log.Panic(rs.T("Invalid default values for alias '%s': %s", alias.Name(), err))
+		}
+	}
+}
+
+// ComputeEmail computes the full email address of the alias
+func alias_ComputeEmail(rs m.AliasSet) m.AliasData {
+	res := h.Alias().NewData()
+	if rs.Company().AliasDomain() == "" {
+		return res.SetEmail(rs.Name())
+	}
+	return res.SetEmail(fmt.Sprintf("%s@%s", rs.Name(), rs.Company().AliasDomain()))
+}
+
+// DefaultValues returns the decoded default values of this alias
+func alias_DefaultValues(rs m.AliasSet) map[string]interface{} {
+	rs.EnsureOne()
+	res := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(rs.Defaults()), &res); err != nil {
+		log.Warn("Invalid alias default values", "alias", rs.ID(), "error", err)
+	}
+	return res
+}
+
+// FindForAddress returns the alias matching the given email address, or an
+// empty AliasSet. The domain of the address must be the alias domain of the
+// alias company, unless this company has no alias domain.
+func alias_FindForAddress(rs m.AliasSet, address string) m.AliasSet {
+	local, domain := splitEmailAddress(address)
+	if local == "" {
+		return h.Alias().NewSet(rs.Env())
+	}
+	for _, alias := range h.Alias().Search(rs.Env(), q.Alias().Name().Equals(local)).Records() {
+		if aliasDomain := alias.Company().AliasDomain(); aliasDomain == "" || aliasDomain == domain {
+			return alias
+		}
+	}
+	return h.Alias().NewSet(rs.Env())
+}
+
+// RouteMessage processes the given incoming email with the handler registered
+// for the model of this alias. The handler is executed as the alias owner.
+func alias_RouteMessage(rs m.AliasSet, msg *mail.Message) error {
+	rs.EnsureOne()
+	handler, ok := GetAliasHandler(rs.Model())
+	if !ok {
+		return fmt.Errorf("no handler registered for model %s", rs.Model())
+	}
+	alias := rs
+	if rs.User().IsNotEmpty() {
+		alias = rs.Sudo(rs.User().ID())
+	}
+	return handler(alias, msg)
+}
+
+// splitEmailAddress returns the lowercase local part and domain of the given address
+func splitEmailAddress(address string) (string, string) {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		address = addr.Address
+	}
+	address = strings.ToLower(strings.TrimSpace(address))
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return address, ""
+	}
+	return address[:at], address[at+1:]
+}
+
+// IsCatchallAddress returns true if the given email address is the catchall
+// address of this company. Emails sent to the catchall address are replies
+// to be routed to the thread they answer.
+func company_IsCatchallAddress(rs m.CompanySet, address string) bool {
+	rs.EnsureOne()
+	if rs.CatchallAlias() == "" {
+		return false
+	}
+	local, domain := splitEmailAddress(address)
+	return local == rs.CatchallAlias() && (rs.AliasDomain() == "" || domain == rs.AliasDomain())
+}
+
+// CatchallEmail returns the catchall email address of this company, or
+// an empty string if the company has no alias domain.
+func company_CatchallEmail(rs m.CompanySet) string {
+	rs.EnsureOne()
+	if rs.AliasDomain() == "" || rs.CatchallAlias() == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s@%s", rs.CatchallAlias(), rs.AliasDomain())
+}
+
+func init() {
+	models.NewModel("Alias")
+	h.Alias().SetDefaultOrder("Name")
+	h.Alias().AddFields(fields_Alias)
+	h.Alias().AddSQLConstraint("name_company_uniq", "unique(name, company_id)", "An alias with the same name already exists for this company!")
+
+	h.Alias().NewMethod("CheckName", alias_CheckName)
+	h.Alias().NewMethod("CheckModel", alias_CheckModel)
+	h.Alias().NewMethod("CheckDefaults", alias_CheckDefaults)
+	h.Alias().NewMethod("ComputeEmail", alias_ComputeEmail)
+	h.Alias().NewMethod("DefaultValues", alias_DefaultValues)
+	h.Alias().NewMethod("FindForAddress", alias_FindForAddress)
+	h.Alias().NewMethod("RouteMessage", alias_RouteMessage)
+
+	h.Company().NewMethod("IsCatchallAddress", company_IsCatchallAddress)
+	h.Company().NewMethod("CatchallEmail", company_CatchallEmail)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAlias(t *testing.T) {
+	Convey("Testing email aliases", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.User().NewSet(env).CurrentUser().Company()
+			company.SetAliasDomain("example.com")
+			alias := h.Alias().Create(env, h.Alias().NewData().
+				SetName("contacts").
+				SetModel("Partner").
+				SetDefaults(`{"is_company": true}`))
+			Convey("Alias email is computed", func() {
+				So(alias.Email(), ShouldEqual, "contacts@example.com")
+				So(alias.DefaultValues(), ShouldResemble, map[string]interface{}{"is_company": true})
+			})
+			Convey("Invalid aliases are rejected", func() {
+				So(func() {
+					h.Alias().Create(env, h.Alias().NewData().SetName("Bad Name").SetModel("Partner"))
+				}, ShouldPanic)
+				So(func() {
+					h.Alias().Create(env, h.Alias().NewData().SetName("catchall").SetModel("Partner"))
+				}, ShouldPanic)
+				So(func() {
+					h.Alias().Create(env, h.Alias().NewData().SetName("nomodel").SetModel("NoModel"))
+				}, ShouldPanic)
+				So(func() {
+					h.Alias().Create(env, h.Alias().NewData().SetName("baddefaults").SetModel("Partner").SetDefaults("{"))
+				}, ShouldPanic)
+			})
+			Convey("Aliases are found by address", func() {
+				pSet := h.Alias().NewSet(env)
+				So(pSet.FindForAddress("Sales Team <Contacts@Example.com>").Equals(alias), ShouldBeTrue)
+				So(pSet.FindForAddress("contacts@other.com").IsEmpty(), ShouldBeTrue)
+				So(pSet.FindForAddress("unknown@example.com").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Catchall address is recognized", func() {
+				So(company.CatchallEmail(), ShouldEqual, "catchall@example.com")
+				So(company.IsCatchallAddress("Catchall@example.com"), ShouldBeTrue)
+				So(company.IsCatchallAddress("contacts@example.com"), ShouldBeFalse)
+			})
+			Convey("Messages are routed to the model handler", func() {
+				RegisterAliasHandler("Partner", func(al m.AliasSet, msg *mail.Message) error {
+					h.Partner().Create(al.Env(), h.Partner().NewData().
+						SetName(msg.Header.Get("Subject")).
+						SetIsCompany(al.DefaultValues()["is_company"].(bool)))
+					return nil
+				})
+				defer func() {
+					aliasHandlers.Lock()
+					delete(aliasHandlers.handlers, "Partner")
+					aliasHandlers.Unlock()
+				}()
+				msg, err := mail.ReadMessage(strings.NewReader("Subject: Alias Corp\r\n\r\nHello"))
+				So(err, ShouldBeNil)
+				So(alias.RouteMessage(msg), ShouldBeNil)
+				partner := h.Partner().Search(env, q.Partner().Name().Equals("Alias Corp"))
+				So(partner.IsCompany(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// Severities of announcements
+const (
+	AnnouncementInfo    = "info"
+	AnnouncementWarning = "warning"
+	AnnouncementDanger  = "danger"
+)
+
+// AnnouncementSeverities is the selection of the severities of announcements
+var AnnouncementSeverities = types.Selection{
+	AnnouncementInfo:    "Information",
+	AnnouncementWarning: "Warning",
+	AnnouncementDanger:  "Critical",
+}
+
+var fields_Announcement = map[string]models.FieldDefinition{
+	"Name":    fields.Char{String: "Title", Required: true, Translate: true},
+	"Message": fields.Text{Required: true, Translate: true},
+	"Severity": fields.Selection{Selection: AnnouncementSeverities, Required: true,
+		Default: models.DefaultValue(AnnouncementInfo)},
+	"DateStart": fields.DateTime{String: "Start Date", Required: true, Index: true,
+		Default: func(env models.Environment) interface{} {
+			return dates.Now()
+		}},
+	"DateEnd": fields.DateTime{String: "End Date", Index: true,
+		Constraint: h.Announcement().Methods().CheckDates(),
+		Help:       "Leave empty to display the announcement until it is archived"},
+	"Groups": fields.Many2Many{String: "Target Groups", RelationModel: h.Group(), JSON: "group_ids",
+		Help: "Only members of these groups see the announcement. Leave empty to target all users."},
+	"Companies": fields.Many2Many{String: "Target Companies", RelationModel: h.Company(), JSON: "company_ids",
+		Help: "Only users working in these companies see the announcement. Leave empty to target all companies."},
+	"Dismissible": fields.Boolean{Default: models.DefaultValue(true),
+		Help: "Users can hide dismissible announcements. Other announcements are displayed until their end date."},
+	"DismissedBy": fields.Many2Many{RelationModel: h.User(), JSON: "dismissed_by_ids", NoCopy: true,
+		ReadOnly: true},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckDates checks that announcements do not end before they start
+func announcement_CheckDates(rs m.AnnouncementSet) {
+	for _, announcement := range rs.Records() {
+		if !announcement.DateEnd().IsZero() && announcement.DateEnd().Lower(announcement.DateStart()) {
+			log.Panic(rs.T("The end date of announcement '%s' is before its start date", announcement.Name()))
+		}
+	}
+}
+
+// IsTargeting returns true if this announcement targets the given user, i.e.
+// if the user belongs to one of its groups and works in one of its companies.
+func announcement_IsTargeting(rs m.AnnouncementSet, user m.UserSet) bool {
+	rs.EnsureOne()
+	if rs.Companies().IsNotEmpty() && rs.Companies().Intersect(user.Company()).IsEmpty() {
+		return false
+	}
+	if rs.Groups().IsEmpty() {
+		return true
+	}
+	for _, group := range rs.Groups().Records() {
+		if user.HasGroup(group.GroupID()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForUser returns the running announcements targeting the given user which
+// the user has not dismissed, the most severe first.
+func announcement_ForUser(rs m.AnnouncementSet, user m.UserSet) m.AnnouncementSet {
+	user.EnsureOne()
+	now := dates.Now()
+	running := h.Announcement().NewSet(rs.Env()).AsSuperUser("read running announcements").Search(q.Announcement().DateStart().LowerOrEqual(now).
+		AndCond(q.Announcement().DateEnd().IsNull().Or().DateEnd().Greater(now)))
+	var targeted []m.AnnouncementSet
+	for _, announcement := range running.Records() {
+		if announcement.DismissedBy().Intersect(user).IsNotEmpty() {
+			continue
+		}
+		if announcement.IsTargeting(user) {
+			targeted = append(targeted, announcement)
+		}
+	}
+	sort.SliceStable(targeted, func(i, j int) bool {
+		return severityRank(targeted[i].Severity()) > severityRank(targeted[j].Severity())
+	})
+	ids := make([]int64, len(targeted))
+	for i, announcement := range targeted {
+		ids[i] = announcement.ID()
+	}
+	return h.Announcement().Browse(rs.Env(), ids)
+}
+
+// severityRank returns the rank of the given severity, higher being more severe
+func severityRank(severity string) int {
+	switch severity {
+	case AnnouncementDanger:
+		return 2
+	case AnnouncementWarning:
+		return 1
+	}
+	return 0
+}
+
+// Dismiss hides these announcements for the current user. It panics if one
+// of them is not dismissible.
+func announcement_Dismiss(rs m.AnnouncementSet) {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	for _, announcement := range rs.AsSuperUser("read announcements").Records() {
+		if !announcement.Dismissible() {
+			log.Panic(rs.T("Announcement '%s' cannot be dismissed", announcement.Name()))
+		}
+		announcement.SetDismissedBy(announcement.DismissedBy().Union(user))
+	}
+}
+
+// ActionResetDismissals displays these announcements again to the users who dismissed them
+func announcement_ActionResetDismissals(rs m.AnnouncementSet) {
+	rs.SetDismissedBy(h.User().NewSet(rs.Env()))
+}
+
+func init() {
+	models.NewModel("Announcement")
+	h.Announcement().SetDefaultOrder("DateStart desc", "ID desc")
+	h.Announcement().AddFields(fields_Announcement)
+	h.Announcement().NewMethod("CheckDates", announcement_CheckDates)
+	h.Announcement().NewMethod("IsTargeting", announcement_IsTargeting)
+	h.Announcement().NewMethod("ForUser", announcement_ForUser)
+	h.Announcement().NewMethod("Dismiss", announcement_Dismiss)
+	h.Announcement().NewMethod("ActionResetDismissals", announcement_ActionResetDismissals)
+}
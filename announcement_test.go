@@ -0,0 +1,76 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAnnouncements(t *testing.T) {
+	Convey("Testing announcements", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.Announcement().NewSet(env).SearchAll().Unlink()
+			admin := h.User().NewSet(env).CurrentUser()
+			user := h.User().Create(env, h.User().NewData().
+				SetName("Announcement User").
+				SetLogin("announcement_user"))
+			systemGroup := h.Group().Search(env, q.Group().GroupID().Equals(GroupSystem.ID()))
+			general := h.Announcement().Create(env, h.Announcement().NewData().
+				SetName("Maintenance").
+				SetMessage("The server will be down tonight").
+				SetDateStart(dates.Now().AddDate(0, 0, -1)))
+			critical := h.Announcement().Create(env, h.Announcement().NewData().
+				SetName("Security Policy").
+				SetMessage("Passwords must be changed").
+				SetSeverity(AnnouncementDanger).
+				SetDismissible(false).
+				SetDateStart(dates.Now().AddDate(0, 0, -1)))
+			adminOnly := h.Announcement().Create(env, h.Announcement().NewData().
+				SetName("Upgrade").
+				SetMessage("Modules will be upgraded").
+				SetGroups(systemGroup).
+				SetDateStart(dates.Now().AddDate(0, 0, -1)))
+			h.Announcement().Create(env, h.Announcement().NewData().
+				SetName("Future").
+				SetMessage("Not yet").
+				SetDateStart(dates.Now().AddDate(0, 0, 1)))
+			h.Announcement().Create(env, h.Announcement().NewData().
+				SetName("Past").
+				SetMessage("Already over").
+				SetDateStart(dates.Now().AddDate(0, 0, -3)).
+				SetDateEnd(dates.Now().AddDate(0, 0, -2)))
+			Convey("Users get running announcements of their groups, the most severe first", func() {
+				forUser := h.Announcement().NewSet(env).ForUser(user)
+				So(forUser.Len(), ShouldEqual, 2)
+				So(forUser.Records()[0].Equals(critical), ShouldBeTrue)
+				So(forUser.Records()[1].Equals(general), ShouldBeTrue)
+				forAdmin := h.Announcement().NewSet(env).ForUser(admin)
+				So(forAdmin.Len(), ShouldEqual, 3)
+				So(forAdmin.Intersect(adminOnly).IsNotEmpty(), ShouldBeTrue)
+			})
+			Convey("Dismissed announcements are hidden for the current user only", func() {
+				general.Dismiss()
+				So(h.Announcement().NewSet(env).ForUser(admin).Intersect(general).IsEmpty(), ShouldBeTrue)
+				So(h.Announcement().NewSet(env).ForUser(user).Intersect(general).IsNotEmpty(), ShouldBeTrue)
+				general.ActionResetDismissals()
+				So(h.Announcement().NewSet(env).ForUser(admin).Intersect(general).IsNotEmpty(), ShouldBeTrue)
+			})
+			Convey("Non dismissible announcements cannot be dismissed", func() {
+				So(func() { critical.Dismiss() }, ShouldPanic)
+			})
+			Convey("Announcements cannot end before they start", func() {
+				So(func() {
+					general.SetDateEnd(dates.Now().AddDate(0, 0, -2))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,148 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/spf13/viper"
+)
+
+// Levels of application log entries
+const (
+	LoggingDebug    = "debug"
+	LoggingInfo     = "info"
+	LoggingWarning  = "warning"
+	LoggingError    = "error"
+	LoggingCritical = "critical"
+)
+
+// LoggingLevels is the selection of the levels of application log entries
+var LoggingLevels = types.Selection{
+	LoggingDebug:    "Debug",
+	LoggingInfo:     "Info",
+	LoggingWarning:  "Warning",
+	LoggingError:    "Error",
+	LoggingCritical: "Critical",
+}
+
+// LoggingDefaultRetentionDays is the number of days application log entries
+// are kept when the base.logging_retention_days parameter is not set.
+const LoggingDefaultRetentionDays = 30
+
+// A LogEntry is a business event to be recorded with LogEvent
+type LogEntry struct {
+	// Level is one of LoggingDebug, LoggingInfo, LoggingWarning, LoggingError or LoggingCritical
+	Level string
+	// Logger is the name of the emitting component, e.g. "mail.gateway"
+	Logger  string
+	Message string
+	// Func is the name of the emitting function or method
+	Func string
+	// Model and ResID identify the record concerned by the event, if any
+	Model string
+	ResID int64
+}
+
+// LogEvent records the given business event in the Logging model, so that
+// it is visible to administrators inside the application. The entry is also
+// written to the server log.
+//
+// The entry is created in its own transaction, so that it is kept even if the
+// current transaction is rolled back, which is precisely when errors happen.
+func LogEvent(env models.Environment, entry LogEntry) {
+	if _, ok := LoggingLevels[entry.Level]; !ok {
+		entry.Level = LoggingInfo
+	}
+	logCtx := []interface{}{"logger", entry.Logger, "model", entry.Model, "res_id", entry.ResID, "func", entry.Func}
+	switch entry.Level {
+	case LoggingDebug:
+		log.Debug(entry.Message, logCtx...)
+	case LoggingInfo:
+		log.Info(entry.Message, logCtx...)
+	case LoggingWarning:
+		log.Warn(entry.Message, logCtx...)
+	default:
+		log.Error(entry.Message, logCtx...)
+	}
+	uid := env.Uid()
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(logEnv models.Environment) {
+		data := h.Logging().NewData().
+			SetLevel(entry.Level).
+			SetLogger(entry.Logger).
+			SetMessage(entry.Message).
+			SetFunc(entry.Func).
+			SetModel(entry.Model).
+			SetResID(entry.ResID).
+			SetDBName(viper.GetString("DB.Name"))
+		if user := h.User().Search(logEnv, q.User().ID().Equals(uid)); user.IsNotEmpty() {
+			data.SetUser(user)
+		}
+		h.Logging().Create(logEnv, data)
+	})
+	if err != nil {
+		log.Warn("Unable to record application log entry", "message", entry.Message, "error", err)
+	}
+}
+
+var fields_Logging = map[string]models.FieldDefinition{
+	"Level":   fields.Selection{Selection: LoggingLevels, Required: true, Index: true},
+	"Logger":  fields.Char{Required: true, Index: true, Help: "Name of the component that emitted this entry"},
+	"Message": fields.Text{Required: true},
+	"Func":    fields.Char{String: "Function"},
+	"Model":   fields.Char{Index: true},
+	"ResID":   fields.Integer{String: "Record ID"},
+	"User":    fields.Many2One{RelationModel: h.User(), OnDelete: models.SetNull},
+	"DBName":  fields.Char{String: "Database", JSON: "db_name"},
+}
+
+// ActionOpenRecord opens the record concerned by this log entry
+func logging_ActionOpenRecord(rs m.LoggingSet) *actions.Action {
+	rs.EnsureOne()
+	if rs.Model() == "" || rs.ResID() == 0 {
+		log.Panic(rs.T("This log entry does not concern a record"))
+	}
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Model:    rs.Model(),
+		ResID:    rs.ResID(),
+		ViewMode: "form",
+	}
+}
+
+// GCLogging deletes the application log entries older than the number of days
+// given by the base.logging_retention_days parameter.
+func autoVacuum_GCLogging(rs m.AutoVacuumSet) {
+	days, err := strconv.Atoi(h.ConfigParameter().NewSet(rs.Env()).GetParam("base.logging_retention_days",
+		strconv.Itoa(LoggingDefaultRetentionDays)))
+	if err != nil || days <= 0 {
+		days = LoggingDefaultRetentionDays
+	}
+	limit := dates.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	res := rs.Env().Cr().Execute(`DELETE FROM logging WHERE create_date < ?`, limit)
+	n, err := res.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	log.Info("GC'd application log entries", "count", n)
+}
+
+func init() {
+	models.NewModel("Logging")
+	h.Logging().SetDefaultOrder("ID desc")
+	h.Logging().AddFields(fields_Logging)
+	h.Logging().NewMethod("ActionOpenRecord", logging_ActionOpenRecord)
+
+	h.AutoVacuum().NewMethod("GCLogging", autoVacuum_GCLogging)
+}
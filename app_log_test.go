@@ -0,0 +1,62 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLogging(t *testing.T) {
+	Convey("Testing application logs", t, func() {
+		Convey("Log entries are kept when the transaction is rolled back", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				LogEvent(env, LogEntry{
+					Level:   LoggingError,
+					Logger:  "test.logging",
+					Message: "Something went wrong",
+					Func:    "TestLogging",
+					Model:   "Partner",
+					ResID:   1,
+				})
+			}), ShouldBeNil)
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				entries := h.Logging().Search(env, q.Logging().Logger().Equals("test.logging"))
+				So(entries.Len(), ShouldEqual, 1)
+				So(entries.Level(), ShouldEqual, LoggingError)
+				So(entries.Message(), ShouldEqual, "Something went wrong")
+				So(entries.User().ID(), ShouldEqual, security.SuperUserID)
+				action := entries.ActionOpenRecord()
+				So(action.Model, ShouldEqual, "Partner")
+				So(action.ResID, ShouldEqual, 1)
+				entries.Unlink()
+			}), ShouldBeNil)
+		})
+		Convey("Unknown levels are logged as info", func() {
+			So(models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				LogEvent(env, LogEntry{Level: "unknown", Logger: "test.logging", Message: "Info"})
+				entries := h.Logging().Search(env, q.Logging().Logger().Equals("test.logging"))
+				So(entries.Level(), ShouldEqual, LoggingInfo)
+				So(func() { entries.ActionOpenRecord() }, ShouldPanic)
+				entries.Unlink()
+			}), ShouldBeNil)
+		})
+		Convey("Old entries are vacuumed", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				entry := h.Logging().Create(env, h.Logging().NewData().
+					SetLevel(LoggingInfo).
+					SetLogger("test.logging").
+					SetMessage("Old entry"))
+				env.Cr().Execute(`UPDATE logging SET create_date = create_date - interval '60 days' WHERE id = ?`, entry.ID())
+				h.AutoVacuum().NewSet(env).GCLogging()
+				So(h.Logging().Search(env, q.Logging().ID().Equals(entry.ID())).IsEmpty(), ShouldBeTrue)
+			}), ShouldBeNil)
+		})
+	})
+}
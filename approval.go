@@ -0,0 +1,396 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// States of approval requests and of their lines
+const (
+	ApprovalPending   = "pending"
+	ApprovalApproved  = "approved"
+	ApprovalRefused   = "refused"
+	ApprovalCancelled = "cancelled"
+)
+
+// ApprovalStates is the selection of the states of approval requests
+var ApprovalStates = types.Selection{
+	ApprovalPending:   "To Approve",
+	ApprovalApproved:  "Approved",
+	ApprovalRefused:   "Refused",
+	ApprovalCancelled: "Cancelled",
+}
+
+// ApprovalModes is the selection of the ways approvers of a rule are consulted
+var ApprovalModes = types.Selection{
+	"parallel":   "All at Once",
+	"sequential": "One After the Other",
+}
+
+// DelegationScopeApprovals routes the approvals requested to the user
+const DelegationScopeApprovals = "approvals"
+
+var fields_ApprovalRule = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Translate: true},
+	"Model": fields.Char{Required: true, Index: true, Constraint: h.ApprovalRule().Methods().CheckModel(),
+		Help: "Name of the model whose records require this approval, e.g. PurchaseOrder"},
+	"Mode": fields.Selection{Selection: ApprovalModes, Required: true, Default: models.DefaultValue("parallel"),
+		Help: "Parallel rules ask all approvers at once, sequential rules ask them in the order of the lines."},
+	"Lines": fields.One2Many{RelationModel: h.ApprovalRuleLine(), ReverseFK: "Rule", JSON: "line_ids",
+		Copy: true},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+	"Company": fields.Many2One{RelationModel: h.Company(),
+		Help: "Leave empty to apply this rule to all companies"},
+}
+
+var fields_ApprovalRuleLine = map[string]models.FieldDefinition{
+	"Rule": fields.Many2One{RelationModel: h.ApprovalRule(), Required: true, OnDelete: models.Cascade,
+		Index: true},
+	"Sequence": fields.Integer{Default: models.DefaultValue(10)},
+	"User": fields.Many2One{String: "Approver", RelationModel: h.User(), OnDelete: models.Restrict,
+		Constraint: h.ApprovalRuleLine().Methods().CheckApprover()},
+	"Group": fields.Many2One{String: "Approver Group", RelationModel: h.Group(), OnDelete: models.Restrict,
+		Constraint: h.ApprovalRuleLine().Methods().CheckApprover(),
+		Help:       "Any member of this group can approve"},
+}
+
+var fields_ApprovalRequest = map[string]models.FieldDefinition{
+	"Rule":     fields.Many2One{RelationModel: h.ApprovalRule(), Required: true, OnDelete: models.Restrict},
+	"ResModel": fields.Char{String: "Related Model", Required: true, Index: true, ReadOnly: true},
+	"ResID":    fields.Integer{String: "Related Record ID", Required: true, Index: true, ReadOnly: true},
+	"ResName": fields.Char{String: "Related Record", Compute: h.ApprovalRequest().Methods().ComputeResName(),
+		Depends: []string{"ResModel", "ResID"}},
+	"Mode": fields.Selection{Selection: ApprovalModes, Related: "Rule.Mode", ReadOnly: true},
+	"State": fields.Selection{Selection: ApprovalStates, Required: true, Index: true, ReadOnly: true,
+		NoCopy: true, Default: models.DefaultValue(ApprovalPending)},
+	"RequestedBy": fields.Many2One{RelationModel: h.User(), Required: true, ReadOnly: true,
+		OnDelete: models.Restrict, Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		}},
+	"Lines": fields.One2Many{RelationModel: h.ApprovalRequestLine(), ReverseFK: "Request", JSON: "line_ids",
+		ReadOnly: true},
+	"DateDone": fields.DateTime{String: "Decision Date", ReadOnly: true, NoCopy: true},
+}
+
+var fields_ApprovalRequestLine = map[string]models.FieldDefinition{
+	"Request": fields.Many2One{RelationModel: h.ApprovalRequest(), Required: true, OnDelete: models.Cascade,
+		Index: true},
+	"Sequence": fields.Integer{Default: models.DefaultValue(10)},
+	"User":     fields.Many2One{String: "Approver", RelationModel: h.User(), OnDelete: models.Restrict},
+	"Group":    fields.Many2One{String: "Approver Group", RelationModel: h.Group(), OnDelete: models.Restrict},
+	"State": fields.Selection{Selection: ApprovalStates, Required: true, ReadOnly: true,
+		Default: models.DefaultValue(ApprovalPending)},
+	"DecidedBy": fields.Many2One{RelationModel: h.User(), ReadOnly: true, OnDelete: models.SetNull},
+	"DateDone":  fields.DateTime{String: "Decision Date", ReadOnly: true},
+	"Comment":   fields.Text{ReadOnly: true},
+}
+
+// CheckModel checks that the model of this rule exists
+func approvalRule_CheckModel(rs m.ApprovalRuleSet) {
+	for _, rule := range rs.Records() {
+		if _, exists := models.Registry.Get(rule.Model()); !exists {
+			log.Panic(rs.T("Unknown model '%s'", rule.Model()))
+		}
+	}
+}
+
+// CheckApprover checks that exactly one of user or group is set on the line
+func approvalRuleLine_CheckApprover(rs m.ApprovalRuleLineSet) {
+	for _, line := range rs.Records() {
+		if line.User().IsEmpty() == line.Group().IsEmpty() {
+			log.Panic(rs.T("Each approval line must have either an approver or an approver group"))
+		}
+	}
+}
+
+// ForModel returns the active rule applying to the given model for the
+// current user's company, or an empty recordset if there is none.
+func approvalRule_ForModel(rs m.ApprovalRuleSet, model string) m.ApprovalRuleSet {
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	return h.ApprovalRule().Search(rs.Env(), q.ApprovalRule().Model().Equals(model).
+		AndCond(q.ApprovalRule().Company().IsNull().Or().Company().Equals(company))).
+		OrderBy("Company", "ID").Limit(1)
+}
+
+// RequestApproval creates an approval request of the record of the given
+// model with the given ID following this rule, and notifies the approvers.
+func approvalRule_RequestApproval(rs m.ApprovalRuleSet, resModel string, resID int64) m.ApprovalRequestSet {
+	rs.EnsureOne()
+	if resModel != rs.Model() {
+		log.Panic(rs.T("Approval rule %s does not apply to %s", rs.Name(), resModel))
+	}
+	if rs.Lines().IsEmpty() {
+		log.Panic(rs.T("Approval rule %s has no approvers", rs.Name()))
+	}
+	if h.ApprovalRequest().NewSet(rs.Env()).PendingFor(resModel, resID).IsNotEmpty() {
+		log.Panic(rs.T("An approval is already pending for this record"))
+	}
+	requests := h.ApprovalRequest().NewSet(rs.Env()).AsSuperUser("create an approval request")
+	request := requests.Create(h.ApprovalRequest().NewData().
+		SetRule(rs).
+		SetResModel(resModel).
+		SetResID(resID).
+		SetRequestedBy(h.User().NewSet(rs.Env()).CurrentUser()))
+	lines := h.ApprovalRequestLine().NewSet(rs.Env()).AsSuperUser("create an approval request")
+	for _, line := range rs.Lines().Records() {
+		lines.Create(h.ApprovalRequestLine().NewData().
+			SetRequest(request).
+			SetSequence(line.Sequence()).
+			SetUser(line.User()).
+			SetGroup(line.Group()))
+	}
+	request.NotifyApprovers()
+	return h.ApprovalRequest().Browse(rs.Env(), request.Ids())
+}
+
+// ComputeResName returns the display name of the record of this request
+func approvalRequest_ComputeResName(rs m.ApprovalRequestSet) m.ApprovalRequestData {
+	return h.ApprovalRequest().NewData().SetResName(ReferencedRecordName(rs.Env(), rs.ResModel(), rs.ResID()))
+}
+
+// PendingFor returns the pending approval requests of the given record
+func approvalRequest_PendingFor(rs m.ApprovalRequestSet, resModel string, resID int64) m.ApprovalRequestSet {
+	return h.ApprovalRequest().Search(rs.Env(), q.ApprovalRequest().ResModel().Equals(resModel).
+		And().ResID().Equals(resID).
+		And().State().Equals(ApprovalPending))
+}
+
+// IsApproved returns true if the given record has an approved request
+// and no pending request.
+func approvalRequest_IsApproved(rs m.ApprovalRequestSet, resModel string, resID int64) bool {
+	requests := h.ApprovalRequest().Search(rs.Env(), q.ApprovalRequest().ResModel().Equals(resModel).
+		And().ResID().Equals(resID).
+		And().State().In([]string{ApprovalPending, ApprovalApproved})).
+		OrderBy("ID desc").Limit(1)
+	return requests.IsNotEmpty() && requests.State() == ApprovalApproved
+}
+
+// CurrentLines returns the pending lines of this request that can be decided
+// now: all the pending lines of parallel requests and the first pending line
+// of sequential requests.
+func approvalRequest_CurrentLines(rs m.ApprovalRequestSet) m.ApprovalRequestLineSet {
+	rs.EnsureOne()
+	pending := rs.Lines().Search(q.ApprovalRequestLine().State().Equals(ApprovalPending)).
+		OrderBy("Sequence", "ID")
+	if rs.State() != ApprovalPending || pending.IsEmpty() {
+		return h.ApprovalRequestLine().NewSet(rs.Env())
+	}
+	if rs.Mode() == "sequential" {
+		return pending.Limit(1)
+	}
+	return pending
+}
+
+// decidableLines returns the current lines of the given request that the
+// current user can decide, or panics if there is none or if the current
+// user requested the approval.
+func decidableLines(rs m.ApprovalRequestSet) m.ApprovalRequestLineSet {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	if rs.RequestedBy().Equals(user) {
+		panic(NewUserError(rs, "You cannot decide on an approval you requested"))
+	}
+	lines := rs.CurrentLines().Filtered(func(r m.ApprovalRequestLineSet) bool {
+		return r.CanDecide(user)
+	})
+	if lines.IsEmpty() {
+		panic(NewUserError(rs, "You are not an approver of this request"))
+	}
+	return lines
+}
+
+// Approve approves the current lines of this request that the current user
+// can decide. The request is approved once all its lines are approved.
+//
+// States are written as the super user since users cannot write requests
+// and their lines directly.
+func approvalRequest_Approve(rs m.ApprovalRequestSet, comment string) {
+	for _, request := range rs.Records() {
+		lines := decidableLines(request)
+		lines.AsSuperUser("approve an approval request").Write(h.ApprovalRequestLine().NewData().
+			SetState(ApprovalApproved).
+			SetDecidedBy(h.User().NewSet(rs.Env()).CurrentUser()).
+			SetDateDone(dates.Now()).
+			SetComment(comment))
+		if request.Lines().Search(q.ApprovalRequestLine().State().Equals(ApprovalPending)).IsNotEmpty() {
+			// Approvers of parallel requests have all been notified on creation,
+			// whereas sequential requests have moved to their next line.
+			if request.Mode() == "sequential" {
+				request.NotifyApprovers()
+			}
+			continue
+		}
+		request.AsSuperUser("approve an approval request").Write(h.ApprovalRequest().NewData().
+			SetState(ApprovalApproved).
+			SetDateDone(dates.Now()))
+		request.NotifyRequester()
+		request.ProcessDecision()
+	}
+}
+
+// Refuse refuses this request with the given reason. A single refusal
+// refuses the whole request.
+func approvalRequest_Refuse(rs m.ApprovalRequestSet, reason string) {
+	for _, request := range rs.Records() {
+		if strings.TrimSpace(reason) == "" {
+			log.Panic(rs.T("Please give the reason of the refusal"))
+		}
+		lines := decidableLines(request)
+		lines.AsSuperUser("refuse an approval request").Write(h.ApprovalRequestLine().NewData().
+			SetState(ApprovalRefused).
+			SetDecidedBy(h.User().NewSet(rs.Env()).CurrentUser()).
+			SetDateDone(dates.Now()).
+			SetComment(reason))
+		request.AsSuperUser("refuse an approval request").Write(h.ApprovalRequest().NewData().
+			SetState(ApprovalRefused).
+			SetDateDone(dates.Now()))
+		request.NotifyRequester()
+		request.ProcessDecision()
+	}
+}
+
+// ActionCancel cancels these requests if they are still pending
+func approvalRequest_ActionCancel(rs m.ApprovalRequestSet) {
+	rs.Search(q.ApprovalRequest().State().Equals(ApprovalPending)).
+		SetState(ApprovalCancelled)
+}
+
+// ProcessDecision is called when these requests are approved or refused. It
+// does nothing by default and is meant to be extended by addons to resume the
+// workflow of the approved record, e.g. to confirm a purchase order.
+func approvalRequest_ProcessDecision(_ m.ApprovalRequestSet) {}
+
+// approvalNotificationData is the data of the approval email templates
+type approvalNotificationData struct {
+	UserName    string
+	UserEmail   string
+	Rule        string
+	Record      string
+	RequestedBy string
+	State       string
+	Comment     string
+	OutOfOffice string
+}
+
+// NotifyApprovers sends an email to the users who can decide the current
+// lines of this request, or to their delegate. The email mentions approvers
+// who are out of office.
+func approvalRequest_NotifyApprovers(rs m.ApprovalRequestSet) {
+	rs.EnsureOne()
+	template := h.MailTemplate().NewSet(rs.Env()).AsSuperUser("read approval request mail template").GetRecord("base_mail_template_approval_request")
+	users := h.User().NewSet(rs.Env())
+	for _, line := range rs.CurrentLines().Records() {
+		users = users.Union(line.Approvers())
+	}
+	for _, user := range users.Records() {
+		today := userToday(user)
+		recipient := user.DelegateFor(DelegationScopeNotifications, today)
+		if recipient.Email() == "" {
+			continue
+		}
+		err := template.ForPartner(recipient.Partner()).SendRecordMail(rs.ResModel(), rs.ResID(), approvalNotificationData{
+			UserName:    recipient.Name(),
+			UserEmail:   recipient.Partner().EmailFormatted(),
+			Rule:        rs.Rule().Name(),
+			Record:      rs.ResName(),
+			RequestedBy: rs.RequestedBy().Name(),
+			OutOfOffice: user.OutOfOfficeNotice(today),
+		})
+		if err != nil {
+			log.Warn("Unable to send approval request", "request", rs.ID(), "user", recipient.ID(), "error", err)
+		}
+	}
+}
+
+// NotifyRequester sends an email to the requester of this request with the decision
+func approvalRequest_NotifyRequester(rs m.ApprovalRequestSet) {
+	rs.EnsureOne()
+	requester := rs.RequestedBy()
+	if requester.Email() == "" {
+		return
+	}
+	var comments []string
+	for _, line := range rs.Lines().Records() {
+		if line.Comment() != "" {
+			comments = append(comments, line.DecidedBy().Name()+": "+line.Comment())
+		}
+	}
+	template := h.MailTemplate().NewSet(rs.Env()).AsSuperUser("read approval decision mail template").GetRecord("base_mail_template_approval_decision")
+	err := template.ForPartner(requester.Partner()).SendRecordMail(rs.ResModel(), rs.ResID(), approvalNotificationData{
+		UserName:    requester.Name(),
+		UserEmail:   requester.Partner().EmailFormatted(),
+		Rule:        rs.Rule().Name(),
+		Record:      rs.ResName(),
+		RequestedBy: requester.Name(),
+		State:       ApprovalStates[rs.State()],
+		Comment:     strings.Join(comments, "\n"),
+	})
+	if err != nil {
+		log.Warn("Unable to send approval decision", "request", rs.ID(), "error", err)
+	}
+}
+
+// Approvers returns the users who can decide this line, not counting delegates
+func approvalRequestLine_Approvers(rs m.ApprovalRequestLineSet) m.UserSet {
+	rs.EnsureOne()
+	if rs.User().IsNotEmpty() {
+		return rs.User()
+	}
+	return h.User().Search(rs.Env(), q.User().Groups().Equals(rs.Group()))
+}
+
+// CanDecide returns true if the given user can approve or refuse this line,
+// either as an approver or as the delegate of the approver.
+func approvalRequestLine_CanDecide(rs m.ApprovalRequestLineSet, user m.UserSet) bool {
+	rs.EnsureOne()
+	if rs.User().IsNotEmpty() {
+		return rs.User().Equals(user) ||
+			rs.User().DelegateFor(DelegationScopeApprovals, userToday(rs.User())).Equals(user)
+	}
+	return user.HasGroup(rs.Group().GroupID())
+}
+
+func init() {
+	RegisterDelegationScope(DelegationScopeApprovals, "Approvals")
+
+	models.NewModel("ApprovalRule")
+	h.ApprovalRule().SetDefaultOrder("Model", "ID")
+	h.ApprovalRule().AddFields(fields_ApprovalRule)
+	h.ApprovalRule().NewMethod("CheckModel", approvalRule_CheckModel)
+	h.ApprovalRule().NewMethod("ForModel", approvalRule_ForModel)
+	h.ApprovalRule().NewMethod("RequestApproval", approvalRule_RequestApproval)
+
+	models.NewModel("ApprovalRuleLine")
+	h.ApprovalRuleLine().SetDefaultOrder("Sequence", "ID")
+	h.ApprovalRuleLine().AddFields(fields_ApprovalRuleLine)
+	h.ApprovalRuleLine().NewMethod("CheckApprover", approvalRuleLine_CheckApprover)
+
+	models.NewModel("ApprovalRequest")
+	h.ApprovalRequest().SetDefaultOrder("ID desc")
+	h.ApprovalRequest().AddFields(fields_ApprovalRequest)
+	h.ApprovalRequest().NewMethod("ComputeResName", approvalRequest_ComputeResName)
+	h.ApprovalRequest().NewMethod("PendingFor", approvalRequest_PendingFor)
+	h.ApprovalRequest().NewMethod("IsApproved", approvalRequest_IsApproved)
+	h.ApprovalRequest().NewMethod("CurrentLines", approvalRequest_CurrentLines)
+	h.ApprovalRequest().NewMethod("Approve", approvalRequest_Approve)
+	h.ApprovalRequest().NewMethod("Refuse", approvalRequest_Refuse)
+	h.ApprovalRequest().NewMethod("ActionCancel", approvalRequest_ActionCancel)
+	h.ApprovalRequest().NewMethod("ProcessDecision", approvalRequest_ProcessDecision)
+	h.ApprovalRequest().NewMethod("NotifyApprovers", approvalRequest_NotifyApprovers)
+	h.ApprovalRequest().NewMethod("NotifyRequester", approvalRequest_NotifyRequester)
+
+	models.NewModel("ApprovalRequestLine")
+	h.ApprovalRequestLine().SetDefaultOrder("Sequence", "ID")
+	h.ApprovalRequestLine().AddFields(fields_ApprovalRequestLine)
+	h.ApprovalRequestLine().NewMethod("Approvers", approvalRequestLine_Approvers)
+	h.ApprovalRequestLine().NewMethod("CanDecide", approvalRequestLine_CanDecide)
+}
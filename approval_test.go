@@ -0,0 +1,113 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApproval(t *testing.T) {
+	Convey("Testing approval workflows", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			sender := new(testMailSender)
+			previous := SetMailSender(sender)
+			defer SetMailSender(previous)
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			first := h.User().Create(env, h.User().NewData().
+				SetName("First Approver").
+				SetLogin("approval_first").
+				SetEmail("first@example.com"))
+			second := h.User().Create(env, h.User().NewData().
+				SetName("Second Approver").
+				SetLogin("approval_second").
+				SetEmail("second@example.com"))
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Approved Partner"))
+			rule := h.ApprovalRule().Create(env, h.ApprovalRule().NewData().
+				SetName("Partner Validation").
+				SetModel("Partner").
+				SetMode("sequential"))
+			h.ApprovalRuleLine().Create(env, h.ApprovalRuleLine().NewData().
+				SetRule(rule).
+				SetSequence(1).
+				SetUser(first))
+			h.ApprovalRuleLine().Create(env, h.ApprovalRuleLine().NewData().
+				SetRule(rule).
+				SetSequence(2).
+				SetUser(second))
+			Convey("Rules are found by model", func() {
+				So(h.ApprovalRule().NewSet(env).ForModel("Partner").Equals(rule), ShouldBeTrue)
+				So(h.ApprovalRule().NewSet(env).ForModel("Company").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Sequential approvers are asked one after the other", func() {
+				request := rule.RequestApproval("Partner", partner.ID())
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(request.State(), ShouldEqual, ApprovalPending)
+				So(request.ResName(), ShouldEqual, "Approved Partner")
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].To[0], ShouldContainSubstring, "first@example.com")
+				So(func() { rule.RequestApproval("Partner", partner.ID()) }, ShouldPanic)
+				So(func() { request.Sudo(second.ID()).Approve("") }, ShouldPanic)
+				request.Sudo(first.ID()).Approve("Fine")
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(request.State(), ShouldEqual, ApprovalPending)
+				So(sender.sent, ShouldHaveLength, 2)
+				So(sender.sent[1].To[0], ShouldContainSubstring, "second@example.com")
+				request.Sudo(second.ID()).Approve("")
+				So(request.State(), ShouldEqual, ApprovalApproved)
+				So(h.ApprovalRequest().NewSet(env).IsApproved("Partner", partner.ID()), ShouldBeTrue)
+			})
+			Convey("Parallel approvers are notified only once", func() {
+				rule.SetMode("parallel")
+				request := rule.RequestApproval("Partner", partner.ID())
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(sender.sent, ShouldHaveLength, 2)
+				request.Sudo(first.ID()).Approve("")
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(request.State(), ShouldEqual, ApprovalPending)
+				So(sender.sent, ShouldHaveLength, 2)
+			})
+			Convey("A single refusal refuses the request", func() {
+				request := rule.RequestApproval("Partner", partner.ID())
+				So(func() { request.Sudo(first.ID()).Refuse("") }, ShouldPanic)
+				request.Sudo(first.ID()).Refuse("Missing VAT")
+				So(request.State(), ShouldEqual, ApprovalRefused)
+				So(h.ApprovalRequest().NewSet(env).IsApproved("Partner", partner.ID()), ShouldBeFalse)
+			})
+			Convey("Requesters cannot decide on their own request", func() {
+				request := rule.Sudo(first.ID()).RequestApproval("Partner", partner.ID())
+				So(request.RequestedBy().Equals(first), ShouldBeTrue)
+				So(func() { request.Sudo(first.ID()).Approve("") }, ShouldPanic)
+				So(request.State(), ShouldEqual, ApprovalPending)
+			})
+			Convey("Approvers cannot write the state directly", func() {
+				request := rule.RequestApproval("Partner", partner.ID())
+				So(func() { request.Sudo(first.ID()).SetState(ApprovalApproved) }, ShouldPanic)
+				So(func() { request.Sudo(first.ID()).ActionCancel() }, ShouldPanic)
+				So(h.ApprovalRequest().NewSet(env).IsApproved("Partner", partner.ID()), ShouldBeFalse)
+			})
+			Convey("Delegates can approve", func() {
+				h.Delegation().Create(env, h.Delegation().NewData().
+					SetFromUser(first).
+					SetToUser(second).
+					SetScope(DelegationScopeApprovals).
+					SetDateFrom(dates.Today().AddDate(0, 0, -1)))
+				request := rule.RequestApproval("Partner", partner.ID())
+				request.Sudo(second.ID()).Approve("On behalf of First Approver")
+				So(request.Lines().Records()[0].State(), ShouldEqual, ApprovalApproved)
+				So(request.Lines().Records()[0].DecidedBy().Equals(second), ShouldBeTrue)
+			})
+			Convey("Rule lines need exactly one approver", func() {
+				So(func() {
+					h.ApprovalRuleLine().Create(env, h.ApprovalRuleLine().NewData().SetRule(rule))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -12,7 +12,6 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/erlangs/okoo/src/actions"
@@ -236,6 +235,9 @@ func attachment_InverseDatas(rs m.AttachmentSet, val string) {
 	if fName != "" {
 		rs.FileDelete(fName)
 	}
+	if val != "" {
+		rs.EnqueueIndexContent()
+	}
 }
 
 // GetDatasRelatedValues compute the fields that depend on data
@@ -251,7 +253,7 @@ func attachment_GetDatasRelatedValues(rs m.AttachmentSet, data string, mimeType
 	values := h.Attachment().NewData().
 		SetFileSize(len(binData)).
 		SetCheckSum(rs.ComputeCheckSum(binData)).
-		SetIndexContent(rs.Index(binData, mimeType)).
+		SetIndexContent("").
 		SetDBDatas(data)
 	if data != "" && rs.Storage() != "db" {
 		// Save the file to the filestore
@@ -293,17 +295,18 @@ func attachment_CheckContents(rs m.AttachmentSet, values m.AttachmentData) m.Att
 	return res
 }
 
-// Index computes the index content of the given filename, or binary data.
-func attachment_Index(_ m.AttachmentSet, binData, fileType string) string {
+// Index computes the index content of the given binary data with the
+// TextExtractor registered for the given mime type.
+func attachment_Index(rs m.AttachmentSet, binData, fileType string) string {
 	if fileType == "" {
 		return ""
 	}
-	if strings.Split(fileType, "/")[0] != "text" {
+	content, err := ExtractText([]byte(binData), fileType)
+	if err != nil {
+		log.Warn("Unable to extract attachment content", "attachment", rs.Ids(), "mimeType", fileType, "error", err)
 		return ""
 	}
-	re := regexp.MustCompile(`[^\x00-\x1F\x7F-\xFF]{4,}`)
-	words := re.FindAllString(binData, -1)
-	return strings.Join(words, "\n")
+	return content
 }
 
 // GetServingGroups returns groups allowed tp create and write serving attachments.
@@ -506,7 +509,7 @@ func attachment_Write(rs m.AttachmentSet, vals m.AttachmentData) bool {
 		return rs.Super().Write(vals)
 	}
 	rs.Check("write", vals)
-	if !rs.Env().Context().GetBool("hexya_force_compute_write") {
+	if !ContextGetBool(rs.Env(), ContextKeyForceComputeWrite) {
 		vals.UnsetFileSize()
 		vals.UnsetCheckSum()
 	}
@@ -543,7 +546,7 @@ func attachment_Unlink(rs m.AttachmentSet) int64 {
 
 func attachment_Create(rs m.AttachmentSet, vals m.AttachmentData) m.AttachmentSet {
 	vals = rs.CheckContents(vals)
-	if !rs.Env().Context().GetBool("hexya_force_compute_write") {
+	if !ContextGetBool(rs.Env(), ContextKeyForceComputeWrite) {
 		vals.UnsetFileSize()
 		vals.UnsetCheckSum()
 	}
@@ -600,6 +603,7 @@ func init() {
 	models.NewModel("Attachment")
 	h.Attachment().SetDefaultOrder("ID desc")
 	h.Attachment().AddFields(fields_Attachment)
+	h.Attachment().InheritModel(h.TagMixin())
 
 	h.Attachment().NewMethod("ComputeResName", attachment_ComputeResName)
 	h.Attachment().NewMethod("Storage", attachment_Storage)
@@ -0,0 +1,322 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/spf13/viper"
+)
+
+// A TextExtractor returns the text contained in the given file data.
+// It is used to fill the IndexContent field of attachments.
+type TextExtractor func(data []byte) (string, error)
+
+var textExtractors = struct {
+	sync.RWMutex
+	extractors map[string]TextExtractor
+}{
+	extractors: make(map[string]TextExtractor),
+}
+
+// RegisterTextExtractor registers the given extractor for the given mime type.
+// The mime type may be a wildcard such as "image/*" to match all the subtypes.
+// It panics if an extractor is already registered for this mime type.
+func RegisterTextExtractor(mimeType string, extractor TextExtractor) {
+	textExtractors.Lock()
+	defer textExtractors.Unlock()
+	if _, exists := textExtractors.extractors[mimeType]; exists {
+		log.Panic("Text extractor already registered", "mimeType", mimeType)
+	}
+	textExtractors.extractors[mimeType] = extractor
+}
+
+// getTextExtractor returns the extractor for the given mime type, looking
+// first for an exact match and then for a wildcard, or nil if there is none.
+func getTextExtractor(mimeType string) TextExtractor {
+	mimeType = strings.TrimSpace(strings.Split(mimeType, ";")[0])
+	textExtractors.RLock()
+	defer textExtractors.RUnlock()
+	if extractor, ok := textExtractors.extractors[mimeType]; ok {
+		return extractor
+	}
+	return textExtractors.extractors[strings.Split(mimeType, "/")[0]+"/*"]
+}
+
+// HasTextExtractor returns true if a text extractor is registered for the given mime type
+func HasTextExtractor(mimeType string) bool {
+	return getTextExtractor(mimeType) != nil
+}
+
+// ExtractText returns the text contained in the given data with the extractor
+// registered for mimeType. It returns an empty string if there is no such extractor.
+func ExtractText(data []byte, mimeType string) (string, error) {
+	extractor := getTextExtractor(mimeType)
+	if extractor == nil || len(data) == 0 {
+		return "", nil
+	}
+	return extractor(data)
+}
+
+var plainTextWordsRegex = regexp.MustCompile(`[^\x00-\x1F\x7F-\xFF]{4,}`)
+
+// extractPlainText returns the printable words of at least 4 characters of data
+func extractPlainText(data []byte) (string, error) {
+	words := plainTextWordsRegex.FindAllString(string(data), -1)
+	return strings.Join(words, "\n"), nil
+}
+
+var pdfStreamRegex = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+
+// extractPDFText returns the text layer of a PDF document.
+//
+// Only the strings shown by text operators in (possibly Flate compressed)
+// content streams are extracted, so that text drawn with custom font
+// encodings or scanned pages are not indexed.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF")) {
+		return "", fmt.Errorf("not a PDF document")
+	}
+	var lines []string
+	for _, match := range pdfStreamRegex.FindAllSubmatch(data, -1) {
+		content := match[1]
+		if r, err := zlib.NewReader(bytes.NewReader(content)); err == nil {
+			inflated, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				continue
+			}
+			content = inflated
+		}
+		lines = append(lines, pdfContentText(content)...)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// pdfContentText returns the lines of text shown in the given PDF content stream
+func pdfContentText(content []byte) []string {
+	var (
+		lines   []string
+		current strings.Builder
+		token   strings.Builder
+	)
+	flush := func() {
+		if line := strings.TrimSpace(current.String()); line != "" {
+			lines = append(lines, line)
+		}
+		current.Reset()
+	}
+	endToken := func() {
+		switch tok := token.String(); tok {
+		case "ET", "T*", "Td", "TD", "Tm", "'", `"`:
+			flush()
+		default:
+			// Large negative offsets in TJ arrays are word spaces
+			if offset, err := strconv.ParseFloat(tok, 64); err == nil && offset < -100 {
+				current.WriteByte(' ')
+			}
+		}
+		token.Reset()
+	}
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '(':
+			endToken()
+			var str string
+			str, i = pdfLiteralString(content, i)
+			current.WriteString(str)
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			endToken()
+			i++
+		case c == '<':
+			endToken()
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				flush()
+				return lines
+			}
+			current.WriteString(pdfHexString(content[i+1 : i+end]))
+			i += end
+		case c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '[' || c == ']':
+			endToken()
+		default:
+			token.WriteByte(c)
+		}
+	}
+	endToken()
+	flush()
+	return lines
+}
+
+// pdfLiteralString decodes the PDF literal string starting with the
+// parenthesis at content[start]. It returns the string and the index
+// of the closing parenthesis.
+func pdfLiteralString(content []byte, start int) (string, int) {
+	var (
+		res   strings.Builder
+		depth int
+	)
+	for i := start + 1; i < len(content); i++ {
+		c := content[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(content) {
+				return res.String(), i
+			}
+			switch e := content[i]; e {
+			case 'n', 'r':
+				res.WriteByte(' ')
+			case 't':
+				res.WriteByte('\t')
+			case 'b', 'f', '\n', '\r':
+			default:
+				if e >= '0' && e <= '7' {
+					val := int(e - '0')
+					for k := 0; k < 2 && i+1 < len(content) && content[i+1] >= '0' && content[i+1] <= '7'; k++ {
+						i++
+						val = val*8 + int(content[i]-'0')
+					}
+					res.WriteRune(rune(val))
+					continue
+				}
+				res.WriteByte(e)
+			}
+		case '(':
+			depth++
+			res.WriteByte(c)
+		case ')':
+			if depth == 0 {
+				return res.String(), i
+			}
+			depth--
+			res.WriteByte(c)
+		default:
+			res.WriteByte(c)
+		}
+	}
+	return res.String(), len(content)
+}
+
+// pdfHexString decodes the content of a PDF hexadecimal string
+func pdfHexString(hex []byte) string {
+	var digits []byte
+	for _, c := range hex {
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	var res strings.Builder
+	for i := 0; i < len(digits); i += 2 {
+		var b byte
+		fmt.Sscanf(string(digits[i:i+2]), "%02x", &b)
+		if b >= 0x20 && b < 0x7F {
+			res.WriteByte(b)
+		}
+	}
+	return res.String()
+}
+
+// TesseractTimeout is the maximum duration of the OCR of a single image
+var TesseractTimeout = 2 * time.Minute
+
+// tesseractExtractor returns a TextExtractor running the tesseract
+// OCR command with the given languages (e.g. "eng+fra").
+func tesseractExtractor(command, languages string) TextExtractor {
+	return func(data []byte) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), TesseractTimeout)
+		defer cancel()
+		args := []string{"stdin", "stdout"}
+		if languages != "" {
+			args = append(args, "-l", languages)
+		}
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, command, args...)
+		cmd.Stdin = bytes.NewReader(data)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("tesseract: %s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+}
+
+// configureAttachmentOCR registers the tesseract extractor for images
+// if the OCR of attachments has been enabled in the configuration.
+func configureAttachmentOCR() {
+	if !viper.GetBool("Attachment.OCR.Enabled") {
+		return
+	}
+	command := viper.GetString("Attachment.OCR.Command")
+	if command == "" {
+		command = "tesseract"
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		log.Warn("OCR of attachments disabled: command not found", "command", command, "error", err)
+		return
+	}
+	log.Info("Enabling OCR of image attachments", "command", command)
+	RegisterTextExtractor("image/*", tesseractExtractor(command, viper.GetString("Attachment.OCR.Languages")))
+}
+
+// ExtractIndexContent extracts the text of these attachments with the
+// registered TextExtractor of their mime type and stores it in IndexContent.
+//
+// It is called asynchronously through a queue job when data is uploaded.
+func attachment_ExtractIndexContent(rs m.AttachmentSet) {
+	for _, attachment := range rs.AsSuperUser("extract attachment content").Records() {
+		var content string
+		if datas := attachment.Datas(); datas != "" {
+			binData, err := base64.StdEncoding.DecodeString(datas)
+			if err != nil {
+				log.Warn("Unable to decode attachment content", "attachment", attachment.ID(), "error", err)
+				continue
+			}
+			content = attachment.Index(string(binData), attachment.MimeType())
+		}
+		attachment.WithContext("attachment_set_datas", true).SetIndexContent(content)
+	}
+}
+
+// EnqueueIndexContent queues the text extraction of the attachments
+// for which a TextExtractor is registered for their mime type.
+func attachment_EnqueueIndexContent(rs m.AttachmentSet) {
+	toIndex := h.Attachment().NewSet(rs.Env())
+	for _, attachment := range rs.AsSuperUser("read attachments to index").Records() {
+		if attachment.Type() == "url" || !HasTextExtractor(attachment.MimeType()) {
+			continue
+		}
+		toIndex = toIndex.Union(attachment)
+	}
+	if toIndex.IsEmpty() {
+		return
+	}
+	toIndex.AsSuperUser("queue attachment indexing").Enqueue(rs.T("Extract attachment content"), h.Attachment().Methods().ExtractIndexContent())
+}
+
+func init() {
+	RegisterTextExtractor("text/*", extractPlainText)
+	RegisterTextExtractor("application/pdf", extractPDFText)
+
+	h.Attachment().NewMethod("ExtractIndexContent", attachment_ExtractIndexContent)
+	h.Attachment().NewMethod("EnqueueIndexContent", attachment_EnqueueIndexContent)
+}
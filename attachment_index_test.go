@@ -0,0 +1,71 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+func TestAttachmentTextExtraction(t *testing.T) {
+	Convey("Testing attachment text extractors", t, func() {
+		Convey("Plain text keeps printable words", func() {
+			res, err := ExtractText([]byte("Hello\x00\x01big world"), "text/plain; charset=utf-8")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "Hello\nbig world")
+		})
+		Convey("PDF text layer is extracted from plain and compressed streams", func() {
+			content := "BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\)) Tj ET\nBT [(Second) -250 (line)] TJ ET"
+			var compressed bytes.Buffer
+			w := zlib.NewWriter(&compressed)
+			w.Write([]byte("BT <436f6d70726573736564> Tj ET"))
+			w.Close()
+			pdf := "%PDF-1.4\n1 0 obj << /Length 1 >>\nstream\n" + content + "\nendstream\nendobj\n" +
+				"2 0 obj << /Filter /FlateDecode >>\nstream\n" + compressed.String() + "\nendstream\nendobj\n"
+			res, err := ExtractText([]byte(pdf), "application/pdf")
+			So(err, ShouldBeNil)
+			So(res, ShouldEqual, "Hello (PDF)\nSecond line\nCompressed")
+			_, err = ExtractText([]byte("not a pdf"), "application/pdf")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Mime types without extractor are not indexed", func() {
+			So(HasTextExtractor("application/octet-stream"), ShouldBeFalse)
+			res, err := ExtractText([]byte("some data"), "application/octet-stream")
+			So(err, ShouldBeNil)
+			So(res, ShouldBeEmpty)
+		})
+		Convey("Registering an extractor twice panics", func() {
+			So(func() { RegisterTextExtractor("application/pdf", extractPlainText) }, ShouldPanic)
+		})
+	})
+	Convey("Testing attachment content indexing", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			viper.Set("DataDir", os.TempDir())
+			attachment := h.Attachment().Create(env, h.Attachment().NewData().
+				SetName("notes.txt").
+				SetMimeType("text/plain").
+				SetDatas(base64.StdEncoding.EncodeToString([]byte("Searchable meeting notes"))))
+			Convey("Uploading data queues the extraction job", func() {
+				So(attachment.IndexContent(), ShouldBeEmpty)
+				job := h.QueueJob().Search(env, q.QueueJob().Model().Equals("Attachment").
+					And().Method().Equals("ExtractIndexContent"))
+				So(job.IsNotEmpty(), ShouldBeTrue)
+			})
+			Convey("Running the extraction fills IndexContent", func() {
+				attachment.ExtractIndexContent()
+				So(attachment.IndexContent(), ShouldEqual, "Searchable meeting notes")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -33,6 +33,8 @@ func autoVacuum_PowerOn(rs m.AutoVacuumSet) {
 	}
 	h.Attachment().NewSet(rs.Env()).FileGC()
 	rs.GCUserLogs()
+	rs.GCLogging()
+	rs.GCContactRequests()
 }
 
 func init() {
@@ -0,0 +1,546 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/spf13/viper"
+)
+
+// States of database backups
+const (
+	BackupRunning = "running"
+	BackupDone    = "done"
+	BackupFailed  = "failed"
+)
+
+// BackupStates is the selection of the states of database backups
+var BackupStates = types.Selection{
+	BackupRunning: "Running",
+	BackupDone:    "Done",
+	BackupFailed:  "Failed",
+}
+
+// Default retention rules of database backups. They can be changed with the
+// 'base.backup.retention_days' and 'base.backup.keep_min' config parameters.
+const (
+	// BackupRetentionDays is the number of days during which backups are kept
+	BackupRetentionDays = 30
+	// BackupKeepMin is the number of most recent successful backups which
+	// are never deleted, whatever their age.
+	BackupKeepMin = 3
+)
+
+// A BackupTarget stores backup archives, e.g. in a local directory or in an S3 bucket
+type BackupTarget interface {
+	// Store saves the archive at path under the given file name and
+	// returns the location of the stored backup.
+	Store(env models.Environment, name, path string) (string, error)
+	// Delete removes the backup at the given location
+	Delete(env models.Environment, location string) error
+}
+
+var backupTargets = struct {
+	sync.RWMutex
+	targets map[string]BackupTarget
+	labels  types.Selection
+}{
+	targets: make(map[string]BackupTarget),
+	labels:  make(types.Selection),
+}
+
+// RegisterBackupTarget registers the given BackupTarget under the given name,
+// so that it can be selected in the base.backup.target config parameter.
+// It panics if a target is already registered with this name.
+func RegisterBackupTarget(name, label string, target BackupTarget) {
+	backupTargets.Lock()
+	defer backupTargets.Unlock()
+	if _, exists := backupTargets.targets[name]; exists {
+		log.Panic("Backup target already registered", "name", name)
+	}
+	backupTargets.targets[name] = target
+	backupTargets.labels[name] = label
+}
+
+// GetBackupTarget returns the BackupTarget registered with the given name.
+func GetBackupTarget(name string) (BackupTarget, bool) {
+	backupTargets.RLock()
+	defer backupTargets.RUnlock()
+	target, ok := backupTargets.targets[name]
+	return target, ok
+}
+
+// BackupTargetsSelection returns the selection of the registered backup targets
+func BackupTargetsSelection() types.Selection {
+	backupTargets.RLock()
+	defer backupTargets.RUnlock()
+	res := make(types.Selection)
+	for name, label := range backupTargets.labels {
+		res[name] = label
+	}
+	return res
+}
+
+// A BackupHook adds files to backup archives, e.g. data stored outside of
+// the database and the filestore by a module.
+type BackupHook func(env models.Environment, archive *zip.Writer) error
+
+var backupHooks = struct {
+	sync.RWMutex
+	hooks map[string]BackupHook
+}{
+	hooks: make(map[string]BackupHook),
+}
+
+// RegisterBackupHook registers a hook which is called for each backup.
+// It panics if a hook is already registered with this name.
+func RegisterBackupHook(name string, hook BackupHook) {
+	backupHooks.Lock()
+	defer backupHooks.Unlock()
+	if _, exists := backupHooks.hooks[name]; exists {
+		log.Panic("Backup hook already registered", "name", name)
+	}
+	backupHooks.hooks[name] = hook
+}
+
+// runBackupHooks calls the registered backup hooks sorted by name
+func runBackupHooks(env models.Environment, archive *zip.Writer) error {
+	backupHooks.RLock()
+	names := make([]string, 0, len(backupHooks.hooks))
+	for name := range backupHooks.hooks {
+		names = append(names, name)
+	}
+	hooks := backupHooks.hooks
+	backupHooks.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := hooks[name](env, archive); err != nil {
+			return fmt.Errorf("backup hook %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// pgDumpCommand returns the pg_dump command dumping the database to the given writer.
+//
+// The pg_dump executable is read from the Backup.PgDump server configuration
+// key and never from config parameters, so that users who can edit settings
+// cannot run arbitrary commands on the server.
+func pgDumpCommand(out io.Writer) *exec.Cmd {
+	pgDump := viper.GetString("Backup.PgDump")
+	if pgDump == "" {
+		pgDump = "pg_dump"
+	}
+	cmd := exec.Command(pgDump, "--no-owner", "--format=plain", viper.GetString("DB.Name"))
+	cmd.Env = append(os.Environ(),
+		"PGHOST="+viper.GetString("DB.Host"),
+		"PGPORT="+viper.GetString("DB.Port"),
+		"PGUSER="+viper.GetString("DB.User"),
+		"PGPASSWORD="+viper.GetString("DB.Password"),
+		"PGSSLMODE="+viper.GetString("DB.SSLMode"))
+	cmd.Stdout = out
+	return cmd
+}
+
+// addDirToArchive adds all the files of dir to the archive under the given prefix
+func addDirToArchive(archive *zip.Writer, dir, prefix string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		w, err := archive.Create(prefix + filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// writeBackupArchive writes a zip archive to path containing a manifest, the
+// SQL dump of the database, the filestore if withFilestore is true and the
+// files of the backup hooks.
+func writeBackupArchive(env models.Environment, path string, withFilestore bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	archive := zip.NewWriter(f)
+	manifest, _ := json.MarshalIndent(map[string]interface{}{
+		"db_name":   viper.GetString("DB.Name"),
+		"date":      dates.Now().String(),
+		"filestore": withFilestore,
+	}, "", "    ")
+	w, err := archive.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(manifest); err != nil {
+		return err
+	}
+	w, err = archive.Create("dump.sql")
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := pgDumpCommand(w)
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("pg_dump: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if withFilestore {
+		if err = addDirToArchive(archive, h.Attachment().NewSet(env).FileStore(), "filestore/"); err != nil {
+			return err
+		}
+	}
+	if err = runBackupHooks(env, archive); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// localBackupTarget stores backups in the directory set in the
+// Backup.LocalDir server configuration key, which defaults to DataDir/backups.
+// It is not a config parameter, so that users who can edit settings cannot
+// write files anywhere on the server.
+type localBackupTarget struct{}
+
+// backupLocalDir returns the directory in which local backups are stored
+func backupLocalDir() string {
+	if dir := viper.GetString("Backup.LocalDir"); dir != "" {
+		return dir
+	}
+	return filepath.Join(viper.GetString("DataDir"), "backups")
+}
+
+// Store copies the archive to the backup directory
+func (t localBackupTarget) Store(_ models.Environment, name, path string) (string, error) {
+	dir := backupLocalDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	location := filepath.Join(dir, name)
+	dst, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return location, dst.Close()
+}
+
+// Delete removes the backup file
+func (t localBackupTarget) Delete(_ models.Environment, location string) error {
+	if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+var _ BackupTarget = localBackupTarget{}
+
+// s3BackupTarget stores backups in an S3 compatible bucket configured with
+// the base.backup.s3_* config parameters.
+type s3BackupTarget struct{}
+
+type s3Config struct {
+	endpoint, region, bucket, prefix, accessKey, secretKey string
+}
+
+func (t s3BackupTarget) config(env models.Environment) s3Config {
+	params := h.ConfigParameter().NewSet(env).AsSuperUser("read backup settings")
+	region := params.GetParam("base.backup.s3_region", "us-east-1")
+	return s3Config{
+		endpoint:  strings.TrimSuffix(params.GetParam("base.backup.s3_endpoint", "https://s3."+region+".amazonaws.com"), "/"),
+		region:    region,
+		bucket:    params.GetParam("base.backup.s3_bucket", ""),
+		prefix:    params.GetParam("base.backup.s3_prefix", ""),
+		accessKey: params.GetParam("base.backup.s3_access_key", ""),
+		secretKey: params.GetParam("base.backup.s3_secret_key", ""),
+	}
+}
+
+// do sends an S3 request with the given method for the given object key
+func (t s3BackupTarget) do(cfg s3Config, method, key string, body io.Reader, size int64) error {
+	if cfg.bucket == "" || cfg.accessKey == "" || cfg.secretKey == "" {
+		return fmt.Errorf("S3 backup target is not configured")
+	}
+	objectURL := fmt.Sprintf("%s/%s/%s", cfg.endpoint, cfg.bucket, (&url.URL{Path: key}).EscapedPath())
+	req, err := http.NewRequest(method, objectURL, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	signS3Request(req, cfg.region, cfg.accessKey, cfg.secretKey, time.Now())
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 %s %s: %s: %s", method, key, resp.Status, msg)
+	}
+	return nil
+}
+
+// Store uploads the archive to the bucket
+func (t s3BackupTarget) Store(env models.Environment, name, path string) (string, error) {
+	cfg := t.config(env)
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := cfg.prefix + name
+	if err = t.do(cfg, http.MethodPut, key, f, info.Size()); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", cfg.bucket, key), nil
+}
+
+// Delete removes the backup object from the bucket
+func (t s3BackupTarget) Delete(env models.Environment, location string) error {
+	cfg := t.config(env)
+	key := strings.TrimPrefix(location, fmt.Sprintf("s3://%s/", cfg.bucket))
+	return t.do(cfg, http.MethodDelete, key, nil, 0)
+}
+
+var _ BackupTarget = s3BackupTarget{}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signS3Request signs the given request with AWS Signature Version 4.
+// The payload is not signed, which S3 accepts over HTTPS.
+func signS3Request(req *http.Request, region, accessKey, secretKey string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := hmacSHA256([]byte("AWS4"+secretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
+
+var fields_DatabaseBackup = map[string]models.FieldDefinition{
+	"Name": fields.Char{String: "File Name", Required: true, ReadOnly: true},
+	"Target": fields.Selection{SelectionFunc: BackupTargetsSelection, Required: true, ReadOnly: true,
+		Default: func(env models.Environment) interface{} {
+			return h.ConfigParameter().NewSet(env).AsSuperUser("read backup settings").GetParam("base.backup.target", "local")
+		}},
+	"Location": fields.Char{ReadOnly: true},
+	"State": fields.Selection{Selection: BackupStates, Required: true, ReadOnly: true,
+		Default: models.DefaultValue(BackupRunning)},
+	"IncludeFilestore": fields.Boolean{Default: models.DefaultValue(true), ReadOnly: true},
+	"DateStart": fields.DateTime{String: "Started On", ReadOnly: true,
+		Default: func(env models.Environment) interface{} {
+			return dates.Now()
+		}},
+	"DateEnd": fields.DateTime{String: "Finished On", ReadOnly: true},
+	"Size":    fields.Integer{String: "Size (bytes)", ReadOnly: true},
+	"Error":   fields.Text{ReadOnly: true},
+}
+
+// RunBackup dumps the database and the filestore to a zip archive, stores
+// it on the configured target and returns the DatabaseBackup record.
+// Errors are recorded on the returned record instead of panicking, so that
+// failed backups are kept in the history.
+func databaseBackup_RunBackup(rs m.DatabaseBackupSet) m.DatabaseBackupSet {
+	now := dates.Now()
+	backup := h.DatabaseBackup().NewSet(rs.Env()).AsSuperUser("record database backup").Create(h.DatabaseBackup().NewData().
+		SetName(fmt.Sprintf("%s_%s.zip", viper.GetString("DB.Name"), now.UTC().Format("20060102_150405"))).
+		SetDateStart(now))
+	location, size, err := storeBackupArchive(backup)
+	if err != nil {
+		log.Warn("Database backup failed", "backup", backup.Name(), "error", err)
+		backup.Write(h.DatabaseBackup().NewData().
+			SetState(BackupFailed).
+			SetError(err.Error()).
+			SetDateEnd(dates.Now()))
+		return backup
+	}
+	backup.Write(h.DatabaseBackup().NewData().
+		SetState(BackupDone).
+		SetLocation(location).
+		SetSize(size).
+		SetDateEnd(dates.Now()))
+	return backup
+}
+
+// storeBackupArchive writes the archive of the given backup to a temporary
+// file and stores it on the backup's target. It returns the location and
+// size of the stored archive.
+func storeBackupArchive(rs m.DatabaseBackupSet) (string, int64, error) {
+	target, ok := GetBackupTarget(rs.Target())
+	if !ok {
+		return "", 0, fmt.Errorf("unknown backup target %s", rs.Target())
+	}
+	dir, err := ioutil.TempDir("", "hexya-backup")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, rs.Name())
+	if err = writeBackupArchive(rs.Env(), path, rs.IncludeFilestore()); err != nil {
+		return "", 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	location, err := target.Store(rs.Env(), rs.Name(), path)
+	return location, info.Size(), err
+}
+
+// ActionBackupNow enqueues a backup of the database
+func databaseBackup_ActionBackupNow(rs m.DatabaseBackupSet) {
+	rs.Enqueue(rs.T("Database backup"), h.DatabaseBackup().Methods().RunBackup())
+}
+
+// ApplyRetention deletes the backups older than the retention period, except
+// the most recent successful ones, from their target and from the history.
+func databaseBackup_ApplyRetention(rs m.DatabaseBackupSet) {
+	params := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read backup retention")
+	days, err := strconv.Atoi(params.GetParam("base.backup.retention_days", strconv.Itoa(BackupRetentionDays)))
+	if err != nil {
+		log.Warn("Invalid backup retention", "error", err)
+		days = BackupRetentionDays
+	}
+	keepMin, err := strconv.Atoi(params.GetParam("base.backup.keep_min", strconv.Itoa(BackupKeepMin)))
+	if err != nil {
+		log.Warn("Invalid backup minimum count", "error", err)
+		keepMin = BackupKeepMin
+	}
+	backups := h.DatabaseBackup().NewSet(rs.Env()).AsSuperUser("apply backup retention")
+	kept := backups.Search(q.DatabaseBackup().State().Equals(BackupDone)).OrderBy("DateStart desc").Limit(keepMin)
+	limit := dates.Now().AddDate(0, 0, -days)
+	expired := backups.Search(q.DatabaseBackup().State().NotEquals(BackupRunning).
+		And().DateStart().Lower(limit)).Subtract(kept)
+	for _, backup := range expired.Records() {
+		if backup.State() == BackupDone && backup.Location() != "" {
+			target, ok := GetBackupTarget(backup.Target())
+			if !ok {
+				log.Warn("Unknown backup target", "backup", backup.Name(), "target", backup.Target())
+				continue
+			}
+			if err := target.Delete(rs.Env(), backup.Location()); err != nil {
+				log.Warn("Unable to delete backup", "backup", backup.Name(), "error", err)
+				continue
+			}
+		}
+		backup.Unlink()
+	}
+}
+
+// ScheduledBackup backs up the database and applies the retention rules.
+// It is called by the base_cron_database_backup cron, which is disabled by default.
+func databaseBackup_ScheduledBackup(rs m.DatabaseBackupSet) {
+	rs.RunBackup()
+	rs.ApplyRetention()
+}
+
+// checkBackups warns if the last backup failed or is too old
+func checkBackups(env models.Environment) SystemCheckResult {
+	last := h.DatabaseBackup().NewSet(env).AsSuperUser("check last backup").Search(
+		q.DatabaseBackup().State().NotEquals(BackupRunning)).OrderBy("DateStart desc").Limit(1)
+	if last.IsEmpty() {
+		return SystemCheckResult{Status: SystemCheckOK, Value: "never"}
+	}
+	res := SystemCheckResult{Status: SystemCheckOK, Value: last.DateStart().String()}
+	switch {
+	case last.State() == BackupFailed:
+		res.Status = SystemCheckError
+		res.Message = fmt.Sprintf("The last backup failed: %s", last.Error())
+	case time.Since(last.DateStart().Time) > SystemCheckBackupMaxAge:
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("The database has not been backed up for more than %s", SystemCheckBackupMaxAge)
+	}
+	return res
+}
+
+func init() {
+	RegisterBackupTarget("local", "Local Directory", localBackupTarget{})
+	RegisterBackupTarget("s3", "Amazon S3", s3BackupTarget{})
+	RegisterSystemCheck("backups", "Last Database Backup", checkBackups)
+
+	models.NewModel("DatabaseBackup")
+	h.DatabaseBackup().SetDefaultOrder("DateStart desc", "ID desc")
+	h.DatabaseBackup().AddFields(fields_DatabaseBackup)
+	h.DatabaseBackup().NewMethod("RunBackup", databaseBackup_RunBackup)
+	h.DatabaseBackup().NewMethod("ActionBackupNow", databaseBackup_ActionBackupNow)
+	h.DatabaseBackup().NewMethod("ApplyRetention", databaseBackup_ApplyRetention)
+	h.DatabaseBackup().NewMethod("ScheduledBackup", databaseBackup_ScheduledBackup)
+}
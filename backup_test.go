@@ -0,0 +1,78 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+func TestDatabaseBackup(t *testing.T) {
+	Convey("Testing database backups", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			dir, err := ioutil.TempDir("", "hexya-backup-test")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			params := h.ConfigParameter().NewSet(env)
+			params.SetParam("base.backup.target", "local")
+			previousDir, previousPgDump := viper.GetString("Backup.LocalDir"), viper.GetString("Backup.PgDump")
+			defer func() {
+				viper.Set("Backup.LocalDir", previousDir)
+				viper.Set("Backup.PgDump", previousPgDump)
+			}()
+			viper.Set("Backup.LocalDir", dir)
+			// echo stands for pg_dump so that tests do not need PostgreSQL client tools
+			viper.Set("Backup.PgDump", "echo")
+			Convey("Backups are stored as zip archives on the target", func() {
+				backup := h.DatabaseBackup().NewSet(env).RunBackup()
+				So(backup.State(), ShouldEqual, BackupDone)
+				So(backup.Location(), ShouldEqual, filepath.Join(dir, backup.Name()))
+				So(backup.Size(), ShouldBeGreaterThan, 0)
+				archive, err := zip.OpenReader(backup.Location())
+				So(err, ShouldBeNil)
+				defer archive.Close()
+				var names []string
+				for _, f := range archive.File {
+					names = append(names, f.Name)
+				}
+				So(names, ShouldContain, "manifest.json")
+				So(names, ShouldContain, "dump.sql")
+			})
+			Convey("Failed backups are recorded", func() {
+				viper.Set("Backup.PgDump", "false")
+				backup := h.DatabaseBackup().NewSet(env).RunBackup()
+				So(backup.State(), ShouldEqual, BackupFailed)
+				So(backup.Error(), ShouldContainSubstring, "pg_dump")
+				result := checkBackups(env)
+				So(result.Status, ShouldEqual, SystemCheckError)
+			})
+			Convey("Old backups are deleted except the most recent ones", func() {
+				params.SetParam("base.backup.keep_min", "1")
+				older := h.DatabaseBackup().NewSet(env).RunBackup()
+				os.Rename(older.Location(), older.Location()+".old")
+				older.SetLocation(older.Location() + ".old")
+				old := h.DatabaseBackup().NewSet(env).RunBackup()
+				old.SetDateStart(dates.Now().AddDate(0, 0, -40))
+				older.SetDateStart(dates.Now().AddDate(0, 0, -50))
+				location := older.Location()
+				h.DatabaseBackup().NewSet(env).ApplyRetention()
+				So(h.DatabaseBackup().Search(env, q.DatabaseBackup().ID().Equals(old.ID())).IsEmpty(), ShouldBeFalse)
+				So(h.DatabaseBackup().Search(env, q.DatabaseBackup().ID().Equals(older.ID())).IsEmpty(), ShouldBeTrue)
+				_, err := os.Stat(location)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
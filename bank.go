@@ -43,6 +43,10 @@ var fields_Bank = map[string]models.FieldDefinition{
 	"Phone":   fields.Char{},
 	"Active":  fields.Boolean{Default: models.DefaultValue(true)},
 	"BIC":     fields.Char{String: "Bank Identifier Code", Index: true, Help: "Sometimes called BIC or Swift."},
+	"ClearingSystem": fields.Selection{Selection: ClearingSystems,
+		Help: "ISO 20022 clearing system in which the bank is identified, for payments without BIC"},
+	"ClearingMemberID": fields.Char{String: "Clearing Member ID",
+		Help: "Identifier of the bank in its clearing system, e.g. the routing number for USABA"},
 }
 
 func bank_NameGet(rs m.BankSet) string {
@@ -90,6 +94,10 @@ var fields_BankAccount = map[string]models.FieldDefinition{
 	"Partner": fields.Many2One{RelationModel: h.Partner(),
 		String: "Account Holder", OnDelete: models.Cascade, Index: true,
 		Filter: q.Partner().IsCompany().Equals(true).Or().Parent().IsNull()},
+	"HolderName": fields.Char{String: "Account Holder Name",
+		Help: "Name of the account holder, if different from the name of the partner"},
+	"HolderCountry": fields.Many2One{String: "Country of Residence", RelationModel: h.Country(),
+		Help: "Country of residence or incorporation of the account holder, if different from the partner country"},
 	"Bank":     fields.Many2One{RelationModel: h.Bank()},
 	"BankName": fields.Char{Related: "Bank.Name"},
 	"BankBIC":  fields.Char{Related: "Bank.BIC"},
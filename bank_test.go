@@ -53,3 +53,71 @@ func TestSanitizedAccountNumber(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestSEPAIdentity(t *testing.T) {
+	Convey("Testing SEPA identities", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			germany := h.Country().Search(env, q.Country().Code().Equals("DE"))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Muster GmbH").
+				SetIsCompany(true).
+				SetStreet("Hauptstraße 1").
+				SetZip("10115").
+				SetCity("Berlin").
+				SetCountry(germany).
+				SetLEI("5493001KJTIIGC8Y1R12"))
+			bank := h.Bank().Create(env, h.Bank().NewData().
+				SetName("Commerzbank").
+				SetBIC("COBADEFFXXX"))
+			Convey("Invalid LEIs are rejected", func() {
+				So(func() { partner.SetLEI("5493001KJTIIGC8Y1R13") }, ShouldPanic)
+				So(func() { partner.SetLEI("5493001KJTIIGC8Y") }, ShouldPanic)
+			})
+			Convey("Identity of an IBAN account", func() {
+				account := h.BankAccount().Create(env, h.BankAccount().NewData().
+					SetName("DE89 3704 0044 0532 0130 00").
+					SetPartner(partner).
+					SetBank(bank))
+				identity, err := account.GetSEPAIdentity()
+				So(err, ShouldBeNil)
+				So(identity.Name, ShouldEqual, "Muster GmbH")
+				So(identity.LEI, ShouldEqual, "5493001KJTIIGC8Y1R12")
+				So(identity.Country, ShouldEqual, "DE")
+				So(identity.AddressLines, ShouldResemble, []string{"Hauptstraße 1", "10115 Berlin"})
+				So(identity.IBAN, ShouldEqual, "DE89370400440532013000")
+				So(identity.BIC, ShouldEqual, "COBADEFFXXX")
+				Convey("Holder name and country can be overridden", func() {
+					france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+					account.SetHolderName("Muster Holding")
+					account.SetHolderCountry(france)
+					identity, err := account.GetSEPAIdentity()
+					So(err, ShouldBeNil)
+					So(identity.Name, ShouldEqual, "Muster Holding")
+					So(identity.Country, ShouldEqual, "FR")
+				})
+			})
+			Convey("Invalid identities are reported", func() {
+				account := h.BankAccount().Create(env, h.BankAccount().NewData().
+					SetName("DE89 3704 0044 0532 0130 01").
+					SetPartner(partner).
+					SetBank(bank))
+				_, err := account.GetSEPAIdentity()
+				So(err, ShouldNotBeNil)
+				usBank := h.Bank().Create(env, h.Bank().NewData().
+					SetName("US Bank").
+					SetClearingSystem("USABA"))
+				usAccount := h.BankAccount().Create(env, h.BankAccount().NewData().
+					SetName("123456789").
+					SetPartner(partner).
+					SetBank(usBank))
+				_, err = usAccount.GetSEPAIdentity()
+				So(err, ShouldNotBeNil)
+				usBank.SetClearingMemberID("021000021")
+				identity, err := usAccount.GetSEPAIdentity()
+				So(err, ShouldBeNil)
+				So(identity.AccountNumber, ShouldEqual, "123456789")
+				So(identity.ClearingSystem, ShouldEqual, "USABA")
+			})
+		}), ShouldBeNil)
+	})
+}
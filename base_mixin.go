@@ -0,0 +1,313 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/operator"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/spf13/viper"
+)
+
+// WithCompany returns a copy of this recordset whose environment is set to
+// work for the given company. Company dependent fields and sequences will use
+// this company instead of the current user's company.
+func baseMixin_WithCompany(rs m.BaseMixinSet, company m.CompanySet) m.BaseMixinSet {
+	if company.IsEmpty() {
+		return rs
+	}
+	return rs.
+		WithContext("company_id", company.ID()).
+		WithContext("force_company", company.ID())
+}
+
+// WithLang returns a copy of this recordset whose environment uses the given
+// language for translations.
+func baseMixin_WithLang(rs m.BaseMixinSet, lang string) m.BaseMixinSet {
+	return rs.WithContext("lang", lang)
+}
+
+// superUserCaller returns the location of the code that requested a super
+// user escalation, skipping the frames of the ORM, of the generated pool and
+// of the escalation helpers themselves.
+func superUserCaller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		switch {
+		case strings.HasPrefix(frame.Function, "github.com/erlangs/okoo/"),
+			strings.HasPrefix(frame.Function, "github.com/erlangs/pool/"),
+			strings.HasPrefix(frame.Function, "reflect."),
+			strings.HasPrefix(frame.Function, "runtime."),
+			strings.HasSuffix(frame.Function, ".logSuperUser"),
+			strings.HasSuffix(frame.Function, ".baseMixin_AsSuperUser"),
+			strings.HasSuffix(frame.Function, ".asSuperUser"):
+		default:
+			return fmt.Sprintf("%s:%d %s", frame.File, frame.Line, frame.Function)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// debugLogging returns true if the server is configured to log at Debug
+// level, so that costly log values are only computed when they are used.
+func debugLogging() bool {
+	return strings.EqualFold(viper.GetString("LogLevel"), "debug")
+}
+
+// logSuperUser logs the escalation of the given collection to the super
+// user with the given reason and the calling code. Escalations are logged
+// at Debug level since they happen on hot paths such as reading config
+// parameters, and the caller is only looked up when Debug is enabled.
+func logSuperUser(rc *models.RecordCollection, reason string) {
+	if !debugLogging() {
+		return
+	}
+	log.Debug("Executing as super user", "model", rc.ModelName(), "ids", rc.Ids(),
+		"uid", rc.Env().Uid(), "reason", reason, "caller", superUserCaller())
+}
+
+// asSuperUser returns a copy of the given collection executed as the super
+// user, logging the escalation with the given reason. It is the untyped
+// counterpart of AsSuperUser, for generic code working on any model.
+func asSuperUser(rc *models.RecordCollection, reason string) *models.RecordCollection {
+	logSuperUser(rc, reason)
+	return rc.Sudo()
+}
+
+// AsSuperUser returns a copy of this recordset executed as the super user.
+//
+// Contrary to calling Sudo() directly, the escalation is logged with the
+// given reason and the calling code, so that bypassing access rights can
+// be audited.
+func baseMixin_AsSuperUser(rs m.BaseMixinSet, reason string) m.BaseMixinSet {
+	logSuperUser(rs.Collection(), reason)
+	return rs.Sudo()
+}
+
+// ParentCyclePath returns the display names of the records forming a cycle
+// in the hierarchy defined by the given parent field, starting from the records
+// of this recordset. The first and last items of the returned slice are the
+// same record (e.g. ["A", "B", "A"]). It returns nil if there is no cycle.
+//
+// The hierarchy is walked in a single recursive SQL query, so that this method
+// remains efficient for deep hierarchies.
+func baseMixin_ParentCyclePath(rs m.BaseMixinSet, parentField models.FieldName) []string {
+	if rs.IsEmpty() {
+		return nil
+	}
+	table := rs.Collection().Model().Table()
+	query := fmt.Sprintf(`
+WITH RECURSIVE ancestors(id, parent_id, path, cycle) AS (
+	SELECT t.id, t.%[2]s, ARRAY[t.id], false
+	FROM %[1]s t
+	WHERE t.id IN (?)
+  UNION ALL
+	SELECT t.id, t.%[2]s, a.path || t.id, t.id = ANY(a.path)
+	FROM %[1]s t
+	JOIN ancestors a ON t.id = a.parent_id
+	WHERE NOT a.cycle
+)
+SELECT array_to_string(path, ',') FROM ancestors WHERE cycle LIMIT 1`, table, parentField.JSON())
+	var paths []string
+	rs.Env().Cr().Select(&paths, query, rs.Ids())
+	if len(paths) == 0 {
+		return nil
+	}
+	var ids []int64
+	for _, sID := range strings.Split(paths[0], ",") {
+		id, err := strconv.ParseInt(sID, 10, 64)
+		if err != nil {
+			log.Panic("Unable to parse cycle path", "path", paths[0], "error", err)
+		}
+		ids = append(ids, id)
+	}
+	// Remove the records leading to the cycle, but not part of it
+	last := ids[len(ids)-1]
+	for i, id := range ids {
+		if id == last {
+			ids = ids[i:]
+			break
+		}
+	}
+	model := models.Registry.MustGet(rs.ModelName())
+	res := make([]string, len(ids))
+	for i, id := range ids {
+		res[i] = model.Browse(rs.Env(), []int64{id}).Call("NameGet").(string)
+	}
+	return res
+}
+
+// NameSearchFields returns the fields in which SearchByName looks for each
+// word of a multi-word search. Default implementation returns the Name field
+// if it exists.
+//
+// Override this method to search in other fields of your model, or return
+// nil to disable multi-word search on your model.
+func baseMixin_NameSearchFields(rs m.BaseMixinSet) models.FieldNames {
+	if _, exists := rs.Collection().Model().Fields().Get("name"); !exists {
+		return nil
+	}
+	return models.FieldNames{models.Registry.MustGet(rs.ModelName()).FieldName("Name")}
+}
+
+// SearchByName splits the given name into words and returns the records for
+// which each word matches at least one of the NameSearchFields. For instance
+// "john acme" matches a partner "John Smith" working at "Acme".
+//
+// Single word searches and non pattern operators are left untouched.
+func baseMixin_SearchByName(rs m.BaseMixinSet, name string, op operator.Operator, additionalCond q.BaseMixinCondition, limit int) m.BaseMixinSet {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return rs.Super().SearchByName(name, op, additionalCond, limit)
+	}
+	switch op {
+	case operator.Contains, operator.IContains, operator.Like, operator.ILike:
+	default:
+		return rs.Super().SearchByName(name, op, additionalCond, limit)
+	}
+	searchFields := rs.NameSearchFields()
+	if len(searchFields) == 0 {
+		return rs.Super().SearchByName(name, op, additionalCond, limit)
+	}
+	model := models.Registry.MustGet(rs.ModelName())
+	var cond q.BaseMixinCondition
+	for i, word := range words {
+		var wordCond q.BaseMixinCondition
+		for j, field := range searchFields {
+			fieldCond := q.BaseMixinCondition{Condition: model.Field(field).AddOperator(op, word)}
+			if j == 0 {
+				wordCond = fieldCond
+				continue
+			}
+			wordCond = wordCond.OrCond(fieldCond)
+		}
+		if i == 0 {
+			cond = wordCond
+			continue
+		}
+		cond = cond.AndCond(wordCond)
+	}
+	if !additionalCond.Underlying().IsEmpty() {
+		cond = cond.AndCond(additionalCond)
+	}
+	return rs.Search(cond).Limit(limit)
+}
+
+// DefaultSearchIterBatchSize is the batch size used by SearchIter when none is given
+const DefaultSearchIterBatchSize = 1000
+
+// SearchIter searches the records matching cond and calls fnct with
+// successive batches of at most batchSize records, in ID order, until all
+// records have been processed or fnct returns false. If batchSize is 0,
+// DefaultSearchIterBatchSize is used.
+//
+// Batches are fetched one at a time with keyset pagination on ID, so that
+// large tables can be traversed without loading all records in memory. The
+// iteration works on a stable snapshot: records created after the call are
+// not returned, and records are never returned twice even if they are
+// modified by fnct. The cache of each batch is cleared after fnct returns.
+func baseMixin_SearchIter(rs m.BaseMixinSet, cond q.BaseMixinCondition, batchSize int, fnct func(m.BaseMixinSet) bool) {
+	if batchSize <= 0 {
+		batchSize = DefaultSearchIterBatchSize
+	}
+	model := models.Registry.MustGet(rs.ModelName())
+	var maxID int64
+	rs.Env().Cr().Get(&maxID, fmt.Sprintf(`SELECT COALESCE(MAX(id), 0) FROM %s`, rs.Collection().Model().Table()))
+	var lastID int64
+	for lastID < maxID {
+		batchCond := model.Field(models.ID).Greater(lastID).AndCond(model.Field(models.ID).LowerOrEqual(maxID))
+		if !cond.Underlying().IsEmpty() {
+			batchCond = batchCond.AndCond(cond)
+		}
+		batch := rs.Search(q.BaseMixinCondition{Condition: batchCond}).OrderBy("ID").Limit(batchSize)
+		if batch.IsEmpty() {
+			return
+		}
+		ids := batch.Ids()
+		lastID = ids[len(ids)-1]
+		cont := fnct(batch)
+		batch.Collection().InvalidateCache()
+		if !cont {
+			return
+		}
+	}
+}
+
+// Prefetch loads the given fields for all the records of this recordset in a
+// single query per model, so that subsequent accesses inside Records() loops
+// are served from the cache. Paths can traverse relations, e.g. "Children.Type"
+// loads Children on this recordset, then Type on all the children at once.
+//
+// It returns this recordset to allow chaining.
+func baseMixin_Prefetch(rs m.BaseMixinSet, paths ...string) m.BaseMixinSet {
+	prefetchPaths(rs.Collection(), paths)
+	return rs
+}
+
+// prefetchPaths loads the given field paths on rc, recursing into relations
+func prefetchPaths(rc *models.RecordCollection, paths []string) {
+	if rc.IsEmpty() || len(paths) == 0 {
+		return
+	}
+	var fieldNames []string
+	subPaths := make(map[string][]string)
+	for _, path := range paths {
+		parts := strings.SplitN(path, ".", 2)
+		if _, exists := subPaths[parts[0]]; !exists {
+			fieldNames = append(fieldNames, parts[0])
+			subPaths[parts[0]] = nil
+		}
+		if len(parts) == 2 {
+			subPaths[parts[0]] = append(subPaths[parts[0]], parts[1])
+		}
+	}
+	model := models.Registry.MustGet(rc.ModelName())
+	fields := make([]models.FieldName, len(fieldNames))
+	for i, name := range fieldNames {
+		fields[i] = model.FieldName(name)
+	}
+	rc.Load(fields...)
+	for i, name := range fieldNames {
+		if len(subPaths[name]) == 0 {
+			continue
+		}
+		relModel := model.FieldsGet(fields[i])[fields[i].JSON()].Relation
+		if relModel == "" {
+			log.Panic("Unable to prefetch path through non relational field", "model", rc.ModelName(), "field", name)
+		}
+		var ids []int64
+		seen := make(map[int64]bool)
+		for _, rec := range rc.Records() {
+			for _, id := range rec.Get(fields[i]).(models.RecordSet).Ids() {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+		prefetchPaths(models.Registry.MustGet(relModel).Browse(rc.Env(), ids), subPaths[name])
+	}
+}
+
+func init() {
+	h.BaseMixin().NewMethod("Prefetch", baseMixin_Prefetch)
+	h.BaseMixin().NewMethod("SearchIter", baseMixin_SearchIter)
+	h.BaseMixin().NewMethod("WithCompany", baseMixin_WithCompany)
+	h.BaseMixin().NewMethod("WithLang", baseMixin_WithLang)
+	h.BaseMixin().NewMethod("AsSuperUser", baseMixin_AsSuperUser)
+	h.BaseMixin().NewMethod("ParentCyclePath", baseMixin_ParentCyclePath)
+	h.BaseMixin().NewMethod("NameSearchFields", baseMixin_NameSearchFields)
+	h.BaseMixin().Methods().SearchByName().Extend(baseMixin_SearchByName)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBaseMixinEnvironmentHelpers(t *testing.T) {
+	Convey("Testing BaseMixin environment helpers", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Helper Partner"))
+			Convey("WithLang sets the lang key of the context", func() {
+				So(partner.WithLang("fr_FR").Env().Context().GetString("lang"), ShouldEqual, "fr_FR")
+			})
+			Convey("WithCompany sets the company keys of the context", func() {
+				company := h.Company().NewSet(env).CompanyDefaultGet()
+				rs := partner.WithCompany(company)
+				So(rs.Env().Context().GetInteger("company_id"), ShouldEqual, company.ID())
+				So(rs.Env().Context().GetInteger("force_company"), ShouldEqual, company.ID())
+			})
+			Convey("WithCompany with an empty company leaves the context untouched", func() {
+				rs := partner.WithCompany(h.Company().NewSet(env))
+				So(rs.Env().Context().HasKey("force_company"), ShouldBeFalse)
+			})
+			Convey("AsSuperUser switches to the super user", func() {
+				So(partner.AsSuperUser("test").Env().Uid(), ShouldEqual, security.SuperUserID)
+			})
+			Convey("Super user escalations are logged with the calling code", func() {
+				So(superUserCaller(), ShouldContainSubstring, "base_mixin_test.go")
+				So(asSuperUser(partner.Collection(), "test").Env().Uid(), ShouldEqual, security.SuperUserID)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestGetModelSchema(t *testing.T) {
+	Convey("Testing model JSON schema generation", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			schema := h.Partner().NewSet(env).GetModelSchema()
+			properties := schema["properties"].(map[string]interface{})
+			Convey("Schema describes the model", func() {
+				So(schema["title"], ShouldEqual, "Partner")
+				So(schema["type"], ShouldEqual, "object")
+				So(schema["required"], ShouldContain, "name")
+			})
+			Convey("Field types are converted", func() {
+				So(properties["name"].(map[string]interface{})["type"], ShouldEqual, "string")
+				So(properties["is_company"].(map[string]interface{})["type"], ShouldEqual, "boolean")
+				So(properties["date"].(map[string]interface{})["format"], ShouldEqual, "date")
+				So(properties["type"].(map[string]interface{})["oneOf"], ShouldNotBeEmpty)
+			})
+			Convey("Relations are given", func() {
+				parent := properties["parent_id"].(map[string]interface{})
+				So(parent["type"], ShouldEqual, "integer")
+				So(parent["x-relation"], ShouldEqual, "Partner")
+				categories := properties["category_ids"].(map[string]interface{})
+				So(categories["type"], ShouldEqual, "array")
+				So(categories["x-relation"], ShouldEqual, "PartnerCategory")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestSearchIter(t *testing.T) {
+	Convey("Testing batched search iteration", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			for i := 0; i < 7; i++ {
+				h.Partner().Create(env, h.Partner().NewData().SetName(fmt.Sprintf("Iter Partner %d", i)).SetRef("ITER"))
+			}
+			cond := q.Partner().Ref().Equals("ITER")
+			Convey("All records are returned in ID ordered batches", func() {
+				var batches []int
+				var ids []int64
+				h.Partner().NewSet(env).SearchIter(cond, 3, func(batch m.PartnerSet) bool {
+					batches = append(batches, batch.Len())
+					ids = append(ids, batch.Ids()...)
+					return true
+				})
+				So(batches, ShouldResemble, []int{3, 3, 1})
+				So(ids, ShouldResemble, h.Partner().Search(env, cond).OrderBy("ID").Ids())
+			})
+			Convey("Iteration stops when the function returns false", func() {
+				var count int
+				h.Partner().NewSet(env).SearchIter(cond, 3, func(batch m.PartnerSet) bool {
+					count += batch.Len()
+					return false
+				})
+				So(count, ShouldEqual, 3)
+			})
+			Convey("Records created during iteration are not returned", func() {
+				var count int
+				h.Partner().NewSet(env).SearchIter(cond, 3, func(batch m.PartnerSet) bool {
+					h.Partner().Create(env, h.Partner().NewData().SetName("Iter Partner New").SetRef("ITER"))
+					count += batch.Len()
+					return true
+				})
+				So(count, ShouldEqual, 7)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPrefetch(t *testing.T) {
+	Convey("Testing prefetching of fields", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Partner().Create(env, h.Partner().NewData().SetName("Prefetch Corp").SetIsCompany(true))
+			h.Partner().Create(env, h.Partner().NewData().SetName("Prefetch Child 1").SetParent(company))
+			h.Partner().Create(env, h.Partner().NewData().SetName("Prefetch Child 2").SetParent(company))
+			Convey("Prefetch returns the same recordset", func() {
+				res := company.Prefetch("Name", "Children.Name", "Children.CommercialPartner")
+				So(res.Equals(company), ShouldBeTrue)
+				for _, child := range res.Children().Records() {
+					So(child.CommercialPartner().Equals(company), ShouldBeTrue)
+				}
+			})
+			Convey("Prefetching through a non relational field panics", func() {
+				So(func() { company.Prefetch("Name.Foo") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -4,6 +4,8 @@
 package base
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/erlangs/okoo/src/models"
@@ -74,6 +76,46 @@ func TestPartners(t *testing.T) {
 				So(partners2.Len(), ShouldEqual, 1)
 				So(partners2.DisplayName(), ShouldEqual, "B Raoul chirurgiens-dentistes.fr")
 			})
+			Convey("Partner multi-word NameSearch", func() {
+				acme := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Acme").
+					SetIsCompany(true))
+				john := h.Partner().Create(env, h.Partner().NewData().
+					SetName("John Smith").
+					SetParent(acme))
+				partners := h.Partner().NewSet(env).SearchByName("john acme", operator.IContains, q.PartnerCondition{}, 0)
+				So(partners.Equals(john), ShouldBeTrue)
+				partners = h.Partner().NewSet(env).SearchByName("john foo", operator.IContains, q.PartnerCondition{}, 0)
+				So(partners.IsEmpty(), ShouldBeTrue)
+				john.SetRef("ACME 042")
+				partners = h.Partner().NewSet(env).SearchByName("ACME 042", operator.Equals, q.PartnerCondition{}, 0)
+				So(partners.Equals(john), ShouldBeTrue)
+				partners = h.Partner().NewSet(env).SearchByName("acme 042", operator.ILike, q.PartnerCondition{}, 0)
+				So(partners.Equals(john), ShouldBeTrue)
+				partners = h.Partner().NewSet(env).SearchByName("ACME 042", operator.Equals, q.Partner().Ref().Equals("ACME 043"), 0)
+				So(partners.IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Partner GetEmailRecipients", func() {
+				acme := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Acme").
+					SetIsCompany(true).
+					SetEmail("info@acme.com").
+					SetLang("en_US"))
+				john := h.Partner().Create(env, h.Partner().NewData().
+					SetName("John").
+					SetParent(acme).
+					SetEmail("Info@acme.com").
+					SetLang("en_US"))
+				jean := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Jean").
+					SetEmail("jean@acme.fr").
+					SetLang("fr_FR"))
+				noMail := h.Partner().Create(env, h.Partner().NewData().SetName("No Mail"))
+				res := john.Union(acme).Union(jean).Union(noMail).GetEmailRecipients()
+				So(res, ShouldHaveLength, 2)
+				So(res["en_US"], ShouldResemble, []string{acme.EmailFormatted()})
+				So(res["fr_FR"], ShouldResemble, []string{jean.EmailFormatted()})
+			})
 			Convey("Partner Address Sync", func() {
 				ghostStep := h.Partner().Create(env, h.Partner().NewData().
 					SetName("GhostStep").
@@ -532,6 +574,7 @@ func TestPartnerRecursion(t *testing.T) {
 			Convey("Our initial data is OK", func() {
 				So(p3.CheckRecursion(), ShouldBeTrue)
 				So(p1.Union(p2).Union(p3).CheckRecursion(), ShouldBeTrue)
+				So(p1.Union(p2).Union(p3).ParentCyclePath(h.Partner().Fields().Parent()), ShouldBeEmpty)
 			})
 			Convey("Creating a recursion on p1 should panic", func() {
 				So(func() { p1.SetParent(p3) }, ShouldPanic)
@@ -546,6 +589,140 @@ func TestPartnerRecursion(t *testing.T) {
 				ps := p1.Union(p2).Union(p3)
 				So(func() { ps.SetPhone("123456") }, ShouldNotPanic)
 			})
+			Convey("A multi-level cycle is reported from the records leading to it", func() {
+				p4 := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Elmtree Grand-Grand-Child 1.1.1").
+					SetParent(p3))
+				env.Cr().Execute(`UPDATE partner SET parent_id = ? WHERE id = ?`, p3.ID(), p1.ID())
+				path := p4.ParentCyclePath(h.Partner().Fields().Parent())
+				So(path, ShouldHaveLength, 4)
+				So(path[0], ShouldEqual, path[3])
+				So(path, ShouldNotContain, p4.DisplayName())
+				So(p2.ParentCyclePath(h.Partner().Fields().Parent()), ShouldHaveLength, 4)
+			})
+			Convey("A self-parent is reported as a cycle of one record", func() {
+				env.Cr().Execute(`UPDATE partner SET parent_id = id WHERE id = ?`, p1.ID())
+				path := p1.ParentCyclePath(h.Partner().Fields().Parent())
+				So(path, ShouldHaveLength, 2)
+				So(path[0], ShouldEqual, path[1])
+				So(p3.ParentCyclePath(h.Partner().Fields().Parent()), ShouldHaveLength, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPartnerCommercialSync(t *testing.T) {
+	Convey("Testing partner commercial sync", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Oaktree").
+				SetIsCompany(true))
+			child := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Oaktree Child").
+				SetParent(company))
+			grandChild := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Oaktree Grand-Child").
+				SetParent(child))
+			subsidiary := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Oaktree Subsidiary").
+				SetIsCompany(true).
+				SetParent(company))
+			Convey("Commercial fields are synced to all descendants within the company", func() {
+				company.SetVAT("BE0477472701")
+				So(child.VAT(), ShouldEqual, "BE0477472701")
+				So(grandChild.VAT(), ShouldEqual, "BE0477472701")
+				So(grandChild.CommercialPartner().Equals(company), ShouldBeTrue)
+				So(subsidiary.VAT(), ShouldBeEmpty)
+			})
+			Convey("Inconsistent commercial partners are detected and fixed", func() {
+				So(company.CommercialInconsistencies().IsEmpty(), ShouldBeTrue)
+				grandChild.WithoutSync().WithContext(ContextKeyForceComputeWrite, true).
+					Write(h.Partner().NewData().SetCommercialPartner(subsidiary))
+				So(company.CommercialInconsistencies().Equals(grandChild), ShouldBeTrue)
+				company.CommercialSyncToChildren()
+				So(grandChild.CommercialPartner().Equals(company), ShouldBeTrue)
+				So(company.CommercialInconsistencies().IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPartnerCategoryMerge(t *testing.T) {
+	Convey("Testing Partner Category Merge", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			tag := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Germany"))
+			dup := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("germany"))
+			child := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Berlin").SetParent(dup))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Dieter").
+				SetCategories(dup))
+			Convey("New tags get a color", func() {
+				So(tag.Color(), ShouldBeGreaterThan, 0)
+				So(tag.Color(), ShouldBeLessThan, len(ColorPicker))
+			})
+			Convey("Merging reassigns partners and children", func() {
+				dup.Merge(tag)
+				So(partner.Categories().Equals(tag), ShouldBeTrue)
+				So(child.Parent().Equals(tag), ShouldBeTrue)
+				So(h.PartnerCategory().Search(env, q.PartnerCategory().Name().Equals("germany")).IsEmpty(), ShouldBeTrue)
+				So(tag.PartnerCount(), ShouldEqual, 1)
+			})
+			Convey("Merging does not tag partners of child tags", func() {
+				berliner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Berliner").
+					SetCategories(child))
+				dup.Merge(tag)
+				So(berliner.Categories().Equals(child), ShouldBeTrue)
+			})
+			Convey("Partner count does not include partners of child tags", func() {
+				h.Partner().Create(env, h.Partner().NewData().
+					SetName("Berliner").
+					SetCategories(child))
+				So(child.PartnerCount(), ShouldEqual, 1)
+				So(dup.PartnerCount(), ShouldEqual, 1)
+				dup.RefreshPartnerCount()
+				So(dup.PartnerCount(), ShouldEqual, 1)
+			})
+			Convey("Partner count follows partner archiving and deletion", func() {
+				So(dup.PartnerCount(), ShouldEqual, 1)
+				partner.SetActive(false)
+				So(dup.PartnerCount(), ShouldEqual, 0)
+				partner.SetActive(true)
+				So(dup.PartnerCount(), ShouldEqual, 1)
+				partner.Unlink()
+				So(dup.PartnerCount(), ShouldEqual, 0)
+			})
+			Convey("Merging into a descendant should panic", func() {
+				So(func() { dup.Merge(child) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPartnerCategorySearch(t *testing.T) {
+	Convey("Testing Partner search by category", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			eu := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("EU"))
+			germany := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Germany").SetParent(eu))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Dieter").
+				SetCategories(germany))
+			Convey("Searching by parent category finds partners with descendant categories", func() {
+				So(eu.WithDescendants().Len(), ShouldEqual, 2)
+				res := h.Partner().Search(env, q.Partner().Categories().Equals(eu))
+				So(res.Equals(partner), ShouldBeTrue)
+			})
+			Convey("Descendant search can be disabled by context", func() {
+				res := h.Partner().NewSet(env).WithContext("category_search_descendants", false).
+					Search(q.Partner().Categories().Equals(eu))
+				So(res.IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Descendant search can be disabled by config parameter", func() {
+				So(partner.SearchCategoriesWithDescendants(), ShouldBeTrue)
+				h.ConfigParameter().NewSet(env).SetParam("base.partner_category_search_descendants", "false")
+				So(partner.SearchCategoriesWithDescendants(), ShouldBeFalse)
+				So(h.Partner().Search(env, q.Partner().Categories().Equals(eu)).IsEmpty(), ShouldBeTrue)
+			})
 		}), ShouldBeNil)
 	})
 }
@@ -599,3 +776,174 @@ func TestParentStore(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestPartnerTimezone(t *testing.T) {
+	Convey("Testing partner timezone suggestion", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			us := h.Country().Search(env, q.Country().Code().Equals("US"))
+			california := h.CountryState().Search(env, q.CountryState().Code().Equals("CA").And().Country().Equals(us))
+			Convey("Timezone is inferred from the country", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("French Partner").
+					SetCountry(h.Country().Search(env, q.Country().Code().Equals("FR"))))
+				So(partner.TZ(), ShouldEqual, "Europe/Paris")
+			})
+			Convey("Multi-zone countries get their primary timezone", func() {
+				for code, tz := range map[string]string{
+					"AU": "Australia/Sydney",
+					"BR": "America/Sao_Paulo",
+					"CA": "America/Toronto",
+					"RU": "Europe/Moscow",
+				} {
+					So(suggestTimezone(h.Country().Search(env, q.Country().Code().Equals(code)), h.CountryState().NewSet(env)), ShouldEqual, tz)
+				}
+			})
+			Convey("Timezone is inferred from the state when available", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Californian Partner").
+					SetCountry(us).
+					SetState(california))
+				So(partner.TZ(), ShouldEqual, "America/Los_Angeles")
+			})
+			Convey("Given timezone is kept", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Remote Partner").
+					SetCountry(us).
+					SetTZ("Europe/Paris"))
+				So(partner.TZ(), ShouldEqual, "Europe/Paris")
+				So(partner.SuggestTimezone(), ShouldEqual, "America/New_York")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPartnerDisplayAddress(t *testing.T) {
+	Convey("Testing partner address display modes", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("<b>Bold</b> & Co").
+				SetStreet("1 <script>alert(1)</script> Street").
+				SetZip("12345").
+				SetCity("Springfield"))
+			Convey("Plain mode keeps data untouched", func() {
+				So(partner.DisplayAddressMode(AddressModePlain, true), ShouldEqual, partner.DisplayAddress(true))
+				So(partner.DisplayAddress(true), ShouldContainSubstring, "<script>")
+			})
+			Convey("HTML mode escapes data", func() {
+				addr := partner.DisplayAddressMode(AddressModeHTML, true)
+				So(addr, ShouldNotContainSubstring, "<script>")
+				So(addr, ShouldStartWith, "1 &lt;script&gt;alert(1)&lt;/script&gt; Street<br/>")
+				So(addr, ShouldNotContainSubstring, "<br/><br/>")
+			})
+			Convey("One line mode joins non empty lines", func() {
+				So(partner.DisplayAddressMode(AddressModeOneLine, true), ShouldEqual,
+					"1 <script>alert(1)</script> Street, Springfield 12345")
+			})
+			Convey("NameGet escapes names in HTML format", func() {
+				name := partner.WithContext("html_format", true).WithContext("show_address", true).NameGet()
+				So(name, ShouldStartWith, "&lt;b&gt;Bold&lt;/b&gt; &amp; Co<br/>1 &lt;script&gt;")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestAddressTemplateFuncs(t *testing.T) {
+	Convey("Testing address format template functions", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			country := h.Country().Create(env, h.Country().NewData().
+				SetName("Templateland").
+				SetCode("XT").
+				SetAddressFormat(`{{ .Street }}
+{{ .Street2 }}
+{{ join " " (upper .City) (prefix "ZIP " .Zip) }}
+{{ stateOrCountry .StateName .CountryName | truncate 8 }}`))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Template Partner").
+				SetStreet("1 Main Street").
+				SetCity("Capital").
+				SetCountry(country))
+			Convey("Functions are applied and blank lines skipped", func() {
+				So(partner.DisplayAddress(true), ShouldEqual, "1 Main Street\nCAPITAL\nTemplate")
+			})
+			Convey("Registered functions are available", func() {
+				So(TemplateFuncs(), ShouldContainKey, "stateOrCountry")
+				So(func() { RegisterTemplateFunc("upper", strings.ToUpper) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPartnerNameWithTitle(t *testing.T) {
+	Convey("Testing partner names with titles", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			doctor := h.PartnerTitle().Create(env, h.PartnerTitle().NewData().
+				SetName("Doctor Test").
+				SetShortcut("Dr."))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("John Watson").
+				SetTitle(doctor))
+			Convey("Abbreviated title is placed before the name by default", func() {
+				So(partner.NameWithTitle(), ShouldEqual, "Dr. John Watson")
+				So(partner.FormatNameWithTitle(false), ShouldEqual, "Doctor Test John Watson")
+			})
+			Convey("Title position can be forced", func() {
+				doctor.SetPosition("after")
+				So(partner.FormatNameWithTitle(true), ShouldEqual, "John Watson Dr.")
+			})
+			Convey("Partners without title or companies keep their name", func() {
+				partner.SetTitle(h.PartnerTitle().NewSet(env))
+				So(partner.NameWithTitle(), ShouldEqual, "John Watson")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestMonetaryFormat(t *testing.T) {
+	Convey("Testing monetary formatting", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			eur := h.Currency().Search(env, q.Currency().Name().Equals("EUR"))
+			usd := h.Currency().NewSet(env).WithContext("active_test", false).Search(q.Currency().Name().Equals("USD"))
+			Convey("Amounts are formatted according to the language", func() {
+				So(eur.FormatMonetary(1234567.456, "fr_FR"), ShouldEqual, "1 234 567,46\u00a0€")
+				So(eur.FormatMonetary(-1234.5, "en_US"), ShouldEqual, "-1,234.50\u00a0€")
+			})
+			Convey("Symbol is placed according to the currency", func() {
+				So(usd.FormatMonetary(1234.5, "en_US"), ShouldEqual, "$\u00a01,234.50")
+			})
+			Convey("Companies format in their currency by default", func() {
+				company := h.User().NewSet(env).CurrentUser().Company()
+				So(company.FormatMonetary(10, h.Currency().NewSet(env), "en_US"),
+					ShouldEqual, company.Currency().FormatMonetary(10, "en_US"))
+				So(company.FormatMonetary(10, usd, "en_US"), ShouldEqual, "$\u00a010.00")
+			})
+			Convey("Numbers are grouped according to the language grouping", func() {
+				So(h.Lang().NewSet(env).FormatNumber(106500, 0, true), ShouldEqual, "106,500")
+				So(h.Lang().NewSet(env).FormatNumber(106500, 0, false), ShouldEqual, "106500")
+			})
+		}), ShouldBeNil)
+	})
+}
+
+func TestPartnerCompanyConsistency(t *testing.T) {
+	Convey("Testing partner and user company consistency", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			user := h.User().Create(env, h.User().NewData().
+				SetName("Consistency User").
+				SetLogin("consistency_user"))
+			other := h.Company().Create(env, h.Company().NewData().SetName("Consistency Company"))
+			partners := user.Partner().Union(h.Partner().Create(env, h.Partner().NewData().SetName("Free Partner")))
+			Convey("Partners can get the company of their users", func() {
+				So(partnerUsersCompanyConflicts(partners, user.Company()), ShouldBeNil)
+				So(func() { partners.SetCompany(user.Company()) }, ShouldNotPanic)
+			})
+			Convey("Conflicting users and companies are listed", func() {
+				err := partnerUsersCompanyConflicts(partners, other)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "Consistency Company")
+				So(err.Error(), ShouldContainSubstring, fmt.Sprintf("Consistency User (%s)", user.Company().Name()))
+				So(func() { partners.SetCompany(other) }, ShouldPanic)
+				So(user.Partner().Company().Equals(other), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
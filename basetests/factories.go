@@ -0,0 +1,95 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+// Package basetests provides factory functions creating consistent fixtures
+// of base models, to be used in the tests of modules depending on base.
+//
+// Factories must be called inside a test environment, e.g. in
+// models.SimulateInNewEnvironment. Names and logins are made unique, so that
+// factories can be called several times in the same environment.
+package basetests
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	base "github.com/erlangs/hexya-base"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// sequence is incremented to make the names of created records unique
+var sequence int64
+
+// nextSequence returns a new unique number
+func nextSequence() int64 {
+	return atomic.AddInt64(&sequence, 1)
+}
+
+// NewTestPartner creates a partner with the given values. Name and Email
+// are set to unique values if not given. vals may be nil.
+//
+// The partner is created without address validation nor Gravatar lookup, so
+// that tests do not depend on the network.
+func NewTestPartner(env models.Environment, vals m.PartnerData) m.PartnerSet {
+	if vals == nil {
+		vals = h.Partner().NewData()
+	}
+	n := nextSequence()
+	if vals.Name() == "" {
+		vals.SetName(fmt.Sprintf("Test Partner %d", n))
+	}
+	if !vals.HasEmail() {
+		vals.SetEmail(fmt.Sprintf("test.partner.%d@example.com", n))
+	}
+	return h.Partner().NewSet(env).
+		WithContext(base.ContextKeySkipAddressValidation, true).
+		WithContext(base.ContextKeyNoGravatar, true).
+		Create(vals)
+}
+
+// NewTestCompanyWithUsers creates a company with the given name and nbUsers
+// employees. Users work in this company only and belong to the Employee
+// group. The company currency is EUR.
+func NewTestCompanyWithUsers(env models.Environment, name string, nbUsers int) (m.CompanySet, m.UserSet) {
+	n := nextSequence()
+	if name == "" {
+		name = fmt.Sprintf("Test Company %d", n)
+	}
+	company := h.Company().Create(env, h.Company().NewData().
+		SetName(name).
+		SetCurrency(h.Currency().Search(env, q.Currency().Name().Equals("EUR"))))
+	employees := h.Group().Search(env, q.Group().GroupID().Equals(base.GroupUser.ID()))
+	users := h.User().NewSet(env)
+	for i := 1; i <= nbUsers; i++ {
+		user := h.User().Create(env, h.User().NewData().
+			SetName(fmt.Sprintf("%s User %d", name, i)).
+			SetLogin(fmt.Sprintf("test_user_%d_%d", n, i)).
+			SetEmail(fmt.Sprintf("test.user.%d.%d@example.com", n, i)).
+			SetCompany(company).
+			SetCompanies(company).
+			SetGroups(employees))
+		users = users.Union(user)
+	}
+	return company, users
+}
+
+// NewTestCurrencyRate creates a rate for the currency with the given ISO
+// code at the given date, for all companies. The currency is activated if
+// needed. It panics if the currency does not exist.
+func NewTestCurrencyRate(env models.Environment, code string, date dates.DateTime, rate float64) m.CurrencyRateSet {
+	currency := h.Currency().NewSet(env).WithContext("active_test", false).Search(q.Currency().Name().Equals(code))
+	if currency.IsEmpty() {
+		panic(fmt.Errorf("unknown currency %s", code))
+	}
+	if !currency.Active() {
+		currency.SetActive(true)
+	}
+	return h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+		SetCurrency(currency).
+		SetName(date).
+		SetRate(rate))
+}
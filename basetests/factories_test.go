@@ -0,0 +1,52 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetests
+
+import (
+	"testing"
+
+	base "github.com/erlangs/hexya-base"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/okoo/src/tests"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMain(m *testing.M) {
+	tests.RunTests(m, "base", nil)
+}
+
+func TestFactories(t *testing.T) {
+	Convey("Testing test data factories", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Partners get unique names and emails", func() {
+				p1 := NewTestPartner(env, nil)
+				p2 := NewTestPartner(env, h.Partner().NewData().SetName("Named Partner"))
+				So(p1.Name(), ShouldNotEqual, p2.Name())
+				So(p1.Email(), ShouldNotEqual, p2.Email())
+				So(p2.Name(), ShouldEqual, "Named Partner")
+			})
+			Convey("Companies are created with their employees", func() {
+				company, users := NewTestCompanyWithUsers(env, "", 3)
+				So(users.Len(), ShouldEqual, 3)
+				for _, user := range users.Records() {
+					So(user.Company().Equals(company), ShouldBeTrue)
+					So(user.HasGroup(base.GroupUser.ID()), ShouldBeTrue)
+				}
+				So(company.Currency().Name(), ShouldEqual, "EUR")
+				_, others := NewTestCompanyWithUsers(env, "", 1)
+				So(others.Login(), ShouldNotBeIn, []string{users.Records()[0].Login(), users.Records()[1].Login()})
+			})
+			Convey("Currency rates activate their currency", func() {
+				rate := NewTestCurrencyRate(env, "USD", dates.Now(), 1.25)
+				So(rate.Currency().Name(), ShouldEqual, "USD")
+				So(rate.Currency().Active(), ShouldBeTrue)
+				So(rate.Rate(), ShouldEqual, 1.25)
+				So(func() { NewTestCurrencyRate(env, "XXZ", dates.Now(), 1) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
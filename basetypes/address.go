@@ -0,0 +1,26 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"github.com/erlangs/okoo/src/models"
+)
+
+// An AddressValidationResult is the outcome of the validation of an address
+type AddressValidationResult struct {
+	// Address is the normalized address
+	Address AddressData
+	// Valid is true if the address has been verified
+	Valid bool
+	// Message explains why the address is invalid
+	Message string
+}
+
+// An AddressValidator checks and normalizes partner addresses.
+type AddressValidator interface {
+	// Validate checks the given address and returns its normalized version.
+	// It returns an error only if the validation could not be performed,
+	// not if the address is invalid.
+	Validate(env models.Environment, address AddressData) (AddressValidationResult, error)
+}
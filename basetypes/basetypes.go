@@ -22,3 +22,9 @@ type AddressData struct {
 
 // A ConfigFieldsMap is a map between fields of ConfigSettings and a ConfigParameter key.
 type ConfigFieldsMap map[*models.Field]string
+
+// A RecordName is the ID and display name of a record, as returned by NameGetMulti
+type RecordName struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
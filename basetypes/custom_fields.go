@@ -0,0 +1,59 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// CustomFieldValues holds the values of the custom fields of a record,
+// keyed by custom field name. It is stored as a JSON object in the database.
+type CustomFieldValues map[string]interface{}
+
+// Value JSON encodes the values for storing in the database
+func (c CustomFieldValues) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return "{}", nil
+	}
+	res, err := json.Marshal(map[string]interface{}(c))
+	if err != nil {
+		return nil, err
+	}
+	return string(res), nil
+}
+
+// Scan decodes the JSON object read from the database
+func (c *CustomFieldValues) Scan(src interface{}) error {
+	var data []byte
+	switch s := src.(type) {
+	case nil:
+		*c = CustomFieldValues{}
+		return nil
+	case string:
+		data = []byte(s)
+	case []byte:
+		data = s
+	default:
+		return fmt.Errorf("unable to scan custom field values from %T", src)
+	}
+	res := make(map[string]interface{})
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &res); err != nil {
+			return err
+		}
+	}
+	*c = res
+	return nil
+}
+
+// Copy returns a copy of these values
+func (c CustomFieldValues) Copy() CustomFieldValues {
+	res := make(CustomFieldValues, len(c))
+	for k, v := range c {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DKIMSignedHeaders are the headers signed by DKIMSign when they are
+// present in the message.
+var DKIMSignedHeaders = []string{"From", "To", "Cc", "Subject", "Date", "Reply-To", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"}
+
+// DKIMSign signs the given RFC 5322 message for the given domain and selector
+// as defined by RFC 6376 with the rsa-sha256 algorithm and the relaxed/relaxed
+// canonicalization. It returns the message with its DKIM-Signature header.
+func DKIMSign(message []byte, domain, selector string, key *rsa.PrivateKey, now time.Time) ([]byte, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("DKIM domain and selector are required")
+	}
+	header, body := splitMessage(message)
+	headers := parseHeaders(header)
+	var (
+		signed []string
+		canon  bytes.Buffer
+	)
+	for _, name := range DKIMSignedHeaders {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if strings.EqualFold(headers[i][0], name) {
+				signed = append(signed, name)
+				canon.WriteString(dkimRelaxedHeader(headers[i][0], headers[i][1]))
+				break
+			}
+		}
+	}
+	if len(signed) == 0 || !strings.EqualFold(signed[0], "From") {
+		return nil, errors.New("DKIM signed messages must have a From header")
+	}
+	bodyHash := sha256.Sum256(DKIMRelaxedBody(body))
+	value := fmt.Sprintf("v=1; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		domain, selector, now.Unix(), strings.Join(signed, ":"), base64.StdEncoding.EncodeToString(bodyHash[:]))
+	canon.WriteString(strings.TrimSuffix(dkimRelaxedHeader("DKIM-Signature", value), "\r\n"))
+	hashed := sha256.Sum256(canon.Bytes())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+	var res bytes.Buffer
+	res.WriteString("DKIM-Signature: ")
+	res.WriteString(value)
+	res.WriteString(base64.StdEncoding.EncodeToString(sig))
+	res.WriteString("\r\n")
+	res.Write(message)
+	return res.Bytes(), nil
+}
+
+// splitMessage returns the header and the body of the given message
+func splitMessage(message []byte) ([]byte, []byte) {
+	if i := bytes.Index(message, []byte("\r\n\r\n")); i >= 0 {
+		return message[:i+2], message[i+4:]
+	}
+	return message, nil
+}
+
+// parseHeaders returns the name and the unfolded value of each header field
+// of the given message header, in order.
+func parseHeaders(header []byte) [][2]string {
+	var res [][2]string
+	for _, line := range strings.Split(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(res) > 0 {
+			res[len(res)-1][1] += line
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		res = append(res, [2]string{parts[0], parts[1]})
+	}
+	return res
+}
+
+// dkimRelaxedHeader returns the relaxed canonicalization of the given header field
+func dkimRelaxedHeader(name, value string) string {
+	return fmt.Sprintf("%s:%s\r\n", strings.ToLower(strings.TrimSpace(name)), strings.Join(strings.Fields(value), " "))
+}
+
+// DKIMRelaxedBody returns the relaxed canonicalization of the given message body
+func DKIMRelaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		var buf strings.Builder
+		space := false
+		for _, r := range line {
+			if r == ' ' || r == '\t' {
+				space = true
+				continue
+			}
+			if space {
+				buf.WriteByte(' ')
+				space = false
+			}
+			buf.WriteRune(r)
+		}
+		lines[i] = buf.String()
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// ParseDKIMPrivateKey returns the RSA private key of the given PEM block,
+// in PKCS #1 or PKCS #8 form.
+func ParseDKIMPrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("DKIM keys must be RSA keys")
+	}
+	return rsaKey, nil
+}
+
+// NewDKIMPrivateKey generates a new RSA private key of the given size and
+// returns it as a PKCS #1 PEM block.
+func NewDKIMPrivateKey(bits int) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+}
+
+// DKIMDNSRecord returns the value of the TXT record to publish at
+// <selector>._domainkey.<domain> for the given key.
+func DKIMDNSRecord(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der), nil
+}
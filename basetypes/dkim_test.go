@@ -0,0 +1,84 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDKIM(t *testing.T) {
+	Convey("Testing DKIM signing", t, func() {
+		pemKey, err := NewDKIMPrivateKey(1024)
+		So(err, ShouldBeNil)
+		key, err := ParseDKIMPrivateKey(pemKey)
+		So(err, ShouldBeNil)
+		Convey("Canonicalization follows RFC 6376 examples", func() {
+			So(dkimRelaxedHeader("A", " X"), ShouldEqual, "a:X\r\n")
+			So(dkimRelaxedHeader("B ", " Y\t\t Z  "), ShouldEqual, "b:Y Z\r\n")
+			So(string(DKIMRelaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))), ShouldEqual, " C\r\nD E\r\n")
+			So(DKIMRelaxedBody([]byte("\r\n\r\n")), ShouldBeEmpty)
+		})
+		Convey("Folded headers are unfolded", func() {
+			headers := parseHeaders([]byte("Subject: a\r\n long subject\r\nTo: x@example.com\r\n"))
+			So(headers, ShouldHaveLength, 2)
+			So(dkimRelaxedHeader(headers[0][0], headers[0][1]), ShouldEqual, "subject:a long subject\r\n")
+		})
+		Convey("Signed messages can be verified with the DNS record key", func() {
+			email := OutgoingEmail{
+				From:    "sender@example.com",
+				To:      []string{"rcpt@example.org"},
+				Subject: "Signed",
+				Body:    "<p>Hello</p>",
+			}
+			signed, err := DKIMSign(email.Bytes(), "example.com", "hexya", key, time.Unix(1600000000, 0))
+			So(err, ShouldBeNil)
+			So(string(signed), ShouldStartWith, "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/relaxed; d=example.com; s=hexya; t=1600000000; h=From:To:Subject:Date:")
+			header, body := splitMessage(signed)
+			headers := parseHeaders(header)
+			So(headers[0][0], ShouldEqual, "DKIM-Signature")
+			value := headers[0][1]
+			tags := make(map[string]string)
+			for _, tag := range strings.Split(value, ";") {
+				parts := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+				tags[parts[0]] = parts[1]
+			}
+			bodyHash := sha256.Sum256(DKIMRelaxedBody(body))
+			So(tags["bh"], ShouldEqual, base64.StdEncoding.EncodeToString(bodyHash[:]))
+			var canon strings.Builder
+			for _, name := range strings.Split(tags["h"], ":") {
+				for i := len(headers) - 1; i > 0; i-- {
+					if strings.EqualFold(headers[i][0], name) {
+						canon.WriteString(dkimRelaxedHeader(headers[i][0], headers[i][1]))
+						break
+					}
+				}
+			}
+			canon.WriteString(strings.TrimSuffix(dkimRelaxedHeader("DKIM-Signature",
+				strings.TrimSuffix(value, tags["b"])), "\r\n"))
+			sig, err := base64.StdEncoding.DecodeString(tags["b"])
+			So(err, ShouldBeNil)
+			hashed := sha256.Sum256([]byte(canon.String()))
+			So(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], sig), ShouldBeNil)
+			record, err := DKIMDNSRecord(&key.PublicKey)
+			So(err, ShouldBeNil)
+			So(record, ShouldStartWith, "v=DKIM1; k=rsa; p=")
+		})
+		Convey("Messages without sender or domain are not signed", func() {
+			_, err := DKIMSign([]byte("To: x@example.com\r\n\r\nbody"), "example.com", "hexya", key, time.Now())
+			So(err, ShouldNotBeNil)
+			_, err = DKIMSign([]byte("From: x@example.com\r\n\r\nbody"), "", "hexya", key, time.Now())
+			So(err, ShouldNotBeNil)
+			_, err = ParseDKIMPrivateKey("not a key")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
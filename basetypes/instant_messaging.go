@@ -0,0 +1,23 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+// An OutgoingIM is an instant message to be sent through an instant messaging channel
+type OutgoingIM struct {
+	// Reference is the unique reference of the message in the database
+	Reference string
+	// Phone is the recipient's phone number in E.164 format
+	Phone string
+	Body  string
+}
+
+// An IncomingIM is an instant message received by an instant messaging channel webhook
+type IncomingIM struct {
+	// Phone is the sender's phone number, preferably in E.164 format
+	Phone string
+	// SenderName is the display name of the sender on the messaging service
+	SenderName  string
+	Body        string
+	ProviderRef string
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An OutgoingEmail is an email to be sent by a MailSender
+type OutgoingEmail struct {
+	From    string
+	To      []string
+	Subject string
+	// Body is the HTML body of the email
+	Body string
+	// Headers are additional headers of the email
+	Headers map[string]string
+}
+
+// Bytes returns the RFC 5322 message of this email
+func (e OutgoingEmail) Bytes() []byte {
+	var buf bytes.Buffer
+	headers := map[string]string{
+		"From":                      e.From,
+		"To":                        strings.Join(e.To, ", "),
+		"Subject":                   mime.QEncoding.Encode("utf-8", e.Subject),
+		"Date":                      time.Now().Format(time.RFC1123Z),
+		"MIME-Version":              "1.0",
+		"Content-Type":              `text/html; charset="utf-8"`,
+		"Content-Transfer-Encoding": "quoted-printable",
+	}
+	for key, value := range e.Headers {
+		headers[key] = value
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, headers[key])
+	}
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(e.Body))
+	qp.Close()
+	return buf.Bytes()
+}
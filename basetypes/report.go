@@ -0,0 +1,26 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+// A ReportLayout holds the company data displayed in the header and the
+// footer of printed documents.
+type ReportLayout struct {
+	CompanyName  string
+	Logo         string
+	AddressLines []string
+	Phone        string
+	Email        string
+	Website      string
+	// LegalMentions are the company registry and tax identification
+	// numbers, formatted with their label, e.g. "TIN: BE0477472701"
+	LegalMentions []string
+	// SocialMedia maps the name of social networks to the company's page URL
+	SocialMedia map[string]string
+	// Header is the HTML tagline displayed below the company logo
+	Header string
+	// Footer is the HTML footer of the documents
+	Footer string
+	// PrintDate is the printing date and time in the timezone of the user
+	PrintDate string
+}
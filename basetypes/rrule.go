@@ -0,0 +1,459 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRuleMaxPeriods is the maximum number of periods that are walked through
+// without finding any occurrence before giving up the expansion of a rule.
+// This prevents infinite loops on rules that can never match (e.g. the 31st
+// of February). For SECONDLY, MINUTELY and HOURLY rules, whose BYxxx parts
+// only filter days, empty days are skipped at once and count as one period.
+const RRuleMaxPeriods = 10000
+
+// RRule frequencies
+const (
+	RRuleSecondly = "SECONDLY"
+	RRuleMinutely = "MINUTELY"
+	RRuleHourly   = "HOURLY"
+	RRuleDaily    = "DAILY"
+	RRuleWeekly   = "WEEKLY"
+	RRuleMonthly  = "MONTHLY"
+	RRuleYearly   = "YEARLY"
+)
+
+// rruleSubDailyUnits are the durations of the periods of sub-daily frequencies
+var rruleSubDailyUnits = map[string]time.Duration{
+	RRuleSecondly: time.Second,
+	RRuleMinutely: time.Minute,
+	RRuleHourly:   time.Hour,
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// An RRuleWeekday is a weekday of a BYDAY rule part, optionally
+// prefixed by its ordinal in the month or year (e.g. -1FR is the last Friday).
+type RRuleWeekday struct {
+	Weekday time.Weekday
+	N       int
+}
+
+// An RRule is a recurrence rule as defined by RFC 5545.
+//
+// The following rule parts are supported: FREQ, INTERVAL, COUNT, UNTIL,
+// BYDAY, BYMONTHDAY, BYMONTH and WKST.
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []RRuleWeekday
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+// ParseRRule parses the given RFC 5545 RRULE string, with or
+// without the "RRULE:" prefix (e.g. "FREQ=MONTHLY;BYDAY=-1FR;COUNT=6").
+func ParseRRule(rule string) (*RRule, error) {
+	rule = strings.TrimPrefix(strings.TrimSpace(rule), "RRULE:")
+	res := RRule{
+		Interval:  1,
+		WeekStart: time.Monday,
+	}
+	for _, part := range strings.Split(rule, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rule part '%s'", part)
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case RRuleSecondly, RRuleMinutely, RRuleHourly, RRuleDaily, RRuleWeekly, RRuleMonthly, RRuleYearly:
+				res.Freq = value
+			default:
+				return nil, fmt.Errorf("invalid frequency '%s'", value)
+			}
+		case "INTERVAL":
+			res.Interval, err = strconv.Atoi(value)
+			if err == nil && res.Interval < 1 {
+				err = errors.New("interval must be strictly positive")
+			}
+		case "COUNT":
+			res.Count, err = strconv.Atoi(value)
+			if err == nil && res.Count < 1 {
+				err = errors.New("count must be strictly positive")
+			}
+		case "UNTIL":
+			res.Until, err = parseRRuleTime(value)
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				var wd RRuleWeekday
+				wd, err = parseRRuleWeekday(day)
+				if err != nil {
+					break
+				}
+				res.ByDay = append(res.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				var md int
+				md, err = strconv.Atoi(day)
+				if err == nil && (md == 0 || md < -31 || md > 31) {
+					err = fmt.Errorf("invalid month day %d", md)
+				}
+				if err != nil {
+					break
+				}
+				res.ByMonthDay = append(res.ByMonthDay, md)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(value, ",") {
+				var mo int
+				mo, err = strconv.Atoi(month)
+				if err == nil && (mo < 1 || mo > 12) {
+					err = fmt.Errorf("invalid month %d", mo)
+				}
+				if err != nil {
+					break
+				}
+				res.ByMonth = append(res.ByMonth, time.Month(mo))
+			}
+		case "WKST":
+			wd, ok := rruleWeekdays[value]
+			if !ok {
+				err = fmt.Errorf("invalid week start '%s'", value)
+			}
+			res.WeekStart = wd
+		default:
+			err = fmt.Errorf("unsupported rule part '%s'", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse RRULE '%s': %s", rule, err)
+		}
+	}
+	if res.Freq == "" {
+		return nil, fmt.Errorf("unable to parse RRULE '%s': FREQ is required", rule)
+	}
+	if res.Count > 0 && !res.Until.IsZero() {
+		return nil, fmt.Errorf("unable to parse RRULE '%s': COUNT and UNTIL are mutually exclusive", rule)
+	}
+	return &res, nil
+}
+
+// parseRRuleTime parses an RFC 5545 DATE or DATE-TIME value
+func parseRRuleTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s'", value)
+}
+
+// parseRRuleWeekday parses a BYDAY item such as "MO", "2TU" or "-1FR"
+func parseRRuleWeekday(value string) (RRuleWeekday, error) {
+	if len(value) < 2 {
+		return RRuleWeekday{}, fmt.Errorf("invalid week day '%s'", value)
+	}
+	wd, ok := rruleWeekdays[value[len(value)-2:]]
+	if !ok {
+		return RRuleWeekday{}, fmt.Errorf("invalid week day '%s'", value)
+	}
+	res := RRuleWeekday{Weekday: wd}
+	if prefix := value[:len(value)-2]; prefix != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(prefix, "+"))
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return RRuleWeekday{}, fmt.Errorf("invalid week day '%s'", value)
+		}
+		res.N = n
+	}
+	return res, nil
+}
+
+// String returns the RFC 5545 representation of this rule
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = strings.ToUpper(wd.Weekday.String()[:2])
+			if wd.N != 0 {
+				days[i] = strconv.Itoa(wd.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, md := range r.ByMonthDay {
+			days[i] = strconv.Itoa(md)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]string, len(r.ByMonth))
+		for i, mo := range r.ByMonth {
+			months[i] = strconv.Itoa(int(mo))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+strings.ToUpper(r.WeekStart.String()[:2]))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Iterate calls fnct with each occurrence of this rule starting at dtstart,
+// in chronological order, until fnct returns false or the rule is exhausted.
+//
+// As per RFC 5545, dtstart is always the first occurrence and counts
+// towards COUNT, even if it does not match the rule.
+func (r *RRule) Iterate(dtstart time.Time, fnct func(time.Time) bool) {
+	var count, empty int
+	emit := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		count++
+		if !fnct(t) {
+			return false
+		}
+		return r.Count == 0 || count < r.Count
+	}
+	if !emit(dtstart) {
+		return
+	}
+	for period := 0; empty < RRuleMaxPeriods; period++ {
+		candidates := r.periodCandidates(dtstart, period)
+		if len(candidates) == 0 {
+			empty++
+			period += r.sameDayPeriods(dtstart, period)
+			continue
+		}
+		empty = 0
+		for _, c := range candidates {
+			if !c.After(dtstart) {
+				continue
+			}
+			if !emit(c) {
+				return
+			}
+		}
+		if !r.Until.IsZero() && candidates[0].After(r.Until) {
+			return
+		}
+	}
+}
+
+// sameDayPeriods returns the number of periods following the given period
+// that fall on the same day. It is always 0 for DAILY and longer frequencies.
+func (r *RRule) sameDayPeriods(dtstart time.Time, period int) int {
+	unit, ok := rruleSubDailyUnits[r.Freq]
+	if !ok {
+		return 0
+	}
+	step := time.Duration(r.Interval) * unit
+	t := dtstart.Add(time.Duration(period) * step)
+	nextDay := time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	return int((nextDay.Sub(t) - 1) / step)
+}
+
+// periodCandidates returns the sorted occurrences of this rule in the
+// given period, the period 0 being the one that contains dtstart.
+func (r *RRule) periodCandidates(dtstart time.Time, period int) []time.Time {
+	n := period * r.Interval
+	var res []time.Time
+	switch r.Freq {
+	case RRuleSecondly, RRuleMinutely, RRuleHourly:
+		if t := dtstart.Add(time.Duration(n) * rruleSubDailyUnits[r.Freq]); r.matchDay(t) {
+			res = append(res, t)
+		}
+	case RRuleDaily:
+		if t := dtstart.AddDate(0, 0, n); r.matchDay(t) {
+			res = append(res, t)
+		}
+	case RRuleWeekly:
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := dtstart.AddDate(0, 0, 7*n-offset)
+		for i := 0; i < 7; i++ {
+			t := weekStart.AddDate(0, 0, i)
+			if len(r.ByMonth) > 0 && !r.inMonths(t.Month()) {
+				continue
+			}
+			if len(r.ByDay) == 0 && t.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if len(r.ByDay) > 0 && !r.matchWeekday(t, nil) {
+				continue
+			}
+			res = append(res, t)
+		}
+	case RRuleMonthly:
+		first := time.Date(dtstart.Year(), dtstart.Month()+time.Month(n), 1,
+			dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+		if len(r.ByMonth) > 0 && !r.inMonths(first.Month()) {
+			return nil
+		}
+		res = r.monthCandidates(dtstart, first)
+	case RRuleYearly:
+		year := dtstart.Year() + n
+		months := r.ByMonth
+		if len(months) == 0 {
+			switch {
+			case len(r.ByMonthDay) > 0:
+				months = []time.Month{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			case len(r.ByDay) > 0:
+				return r.yearWeekdayCandidates(dtstart, year)
+			default:
+				months = []time.Month{dtstart.Month()}
+			}
+		}
+		for _, month := range months {
+			first := time.Date(year, month, 1,
+				dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+			res = append(res, r.monthCandidates(dtstart, first)...)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Before(res[j])
+	})
+	return res
+}
+
+// monthCandidates returns the occurrences of this rule in the month starting at first
+func (r *RRule) monthCandidates(dtstart, first time.Time) []time.Time {
+	var res []time.Time
+	next := first.AddDate(0, 1, 0)
+	daysInMonth := next.AddDate(0, 0, -1).Day()
+	for t := first; t.Before(next); t = t.AddDate(0, 0, 1) {
+		switch {
+		case len(r.ByMonthDay) == 0 && len(r.ByDay) == 0:
+			if t.Day() != dtstart.Day() {
+				continue
+			}
+		case len(r.ByMonthDay) > 0 && !r.matchMonthDay(t.Day(), daysInMonth):
+			continue
+		case len(r.ByDay) > 0 && !r.matchWeekday(t, &first):
+			continue
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+// yearWeekdayCandidates returns the days of the given year matching BYDAY,
+// ordinals being relative to the year.
+func (r *RRule) yearWeekdayCandidates(dtstart time.Time, year int) []time.Time {
+	var res []time.Time
+	first := time.Date(year, 1, 1, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), 0, dtstart.Location())
+	next := first.AddDate(1, 0, 0)
+	for t := first; t.Before(next); t = t.AddDate(0, 0, 1) {
+		for _, wd := range r.ByDay {
+			if t.Weekday() == wd.Weekday && matchOrdinal(t, first, next, wd.N) {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// matchDay returns true if t matches the BYMONTH, BYMONTHDAY and BYDAY
+// rule parts, when used as filters (i.e. for frequencies of a day or less).
+func (r *RRule) matchDay(t time.Time) bool {
+	if len(r.ByMonth) > 0 && !r.inMonths(t.Month()) {
+		return false
+	}
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if len(r.ByMonthDay) > 0 && !r.matchMonthDay(t.Day(), daysInMonth) {
+		return false
+	}
+	if len(r.ByDay) > 0 && !r.matchWeekday(t, nil) {
+		return false
+	}
+	return true
+}
+
+// inMonths returns true if month is in BYMONTH
+func (r *RRule) inMonths(month time.Month) bool {
+	for _, mo := range r.ByMonth {
+		if mo == month {
+			return true
+		}
+	}
+	return false
+}
+
+// matchMonthDay returns true if day is in BYMONTHDAY, negative values
+// counting from the end of the month.
+func (r *RRule) matchMonthDay(day, daysInMonth int) bool {
+	for _, md := range r.ByMonthDay {
+		if md == day || (md < 0 && daysInMonth+md+1 == day) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWeekday returns true if t is in BYDAY. If monthStart is given,
+// ordinals are relative to this month, otherwise they are ignored.
+func (r *RRule) matchWeekday(t time.Time, monthStart *time.Time) bool {
+	for _, wd := range r.ByDay {
+		if t.Weekday() != wd.Weekday {
+			continue
+		}
+		if wd.N == 0 || monthStart == nil {
+			return true
+		}
+		if matchOrdinal(t, *monthStart, monthStart.AddDate(0, 1, 0), wd.N) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrdinal returns true if t is the n-th occurrence of its weekday
+// in the [start, end) interval. Negative n count from the end.
+func matchOrdinal(t, start, end time.Time, n int) bool {
+	if n == 0 {
+		return true
+	}
+	day := func(d time.Time) int {
+		return int(time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+	}
+	if n > 0 {
+		return (day(t)-day(start))/7+1 == n
+	}
+	return (day(end)-day(t)-1)/7+1 == -n
+}
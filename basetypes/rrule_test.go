@@ -0,0 +1,76 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func expandRRule(rule string, dtstart time.Time, max int) []string {
+	rr, err := ParseRRule(rule)
+	So(err, ShouldBeNil)
+	var res []string
+	rr.Iterate(dtstart, func(t time.Time) bool {
+		res = append(res, t.Format("2006-01-02"))
+		return len(res) < max
+	})
+	return res
+}
+
+func TestRRule(t *testing.T) {
+	Convey("Testing RRULE parsing and expansion", t, func() {
+		start := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC) // Monday
+		Convey("Invalid rules are rejected", func() {
+			for _, rule := range []string{"", "INTERVAL=2", "FREQ=FOO", "FREQ=DAILY;COUNT=0",
+				"FREQ=DAILY;BYDAY=XX", "FREQ=DAILY;COUNT=2;UNTIL=20250101", "FREQ=DAILY;BYMONTH=13"} {
+				_, err := ParseRRule(rule)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("Rules are serialized back to RFC 5545", func() {
+			rr, err := ParseRRule("RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR;COUNT=3")
+			So(err, ShouldBeNil)
+			So(rr.String(), ShouldEqual, "FREQ=MONTHLY;INTERVAL=2;COUNT=3;BYDAY=-1FR")
+		})
+		Convey("Sub-daily rules matching far away days", func() {
+			rr, err := ParseRRule("FREQ=MINUTELY;INTERVAL=30;BYMONTH=12;COUNT=3")
+			So(err, ShouldBeNil)
+			var res []string
+			rr.Iterate(start, func(t time.Time) bool {
+				res = append(res, t.Format("2006-01-02 15:04"))
+				return true
+			})
+			So(res, ShouldResemble, []string{"2025-01-06 09:00", "2025-12-01 00:00", "2025-12-01 00:30"})
+			So(expandRRule("FREQ=SECONDLY;BYMONTHDAY=29;BYMONTH=2;COUNT=2", start, 10), ShouldResemble,
+				[]string{"2025-01-06", "2028-02-29"})
+		})
+		Convey("Daily with count", func() {
+			So(expandRRule("FREQ=DAILY;INTERVAL=2;COUNT=3", start, 10), ShouldResemble,
+				[]string{"2025-01-06", "2025-01-08", "2025-01-10"})
+		})
+		Convey("Weekly on several days until a date", func() {
+			So(expandRRule("FREQ=WEEKLY;BYDAY=MO,WE,FR;UNTIL=20250115T235959Z", start, 10), ShouldResemble,
+				[]string{"2025-01-06", "2025-01-08", "2025-01-10", "2025-01-13", "2025-01-15"})
+		})
+		Convey("Monthly on the last Friday", func() {
+			So(expandRRule("FREQ=MONTHLY;BYDAY=-1FR", start, 4), ShouldResemble,
+				[]string{"2025-01-06", "2025-01-31", "2025-02-28", "2025-03-28"})
+		})
+		Convey("Monthly on the 31st skips short months", func() {
+			So(expandRRule("FREQ=MONTHLY;BYMONTHDAY=31", start, 4), ShouldResemble,
+				[]string{"2025-01-06", "2025-01-31", "2025-03-31", "2025-05-31"})
+		})
+		Convey("Yearly on the second Tuesday of March", func() {
+			So(expandRRule("FREQ=YEARLY;BYMONTH=3;BYDAY=2TU", start, 3), ShouldResemble,
+				[]string{"2025-01-06", "2025-03-11", "2026-03-10"})
+		})
+		Convey("Impossible rules terminate", func() {
+			So(expandRRule("FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", start, 3), ShouldResemble,
+				[]string{"2025-01-06"})
+		})
+	})
+}
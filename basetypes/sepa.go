@@ -0,0 +1,27 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package basetypes
+
+// A SEPAIdentity holds the ISO 20022 identification of the holder of a bank
+// account and of its bank, as needed in payment initiation (pain.001) files.
+type SEPAIdentity struct {
+	// Name of the account holder
+	Name string
+	// LEI is the Legal Entity Identifier of the account holder, if any
+	LEI string
+	// Country is the ISO 3166 code of the country of residence or incorporation of the holder
+	Country string
+	// AddressLines are the lines of the postal address of the holder
+	AddressLines []string
+	// IBAN of the account, or empty if the account number is not an IBAN
+	IBAN string
+	// AccountNumber is the sanitized account number, used when there is no IBAN
+	AccountNumber string
+	// BIC of the bank
+	BIC string
+	// ClearingSystem is the ISO 20022 clearing system identification code of the bank, e.g. "USABA"
+	ClearingSystem string
+	// ClearingMemberID is the identifier of the bank in its clearing system
+	ClearingMemberID string
+}
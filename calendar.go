@@ -0,0 +1,318 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// icsEscaper escapes text values of iCalendar properties
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// ICSLine returns the given iCalendar content line, folded at 75 octets
+// as required by RFC 5545 and terminated by CRLF.
+func ICSLine(name, value string) string {
+	line := name + ":" + value
+	var res strings.Builder
+	for len(line) > 75 {
+		cut := 75
+		// Do not split UTF-8 multi-byte characters
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		res.WriteString(line[:cut])
+		res.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	res.WriteString(line)
+	res.WriteString("\r\n")
+	return res.String()
+}
+
+// ICSText escapes the given string to be used as an iCalendar TEXT value
+func ICSText(value string) string {
+	return icsEscaper.Replace(value)
+}
+
+// icsCommonName returns the given name suitable for a CN parameter value
+func icsCommonName(name string) string {
+	return strings.Replace(name, `"`, "'", -1)
+}
+
+// ICSTimezoneYears is the number of years after the last event (or after the
+// current year if later) covered by the VTIMEZONE components of exported
+// calendars, so that recurring events keep their local time.
+const ICSTimezoneYears = 5
+
+// icsUTCOffset returns the given offset in seconds east of UTC in the
+// iCalendar UTC-OFFSET format (e.g. +0130).
+func icsUTCOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset%3600/60)
+}
+
+// icsObservance returns a STANDARD or DAYLIGHT component of a VTIMEZONE
+// for the offset change happening at t.
+func icsObservance(t time.Time, offsetFrom int) string {
+	name, offsetTo := t.Zone()
+	kind := "STANDARD"
+	if t.IsDST() {
+		kind = "DAYLIGHT"
+	}
+	var res strings.Builder
+	res.WriteString(ICSLine("BEGIN", kind))
+	res.WriteString(ICSLine("DTSTART", t.UTC().Add(time.Duration(offsetFrom)*time.Second).Format("20060102T150405")))
+	res.WriteString(ICSLine("TZOFFSETFROM", icsUTCOffset(offsetFrom)))
+	res.WriteString(ICSLine("TZOFFSETTO", icsUTCOffset(offsetTo)))
+	res.WriteString(ICSLine("TZNAME", ICSText(name)))
+	res.WriteString(ICSLine("END", kind))
+	return res.String()
+}
+
+// ICSTimezone returns the VTIMEZONE component of the given location, with
+// the offset in effect at from and all the offset changes until to.
+func ICSTimezone(loc *time.Location, from, to time.Time) string {
+	var res strings.Builder
+	res.WriteString(ICSLine("BEGIN", "VTIMEZONE"))
+	res.WriteString(ICSLine("TZID", loc.String()))
+	prev := from.In(loc)
+	_, prevOffset := prev.Zone()
+	res.WriteString(icsObservance(prev, prevOffset))
+	for prev.Before(to) {
+		next := prev.AddDate(0, 0, 1)
+		if _, offset := next.Zone(); offset != prevOffset {
+			// Find the exact transition time between prev and next
+			low, high := prev, next
+			for high.Sub(low) > time.Second {
+				mid := low.Add(high.Sub(low) / 2)
+				if _, o := mid.Zone(); o == prevOffset {
+					low = mid
+				} else {
+					high = mid
+				}
+			}
+			res.WriteString(icsObservance(high, prevOffset))
+			prevOffset = offset
+		}
+		prev = next
+	}
+	res.WriteString(ICSLine("END", "VTIMEZONE"))
+	return res.String()
+}
+
+// ICSCalendar wraps the given VEVENT components into a VCALENDAR object
+func ICSCalendar(name string, components ...string) string {
+	var res strings.Builder
+	res.WriteString(ICSLine("BEGIN", "VCALENDAR"))
+	res.WriteString(ICSLine("VERSION", "2.0"))
+	res.WriteString(ICSLine("PRODID", "-//Hexya//Hexya Calendar//EN"))
+	res.WriteString(ICSLine("CALSCALE", "GREGORIAN"))
+	if name != "" {
+		res.WriteString(ICSLine("X-WR-CALNAME", ICSText(name)))
+	}
+	for _, comp := range components {
+		res.WriteString(comp)
+	}
+	res.WriteString(ICSLine("END", "VCALENDAR"))
+	return res.String()
+}
+
+var fields_CalendarEvent = map[string]models.FieldDefinition{
+	"Name":        fields.Char{String: "Meeting Subject", Required: true},
+	"Description": fields.Text{},
+	"Location":    fields.Char{},
+	"Start": fields.DateTime{Required: true, Index: true, Constraint: h.CalendarEvent().Methods().CheckDates(),
+		Default: func(models.Environment) interface{} {
+			return dates.Now()
+		}},
+	"Stop": fields.DateTime{Required: true, Index: true, Constraint: h.CalendarEvent().Methods().CheckDates(),
+		Default: func(models.Environment) interface{} {
+			return dates.Now().Add(time.Hour)
+		}},
+	"AllDay": fields.Boolean{String: "All Day"},
+	"TZ": fields.Char{String: "Timezone",
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Partner().TZ()
+		},
+		Help: `Timezone in which the event takes place. Recurrences are expanded
+in this timezone so that occurrences keep the same local time across DST changes.`},
+	"User": fields.Many2One{String: "Organizer", RelationModel: h.User(),
+		OnChange: h.CalendarEvent().Methods().OnchangeUser(),
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		}},
+	"Attendees": fields.Many2Many{RelationModel: h.Partner(), JSON: "partner_ids"},
+	"Recurrence": fields.Many2One{RelationModel: h.RecurrenceRule(), OnDelete: models.SetNull,
+		Help: "If set, this event is repeated according to this recurrence rule, starting at the event's start."},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckDates checks that events do not stop before they start
+func calendarEvent_CheckDates(rs m.CalendarEventSet) {
+	for _, event := range rs.Records() {
+		if event.Stop().Lower(event.Start()) {
+			log.Panic(rs.T("The ending date of event '%s' cannot be earlier than its starting date.", event.Name()))
+		}
+	}
+}
+
+// OnchangeUser sets the timezone of the event to the organizer's one
+func calendarEvent_OnchangeUser(rs m.CalendarEventSet) m.CalendarEventData {
+	res := h.CalendarEvent().NewData()
+	if rs.User().IsNotEmpty() && rs.User().Partner().TZ() != "" {
+		res.SetTZ(rs.User().Partner().TZ())
+	}
+	return res
+}
+
+// TimeLocation returns the time location of this event, or UTC
+// if the event has no timezone or an invalid one.
+func calendarEvent_TimeLocation(rs m.CalendarEventSet) *time.Location {
+	rs.EnsureOne()
+	if rs.TZ() == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(rs.TZ())
+	if err != nil {
+		log.Warn("Invalid timezone on calendar event", "event", rs.ID(), "tz", rs.TZ(), "error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// Occurrences returns the start dates of the occurrences of this
+// event between start and end (both included).
+func calendarEvent_Occurrences(rs m.CalendarEventSet, start, end dates.DateTime) []dates.DateTime {
+	rs.EnsureOne()
+	if rs.Recurrence().IsEmpty() {
+		if rs.Start().Lower(start) || rs.Start().Greater(end) {
+			return nil
+		}
+		return []dates.DateTime{rs.Start()}
+	}
+	var res []dates.DateTime
+	rs.Recurrence().ParsedRule().Iterate(rs.Start().In(rs.TimeLocation()), func(t time.Time) bool {
+		if t.After(end.Time) {
+			return false
+		}
+		if !t.Before(start.Time) {
+			res = append(res, dates.DateTime{Time: t.UTC()})
+		}
+		return true
+	})
+	return res
+}
+
+// ICSEvent returns the VEVENT component of this event in iCalendar format
+func calendarEvent_ICSEvent(rs m.CalendarEventSet) string {
+	rs.EnsureOne()
+	loc := rs.TimeLocation()
+	var res strings.Builder
+	res.WriteString(ICSLine("BEGIN", "VEVENT"))
+	res.WriteString(ICSLine("UID", fmt.Sprintf("calendar-event-%d@hexya", rs.ID())))
+	res.WriteString(ICSLine("DTSTAMP", dates.Now().UTC().Format("20060102T150405Z")))
+	switch {
+	case rs.AllDay():
+		res.WriteString(ICSLine("DTSTART;VALUE=DATE", rs.Start().In(loc).Format("20060102")))
+		res.WriteString(ICSLine("DTEND;VALUE=DATE", rs.Stop().In(loc).AddDate(0, 0, 1).Format("20060102")))
+	case loc != time.UTC:
+		res.WriteString(ICSLine("DTSTART;TZID="+loc.String(), rs.Start().In(loc).Format("20060102T150405")))
+		res.WriteString(ICSLine("DTEND;TZID="+loc.String(), rs.Stop().In(loc).Format("20060102T150405")))
+	default:
+		res.WriteString(ICSLine("DTSTART", rs.Start().UTC().Format("20060102T150405Z")))
+		res.WriteString(ICSLine("DTEND", rs.Stop().UTC().Format("20060102T150405Z")))
+	}
+	res.WriteString(ICSLine("SUMMARY", ICSText(rs.Name())))
+	if rs.Description() != "" {
+		res.WriteString(ICSLine("DESCRIPTION", ICSText(rs.Description())))
+	}
+	if rs.Location() != "" {
+		res.WriteString(ICSLine("LOCATION", ICSText(rs.Location())))
+	}
+	if rs.Recurrence().IsNotEmpty() {
+		res.WriteString(ICSLine("RRULE", rs.Recurrence().ParsedRule().String()))
+	}
+	if organizer := rs.User().Partner(); organizer.Email() != "" {
+		res.WriteString(ICSLine(fmt.Sprintf("ORGANIZER;CN=\"%s\"", icsCommonName(organizer.Name())), "mailto:"+organizer.Email()))
+	}
+	for _, attendee := range rs.Attendees().Records() {
+		if attendee.Email() == "" {
+			continue
+		}
+		res.WriteString(ICSLine(fmt.Sprintf("ATTENDEE;CN=\"%s\"", icsCommonName(attendee.Name())), "mailto:"+attendee.Email()))
+	}
+	res.WriteString(ICSLine("END", "VEVENT"))
+	return res.String()
+}
+
+// ICSTimezones returns the VTIMEZONE components of the timezones referenced
+// by the TZID parameters of the VEVENT components of these events.
+func calendarEvent_ICSTimezones(rs m.CalendarEventSet) []string {
+	var (
+		locations []*time.Location
+		seen      = make(map[string]bool)
+		from, to  time.Time
+	)
+	for _, event := range rs.Records() {
+		loc := event.TimeLocation()
+		if event.AllDay() || loc == time.UTC {
+			continue
+		}
+		if !seen[loc.String()] {
+			seen[loc.String()] = true
+			locations = append(locations, loc)
+		}
+		if from.IsZero() || event.Start().Time.Before(from) {
+			from = event.Start().Time
+		}
+		if event.Stop().Time.After(to) {
+			to = event.Stop().Time
+		}
+	}
+	if now := time.Now(); now.After(to) {
+		to = now
+	}
+	from = time.Date(from.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year()+ICSTimezoneYears+1, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := make([]string, len(locations))
+	for i, loc := range locations {
+		res[i] = ICSTimezone(loc, from, to)
+	}
+	return res
+}
+
+// ExportICS returns the events of this recordset as an iCalendar object
+func calendarEvent_ExportICS(rs m.CalendarEventSet) string {
+	components := rs.ICSTimezones()
+	for _, event := range rs.Records() {
+		components = append(components, event.ICSEvent())
+	}
+	return ICSCalendar("", components...)
+}
+
+func init() {
+	models.NewModel("CalendarEvent")
+	h.CalendarEvent().SetDefaultOrder("Start DESC", "Name")
+	h.CalendarEvent().AddFields(fields_CalendarEvent)
+
+	h.CalendarEvent().NewMethod("CheckDates", calendarEvent_CheckDates)
+	h.CalendarEvent().NewMethod("OnchangeUser", calendarEvent_OnchangeUser)
+	h.CalendarEvent().NewMethod("TimeLocation", calendarEvent_TimeLocation)
+	h.CalendarEvent().NewMethod("Occurrences", calendarEvent_Occurrences)
+	h.CalendarEvent().NewMethod("ICSEvent", calendarEvent_ICSEvent)
+	h.CalendarEvent().NewMethod("ICSTimezones", calendarEvent_ICSTimezones)
+	h.CalendarEvent().NewMethod("ExportICS", calendarEvent_ExportICS)
+}
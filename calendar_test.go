@@ -0,0 +1,104 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCalendarEvent(t *testing.T) {
+	Convey("Testing calendar events", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			attendee := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Jane; Doe").
+				SetEmail("jane@example.com"))
+			weekly := h.RecurrenceRule().Create(env, h.RecurrenceRule().NewData().
+				SetName("Weekly").
+				SetRule("FREQ=WEEKLY;COUNT=4"))
+			event := h.CalendarEvent().Create(env, h.CalendarEvent().NewData().
+				SetName("Weekly meeting").
+				SetStart(dates.ParseDateTime("2025-03-17 08:00:00")).
+				SetStop(dates.ParseDateTime("2025-03-17 09:00:00")).
+				SetTZ("Europe/Paris").
+				SetAttendees(attendee).
+				SetRecurrence(weekly))
+			Convey("Events cannot stop before they start", func() {
+				So(func() {
+					event.SetStop(dates.ParseDateTime("2025-03-16 08:00:00"))
+				}, ShouldPanic)
+			})
+			Convey("Occurrences keep the local time across DST changes", func() {
+				occurrences := event.Occurrences(dates.ParseDateTime("2025-03-01 00:00:00"),
+					dates.ParseDateTime("2025-04-30 00:00:00"))
+				So(occurrences, ShouldHaveLength, 4)
+				So(occurrences[1].Hour(), ShouldEqual, 8)
+				So(occurrences[2].Hour(), ShouldEqual, 7)
+			})
+			Convey("Recurrence rules are expanded in their timezone", func() {
+				daily := h.RecurrenceRule().Create(env, h.RecurrenceRule().NewData().
+					SetName("Daily").
+					SetRule("FREQ=DAILY;COUNT=3").
+					SetDateStart(dates.ParseDateTime("2025-03-29 08:00:00")).
+					SetTZ("Europe/Paris"))
+				occurrences := daily.Between(dates.ParseDateTime("2025-03-01 00:00:00"),
+					dates.ParseDateTime("2025-04-30 00:00:00"))
+				So(occurrences, ShouldHaveLength, 3)
+				So(occurrences[0].Hour(), ShouldEqual, 8)
+				So(occurrences[2].Hour(), ShouldEqual, 7)
+				next := daily.NextOccurrencesAfter(dates.ParseDateTime("2025-03-29 12:00:00"), 2)
+				So(next, ShouldHaveLength, 2)
+				So(next[1].Hour(), ShouldEqual, 7)
+			})
+			Convey("Events are exported in iCalendar format", func() {
+				ics := event.ExportICS()
+				So(ics, ShouldStartWith, "BEGIN:VCALENDAR\r\n")
+				So(ics, ShouldContainSubstring, "DTSTART;TZID=Europe/Paris:20250317T090000\r\n")
+				So(ics, ShouldContainSubstring, "BEGIN:VTIMEZONE\r\nTZID:Europe/Paris\r\n")
+				So(ics, ShouldContainSubstring, "BEGIN:DAYLIGHT\r\nDTSTART:20250330T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\n")
+				So(strings.Index(ics, "BEGIN:VTIMEZONE"), ShouldBeLessThan, strings.Index(ics, "BEGIN:VEVENT"))
+				So(ics, ShouldContainSubstring, "RRULE:FREQ=WEEKLY;COUNT=4\r\n")
+				So(ics, ShouldContainSubstring, "SUMMARY:Weekly meeting\r\n")
+				So(ics, ShouldContainSubstring, `ATTENDEE;CN="Jane; Doe":mailto:jane@example.com`)
+				So(strings.HasSuffix(ics, "END:VCALENDAR\r\n"), ShouldBeTrue)
+			})
+			Convey("Long lines are folded", func() {
+				line := ICSLine("DESCRIPTION", strings.Repeat("é", 60))
+				for _, l := range strings.Split(strings.TrimSuffix(line, "\r\n"), "\r\n") {
+					So(len(l), ShouldBeLessThanOrEqualTo, 76)
+				}
+			})
+			Convey("Users get a tokenized calendar feed with their events", func() {
+				user := h.User().Create(env, h.User().NewData().
+					SetName("Feed User").
+					SetLogin("feed_user").
+					SetEmail("feed@example.com"))
+				event.SetAttendees(attendee.Union(user.Partner()))
+				So(user.CalendarFeedURL(), ShouldBeBlank)
+				user.ResetCalendarToken()
+				token := user.CalendarToken()
+				So(token, ShouldNotBeBlank)
+				So(user.CalendarFeedURL(), ShouldEndWith, "/calendar/feed/"+token+"/calendar.ics")
+				So(user.CalendarFeed(), ShouldContainSubstring, "SUMMARY:Weekly meeting\r\n")
+				h.Activity().Create(env, h.Activity().NewData().
+					SetSummary("Call back").
+					SetUser(user).
+					SetDateDeadline(dates.ParseDate("2025-03-20")))
+				So(user.CalendarFeed(), ShouldContainSubstring, "DTSTART;VALUE=DATE:20250320\r\n")
+				So(user.CalendarFeed(), ShouldContainSubstring, "SUMMARY:Call back\r\n")
+				user.ResetCalendarToken()
+				So(user.CalendarToken(), ShouldNotEqual, token)
+				token = user.CalendarToken()
+				user.Sudo(user.ID()).Write(h.User().NewData().SetCalendarToken("chosen-token"))
+				So(user.CalendarToken(), ShouldEqual, token)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// A ColorDefinition is a color of the palette of the web client
+type ColorDefinition struct {
+	Name string
+	// Hex is the CSS color, e.g. #F06050. It is empty for "No color".
+	Hex string
+}
+
+// ColorPalette is the palette of the web client, indexed by color index.
+// It is the reference for all the Color fields of the ColorMixin.
+var ColorPalette = []ColorDefinition{
+	{Name: "No color"},
+	{Name: "Red", Hex: "#F06050"},
+	{Name: "Orange", Hex: "#F4A460"},
+	{Name: "Yellow", Hex: "#F7CD1F"},
+	{Name: "Light blue", Hex: "#6CC1ED"},
+	{Name: "Dark purple", Hex: "#814968"},
+	{Name: "Salmon pink", Hex: "#EB7E7F"},
+	{Name: "Medium blue", Hex: "#2C8397"},
+	{Name: "Dark blue", Hex: "#475577"},
+	{Name: "Fuchsia", Hex: "#D6145F"},
+	{Name: "Green", Hex: "#30C381"},
+	{Name: "Purple", Hex: "#9365B8"},
+}
+
+// colorPaletteSelection returns the color indexes of the palette as a selection
+func colorPaletteSelection() types.Selection {
+	res := make(types.Selection, len(ColorPalette))
+	for i, color := range ColorPalette {
+		res[strconv.Itoa(i)] = color.Name
+	}
+	return res
+}
+
+// colorClass returns the CSS class of the given color index. Indexes outside
+// the palette get the class of "No color".
+func colorClass(color int64) string {
+	if color < 0 || color >= int64(len(ColorPalette)) {
+		color = 0
+	}
+	return fmt.Sprintf("o_tag_color_%d", color)
+}
+
+var fields_ColorMixin = map[string]models.FieldDefinition{
+	"Color": fields.Integer{String: "Color Index"},
+	"ColorClass": fields.Char{String: "Color CSS Class", Compute: h.ColorMixin().Methods().ComputeColorClass(),
+		Depends: []string{"Color"}},
+}
+
+// ComputeColorClass returns the CSS class of the color of the record
+func colorMixin_ComputeColorClass(rs m.ColorMixinSet) m.ColorMixinData {
+	return h.ColorMixin().NewData().SetColorClass(colorClass(rs.Color()))
+}
+
+// ColorHex returns the CSS color of the record, or an empty string if it has no color
+func colorMixin_ColorHex(rs m.ColorMixinSet) string {
+	color := rs.Color()
+	if color < 0 || color >= int64(len(ColorPalette)) {
+		return ""
+	}
+	return ColorPalette[color].Hex
+}
+
+// NextColor returns the color index that is the least used by the records of
+// this model, so that new records get a color as distinct as possible from
+// the others. Archived records are ignored. "No color" (0) is never returned.
+func colorMixin_NextColor(rs m.ColorMixinSet) int64 {
+	var usages []struct {
+		Color int64
+		Count int64
+	}
+	where := "color IS NOT NULL"
+	if _, exists := rs.Collection().Model().Fields().Get("active"); exists {
+		where += " AND active = true"
+	}
+	rs.Env().Cr().Select(&usages, fmt.Sprintf(`
+SELECT color, COUNT(id) AS count FROM %s
+WHERE %s
+GROUP BY color`, rs.Collection().Model().Table(), where))
+	counts := make(map[int64]int64)
+	for _, u := range usages {
+		counts[u.Color] = u.Count
+	}
+	res := int64(1)
+	for color := int64(1); color < int64(len(ColorPalette)); color++ {
+		if counts[color] < counts[res] {
+			res = color
+		}
+	}
+	return res
+}
+
+func init() {
+	models.NewMixinModel("ColorMixin")
+	h.ColorMixin().AddFields(fields_ColorMixin)
+	h.ColorMixin().NewMethod("ComputeColorClass", colorMixin_ComputeColorClass)
+	h.ColorMixin().NewMethod("ColorHex", colorMixin_ColorHex)
+	h.ColorMixin().NewMethod("NextColor", colorMixin_NextColor)
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestColorMixin(t *testing.T) {
+	Convey("Testing the color mixin", t, func() {
+		Convey("The color picker matches the palette", func() {
+			So(ColorPicker, ShouldHaveLength, len(ColorPalette))
+			So(ColorPicker["1"], ShouldEqual, "Red")
+		})
+		Convey("CSS classes fall back on no color outside the palette", func() {
+			So(colorClass(3), ShouldEqual, "o_tag_color_3")
+			So(colorClass(-3), ShouldEqual, "o_tag_color_0")
+			So(colorClass(int64(len(ColorPalette))), ShouldEqual, "o_tag_color_0")
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Partners have a color class and a CSS color", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().SetName("Colored").SetColor(10))
+				So(partner.ColorClass(), ShouldEqual, "o_tag_color_10")
+				So(partner.ColorHex(), ShouldEqual, "#30C381")
+				partner.SetColor(0)
+				So(partner.ColorHex(), ShouldBeEmpty)
+			})
+			Convey("Tags and partner categories get the least used color", func() {
+				tag := h.Tag().Create(env, h.Tag().NewData().SetName("Color Tag"))
+				So(tag.Color(), ShouldBeGreaterThan, 0)
+				So(tag.Color(), ShouldBeLessThan, len(ColorPalette))
+				category := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Color Category"))
+				So(category.Color(), ShouldBeGreaterThan, 0)
+				explicit := h.Tag().Create(env, h.Tag().NewData().SetName("Explicit Tag").SetColor(0))
+				So(explicit.Color(), ShouldEqual, 0)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
 
 	"github.com/erlangs/okoo/src/models"
 	"github.com/erlangs/okoo/src/models/fields"
@@ -35,6 +36,9 @@ var CompanyDependent = models.FieldContexts{
 	},
 }
 
+// colorHexPattern matches the CSS hex colors accepted for company theming
+var colorHexPattern = regexp.MustCompile(`^#(?:[0-9a-fA-F]{3}){1,2}$`)
+
 // CompanyGetUserCurrency returns the currency of the current user's company if it exists
 // or the default currency otherwise
 func CompanyGetUserCurrency(env models.Environment) interface{} {
@@ -74,12 +78,89 @@ var fields_Company = map[string]models.FieldDefinition{
 	"Phone":           fields.Char{Related: "Partner.Phone"},
 	"Website":         fields.Char{Related: "Partner.Website"},
 	"VAT":             fields.Char{Related: "Partner.VAT"},
-	"CompanyRegistry": fields.Char{Size: 64},
+	"CompanyRegistry": fields.Char{Size: 64, Help: "Registration number in the trade register, printed on documents"},
+	"ReportShowTIN":   fields.Boolean{String: "Show TIN on Documents", Default: models.DefaultValue(true)},
+	"TINLabel": fields.Char{String: "TIN Label",
+		Help: "Label of the tax identification number on documents, e.g. VAT or EIN. Defaults to TIN."},
+	"SocialTwitter":   fields.Char{String: "Twitter Account"},
+	"SocialFacebook":  fields.Char{String: "Facebook Account"},
+	"SocialLinkedIn":  fields.Char{String: "LinkedIn Account", JSON: "social_linkedin"},
+	"SocialInstagram": fields.Char{String: "Instagram Account"},
+	"SocialYoutube":   fields.Char{String: "Youtube Account"},
+	"SocialGithub":    fields.Char{String: "GitHub Account"},
+	"ReportHeader": fields.Text{String: "Company Tagline", Translate: true,
+		Help: "HTML tagline displayed below the company logo on printed documents"},
+	"ReportFooter": fields.Text{Translate: true,
+		Help: "HTML footer of printed documents. Leave empty to print the company contact details and legal mentions."},
 	"Favicon": fields.Binary{String: "Company Favicon", Default: func(env models.Environment) interface{} {
 		fileName := filepath.Join(server.ResourceDir, "static", "web", "src", "img", "favicon.ico")
 		imgData, _ := ioutil.ReadFile(fileName)
 		return base64.StdEncoding.EncodeToString(imgData)
 	}, Help: `This field holds the image used to display a favicon for a given company.`},
+	"AddressValidator": fields.Selection{SelectionFunc: AddressValidatorsSelection,
+		Default: models.DefaultValue("none"), String: "Address Validation",
+		Help: "Validator used to check and normalize the addresses of this company's partners when they are modified."},
+	"AddressSyncPolicy": fields.Selection{Selection: AddressSyncPolicies, Required: true,
+		Default: models.DefaultValue(AddressSyncAlways), String: "Contact Address Sync",
+		Help: "Whether the address of contacts is updated when the address of their parent company changes."},
+	"AliasDomain": fields.Char{String: "Alias Domain",
+		Help: "Domain of the email aliases of this company, e.g. example.com for sales@example.com"},
+	"CatchallAlias": fields.Char{String: "Catchall Alias", Default: models.DefaultValue("catchall"),
+		Help: "Local part of the address to which replies to outgoing emails are sent, e.g. catchall for catchall@example.com"},
+	"PrimaryColor": fields.Char{Size: 7, Default: models.DefaultValue("#875A7B"),
+		Constraint: h.Company().Methods().CheckThemeColors(),
+		Help:       "Main brand color of the company, as an hexadecimal CSS color (e.g. #875A7B)"},
+	"SecondaryColor": fields.Char{Size: 7, Default: models.DefaultValue("#8595A2"),
+		Constraint: h.Company().Methods().CheckThemeColors(),
+		Help:       "Secondary brand color of the company, as an hexadecimal CSS color (e.g. #8595A2)"},
+	"FiscalYearLastDay": fields.Integer{Required: true, Default: models.DefaultValue(31),
+		Constraint: h.Company().Methods().CheckFiscalYear()},
+	"FiscalYearLastMonth": fields.Integer{Required: true, Default: models.DefaultValue(12),
+		Constraint: h.Company().Methods().CheckFiscalYear()},
+	"PeriodLockDate": fields.Date{String: "Lock Date for Non-Administrators",
+		Help: "Only members of the Settings group can edit entries prior to and inclusive of this date."},
+	"FiscalYearLockDate": fields.Date{String: "Lock Date",
+		Help: "No user, including members of the Settings group, can edit entries prior to and inclusive of this date."},
+}
+
+// CheckThemeColors checks that the theme colors are valid hexadecimal CSS colors
+func company_CheckThemeColors(rs m.CompanySet) {
+	for _, company := range rs.Records() {
+		for _, color := range []string{company.PrimaryColor(), company.SecondaryColor()} {
+			if color != "" && !colorHexPattern.MatchString(color) {
+				log.Panic(rs.T("Invalid color '%s': colors must be given in hexadecimal format (e.g. #875A7B)", color))
+			}
+		}
+	}
+}
+
+// GetThemeCSS returns a CSS stylesheet defining the theme variables of this company,
+// so that web clients can be branded per company.
+func company_GetThemeCSS(rs m.CompanySet) string {
+	rs.EnsureOne()
+	primary, secondary := rs.PrimaryColor(), rs.SecondaryColor()
+	if primary == "" {
+		primary = "#875A7B"
+	}
+	if secondary == "" {
+		secondary = "#8595A2"
+	}
+	return fmt.Sprintf(`:root {
+	--company-primary-color: %s;
+	--company-secondary-color: %s;
+}
+`, primary, secondary)
+}
+
+// FormatMonetary returns the given amount formatted in the given currency according
+// to the conventions of the given language code. If currency is empty, the currency
+// of this company is used. If lang is empty, the language of the context is used.
+func company_FormatMonetary(rs m.CompanySet, amount float64, currency m.CurrencySet, lang string) string {
+	rs.EnsureOne()
+	if currency.IsEmpty() {
+		currency = rs.Currency()
+	}
+	return currency.FormatMonetary(amount, lang)
 }
 
 func company_Copy(rs m.CompanySet, overrides m.CompanyData) m.CompanySet {
@@ -163,6 +244,7 @@ func company_SearchByName(rs m.CompanySet, name string, op operator.Operator, ad
 func init() {
 	models.NewModel("Company")
 	h.Company().AddFields(fields_Company)
+	h.Company().InheritModel(h.CustomFieldMixin())
 
 	h.Company().Methods().Copy().Extend(company_Copy)
 	h.Company().NewMethod("ComputeLogoWeb", company_ComputeLogoWeb)
@@ -172,5 +254,8 @@ func init() {
 	h.Company().NewMethod("CompanyDefaultGet", company_CompanyDefaultGet)
 	h.Company().Methods().Create().Extend(company_Create)
 	h.Company().NewMethod("CheckParent", company_CheckParent)
+	h.Company().NewMethod("CheckThemeColors", company_CheckThemeColors)
+	h.Company().NewMethod("GetThemeCSS", company_GetThemeCSS)
+	h.Company().NewMethod("FormatMonetary", company_FormatMonetary)
 	h.Company().Methods().SearchByName().Extend(company_SearchByName)
 }
@@ -0,0 +1,91 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"time"
+
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// fiscalYearEnd returns the last day of the fiscal year ending in the given
+// year. The day is clamped to the length of the month, and a fiscal year
+// ending on February 28th or 29th always ends on the last day of February.
+func fiscalYearEnd(year, lastDay, lastMonth int) time.Time {
+	monthEnd := time.Date(year, time.Month(lastMonth)+1, 0, 0, 0, 0, 0, time.UTC)
+	if lastDay > monthEnd.Day() || (lastMonth == 2 && lastDay >= 28) {
+		return monthEnd
+	}
+	return time.Date(year, time.Month(lastMonth), lastDay, 0, 0, 0, 0, time.UTC)
+}
+
+// fiscalYearDates returns the first and last days of the fiscal year
+// containing the given date, for fiscal years ending on the given day and month.
+func fiscalYearDates(date time.Time, lastDay, lastMonth int) (time.Time, time.Time) {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	dateTo := fiscalYearEnd(day.Year(), lastDay, lastMonth)
+	if day.After(dateTo) {
+		return dateTo.AddDate(0, 0, 1), fiscalYearEnd(day.Year()+1, lastDay, lastMonth)
+	}
+	return fiscalYearEnd(day.Year()-1, lastDay, lastMonth).AddDate(0, 0, 1), dateTo
+}
+
+// CheckFiscalYear checks that the last day of the fiscal year exists
+func company_CheckFiscalYear(rs m.CompanySet) {
+	for _, company := range rs.Records() {
+		if company.FiscalYearLastMonth() < 1 || company.FiscalYearLastMonth() > 12 {
+			log.Panic(rs.T("The last month of the fiscal year must be between 1 and 12"))
+		}
+		// A leap year is used so that February 29th is accepted
+		monthEnd := time.Date(2020, time.Month(company.FiscalYearLastMonth())+1, 0, 0, 0, 0, 0, time.UTC)
+		if company.FiscalYearLastDay() < 1 || company.FiscalYearLastDay() > int64(monthEnd.Day()) {
+			log.Panic(rs.T("Invalid fiscal year last day: %d/%d does not exist",
+				company.FiscalYearLastDay(), company.FiscalYearLastMonth()))
+		}
+	}
+}
+
+// ComputeFiscalYearDates returns the first and last days of the fiscal year
+// of this company containing the given date.
+func company_ComputeFiscalYearDates(rs m.CompanySet, date dates.Date) (dates.Date, dates.Date) {
+	rs.EnsureOne()
+	from, to := fiscalYearDates(date.Time, int(rs.FiscalYearLastDay()), int(rs.FiscalYearLastMonth()))
+	return dates.Date{Time: from}, dates.Date{Time: to}
+}
+
+// UserLockDate returns the date until which entries of this company are locked
+// for the current user. The fiscal year lock date applies to all users,
+// whereas the period lock date does not apply to members of the Settings group.
+func company_UserLockDate(rs m.CompanySet) dates.Date {
+	rs.EnsureOne()
+	lockDate := rs.FiscalYearLockDate()
+	if h.User().NewSet(rs.Env()).CurrentUser().IsSystem() {
+		return lockDate
+	}
+	if rs.PeriodLockDate().Greater(lockDate) {
+		return rs.PeriodLockDate()
+	}
+	return lockDate
+}
+
+// CheckLockDate panics if the given date is on or before the lock date of
+// this company for the current user. Addons should call it before creating
+// or modifying dated records such as journal entries.
+func company_CheckLockDate(rs m.CompanySet, date dates.Date) {
+	rs.EnsureOne()
+	lockDate := rs.UserLockDate()
+	if !lockDate.IsZero() && !date.Greater(lockDate) {
+		log.Panic(rs.T("You cannot add or modify entries of %s prior to and inclusive of the lock date %s",
+			rs.Name(), lockDate.String()))
+	}
+}
+
+func init() {
+	h.Company().NewMethod("CheckFiscalYear", company_CheckFiscalYear)
+	h.Company().NewMethod("ComputeFiscalYearDates", company_ComputeFiscalYearDates)
+	h.Company().NewMethod("UserLockDate", company_UserLockDate)
+	h.Company().NewMethod("CheckLockDate", company_CheckLockDate)
+}
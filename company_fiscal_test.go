@@ -0,0 +1,61 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompanyFiscalYear(t *testing.T) {
+	Convey("Testing company fiscal years", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Create(env, h.Company().NewData().SetName("Fiscal Company"))
+			Convey("Fiscal years default to calendar years", func() {
+				from, to := company.ComputeFiscalYearDates(dates.ParseDate("2020-05-10"))
+				So(from.String(), ShouldEqual, "2020-01-01")
+				So(to.String(), ShouldEqual, "2020-12-31")
+			})
+			Convey("Fiscal years can end on any day", func() {
+				company.Write(h.Company().NewData().
+					SetFiscalYearLastDay(31).
+					SetFiscalYearLastMonth(3))
+				from, to := company.ComputeFiscalYearDates(dates.ParseDate("2020-05-10"))
+				So(from.String(), ShouldEqual, "2020-04-01")
+				So(to.String(), ShouldEqual, "2021-03-31")
+				from, to = company.ComputeFiscalYearDates(dates.ParseDate("2020-03-31"))
+				So(from.String(), ShouldEqual, "2019-04-01")
+				So(to.String(), ShouldEqual, "2020-03-31")
+			})
+			Convey("Fiscal years ending in February follow leap years", func() {
+				company.Write(h.Company().NewData().
+					SetFiscalYearLastDay(28).
+					SetFiscalYearLastMonth(2))
+				from, to := company.ComputeFiscalYearDates(dates.ParseDate("2019-12-01"))
+				So(from.String(), ShouldEqual, "2019-03-01")
+				So(to.String(), ShouldEqual, "2020-02-29")
+			})
+			Convey("Invalid fiscal year ends are rejected", func() {
+				So(func() { company.SetFiscalYearLastMonth(13) }, ShouldPanic)
+				So(func() {
+					company.Write(h.Company().NewData().
+						SetFiscalYearLastDay(31).
+						SetFiscalYearLastMonth(4))
+				}, ShouldPanic)
+			})
+			Convey("Lock dates prevent changes to past entries", func() {
+				company.SetFiscalYearLockDate(dates.ParseDate("2019-12-31"))
+				So(func() { company.CheckLockDate(dates.ParseDate("2019-12-31")) }, ShouldPanic)
+				So(func() { company.CheckLockDate(dates.ParseDate("2020-01-01")) }, ShouldNotPanic)
+				company.SetPeriodLockDate(dates.ParseDate("2020-03-31"))
+				So(company.UserLockDate().String(), ShouldEqual, "2019-12-31")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// Actions of the company offboarding wizard on dependent records
+const (
+	OffboardingReassign = "reassign"
+	OffboardingArchive  = "archive"
+)
+
+// OffboardingActions is the selection of the actions of the company offboarding wizard
+var OffboardingActions = types.Selection{
+	OffboardingReassign: "Reassign to Another Company",
+	OffboardingArchive:  "Archive",
+}
+
+// DependentUsers returns the active users allowed in these companies
+func company_DependentUsers(rs m.CompanySet) m.UserSet {
+	return h.User().Search(rs.Env(), q.User().Companies().In(rs))
+}
+
+// DependentPartners returns the active partners belonging to these companies,
+// except the partners of the companies themselves.
+func company_DependentPartners(rs m.CompanySet) m.PartnerSet {
+	return h.Partner().Search(rs.Env(), q.Partner().Company().In(rs).
+		And().ID().NotIn(rs.Partner().Ids()))
+}
+
+// DependentSequences returns the active sequences of these companies
+func company_DependentSequences(rs m.CompanySet) m.SequenceSet {
+	return h.Sequence().Search(rs.Env(), q.Sequence().Company().In(rs))
+}
+
+// CheckUnlink panics if one of these companies still has active users, partners
+// or sequences, which would be orphaned by the deletion of the company.
+func company_CheckUnlink(rs m.CompanySet) {
+	for _, company := range rs.Records() {
+		users := company.DependentUsers().SearchCount()
+		partners := company.DependentPartners().SearchCount()
+		sequences := company.DependentSequences().SearchCount()
+		if users+partners+sequences == 0 {
+			continue
+		}
+		log.Panic(rs.T("Company %s cannot be deleted: it still has %d users, %d contacts and %d sequences. Use the offboarding wizard to reassign or archive them first.",
+			company.Name(), users, partners, sequences))
+	}
+}
+
+func company_Unlink(rs m.CompanySet) int64 {
+	rs.CheckUnlink()
+	return rs.Super().Unlink()
+}
+
+var fields_CompanyOffboardingWizard = map[string]models.FieldDefinition{
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.Company().BrowseOne(env, env.Context().GetInteger(ContextKeyActiveID))
+		}, OnDelete: models.Cascade},
+	"TargetCompany": fields.Many2One{RelationModel: h.Company(), String: "Reassign To",
+		Constraint: h.CompanyOffboardingWizard().Methods().CheckTargetCompany(),
+		Help:       "Company receiving the reassigned users, contacts and sequences"},
+	"UserAction": fields.Selection{Selection: OffboardingActions, String: "Users", Required: true,
+		Default:    models.DefaultValue(OffboardingReassign),
+		Constraint: h.CompanyOffboardingWizard().Methods().CheckTargetCompany()},
+	"PartnerAction": fields.Selection{Selection: OffboardingActions, String: "Contacts", Required: true,
+		Default:    models.DefaultValue(OffboardingReassign),
+		Constraint: h.CompanyOffboardingWizard().Methods().CheckTargetCompany()},
+	"SequenceAction": fields.Selection{Selection: OffboardingActions, String: "Sequences", Required: true,
+		Default:    models.DefaultValue(OffboardingArchive),
+		Constraint: h.CompanyOffboardingWizard().Methods().CheckTargetCompany()},
+	"UserCount": fields.Integer{String: "Number of Users", GoType: new(int),
+		Compute: h.CompanyOffboardingWizard().Methods().ComputeCounts(), Depends: []string{"Company"}},
+	"PartnerCount": fields.Integer{String: "Number of Contacts", GoType: new(int),
+		Compute: h.CompanyOffboardingWizard().Methods().ComputeCounts(), Depends: []string{"Company"}},
+	"SequenceCount": fields.Integer{String: "Number of Sequences", GoType: new(int),
+		Compute: h.CompanyOffboardingWizard().Methods().ComputeCounts(), Depends: []string{"Company"}},
+	"DeleteCompany": fields.Boolean{String: "Delete Company Afterwards",
+		Constraint: h.CompanyOffboardingWizard().Methods().CheckTargetCompany(),
+		Help:       "Delete the company once all its users, contacts and sequences have been reassigned or archived"},
+}
+
+// ComputeCounts computes the number of records depending on the company
+func companyOffboardingWizard_ComputeCounts(rs m.CompanyOffboardingWizardSet) m.CompanyOffboardingWizardData {
+	return h.CompanyOffboardingWizard().NewData().
+		SetUserCount(rs.Company().DependentUsers().SearchCount()).
+		SetPartnerCount(rs.Company().DependentPartners().SearchCount()).
+		SetSequenceCount(rs.Company().DependentSequences().SearchCount())
+}
+
+// NeedsTargetCompany returns true if some records are to be reassigned, or if
+// the company is to be deleted while it still has users, since archived users
+// must also be moved to another company.
+func companyOffboardingWizard_NeedsTargetCompany(rs m.CompanyOffboardingWizardSet) bool {
+	if rs.UserAction() == OffboardingReassign || rs.PartnerAction() == OffboardingReassign ||
+		rs.SequenceAction() == OffboardingReassign {
+		return true
+	}
+	return rs.DeleteCompany() && rs.Company().DependentUsers().IsNotEmpty()
+}
+
+// CheckTargetCompany checks that a target company different from the
+// offboarded company is set when records are to be reassigned.
+func companyOffboardingWizard_CheckTargetCompany(rs m.CompanyOffboardingWizardSet) {
+	for _, wizard := range rs.Records() {
+		if !wizard.NeedsTargetCompany() {
+			continue
+		}
+		if wizard.TargetCompany().IsEmpty() {
+			log.Panic(rs.T("Please select the company to which records are reassigned."))
+		}
+		if wizard.TargetCompany().Equals(wizard.Company()) {
+			log.Panic(rs.T("Records cannot be reassigned to the company being offboarded."))
+		}
+	}
+}
+
+// OffboardUsers reassigns or archives the users of the company.
+// Users also allowed in other companies only lose access to this company.
+func companyOffboardingWizard_OffboardUsers(rs m.CompanyOffboardingWizardSet) int {
+	company, target := rs.Company(), rs.TargetCompany()
+	users := company.DependentUsers()
+	for _, user := range users.Records() {
+		companies := user.Companies().Subtract(company)
+		mainCompany := user.Company()
+		if mainCompany.Equals(company) {
+			switch rs.UserAction() {
+			case OffboardingArchive:
+				user.SetActive(false)
+				if companies.IsEmpty() {
+					// Users must belong to a company, so that archived users
+					// of this company only are moved to the target company.
+					if target.IsEmpty() {
+						continue
+					}
+					companies = target
+				}
+				mainCompany = companies.Records()[0]
+			default:
+				companies = companies.Union(target)
+				mainCompany = target
+			}
+		}
+		user.Write(h.User().NewData().
+			SetCompanies(companies).
+			SetCompany(mainCompany))
+	}
+	return users.Len()
+}
+
+// OffboardPartners reassigns or archives the contacts of the company
+func companyOffboardingWizard_OffboardPartners(rs m.CompanyOffboardingWizardSet) int {
+	partners := rs.Company().DependentPartners()
+	switch rs.PartnerAction() {
+	case OffboardingArchive:
+		partners.SetActive(false)
+	default:
+		partners.SetCompany(rs.TargetCompany())
+	}
+	return partners.Len()
+}
+
+// OffboardSequences reassigns or archives the sequences of the company
+func companyOffboardingWizard_OffboardSequences(rs m.CompanyOffboardingWizardSet) int {
+	sequences := rs.Company().DependentSequences()
+	switch rs.SequenceAction() {
+	case OffboardingArchive:
+		sequences.SetActive(false)
+	default:
+		sequences.SetCompany(rs.TargetCompany())
+	}
+	return sequences.Len()
+}
+
+// ActionApply reassigns or archives the users, contacts and sequences of the
+// company and deletes it if requested. The offboarding is recorded in the
+// application log.
+func companyOffboardingWizard_ActionApply(rs m.CompanyOffboardingWizardSet) *actions.Action {
+	rs.EnsureOne()
+	rs.CheckTargetCompany()
+	company := rs.Company()
+	users := rs.OffboardUsers()
+	partners := rs.OffboardPartners()
+	sequences := rs.OffboardSequences()
+	LogEvent(rs.Env(), LogEntry{
+		Level:  LoggingInfo,
+		Logger: "company",
+		Message: fmt.Sprintf("Company '%s' offboarded: %d users (%s), %d contacts (%s) and %d sequences (%s)",
+			company.Name(), users, rs.UserAction(), partners, rs.PartnerAction(), sequences, rs.SequenceAction()),
+		Func:  "CompanyOffboardingWizard.ActionApply",
+		Model: "Company",
+		ResID: company.ID(),
+	})
+	if rs.DeleteCompany() {
+		company.Unlink()
+	}
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+func init() {
+	h.Company().NewMethod("DependentUsers", company_DependentUsers)
+	h.Company().NewMethod("DependentPartners", company_DependentPartners)
+	h.Company().NewMethod("DependentSequences", company_DependentSequences)
+	h.Company().NewMethod("CheckUnlink", company_CheckUnlink)
+	h.Company().Methods().Unlink().Extend(company_Unlink)
+
+	models.NewTransientModel("CompanyOffboardingWizard")
+	h.CompanyOffboardingWizard().AddFields(fields_CompanyOffboardingWizard)
+	h.CompanyOffboardingWizard().NewMethod("ComputeCounts", companyOffboardingWizard_ComputeCounts)
+	h.CompanyOffboardingWizard().NewMethod("NeedsTargetCompany", companyOffboardingWizard_NeedsTargetCompany)
+	h.CompanyOffboardingWizard().NewMethod("CheckTargetCompany", companyOffboardingWizard_CheckTargetCompany)
+	h.CompanyOffboardingWizard().NewMethod("OffboardUsers", companyOffboardingWizard_OffboardUsers)
+	h.CompanyOffboardingWizard().NewMethod("OffboardPartners", companyOffboardingWizard_OffboardPartners)
+	h.CompanyOffboardingWizard().NewMethod("OffboardSequences", companyOffboardingWizard_OffboardSequences)
+	h.CompanyOffboardingWizard().NewMethod("ActionApply", companyOffboardingWizard_ActionApply)
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompanyOffboarding(t *testing.T) {
+	Convey("Testing company deletion protection and offboarding", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			mainCompany := h.Company().NewSet(env).CompanyDefaultGet()
+			company := h.Company().Create(env, h.Company().NewData().SetName("Offboarded Company"))
+			user := h.User().Create(env, h.User().NewData().
+				SetName("Offboarded User").
+				SetLogin("offboarded_user").
+				SetCompany(company).
+				SetCompanies(company))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Offboarded Contact").
+				SetCompany(company))
+			sequence := h.Sequence().Create(env, h.Sequence().NewData().
+				SetName("Offboarded Sequence").
+				SetCode("offboarded_sequence").
+				SetCompany(company))
+			Convey("Companies with dependent records cannot be deleted", func() {
+				So(company.DependentUsers().Equals(user), ShouldBeTrue)
+				So(company.DependentPartners().Contains(partner), ShouldBeTrue)
+				So(company.DependentPartners().Contains(company.Partner()), ShouldBeFalse)
+				So(company.DependentSequences().Equals(sequence), ShouldBeTrue)
+				So(func() { company.Unlink() }, ShouldPanic)
+			})
+			Convey("A target company is required to reassign records", func() {
+				So(func() {
+					h.CompanyOffboardingWizard().Create(env, h.CompanyOffboardingWizard().NewData().
+						SetCompany(company))
+				}, ShouldPanic)
+				So(func() {
+					h.CompanyOffboardingWizard().Create(env, h.CompanyOffboardingWizard().NewData().
+						SetCompany(company).
+						SetTargetCompany(company))
+				}, ShouldPanic)
+			})
+			Convey("The wizard reassigns and archives records before deleting the company", func() {
+				wizard := h.CompanyOffboardingWizard().Create(env, h.CompanyOffboardingWizard().NewData().
+					SetCompany(company).
+					SetTargetCompany(mainCompany).
+					SetUserAction(OffboardingReassign).
+					SetPartnerAction(OffboardingReassign).
+					SetSequenceAction(OffboardingArchive).
+					SetDeleteCompany(true))
+				So(wizard.UserCount(), ShouldEqual, 1)
+				So(wizard.SequenceCount(), ShouldEqual, 1)
+				wizard.ActionApply()
+				So(user.Company().Equals(mainCompany), ShouldBeTrue)
+				So(user.Companies().Equals(mainCompany), ShouldBeTrue)
+				So(partner.Company().Equals(mainCompany), ShouldBeTrue)
+				So(sequence.Active(), ShouldBeFalse)
+				So(h.Company().Search(env, q.Company().Name().Equals("Offboarded Company")).IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
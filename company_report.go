@@ -0,0 +1,100 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"html"
+	"strings"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// LegalMentions returns the company registry and tax identification numbers
+// of this company formatted with their label, for printing on documents.
+func company_LegalMentions(rs m.CompanySet) []string {
+	rs.EnsureOne()
+	var res []string
+	if rs.CompanyRegistry() != "" {
+		res = append(res, rs.T("Company Registry: %s", rs.CompanyRegistry()))
+	}
+	if rs.ReportShowTIN() && rs.VAT() != "" {
+		label := rs.TINLabel()
+		if label == "" {
+			label = rs.T("TIN")
+		}
+		res = append(res, label+": "+rs.VAT())
+	}
+	return res
+}
+
+// SocialMedia returns the URLs of the social media pages of this company keyed by network name
+func company_SocialMedia(rs m.CompanySet) map[string]string {
+	rs.EnsureOne()
+	res := make(map[string]string)
+	for network, url := range map[string]string{
+		"twitter":   rs.SocialTwitter(),
+		"facebook":  rs.SocialFacebook(),
+		"linkedin":  rs.SocialLinkedIn(),
+		"instagram": rs.SocialInstagram(),
+		"youtube":   rs.SocialYoutube(),
+		"github":    rs.SocialGithub(),
+	} {
+		if url != "" {
+			res[network] = url
+		}
+	}
+	return res
+}
+
+// DefaultReportFooter returns the footer printed on documents when no
+// custom footer is set: the contact details and legal mentions of this company.
+func company_DefaultReportFooter(rs m.CompanySet) string {
+	rs.EnsureOne()
+	var parts []string
+	for _, part := range append([]string{rs.Phone(), rs.Email(), rs.Website()}, rs.LegalMentions()...) {
+		if part != "" {
+			parts = append(parts, html.EscapeString(part))
+		}
+	}
+	return strings.Join(parts, " | ")
+}
+
+// ReportLayoutData returns the data of this company displayed in the header
+// and footer of printed documents.
+func company_ReportLayoutData(rs m.CompanySet) basetypes.ReportLayout {
+	rs.EnsureOne()
+	var addressLines []string
+	for _, line := range strings.Split(rs.Partner().DisplayAddress(true), "\n") {
+		if strings.TrimSpace(line) != "" {
+			addressLines = append(addressLines, line)
+		}
+	}
+	footer := rs.ReportFooter()
+	if footer == "" {
+		footer = rs.DefaultReportFooter()
+	}
+	return basetypes.ReportLayout{
+		CompanyName:   rs.Name(),
+		Logo:          rs.LogoWeb(),
+		AddressLines:  addressLines,
+		Phone:         rs.Phone(),
+		Email:         rs.Email(),
+		Website:       rs.Website(),
+		LegalMentions: rs.LegalMentions(),
+		SocialMedia:   rs.SocialMedia(),
+		Header:        rs.ReportHeader(),
+		Footer:        footer,
+		PrintDate:     rs.FormatInUserTZ(dates.Now()),
+	}
+}
+
+func init() {
+	h.Company().NewMethod("LegalMentions", company_LegalMentions)
+	h.Company().NewMethod("SocialMedia", company_SocialMedia)
+	h.Company().NewMethod("DefaultReportFooter", company_DefaultReportFooter)
+	h.Company().NewMethod("ReportLayoutData", company_ReportLayoutData)
+}
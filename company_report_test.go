@@ -0,0 +1,48 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompanyReportLayout(t *testing.T) {
+	Convey("Testing company report layout data", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Create(env, h.Company().NewData().
+				SetName("Layout Company").
+				SetPhone("+32 2 123 45 67").
+				SetVAT("BE0477472701").
+				SetCompanyRegistry("RPM Brussels").
+				SetSocialGithub("https://github.com/hexya-erp"))
+			Convey("Legal mentions include the registry and the TIN", func() {
+				So(company.LegalMentions(), ShouldResemble, []string{"Company Registry: RPM Brussels", "TIN: BE0477472701"})
+				company.SetTINLabel("VAT")
+				So(company.LegalMentions(), ShouldContain, "VAT: BE0477472701")
+				company.SetReportShowTIN(false)
+				So(company.LegalMentions(), ShouldResemble, []string{"Company Registry: RPM Brussels"})
+			})
+			Convey("The default footer lists contact details and legal mentions", func() {
+				layout := company.ReportLayoutData()
+				So(layout.CompanyName, ShouldEqual, "Layout Company")
+				So(layout.Footer, ShouldContainSubstring, "+32 2 123 45 67")
+				So(layout.Footer, ShouldContainSubstring, "TIN: BE0477472701")
+				So(layout.SocialMedia, ShouldResemble, map[string]string{"github": "https://github.com/hexya-erp"})
+			})
+			Convey("A custom footer replaces the default one", func() {
+				company.Write(h.Company().NewData().
+					SetReportHeader("<em>Quality since 1901</em>").
+					SetReportFooter("<p>Custom footer</p>"))
+				layout := company.ReportLayoutData()
+				So(layout.Header, ShouldEqual, "<em>Quality since 1901</em>")
+				So(layout.Footer, ShouldEqual, "<p>Custom footer</p>")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,41 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompanyTheme(t *testing.T) {
+	Convey("Testing company theme colors", t, func() {
+		var mainCompanyID int64
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Company().Search(env, q.Company().HexyaExternalID().Equals("base_main_company"))
+			mainCompanyID = company.ID()
+			Convey("Default colors are used when none is set", func() {
+				company.Write(h.Company().NewData().SetPrimaryColor("").SetSecondaryColor(""))
+				So(company.GetThemeCSS(), ShouldContainSubstring, "--company-primary-color: #875A7B;")
+			})
+			Convey("Company colors are exported as CSS variables", func() {
+				company.Write(h.Company().NewData().SetPrimaryColor("#112233").SetSecondaryColor("#445566"))
+				css := company.GetThemeCSS()
+				So(css, ShouldContainSubstring, "--company-primary-color: #112233;")
+				So(css, ShouldContainSubstring, "--company-secondary-color: #445566;")
+			})
+			Convey("Invalid colors are rejected", func() {
+				So(func() { company.SetPrimaryColor("red;}body{display:none") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+		Convey("The public theme endpoint serves existing companies only", func() {
+			So(companyThemeCSS(mainCompanyID), ShouldContainSubstring, "--company-primary-color")
+			So(companyThemeCSS(-1), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fieldtype"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// A DependencyEdge links a computed field to one of the fields it depends on.
+// Fields are given as "Model.Field".
+type DependencyEdge struct {
+	Field     string `json:"field"`
+	DependsOn string `json:"depends_on"`
+	Path      string `json:"path"`
+}
+
+// A DependencyGraph is the graph of the compute dependencies of all the models
+// of the registry, as declared in the Depends attribute of computed fields.
+type DependencyGraph struct {
+	// Edges of the graph
+	Edges []DependencyEdge `json:"edges"`
+	// Errors lists the Depends paths that cannot be resolved
+	Errors []string `json:"errors"`
+	// Cycles lists the dependency cycles between fields of the same record,
+	// each one as a list of "Model.Field". Dependencies through relations are
+	// not taken into account since they are legitimate on hierarchies (e.g.
+	// a field depending on the same field of the parent record).
+	Cycles [][]string `json:"cycles"`
+}
+
+// modelFieldInfos returns the field infos of the given model, keyed by Go field name and by JSON name
+func modelFieldInfos(model *models.Model) map[string]*models.FieldInfo {
+	res := make(map[string]*models.FieldInfo)
+	for jsonName, fi := range model.FieldsGet() {
+		res[jsonName] = fi
+		res[fi.Name] = fi
+	}
+	return res
+}
+
+// resolveDependsPath walks the given Depends path from the given model and
+// returns the "Model.Field" names of each field of the path.
+func resolveDependsPath(modelName, path string) ([]string, error) {
+	var res []string
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		model, ok := models.Registry.Get(modelName)
+		if !ok {
+			return nil, fmt.Errorf("unknown model '%s'", modelName)
+		}
+		fi, ok := modelFieldInfos(model)[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field '%s' on model '%s'", name, modelName)
+		}
+		res = append(res, fmt.Sprintf("%s.%s", modelName, fi.Name))
+		if i == len(names)-1 {
+			break
+		}
+		switch fi.Type {
+		case fieldtype.Many2One, fieldtype.One2One, fieldtype.Rev2One, fieldtype.One2Many, fieldtype.Many2Many:
+			modelName = fi.Relation
+		default:
+			return nil, fmt.Errorf("field '%s' of model '%s' is not relational", name, modelName)
+		}
+	}
+	return res, nil
+}
+
+// ComputeDependencyGraph walks the Depends declarations of all the fields of
+// all the models of the registry and returns the dependency graph, including
+// the paths that cannot be resolved and the dependency cycles.
+func ComputeDependencyGraph() *DependencyGraph {
+	graph := &DependencyGraph{
+		Edges:  []DependencyEdge{},
+		Errors: []string{},
+		Cycles: [][]string{},
+	}
+	adjacency := make(map[string][]string)
+	for _, model := range models.Registry.All() {
+		for _, fi := range model.FieldsGet() {
+			field := fmt.Sprintf("%s.%s", model.Name(), fi.Name)
+			for _, path := range fi.Depends {
+				steps, err := resolveDependsPath(model.Name(), path)
+				if err != nil {
+					graph.Errors = append(graph.Errors, fmt.Sprintf("%s depends on '%s': %s", field, path, err))
+					continue
+				}
+				for _, step := range steps {
+					graph.Edges = append(graph.Edges, DependencyEdge{Field: field, DependsOn: step, Path: path})
+				}
+				if len(steps) == 1 {
+					adjacency[field] = append(adjacency[field], steps[0])
+				}
+			}
+		}
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Field != graph.Edges[j].Field {
+			return graph.Edges[i].Field < graph.Edges[j].Field
+		}
+		return graph.Edges[i].DependsOn < graph.Edges[j].DependsOn
+	})
+	sort.Strings(graph.Errors)
+	graph.Cycles = dependencyCycles(adjacency)
+	return graph
+}
+
+// dependencyCycles returns the cycles of the given directed graph
+func dependencyCycles(adjacency map[string][]string) [][]string {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	res := [][]string{}
+	state := make(map[string]int)
+	var stack []string
+	var visit func(node string)
+	visit = func(node string) {
+		state[node] = inProgress
+		stack = append(stack, node)
+		for _, next := range adjacency[node] {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+			case inProgress:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == next {
+						cycle := make([]string, len(stack)-i)
+						copy(cycle, stack[i:])
+						res = append(res, cycle)
+						break
+					}
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[node] = done
+	}
+	nodes := make([]string, 0, len(adjacency))
+	for node := range adjacency {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			visit(node)
+		}
+	}
+	return res
+}
+
+// CheckComputeDependencies checks the Depends declarations of all computed
+// fields of the registry. It logs a warning for each path that cannot be
+// resolved and each dependency cycle, and returns these problems.
+func model_CheckComputeDependencies(_ m.ModelSet) []string {
+	graph := ComputeDependencyGraph()
+	res := make([]string, 0, len(graph.Errors)+len(graph.Cycles))
+	for _, e := range graph.Errors {
+		log.Warn("Invalid compute dependency", "error", e)
+		res = append(res, e)
+	}
+	for _, cycle := range graph.Cycles {
+		msg := fmt.Sprintf("dependency cycle: %s -> %s", strings.Join(cycle, " -> "), cycle[0])
+		log.Warn("Compute dependency cycle", "cycle", strings.Join(cycle, " -> "))
+		res = append(res, msg)
+	}
+	return res
+}
+
+func init() {
+	h.Model().NewMethod("CheckComputeDependencies", model_CheckComputeDependencies)
+}
@@ -0,0 +1,42 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestComputeDependencies(t *testing.T) {
+	Convey("Testing compute dependencies checks", t, func() {
+		Convey("Depends paths are resolved through relations", func() {
+			steps, err := resolveDependsPath("Company", "Partner.Image")
+			So(err, ShouldBeNil)
+			So(steps, ShouldResemble, []string{"Company.Partner", "Partner.Image"})
+		})
+		Convey("Invalid Depends paths are detected", func() {
+			_, err := resolveDependsPath("Company", "Partner.Imag")
+			So(err, ShouldNotBeNil)
+			_, err = resolveDependsPath("Partner", "Name.Foo")
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Cycles are detected", func() {
+			cycles := dependencyCycles(map[string][]string{
+				"A.F1": {"A.F2"},
+				"A.F2": {"A.F3"},
+				"A.F3": {"A.F1"},
+				"A.F4": {"A.F1"},
+			})
+			So(cycles, ShouldResemble, [][]string{{"A.F1", "A.F2", "A.F3"}})
+			So(dependencyCycles(map[string][]string{"A.F1": {"A.F2"}}), ShouldBeEmpty)
+		})
+		Convey("The graph of the base module is consistent", func() {
+			graph := ComputeDependencyGraph()
+			So(graph.Errors, ShouldBeEmpty)
+			So(graph.Cycles, ShouldBeEmpty)
+			So(graph.Edges, ShouldContain, DependencyEdge{Field: "Company.LogoWeb", DependsOn: "Partner.Image", Path: "Partner.Image"})
+		})
+	})
+}
@@ -38,7 +38,7 @@ func configParameter_Init(rs m.ConfigParameterSet, force ...bool) {
 		forceInit = true
 	}
 	for key, fnct := range defaultParameters {
-		params := rs.Env().Pool(rs.ModelName()).Sudo().Search(q.ConfigParameter().Key().Equals(key).Condition)
+		params := rs.AsSuperUser("initialize config parameters").Search(q.ConfigParameter().Key().Equals(key))
 		if forceInit || params.IsEmpty() {
 			value, groups := fnct(rs.Env())
 			h.ConfigParameter().NewSet(rs.Env()).SetParam(key, value).LimitToGroups(groups)
@@ -86,6 +86,7 @@ func configParameter_LimitToGroups(rs m.ConfigParameterSet, groups m.GroupSet) {
 func init() {
 	models.NewModel("ConfigParameter")
 	h.ConfigParameter().AddFields(fields_ConfigParameter)
+	h.ConfigParameter().InheritModel(h.ReferenceCacheMixin())
 
 	h.ConfigParameter().NewMethod("Init", configParameter_Init)
 	h.ConfigParameter().NewMethod("GetParam", configParameter_GetParam)
@@ -0,0 +1,210 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/okoo/src/tools/emailutils"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// States of contact requests
+const (
+	ContactRequestNew       = "new"
+	ContactRequestProcessed = "processed"
+)
+
+// ContactRequestStates is the selection of the states of contact requests
+var ContactRequestStates = types.Selection{
+	ContactRequestNew:       "New",
+	ContactRequestProcessed: "Processed",
+}
+
+// ContactHoneypotField is the name of the hidden field of contact forms which
+// must be left empty. Bots filling it in are silently ignored.
+const ContactHoneypotField = "website_url"
+
+// Rate limit of the contact form: at most ContactRequestRateLimit requests
+// per ContactRequestRateWindow from the same IP address.
+var (
+	ContactRequestRateLimit  = 5
+	ContactRequestRateWindow = time.Hour
+)
+
+// ContactRequestRetentionDays is the default number of days after which
+// processed contact requests are deleted. It can be changed with the
+// 'base.contact_request_retention_days' config parameter.
+const ContactRequestRetentionDays = 180
+
+// A rateLimiter counts the events per key over a sliding window
+type rateLimiter struct {
+	sync.Mutex
+	events    map[string][]time.Time
+	lastPrune time.Time
+}
+
+// prune removes the keys without events within window, so that the
+// limiter does not grow with every key ever seen. It is run at most
+// once per window.
+func (r *rateLimiter) prune(now time.Time, window time.Duration) {
+	if now.Sub(r.lastPrune) < window {
+		return
+	}
+	for key, events := range r.events {
+		if len(events) == 0 || now.Sub(events[len(events)-1]) >= window {
+			delete(r.events, key)
+		}
+	}
+	r.lastPrune = now
+}
+
+// Allow records an event for the given key at the given time and returns
+// false if there already are limit events for this key within window.
+func (r *rateLimiter) Allow(key string, now time.Time, limit int, window time.Duration) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.prune(now, window)
+	var recent []time.Time
+	for _, t := range r.events[key] {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= limit {
+		r.events[key] = recent
+		return false
+	}
+	r.events[key] = append(recent, now)
+	return true
+}
+
+// contactRateLimiter limits the contact requests per IP address
+var contactRateLimiter = &rateLimiter{events: make(map[string][]time.Time)}
+
+var fields_ContactRequest = map[string]models.FieldDefinition{
+	"Name":        fields.Char{String: "Contact Name", Required: true},
+	"Email":       fields.Char{Required: true, Constraint: h.ContactRequest().Methods().CheckEmail()},
+	"Phone":       fields.Char{},
+	"CompanyName": fields.Char{String: "Company"},
+	"Subject":     fields.Char{},
+	"Message":     fields.Text{Required: true},
+	"Partner": fields.Many2One{RelationModel: h.Partner(), OnDelete: models.SetNull,
+		Help: "Contact found from the email address of the request, or created when the request is processed"},
+	"State": fields.Selection{Selection: ContactRequestStates, Required: true, Index: true,
+		Default: models.DefaultValue(ContactRequestNew)},
+	"IPAddress":      fields.Char{String: "IP Address", ReadOnly: true},
+	"UserAgent":      fields.Char{ReadOnly: true},
+	"AcceptLanguage": fields.Char{String: "Browser Languages", ReadOnly: true},
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+}
+
+// CheckEmail checks that the email address of the requests is valid
+func contactRequest_CheckEmail(rs m.ContactRequestSet) {
+	for _, request := range rs.Records() {
+		if !emailutils.IsValidAddress(strings.TrimSpace(request.Email())) {
+			log.Panic(rs.T("Invalid email address '%s'", request.Email()))
+		}
+	}
+}
+
+// LinkPartner finds the partner with the email address of each request and
+// links it to the request. If create is true, the partner is created when it
+// does not exist. The language of created partners is detected from the
+// browser languages and the message.
+func contactRequest_LinkPartner(rs m.ContactRequestSet, create bool) {
+	for _, request := range rs.Records() {
+		if request.Partner().IsNotEmpty() {
+			continue
+		}
+		partners := h.Partner().NewSet(rs.Env()).AsSuperUser("match contact request partner").
+			WithContext(ContextKeyAcceptLanguage, request.AcceptLanguage()).
+			WithContext(ContextKeyDetectLangText, request.Message())
+		// Emails are compared exactly, since patterns must not be taken from the public form
+		partner := partners.ImportMatch(h.Partner().NewData().SetEmail(request.Email()), []string{PartnerMatchEmail})
+		if partner.IsEmpty() {
+			if !create {
+				continue
+			}
+			partner = h.Partner().Create(partners.Env(), h.Partner().NewData().
+				SetName(request.Name()).
+				SetEmail(strings.TrimSpace(request.Email())).
+				SetPhone(request.Phone()).
+				SetCompanyName(request.CompanyName()))
+		}
+		request.SetPartner(partner)
+	}
+}
+
+// ProcessRequest is called for each new contact request submitted through the
+// contact form. It links the request to an existing partner. Partners are
+// only created when the request is qualified, so that spam does not pollute
+// the contacts.
+//
+// Website and CRM addons extend this method, e.g. to create a lead from the
+// request and mark it as processed.
+func contactRequest_ProcessRequest(rs m.ContactRequestSet) {
+	rs.LinkPartner(false)
+}
+
+// ActionMarkProcessed marks these requests as processed and creates the
+// partners of the requests which are not linked to one yet.
+func contactRequest_ActionMarkProcessed(rs m.ContactRequestSet) {
+	rs.LinkPartner(true)
+	rs.SetState(ContactRequestProcessed)
+}
+
+// Submit creates a contact request with the given data and processes it.
+// The request is rejected if the rate limit of the given IP address is
+// reached, in which case an empty set is returned.
+func contactRequest_Submit(rs m.ContactRequestSet, data m.ContactRequestData) m.ContactRequestSet {
+	if !contactRateLimiter.Allow(data.IPAddress(), time.Now(), ContactRequestRateLimit, ContactRequestRateWindow) {
+		log.Warn("Contact request rate limit reached", "ip", data.IPAddress())
+		return h.ContactRequest().NewSet(rs.Env())
+	}
+	request := h.ContactRequest().NewSet(rs.Env()).AsSuperUser("create public contact request").Create(data)
+	request.ProcessRequest()
+	return request
+}
+
+// GCContactRequests deletes the processed contact requests older than the retention delay
+func autoVacuum_GCContactRequests(rs m.AutoVacuumSet) {
+	days, err := strconv.Atoi(h.ConfigParameter().NewSet(rs.Env()).GetParam("base.contact_request_retention_days",
+		strconv.Itoa(ContactRequestRetentionDays)))
+	if err != nil || days <= 0 {
+		days = ContactRequestRetentionDays
+	}
+	limit := dates.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	res := rs.Env().Cr().Execute(`DELETE FROM contact_request WHERE state = ? AND create_date < ?`,
+		ContactRequestProcessed, limit)
+	n, err := res.RowsAffected()
+	if err != nil {
+		panic(err)
+	}
+	log.Info("GC'd contact requests", "count", n)
+}
+
+func init() {
+	models.NewModel("ContactRequest")
+	h.ContactRequest().SetDefaultOrder("ID desc")
+	h.ContactRequest().AddFields(fields_ContactRequest)
+	h.ContactRequest().NewMethod("CheckEmail", contactRequest_CheckEmail)
+	h.ContactRequest().NewMethod("LinkPartner", contactRequest_LinkPartner)
+	h.ContactRequest().NewMethod("ProcessRequest", contactRequest_ProcessRequest)
+	h.ContactRequest().NewMethod("ActionMarkProcessed", contactRequest_ActionMarkProcessed)
+	h.ContactRequest().NewMethod("Submit", contactRequest_Submit)
+
+	h.AutoVacuum().NewMethod("GCContactRequests", autoVacuum_GCContactRequests)
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestContactRequest(t *testing.T) {
+	Convey("Testing contact requests", t, func() {
+		Convey("The rate limiter counts events over a sliding window", func() {
+			limiter := &rateLimiter{events: make(map[string][]time.Time)}
+			now := time.Now()
+			So(limiter.Allow("1.2.3.4", now, 2, time.Hour), ShouldBeTrue)
+			So(limiter.Allow("1.2.3.4", now.Add(time.Minute), 2, time.Hour), ShouldBeTrue)
+			So(limiter.Allow("1.2.3.4", now.Add(2*time.Minute), 2, time.Hour), ShouldBeFalse)
+			So(limiter.Allow("5.6.7.8", now.Add(2*time.Minute), 2, time.Hour), ShouldBeTrue)
+			So(limiter.Allow("1.2.3.4", now.Add(time.Hour+time.Second), 2, time.Hour), ShouldBeTrue)
+		})
+		Convey("The rate limiter forgets keys without recent events", func() {
+			limiter := &rateLimiter{events: make(map[string][]time.Time)}
+			now := time.Now()
+			limiter.Allow("1.2.3.4", now, 2, time.Hour)
+			limiter.Allow("5.6.7.8", now.Add(2*time.Hour), 2, time.Hour)
+			So(limiter.events, ShouldNotContainKey, "1.2.3.4")
+			So(limiter.events, ShouldContainKey, "5.6.7.8")
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			data := func(ip string) m.ContactRequestData {
+				return h.ContactRequest().NewData().
+					SetName("Jane Prospect").
+					SetEmail("jane@prospect.example").
+					SetMessage("I would like a quote.").
+					SetIPAddress(ip)
+			}
+			Convey("Partners are only created when requests are processed", func() {
+				request := h.ContactRequest().NewSet(env).Submit(data("10.0.0.1"))
+				So(request.Len(), ShouldEqual, 1)
+				So(request.State(), ShouldEqual, ContactRequestNew)
+				So(request.Partner().IsEmpty(), ShouldBeTrue)
+				request.ActionMarkProcessed()
+				So(request.State(), ShouldEqual, ContactRequestProcessed)
+				So(request.Partner().Email(), ShouldEqual, "jane@prospect.example")
+				again := h.ContactRequest().NewSet(env).Submit(data("10.0.0.1"))
+				So(again.Partner().Equals(request.Partner()), ShouldBeTrue)
+				So(h.Partner().Search(env, q.Partner().Email().Equals("jane@prospect.example")).Len(), ShouldEqual, 1)
+			})
+			Convey("Emails are matched exactly", func() {
+				other := h.Partner().Create(env, h.Partner().NewData().
+					SetName("John Other").
+					SetEmail("johnxdoe@prospect.example"))
+				request := h.ContactRequest().NewSet(env).Submit(data("10.0.0.3").SetEmail("john_doe@prospect.example"))
+				So(request.Partner().IsEmpty(), ShouldBeTrue)
+				request = h.ContactRequest().NewSet(env).Submit(data("10.0.0.3").SetEmail(" JohnXDoe@Prospect.example"))
+				So(request.Partner().Equals(other), ShouldBeTrue)
+			})
+			Convey("Requests with an invalid email are rejected", func() {
+				So(func() { h.ContactRequest().NewSet(env).Submit(data("10.0.0.2").SetEmail("not an email")) }, ShouldPanic)
+			})
+			Convey("Requests over the rate limit are discarded", func() {
+				for i := 0; i < ContactRequestRateLimit; i++ {
+					So(h.ContactRequest().NewSet(env).Submit(data("10.0.0.3")).IsNotEmpty(), ShouldBeTrue)
+				}
+				So(h.ContactRequest().NewSet(env).Submit(data("10.0.0.3")).IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,184 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+)
+
+// Context keys used by the base module
+const (
+	ContextKeyLang                      = "lang"
+	ContextKeyTZ                        = "tz"
+	ContextKeyActiveID                  = "active_id"
+	ContextKeyActiveIDs                 = "active_ids"
+	ContextKeyInstallMode               = "install_mode"
+	ContextKeyCompanyID                 = "company_id"
+	ContextKeyForceCompany              = "force_company"
+	ContextKeyForceComputeWrite         = "hexya_force_compute_write"
+	ContextKeyOnchangeOrigin            = "hexya_onchange_origin"
+	ContextKeyForceEmail                = "force_email"
+	ContextKeyNoGravatar                = "no_gravatar"
+	ContextKeyShowAddress               = "show_address"
+	ContextKeyShowAddressOnly           = "show_address_only"
+	ContextKeyShowEmail                 = "show_email"
+	ContextKeyShowVAT                   = "show_vat"
+	ContextKeyHTMLFormat                = "html_format"
+	ContextKeyPartnerSkipSync           = "partner_skip_sync"
+	ContextKeyPartnerCategoryDisplay    = "partner_category_display"
+	ContextKeyCategoryID                = "category_id"
+	ContextKeyCategorySearchDescendants = "category_search_descendants"
+	ContextKeySchemaOrgOrganization     = "schema_org_organization"
+	ContextKeySkipAddressValidation     = "skip_address_validation"
+	ContextKeyActivityNoRedirect        = "activity_no_redirect"
+	ContextKeyAcceptLanguage            = "accept_language"
+	ContextKeyDetectLangText            = "detect_lang_text"
+	ContextKeySetupStep                 = "setup_step"
+	ContextKeyMailServer                = "mail_server_id"
+	// ContextKeyGotoSuper is deprecated: use AsSuperUser instead.
+	ContextKeyGotoSuper = "goto_super"
+)
+
+// ContextKeyPrefixes are the prefixes of context keys built from a field
+// name, which are always considered as known.
+var ContextKeyPrefixes = []string{"default_", "search_default_"}
+
+// A ContextKeyInfo documents a context key
+type ContextKeyInfo struct {
+	Name string
+	// Type is the Go type of the value, e.g. "bool", "string" or "int64"
+	Type string
+	Help string
+}
+
+var contextKeys = struct {
+	sync.RWMutex
+	infos  map[string]ContextKeyInfo
+	warned map[string]bool
+}{
+	infos:  make(map[string]ContextKeyInfo),
+	warned: make(map[string]bool),
+}
+
+// RegisterContextKey registers a context key with its type and documentation.
+// Reading a key which is not registered with the ContextXXX accessors logs a
+// warning, so that typos and undocumented keys are caught early.
+// It panics if the key is already registered.
+func RegisterContextKey(name, typ, help string) {
+	contextKeys.Lock()
+	defer contextKeys.Unlock()
+	if _, exists := contextKeys.infos[name]; exists {
+		log.Panic("Context key already registered", "name", name)
+	}
+	contextKeys.infos[name] = ContextKeyInfo{Name: name, Type: typ, Help: help}
+}
+
+// GetContextKey returns the documentation of the given context key and
+// whether it is registered.
+func GetContextKey(name string) (ContextKeyInfo, bool) {
+	contextKeys.RLock()
+	defer contextKeys.RUnlock()
+	info, ok := contextKeys.infos[name]
+	return info, ok
+}
+
+// ContextKeys returns the documentation of all registered context keys,
+// sorted by name.
+func ContextKeys() []ContextKeyInfo {
+	contextKeys.RLock()
+	defer contextKeys.RUnlock()
+	res := make([]ContextKeyInfo, 0, len(contextKeys.infos))
+	for _, info := range contextKeys.infos {
+		res = append(res, info)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// checkContextKey logs a warning the first time an unknown context key is read
+func checkContextKey(name string) {
+	for _, prefix := range ContextKeyPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return
+		}
+	}
+	contextKeys.RLock()
+	_, known := contextKeys.infos[name]
+	warned := contextKeys.warned[name]
+	contextKeys.RUnlock()
+	if known || warned {
+		return
+	}
+	contextKeys.Lock()
+	contextKeys.warned[name] = true
+	contextKeys.Unlock()
+	log.Warn("Unknown context key, register it with RegisterContextKey", "key", name)
+}
+
+// ContextHasKey returns true if the given key is set in the context of env
+func ContextHasKey(env models.Environment, key string) bool {
+	checkContextKey(key)
+	return env.Context().HasKey(key)
+}
+
+// ContextGet returns the value of the given key in the context of env
+func ContextGet(env models.Environment, key string) interface{} {
+	checkContextKey(key)
+	return env.Context().Get(key)
+}
+
+// ContextGetBool returns the value of the given key in the context of env as a bool
+func ContextGetBool(env models.Environment, key string) bool {
+	checkContextKey(key)
+	return env.Context().GetBool(key)
+}
+
+// ContextGetString returns the value of the given key in the context of env as a string
+func ContextGetString(env models.Environment, key string) string {
+	checkContextKey(key)
+	return env.Context().GetString(key)
+}
+
+// ContextGetInteger returns the value of the given key in the context of env as an int64
+func ContextGetInteger(env models.Environment, key string) int64 {
+	checkContextKey(key)
+	return env.Context().GetInteger(key)
+}
+
+func init() {
+	RegisterContextKey(ContextKeyLang, "string", "Language code used for translations")
+	RegisterContextKey(ContextKeyTZ, "string", "Timezone used to display dates")
+	RegisterContextKey(ContextKeyActiveID, "int64", "ID of the record opened in the client")
+	RegisterContextKey(ContextKeyActiveIDs, "[]int64", "IDs of the records selected in the client")
+	RegisterContextKey(ContextKeyInstallMode, "bool", "Set while installing modules and loading data")
+	RegisterContextKey(ContextKeyCompanyID, "int64", "ID of the company to work for")
+	RegisterContextKey(ContextKeyForceCompany, "int64", "ID of the company used for company dependent fields and sequences")
+	RegisterContextKey(ContextKeyForceComputeWrite, "bool", "Allows writing computed fields")
+	RegisterContextKey(ContextKeyOnchangeOrigin, "RecordData", "Values of the record before the onchange")
+	RegisterContextKey(ContextKeyForceEmail, "bool", "Partner.NameCreate fails if no email address is given")
+	RegisterContextKey(ContextKeyNoGravatar, "bool", "Do not fetch the Gravatar image of partners")
+	RegisterContextKey(ContextKeyShowAddress, "bool", "Append the address to the partner display name")
+	RegisterContextKey(ContextKeyShowAddressOnly, "bool", "Display the address of the partner instead of its name")
+	RegisterContextKey(ContextKeyShowEmail, "bool", "Display the email of the partner with its name")
+	RegisterContextKey(ContextKeyShowVAT, "bool", "Display the VAT number of the partner with its name")
+	RegisterContextKey(ContextKeyHTMLFormat, "bool", "Format the partner display name as HTML")
+	RegisterContextKey(ContextKeyPartnerSkipSync, "bool", "Do not synchronise commercial and address fields of partners")
+	RegisterContextKey(ContextKeyPartnerCategoryDisplay, "string", `Set to "short" to display partner tags without their parents`)
+	RegisterContextKey(ContextKeyCategoryID, "int64", "ID of the default tag of new partners")
+	RegisterContextKey(ContextKeyCategorySearchDescendants, "bool", "Whether searching partners by tag also matches child tags")
+	RegisterContextKey(ContextKeySchemaOrgOrganization, "bool", "Export the partner as a schema.org Organization")
+	RegisterContextKey(ContextKeySkipAddressValidation, "bool", "Do not validate partner addresses")
+	RegisterContextKey(ContextKeyActivityNoRedirect, "bool", "Do not redirect activities of absent users to their delegate or backup")
+	RegisterContextKey(ContextKeyAcceptLanguage, "string", "Accept-Language header of the browser, used to set the language of new partners, e.g. on portal signup")
+	RegisterContextKey(ContextKeyDetectLangText, "string", "Text written by new partners, e.g. an inbound email, used to detect their language")
+	RegisterContextKey(ContextKeySetupStep, "string", "Name of the step of the setup wizard being validated")
+	RegisterContextKey(ContextKeyMailServer, "int64", "ID of the MailServer used to send emails")
+	RegisterContextKey(ContextKeyGotoSuper, "bool", "Deprecated and ignored: use AsSuperUser to run a method as superuser")
+}
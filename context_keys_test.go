@@ -0,0 +1,39 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestContextKeys(t *testing.T) {
+	Convey("Testing the context keys registry", t, func() {
+		Convey("Base keys are documented", func() {
+			info, ok := GetContextKey(ContextKeyShowAddress)
+			So(ok, ShouldBeTrue)
+			So(info.Type, ShouldEqual, "bool")
+			So(info.Help, ShouldNotBeBlank)
+			keys := ContextKeys()
+			for i := 1; i < len(keys); i++ {
+				So(keys[i-1].Name, ShouldBeLessThan, keys[i].Name)
+			}
+		})
+		Convey("Keys cannot be registered twice", func() {
+			So(func() { RegisterContextKey(ContextKeyLang, "string", "") }, ShouldPanic)
+		})
+		Convey("Accessors read the context", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				partners := h.Partner().NewSet(env).WithContext(ContextKeyShowEmail, true)
+				So(ContextHasKey(partners.Env(), ContextKeyShowEmail), ShouldBeTrue)
+				So(ContextGetBool(partners.Env(), ContextKeyShowEmail), ShouldBeTrue)
+				So(ContextGetBool(partners.Env(), "unregistered_test_key"), ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,668 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erlangs/okoo/src/controllers"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/okoo/src/server"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+)
+
+// companyThemeCSS returns the theme CSS of the company with the given ID,
+// or an empty string if there is no such company.
+func companyThemeCSS(companyID int64) string {
+	var css string
+	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		company := h.Company().Search(env, q.Company().ID().Equals(companyID))
+		if company.IsEmpty() {
+			return
+		}
+		css = company.GetThemeCSS()
+	})
+	return css
+}
+
+// CompanyThemeCSS serves the theme CSS variables of the company given by its ID in the URL.
+//
+// This endpoint is intentionally public: the login page and the public pages
+// are branded before any user is authenticated. It only discloses the theme
+// colors of the company, which are shown on these pages anyway.
+func CompanyThemeCSS(c *server.Context) {
+	companyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	css := companyThemeCSS(companyID)
+	if css == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "text/css; charset=utf-8", []byte(css))
+}
+
+// UserCalendarFeed serves the ICS calendar feed of the user given by its calendar token in the URL.
+func UserCalendarFeed(c *server.Context) {
+	token := c.Param("token")
+	if token == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	var uid int64
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		user := h.User().Search(env, q.User().CalendarToken().Equals(token))
+		if user.Len() == 1 {
+			uid = user.ID()
+		}
+	})
+	if err != nil || uid == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	var ics string
+	// Compute the feed as the user so that access rights apply
+	err = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		ics = h.User().NewSet(env).CurrentUser().CalendarFeed()
+	})
+	if err != nil || ics == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(ics))
+}
+
+// ComputeDependencies serves the compute dependency graph of the registry as JSON,
+// for debugging recompute storms. If the 'model' query parameter is given, only the
+// dependencies of the fields of this model are returned. It is only available to
+// members of the Settings group.
+func ComputeDependencies(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	var allowed bool
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		allowed = h.User().NewSet(env).CurrentUser().IsSystem()
+	})
+	if err != nil || !allowed {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	graph := ComputeDependencyGraph()
+	if model := c.Query("model"); model != "" {
+		edges := []DependencyEdge{}
+		for _, edge := range graph.Edges {
+			if strings.HasPrefix(edge.Field, model+".") {
+				edges = append(edges, edge)
+			}
+		}
+		graph.Edges = edges
+	}
+	c.JSON(http.StatusOK, graph)
+}
+
+// healthResponse is the JSON response of the Health and SystemChecks controllers
+type healthResponse struct {
+	Status string              `json:"status"`
+	Checks []SystemCheckResult `json:"checks,omitempty"`
+}
+
+// Health is a cheap public liveness probe for load balancers. It only checks
+// that the database answers and replies with the 503 status otherwise.
+// The detailed system checks are served by SystemChecks.
+func Health(c *server.Context) {
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		var one int
+		env.Cr().Get(&one, "SELECT 1")
+	})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, healthResponse{Status: SystemCheckError})
+		return
+	}
+	c.JSON(http.StatusOK, healthResponse{Status: SystemCheckOK})
+}
+
+// SystemChecks serves the results of the system checks as JSON to the
+// members of the Settings group. It answers with the 503 status if a check
+// has the error status, so that it can be used by monitoring probes.
+// Results are cached for SystemChecksCacheDuration.
+func SystemChecks(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	var isAdmin bool
+	models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		isAdmin = h.User().NewSet(env).CurrentUser().IsSystem()
+	})
+	if !isAdmin {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	results, err := CachedSystemChecks()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, healthResponse{Status: SystemCheckError})
+		return
+	}
+	status := SystemCheckStatus(results)
+	code := http.StatusOK
+	if status == SystemCheckError {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, healthResponse{Status: status, Checks: results})
+}
+
+// SMSStatusCallback receives the delivery reports of the SMS provider given
+// by its name in the URL and updates the state of the corresponding messages.
+func SMSStatusCallback(c *server.Context) {
+	providerName := c.Param("provider")
+	provider, ok := GetSMSProvider(providerName)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	ref, state, message, err := provider.ParseStatusCallback(c.Request)
+	if err != nil {
+		log.Warn("Invalid SMS delivery report", "provider", providerName, "error", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	var found bool
+	err = models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		found = h.SMSMessage().NewSet(env).UpdateDeliveryState(providerName, ref, state, message)
+	})
+	switch {
+	case err != nil:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	case !found:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte("OK"))
+	}
+}
+
+// IMWebhook receives the messages sent to the instant messaging channel given
+// by its name in the URL. GET requests are passed to the channel for webhook
+// verification if it implements IMWebhookVerifier.
+func IMWebhook(c *server.Context) {
+	channelName := c.Param("channel")
+	channel, ok := GetIMChannel(channelName)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if c.Request.Method == http.MethodGet {
+		verifier, ok := channel.(IMWebhookVerifier)
+		if !ok {
+			c.AbortWithStatus(http.StatusMethodNotAllowed)
+			return
+		}
+		body, valid := verifier.VerifyWebhook(c.Request)
+		if !valid {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(body))
+		return
+	}
+	messages, err := channel.ParseWebhook(c.Request)
+	if err != nil {
+		log.Warn("Invalid instant messaging webhook request", "channel", channelName, "error", err)
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		h.IMMessage().NewSet(env).Receive(channelName, messages)
+	})
+	if err != nil {
+		log.Warn("Unable to record instant messages", "channel", channelName, "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte("OK"))
+}
+
+// SharedContent serves the record or attachment shared by the share link
+// given by its token and signature in the URL. Each access is logged on the link.
+func SharedContent(c *server.Context) {
+	var (
+		contentType string
+		content     []byte
+	)
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		link := h.ShareLink().NewSet(env).FindValid(c.Param("token"), c.Param("signature"))
+		if link.IsEmpty() {
+			return
+		}
+		contentType, content = link.Content()
+		if content != nil {
+			link.LogAccess(c.ClientIP(), c.Request.UserAgent())
+		}
+	})
+	if err != nil || content == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, contentType, content)
+}
+
+// signingResponse is the JSON response of the signing controllers
+type signingResponse struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Request  string `json:"request,omitempty"`
+	Document string `json:"document,omitempty"`
+	Signer   string `json:"signer,omitempty"`
+}
+
+// SigningPage serves the description of the signature request of the signer
+// given by its signing token in the URL, and records in the audit trail that
+// the signer viewed it. Adding 'document' to the URL serves the document to
+// sign instead.
+func SigningPage(c *server.Context) {
+	var (
+		res         *signingResponse
+		contentType string
+		content     []byte
+	)
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		signer := h.SignatureRequestSigner().NewSet(env).FindByToken(c.Param("token"))
+		if signer.IsEmpty() {
+			return
+		}
+		document := signer.Request().Document()
+		if c.Param("document") != "" {
+			data, err := base64.StdEncoding.DecodeString(document.Datas())
+			if err != nil {
+				log.Warn("Unable to decode document to sign", "attachment", document.ID(), "error", err)
+				return
+			}
+			contentType, content = document.MimeType(), data
+			return
+		}
+		signer.MarkViewed(c.ClientIP())
+		res = &signingResponse{
+			Status:   "ok",
+			Request:  signer.Request().Name(),
+			Document: document.Name(),
+			Signer:   signer.Partner().Name(),
+		}
+	})
+	switch {
+	case err != nil:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	case content != nil:
+		c.Data(http.StatusOK, contentType, content)
+	case res == nil:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// SignDocument records the decision posted by the signer given by its
+// signing token in the URL. The form must have either a signature field to
+// sign the document, or a refuse field with the reason of the refusal.
+func SignDocument(c *server.Context) {
+	token := c.Param("token")
+	signature := c.PostForm("signature")
+	reason, refuse := c.GetPostForm("refuse")
+	if !refuse && strings.TrimSpace(signature) == "" {
+		c.JSON(http.StatusBadRequest, signingResponse{Status: "error", Error: "signature or refuse is required"})
+		return
+	}
+	var found bool
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		signer := h.SignatureRequestSigner().NewSet(env).FindByToken(token)
+		if signer.IsEmpty() {
+			return
+		}
+		found = true
+		if refuse {
+			signer.Refuse(token, reason, c.ClientIP())
+			return
+		}
+		signer.Sign(token, signature, c.ClientIP())
+	})
+	switch {
+	case !found:
+		c.AbortWithStatus(http.StatusNotFound)
+	case err != nil:
+		data, ok := UserErrorData(err)
+		if !ok {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.JSON(http.StatusBadRequest, signingResponse{Status: "error", Error: data["message"].(string)})
+	default:
+		c.JSON(http.StatusOK, signingResponse{Status: "ok"})
+	}
+}
+
+// contactResponse is the JSON response of the ContactUs controller
+type contactResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ContactUs records the contact request posted by the public contact form.
+// The form must have name, email and message fields, and may have phone,
+// company and subject fields. Requests filling in the hidden honeypot field
+// are answered as accepted but discarded. Too many requests from the same
+// IP address are answered with the 429 status.
+func ContactUs(c *server.Context) {
+	if c.PostForm(ContactHoneypotField) != "" {
+		log.Info("Discarding contact request caught by the honeypot", "ip", c.ClientIP())
+		c.JSON(http.StatusOK, contactResponse{Status: "ok"})
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	email := strings.TrimSpace(c.PostForm("email"))
+	message := strings.TrimSpace(c.PostForm("message"))
+	if name == "" || email == "" || message == "" {
+		c.JSON(http.StatusBadRequest, contactResponse{Status: "error", Error: "name, email and message are required"})
+		return
+	}
+	var created bool
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		request := h.ContactRequest().NewSet(env).Submit(h.ContactRequest().NewData().
+			SetName(name).
+			SetEmail(email).
+			SetPhone(c.PostForm("phone")).
+			SetCompanyName(c.PostForm("company")).
+			SetSubject(c.PostForm("subject")).
+			SetMessage(message).
+			SetIPAddress(c.ClientIP()).
+			SetUserAgent(c.Request.UserAgent()).
+			SetAcceptLanguage(c.GetHeader("Accept-Language")))
+		created = request.IsNotEmpty()
+	})
+	switch {
+	case err != nil:
+		log.Warn("Unable to record contact request", "ip", c.ClientIP(), "error", err)
+		c.JSON(http.StatusBadRequest, contactResponse{Status: "error", Error: "invalid contact request"})
+	case !created:
+		c.JSON(http.StatusTooManyRequests, contactResponse{Status: "error", Error: "too many requests"})
+	default:
+		c.JSON(http.StatusOK, contactResponse{Status: "ok"})
+	}
+}
+
+// DynamicSelectionOptions serves the options of the dynamic selection field
+// given by its model and field names in the URL as a JSON list sorted by label.
+// If the 'refresh' query parameter is set and the user is an administrator, the
+// cached options are invalidated first, so that the options added by providers
+// since are returned.
+func DynamicSelectionOptions(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	model, field := c.Param("model"), c.Param("field")
+	if c.Query("refresh") != "" {
+		var isAdmin bool
+		err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+			isAdmin = h.User().NewSet(env).CurrentUser().IsAdmin()
+		})
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		InvalidateSelectionOptions(model, field)
+	}
+	options := SelectionOptions(model, field)
+	if options == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, sortedSelectionOptions(options))
+}
+
+// announcementNotification is an announcement as served by the Notifications controller
+type announcementNotification struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	Message     string `json:"message"`
+	Severity    string `json:"severity"`
+	Dismissible bool   `json:"dismissible"`
+}
+
+// notificationsResponse is the JSON response of the Notifications controller
+type notificationsResponse struct {
+	Announcements []announcementNotification `json:"announcements"`
+}
+
+// Notifications serves the notifications of the logged-in user as JSON, that
+// is the running announcements targeting them which they have not dismissed.
+func Notifications(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	res := notificationsResponse{Announcements: []announcementNotification{}}
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		user := h.User().NewSet(env).CurrentUser()
+		for _, announcement := range h.Announcement().NewSet(env).ForUser(user).Records() {
+			res.Announcements = append(res.Announcements, announcementNotification{
+				ID:          announcement.ID(),
+				Title:       announcement.Name(),
+				Message:     announcement.Message(),
+				Severity:    announcement.Severity(),
+				Dismissible: announcement.Dismissible(),
+			})
+		}
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// DismissAnnouncement hides the announcement given by its ID in the URL for the logged-in user
+func DismissAnnouncement(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	var found bool
+	err = models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		announcement := h.Announcement().Search(env, q.Announcement().ID().Equals(id))
+		if announcement.IsEmpty() {
+			return
+		}
+		found = true
+		announcement.Dismiss()
+	})
+	switch {
+	case err != nil:
+		c.AbortWithStatus(http.StatusBadRequest)
+	case !found:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte("OK"))
+	}
+}
+
+// MethodSignatures serves as JSON the signatures of the methods of the model
+// given by its name in the URL that the logged-in user is allowed to execute,
+// so that API layers and external code generators can call them.
+func MethodSignatures(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	var (
+		signatures []MethodSignature
+		found      bool
+	)
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		signatures, found = ModelMethodSignatures(env, c.Param("model"))
+	})
+	switch {
+	case err != nil:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	case !found:
+		c.AbortWithStatus(http.StatusNotFound)
+	default:
+		c.JSON(http.StatusOK, signatures)
+	}
+}
+
+// Metrics serves the metrics of all registered collectors in the Prometheus
+// text exposition format. The request must be authenticated with HTTP basic
+// authentication against the Metrics.User configuration key, unless
+// Metrics.Public is set.
+func Metrics(c *server.Context) {
+	if !checkMetricsAuth(c) {
+		c.Header("WWW-Authenticate", `Basic realm="metrics"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	var b strings.Builder
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		CollectMetrics(env, &b)
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// SearchEverywhere returns the records of all the models registered for the
+// global search matching the 'q' query parameter as a JSON list ranked by
+// relevance. The 'limit' query parameter sets the maximum number of results.
+func SearchEverywhere(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	var results []GlobalSearchResult
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		results = GlobalSearch(env, c.Query("q"), limit)
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// currencyRateResponse is the JSON response of the CurrencyRateAt controller
+type currencyRateResponse struct {
+	Currency string  `json:"currency"`
+	Date     string  `json:"date"`
+	Rate     float64 `json:"rate"`
+}
+
+// CurrencyRateAt serves the rate of the currency given by its code in the URL
+// at the date given by the 'date' query parameter (YYYY-MM-DD), or today if
+// it is not set. The optional 'company_id' query parameter selects the company
+// whose rates are used instead of the company of the logged-in user.
+func CurrencyRateAt(c *server.Context) {
+	uid, ok := c.Session().Get("uid").(int64)
+	if !ok || uid == 0 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	date := dates.Today()
+	if c.Query("date") != "" {
+		t, err := time.Parse(CurrencyRateDateFormat, c.Query("date"))
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		date = dates.Date{Time: t}
+	}
+	var (
+		res       *currencyRateResponse
+		forbidden bool
+	)
+	err := models.ExecuteInNewEnvironment(uid, func(env models.Environment) {
+		currency := h.Currency().NewSet(env).WithContext("active_test", false).
+			Search(q.Currency().Name().Equals(strings.ToUpper(c.Param("code"))))
+		if currency.IsEmpty() {
+			return
+		}
+		if companyID, err := strconv.ParseInt(c.Query("company_id"), 10, 64); err == nil {
+			user := h.User().NewSet(env).CurrentUser()
+			if user.Companies().Union(user.Company()).Intersect(h.Company().BrowseOne(env, companyID)).IsEmpty() {
+				forbidden = true
+				return
+			}
+			currency = currency.WithContext("company_id", companyID)
+		}
+		res = &currencyRateResponse{
+			Currency: currency.Name(),
+			Date:     date.Format(CurrencyRateDateFormat),
+			Rate:     currency.RateAt(date.ToDateTime()),
+		}
+	})
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if forbidden {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	if res == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func init() {
+	root := controllers.Registry
+	root.AddController(http.MethodGet, "/web/company/:id/theme.css", reportControllerErrors(CompanyThemeCSS))
+	root.AddController(http.MethodGet, "/calendar/feed/:token/calendar.ics", reportControllerErrors(UserCalendarFeed))
+	root.AddController(http.MethodGet, "/debug/compute_dependencies", reportControllerErrors(ComputeDependencies))
+	root.AddController(http.MethodGet, "/healthz", reportControllerErrors(Health))
+	root.AddController(http.MethodGet, "/healthz/checks", reportControllerErrors(SystemChecks))
+	root.AddController(http.MethodPost, "/sms/status/:provider", reportControllerErrors(SMSStatusCallback))
+	root.AddController(http.MethodGet, "/im/webhook/:channel", reportControllerErrors(IMWebhook))
+	root.AddController(http.MethodPost, "/im/webhook/:channel", reportControllerErrors(IMWebhook))
+	root.AddController(http.MethodGet, "/share/:token/:signature", reportControllerErrors(SharedContent))
+	root.AddController(http.MethodGet, "/sign/:token", reportControllerErrors(SigningPage))
+	root.AddController(http.MethodGet, "/sign/:token/:document", reportControllerErrors(SigningPage))
+	root.AddController(http.MethodPost, "/sign/:token", reportControllerErrors(SignDocument))
+	root.AddController(http.MethodPost, "/contactus", reportControllerErrors(ContactUs))
+	root.AddController(http.MethodGet, "/web/selection/:model/:field", reportControllerErrors(DynamicSelectionOptions))
+	root.AddController(http.MethodGet, "/web/notifications", reportControllerErrors(Notifications))
+	root.AddController(http.MethodPost, "/web/announcements/:id/dismiss", reportControllerErrors(DismissAnnouncement))
+	root.AddController(http.MethodGet, "/web/api/methods/:model", reportControllerErrors(MethodSignatures))
+	root.AddController(http.MethodGet, "/metrics", reportControllerErrors(Metrics))
+	root.AddController(http.MethodGet, "/web/search", reportControllerErrors(SearchEverywhere))
+	root.AddController(http.MethodGet, "/web/currency/:code/rate", reportControllerErrors(CurrencyRateAt))
+}
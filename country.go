@@ -8,6 +8,7 @@ import (
 	"github.com/erlangs/okoo/src/models/fields"
 	"github.com/erlangs/okoo/src/models/types"
 	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
 )
 
 var fields_CountryGroup = map[string]models.FieldDefinition{
@@ -23,18 +24,24 @@ var fields_CountryState = map[string]models.FieldDefinition{
 		Help: "The state code in max. three chars.", Required: true},
 }
 
+// DefaultAddressFormat is the address format used for countries that do not define one
+const DefaultAddressFormat = "{{ .Street }}\n{{ .Street2 }}\n{{ .City }} {{ .StateCode }} {{ .Zip }}\n{{ .CountryName }}"
+
 var fields_Country = map[string]models.FieldDefinition{
 	"Name": fields.Char{String: "Country Name", Help: "The full name of the country.", Translate: true, Required: true, Unique: true},
 	"Code": fields.Char{String: "Country Code", Size: 2, Unique: true, Help: "The ISO country code in two chars.\nYou can use this field for quick search."},
-	"AddressFormat": fields.Text{Default: func(env models.Environment) interface{} {
-		return "{{ .Street }}\n{{ .Street2 }}\n{{ .City }} {{ .StateCode }} {{ .Zip }}\n{{ .CountryName }}"
-	}, Help: `You can state here the usual format to use for the addresses belonging to this country.
+	"AddressFormat": fields.Text{Default: models.DefaultValue(DefaultAddressFormat),
+		Constraint: h.Country().Methods().CheckAddressFormat(), Help: `You can state here the usual format to use for the addresses belonging to this country.
 You can use Go-style string pattern with all the fields of the address 
 (for example, use '{{ .Street }}' to display the field 'Street') plus
 {{ .StateName }}: the name of the state
 {{ .StateCode }}: the code of the state
 {{ .CountryName }}: the name of the country
 {{ .CountryCode }}: the code of the country
+The following functions are also available: upper, lower, title, trim,
+truncate (e.g. '{{ truncate 10 .City }}'), stateOrCountry (e.g. '{{ stateOrCountry .StateName .CountryName }}'),
+prefix (e.g. '{{ prefix "ZIP " .Zip }}') and join (e.g. '{{ join " " .City .Zip }}').
+Blank lines are removed from the rendered address.
 `},
 	"AddressViewID": fields.Char{String: "Input View", Help: `Use this field if you want to replace the usual way to encode a complete address.
 Note that the address_format field is used to modify the way to display addresses
@@ -45,6 +52,8 @@ addresses.`},
 	"PhoneCode":     fields.Integer{String: "Country Calling Code"},
 	"CountryGroups": fields.Many2Many{RelationModel: h.CountryGroup()},
 	"States":        fields.One2Many{RelationModel: h.CountryState(), ReverseFK: "Country"},
+	"StatesLoaded": fields.Boolean{ReadOnly: true, NoCopy: true,
+		Help: "Set once the ISO 3166-2 states of this country have been loaded"},
 	"NamePosition": fields.Selection{Selection: types.Selection{
 		"before": "Before Address",
 		"after":  "After Address",
@@ -53,14 +62,31 @@ addresses.`},
 	"VATLabel": fields.Char{Translate: true, Help: "Use this field if you want to change vat label."},
 }
 
+// CheckAddressFormat checks that the address format is a valid template
+func country_CheckAddressFormat(rs m.CountrySet) {
+	for _, country := range rs.Records() {
+		if country.AddressFormat() == "" {
+			continue
+		}
+		if _, err := DefaultTemplateSandbox.Parse(country.AddressFormat()); err != nil {
+			log.Panic(rs.T("Invalid address format for %s: %s", country.Name(), err))
+		}
+	}
+}
+
 func init() {
 	models.NewModel("CountryGroup")
 	h.CountryGroup().AddFields(fields_CountryGroup)
 
 	models.NewModel("CountryState")
+	h.CountryState().InheritModel(h.ReferenceCacheMixin())
 	h.CountryState().AddFields(fields_CountryState)
 	h.CountryState().AddSQLConstraint("name_code_uniq", "unique(country_id, code)", "The code of the state must be unique by country !")
 
 	models.NewModel("Country")
+	h.Country().InheritModel(h.ReferenceCacheMixin())
+	h.Country().InheritModel(h.ReferenceDataMixin())
 	h.Country().AddFields(fields_Country)
+
+	h.Country().NewMethod("CheckAddressFormat", country_CheckAddressFormat)
 }
@@ -0,0 +1,288 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+// isoCountryStates holds the ISO 3166-2 subdivisions loaded by Country.LoadStates,
+// indexed by country code. Codes are the part of the ISO 3166-2 code after the
+// country prefix (e.g. "ON" for "CA-ON").
+var isoCountryStates = map[string][]isoCountryState{
+	"AT": {
+		{Code: "1", Name: "Burgenland"},
+		{Code: "2", Name: "Kärnten"},
+		{Code: "3", Name: "Niederösterreich"},
+		{Code: "4", Name: "Oberösterreich"},
+		{Code: "5", Name: "Salzburg"},
+		{Code: "6", Name: "Steiermark"},
+		{Code: "7", Name: "Tirol"},
+		{Code: "8", Name: "Vorarlberg"},
+		{Code: "9", Name: "Wien"},
+	},
+	"AU": {
+		{Code: "ACT", Name: "Australian Capital Territory"},
+		{Code: "NSW", Name: "New South Wales"},
+		{Code: "NT", Name: "Northern Territory"},
+		{Code: "QLD", Name: "Queensland"},
+		{Code: "SA", Name: "South Australia"},
+		{Code: "TAS", Name: "Tasmania"},
+		{Code: "VIC", Name: "Victoria"},
+		{Code: "WA", Name: "Western Australia"},
+	},
+	"BE": {
+		{Code: "VAN", Name: "Antwerpen"},
+		{Code: "WBR", Name: "Brabant wallon"},
+		{Code: "BRU", Name: "Bruxelles-Capitale"},
+		{Code: "WHT", Name: "Hainaut"},
+		{Code: "VLI", Name: "Limburg"},
+		{Code: "WLG", Name: "Liège"},
+		{Code: "WLX", Name: "Luxembourg"},
+		{Code: "WNA", Name: "Namur"},
+		{Code: "VOV", Name: "Oost-Vlaanderen"},
+		{Code: "VBR", Name: "Vlaams-Brabant"},
+		{Code: "VWV", Name: "West-Vlaanderen"},
+	},
+	"BR": {
+		{Code: "AC", Name: "Acre"},
+		{Code: "AL", Name: "Alagoas"},
+		{Code: "AP", Name: "Amapá"},
+		{Code: "AM", Name: "Amazonas"},
+		{Code: "BA", Name: "Bahia"},
+		{Code: "CE", Name: "Ceará"},
+		{Code: "DF", Name: "Distrito Federal"},
+		{Code: "ES", Name: "Espírito Santo"},
+		{Code: "GO", Name: "Goiás"},
+		{Code: "MA", Name: "Maranhão"},
+		{Code: "MT", Name: "Mato Grosso"},
+		{Code: "MS", Name: "Mato Grosso do Sul"},
+		{Code: "MG", Name: "Minas Gerais"},
+		{Code: "PA", Name: "Pará"},
+		{Code: "PB", Name: "Paraíba"},
+		{Code: "PR", Name: "Paraná"},
+		{Code: "PE", Name: "Pernambuco"},
+		{Code: "PI", Name: "Piauí"},
+		{Code: "RJ", Name: "Rio de Janeiro"},
+		{Code: "RN", Name: "Rio Grande do Norte"},
+		{Code: "RS", Name: "Rio Grande do Sul"},
+		{Code: "RO", Name: "Rondônia"},
+		{Code: "RR", Name: "Roraima"},
+		{Code: "SC", Name: "Santa Catarina"},
+		{Code: "SP", Name: "São Paulo"},
+		{Code: "SE", Name: "Sergipe"},
+		{Code: "TO", Name: "Tocantins"},
+	},
+	"CA": {
+		{Code: "AB", Name: "Alberta"},
+		{Code: "BC", Name: "British Columbia"},
+		{Code: "MB", Name: "Manitoba"},
+		{Code: "NB", Name: "New Brunswick"},
+		{Code: "NL", Name: "Newfoundland and Labrador"},
+		{Code: "NS", Name: "Nova Scotia"},
+		{Code: "NT", Name: "Northwest Territories"},
+		{Code: "NU", Name: "Nunavut"},
+		{Code: "ON", Name: "Ontario"},
+		{Code: "PE", Name: "Prince Edward Island"},
+		{Code: "QC", Name: "Quebec"},
+		{Code: "SK", Name: "Saskatchewan"},
+		{Code: "YT", Name: "Yukon"},
+	},
+	"CH": {
+		{Code: "AG", Name: "Aargau"},
+		{Code: "AR", Name: "Appenzell Ausserrhoden"},
+		{Code: "AI", Name: "Appenzell Innerrhoden"},
+		{Code: "BL", Name: "Basel-Landschaft"},
+		{Code: "BS", Name: "Basel-Stadt"},
+		{Code: "BE", Name: "Bern"},
+		{Code: "FR", Name: "Fribourg"},
+		{Code: "GE", Name: "Genève"},
+		{Code: "GL", Name: "Glarus"},
+		{Code: "GR", Name: "Graubünden"},
+		{Code: "JU", Name: "Jura"},
+		{Code: "LU", Name: "Luzern"},
+		{Code: "NE", Name: "Neuchâtel"},
+		{Code: "NW", Name: "Nidwalden"},
+		{Code: "OW", Name: "Obwalden"},
+		{Code: "SG", Name: "Sankt Gallen"},
+		{Code: "SH", Name: "Schaffhausen"},
+		{Code: "SZ", Name: "Schwyz"},
+		{Code: "SO", Name: "Solothurn"},
+		{Code: "TG", Name: "Thurgau"},
+		{Code: "TI", Name: "Ticino"},
+		{Code: "UR", Name: "Uri"},
+		{Code: "VS", Name: "Valais"},
+		{Code: "VD", Name: "Vaud"},
+		{Code: "ZG", Name: "Zug"},
+		{Code: "ZH", Name: "Zürich"},
+	},
+	"DE": {
+		{Code: "BW", Name: "Baden-Württemberg"},
+		{Code: "BY", Name: "Bayern"},
+		{Code: "BE", Name: "Berlin"},
+		{Code: "BB", Name: "Brandenburg"},
+		{Code: "HB", Name: "Bremen"},
+		{Code: "HH", Name: "Hamburg"},
+		{Code: "HE", Name: "Hessen"},
+		{Code: "MV", Name: "Mecklenburg-Vorpommern"},
+		{Code: "NI", Name: "Niedersachsen"},
+		{Code: "NW", Name: "Nordrhein-Westfalen"},
+		{Code: "RP", Name: "Rheinland-Pfalz"},
+		{Code: "SL", Name: "Saarland"},
+		{Code: "SN", Name: "Sachsen"},
+		{Code: "ST", Name: "Sachsen-Anhalt"},
+		{Code: "SH", Name: "Schleswig-Holstein"},
+		{Code: "TH", Name: "Thüringen"},
+	},
+	"FR": {
+		{Code: "ARA", Name: "Auvergne-Rhône-Alpes"},
+		{Code: "BFC", Name: "Bourgogne-Franche-Comté"},
+		{Code: "BRE", Name: "Bretagne"},
+		{Code: "CVL", Name: "Centre-Val de Loire"},
+		{Code: "20R", Name: "Corse"},
+		{Code: "GES", Name: "Grand Est"},
+		{Code: "HDF", Name: "Hauts-de-France"},
+		{Code: "IDF", Name: "Île-de-France"},
+		{Code: "NOR", Name: "Normandie"},
+		{Code: "NAQ", Name: "Nouvelle-Aquitaine"},
+		{Code: "OCC", Name: "Occitanie"},
+		{Code: "PDL", Name: "Pays de la Loire"},
+		{Code: "PAC", Name: "Provence-Alpes-Côte d'Azur"},
+	},
+	"IN": {
+		{Code: "AN", Name: "Andaman and Nicobar Islands"},
+		{Code: "AP", Name: "Andhra Pradesh"},
+		{Code: "AR", Name: "Arunachal Pradesh"},
+		{Code: "AS", Name: "Assam"},
+		{Code: "BR", Name: "Bihar"},
+		{Code: "CH", Name: "Chandigarh"},
+		{Code: "CT", Name: "Chhattisgarh"},
+		{Code: "DH", Name: "Dadra and Nagar Haveli and Daman and Diu"},
+		{Code: "DL", Name: "Delhi"},
+		{Code: "GA", Name: "Goa"},
+		{Code: "GJ", Name: "Gujarat"},
+		{Code: "HR", Name: "Haryana"},
+		{Code: "HP", Name: "Himachal Pradesh"},
+		{Code: "JK", Name: "Jammu and Kashmir"},
+		{Code: "JH", Name: "Jharkhand"},
+		{Code: "KA", Name: "Karnataka"},
+		{Code: "KL", Name: "Kerala"},
+		{Code: "LA", Name: "Ladakh"},
+		{Code: "LD", Name: "Lakshadweep"},
+		{Code: "MP", Name: "Madhya Pradesh"},
+		{Code: "MH", Name: "Maharashtra"},
+		{Code: "MN", Name: "Manipur"},
+		{Code: "ML", Name: "Meghalaya"},
+		{Code: "MZ", Name: "Mizoram"},
+		{Code: "NL", Name: "Nagaland"},
+		{Code: "OR", Name: "Odisha"},
+		{Code: "PY", Name: "Puducherry"},
+		{Code: "PB", Name: "Punjab"},
+		{Code: "RJ", Name: "Rajasthan"},
+		{Code: "SK", Name: "Sikkim"},
+		{Code: "TN", Name: "Tamil Nadu"},
+		{Code: "TG", Name: "Telangana"},
+		{Code: "TR", Name: "Tripura"},
+		{Code: "UP", Name: "Uttar Pradesh"},
+		{Code: "UT", Name: "Uttarakhand"},
+		{Code: "WB", Name: "West Bengal"},
+	},
+	"MX": {
+		{Code: "AGU", Name: "Aguascalientes"},
+		{Code: "BCN", Name: "Baja California"},
+		{Code: "BCS", Name: "Baja California Sur"},
+		{Code: "CAM", Name: "Campeche"},
+		{Code: "CHP", Name: "Chiapas"},
+		{Code: "CHH", Name: "Chihuahua"},
+		{Code: "CMX", Name: "Ciudad de México"},
+		{Code: "COA", Name: "Coahuila"},
+		{Code: "COL", Name: "Colima"},
+		{Code: "DUR", Name: "Durango"},
+		{Code: "GUA", Name: "Guanajuato"},
+		{Code: "GRO", Name: "Guerrero"},
+		{Code: "HID", Name: "Hidalgo"},
+		{Code: "JAL", Name: "Jalisco"},
+		{Code: "MEX", Name: "México"},
+		{Code: "MIC", Name: "Michoacán"},
+		{Code: "MOR", Name: "Morelos"},
+		{Code: "NAY", Name: "Nayarit"},
+		{Code: "NLE", Name: "Nuevo León"},
+		{Code: "OAX", Name: "Oaxaca"},
+		{Code: "PUE", Name: "Puebla"},
+		{Code: "QUE", Name: "Querétaro"},
+		{Code: "ROO", Name: "Quintana Roo"},
+		{Code: "SLP", Name: "San Luis Potosí"},
+		{Code: "SIN", Name: "Sinaloa"},
+		{Code: "SON", Name: "Sonora"},
+		{Code: "TAB", Name: "Tabasco"},
+		{Code: "TAM", Name: "Tamaulipas"},
+		{Code: "TLA", Name: "Tlaxcala"},
+		{Code: "VER", Name: "Veracruz"},
+		{Code: "YUC", Name: "Yucatán"},
+		{Code: "ZAC", Name: "Zacatecas"},
+	},
+	"NL": {
+		{Code: "DR", Name: "Drenthe"},
+		{Code: "FL", Name: "Flevoland"},
+		{Code: "FR", Name: "Fryslân"},
+		{Code: "GE", Name: "Gelderland"},
+		{Code: "GR", Name: "Groningen"},
+		{Code: "LI", Name: "Limburg"},
+		{Code: "NB", Name: "Noord-Brabant"},
+		{Code: "NH", Name: "Noord-Holland"},
+		{Code: "OV", Name: "Overijssel"},
+		{Code: "UT", Name: "Utrecht"},
+		{Code: "ZE", Name: "Zeeland"},
+		{Code: "ZH", Name: "Zuid-Holland"},
+	},
+	"US": {
+		{Code: "AL", Name: "Alabama"},
+		{Code: "AK", Name: "Alaska"},
+		{Code: "AZ", Name: "Arizona"},
+		{Code: "AR", Name: "Arkansas"},
+		{Code: "CA", Name: "California"},
+		{Code: "CO", Name: "Colorado"},
+		{Code: "CT", Name: "Connecticut"},
+		{Code: "DE", Name: "Delaware"},
+		{Code: "DC", Name: "District of Columbia"},
+		{Code: "FL", Name: "Florida"},
+		{Code: "GA", Name: "Georgia"},
+		{Code: "HI", Name: "Hawaii"},
+		{Code: "ID", Name: "Idaho"},
+		{Code: "IL", Name: "Illinois"},
+		{Code: "IN", Name: "Indiana"},
+		{Code: "IA", Name: "Iowa"},
+		{Code: "KS", Name: "Kansas"},
+		{Code: "KY", Name: "Kentucky"},
+		{Code: "LA", Name: "Louisiana"},
+		{Code: "ME", Name: "Maine"},
+		{Code: "MT", Name: "Montana"},
+		{Code: "NE", Name: "Nebraska"},
+		{Code: "NV", Name: "Nevada"},
+		{Code: "NH", Name: "New Hampshire"},
+		{Code: "NJ", Name: "New Jersey"},
+		{Code: "NM", Name: "New Mexico"},
+		{Code: "NY", Name: "New York"},
+		{Code: "NC", Name: "North Carolina"},
+		{Code: "ND", Name: "North Dakota"},
+		{Code: "OH", Name: "Ohio"},
+		{Code: "OK", Name: "Oklahoma"},
+		{Code: "OR", Name: "Oregon"},
+		{Code: "MD", Name: "Maryland"},
+		{Code: "MA", Name: "Massachusetts"},
+		{Code: "MI", Name: "Michigan"},
+		{Code: "MN", Name: "Minnesota"},
+		{Code: "MS", Name: "Mississippi"},
+		{Code: "MO", Name: "Missouri"},
+		{Code: "PA", Name: "Pennsylvania"},
+		{Code: "RI", Name: "Rhode Island"},
+		{Code: "SC", Name: "South Carolina"},
+		{Code: "SD", Name: "South Dakota"},
+		{Code: "TN", Name: "Tennessee"},
+		{Code: "TX", Name: "Texas"},
+		{Code: "UT", Name: "Utah"},
+		{Code: "VT", Name: "Vermont"},
+		{Code: "VA", Name: "Virginia"},
+		{Code: "WA", Name: "Washington"},
+		{Code: "WV", Name: "West Virginia"},
+		{Code: "WI", Name: "Wisconsin"},
+		{Code: "WY", Name: "Wyoming"},
+	},
+}
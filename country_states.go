@@ -0,0 +1,135 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// An isoCountryState is an ISO 3166-2 subdivision of a country
+type isoCountryState struct {
+	Code string
+	Name string
+}
+
+// HasStateData returns true if ISO 3166-2 states are available for this country
+func country_HasStateData(rs m.CountrySet) bool {
+	_, ok := isoCountryStates[rs.Code()]
+	return ok
+}
+
+// LoadStates creates the ISO 3166-2 states of these countries that do not exist yet
+// and marks the countries as loaded. It returns the number of created states.
+//
+// States are written as superuser so that they can be loaded on demand when a
+// country is first used, even if reference data are frozen.
+func country_LoadStates(rs m.CountrySet) int {
+	var created int
+	for _, country := range rs.AsSuperUser("load country states").Records() {
+		existing := make(map[string]bool)
+		for _, state := range h.CountryState().Search(rs.Env(), q.CountryState().Country().Equals(country)).AsSuperUser("load country states").Records() {
+			existing[state.Code()] = true
+		}
+		for _, state := range isoCountryStates[country.Code()] {
+			if existing[state.Code] {
+				continue
+			}
+			h.CountryState().NewSet(rs.Env()).AsSuperUser("load country states").Create(h.CountryState().NewData().
+				SetCountry(country).
+				SetCode(state.Code).
+				SetName(state.Name))
+			created++
+		}
+		country.SetStatesLoaded(true)
+	}
+	return created
+}
+
+// EnsureStates loads the states of these countries if they have not been loaded yet.
+func country_EnsureStates(rs m.CountrySet) {
+	toLoad := h.Country().NewSet(rs.Env())
+	for _, country := range rs.Records() {
+		if country.StatesLoaded() || !country.HasStateData() {
+			continue
+		}
+		toLoad = toLoad.Union(country)
+	}
+	if toLoad.IsEmpty() {
+		return
+	}
+	toLoad.LoadStates()
+}
+
+func partner_CreateCountryStates(rs m.PartnerSet, vals m.PartnerData) m.PartnerSet {
+	if vals.Country().IsNotEmpty() {
+		vals.Country().EnsureStates()
+	}
+	return rs.Super().Create(vals)
+}
+
+func partner_WriteCountryStates(rs m.PartnerSet, vals m.PartnerData) bool {
+	if vals.HasCountry() && vals.Country().IsNotEmpty() {
+		vals.Country().EnsureStates()
+	}
+	return rs.Super().Write(vals)
+}
+
+// OnchangeCountryFilters loads the states of the selected country so that
+// they can be selected before the partner is saved.
+func partner_OnchangeCountryFiltersStates(rs m.PartnerSet) map[models.FieldName]models.Conditioner {
+	if rs.Country().IsNotEmpty() {
+		rs.Country().EnsureStates()
+	}
+	return rs.Super().OnchangeCountryFilters()
+}
+
+var fields_CountryStateLoader = map[string]models.FieldDefinition{
+	"Countries": fields.Many2Many{RelationModel: h.Country(), Required: true, JSON: "country_ids",
+		M2MLinkModelName: "CountryStateLoaderCountryRel",
+		Default: func(env models.Environment) interface{} {
+			if ids := env.Context().GetIntegerSlice(ContextKeyActiveIDs); len(ids) > 0 {
+				return h.Country().Search(env, q.Country().ID().In(ids))
+			}
+			codes := make([]string, 0, len(isoCountryStates))
+			for code := range isoCountryStates {
+				codes = append(codes, code)
+			}
+			return h.Country().Search(env, q.Country().Code().In(codes).And().StatesLoaded().Equals(false))
+		}},
+}
+
+// ActionLoad loads the states of the selected countries
+func countryStateLoader_ActionLoad(rs m.CountryStateLoaderSet) *actions.Action {
+	rs.EnsureOne()
+	created := rs.Countries().LoadStates()
+	LogEvent(rs.Env(), LogEntry{
+		Level:   LoggingInfo,
+		Logger:  "country",
+		Message: fmt.Sprintf("%d states loaded for %d countries", created, rs.Countries().Len()),
+		Func:    "CountryStateLoader.ActionLoad",
+		Model:   "Country",
+	})
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+func init() {
+	h.Country().NewMethod("HasStateData", country_HasStateData)
+	h.Country().NewMethod("LoadStates", country_LoadStates)
+	h.Country().NewMethod("EnsureStates", country_EnsureStates)
+
+	h.Partner().Methods().Create().Extend(partner_CreateCountryStates)
+	h.Partner().Methods().Write().Extend(partner_WriteCountryStates)
+	h.Partner().Methods().OnchangeCountryFilters().Extend(partner_OnchangeCountryFiltersStates)
+
+	models.NewTransientModel("CountryStateLoader")
+	h.CountryStateLoader().AddFields(fields_CountryStateLoader)
+	h.CountryStateLoader().NewMethod("ActionLoad", countryStateLoader_ActionLoad)
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCountryStates(t *testing.T) {
+	Convey("Testing on demand loading of country states", t, func() {
+		Convey("Embedded state codes are unique and fit in the state code field", func() {
+			for country, states := range isoCountryStates {
+				codes := make(map[string]bool)
+				for _, state := range states {
+					So(len(state.Code), ShouldBeBetweenOrEqual, 1, 3)
+					So(codes[state.Code], ShouldBeFalse)
+					codes[state.Code] = true
+				}
+				So(len(country), ShouldEqual, 2)
+			}
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			belgium := h.Country().Search(env, q.Country().Code().Equals("BE"))
+			canada := h.Country().Search(env, q.Country().Code().Equals("CA"))
+			Convey("LoadStates creates the missing states only once", func() {
+				So(belgium.HasStateData(), ShouldBeTrue)
+				So(belgium.LoadStates(), ShouldEqual, len(isoCountryStates["BE"]))
+				So(belgium.StatesLoaded(), ShouldBeTrue)
+				So(belgium.States().Len(), ShouldEqual, len(isoCountryStates["BE"]))
+				So(belgium.LoadStates(), ShouldEqual, 0)
+			})
+			Convey("States are loaded when a country is first used", func() {
+				So(canada.StatesLoaded(), ShouldBeFalse)
+				h.Partner().Create(env, h.Partner().NewData().
+					SetName("Canadian Partner").
+					SetCountry(canada))
+				So(canada.StatesLoaded(), ShouldBeTrue)
+				ontario := h.CountryState().Search(env, q.CountryState().Country().Equals(canada).And().Code().Equals("ON"))
+				So(ontario.Name(), ShouldEqual, "Ontario")
+			})
+			Convey("The bulk loader loads the selected countries", func() {
+				loader := h.CountryStateLoader().Create(env, h.CountryStateLoader().NewData().
+					SetCountries(belgium.Union(canada)))
+				loader.ActionLoad()
+				So(belgium.StatesLoaded(), ShouldBeTrue)
+				So(canada.StatesLoaded(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -32,6 +32,8 @@ var fields_Cron = map[string]models.FieldDefinition{
 		"weeks":   "Weeks",
 		"months":  "Months",
 	}, String: "Interval Unit", Default: models.DefaultValue("months")},
+	"Recurrence": fields.Many2One{RelationModel: h.RecurrenceRule(),
+		Help: "If set, the next execution dates are computed from this recurrence rule instead of the interval."},
 	"NextCall": fields.DateTime{String: "Next Execution Date", Required: true, Default: models.DefaultValue(dates.Now()),
 		Help: "Next planned execution date for this job."},
 	"Model":  fields.Char{Required: true, Constraint: h.Cron().Methods().CheckParameters()},
@@ -64,9 +66,18 @@ func cron_CheckParameters(rs m.QueueJobSet) {
 	}
 }
 
-// GetFutureCall returns the DateTime of the call after NextCall.`,
+// GetFutureCall returns the DateTime of the call after NextCall.
+//
+// If this cron has a Recurrence, it returns a zero DateTime when
+// the recurrence has no more occurrences.
 func cron_GetFutureCall(rs m.CronSet) dates.DateTime {
 	var res dates.DateTime
+	if rs.Recurrence().IsNotEmpty() {
+		if next := rs.Recurrence().NextOccurrencesAfter(rs.NextCall(), 1); len(next) > 0 {
+			res = next[0]
+		}
+		return res
+	}
 	switch rs.IntervalType() {
 	case "minutes":
 		res = rs.NextCall().Add(time.Duration(rs.IntervalNumber()) * time.Minute)
@@ -105,13 +116,19 @@ func runCron() {
 				SetRecordsIds(cron.RecordsIds()).
 				SetArguments(cron.Arguments()).
 				SetUser(cron.User()))
+			metricCronRuns.Inc(cron.Name())
 		}
 		cronIds = crons.Ids()
 	})
 	// Set next call in a different transaction in case creating the job failed and rolled back
 	models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
 		for _, cron := range h.Cron().Browse(env, cronIds).Records() {
-			cron.SetNextCall(cron.FutureCallDate())
+			nextCall := cron.FutureCallDate()
+			if nextCall.IsZero() {
+				cron.SetActive(false)
+				continue
+			}
+			cron.SetNextCall(nextCall)
 		}
 	})
 }
@@ -23,27 +23,33 @@ import (
 const CurrencyDisplayPattern = `(\w+)\s*(?:\((.*)\))?`
 
 var fields_CurrencyRate = map[string]models.FieldDefinition{
-	"Name": fields.DateTime{String: "Date", Required: true, Index: true},
+	"Name": fields.DateTime{String: "Date", Required: true, Index: true,
+		Constraint: h.CurrencyRate().Methods().CheckUniqueDate()},
 	"Rate": fields.Float{Digits: nbutils.Digits{Precision: 16, Scale: 6},
 		Help: "The rate of the currency to the currency of rate 1"},
-	"Currency": fields.Many2One{RelationModel: h.Currency()},
-	"Company":  fields.Many2One{RelationModel: h.Company()},
+	"Currency": fields.Many2One{RelationModel: h.Currency(), Constraint: h.CurrencyRate().Methods().CheckUniqueDate()},
+	"Company":  fields.Many2One{RelationModel: h.Company(), Constraint: h.CurrencyRate().Methods().CheckUniqueDate()},
 }
 var fields_Currency = map[string]models.FieldDefinition{
-	"Name": fields.Char{String: "Currency", Help: "Currency Code [ISO 4217]", Size: 3,
-		Unique: true},
+	"Name": fields.Char{String: "Currency", Help: "Currency Code [ISO 4217], or any code for custom currencies", Size: 10,
+		Unique: true, Constraint: h.Currency().Methods().CheckCode()},
+	"FullName":    fields.Char{String: "Currency Name", Translate: true},
+	"NumericCode": fields.Char{String: "ISO Numeric Code", Size: 3},
+	"Custom": fields.Boolean{String: "Custom Currency", Constraint: h.Currency().Methods().CheckCode(),
+		Help: "Custom currencies, such as crypto-currencies, are not part of ISO 4217"},
 	"Symbol": fields.Char{Help: "Currency sign, to be used when printing amounts", Size: 4},
 	"Rate": fields.Float{String: "Current Rate",
 		Help: "The rate of the currency to the currency of rate 1", Digits: nbutils.Digits{Precision: 16, Scale: 6},
 		Compute: h.Currency().Methods().ComputeCurrentRate(), Depends: []string{"Rates", "Rates.Rate"}},
 	"Rates": fields.One2Many{RelationModel: h.CurrencyRate(), ReverseFK: "Currency"},
-	"Rounding": fields.Float{String: "Rounding Factor", Digits: nbutils.Digits{Precision: 12,
-		Scale: 6}},
+	"Rounding": fields.Float{String: "Rounding Factor", Digits: nbutils.Digits{Precision: 18,
+		Scale: 10}, Default: models.DefaultValue(0.01), Constraint: h.Currency().Methods().CheckRounding()},
 	"DecimalPlaces": fields.Integer{GoType: new(int),
 		Compute: h.Currency().Methods().ComputeDecimalPlaces(), Depends: []string{"Rounding"}},
-	"Active": fields.Boolean{},
+	"Active": fields.Boolean{Default: models.DefaultValue(true)},
 	"Position": fields.Selection{Selection: types.Selection{"after": "After Amount", "before": "Before Amount"},
-		String: "Symbol Position", Help: "Determines where the currency symbol should be placed after or before the amount."},
+		String: "Symbol Position", Default: models.DefaultValue("after"),
+		Help: "Determines where the currency symbol should be placed after or before the amount."},
 	"Date": fields.Date{Compute: h.Currency().Methods().ComputeDate(), Depends: []string{"Rates", "Rates.Name"}},
 }
 
@@ -55,23 +61,7 @@ func currency_ComputeCurrentRate(rs m.CurrencySet) m.CurrencyData {
 	if rs.Env().Context().HasKey("date") {
 		date = rs.Env().Context().GetDate("date").ToDateTime()
 	}
-	company := h.User().NewSet(rs.Env()).GetCompany()
-	if rs.Env().Context().HasKey("company_id") {
-		company = h.Company().Browse(rs.Env(), []int64{rs.Env().Context().GetInteger("company_id")})
-	}
-	rate := h.CurrencyRate().Search(rs.Env(),
-		q.CurrencyRate().Currency().Equals(rs).
-			And().Name().LowerOrEqual(date).
-			AndCond(
-				q.CurrencyRate().Company().IsNull().
-					Or().Company().Equals(company))).
-		OrderBy("Company", "Name desc").
-		Limit(1)
-	res := rate.Rate()
-	if res == 0 {
-		res = 1.0
-	}
-	return h.Currency().NewData().SetRate(res)
+	return h.Currency().NewData().SetRate(rs.RateAt(date))
 }
 
 // ComputeDecimalPlaces returns the decimal place from the currency's rounding
@@ -176,6 +166,28 @@ func currency_GetFormatCurrenciesJsFunction(rs m.CurrencySet) string {
 	return function
 }
 
+// FormatMonetary returns the given amount rounded and formatted according to this
+// currency and to the conventions of the given language code, with the currency
+// symbol placed according to the Position of the currency (e.g. "$ 1,234.50" or
+// "1 234,50 €"). If lang is empty, the language of the context is used.
+func currency_FormatMonetary(rs m.CurrencySet, amount float64, lang string) string {
+	rs.EnsureOne()
+	if lang == "" {
+		lang = rs.Env().Context().GetString("lang")
+	}
+	language := h.Lang().NewSet(rs.Env()).GetLang(lang)
+	formatted := language.FormatNumber(rs.Round(amount), rs.DecimalPlaces(), true)
+	currency := cachedCurrency(rs)
+	symbol := currency.Symbol
+	if symbol == "" {
+		symbol = currency.Name
+	}
+	if currency.Position == "before" {
+		return fmt.Sprintf("%s\u00A0%s", symbol, formatted)
+	}
+	return fmt.Sprintf("%s\u00A0%s", formatted, symbol)
+}
+
 // SelectCompaniesRates returns an SQL query to get the currency rates per companies.
 func currency_SelectCompaniesRates(_ m.CurrencySet) string {
 	return `
@@ -210,6 +222,8 @@ func init() {
 	h.CurrencyRate().AddFields(fields_CurrencyRate)
 
 	models.NewModel("Currency")
+	h.Currency().InheritModel(h.ReferenceCacheMixin())
+	h.Currency().InheritModel(h.ReferenceDataMixin())
 	h.Currency().AddFields(fields_Currency)
 
 	h.Currency().NewMethod("ComputeCurrentRate", currency_ComputeCurrentRate)
@@ -222,5 +236,6 @@ func init() {
 	h.Currency().NewMethod("Compute", currency_Compute)
 	h.Currency().NewMethod("GetFormatCurrenciesJsFunction", currency_GetFormatCurrenciesJsFunction)
 	h.Currency().NewMethod("SelectCompaniesRates", currency_SelectCompaniesRates)
+	h.Currency().NewMethod("FormatMonetary", currency_FormatMonetary)
 	h.Currency().Methods().SearchByName().Extend(currency_SearchByName)
 }
@@ -0,0 +1,169 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+// isoCurrencyDataVersion is the version of the ISO 4217 data below. The seed
+// loader only runs again when it changes.
+const isoCurrencyDataVersion = "2020-1"
+
+// isoCurrencies holds the active ISO 4217 currencies, indexed by alphabetic code
+var isoCurrencies = map[string]isoCurrency{
+	"AED": {Numeric: "784", MinorUnits: 2, Name: "UAE Dirham"},
+	"AFN": {Numeric: "971", MinorUnits: 2, Name: "Afghani"},
+	"ALL": {Numeric: "008", MinorUnits: 2, Name: "Lek"},
+	"AMD": {Numeric: "051", MinorUnits: 2, Name: "Armenian Dram"},
+	"ANG": {Numeric: "532", MinorUnits: 2, Name: "Netherlands Antillean Guilder"},
+	"AOA": {Numeric: "973", MinorUnits: 2, Name: "Kwanza"},
+	"ARS": {Numeric: "032", MinorUnits: 2, Name: "Argentine Peso"},
+	"AUD": {Numeric: "036", MinorUnits: 2, Name: "Australian Dollar"},
+	"AWG": {Numeric: "533", MinorUnits: 2, Name: "Aruban Florin"},
+	"AZN": {Numeric: "944", MinorUnits: 2, Name: "Azerbaijan Manat"},
+	"BAM": {Numeric: "977", MinorUnits: 2, Name: "Convertible Mark"},
+	"BBD": {Numeric: "052", MinorUnits: 2, Name: "Barbados Dollar"},
+	"BDT": {Numeric: "050", MinorUnits: 2, Name: "Taka"},
+	"BGN": {Numeric: "975", MinorUnits: 2, Name: "Bulgarian Lev"},
+	"BHD": {Numeric: "048", MinorUnits: 3, Name: "Bahraini Dinar"},
+	"BIF": {Numeric: "108", MinorUnits: 0, Name: "Burundi Franc"},
+	"BMD": {Numeric: "060", MinorUnits: 2, Name: "Bermudian Dollar"},
+	"BND": {Numeric: "096", MinorUnits: 2, Name: "Brunei Dollar"},
+	"BOB": {Numeric: "068", MinorUnits: 2, Name: "Boliviano"},
+	"BRL": {Numeric: "986", MinorUnits: 2, Name: "Brazilian Real"},
+	"BSD": {Numeric: "044", MinorUnits: 2, Name: "Bahamian Dollar"},
+	"BTN": {Numeric: "064", MinorUnits: 2, Name: "Ngultrum"},
+	"BWP": {Numeric: "072", MinorUnits: 2, Name: "Pula"},
+	"BYN": {Numeric: "933", MinorUnits: 2, Name: "Belarusian Ruble"},
+	"BZD": {Numeric: "084", MinorUnits: 2, Name: "Belize Dollar"},
+	"CAD": {Numeric: "124", MinorUnits: 2, Name: "Canadian Dollar"},
+	"CDF": {Numeric: "976", MinorUnits: 2, Name: "Congolese Franc"},
+	"CHF": {Numeric: "756", MinorUnits: 2, Name: "Swiss Franc"},
+	"CLP": {Numeric: "152", MinorUnits: 0, Name: "Chilean Peso"},
+	"CNY": {Numeric: "156", MinorUnits: 2, Name: "Yuan Renminbi"},
+	"COP": {Numeric: "170", MinorUnits: 2, Name: "Colombian Peso"},
+	"CRC": {Numeric: "188", MinorUnits: 2, Name: "Costa Rican Colon"},
+	"CUC": {Numeric: "931", MinorUnits: 2, Name: "Peso Convertible"},
+	"CUP": {Numeric: "192", MinorUnits: 2, Name: "Cuban Peso"},
+	"CVE": {Numeric: "132", MinorUnits: 2, Name: "Cabo Verde Escudo"},
+	"CZK": {Numeric: "203", MinorUnits: 2, Name: "Czech Koruna"},
+	"DJF": {Numeric: "262", MinorUnits: 0, Name: "Djibouti Franc"},
+	"DKK": {Numeric: "208", MinorUnits: 2, Name: "Danish Krone"},
+	"DOP": {Numeric: "214", MinorUnits: 2, Name: "Dominican Peso"},
+	"DZD": {Numeric: "012", MinorUnits: 2, Name: "Algerian Dinar"},
+	"EGP": {Numeric: "818", MinorUnits: 2, Name: "Egyptian Pound"},
+	"ERN": {Numeric: "232", MinorUnits: 2, Name: "Nakfa"},
+	"ETB": {Numeric: "230", MinorUnits: 2, Name: "Ethiopian Birr"},
+	"EUR": {Numeric: "978", MinorUnits: 2, Name: "Euro"},
+	"FJD": {Numeric: "242", MinorUnits: 2, Name: "Fiji Dollar"},
+	"FKP": {Numeric: "238", MinorUnits: 2, Name: "Falkland Islands Pound"},
+	"GBP": {Numeric: "826", MinorUnits: 2, Name: "Pound Sterling"},
+	"GEL": {Numeric: "981", MinorUnits: 2, Name: "Lari"},
+	"GHS": {Numeric: "936", MinorUnits: 2, Name: "Ghana Cedi"},
+	"GIP": {Numeric: "292", MinorUnits: 2, Name: "Gibraltar Pound"},
+	"GMD": {Numeric: "270", MinorUnits: 2, Name: "Dalasi"},
+	"GNF": {Numeric: "324", MinorUnits: 0, Name: "Guinean Franc"},
+	"GTQ": {Numeric: "320", MinorUnits: 2, Name: "Quetzal"},
+	"GYD": {Numeric: "328", MinorUnits: 2, Name: "Guyana Dollar"},
+	"HKD": {Numeric: "344", MinorUnits: 2, Name: "Hong Kong Dollar"},
+	"HNL": {Numeric: "340", MinorUnits: 2, Name: "Lempira"},
+	"HRK": {Numeric: "191", MinorUnits: 2, Name: "Kuna"},
+	"HTG": {Numeric: "332", MinorUnits: 2, Name: "Gourde"},
+	"HUF": {Numeric: "348", MinorUnits: 2, Name: "Forint"},
+	"IDR": {Numeric: "360", MinorUnits: 2, Name: "Rupiah"},
+	"ILS": {Numeric: "376", MinorUnits: 2, Name: "New Israeli Sheqel"},
+	"INR": {Numeric: "356", MinorUnits: 2, Name: "Indian Rupee"},
+	"IQD": {Numeric: "368", MinorUnits: 3, Name: "Iraqi Dinar"},
+	"IRR": {Numeric: "364", MinorUnits: 2, Name: "Iranian Rial"},
+	"ISK": {Numeric: "352", MinorUnits: 0, Name: "Iceland Krona"},
+	"JMD": {Numeric: "388", MinorUnits: 2, Name: "Jamaican Dollar"},
+	"JOD": {Numeric: "400", MinorUnits: 3, Name: "Jordanian Dinar"},
+	"JPY": {Numeric: "392", MinorUnits: 0, Name: "Yen"},
+	"KES": {Numeric: "404", MinorUnits: 2, Name: "Kenyan Shilling"},
+	"KGS": {Numeric: "417", MinorUnits: 2, Name: "Som"},
+	"KHR": {Numeric: "116", MinorUnits: 2, Name: "Riel"},
+	"KMF": {Numeric: "174", MinorUnits: 0, Name: "Comorian Franc"},
+	"KPW": {Numeric: "408", MinorUnits: 2, Name: "North Korean Won"},
+	"KRW": {Numeric: "410", MinorUnits: 0, Name: "Won"},
+	"KWD": {Numeric: "414", MinorUnits: 3, Name: "Kuwaiti Dinar"},
+	"KYD": {Numeric: "136", MinorUnits: 2, Name: "Cayman Islands Dollar"},
+	"KZT": {Numeric: "398", MinorUnits: 2, Name: "Tenge"},
+	"LAK": {Numeric: "418", MinorUnits: 2, Name: "Lao Kip"},
+	"LBP": {Numeric: "422", MinorUnits: 2, Name: "Lebanese Pound"},
+	"LKR": {Numeric: "144", MinorUnits: 2, Name: "Sri Lanka Rupee"},
+	"LRD": {Numeric: "430", MinorUnits: 2, Name: "Liberian Dollar"},
+	"LSL": {Numeric: "426", MinorUnits: 2, Name: "Loti"},
+	"LYD": {Numeric: "434", MinorUnits: 3, Name: "Libyan Dinar"},
+	"MAD": {Numeric: "504", MinorUnits: 2, Name: "Moroccan Dirham"},
+	"MDL": {Numeric: "498", MinorUnits: 2, Name: "Moldovan Leu"},
+	"MGA": {Numeric: "969", MinorUnits: 2, Name: "Malagasy Ariary"},
+	"MKD": {Numeric: "807", MinorUnits: 2, Name: "Denar"},
+	"MMK": {Numeric: "104", MinorUnits: 2, Name: "Kyat"},
+	"MNT": {Numeric: "496", MinorUnits: 2, Name: "Tugrik"},
+	"MOP": {Numeric: "446", MinorUnits: 2, Name: "Pataca"},
+	"MRU": {Numeric: "929", MinorUnits: 2, Name: "Ouguiya"},
+	"MUR": {Numeric: "480", MinorUnits: 2, Name: "Mauritius Rupee"},
+	"MVR": {Numeric: "462", MinorUnits: 2, Name: "Rufiyaa"},
+	"MWK": {Numeric: "454", MinorUnits: 2, Name: "Malawi Kwacha"},
+	"MXN": {Numeric: "484", MinorUnits: 2, Name: "Mexican Peso"},
+	"MYR": {Numeric: "458", MinorUnits: 2, Name: "Malaysian Ringgit"},
+	"MZN": {Numeric: "943", MinorUnits: 2, Name: "Mozambique Metical"},
+	"NAD": {Numeric: "516", MinorUnits: 2, Name: "Namibia Dollar"},
+	"NGN": {Numeric: "566", MinorUnits: 2, Name: "Naira"},
+	"NIO": {Numeric: "558", MinorUnits: 2, Name: "Cordoba Oro"},
+	"NOK": {Numeric: "578", MinorUnits: 2, Name: "Norwegian Krone"},
+	"NPR": {Numeric: "524", MinorUnits: 2, Name: "Nepalese Rupee"},
+	"NZD": {Numeric: "554", MinorUnits: 2, Name: "New Zealand Dollar"},
+	"OMR": {Numeric: "512", MinorUnits: 3, Name: "Rial Omani"},
+	"PAB": {Numeric: "590", MinorUnits: 2, Name: "Balboa"},
+	"PEN": {Numeric: "604", MinorUnits: 2, Name: "Sol"},
+	"PGK": {Numeric: "598", MinorUnits: 2, Name: "Kina"},
+	"PHP": {Numeric: "608", MinorUnits: 2, Name: "Philippine Peso"},
+	"PKR": {Numeric: "586", MinorUnits: 2, Name: "Pakistan Rupee"},
+	"PLN": {Numeric: "985", MinorUnits: 2, Name: "Zloty"},
+	"PYG": {Numeric: "600", MinorUnits: 0, Name: "Guarani"},
+	"QAR": {Numeric: "634", MinorUnits: 2, Name: "Qatari Rial"},
+	"RON": {Numeric: "946", MinorUnits: 2, Name: "Romanian Leu"},
+	"RSD": {Numeric: "941", MinorUnits: 2, Name: "Serbian Dinar"},
+	"RUB": {Numeric: "643", MinorUnits: 2, Name: "Russian Ruble"},
+	"RWF": {Numeric: "646", MinorUnits: 0, Name: "Rwanda Franc"},
+	"SAR": {Numeric: "682", MinorUnits: 2, Name: "Saudi Riyal"},
+	"SBD": {Numeric: "090", MinorUnits: 2, Name: "Solomon Islands Dollar"},
+	"SCR": {Numeric: "690", MinorUnits: 2, Name: "Seychelles Rupee"},
+	"SDG": {Numeric: "938", MinorUnits: 2, Name: "Sudanese Pound"},
+	"SEK": {Numeric: "752", MinorUnits: 2, Name: "Swedish Krona"},
+	"SGD": {Numeric: "702", MinorUnits: 2, Name: "Singapore Dollar"},
+	"SHP": {Numeric: "654", MinorUnits: 2, Name: "Saint Helena Pound"},
+	"SLL": {Numeric: "694", MinorUnits: 2, Name: "Leone"},
+	"SOS": {Numeric: "706", MinorUnits: 2, Name: "Somali Shilling"},
+	"SRD": {Numeric: "968", MinorUnits: 2, Name: "Surinam Dollar"},
+	"SSP": {Numeric: "728", MinorUnits: 2, Name: "South Sudanese Pound"},
+	"STN": {Numeric: "930", MinorUnits: 2, Name: "Dobra"},
+	"SVC": {Numeric: "222", MinorUnits: 2, Name: "El Salvador Colon"},
+	"SYP": {Numeric: "760", MinorUnits: 2, Name: "Syrian Pound"},
+	"SZL": {Numeric: "748", MinorUnits: 2, Name: "Lilangeni"},
+	"THB": {Numeric: "764", MinorUnits: 2, Name: "Baht"},
+	"TJS": {Numeric: "972", MinorUnits: 2, Name: "Somoni"},
+	"TMT": {Numeric: "934", MinorUnits: 2, Name: "Turkmenistan New Manat"},
+	"TND": {Numeric: "788", MinorUnits: 3, Name: "Tunisian Dinar"},
+	"TOP": {Numeric: "776", MinorUnits: 2, Name: "Pa'anga"},
+	"TRY": {Numeric: "949", MinorUnits: 2, Name: "Turkish Lira"},
+	"TTD": {Numeric: "780", MinorUnits: 2, Name: "Trinidad and Tobago Dollar"},
+	"TWD": {Numeric: "901", MinorUnits: 2, Name: "New Taiwan Dollar"},
+	"TZS": {Numeric: "834", MinorUnits: 2, Name: "Tanzanian Shilling"},
+	"UAH": {Numeric: "980", MinorUnits: 2, Name: "Hryvnia"},
+	"UGX": {Numeric: "800", MinorUnits: 0, Name: "Uganda Shilling"},
+	"USD": {Numeric: "840", MinorUnits: 2, Name: "US Dollar"},
+	"UYU": {Numeric: "858", MinorUnits: 2, Name: "Peso Uruguayo"},
+	"UZS": {Numeric: "860", MinorUnits: 2, Name: "Uzbekistan Sum"},
+	"VES": {Numeric: "928", MinorUnits: 2, Name: "Bolívar Soberano"},
+	"VND": {Numeric: "704", MinorUnits: 0, Name: "Dong"},
+	"VUV": {Numeric: "548", MinorUnits: 0, Name: "Vatu"},
+	"WST": {Numeric: "882", MinorUnits: 2, Name: "Tala"},
+	"XAF": {Numeric: "950", MinorUnits: 0, Name: "CFA Franc BEAC"},
+	"XCD": {Numeric: "951", MinorUnits: 2, Name: "East Caribbean Dollar"},
+	"XOF": {Numeric: "952", MinorUnits: 0, Name: "CFA Franc BCEAO"},
+	"XPF": {Numeric: "953", MinorUnits: 0, Name: "CFP Franc"},
+	"YER": {Numeric: "886", MinorUnits: 2, Name: "Yemeni Rial"},
+	"ZAR": {Numeric: "710", MinorUnits: 2, Name: "Rand"},
+	"ZMW": {Numeric: "967", MinorUnits: 2, Name: "Zambian Kwacha"},
+	"ZWL": {Numeric: "932", MinorUnits: 2, Name: "Zimbabwe Dollar"},
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// CurrencyRateDateFormat is the format of the dates in currency rates CSV files
+const CurrencyRateDateFormat = "2006-01-02"
+
+// rateCompany returns the company whose rates apply to the given currency set,
+// that is the company given by the 'company_id' context key or the company of
+// the current user.
+func rateCompany(rs m.CurrencySet) m.CompanySet {
+	if rs.Env().Context().HasKey("company_id") {
+		return h.Company().Browse(rs.Env(), []int64{rs.Env().Context().GetInteger("company_id")})
+	}
+	return h.User().NewSet(rs.Env()).GetCompany()
+}
+
+// RateAt returns the rate of this currency at the given date, that is the latest
+// rate not after this date. Rates of the company given by the 'company_id' context
+// key, or of the current user's company, take precedence over rates without company.
+//
+// It returns 1 if this currency has no rate before the given date.
+func currency_RateAt(rs m.CurrencySet, date dates.DateTime) float64 {
+	rs.EnsureOne()
+	rate := h.CurrencyRate().Search(rs.Env(),
+		q.CurrencyRate().Currency().Equals(rs).
+			And().Name().LowerOrEqual(date).
+			AndCond(
+				q.CurrencyRate().Company().IsNull().
+					Or().Company().Equals(rateCompany(rs)))).
+		OrderBy("Company", "Name desc").
+		Limit(1)
+	if rate.Rate() == 0 {
+		return 1.0
+	}
+	return rate.Rate()
+}
+
+// currencyRateDayCondition returns the condition on currency rates of the given
+// currency and company set on the day of the given date.
+func currencyRateDayCondition(currency m.CurrencySet, company m.CompanySet, date dates.DateTime) q.CurrencyRateCondition {
+	day := date.ToDate().ToDateTime()
+	cond := q.CurrencyRate().Currency().Equals(currency).
+		And().Name().GreaterOrEqual(day).
+		And().Name().Lower(day.AddDate(0, 0, 1))
+	if company.IsEmpty() {
+		return cond.And().Company().IsNull()
+	}
+	return cond.And().Company().Equals(company)
+}
+
+// CheckUniqueDate checks that there is at most one rate per currency, company and day
+func currencyRate_CheckUniqueDate(rs m.CurrencyRateSet) {
+	for _, rate := range rs.Records() {
+		others := h.CurrencyRate().Search(rs.Env(),
+			currencyRateDayCondition(rate.Currency(), rate.Company(), rate.Name())).Subtract(rate)
+		if others.IsNotEmpty() {
+			log.Panic(rs.T("Only one rate of currency %s is allowed per day and company (%s).",
+				rate.Currency().Name(), rate.Name().ToDate().Format(CurrencyRateDateFormat)))
+		}
+	}
+}
+
+// ImportCSV creates or updates the rates of the given company from the given CSV
+// data. If company is empty, rates are shared by all companies.
+//
+// The first line of the data must be a header with 'currency', 'date' and 'rate'
+// columns in any order. Currencies are given by their code and dates with the
+// YYYY-MM-DD format. The rate of a currency already set on a date is overwritten.
+// It returns the number of imported rates.
+func currencyRate_ImportCSV(rs m.CurrencyRateSet, data []byte, company m.CompanySet) int {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		log.Panic(rs.T("Unable to read the header of the currency rates file: %s", err))
+	}
+	columns := make(map[string]int)
+	for i, col := range header {
+		columns[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	for _, col := range []string{"currency", "date", "rate"} {
+		if _, ok := columns[col]; !ok {
+			log.Panic(rs.T("Missing column '%s' in the currency rates file", col))
+		}
+	}
+	currencies := make(map[string]m.CurrencySet)
+	for _, currency := range h.Currency().NewSet(rs.Env()).WithContext("active_test", false).SearchAll().Records() {
+		currencies[currency.Name()] = currency
+	}
+	var count int
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Panic(rs.T("Error in the currency rates file at line %d: %s", line, err))
+		}
+		currency, ok := currencies[strings.ToUpper(strings.TrimSpace(record[columns["currency"]]))]
+		if !ok {
+			log.Panic(rs.T("Unknown currency '%s' at line %d", record[columns["currency"]], line))
+		}
+		date, err := time.Parse(CurrencyRateDateFormat, strings.TrimSpace(record[columns["date"]]))
+		if err != nil {
+			log.Panic(rs.T("Invalid date '%s' at line %d, expected format is YYYY-MM-DD", record[columns["date"]], line))
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[columns["rate"]]), 64)
+		if err != nil || value <= 0 {
+			log.Panic(rs.T("Invalid rate '%s' at line %d", record[columns["rate"]], line))
+		}
+		name := dates.DateTime{Time: date}
+		existing := h.CurrencyRate().Search(rs.Env(), currencyRateDayCondition(currency, company, name))
+		if existing.IsNotEmpty() {
+			existing.SetRate(value)
+		} else {
+			h.CurrencyRate().Create(rs.Env(), h.CurrencyRate().NewData().
+				SetCurrency(currency).
+				SetCompany(company).
+				SetName(name).
+				SetRate(value))
+		}
+		count++
+	}
+	return count
+}
+
+var fields_CurrencyRateImportWizard = map[string]models.FieldDefinition{
+	"Data": fields.Binary{String: "CSV File", Required: true,
+		Help: "CSV file with 'currency', 'date' (YYYY-MM-DD) and 'rate' columns"},
+	"FileName": fields.Char{},
+	"Company": fields.Many2One{RelationModel: h.Company(),
+		Help: "Company of the imported rates. Leave empty to share the rates between all companies."},
+}
+
+// ActionImport imports the currency rates of the CSV file of this wizard
+func currencyRateImportWizard_ActionImport(rs m.CurrencyRateImportWizardSet) *actions.Action {
+	rs.EnsureOne()
+	data, err := base64.StdEncoding.DecodeString(rs.Data())
+	if err != nil {
+		log.Panic(rs.T("Unable to decode the currency rates file: %s", err))
+	}
+	count := h.CurrencyRate().NewSet(rs.Env()).ImportCSV(data, rs.Company())
+	LogEvent(rs.Env(), LogEntry{
+		Level:   LoggingInfo,
+		Logger:  "currency",
+		Message: fmt.Sprintf("%d currency rates imported from %s", count, rs.FileName()),
+		Func:    "CurrencyRateImportWizard.ActionImport",
+		Model:   "CurrencyRate",
+	})
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+func init() {
+	h.Currency().NewMethod("RateAt", currency_RateAt)
+
+	h.CurrencyRate().NewMethod("CheckUniqueDate", currencyRate_CheckUniqueDate)
+	h.CurrencyRate().NewMethod("ImportCSV", currencyRate_ImportCSV)
+	h.CurrencyRate().AddSQLConstraint("unique_name_per_day", "unique(name, currency_id, company_id)",
+		"Only one currency rate per day allowed!")
+
+	models.NewTransientModel("CurrencyRateImportWizard")
+	h.CurrencyRateImportWizard().AddFields(fields_CurrencyRateImportWizard)
+	h.CurrencyRateImportWizard().NewMethod("ActionImport", currencyRateImportWizard_ActionImport)
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCurrencyRates(t *testing.T) {
+	Convey("Testing historical currency rates", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			chf := h.Currency().NewSet(env).WithContext("active_test", false).
+				Search(q.Currency().Name().Equals("CHF"))
+			h.CurrencyRate().Search(env, q.CurrencyRate().Currency().Equals(chf)).Unlink()
+			Convey("RateAt returns the latest rate not after the date", func() {
+				So(h.CurrencyRate().NewSet(env).ImportCSV([]byte("date,currency,rate\n"+
+					"2020-01-01,CHF,1.08\n"+
+					"2020-02-01,chf,1.06\n"), h.Company().NewSet(env)), ShouldEqual, 2)
+				So(chf.RateAt(dates.ParseDate("2019-12-31").ToDateTime()), ShouldEqual, 1)
+				So(chf.RateAt(dates.ParseDate("2020-01-01").ToDateTime()), ShouldEqual, 1.08)
+				So(chf.RateAt(dates.ParseDate("2020-01-15").ToDateTime()), ShouldEqual, 1.08)
+				So(chf.RateAt(dates.ParseDate("2020-03-01").ToDateTime()), ShouldEqual, 1.06)
+			})
+			Convey("Importing a rate twice on the same day overwrites it", func() {
+				h.CurrencyRate().NewSet(env).ImportCSV([]byte("currency,date,rate\nCHF,2020-01-01,1.08\n"), h.Company().NewSet(env))
+				h.CurrencyRate().NewSet(env).ImportCSV([]byte("currency,date,rate\nCHF,2020-01-01,1.09\n"), h.Company().NewSet(env))
+				So(h.CurrencyRate().Search(env, q.CurrencyRate().Currency().Equals(chf)).Len(), ShouldEqual, 1)
+				So(chf.RateAt(dates.ParseDate("2020-01-01").ToDateTime()), ShouldEqual, 1.09)
+			})
+			Convey("Only one rate is allowed per currency, company and day", func() {
+				h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+					SetCurrency(chf).
+					SetName(dates.ParseDateTime("2020-01-01 00:00:00")).
+					SetRate(1.08))
+				So(func() {
+					h.CurrencyRate().Create(env, h.CurrencyRate().NewData().
+						SetCurrency(chf).
+						SetName(dates.ParseDateTime("2020-01-01 12:00:00")).
+						SetRate(1.07))
+				}, ShouldPanic)
+			})
+			Convey("Invalid CSV files are rejected", func() {
+				So(func() {
+					h.CurrencyRate().NewSet(env).ImportCSV([]byte("currency,rate\nCHF,1.08\n"), h.Company().NewSet(env))
+				}, ShouldPanic)
+				So(func() {
+					h.CurrencyRate().NewSet(env).ImportCSV([]byte("currency,date,rate\nXXX,2020-01-01,1.08\n"), h.Company().NewSet(env))
+				}, ShouldPanic)
+				So(func() {
+					h.CurrencyRate().NewSet(env).ImportCSV([]byte("currency,date,rate\nCHF,01/01/2020,1.08\n"), h.Company().NewSet(env))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// CurrencyISOVersionParam holds the version of the ISO 4217 data last loaded in the database
+const CurrencyISOVersionParam = "base.currency_iso_version"
+
+var (
+	isoCurrencyCodeRegex    = regexp.MustCompile(`^[A-Z]{3}$`)
+	customCurrencyCodeRegex = regexp.MustCompile(`^[A-Z0-9]{2,10}$`)
+)
+
+// An isoCurrency holds the ISO 4217 data of a currency
+type isoCurrency struct {
+	Numeric    string
+	MinorUnits int
+	Name       string
+}
+
+// Rounding returns the rounding factor matching the minor units of this currency
+func (c isoCurrency) Rounding() float64 {
+	return math.Pow10(-c.MinorUnits)
+}
+
+// CheckCode checks that the code of ISO currencies is made of three uppercase
+// letters and that custom currencies do not reuse an ISO 4217 code.
+func currency_CheckCode(rs m.CurrencySet) {
+	for _, currency := range rs.Records() {
+		if !currency.Custom() {
+			if !isoCurrencyCodeRegex.MatchString(currency.Name()) {
+				log.Panic(rs.T("Invalid currency code '%s': ISO 4217 codes are made of three uppercase letters.", currency.Name()))
+			}
+			continue
+		}
+		if !customCurrencyCodeRegex.MatchString(currency.Name()) {
+			log.Panic(rs.T("Invalid custom currency code '%s': it must be made of 2 to 10 uppercase letters or digits.", currency.Name()))
+		}
+		if _, exists := isoCurrencies[currency.Name()]; exists {
+			log.Panic(rs.T("'%s' is an ISO 4217 currency code and cannot be used for a custom currency.", currency.Name()))
+		}
+	}
+}
+
+// CheckRounding checks that the rounding factor of the currencies is strictly positive
+func currency_CheckRounding(rs m.CurrencySet) {
+	for _, currency := range rs.Records() {
+		if currency.Rounding() <= 0 {
+			log.Panic(rs.T("The rounding factor of currency '%s' must be strictly positive.", currency.Name()))
+		}
+	}
+}
+
+// LoadISOCurrencies creates the ISO 4217 currencies that do not exist yet as
+// inactive currencies and completes the existing ones with their ISO name and
+// numeric code. It returns the number of created currencies.
+//
+// The rounding of existing currencies is only updated if it has not been
+// customized, i.e. if it is still the 0.01 default.
+func currency_LoadISOCurrencies(rs m.CurrencySet) int {
+	rs = rs.AsSuperUser("seed currencies").WithContext("active_test", false)
+	existing := make(map[string]m.CurrencySet)
+	for _, currency := range rs.SearchAll().Records() {
+		existing[currency.Name()] = currency
+	}
+	var created int
+	for code, iso := range isoCurrencies {
+		currency, ok := existing[code]
+		if !ok {
+			rs.Create(h.Currency().NewData().
+				SetName(code).
+				SetFullName(iso.Name).
+				SetNumericCode(iso.Numeric).
+				SetSymbol(code).
+				SetPosition("after").
+				SetRounding(iso.Rounding()).
+				SetActive(false))
+			created++
+			continue
+		}
+		vals := h.Currency().NewData()
+		var update bool
+		if currency.FullName() == "" {
+			vals.SetFullName(iso.Name)
+			update = true
+		}
+		if currency.NumericCode() != iso.Numeric {
+			vals.SetNumericCode(iso.Numeric)
+			update = true
+		}
+		if currency.Rounding() == 0 || currency.Rounding() == 0.01 && iso.MinorUnits != 2 {
+			vals.SetRounding(iso.Rounding())
+			update = true
+		}
+		if update {
+			currency.Write(vals)
+		}
+	}
+	return created
+}
+
+// seedISOCurrencies loads the embedded ISO 4217 data if it has not been loaded
+// in this database yet or if it has been updated since.
+func seedISOCurrencies(env models.Environment) {
+	params := h.ConfigParameter().NewSet(env).AsSuperUser("read currency seed settings")
+	if params.GetParam(CurrencyISOVersionParam, "") == isoCurrencyDataVersion {
+		return
+	}
+	created := h.Currency().NewSet(env).LoadISOCurrencies()
+	params.SetParam(CurrencyISOVersionParam, isoCurrencyDataVersion)
+	LogEvent(env, LogEntry{
+		Level:   LoggingInfo,
+		Logger:  "currency",
+		Message: fmt.Sprintf("ISO 4217 currencies %s loaded, %d currencies created", isoCurrencyDataVersion, created),
+		Func:    "seedISOCurrencies",
+		Model:   "Currency",
+	})
+}
+
+func init() {
+	h.Currency().NewMethod("CheckCode", currency_CheckCode)
+	h.Currency().NewMethod("CheckRounding", currency_CheckRounding)
+	h.Currency().NewMethod("LoadISOCurrencies", currency_LoadISOCurrencies)
+}
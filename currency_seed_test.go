@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCurrencySeed(t *testing.T) {
+	Convey("Testing ISO 4217 seed data and custom currencies", t, func() {
+		Convey("Embedded ISO data is consistent", func() {
+			for code, iso := range isoCurrencies {
+				So(isoCurrencyCodeRegex.MatchString(code), ShouldBeTrue)
+				So(len(iso.Numeric), ShouldEqual, 3)
+				So(iso.MinorUnits, ShouldBeBetweenOrEqual, 0, 3)
+				So(iso.Name, ShouldNotBeBlank)
+			}
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Loading ISO data completes existing currencies", func() {
+				jpy := h.Currency().NewSet(env).WithContext("active_test", false).
+					Search(q.Currency().Name().Equals("JPY"))
+				jpy.SetRounding(0.01)
+				h.Currency().NewSet(env).LoadISOCurrencies()
+				So(jpy.Rounding(), ShouldEqual, 1)
+				So(jpy.DecimalPlaces(), ShouldEqual, 0)
+				So(jpy.FullName(), ShouldEqual, "Yen")
+				So(jpy.NumericCode(), ShouldEqual, "392")
+				So(h.Currency().NewSet(env).LoadISOCurrencies(), ShouldEqual, 0)
+			})
+			Convey("Custom currencies can be created with a fine rounding", func() {
+				btc := h.Currency().Create(env, h.Currency().NewData().
+					SetName("BTC").
+					SetFullName("Bitcoin").
+					SetSymbol("₿").
+					SetCustom(true).
+					SetRounding(0.00000001))
+				So(btc.DecimalPlaces(), ShouldEqual, 8)
+				So(btc.Active(), ShouldBeTrue)
+				So(btc.Position(), ShouldEqual, "after")
+			})
+			Convey("Invalid currency codes are rejected", func() {
+				So(func() {
+					h.Currency().Create(env, h.Currency().NewData().SetName("XYZW"))
+				}, ShouldPanic)
+				So(func() {
+					h.Currency().Create(env, h.Currency().NewData().SetName("my-coin").SetCustom(true))
+				}, ShouldPanic)
+				So(func() {
+					h.Currency().Create(env, h.Currency().NewData().SetName("EUR").SetCustom(true))
+				}, ShouldPanic)
+			})
+			Convey("Rounding must be strictly positive", func() {
+				So(func() {
+					h.Currency().Create(env, h.Currency().NewData().
+						SetName("ZZCOIN").
+						SetCustom(true).
+						SetRounding(-1))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,323 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// CustomFieldModels is the selection of the models on which custom fields can be defined.
+// Models added here must inherit CustomFieldMixin.
+var CustomFieldModels = types.Selection{
+	"Partner": "Contact",
+	"Company": "Company",
+}
+
+// CustomFieldTypes is the selection of the available custom field types
+var CustomFieldTypes = types.Selection{
+	"char":      "Text",
+	"boolean":   "Checkbox",
+	"selection": "Selection",
+	"many2one":  "Many2One",
+}
+
+// customFieldNameRegex is the pattern that custom field names must match
+var customFieldNameRegex = regexp.MustCompile(`^x_[a-z0-9_]+$`)
+
+var fields_CustomField = map[string]models.FieldDefinition{
+	"Name": fields.Char{String: "Field Name", Required: true, Index: true,
+		Constraint: h.CustomField().Methods().CheckDefinition(),
+		Help:       "Technical name of the field. It must start with 'x_' and contain only lowercase letters, digits and underscores."},
+	"Label": fields.Char{Required: true, Translate: true},
+	"Model": fields.Selection{Selection: CustomFieldModels, Required: true, Index: true,
+		Constraint: h.CustomField().Methods().CheckDefinition()},
+	"Type": fields.Selection{String: "Field Type", Selection: CustomFieldTypes, Required: true,
+		Default: models.DefaultValue("char"), Constraint: h.CustomField().Methods().CheckDefinition()},
+	"SelectionValues": fields.Text{String: "Selection Options",
+		Constraint: h.CustomField().Methods().CheckDefinition(),
+		Help:       "Options of selection fields, one 'key:label' per line"},
+	"Relation": fields.Char{String: "Related Model", Constraint: h.CustomField().Methods().CheckDefinition(),
+		Help: "Name of the related model of Many2One fields, e.g. Country"},
+	"Required": fields.Boolean{},
+	"Help":     fields.Text{Translate: true},
+	"Active":   fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckDefinition checks that the custom field definition is consistent
+func customField_CheckDefinition(rs m.CustomFieldSet) {
+	for _, cf := range rs.Records() {
+		if !customFieldNameRegex.MatchString(cf.Name()) {
+			log.Panic(rs.T("Invalid custom field name '%s': it must start with 'x_' and contain only lowercase letters, digits and underscores.", cf.Name()))
+		}
+		switch cf.Type() {
+		case "selection":
+			if len(cf.SelectionOptions()) == 0 {
+				log.Panic(rs.T("Selection field '%s' must have at least one option.", cf.Name()))
+			}
+		case "many2one":
+			if _, exists := models.Registry.Get(cf.Relation()); !exists {
+				log.Panic(rs.T("Unknown related model '%s' for field '%s'.", cf.Relation(), cf.Name()))
+			}
+		}
+	}
+}
+
+// SelectionOptions returns the options of this selection custom field
+func customField_SelectionOptions(rs m.CustomFieldSet) types.Selection {
+	rs.EnsureOne()
+	res := make(types.Selection)
+	for _, line := range strings.Split(rs.SelectionValues(), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		key := strings.TrimSpace(parts[0])
+		label := key
+		if len(parts) == 2 {
+			label = strings.TrimSpace(parts[1])
+		}
+		res[key] = label
+	}
+	return res
+}
+
+// NormalizeValue checks that value is valid for this custom field and
+// returns it converted to the type in which it is stored. It panics if
+// the value is not valid.
+//
+// Many2One values are stored as the ID of the related record and can be
+// given as an ID or a RecordSet.
+func customField_NormalizeValue(rs m.CustomFieldSet, value interface{}) interface{} {
+	rs.EnsureOne()
+	if value == nil {
+		if rs.Required() {
+			log.Panic(rs.T("Field '%s' is required.", rs.Label()))
+		}
+		return nil
+	}
+	switch rs.Type() {
+	case "char", "selection":
+		val, ok := value.(string)
+		if !ok {
+			log.Panic(rs.T("Invalid value for field '%s': text expected.", rs.Label()))
+		}
+		if val == "" && rs.Required() {
+			log.Panic(rs.T("Field '%s' is required.", rs.Label()))
+		}
+		if val != "" && rs.Type() == "selection" {
+			if _, ok := rs.SelectionOptions()[val]; !ok {
+				log.Panic(rs.T("Invalid value '%s' for field '%s'.", val, rs.Label()))
+			}
+		}
+		return val
+	case "boolean":
+		val, ok := value.(bool)
+		if !ok {
+			log.Panic(rs.T("Invalid value for field '%s': boolean expected.", rs.Label()))
+		}
+		return val
+	case "many2one":
+		var id int64
+		switch val := value.(type) {
+		case int64:
+			id = val
+		case int:
+			id = int64(val)
+		case float64:
+			// JSON decoded values
+			id = int64(val)
+		case models.RecordSet:
+			if val.Len() > 1 {
+				log.Panic(rs.T("Invalid value for field '%s': a single record expected.", rs.Label()))
+			}
+			if val.Len() == 1 {
+				id = val.Ids()[0]
+			}
+		default:
+			log.Panic(rs.T("Invalid value for field '%s': record expected.", rs.Label()))
+		}
+		if id == 0 {
+			if rs.Required() {
+				log.Panic(rs.T("Field '%s' is required.", rs.Label()))
+			}
+			return nil
+		}
+		relModel := models.Registry.MustGet(rs.Relation())
+		if rs.Env().Pool(rs.Relation()).Search(relModel.Field(models.ID).Equals(id)).IsEmpty() {
+			log.Panic(rs.T("Record %d of '%s' does not exist.", id, rs.Relation()))
+		}
+		return id
+	}
+	return value
+}
+
+// customValuesExpression is the SQL expression of the custom values of a
+// record as JSONB, used for searching and indexing. Values are stored in a
+// text column, since the ORM has no JSONB field type, and cast on the fly;
+// the expression index keeps the cast out of the searches' cost.
+const customValuesExpression = "NULLIF(custom_values, '')::jsonb"
+
+var fields_CustomFieldMixin = map[string]models.FieldDefinition{
+	"CustomValues": fields.Text{String: "Custom Fields", GoType: new(basetypes.CustomFieldValues), NoCopy: true,
+		Help: "Values of the custom fields of this record, as a JSON object keyed by custom field name"},
+}
+
+// CustomFieldDefinitions returns the active custom fields defined for this model
+func customFieldMixin_CustomFieldDefinitions(rs m.CustomFieldMixinSet) m.CustomFieldSet {
+	return h.CustomField().Search(rs.Env(), q.CustomField().Model().Equals(rs.ModelName()))
+}
+
+// CustomFieldDefinition returns the active custom field of this model with the given name.
+// It panics if there is no such field.
+func customFieldMixin_CustomFieldDefinition(rs m.CustomFieldMixinSet, name string) m.CustomFieldSet {
+	cf := h.CustomField().Search(rs.Env(),
+		q.CustomField().Model().Equals(rs.ModelName()).And().Name().Equals(name))
+	if cf.IsEmpty() {
+		log.Panic(rs.T("Unknown custom field '%s' on model '%s'.", name, rs.ModelName()))
+	}
+	return cf
+}
+
+// CustomFieldValue returns the value of the custom field with the given name
+// for this record, or nil if it is not set. Many2One values are returned as
+// a RecordSet of the related model.
+func customFieldMixin_CustomFieldValue(rs m.CustomFieldMixinSet, name string) interface{} {
+	rs.EnsureOne()
+	cf := rs.CustomFieldDefinition(name)
+	value, ok := rs.CustomValues()[name]
+	if !ok || value == nil {
+		return nil
+	}
+	if cf.Type() == "many2one" {
+		var id int64
+		switch val := value.(type) {
+		case int64:
+			id = val
+		case float64:
+			// JSON decoded values
+			id = int64(val)
+		}
+		return models.Registry.MustGet(cf.Relation()).Browse(rs.Env(), []int64{id})
+	}
+	return value
+}
+
+// SetCustomFieldValues sets the given custom field values on all the records of
+// this recordset. Other custom field values of the records are left untouched
+// and a nil value unsets the field.
+func customFieldMixin_SetCustomFieldValues(rs m.CustomFieldMixinSet, values map[string]interface{}) {
+	for _, rec := range rs.Records() {
+		vals := rec.CustomValues().Copy()
+		for name, value := range values {
+			vals[name] = value
+		}
+		rec.Write(h.CustomFieldMixin().NewData().SetCustomValues(vals))
+	}
+}
+
+// NormalizeCustomValues checks the given custom values against the custom
+// fields definitions of this model and returns them normalized. Unset values
+// and values of unknown or archived custom fields are removed. It panics if a
+// value is invalid or if a required field is missing and checkRequired is true.
+func customFieldMixin_NormalizeCustomValues(rs m.CustomFieldMixinSet, values basetypes.CustomFieldValues, checkRequired bool) basetypes.CustomFieldValues {
+	definitions := make(map[string]m.CustomFieldSet)
+	for _, cf := range rs.CustomFieldDefinitions().Records() {
+		definitions[cf.Name()] = cf
+	}
+	res := make(basetypes.CustomFieldValues)
+	for name, value := range values {
+		cf, ok := definitions[name]
+		if !ok {
+			log.Debug("Dropping value of unknown custom field", "model", rs.ModelName(), "field", name)
+			continue
+		}
+		if val := cf.NormalizeValue(value); val != nil {
+			res[name] = val
+		}
+	}
+	if checkRequired {
+		for name, cf := range definitions {
+			if _, ok := res[name]; !ok && cf.Required() {
+				log.Panic(rs.T("Field '%s' is required.", cf.Label()))
+			}
+		}
+	}
+	return res
+}
+
+// SearchByCustomField returns the records of this model whose custom field
+// with the given name has the given value.
+func customFieldMixin_SearchByCustomField(rs m.CustomFieldMixinSet, name string, value interface{}) m.CustomFieldMixinSet {
+	cf := rs.CustomFieldDefinition(name)
+	encoded, err := json.Marshal(map[string]interface{}{name: cf.NormalizeValue(value)})
+	if err != nil {
+		log.Panic("Unable to encode custom field value", "field", name, "error", err)
+	}
+	var ids []int64
+	// Custom values are stored as text: this containment query matches the
+	// expression of the GIN index registered on custom values.
+	query := fmt.Sprintf(`SELECT id FROM %s WHERE %s @> ?::jsonb`, rs.Collection().Model().Table(), customValuesExpression)
+	rs.Env().Cr().Select(&ids, query, string(encoded))
+	model := models.Registry.MustGet(rs.ModelName())
+	return rs.Search(q.CustomFieldMixinCondition{Condition: model.Field(models.ID).In(ids)})
+}
+
+// Create normalizes the given custom values. Required custom fields are only
+// enforced when the caller gives custom values, so that creation paths which
+// do not know about custom fields (e.g. user creation or imports) still work.
+func customFieldMixin_Create(rs m.CustomFieldMixinSet, vals m.CustomFieldMixinData) m.CustomFieldMixinSet {
+	if vals.HasCustomValues() {
+		vals.SetCustomValues(rs.NormalizeCustomValues(vals.CustomValues(), true))
+	}
+	return rs.Super().Create(vals)
+}
+
+func customFieldMixin_Write(rs m.CustomFieldMixinSet, vals m.CustomFieldMixinData) bool {
+	if vals.HasCustomValues() {
+		vals.SetCustomValues(rs.NormalizeCustomValues(vals.CustomValues(), true))
+	}
+	return rs.Super().Write(vals)
+}
+
+func init() {
+	models.NewModel("CustomField")
+	h.CustomField().SetDefaultOrder("Model", "Name")
+	h.CustomField().AddFields(fields_CustomField)
+	h.CustomField().AddSQLConstraint("name_model_uniq", "unique(model, name)", "A custom field with the same name already exists on this model!")
+
+	h.CustomField().NewMethod("CheckDefinition", customField_CheckDefinition)
+	h.CustomField().NewMethod("SelectionOptions", customField_SelectionOptions)
+	h.CustomField().NewMethod("NormalizeValue", customField_NormalizeValue)
+
+	models.NewMixinModel("CustomFieldMixin")
+	h.CustomFieldMixin().AddFields(fields_CustomFieldMixin)
+	h.CustomFieldMixin().NewMethod("CustomFieldDefinitions", customFieldMixin_CustomFieldDefinitions)
+	h.CustomFieldMixin().NewMethod("CustomFieldDefinition", customFieldMixin_CustomFieldDefinition)
+	h.CustomFieldMixin().NewMethod("CustomFieldValue", customFieldMixin_CustomFieldValue)
+	h.CustomFieldMixin().NewMethod("SetCustomFieldValues", customFieldMixin_SetCustomFieldValues)
+	h.CustomFieldMixin().NewMethod("NormalizeCustomValues", customFieldMixin_NormalizeCustomValues)
+	h.CustomFieldMixin().NewMethod("SearchByCustomField", customFieldMixin_SearchByCustomField)
+	h.CustomFieldMixin().Methods().Create().Extend(customFieldMixin_Create)
+	h.CustomFieldMixin().Methods().Write().Extend(customFieldMixin_Write)
+
+	for model := range CustomFieldModels {
+		RegisterIndex(IndexDefinition{
+			Name:        fmt.Sprintf("%s_custom_values_idx", strings.ToLower(model)),
+			Model:       model,
+			Method:      "gin",
+			Expressions: "(" + customValuesExpression + ")",
+		})
+	}
+}
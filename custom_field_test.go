@@ -0,0 +1,92 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCustomFields(t *testing.T) {
+	Convey("Testing custom fields", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.CustomField().Create(env, h.CustomField().NewData().
+				SetModel("Partner").
+				SetName("x_shoe_size").
+				SetLabel("Shoe Size"))
+			h.CustomField().Create(env, h.CustomField().NewData().
+				SetModel("Partner").
+				SetName("x_tier").
+				SetLabel("Tier").
+				SetType("selection").
+				SetSelectionValues("gold:Gold\nsilver:Silver"))
+			h.CustomField().Create(env, h.CustomField().NewData().
+				SetModel("Partner").
+				SetName("x_home_country").
+				SetLabel("Home Country").
+				SetType("many2one").
+				SetRelation("Country"))
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Custom Partner"))
+			Convey("Invalid definitions are rejected", func() {
+				So(func() {
+					h.CustomField().Create(env, h.CustomField().NewData().
+						SetModel("Partner").SetName("shoe_size").SetLabel("Shoe Size"))
+				}, ShouldPanic)
+				So(func() {
+					h.CustomField().Create(env, h.CustomField().NewData().
+						SetModel("Partner").SetName("x_rel").SetLabel("Rel").SetType("many2one").SetRelation("NoModel"))
+				}, ShouldPanic)
+			})
+			Convey("Values can be set and read", func() {
+				france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+				partner.SetCustomFieldValues(map[string]interface{}{
+					"x_shoe_size":    "42",
+					"x_tier":         "gold",
+					"x_home_country": france,
+				})
+				So(partner.CustomFieldValue("x_shoe_size"), ShouldEqual, "42")
+				So(partner.CustomFieldValue("x_tier"), ShouldEqual, "gold")
+				So(partner.CustomFieldValue("x_home_country").(models.RecordSet).Ids(), ShouldResemble, france.Ids())
+				partner.SetCustomFieldValues(map[string]interface{}{"x_tier": nil})
+				So(partner.CustomFieldValue("x_tier"), ShouldBeNil)
+				So(partner.CustomFieldValue("x_shoe_size"), ShouldEqual, "42")
+			})
+			Convey("Invalid values are rejected", func() {
+				So(func() { partner.SetCustomFieldValues(map[string]interface{}{"x_tier": "bronze"}) }, ShouldPanic)
+				So(func() { partner.SetCustomFieldValues(map[string]interface{}{"x_shoe_size": 42}) }, ShouldPanic)
+			})
+			Convey("Values of unknown fields are dropped", func() {
+				partner.SetCustomFieldValues(map[string]interface{}{"x_shoe_size": "42", "x_unknown": "val"})
+				So(partner.CustomValues(), ShouldNotContainKey, "x_unknown")
+				So(partner.CustomFieldValue("x_shoe_size"), ShouldEqual, "42")
+			})
+			Convey("Required fields are only enforced when custom values are given", func() {
+				h.CustomField().Create(env, h.CustomField().NewData().
+					SetModel("Partner").
+					SetName("x_account").
+					SetLabel("Account").
+					SetRequired(true))
+				other := h.Partner().Create(env, h.Partner().NewData().SetName("Plain Partner"))
+				So(other.IsNotEmpty(), ShouldBeTrue)
+				So(func() {
+					h.Partner().Create(env, h.Partner().NewData().
+						SetName("Custom Partner").
+						SetCustomValues(basetypes.CustomFieldValues{"x_shoe_size": "42"}))
+				}, ShouldPanic)
+			})
+			Convey("Records can be searched by custom field", func() {
+				partner.SetCustomFieldValues(map[string]interface{}{"x_tier": "silver"})
+				res := partner.SearchByCustomField("x_tier", "silver")
+				So(res.Ids(), ShouldResemble, partner.Ids())
+				So(partner.SearchByCustomField("x_tier", "gold").IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,511 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/fieldtype"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// DataSyncBundleVersion is the version of the bundle format written by ExportBundle
+const DataSyncBundleVersion = 1
+
+// Modes of data sync bundle imports
+const (
+	// DataSyncPreview only computes the differences, without writing anything
+	DataSyncPreview = "preview"
+	// DataSyncMerge creates the missing records and updates the existing ones
+	DataSyncMerge = "merge"
+	// DataSyncCreateOnly creates the missing records and keeps the existing ones
+	DataSyncCreateOnly = "create"
+)
+
+// DataSyncModes is the selection of the available data sync import modes
+var DataSyncModes = types.Selection{
+	DataSyncPreview:    "Preview differences only",
+	DataSyncMerge:      "Create and update records",
+	DataSyncCreateOnly: "Create missing records only",
+}
+
+// Outcomes of the import of a data sync record
+const (
+	DataSyncCreated   = "created"
+	DataSyncUpdated   = "updated"
+	DataSyncUnchanged = "unchanged"
+	DataSyncSkipped   = "skipped"
+)
+
+// DataSyncDefaultModels are the master data models of new data sync profiles
+var DataSyncDefaultModels = []string{"Country", "CountryState", "Currency", "PartnerCategory", "Sequence", "MailTemplate"}
+
+// dataSyncSkippedFields are technical fields that are never synchronized
+var dataSyncSkippedFields = map[string]bool{
+	"ID":              true,
+	"HexyaExternalID": true,
+	"HexyaVersion":    true,
+	"CreateDate":      true,
+	"CreateUID":       true,
+	"WriteDate":       true,
+	"WriteUID":        true,
+}
+
+var dataSyncExcludedFields = struct {
+	sync.RWMutex
+	fields map[string]bool
+}{
+	fields: make(map[string]bool),
+}
+
+// RegisterDataSyncExcludedField declares that the given field of the given
+// model holds instance specific data, such as counters, and must not be
+// exported nor overwritten by data sync profiles.
+func RegisterDataSyncExcludedField(model, field string) {
+	dataSyncExcludedFields.Lock()
+	defer dataSyncExcludedFields.Unlock()
+	dataSyncExcludedFields.fields[fmt.Sprintf("%s.%s", model, field)] = true
+}
+
+// A DataSyncRecord is a record of a data sync bundle.
+//
+// Values are keyed by field name. Relational fields hold the external IDs
+// of the related records.
+type DataSyncRecord struct {
+	Model      string                 `json:"model"`
+	ExternalID string                 `json:"external_id"`
+	Values     map[string]interface{} `json:"values"`
+}
+
+// A DataSyncBundle holds the master data exported by a data sync profile
+type DataSyncBundle struct {
+	Version  int              `json:"version"`
+	Profile  string           `json:"profile"`
+	Exported string           `json:"exported"`
+	Records  []DataSyncRecord `json:"records"`
+}
+
+// A DataSyncResult is the outcome of the import of a DataSyncRecord
+type DataSyncResult struct {
+	Model      string
+	ExternalID string
+	Outcome    string
+	// Changes lists the differing fields of an existing record as 'Field: old -> new'
+	Changes  []string
+	Warnings []string
+}
+
+// dataSyncFields returns the fields of the given model that are synchronized,
+// sorted by name. These are the stored and writable fields, except binaries
+// and the reverse side of relations.
+func dataSyncFields(model *models.Model) []*models.FieldInfo {
+	dataSyncExcludedFields.RLock()
+	defer dataSyncExcludedFields.RUnlock()
+	var res []*models.FieldInfo
+	for _, fi := range model.FieldsGet() {
+		if !fi.Store || fi.ReadOnly || dataSyncSkippedFields[fi.Name] ||
+			dataSyncExcludedFields.fields[fmt.Sprintf("%s.%s", model.Name(), fi.Name)] {
+			continue
+		}
+		switch fi.Type {
+		case fieldtype.Binary, fieldtype.One2Many, fieldtype.Rev2One:
+			continue
+		}
+		res = append(res, fi)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// dataSyncExternalID returns the external ID of the given record
+func dataSyncExternalID(rec *models.RecordCollection) string {
+	xid, _ := rec.Get(rec.Model().FieldName("HexyaExternalID")).(string)
+	return xid
+}
+
+// dataSyncValue returns the bundle value of the given field value
+func dataSyncValue(fi *models.FieldInfo, value interface{}) interface{} {
+	switch fi.Type {
+	case fieldtype.Many2One, fieldtype.One2One:
+		rs, ok := value.(models.RecordSet)
+		if !ok || len(rs.Ids()) == 0 {
+			return ""
+		}
+		return dataSyncExternalID(rs.Collection())
+	case fieldtype.Many2Many:
+		xids := make([]string, 0)
+		if rs, ok := value.(models.RecordSet); ok {
+			for _, rec := range rs.Collection().Records() {
+				xids = append(xids, dataSyncExternalID(rec))
+			}
+		}
+		sort.Strings(xids)
+		return xids
+	case fieldtype.Date:
+		date, ok := value.(dates.Date)
+		if !ok || date.IsZero() {
+			return ""
+		}
+		return date.String()
+	case fieldtype.DateTime:
+		dateTime, ok := value.(dates.DateTime)
+		if !ok || dateTime.IsZero() {
+			return ""
+		}
+		return dateTime.String()
+	}
+	return value
+}
+
+// dataSyncJSON returns the JSON representation of the given bundle value
+func dataSyncJSON(value interface{}) string {
+	res, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(res)
+}
+
+// dataSyncResolve returns the records of the given model with the given
+// external IDs, and the external IDs that were not found.
+func dataSyncResolve(env models.Environment, model string, xids []string) (*models.RecordCollection, []string) {
+	rc := asSuperUser(env.Pool(model), "resolve synchronized records").WithContext("active_test", false)
+	if len(xids) == 0 {
+		return rc, nil
+	}
+	rc = rc.Search(rc.Model().Field(rc.Model().FieldName("HexyaExternalID")).In(xids))
+	found := make(map[string]bool)
+	for _, rec := range rc.Records() {
+		found[dataSyncExternalID(rec)] = true
+	}
+	var missing []string
+	for _, xid := range xids {
+		if !found[xid] {
+			missing = append(missing, xid)
+		}
+	}
+	return rc, missing
+}
+
+// dataSyncFieldValue converts the given bundle value of the given field to
+// the value to write on the record. It also returns the external IDs of the
+// related records that do not exist in this database.
+func dataSyncFieldValue(env models.Environment, fi *models.FieldInfo, value interface{}) (interface{}, []string) {
+	switch fi.Type {
+	case fieldtype.Many2One, fieldtype.One2One:
+		xid, _ := value.(string)
+		if xid == "" {
+			return env.Pool(fi.Relation), nil
+		}
+		return dataSyncResolve(env, fi.Relation, []string{xid})
+	case fieldtype.Many2Many:
+		var xids []string
+		switch val := value.(type) {
+		case []string:
+			xids = val
+		case []interface{}:
+			for _, xid := range val {
+				xids = append(xids, fmt.Sprintf("%v", xid))
+			}
+		}
+		return dataSyncResolve(env, fi.Relation, xids)
+	case fieldtype.Integer:
+		if val, ok := value.(float64); ok {
+			return int64(val), nil
+		}
+	case fieldtype.Date:
+		val, _ := value.(string)
+		if val == "" {
+			return dates.Date{}, nil
+		}
+		return dates.ParseDate(val), nil
+	case fieldtype.DateTime:
+		val, _ := value.(string)
+		if val == "" {
+			return dates.DateTime{}, nil
+		}
+		return dates.ParseDateTime(val), nil
+	}
+	return value, nil
+}
+
+var fields_DataSyncProfile = map[string]models.FieldDefinition{
+	"Name":        fields.Char{Required: true},
+	"Description": fields.Text{},
+	"Models": fields.Many2Many{RelationModel: h.Model(), JSON: "model_ids",
+		Default: func(env models.Environment) interface{} {
+			return h.Model().Search(env, q.Model().Name().In(DataSyncDefaultModels))
+		},
+		Help: "Models whose records are exported and imported by this profile"},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// ExportBundle returns the records of the models of this profile, archived
+// ones included, with their external IDs.
+//
+// Records are matched by external ID when the bundle is imported. The
+// external IDs of records created from data files are the same on all
+// instances, as well as those of a staging database restored from a backup
+// of the production database.
+func dataSyncProfile_ExportBundle(rs m.DataSyncProfileSet) *DataSyncBundle {
+	rs.EnsureOne()
+	bundle := &DataSyncBundle{
+		Version:  DataSyncBundleVersion,
+		Profile:  rs.Name(),
+		Exported: dates.Now().String(),
+		Records:  make([]DataSyncRecord, 0),
+	}
+	for _, modelRec := range rs.Models().Records() {
+		model, ok := models.Registry.Get(modelRec.Name())
+		if !ok {
+			continue
+		}
+		syncFields := dataSyncFields(model)
+		records := asSuperUser(rs.Env().Pool(model.Name()), "export synchronized records").WithContext("active_test", false).SearchAll()
+		for _, rec := range records.Records() {
+			values := make(map[string]interface{})
+			for _, fi := range syncFields {
+				values[fi.Name] = dataSyncValue(fi, rec.Get(model.FieldName(fi.Name)))
+			}
+			bundle.Records = append(bundle.Records, DataSyncRecord{
+				Model:      model.Name(),
+				ExternalID: dataSyncExternalID(rec),
+				Values:     values,
+			})
+		}
+	}
+	return bundle
+}
+
+// ImportBundle imports the given bundle in this database with the given mode,
+// which is one of DataSyncPreview, DataSyncMerge or DataSyncCreateOnly.
+// Records of models that are not part of this profile are skipped.
+//
+// It returns the outcome of each record of the bundle. Relations to records
+// that do not exist in this database are reported as warnings. Relations to
+// records that appear later in the bundle are set once all records have been
+// imported.
+func dataSyncProfile_ImportBundle(rs m.DataSyncProfileSet, bundle *DataSyncBundle, mode string) []DataSyncResult {
+	rs.EnsureOne()
+	if bundle.Version != DataSyncBundleVersion {
+		log.Panic(rs.T("Unsupported data sync bundle version %d", bundle.Version))
+	}
+	profileModels := make(map[string]bool)
+	for _, modelRec := range rs.Models().Records() {
+		profileModels[modelRec.Name()] = true
+	}
+	bundleRecords := make(map[string]bool)
+	for _, record := range bundle.Records {
+		bundleRecords[fmt.Sprintf("%s/%s", record.Model, record.ExternalID)] = true
+	}
+	type pendingValue struct {
+		result int
+		record *models.RecordCollection
+		field  *models.FieldInfo
+		value  interface{}
+	}
+	var pending []pendingValue
+	results := make([]DataSyncResult, len(bundle.Records))
+	for i, record := range bundle.Records {
+		results[i] = DataSyncResult{Model: record.Model, ExternalID: record.ExternalID}
+		model, ok := models.Registry.Get(record.Model)
+		if !ok || !profileModels[record.Model] || record.ExternalID == "" {
+			results[i].Outcome = DataSyncSkipped
+			continue
+		}
+		existing, _ := dataSyncResolve(rs.Env(), record.Model, []string{record.ExternalID})
+		data := models.NewModelDataFromRS(existing)
+		var deferred []pendingValue
+		for _, fi := range dataSyncFields(model) {
+			value, ok := record.Values[fi.Name]
+			if !ok {
+				continue
+			}
+			if existing.IsNotEmpty() {
+				current := dataSyncValue(fi, existing.Get(model.FieldName(fi.Name)))
+				if dataSyncJSON(current) == dataSyncJSON(value) {
+					continue
+				}
+				results[i].Changes = append(results[i].Changes,
+					fmt.Sprintf("%s: %s -> %s", fi.Name, dataSyncJSON(current), dataSyncJSON(value)))
+			}
+			fieldValue, missing := dataSyncFieldValue(rs.Env(), fi, value)
+			if len(missing) > 0 {
+				deferred = append(deferred, pendingValue{result: i, field: fi, value: value})
+				continue
+			}
+			data.Set(model.FieldName(fi.Name), fieldValue)
+		}
+		switch {
+		case existing.IsEmpty():
+			results[i].Outcome = DataSyncCreated
+		case len(results[i].Changes) == 0:
+			results[i].Outcome = DataSyncUnchanged
+		case mode == DataSyncCreateOnly:
+			results[i].Outcome = DataSyncSkipped
+		default:
+			results[i].Outcome = DataSyncUpdated
+		}
+		if mode != DataSyncPreview {
+			switch results[i].Outcome {
+			case DataSyncCreated:
+				data.Set(model.FieldName("HexyaExternalID"), record.ExternalID)
+				existing = existing.Call("Create", data).(models.RecordSet).Collection()
+			case DataSyncUpdated:
+				existing.Call("Write", data)
+			}
+		}
+		if results[i].Outcome == DataSyncCreated || results[i].Outcome == DataSyncUpdated {
+			for _, pv := range deferred {
+				pv.record = existing
+				pending = append(pending, pv)
+			}
+		}
+	}
+	for _, pv := range pending {
+		fieldValue, missing := dataSyncFieldValue(rs.Env(), pv.field, pv.value)
+		var unknown []string
+		for _, xid := range missing {
+			if mode == DataSyncPreview && bundleRecords[fmt.Sprintf("%s/%s", pv.field.Relation, xid)] {
+				continue
+			}
+			unknown = append(unknown, xid)
+		}
+		if len(unknown) > 0 {
+			results[pv.result].Warnings = append(results[pv.result].Warnings,
+				rs.T("%s: unknown related records %s", pv.field.Name, strings.Join(unknown, ", ")))
+			continue
+		}
+		if mode == DataSyncPreview {
+			continue
+		}
+		data := models.NewModelDataFromRS(pv.record)
+		data.Set(pv.record.Model().FieldName(pv.field.Name), fieldValue)
+		pv.record.Call("Write", data)
+	}
+	return results
+}
+
+// dataSyncReportText returns a human readable report of the given import results.
+// Unchanged records without warnings are only counted.
+func dataSyncReportText(results []DataSyncResult) string {
+	counts := make(map[string]int)
+	var lines []string
+	for _, res := range results {
+		counts[res.Outcome]++
+		if res.Outcome == DataSyncUnchanged && len(res.Warnings) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("[%s] %s %s", res.Outcome, res.Model, res.ExternalID))
+		for _, change := range res.Changes {
+			lines = append(lines, "    "+change)
+		}
+		for _, warning := range res.Warnings {
+			lines = append(lines, "    warning: "+warning)
+		}
+	}
+	header := fmt.Sprintf("%d created, %d updated, %d unchanged, %d skipped",
+		counts[DataSyncCreated], counts[DataSyncUpdated], counts[DataSyncUnchanged], counts[DataSyncSkipped])
+	if len(lines) == 0 {
+		return header
+	}
+	return header + "\n\n" + strings.Join(lines, "\n")
+}
+
+var fields_DataSyncWizard = map[string]models.FieldDefinition{
+	"Profile": fields.Many2One{RelationModel: h.DataSyncProfile(), Required: true, OnDelete: models.Cascade,
+		Default: func(env models.Environment) interface{} {
+			return h.DataSyncProfile().BrowseOne(env, env.Context().GetInteger(ContextKeyActiveID))
+		}},
+	"Mode": fields.Selection{Selection: DataSyncModes, Required: true,
+		Default: models.DefaultValue(DataSyncPreview)},
+	"Data":     fields.Binary{String: "Bundle", Help: "JSON bundle exported by a data sync profile"},
+	"FileName": fields.Char{},
+	"Report":   fields.Text{ReadOnly: true},
+}
+
+// Reopen returns an action that opens this wizard again
+func dataSyncWizard_Reopen(rs m.DataSyncWizardSet) *actions.Action {
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Model:    "DataSyncWizard",
+		ResID:    rs.ID(),
+		ViewMode: "form",
+		Target:   "new",
+	}
+}
+
+// ActionExport exports the bundle of the profile of this wizard
+// in its Data field, ready to be downloaded.
+func dataSyncWizard_ActionExport(rs m.DataSyncWizardSet) *actions.Action {
+	rs.EnsureOne()
+	bundle := rs.Profile().ExportBundle()
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		log.Panic(rs.T("Unable to encode the data sync bundle: %s", err))
+	}
+	rs.SetData(base64.StdEncoding.EncodeToString(data))
+	rs.SetFileName(fmt.Sprintf("%s.json", strings.ReplaceAll(strings.ToLower(rs.Profile().Name()), " ", "_")))
+	rs.SetReport(rs.T("%d records exported", len(bundle.Records)))
+	return rs.Reopen()
+}
+
+// ActionImport imports the bundle of this wizard with its mode and
+// writes the differences found in its Report field.
+func dataSyncWizard_ActionImport(rs m.DataSyncWizardSet) *actions.Action {
+	rs.EnsureOne()
+	data, err := base64.StdEncoding.DecodeString(rs.Data())
+	if err != nil || len(data) == 0 {
+		log.Panic(rs.T("Unable to decode the data sync bundle: %s", err))
+	}
+	var bundle DataSyncBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		log.Panic(rs.T("Invalid data sync bundle: %s", err))
+	}
+	results := rs.Profile().ImportBundle(&bundle, rs.Mode())
+	report := dataSyncReportText(results)
+	rs.SetReport(report)
+	if rs.Mode() != DataSyncPreview {
+		LogEvent(rs.Env(), LogEntry{
+			Level:   LoggingInfo,
+			Logger:  "data_sync",
+			Message: fmt.Sprintf("Bundle '%s' of %s imported: %s", bundle.Profile, bundle.Exported, strings.SplitN(report, "\n", 2)[0]),
+			Func:    "DataSyncWizard.ActionImport",
+			Model:   "DataSyncProfile",
+			ResID:   rs.Profile().ID(),
+		})
+	}
+	return rs.Reopen()
+}
+
+func init() {
+	RegisterDataSyncExcludedField("Sequence", "NumberNext")
+	RegisterDataSyncExcludedField("Sequence", "NumberNextActual")
+	RegisterDataSyncExcludedField("PartnerCategory", "Partners")
+
+	models.NewModel("DataSyncProfile")
+	h.DataSyncProfile().AddFields(fields_DataSyncProfile)
+	h.DataSyncProfile().NewMethod("ExportBundle", dataSyncProfile_ExportBundle)
+	h.DataSyncProfile().NewMethod("ImportBundle", dataSyncProfile_ImportBundle)
+
+	models.NewTransientModel("DataSyncWizard")
+	h.DataSyncWizard().AddFields(fields_DataSyncWizard)
+	h.DataSyncWizard().NewMethod("Reopen", dataSyncWizard_Reopen)
+	h.DataSyncWizard().NewMethod("ActionExport", dataSyncWizard_ActionExport)
+	h.DataSyncWizard().NewMethod("ActionImport", dataSyncWizard_ActionImport)
+}
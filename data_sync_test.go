@@ -0,0 +1,134 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDataSync(t *testing.T) {
+	Convey("Testing data sync profiles", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			profile := h.DataSyncProfile().Create(env, h.DataSyncProfile().NewData().
+				SetName("Tags").
+				SetModels(h.Model().Search(env, q.Model().Name().Equals("PartnerCategory"))))
+			parent := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().
+				SetName("Sync Parent").
+				SetHexyaExternalID("test_sync_parent"))
+			child := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().
+				SetName("Sync Child").
+				SetParent(parent).
+				SetHexyaExternalID("test_sync_child"))
+			bundle := profile.ExportBundle()
+			exported := func(xid string) *DataSyncRecord {
+				for i, record := range bundle.Records {
+					if record.ExternalID == xid {
+						return &bundle.Records[i]
+					}
+				}
+				return nil
+			}
+			Convey("Records are exported with the external IDs of related records", func() {
+				So(bundle.Version, ShouldEqual, DataSyncBundleVersion)
+				record := exported("test_sync_child")
+				So(record, ShouldNotBeNil)
+				So(record.Model, ShouldEqual, "PartnerCategory")
+				So(record.Values["Name"], ShouldEqual, "Sync Child")
+				So(record.Values["Parent"], ShouldEqual, "test_sync_parent")
+				So(record.Values, ShouldNotContainKey, "Partners")
+				So(record.Values, ShouldNotContainKey, "HexyaExternalID")
+			})
+			Convey("Bundles survive a JSON round trip", func() {
+				data, err := json.Marshal(bundle)
+				So(err, ShouldBeNil)
+				var decoded DataSyncBundle
+				So(json.Unmarshal(data, &decoded), ShouldBeNil)
+				results := profile.ImportBundle(&decoded, DataSyncPreview)
+				for _, res := range results {
+					So(res.Outcome, ShouldEqual, DataSyncUnchanged)
+				}
+			})
+			Convey("Preview reports differences without writing", func() {
+				child.SetName("Renamed Child")
+				results := profile.ImportBundle(bundle, DataSyncPreview)
+				So(child.Name(), ShouldEqual, "Renamed Child")
+				var found bool
+				for _, res := range results {
+					if res.ExternalID == "test_sync_child" {
+						found = true
+						So(res.Outcome, ShouldEqual, DataSyncUpdated)
+						So(res.Changes, ShouldResemble, []string{`Name: "Renamed Child" -> "Sync Child"`})
+					}
+				}
+				So(found, ShouldBeTrue)
+				So(dataSyncReportText(results), ShouldContainSubstring, "[updated] PartnerCategory test_sync_child")
+			})
+			Convey("Merge creates missing records and updates existing ones", func() {
+				child.Unlink()
+				parent.SetName("Renamed Parent")
+				profile.ImportBundle(bundle, DataSyncMerge)
+				So(parent.Name(), ShouldEqual, "Sync Parent")
+				created := h.PartnerCategory().Search(env, q.PartnerCategory().HexyaExternalID().Equals("test_sync_child"))
+				So(created.Len(), ShouldEqual, 1)
+				So(created.Name(), ShouldEqual, "Sync Child")
+				So(created.Parent().Equals(parent), ShouldBeTrue)
+				results := profile.ImportBundle(bundle, DataSyncMerge)
+				for _, res := range results {
+					So(res.Outcome, ShouldEqual, DataSyncUnchanged)
+				}
+			})
+			Convey("Create only mode keeps existing records", func() {
+				parent.SetName("Renamed Parent")
+				results := profile.ImportBundle(bundle, DataSyncCreateOnly)
+				So(parent.Name(), ShouldEqual, "Renamed Parent")
+				for _, res := range results {
+					if res.ExternalID == "test_sync_parent" {
+						So(res.Outcome, ShouldEqual, DataSyncSkipped)
+					}
+				}
+			})
+			Convey("Relations to records created later in the bundle are resolved", func() {
+				bundle := &DataSyncBundle{
+					Version: DataSyncBundleVersion,
+					Records: []DataSyncRecord{
+						{Model: "PartnerCategory", ExternalID: "test_sync_late_child",
+							Values: map[string]interface{}{"Name": "Late Child", "Parent": "test_sync_late_parent"}},
+						{Model: "PartnerCategory", ExternalID: "test_sync_late_parent",
+							Values: map[string]interface{}{"Name": "Late Parent"}},
+						{Model: "PartnerCategory", ExternalID: "test_sync_orphan",
+							Values: map[string]interface{}{"Name": "Orphan", "Parent": "test_sync_unknown"}},
+						{Model: "Currency", ExternalID: "test_sync_currency",
+							Values: map[string]interface{}{"Name": "XTS"}},
+					},
+				}
+				preview := profile.ImportBundle(bundle, DataSyncPreview)
+				So(preview[0].Outcome, ShouldEqual, DataSyncCreated)
+				So(preview[0].Warnings, ShouldBeEmpty)
+				So(preview[2].Warnings, ShouldHaveLength, 1)
+				So(preview[3].Outcome, ShouldEqual, DataSyncSkipped)
+				So(h.PartnerCategory().Search(env, q.PartnerCategory().Name().Equals("Late Child")).IsEmpty(), ShouldBeTrue)
+
+				profile.ImportBundle(bundle, DataSyncMerge)
+				lateChild := h.PartnerCategory().Search(env, q.PartnerCategory().HexyaExternalID().Equals("test_sync_late_child"))
+				So(lateChild.Parent().Name(), ShouldEqual, "Late Parent")
+				orphan := h.PartnerCategory().Search(env, q.PartnerCategory().HexyaExternalID().Equals("test_sync_orphan"))
+				So(orphan.Parent().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Sequence counters are not synchronized", func() {
+				model := models.Registry.MustGet("Sequence")
+				for _, fi := range dataSyncFields(model) {
+					So(fi.Name, ShouldNotEqual, "NumberNext")
+					So(fi.Name, ShouldNotEqual, "NumberNextActual")
+				}
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,320 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/google/uuid"
+)
+
+// Masking kinds define how the values of a masked field are pseudonymized
+const (
+	// MaskText replaces letters and digits, keeping case, spaces and punctuation
+	MaskText = "text"
+	// MaskEmail masks the local part and the domain of email addresses.
+	// Domains are moved to the reserved .example TLD so that no email can be
+	// delivered from a masked database.
+	MaskEmail = "email"
+	// MaskPhone replaces digits, keeping separators and the country code
+	MaskPhone = "phone"
+	// MaskIBAN replaces the account number, keeping the country code
+	// and computing valid check digits
+	MaskIBAN = "iban"
+	// MaskEmailList masks each address of a comma-separated list of emails
+	MaskEmailList = "email_list"
+	// MaskClear erases the value, e.g. for email bodies or signatures
+	MaskClear = "clear"
+)
+
+// A MaskedField is a field whose values are pseudonymized by MaskDatabase
+type MaskedField struct {
+	Model string
+	Field string
+	Kind  string
+}
+
+var maskedFields = struct {
+	sync.RWMutex
+	fields map[string]MaskedField
+}{
+	fields: make(map[string]MaskedField),
+}
+
+// RegisterMaskedField declares that the given field of the given model holds
+// personal data to be pseudonymized by the MaskDatabase wizard with the given
+// masking kind. It panics if the field is already registered.
+func RegisterMaskedField(model, field, kind string) {
+	maskedFields.Lock()
+	defer maskedFields.Unlock()
+	key := model + "." + field
+	if _, exists := maskedFields.fields[key]; exists {
+		log.Panic("Masked field already registered", "model", model, "field", field)
+	}
+	maskedFields.fields[key] = MaskedField{Model: model, Field: field, Kind: kind}
+}
+
+// MaskedFields returns the registered masked fields sorted by model and field
+func MaskedFields() []MaskedField {
+	maskedFields.RLock()
+	defer maskedFields.RUnlock()
+	res := make([]MaskedField, 0, len(maskedFields.fields))
+	for _, field := range maskedFields.fields {
+		res = append(res, field)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Model != res[j].Model {
+			return res[i].Model < res[j].Model
+		}
+		return res[i].Field < res[j].Field
+	})
+	return res
+}
+
+// maskStream returns n pseudo-random bytes derived from the given secret and
+// value. The same value always gives the same bytes for a given secret, so
+// that values repeated across records and models are masked consistently.
+func maskStream(secret, value string, n int) []byte {
+	var res []byte
+	counter := make([]byte, 4)
+	for i := uint32(0); len(res) < n; i++ {
+		mac := hmac.New(sha256.New, []byte(secret))
+		binary.BigEndian.PutUint32(counter, i)
+		mac.Write(counter)
+		mac.Write([]byte(value))
+		res = append(res, mac.Sum(nil)...)
+	}
+	return res[:n]
+}
+
+// maskFormat replaces each letter of value with a letter of the same case and
+// each digit with a digit. Other characters are kept.
+func maskFormat(secret, value string) string {
+	runes := []rune(value)
+	stream := maskStream(secret, value, len(runes))
+	for i, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			runes[i] = rune('A' + stream[i]%26)
+		case unicode.IsLetter(r):
+			runes[i] = rune('a' + stream[i]%26)
+		case unicode.IsDigit(r):
+			runes[i] = rune('0' + stream[i]%10)
+		}
+	}
+	return string(runes)
+}
+
+// maskEmail masks the local part and the domain of the given email
+// separately, so that addresses of the same domain keep the same domain.
+func maskEmail(secret, value string) string {
+	at := strings.LastIndex(value, "@")
+	if at < 0 {
+		return maskFormat(secret, value)
+	}
+	domain := value[at+1:]
+	if dot := strings.LastIndex(domain, "."); dot >= 0 {
+		domain = domain[:dot]
+	}
+	return maskFormat(secret, value[:at]) + "@" + maskFormat(secret, domain) + ".example"
+}
+
+// maskPhone masks the digits of the given phone number, keeping the
+// international prefix of numbers starting with '+'.
+func maskPhone(secret, value string) string {
+	if strings.HasPrefix(value, "+") && len(value) > 3 {
+		return value[:3] + maskFormat(secret, value[3:])
+	}
+	return maskFormat(secret, value)
+}
+
+// ibanCheckDigits returns the check digits of the IBAN with the given
+// country code and BBAN.
+func ibanCheckDigits(country, bban string) string {
+	var digits strings.Builder
+	for _, r := range bban + country + "00" {
+		if r >= 'A' && r <= 'Z' {
+			digits.WriteString(fmt.Sprintf("%d", r-'A'+10))
+			continue
+		}
+		digits.WriteRune(r)
+	}
+	num, _ := new(big.Int).SetString(digits.String(), 10)
+	mod := new(big.Int).Mod(num, big.NewInt(97)).Int64()
+	return fmt.Sprintf("%02d", 98-mod)
+}
+
+// maskIBAN masks the BBAN of the given IBAN and computes new check digits,
+// so that the masked IBAN is still valid. Values which are not IBANs are
+// masked as text.
+func maskIBAN(secret, value string) string {
+	iban := sanitizeAccountNumber(value)
+	if len(iban) < 5 || !unicode.IsLetter(rune(iban[0])) || !unicode.IsLetter(rune(iban[1])) {
+		return maskFormat(secret, value)
+	}
+	country, bban := iban[:2], maskFormat(secret, iban[4:])
+	res := country + ibanCheckDigits(country, bban) + bban
+	if !strings.Contains(value, " ") {
+		return res
+	}
+	var grouped []string
+	for i := 0; i < len(res); i += 4 {
+		end := i + 4
+		if end > len(res) {
+			end = len(res)
+		}
+		grouped = append(grouped, res[i:end])
+	}
+	return strings.Join(grouped, " ")
+}
+
+// maskEmailList masks each address of the given comma-separated list
+func maskEmailList(secret, value string) string {
+	addresses := strings.Split(value, ",")
+	for i, address := range addresses {
+		addresses[i] = maskEmail(secret, strings.TrimSpace(address))
+	}
+	return strings.Join(addresses, ", ")
+}
+
+// maskValue returns the masked value of the given kind
+func maskValue(kind, secret, value string) string {
+	switch kind {
+	case MaskClear:
+		return ""
+	case MaskEmail:
+		return maskEmail(secret, value)
+	case MaskEmailList:
+		return maskEmailList(secret, value)
+	case MaskPhone:
+		return maskPhone(secret, value)
+	case MaskIBAN:
+		return maskIBAN(secret, value)
+	default:
+		return maskFormat(secret, value)
+	}
+}
+
+var fields_MaskDatabase = map[string]models.FieldDefinition{
+	"Seed": fields.Char{Required: true,
+		Default: func(env models.Environment) interface{} {
+			return uuid.New().String()
+		}, Help: "Secret used to pseudonymize values. The same seed always gives the same masked values."},
+	"Confirm": fields.Boolean{String: "I confirm this database is not a production database"},
+}
+
+// ActionMask pseudonymizes all the registered masked fields of the database.
+// Values are masked consistently: the same value in several records or
+// models gives the same masked value. The login of the current user and of
+// the administrator are kept so that they can still log in.
+//
+// Messages waiting to be sent are cancelled and mail servers are archived
+// first, so that nothing is sent from the masked database.
+func maskDatabase_ActionMask(rs m.MaskDatabaseSet) *actions.Action {
+	rs.EnsureOne()
+	if !rs.Confirm() {
+		log.Panic(rs.T("Please confirm that this database is not a production database"))
+	}
+	configParams := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("mask database")
+	if configParams.GetParam("base.production_database", "false") == "true" {
+		log.Panic(rs.T("This database is flagged as a production database and cannot be masked"))
+	}
+	disableOutgoingMessages(rs.Env())
+	keptUsers := map[int64]bool{security.SuperUserID: true, rs.Env().Uid(): true}
+	for _, mf := range MaskedFields() {
+		model, ok := models.Registry.Get(mf.Model)
+		if !ok {
+			log.Warn("Unknown model for masked field", "model", mf.Model, "field", mf.Field)
+			continue
+		}
+		field := model.FieldName(mf.Field)
+		records := asSuperUser(rs.Env().Pool(mf.Model), "mask database").
+			WithContext(ContextKeyPartnerSkipSync, true).
+			WithContext(ContextKeySkipAddressValidation, true).
+			SearchAll()
+		for _, rec := range records.Records() {
+			if mf.Model == "User" && keptUsers[rec.Ids()[0]] {
+				continue
+			}
+			value, _ := rec.Get(field).(string)
+			if value == "" {
+				continue
+			}
+			rec.Set(field, maskValue(mf.Kind, rs.Seed(), value))
+		}
+		log.Info("Masked field", "model", mf.Model, "field", mf.Field, "records", records.Len())
+	}
+	configParams.SetParam("base.database_masked", dates.Now().String())
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+// disableOutgoingMessages cancels the queued emails, SMS and instant messages
+// and archives the mail servers.
+func disableOutgoingMessages(env models.Environment) {
+	h.MailMessage().NewSet(env).AsSuperUser("mask database").
+		Search(q.MailMessage().State().Equals(MailMessageOutgoing)).
+		Write(h.MailMessage().NewData().SetState(MailMessageCancelled))
+	h.SMSMessage().NewSet(env).AsSuperUser("mask database").
+		Search(q.SMSMessage().State().Equals(SMSOutgoing)).
+		Write(h.SMSMessage().NewData().SetState(SMSCancelled))
+	h.IMMessage().NewSet(env).AsSuperUser("mask database").
+		Search(q.IMMessage().State().Equals(SMSOutgoing)).
+		Write(h.IMMessage().NewData().SetState(SMSCancelled))
+	h.MailServer().NewSet(env).AsSuperUser("mask database").SearchAll().
+		Write(h.MailServer().NewData().SetActive(false))
+}
+
+func init() {
+	RegisterMaskedField("Partner", "Name", MaskText)
+	RegisterMaskedField("Partner", "Email", MaskEmail)
+	RegisterMaskedField("Partner", "Phone", MaskPhone)
+	RegisterMaskedField("Partner", "Mobile", MaskPhone)
+	RegisterMaskedField("Partner", "Street", MaskText)
+	RegisterMaskedField("Partner", "Street2", MaskText)
+	RegisterMaskedField("Partner", "Zip", MaskText)
+	RegisterMaskedField("Partner", "City", MaskText)
+	RegisterMaskedField("Partner", "VAT", MaskText)
+	RegisterMaskedField("User", "Login", MaskEmail)
+	RegisterMaskedField("BankAccount", "Name", MaskIBAN)
+	RegisterMaskedField("BankAccount", "HolderName", MaskText)
+	RegisterMaskedField("ContactRequest", "Name", MaskText)
+	RegisterMaskedField("ContactRequest", "Email", MaskEmail)
+	RegisterMaskedField("ContactRequest", "Phone", MaskPhone)
+	RegisterMaskedField("ContactRequest", "CompanyName", MaskText)
+	RegisterMaskedField("ContactRequest", "Message", MaskText)
+	RegisterMaskedField("ContactRequest", "IPAddress", MaskText)
+	RegisterMaskedField("MailMessage", "EmailFrom", MaskEmail)
+	RegisterMaskedField("MailMessage", "EmailTo", MaskEmailList)
+	RegisterMaskedField("MailMessage", "Subject", MaskText)
+	RegisterMaskedField("MailMessage", "BodyHTML", MaskClear)
+	RegisterMaskedField("MailMessage", "Headers", MaskClear)
+	RegisterMaskedField("SMSMessage", "Number", MaskPhone)
+	RegisterMaskedField("SMSMessage", "Body", MaskText)
+	RegisterMaskedField("IMMessage", "Phone", MaskPhone)
+	RegisterMaskedField("IMMessage", "Body", MaskText)
+	RegisterMaskedField("SignatureRequestSigner", "Signature", MaskClear)
+	RegisterMaskedField("SignatureAuditEntry", "IPAddress", MaskText)
+	RegisterMaskedField("SignatureAuditEntry", "Details", MaskText)
+	RegisterMaskedField("Logging", "Message", MaskText)
+
+	models.NewTransientModel("MaskDatabase")
+	h.MaskDatabase().AddFields(fields_MaskDatabase)
+	h.MaskDatabase().NewMethod("ActionMask", maskDatabase_ActionMask)
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMaskValues(t *testing.T) {
+	Convey("Testing value masking", t, func() {
+		Convey("Masking is deterministic and keeps the format", func() {
+			masked := maskValue(MaskText, "seed", "John Smith-Doe")
+			So(masked, ShouldNotEqual, "John Smith-Doe")
+			So(masked, ShouldEqual, maskValue(MaskText, "seed", "John Smith-Doe"))
+			So(masked, ShouldHaveLength, len("John Smith-Doe"))
+			So(masked[4], ShouldEqual, ' ')
+			So(masked[10], ShouldEqual, '-')
+			So(maskValue(MaskText, "other", "John Smith-Doe"), ShouldNotEqual, masked)
+		})
+		Convey("Emails are moved to the .example TLD", func() {
+			masked := maskValue(MaskEmail, "seed", "john.smith@acme.com")
+			So(masked, ShouldEndWith, ".example")
+			So(masked, ShouldContainSubstring, "@")
+			So(maskValue(MaskEmail, "seed", "jane@acme.com"), ShouldEndWith,
+				masked[len("john.smith"):])
+		})
+		Convey("Phones keep their international prefix", func() {
+			So(maskValue(MaskPhone, "seed", "+33 6 12 34 56 78"), ShouldStartWith, "+33 ")
+		})
+		Convey("Masked IBANs are valid", func() {
+			So(ibanCheckDigits("DE", "370400440532013000"), ShouldEqual, "89")
+			masked := maskValue(MaskIBAN, "seed", "DE89370400440532013000")
+			So(masked, ShouldStartWith, "DE")
+			So(masked, ShouldNotEqual, "DE89370400440532013000")
+			So(masked[2:4], ShouldEqual, ibanCheckDigits("DE", masked[4:]))
+		})
+	})
+}
+
+func TestMaskDatabase(t *testing.T) {
+	Convey("Testing the MaskDatabase wizard", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Jane Customer").
+				SetEmail("jane@customer.com").
+				SetPhone("+33 1 23 45 67 89"))
+			other := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Jane Customer").
+				SetEmail("sales@customer.com"))
+			Convey("Masking requires a confirmation", func() {
+				wizard := h.MaskDatabase().Create(env, h.MaskDatabase().NewData())
+				So(func() { wizard.ActionMask() }, ShouldPanic)
+			})
+			Convey("Masking pseudonymizes registered fields consistently", func() {
+				wizard := h.MaskDatabase().Create(env, h.MaskDatabase().NewData().
+					SetSeed("test-seed").
+					SetConfirm(true))
+				wizard.ActionMask()
+				So(partner.Name(), ShouldNotEqual, "Jane Customer")
+				So(partner.Name(), ShouldEqual, other.Name())
+				So(partner.Email(), ShouldEqual, maskValue(MaskEmail, "test-seed", "jane@customer.com"))
+				So(partner.Phone(), ShouldStartWith, "+33 ")
+				So(h.ConfigParameter().NewSet(env).GetParam("base.database_masked", ""), ShouldNotBeBlank)
+			})
+			Convey("Masking cancels queued messages and archives mail servers", func() {
+				message := h.MailMessage().Create(env, h.MailMessage().NewData().
+					SetEmailFrom("noreply@customer.com").
+					SetEmailTo("jane@customer.com, sales@customer.com").
+					SetBodyHTML("<p>Hello Jane</p>"))
+				server := h.MailServer().Create(env, h.MailServer().NewData().
+					SetName("Production SMTP").
+					SetHost("smtp.customer.com"))
+				wizard := h.MaskDatabase().Create(env, h.MaskDatabase().NewData().
+					SetSeed("test-seed").
+					SetConfirm(true))
+				wizard.ActionMask()
+				So(message.State(), ShouldEqual, MailMessageCancelled)
+				So(message.BodyHTML(), ShouldBeBlank)
+				So(message.EmailTo(), ShouldEqual, maskValue(MaskEmail, "test-seed", "jane@customer.com")+", "+
+					maskValue(MaskEmail, "test-seed", "sales@customer.com"))
+				So(server.Active(), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
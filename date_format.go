@@ -0,0 +1,93 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"time"
+
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Default date and time layouts, used when no language is given
+const (
+	DefaultDateFormat = "2006-01-02"
+	DefaultTimeFormat = "15:04:05"
+)
+
+// langFormats returns the date and time layouts of this language, or the
+// default ones if this LangSet is empty.
+func langFormats(rs m.LangSet) (string, string) {
+	if rs.IsEmpty() {
+		return DefaultDateFormat, DefaultTimeFormat
+	}
+	rs.EnsureOne()
+	dateFormat, timeFormat := rs.DateFormat(), rs.TimeFormat()
+	if dateFormat == "" {
+		dateFormat = DefaultDateFormat
+	}
+	if timeFormat == "" {
+		timeFormat = DefaultTimeFormat
+	}
+	return dateFormat, timeFormat
+}
+
+// FormatDate returns the given date formatted with the date format of this
+// language. It returns an empty string for the zero date.
+func lang_FormatDate(rs m.LangSet, date dates.Date) string {
+	if date.IsZero() {
+		return ""
+	}
+	dateFormat, _ := langFormats(rs)
+	return date.Time.Format(dateFormat)
+}
+
+// FormatDateTime returns the given datetime converted to the given timezone
+// and formatted with the date and time formats of this language. UTC is used
+// if tz is empty or invalid. It returns an empty string for the zero datetime.
+func lang_FormatDateTime(rs m.LangSet, dt dates.DateTime, tz string) string {
+	if dt.IsZero() {
+		return ""
+	}
+	dateFormat, timeFormat := langFormats(rs)
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Warn("Invalid timezone, using UTC", "tz", tz, "error", err)
+		loc = time.UTC
+	}
+	return dt.Time.In(loc).Format(dateFormat + " " + timeFormat)
+}
+
+// FormatInUserTZ returns the given datetime formatted for the current user,
+// i.e. in the timezone and with the language formats of the context, or of
+// the user if they are not set in the context.
+func baseMixin_FormatInUserTZ(rs m.BaseMixinSet, dt dates.DateTime) string {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	tz := ContextGetString(rs.Env(), ContextKeyTZ)
+	if tz == "" {
+		tz = user.TZ()
+	}
+	lang := ContextGetString(rs.Env(), ContextKeyLang)
+	if lang == "" {
+		lang = user.Lang()
+	}
+	return h.Lang().NewSet(rs.Env()).GetLang(lang).FormatDateTime(dt, tz)
+}
+
+// FormatInPartnerTZ returns the given datetime formatted for the given
+// partner, i.e. in their timezone and with the formats of their language.
+// It should be used for dates sent to partners, such as in emails.
+func baseMixin_FormatInPartnerTZ(rs m.BaseMixinSet, partner m.PartnerSet, dt dates.DateTime) string {
+	partner.EnsureOne()
+	return h.Lang().NewSet(rs.Env()).GetLang(partner.Lang()).FormatDateTime(dt, partner.TZ())
+}
+
+func init() {
+	h.Lang().NewMethod("FormatDate", lang_FormatDate)
+	h.Lang().NewMethod("FormatDateTime", lang_FormatDateTime)
+
+	h.BaseMixin().NewMethod("FormatInUserTZ", baseMixin_FormatInUserTZ)
+	h.BaseMixin().NewMethod("FormatInPartnerTZ", baseMixin_FormatInPartnerTZ)
+}
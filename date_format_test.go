@@ -0,0 +1,48 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDateFormat(t *testing.T) {
+	Convey("Testing timezone aware date formatting", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			dt := dates.DateTime{Time: time.Date(2020, 1, 31, 23, 30, 0, 0, time.UTC)}
+			french := h.Lang().NewSet(env).GetLang("fr_FR")
+			french.Write(h.Lang().NewData().
+				SetActive(true).
+				SetDateFormat("02/01/2006").
+				SetTimeFormat("15:04"))
+			Convey("Languages format dates with their formats", func() {
+				So(french.FormatDate(dt.ToDate()), ShouldEqual, "31/01/2020")
+				So(french.FormatDateTime(dt, "Europe/Paris"), ShouldEqual, "01/02/2020 00:30")
+				So(h.Lang().NewSet(env).FormatDateTime(dt, ""), ShouldEqual, "2020-01-31 23:30:00")
+				So(french.FormatDateTime(dates.DateTime{}, "Europe/Paris"), ShouldBeBlank)
+			})
+			Convey("Datetimes are formatted for partners", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Tokyo Partner").
+					SetLang("fr_FR").
+					SetTZ("Asia/Tokyo"))
+				So(partner.FormatInPartnerTZ(partner, dt), ShouldEqual, "01/02/2020 08:30")
+			})
+			Convey("Datetimes are formatted for the current user", func() {
+				user := h.User().NewSet(env).CurrentUser()
+				user.Write(h.User().NewData().SetTZ("America/New_York"))
+				partners := h.Partner().NewSet(env).WithContext(ContextKeyTZ, "").WithContext(ContextKeyLang, "fr_FR")
+				So(partners.FormatInUserTZ(dt), ShouldEqual, "31/01/2020 18:30")
+				So(partners.WithContext(ContextKeyTZ, "Europe/Paris").FormatInUserTZ(dt), ShouldEqual, "01/02/2020 00:30")
+			})
+		}), ShouldBeNil)
+	})
+}
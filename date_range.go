@@ -0,0 +1,194 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// DateRangeUnits is the selection of the units available to generate date ranges
+var DateRangeUnits = types.Selection{
+	"week":    "Weeks",
+	"month":   "Months",
+	"quarter": "Quarters",
+	"year":    "Years",
+}
+
+var fields_DateRangeType = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Translate: true},
+	"AllowOverlap": fields.Boolean{
+		Help: "If set, date ranges of this type can overlap each other."},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+	"Company": fields.Many2One{RelationModel: h.Company(), Index: true,
+		Default: func(env models.Environment) interface{} {
+			return h.Company().NewSet(env).CompanyDefaultGet()
+		}},
+	"Ranges": fields.One2Many{RelationModel: h.DateRange(), ReverseFK: "Type"},
+}
+
+// FindRange returns the date range of this type that contains the given date,
+// for the current company or shared between companies. It returns an empty
+// DateRangeSet if none is found, or the first one (by start date) if ranges
+// of this type are allowed to overlap.
+func dateRangeType_FindRange(rs m.DateRangeTypeSet, date dates.Date) m.DateRangeSet {
+	rs.EnsureOne()
+	company := h.Company().NewSet(rs.Env()).CompanyDefaultGet()
+	return h.DateRange().Search(rs.Env(),
+		q.DateRange().Type().Equals(rs).
+			And().DateStart().LowerOrEqual(date).
+			And().DateEnd().GreaterOrEqual(date).
+			AndCond(q.DateRange().Company().IsNull().Or().Company().Equals(company))).
+		OrderBy("DateStart").
+		Limit(1)
+}
+
+var fields_DateRange = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Translate: true},
+	"DateStart": fields.Date{String: "Start Date", Required: true, Index: true,
+		Constraint: h.DateRange().Methods().CheckDates()},
+	"DateEnd": fields.Date{String: "End Date", Required: true, Index: true,
+		Constraint: h.DateRange().Methods().CheckDates()},
+	"Type": fields.Many2One{RelationModel: h.DateRangeType(), Required: true, Index: true,
+		OnDelete: models.Restrict, Constraint: h.DateRange().Methods().CheckDates()},
+	"Company": fields.Many2One{RelationModel: h.Company(), Index: true,
+		Default: func(env models.Environment) interface{} {
+			return h.Company().NewSet(env).CompanyDefaultGet()
+		}, Constraint: h.DateRange().Methods().CheckDates()},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckDates checks that the start date is before the end date and that
+// the range does not overlap another range of the same type and company,
+// or a range of the same type shared between companies, unless the type
+// allows it.
+func dateRange_CheckDates(rs m.DateRangeSet) {
+	for _, dr := range rs.Records() {
+		if dr.DateStart().Greater(dr.DateEnd()) {
+			log.Panic(rs.T("%s is not a valid range (%s > %s)", dr.Name(), dr.DateStart(), dr.DateEnd()))
+		}
+		if dr.Type().AllowOverlap() {
+			continue
+		}
+		cond := q.DateRange().Type().Equals(dr.Type()).
+			And().ID().NotEquals(dr.ID()).
+			And().DateStart().LowerOrEqual(dr.DateEnd()).
+			And().DateEnd().GreaterOrEqual(dr.DateStart())
+		// Global ranges apply to all companies, so they overlap the ranges of any
+		// company, and company ranges overlap the global ones.
+		if dr.Company().IsNotEmpty() {
+			cond = cond.AndCond(q.DateRange().Company().IsNull().Or().Company().Equals(dr.Company()))
+		}
+		overlaps := h.DateRange().NewSet(rs.Env()).AsSuperUser("check date range overlaps").
+			Search(cond).Limit(1)
+		if overlaps.IsNotEmpty() {
+			log.Panic(rs.T("%s overlaps %s", dr.Name(), overlaps.Name()))
+		}
+	}
+}
+
+var fields_DateRangeGenerator = map[string]models.FieldDefinition{
+	"NamePrefix": fields.Char{String: "Range Name Prefix", Required: true},
+	"DateStart":  fields.Date{String: "Start Date", Required: true},
+	"Type":       fields.Many2One{RelationModel: h.DateRangeType(), Required: true},
+	"Company": fields.Many2One{RelationModel: h.Company(),
+		Default: func(env models.Environment) interface{} {
+			return h.Company().NewSet(env).CompanyDefaultGet()
+		}},
+	"Unit": fields.Selection{Selection: DateRangeUnits, Required: true,
+		Default: models.DefaultValue("month")},
+	"Duration": fields.Integer{Required: true, Default: models.DefaultValue(1), GoType: new(int),
+		Help: "Number of units in each generated range"},
+	"Count": fields.Integer{String: "Number of Ranges", Required: true, Default: models.DefaultValue(12),
+		GoType: new(int)},
+}
+
+// ComputeDateRanges returns the data of the date ranges to generate
+func dateRangeGenerator_ComputeDateRanges(rs m.DateRangeGeneratorSet) []m.DateRangeData {
+	rs.EnsureOne()
+	if rs.Duration() <= 0 || rs.Count() <= 0 {
+		log.Panic(rs.T("Duration and number of ranges must be strictly positive"))
+	}
+	res := make([]m.DateRangeData, rs.Count())
+	start := rs.DateStart().Time
+	for i := 0; i < rs.Count(); i++ {
+		rangeStart := dateRangeBoundary(start, rs.Unit(), rs.Duration(), i)
+		rangeEnd := dateRangeBoundary(start, rs.Unit(), rs.Duration(), i+1).AddDate(0, 0, -1)
+		res[i] = h.DateRange().NewData().
+			SetName(fmt.Sprintf("%s%0*d", rs.NamePrefix(), len(fmt.Sprint(rs.Count())), i+1)).
+			SetDateStart(dates.Date{Time: rangeStart}).
+			SetDateEnd(dates.Date{Time: rangeEnd}).
+			SetType(rs.Type()).
+			SetCompany(rs.Company())
+	}
+	return res
+}
+
+// dateRangeBoundary returns the start of the index-th range of duration
+// units generated from start. Month based units are computed from start
+// and clamped to the end of the month, so that ranges starting on the 31st
+// do not drift (e.g. Jan 31, Feb 28, Mar 31, Apr 30).
+func dateRangeBoundary(start time.Time, unit string, duration, index int) time.Time {
+	var months int
+	switch unit {
+	case "week":
+		return start.AddDate(0, 0, 7*duration*index)
+	case "quarter":
+		months = 3 * duration * index
+	case "year":
+		months = 12 * duration * index
+	default:
+		months = duration * index
+	}
+	firstOfMonth := time.Date(start.Year(), start.Month()+time.Month(months), 1, 0, 0, 0, 0, start.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := start.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return firstOfMonth.AddDate(0, 0, day-1)
+}
+
+// ActionApply generates the date ranges and opens them
+func dateRangeGenerator_ActionApply(rs m.DateRangeGeneratorSet) *actions.Action {
+	for _, data := range rs.ComputeDateRanges() {
+		h.DateRange().Create(rs.Env(), data)
+	}
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Model:    "DateRange",
+		ViewMode: "tree,form",
+		Domain:   fmt.Sprintf("[('type_id', '=', %d)]", rs.Type().ID()),
+	}
+}
+
+func init() {
+	models.NewModel("DateRangeType")
+	h.DateRangeType().SetDefaultOrder("Name")
+	h.DateRangeType().AddFields(fields_DateRangeType)
+	h.DateRangeType().AddSQLConstraint("date_range_type_uniq", "unique(name, company_id)",
+		"A date range type must be unique per company !")
+	h.DateRangeType().NewMethod("FindRange", dateRangeType_FindRange)
+
+	models.NewModel("DateRange")
+	h.DateRange().SetDefaultOrder("Type", "DateStart")
+	h.DateRange().AddFields(fields_DateRange)
+	h.DateRange().AddSQLConstraint("date_range_uniq", "unique(name, type_id, company_id)",
+		"A date range must be unique per company !")
+	h.DateRange().NewMethod("CheckDates", dateRange_CheckDates)
+
+	models.NewTransientModel("DateRangeGenerator")
+	h.DateRangeGenerator().AddFields(fields_DateRangeGenerator)
+	h.DateRangeGenerator().NewMethod("ComputeDateRanges", dateRangeGenerator_ComputeDateRanges)
+	h.DateRangeGenerator().NewMethod("ActionApply", dateRangeGenerator_ActionApply)
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDateRanges(t *testing.T) {
+	Convey("Testing date ranges", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			drType := h.DateRangeType().Create(env, h.DateRangeType().NewData().SetName("Fiscal Periods"))
+			Convey("Generating monthly ranges for a year", func() {
+				wizard := h.DateRangeGenerator().Create(env, h.DateRangeGenerator().NewData().
+					SetNamePrefix("2025-").
+					SetDateStart(dates.ParseDate("2025-01-01")).
+					SetType(drType).
+					SetUnit("month").
+					SetDuration(1).
+					SetCount(12))
+				wizard.ActionApply()
+				So(drType.Ranges().Len(), ShouldEqual, 12)
+				feb := drType.FindRange(dates.ParseDate("2025-02-14"))
+				So(feb.Name(), ShouldEqual, "2025-02")
+				So(feb.DateStart().Equal(dates.ParseDate("2025-02-01")), ShouldBeTrue)
+				So(feb.DateEnd().Equal(dates.ParseDate("2025-02-28")), ShouldBeTrue)
+				So(drType.FindRange(dates.ParseDate("2026-01-01")).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Monthly ranges starting on the 31st do not drift", func() {
+				wizard := h.DateRangeGenerator().Create(env, h.DateRangeGenerator().NewData().
+					SetNamePrefix("EOM-").
+					SetDateStart(dates.ParseDate("2025-01-31")).
+					SetType(drType).
+					SetUnit("month").
+					SetDuration(1).
+					SetCount(4))
+				ranges := wizard.ComputeDateRanges()
+				So(ranges[0].DateEnd().Equal(dates.ParseDate("2025-02-27")), ShouldBeTrue)
+				So(ranges[1].DateStart().Equal(dates.ParseDate("2025-02-28")), ShouldBeTrue)
+				So(ranges[2].DateStart().Equal(dates.ParseDate("2025-03-31")), ShouldBeTrue)
+				So(ranges[3].DateStart().Equal(dates.ParseDate("2025-04-30")), ShouldBeTrue)
+				So(ranges[3].DateEnd().Equal(dates.ParseDate("2025-05-30")), ShouldBeTrue)
+			})
+			Convey("Overlapping ranges are rejected", func() {
+				h.DateRange().Create(env, h.DateRange().NewData().
+					SetName("H1").
+					SetType(drType).
+					SetDateStart(dates.ParseDate("2025-01-01")).
+					SetDateEnd(dates.ParseDate("2025-06-30")))
+				So(func() {
+					h.DateRange().Create(env, h.DateRange().NewData().
+						SetName("Q2").
+						SetType(drType).
+						SetDateStart(dates.ParseDate("2025-04-01")).
+						SetDateEnd(dates.ParseDate("2025-06-30")))
+				}, ShouldPanic)
+			})
+			Convey("Company ranges overlapping global ranges are rejected", func() {
+				h.DateRange().Create(env, h.DateRange().NewData().
+					SetName("Global H1").
+					SetType(drType).
+					SetCompany(h.Company().NewSet(env)).
+					SetDateStart(dates.ParseDate("2025-01-01")).
+					SetDateEnd(dates.ParseDate("2025-06-30")))
+				So(func() {
+					h.DateRange().Create(env, h.DateRange().NewData().
+						SetName("Company Q2").
+						SetType(drType).
+						SetDateStart(dates.ParseDate("2025-04-01")).
+						SetDateEnd(dates.ParseDate("2025-06-30")))
+				}, ShouldPanic)
+			})
+			Convey("Ranges ending before they start are rejected", func() {
+				So(func() {
+					h.DateRange().Create(env, h.DateRange().NewData().
+						SetName("Backwards").
+						SetType(drType).
+						SetDateStart(dates.ParseDate("2025-06-30")).
+						SetDateEnd(dates.ParseDate("2025-01-01")))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// An IndexDefinition describes a database index that cannot be declared
+// with the Index attribute of a field, such as composite, expression or
+// partial indexes.
+type IndexDefinition struct {
+	// Name of the index in the database
+	Name string
+	// Model on which the index is created
+	Model string
+	// Expressions is the list of indexed columns or expressions, e.g. "lower(email)"
+	Expressions string
+	// Method is the optional index method, e.g. "gin". Defaults to btree.
+	Method string
+	// Where is the optional predicate of a partial index, e.g. "active = true"
+	Where string
+	// Unique makes this index enforce the uniqueness of the indexed values
+	Unique bool
+	// Fields are the fields that must exist on the model for this index to be
+	// created. It allows declaring indexes on fields added by other modules.
+	Fields []string
+}
+
+// Available returns true if the model of this index and all its Fields exist
+func (i IndexDefinition) Available() bool {
+	model, ok := models.Registry.Get(i.Model)
+	if !ok {
+		return false
+	}
+	for _, field := range i.Fields {
+		if _, exists := model.Fields().Get(field); !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// SQL returns the SQL statement to create this index if it does not exist
+func (i IndexDefinition) SQL() string {
+	kind := "INDEX"
+	if i.Unique {
+		kind = "UNIQUE INDEX"
+	}
+	table := models.Registry.MustGet(i.Model).Table()
+	if i.Method != "" {
+		table += " USING " + i.Method
+	}
+	query := fmt.Sprintf(`CREATE %s IF NOT EXISTS %s ON %s (%s)`,
+		kind, i.Name, table, i.Expressions)
+	if i.Where != "" {
+		query += " WHERE " + i.Where
+	}
+	return query
+}
+
+var dbIndexes = struct {
+	sync.RWMutex
+	indexes map[string]IndexDefinition
+}{
+	indexes: make(map[string]IndexDefinition),
+}
+
+// RegisterIndex declares the given index so that it is created at startup.
+// It panics if an index is already registered with the same name.
+func RegisterIndex(index IndexDefinition) {
+	dbIndexes.Lock()
+	defer dbIndexes.Unlock()
+	if _, exists := dbIndexes.indexes[index.Name]; exists {
+		log.Panic("Index already registered", "name", index.Name)
+	}
+	dbIndexes.indexes[index.Name] = index
+}
+
+// RegisteredIndexes returns the registered indexes sorted by name
+func RegisteredIndexes() []IndexDefinition {
+	dbIndexes.RLock()
+	defer dbIndexes.RUnlock()
+	res := make([]IndexDefinition, 0, len(dbIndexes.indexes))
+	for _, index := range dbIndexes.indexes {
+		res = append(res, index)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// CreateIndexes creates the registered indexes that do not exist yet in the database.
+// Indexes whose fields do not exist are skipped.
+func model_CreateIndexes(rs m.ModelSet) {
+	for _, index := range RegisteredIndexes() {
+		if !index.Available() {
+			log.Debug("Skipping index on missing fields", "index", index.Name, "model", index.Model, "fields", index.Fields)
+			continue
+		}
+		rs.Env().Cr().Execute(index.SQL())
+	}
+}
+
+// AnalyzeIndexesMinRows is the minimum number of rows for a table to be reported by AnalyzeIndexes
+const AnalyzeIndexesMinRows = 10000
+
+// AnalyzeIndexes reports the tables of at least AnalyzeIndexesMinRows rows on
+// which PostgreSQL performed more sequential scans than index scans since
+// the statistics were last reset. Such tables probably lack an index for some
+// frequent queries.
+//
+// Each returned line gives the table, its number of rows and its scan counts.
+func model_AnalyzeIndexes(rs m.ModelSet) []string {
+	var stats []struct {
+		Table   string `db:"relname"`
+		Rows    int64  `db:"n_live_tup"`
+		SeqScan int64  `db:"seq_scan"`
+		IdxScan int64  `db:"idx_scan"`
+	}
+	rs.Env().Cr().Select(&stats, `
+SELECT relname, n_live_tup, seq_scan, COALESCE(idx_scan, 0) AS idx_scan
+FROM pg_stat_user_tables
+WHERE n_live_tup >= ? AND seq_scan > COALESCE(idx_scan, 0)
+ORDER BY seq_scan * n_live_tup DESC`, AnalyzeIndexesMinRows)
+	res := make([]string, len(stats))
+	for i, stat := range stats {
+		res[i] = fmt.Sprintf("%s: %d rows, %d sequential scans, %d index scans",
+			stat.Table, stat.Rows, stat.SeqScan, stat.IdxScan)
+		log.Info("Table with more sequential scans than index scans", "table", stat.Table,
+			"rows", stat.Rows, "seq_scan", stat.SeqScan, "idx_scan", stat.IdxScan)
+	}
+	return res
+}
+
+func init() {
+	h.Model().NewMethod("CreateIndexes", model_CreateIndexes)
+	h.Model().NewMethod("AnalyzeIndexes", model_AnalyzeIndexes)
+
+	// Customer and Supplier flags are added to partners by other modules
+	RegisterIndex(IndexDefinition{
+		Name:        "partner_active_customer_idx",
+		Model:       "Partner",
+		Expressions: "customer",
+		Where:       "active = true",
+		Fields:      []string{"Customer"},
+	})
+	RegisterIndex(IndexDefinition{
+		Name:        "partner_active_supplier_idx",
+		Model:       "Partner",
+		Expressions: "supplier",
+		Where:       "active = true",
+		Fields:      []string{"Supplier"},
+	})
+	// Same expression as the email matcher of ImportMatch
+	RegisterIndex(IndexDefinition{
+		Name:        "partner_lower_trim_email_idx",
+		Model:       "Partner",
+		Expressions: "lower(trim(email))",
+		Where:       "email IS NOT NULL",
+	})
+	RegisterIndex(IndexDefinition{
+		Name:        "partner_parent_type_idx",
+		Model:       "Partner",
+		Expressions: "parent_id, type",
+	})
+	RegisterIndex(IndexDefinition{
+		Name:        "partner_active_commercial_partner_idx",
+		Model:       "Partner",
+		Expressions: "commercial_partner_id",
+		Where:       "active = true",
+	})
+}
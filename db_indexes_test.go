@@ -0,0 +1,41 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDBIndexes(t *testing.T) {
+	Convey("Testing declared database indexes", t, func() {
+		Convey("Index SQL is generated", func() {
+			index := IndexDefinition{Name: "test_idx", Model: "Partner", Expressions: "lower(email)", Where: "email IS NOT NULL"}
+			So(index.SQL(), ShouldEqual, "CREATE INDEX IF NOT EXISTS test_idx ON partner (lower(email)) WHERE email IS NOT NULL")
+		})
+		Convey("Indexes on missing fields are not available", func() {
+			So(IndexDefinition{Name: "test_idx", Model: "Partner", Expressions: "email"}.Available(), ShouldBeTrue)
+			So(IndexDefinition{Name: "test_idx", Model: "Partner", Expressions: "x", Fields: []string{"NoField"}}.Available(), ShouldBeFalse)
+		})
+		Convey("Registering an index twice panics", func() {
+			So(func() { RegisterIndex(IndexDefinition{Name: "partner_lower_trim_email_idx"}) }, ShouldPanic)
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Registered indexes are created", func() {
+				h.Model().NewSet(env).CreateIndexes()
+				var count int
+				env.Cr().Get(&count, `SELECT COUNT(*) FROM pg_indexes WHERE indexname IN (?)`,
+					[]string{"partner_lower_trim_email_idx", "partner_parent_type_idx"})
+				So(count, ShouldEqual, 2)
+			})
+			Convey("Index analysis runs", func() {
+				So(func() { h.Model().NewSet(env).AnalyzeIndexes() }, ShouldNotPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
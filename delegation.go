@@ -0,0 +1,214 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// Delegation scopes defined in base
+const (
+	// DelegationScopeAll delegates all the scopes
+	DelegationScopeAll = "all"
+	// DelegationScopeActivities routes the activities assigned to the user
+	DelegationScopeActivities = "activities"
+	// DelegationScopeNotifications routes the notifications sent to the user
+	DelegationScopeNotifications = "notifications"
+)
+
+var delegationScopes = struct {
+	sync.RWMutex
+	labels types.Selection
+}{
+	labels: types.Selection{
+		DelegationScopeAll:           "All",
+		DelegationScopeActivities:    "Activities",
+		DelegationScopeNotifications: "Notifications",
+	},
+}
+
+// RegisterDelegationScope registers a new scope of work that users can
+// delegate, so that addons can route their own work items to delegates
+// with User.DelegateFor. It panics if the scope is already registered.
+func RegisterDelegationScope(name, label string) {
+	delegationScopes.Lock()
+	defer delegationScopes.Unlock()
+	if _, exists := delegationScopes.labels[name]; exists {
+		log.Panic("Delegation scope already registered", "name", name)
+	}
+	delegationScopes.labels[name] = label
+}
+
+// DelegationScopesSelection returns the selection of the registered delegation scopes
+func DelegationScopesSelection() types.Selection {
+	delegationScopes.RLock()
+	defer delegationScopes.RUnlock()
+	res := make(types.Selection)
+	for name, label := range delegationScopes.labels {
+		res[name] = label
+	}
+	return res
+}
+
+var fields_Delegation = map[string]models.FieldDefinition{
+	"FromUser": fields.Many2One{String: "Delegating User", RelationModel: h.User(), Required: true, Index: true,
+		OnDelete: models.Cascade, Constraint: h.Delegation().Methods().CheckUsers(),
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		}},
+	"ToUser": fields.Many2One{String: "Delegate", RelationModel: h.User(), Required: true, Index: true,
+		OnDelete: models.Cascade, Constraint: h.Delegation().Methods().CheckUsers()},
+	"DateFrom": fields.Date{String: "From", Required: true, Constraint: h.Delegation().Methods().CheckDates(),
+		Default: func(env models.Environment) interface{} {
+			return dates.Today()
+		}},
+	"DateTo": fields.Date{String: "To", Constraint: h.Delegation().Methods().CheckDates(),
+		Help: "Leave empty for a delegation without end date."},
+	"Scope": fields.Selection{SelectionFunc: DelegationScopesSelection, Required: true,
+		Default: models.DefaultValue(DelegationScopeAll)},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckUsers checks that users do not delegate to themselves
+func delegation_CheckUsers(rs m.DelegationSet) {
+	for _, delegation := range rs.Records() {
+		if delegation.FromUser().Equals(delegation.ToUser()) {
+			log.Panic(rs.T("A user cannot delegate to themselves"))
+		}
+	}
+}
+
+// CheckDates checks that delegations do not end before they start
+func delegation_CheckDates(rs m.DelegationSet) {
+	for _, delegation := range rs.Records() {
+		if !delegation.DateTo().IsZero() && delegation.DateTo().Lower(delegation.DateFrom()) {
+			log.Panic(rs.T("The end of the delegation must be after its start"))
+		}
+	}
+}
+
+// NameGet returns "From User → Delegate"
+func delegation_NameGet(rs m.DelegationSet) string {
+	return rs.T("%s → %s", rs.FromUser().Name(), rs.ToUser().Name())
+}
+
+// Search restricts the delegations seen by users who are not administrators
+// to the delegations they give or receive.
+func delegation_Search(rs m.DelegationSet, cond q.DelegationCondition) m.DelegationSet {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	if user.IsAdmin() {
+		return rs.Super().Search(cond)
+	}
+	return rs.Super().Search(cond.AndCond(q.Delegation().FromUser().Equals(user).Or().ToUser().Equals(user)))
+}
+
+// Load checks that users who are not administrators only read the
+// delegations they give or receive.
+func delegation_Load(rs m.DelegationSet, fields ...models.FieldName) m.DelegationSet {
+	if !h.User().NewSet(rs.Env()).CurrentUser().IsAdmin() &&
+		rs.Search(q.Delegation().ID().In(rs.Ids())).Len() != rs.Len() {
+		panic(NewUserError(rs, "You can only access the delegations you give or receive"))
+	}
+	return rs.Super().Load(fields...)
+}
+
+// checkDelegationOwner panics if the current user is not an administrator
+// and does not give all the given delegations.
+func checkDelegationOwner(rs m.DelegationSet) {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	if user.IsAdmin() {
+		return
+	}
+	if rs.Search(q.Delegation().ID().In(rs.Ids()).And().FromUser().Equals(user)).Len() != rs.Len() {
+		panic(NewUserError(rs, "You can only modify the delegations you give"))
+	}
+}
+
+// Create forces the delegating user to the current user for users who are
+// not administrators, so that nobody can delegate the work of someone else.
+func delegation_Create(rs m.DelegationSet, vals m.DelegationData) m.DelegationSet {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	if !user.IsAdmin() {
+		vals.SetFromUser(user)
+	}
+	return rs.Super().Create(vals)
+}
+
+// Write checks that users who are not administrators only modify the
+// delegations they give, and cannot change the delegating user.
+func delegation_Write(rs m.DelegationSet, vals m.DelegationData) bool {
+	checkDelegationOwner(rs)
+	if vals.HasFromUser() && !h.User().NewSet(rs.Env()).CurrentUser().IsAdmin() {
+		vals.SetFromUser(h.User().NewSet(rs.Env()).CurrentUser())
+	}
+	return rs.Super().Write(vals)
+}
+
+// Unlink checks that users who are not administrators only delete the
+// delegations they give.
+func delegation_Unlink(rs m.DelegationSet) int64 {
+	checkDelegationOwner(rs)
+	return rs.Super().Unlink()
+}
+
+// ActiveDelegate returns the user to whom this user delegates the given
+// scope on the given date, or an empty UserSet if there is no such delegation.
+func user_ActiveDelegate(rs m.UserSet, scope string, date dates.Date) m.UserSet {
+	rs.EnsureOne()
+	delegation := h.Delegation().NewSet(rs.Env()).AsSuperUser("read active delegation").Search(q.Delegation().FromUser().Equals(rs).
+		And().Scope().In([]string{scope, DelegationScopeAll}).
+		And().DateFrom().LowerOrEqual(date).
+		AndCond(q.Delegation().DateTo().IsNull().Or().DateTo().GreaterOrEqual(date))).
+		OrderBy("ID desc").Limit(1)
+	if delegation.IsEmpty() {
+		return h.User().NewSet(rs.Env())
+	}
+	return h.User().BrowseOne(rs.Env(), delegation.ToUser().ID())
+}
+
+// DelegateFor returns the user who handles the work of this user in the given
+// scope on the given date, following chains of delegations. It returns this
+// user if the work is not delegated or if the delegations loop.
+func user_DelegateFor(rs m.UserSet, scope string, date dates.Date) m.UserSet {
+	rs.EnsureOne()
+	visited := map[int64]bool{rs.ID(): true}
+	user := rs
+	for {
+		delegate := user.ActiveDelegate(scope, date)
+		if delegate.IsEmpty() {
+			return user
+		}
+		if visited[delegate.ID()] {
+			log.Warn("Delegation loop detected", "user", rs.ID(), "scope", scope)
+			return rs
+		}
+		visited[delegate.ID()] = true
+		user = delegate
+	}
+}
+
+func init() {
+	models.NewModel("Delegation")
+	h.Delegation().SetDefaultOrder("DateFrom desc", "ID desc")
+	h.Delegation().AddFields(fields_Delegation)
+	h.Delegation().NewMethod("CheckUsers", delegation_CheckUsers)
+	h.Delegation().NewMethod("CheckDates", delegation_CheckDates)
+	h.Delegation().Methods().NameGet().Extend(delegation_NameGet)
+	h.Delegation().Methods().Search().Extend(delegation_Search)
+	h.Delegation().Methods().Load().Extend(delegation_Load)
+	h.Delegation().Methods().Create().Extend(delegation_Create)
+	h.Delegation().Methods().Write().Extend(delegation_Write)
+	h.Delegation().Methods().Unlink().Extend(delegation_Unlink)
+
+	h.User().NewMethod("ActiveDelegate", user_ActiveDelegate)
+	h.User().NewMethod("DelegateFor", user_DelegateFor)
+}
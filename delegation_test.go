@@ -0,0 +1,72 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDelegation(t *testing.T) {
+	Convey("Testing delegations", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			today := dates.Today()
+			manager := h.User().Create(env, h.User().NewData().SetName("Manager").SetLogin("delegation_manager"))
+			deputy := h.User().Create(env, h.User().NewData().SetName("Deputy").SetLogin("delegation_deputy"))
+			assistant := h.User().Create(env, h.User().NewData().SetName("Assistant").SetLogin("delegation_assistant"))
+			h.Delegation().Create(env, h.Delegation().NewData().
+				SetFromUser(manager).
+				SetToUser(deputy).
+				SetScope(DelegationScopeActivities).
+				SetDateFrom(today).
+				SetDateTo(today.AddDate(0, 0, 10)))
+			Convey("Work is routed to the delegate during the delegation", func() {
+				So(manager.DelegateFor(DelegationScopeActivities, today).Equals(deputy), ShouldBeTrue)
+				So(manager.DelegateFor(DelegationScopeActivities, today.AddDate(0, 0, 11)).Equals(manager), ShouldBeTrue)
+				So(manager.DelegateFor(DelegationScopeNotifications, today).Equals(manager), ShouldBeTrue)
+			})
+			Convey("Delegations are followed in chain and loops are detected", func() {
+				h.Delegation().Create(env, h.Delegation().NewData().
+					SetFromUser(deputy).
+					SetToUser(assistant))
+				So(manager.DelegateFor(DelegationScopeActivities, today).Equals(assistant), ShouldBeTrue)
+				h.Delegation().Create(env, h.Delegation().NewData().
+					SetFromUser(assistant).
+					SetToUser(manager))
+				So(manager.DelegateFor(DelegationScopeActivities, today).Equals(manager), ShouldBeTrue)
+			})
+			Convey("Activities are assigned to the delegate", func() {
+				activity := h.Activity().Create(env, h.Activity().NewData().
+					SetSummary("Approve budget").
+					SetUser(manager).
+					SetDateDeadline(today.AddDate(0, 0, 1)))
+				So(activity.User().Equals(deputy), ShouldBeTrue)
+				So(activity.Note(), ShouldContainSubstring, "Manager")
+			})
+			Convey("Users can only delegate their own work", func() {
+				delegation := h.Delegation().NewSet(env).Sudo(assistant.ID()).Create(h.Delegation().NewData().
+					SetFromUser(manager).
+					SetToUser(deputy))
+				So(delegation.FromUser().Equals(assistant), ShouldBeTrue)
+				So(func() { delegation.Sudo(assistant.ID()).SetFromUser(manager) }, ShouldNotPanic)
+				So(delegation.FromUser().Equals(assistant), ShouldBeTrue)
+				So(h.Delegation().NewSet(env).Sudo(assistant.ID()).SearchAll().Len(), ShouldEqual, 1)
+				So(h.Delegation().NewSet(env).Sudo(deputy.ID()).SearchAll().Len(), ShouldEqual, 2)
+				So(func() { delegation.Sudo(deputy.ID()).SetDateTo(today) }, ShouldPanic)
+			})
+			Convey("Users cannot delegate to themselves", func() {
+				So(func() {
+					h.Delegation().Create(env, h.Delegation().NewData().
+						SetFromUser(manager).
+						SetToUser(manager))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// A DigestKPIProvider computes the value of a KPI for the given company
+// over the period from start (included) to end (excluded).
+type DigestKPIProvider func(env models.Environment, company m.CompanySet, start, end dates.DateTime) float64
+
+// A DigestKPI is a KPI that can be included in digests
+type DigestKPI struct {
+	Name     string
+	Label    string
+	Provider DigestKPIProvider
+}
+
+var digestKPIs = struct {
+	sync.RWMutex
+	kpis map[string]DigestKPI
+}{
+	kpis: make(map[string]DigestKPI),
+}
+
+// RegisterDigestKPI registers a KPI that can be included in digests under
+// the given name. It panics if a KPI is already registered with this name.
+func RegisterDigestKPI(name, label string, provider DigestKPIProvider) {
+	digestKPIs.Lock()
+	defer digestKPIs.Unlock()
+	if _, exists := digestKPIs.kpis[name]; exists {
+		log.Panic("Digest KPI already registered", "name", name)
+	}
+	digestKPIs.kpis[name] = DigestKPI{Name: name, Label: label, Provider: provider}
+}
+
+// GetDigestKPI returns the KPI registered with the given name
+func GetDigestKPI(name string) (DigestKPI, bool) {
+	digestKPIs.RLock()
+	defer digestKPIs.RUnlock()
+	kpi, ok := digestKPIs.kpis[name]
+	return kpi, ok
+}
+
+// DigestKPIs returns all the registered KPIs sorted by name
+func DigestKPIs() []DigestKPI {
+	digestKPIs.RLock()
+	defer digestKPIs.RUnlock()
+	res := make([]DigestKPI, 0, len(digestKPIs.kpis))
+	for _, kpi := range digestKPIs.kpis {
+		res = append(res, kpi)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// DigestPeriodicities is the selection of the sending periodicities of digests
+var DigestPeriodicities = types.Selection{
+	"daily":   "Daily",
+	"weekly":  "Weekly",
+	"monthly": "Monthly",
+}
+
+// digestKPIValue is the value of a KPI as given to digest templates
+type digestKPIValue struct {
+	Name  string
+	Label string
+	Value float64
+}
+
+// digestTemplateData is the data given to digest templates
+type digestTemplateData struct {
+	Name      string
+	Company   string
+	UserName  string
+	UserEmail string
+	DateFrom  string
+	DateTo    string
+	KPIs      []digestKPIValue
+}
+
+var fields_Digest = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Translate: true},
+	"Periodicity": fields.Selection{Selection: DigestPeriodicities, Required: true,
+		Default: models.DefaultValue("weekly")},
+	"KPIs": fields.Char{String: "KPIs", Default: models.DefaultValue("new_partners,new_users"),
+		Constraint: h.Digest().Methods().CheckKPIs(),
+		Help:       "Comma-separated names of the KPIs to include, e.g. 'new_partners,new_users,failed_crons'"},
+	"Users": fields.Many2Many{String: "Recipients", RelationModel: h.User(), JSON: "user_ids",
+		Help: "Users subscribed to this digest"},
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+	"Template": fields.Many2One{String: "Email Template", RelationModel: h.MailTemplate(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.MailTemplate().NewSet(env).GetRecord("base_mail_template_digest")
+		}},
+	"NextRunDate": fields.Date{String: "Next Send Date", Default: func(env models.Environment) interface{} {
+		return dates.Today()
+	}},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckKPIs checks that all the KPIs of this digest are registered
+func digest_CheckKPIs(rs m.DigestSet) {
+	for _, digest := range rs.Records() {
+		for _, name := range digest.KPINames() {
+			if _, ok := GetDigestKPI(name); !ok {
+				log.Panic(rs.T("Unknown KPI '%s' in digest '%s'", name, digest.Name()))
+			}
+		}
+	}
+}
+
+// KPINames returns the names of the KPIs of this digest
+func digest_KPINames(rs m.DigestSet) []string {
+	var res []string
+	for _, name := range strings.Split(rs.KPIs(), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+// NextRunDateAfter returns the date at which this digest must be sent after the given date
+func digest_NextRunDateAfter(rs m.DigestSet, date dates.Date) dates.Date {
+	switch rs.Periodicity() {
+	case "daily":
+		return date.AddDate(0, 0, 1)
+	case "monthly":
+		return date.AddDate(0, 1, 0)
+	default:
+		return date.AddDate(0, 0, 7)
+	}
+}
+
+// PeriodStart returns the start of the period of this digest that ends at the given date
+func digest_PeriodStart(rs m.DigestSet, end dates.DateTime) dates.DateTime {
+	switch rs.Periodicity() {
+	case "daily":
+		return end.AddDate(0, 0, -1)
+	case "monthly":
+		return end.AddDate(0, -1, 0)
+	default:
+		return end.AddDate(0, 0, -7)
+	}
+}
+
+// ComputeKPIs returns the values of the KPIs of this digest for its company
+// over the period from start (included) to end (excluded).
+func digest_ComputeKPIs(rs m.DigestSet, start, end dates.DateTime) map[string]float64 {
+	rs.EnsureOne()
+	res := make(map[string]float64)
+	for _, name := range rs.KPINames() {
+		kpi, ok := GetDigestKPI(name)
+		if !ok {
+			continue
+		}
+		res[name] = kpi.Provider(rs.Env(), rs.Company(), start, end)
+	}
+	return res
+}
+
+// SendDigest computes the KPIs of these digests over their last period and
+// emails them to their subscribed users in their language. Sending errors
+// are logged so that a failing recipient does not prevent the others from
+// receiving the digest. The next sending date of each digest is then updated.
+func digest_SendDigest(rs m.DigestSet) {
+	today := dates.Today()
+	for _, digest := range rs.Records() {
+		end := today.ToDateTime()
+		start := digest.PeriodStart(end)
+		values := digest.ComputeKPIs(start, end)
+		for _, user := range digest.Users().Records() {
+			if user.Email() == "" {
+				continue
+			}
+			localized := digest.ForPartner(user.Partner())
+			lang := h.Lang().NewSet(rs.Env()).GetLang(user.Lang())
+			data := digestTemplateData{
+				Name:      localized.Name(),
+				Company:   digest.Company().Name(),
+				UserName:  user.Name(),
+				UserEmail: user.Partner().EmailFormatted(),
+				DateFrom:  lang.FormatDate(start.ToDate()),
+				DateTo:    lang.FormatDate(end.AddDate(0, 0, -1).ToDate()),
+			}
+			for _, name := range digest.KPINames() {
+				kpi, _ := GetDigestKPI(name)
+				data.KPIs = append(data.KPIs, digestKPIValue{
+					Name:  name,
+					Label: localized.T(kpi.Label),
+					Value: values[name],
+				})
+			}
+			if err := localized.Template().SendMail(data); err != nil {
+				log.Warn("Unable to send digest", "digest", digest.ID(), "user", user.ID(), "error", err)
+			}
+		}
+		digest.SetNextRunDate(digest.NextRunDateAfter(today))
+	}
+}
+
+// SendDueDigests sends all the active digests whose next sending date is
+// today or in the past. It is called by the digest cron.
+func digest_SendDueDigests(rs m.DigestSet) {
+	h.Digest().Search(rs.Env(), q.Digest().NextRunDate().LowerOrEqual(dates.Today())).SendDigest()
+}
+
+// Subscribe adds the current user to the recipients of these digests
+func digest_Subscribe(rs m.DigestSet) {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	for _, digest := range rs.Records() {
+		digest.AsSuperUser("subscribe to digest").SetUsers(digest.Users().Union(user))
+	}
+}
+
+// Unsubscribe removes the current user from the recipients of these digests
+func digest_Unsubscribe(rs m.DigestSet) {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	for _, digest := range rs.Records() {
+		digest.AsSuperUser("unsubscribe from digest").SetUsers(digest.Users().Subtract(user))
+	}
+}
+
+func init() {
+	models.NewModel("Digest")
+	h.Digest().SetDefaultOrder("Name")
+	h.Digest().AddFields(fields_Digest)
+
+	h.Digest().NewMethod("CheckKPIs", digest_CheckKPIs)
+	h.Digest().NewMethod("KPINames", digest_KPINames)
+	h.Digest().NewMethod("NextRunDateAfter", digest_NextRunDateAfter)
+	h.Digest().NewMethod("PeriodStart", digest_PeriodStart)
+	h.Digest().NewMethod("ComputeKPIs", digest_ComputeKPIs)
+	h.Digest().NewMethod("SendDigest", digest_SendDigest)
+	h.Digest().NewMethod("SendDueDigests", digest_SendDueDigests)
+	h.Digest().NewMethod("Subscribe", digest_Subscribe)
+	h.Digest().NewMethod("Unsubscribe", digest_Unsubscribe)
+
+	RegisterDigestKPI("new_partners", "New Contacts",
+		func(env models.Environment, company m.CompanySet, start, end dates.DateTime) float64 {
+			return float64(h.Partner().Search(env, q.Partner().CreateDate().GreaterOrEqual(start).
+				And().CreateDate().Lower(end).
+				AndCond(q.Partner().Company().Equals(company).Or().Company().IsNull())).SearchCount())
+		})
+	RegisterDigestKPI("new_users", "New Users",
+		func(env models.Environment, company m.CompanySet, start, end dates.DateTime) float64 {
+			return float64(h.User().Search(env, q.User().CreateDate().GreaterOrEqual(start).
+				And().CreateDate().Lower(end).
+				And().Companies().Equals(company)).SearchCount())
+		})
+	RegisterDigestKPI("failed_crons", "Failed Scheduled Actions",
+		func(env models.Environment, company m.CompanySet, start, end dates.DateTime) float64 {
+			return float64(h.QueueJob().Search(env, q.QueueJob().State().Equals("failed").
+				And().Name().ILike("Cron Job: %").
+				And().DateDone().GreaterOrEqual(start).
+				And().DateDone().Lower(end).
+				And().Company().Equals(company)).SearchCount())
+		})
+}
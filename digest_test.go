@@ -0,0 +1,94 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testMailSender records the emails it is asked to send
+type testMailSender struct {
+	sent []basetypes.OutgoingEmail
+}
+
+// Send records the given email
+func (s *testMailSender) Send(env models.Environment, email basetypes.OutgoingEmail) error {
+	s.sent = append(s.sent, email)
+	return nil
+}
+
+func TestDigest(t *testing.T) {
+	Convey("Testing KPI digests", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			sender := new(testMailSender)
+			previous := SetMailSender(sender)
+			defer SetMailSender(previous)
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			admin := h.User().NewSet(env).CurrentUser()
+			admin.SetEmail("admin@example.com")
+			digest := h.Digest().Create(env, h.Digest().NewData().
+				SetName("Weekly Digest").
+				SetPeriodicity("weekly").
+				SetKPIs("new_partners, failed_crons").
+				SetUsers(admin))
+			Convey("KPIs are checked", func() {
+				So(digest.KPINames(), ShouldResemble, []string{"new_partners", "failed_crons"})
+				So(func() { digest.SetKPIs("new_partners,unknown_kpi") }, ShouldPanic)
+			})
+			Convey("KPIs are computed over the period", func() {
+				end := dates.Now().AddDate(0, 0, 1)
+				start := digest.PeriodStart(end)
+				before := digest.ComputeKPIs(start, end)["new_partners"]
+				h.Partner().Create(env, h.Partner().NewData().SetName("Digest Partner"))
+				So(digest.ComputeKPIs(start, end)["new_partners"], ShouldEqual, before+1)
+				So(digest.ComputeKPIs(start.AddDate(-1, 0, 0), start)["new_partners"], ShouldBeZeroValue)
+			})
+			Convey("Due digests are sent to subscribed users", func() {
+				digest.SetNextRunDate(dates.Today())
+				h.Digest().NewSet(env).SendDueDigests()
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].From, ShouldEqual, "noreply@example.com")
+				So(sender.sent[0].To, ShouldHaveLength, 1)
+				So(sender.sent[0].To[0], ShouldContainSubstring, "admin@example.com")
+				So(sender.sent[0].Subject, ShouldContainSubstring, "Weekly Digest")
+				So(sender.sent[0].Body, ShouldContainSubstring, "New Contacts")
+				So(sender.sent[0].Body, ShouldContainSubstring, "Failed Scheduled Actions")
+				So(digest.NextRunDate().Equal(dates.Today().AddDate(0, 0, 7)), ShouldBeTrue)
+				Convey("Digests are not sent before their next date", func() {
+					h.Digest().NewSet(env).SendDueDigests()
+					h.MailMessage().NewSet(env).ProcessQueue()
+					So(sender.sent, ShouldHaveLength, 1)
+				})
+			})
+			Convey("Users can unsubscribe", func() {
+				digest.Unsubscribe()
+				So(digest.Users().IsEmpty(), ShouldBeTrue)
+				digest.Subscribe()
+				So(digest.Users().Equals(admin), ShouldBeTrue)
+			})
+			Convey("Mail templates are rendered with the given data", func() {
+				tmpl := h.MailTemplate().Create(env, h.MailTemplate().NewData().
+					SetName("Test Template").
+					SetEmailTo("{{ .Email }}, other@example.com").
+					SetSubject("Hello {{ .Name }}").
+					SetBodyHTML("<p>{{ .Name }}</p>"))
+				email, err := tmpl.GenerateEmail(map[string]string{"Name": "John", "Email": "john@example.com"})
+				So(err, ShouldBeNil)
+				So(email.To, ShouldResemble, []string{"john@example.com", "other@example.com"})
+				So(email.Subject, ShouldEqual, "Hello John")
+				So(strings.TrimSpace(email.Body), ShouldEqual, "<p>John</p>")
+				So(func() { tmpl.SetSubject("{{ call .Name }}") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
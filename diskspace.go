@@ -0,0 +1,18 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+//go:build !windows
+// +build !windows
+
+package base
+
+import "syscall"
+
+// diskSpace returns the free and total space in bytes of the disk of the given directory
+func diskSpace(dir string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), nil
+}
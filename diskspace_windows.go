@@ -0,0 +1,11 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import "errors"
+
+// diskSpace is not implemented on Windows
+func diskSpace(dir string) (uint64, uint64, error) {
+	return 0, 0, errors.New("disk space is not available on this platform")
+}
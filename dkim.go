@@ -0,0 +1,172 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// DKIMKeySize is the size in bits of the DKIM keys generated by Hexya
+var DKIMKeySize = 2048
+
+var fields_DKIMKey = map[string]models.FieldDefinition{
+	"Domain": fields.Char{Required: true, Index: true,
+		Help: "Emails sent from addresses of this domain are signed with this key"},
+	"Selector": fields.Char{Required: true,
+		Help: "The public key must be published in the TXT record <selector>._domainkey.<domain>"},
+	"PrivateKey": fields.Text{NoCopy: true, Constraint: h.DKIMKey().Methods().CheckPrivateKey(),
+		Help: "RSA private key in PEM format. A new key is generated if left empty."},
+	"DNSRecord": fields.Text{String: "DNS TXT Record", Compute: h.DKIMKey().Methods().ComputeDNSRecord(),
+		Depends: []string{"PrivateKey"}},
+	"DNSName": fields.Char{String: "DNS Record Name", Compute: h.DKIMKey().Methods().ComputeDNSName(),
+		Depends: []string{"Domain", "Selector"}},
+	"Company": fields.Many2One{RelationModel: h.Company(),
+		Default: func(env models.Environment) interface{} {
+			return h.Company().NewSet(env).CompanyDefaultGet()
+		}},
+	"Current": fields.Boolean{String: "Used For Signing", ReadOnly: true,
+		Help: "Only the current key of a domain is used to sign emails. Activate a key once its DNS record is published."},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckPrivateKey checks that the private key of this DKIM key can be parsed
+func dkimKey_CheckPrivateKey(rs m.DKIMKeySet) {
+	for _, key := range rs.Records() {
+		if _, err := basetypes.ParseDKIMPrivateKey(key.PrivateKey()); err != nil {
+			log.Panic(rs.T("Invalid DKIM private key for %s: %s", key.DNSName(), err))
+		}
+	}
+}
+
+// ComputeDNSRecord returns the TXT record publishing the public key
+func dkimKey_ComputeDNSRecord(rs m.DKIMKeySet) m.DKIMKeyData {
+	res := h.DKIMKey().NewData().SetDNSRecord("")
+	key, err := basetypes.ParseDKIMPrivateKey(rs.PrivateKey())
+	if err != nil {
+		return res
+	}
+	record, err := basetypes.DKIMDNSRecord(&key.PublicKey)
+	if err != nil {
+		return res
+	}
+	return res.SetDNSRecord(record)
+}
+
+// ComputeDNSName returns the name of the TXT record publishing the public key
+func dkimKey_ComputeDNSName(rs m.DKIMKeySet) m.DKIMKeyData {
+	return h.DKIMKey().NewData().SetDNSName(fmt.Sprintf("%s._domainkey.%s", rs.Selector(), rs.Domain()))
+}
+
+// newDKIMSelector returns a selector for a new key of the given domain
+func newDKIMSelector(env models.Environment, domain string) string {
+	base := fmt.Sprintf("hexya%s", time.Now().Format("200601"))
+	selector := base
+	for i := 2; h.DKIMKey().NewSet(env).AsSuperUser("find free DKIM selector").WithContext("active_test", false).Search(
+		q.DKIMKey().Domain().Equals(domain).And().Selector().Equals(selector)).SearchCount() > 0; i++ {
+		selector = fmt.Sprintf("%s-%d", base, i)
+	}
+	return selector
+}
+
+// Create generates a private key and a selector for the new DKIM key if none is given
+func dkimKey_Create(rs m.DKIMKeySet, vals m.DKIMKeyData) m.DKIMKeySet {
+	vals.SetDomain(strings.ToLower(strings.TrimSpace(vals.Domain())))
+	if vals.Selector() == "" {
+		vals.SetSelector(newDKIMSelector(rs.Env(), vals.Domain()))
+	}
+	if vals.PrivateKey() == "" {
+		key, err := basetypes.NewDKIMPrivateKey(DKIMKeySize)
+		if err != nil {
+			log.Panic(rs.T("Unable to generate DKIM key: %s", err))
+		}
+		vals.SetPrivateKey(key)
+	}
+	res := rs.Super().Create(vals)
+	if vals.Current() {
+		res.ActionActivate()
+	}
+	return res
+}
+
+// ActionActivate makes this key the one used to sign the emails of its
+// domain and archives the previous keys of the domain.
+func dkimKey_ActionActivate(rs m.DKIMKeySet) bool {
+	rs.EnsureOne()
+	h.DKIMKey().NewSet(rs.Env()).Search(
+		q.DKIMKey().Domain().Equals(rs.Domain()).And().ID().NotEquals(rs.ID())).
+		Write(h.DKIMKey().NewData().SetCurrent(false).SetActive(false))
+	rs.Write(h.DKIMKey().NewData().SetCurrent(true).SetActive(true))
+	return true
+}
+
+// ActionRotate creates a new key with a new selector for the domain of this
+// key and opens it. The current key stays in use until the new one is
+// activated, which should be done once its DNS record is published.
+func dkimKey_ActionRotate(rs m.DKIMKeySet) *actions.Action {
+	rs.EnsureOne()
+	newKey := h.DKIMKey().Create(rs.Env(), h.DKIMKey().NewData().
+		SetDomain(rs.Domain()).
+		SetCompany(rs.Company()))
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Model:    "DKIMKey",
+		ResID:    newKey.ID(),
+		ViewMode: "form",
+	}
+}
+
+// DKIMKeyFor returns the current DKIM key of the given domain, or an empty
+// set if emails of this domain are not signed.
+func DKIMKeyFor(env models.Environment, domain string) m.DKIMKeySet {
+	return h.DKIMKey().NewSet(env).AsSuperUser("read DKIM signing key").Search(
+		q.DKIMKey().Domain().Equals(strings.ToLower(domain)).And().Current().Equals(true)).
+		OrderBy("ID desc").Limit(1)
+}
+
+// dkimSignMessage signs the given message sent from the given address with
+// the DKIM key of the address domain, if any. The message is returned
+// unsigned if it cannot be signed.
+func dkimSignMessage(env models.Environment, from string, message []byte) []byte {
+	domain := from[strings.LastIndex(from, "@")+1:]
+	key := DKIMKeyFor(env, domain)
+	if key.IsEmpty() {
+		return message
+	}
+	privateKey, err := basetypes.ParseDKIMPrivateKey(key.PrivateKey())
+	if err != nil {
+		log.Warn("Invalid DKIM key, sending unsigned email", "domain", domain, "error", err)
+		return message
+	}
+	signed, err := basetypes.DKIMSign(message, domain, key.Selector(), privateKey, time.Now())
+	if err != nil {
+		log.Warn("Unable to sign email with DKIM, sending unsigned email", "domain", domain, "error", err)
+		return message
+	}
+	return signed
+}
+
+func init() {
+	models.NewModel("DKIMKey")
+	h.DKIMKey().SetDefaultOrder("Domain", "ID desc")
+	h.DKIMKey().AddFields(fields_DKIMKey)
+	h.DKIMKey().AddSQLConstraint("domain_selector_uniq", "unique(domain, selector)",
+		"A DKIM key with the same selector already exists for this domain!")
+
+	h.DKIMKey().NewMethod("CheckPrivateKey", dkimKey_CheckPrivateKey)
+	h.DKIMKey().NewMethod("ComputeDNSRecord", dkimKey_ComputeDNSRecord)
+	h.DKIMKey().NewMethod("ComputeDNSName", dkimKey_ComputeDNSName)
+	h.DKIMKey().NewMethod("ActionActivate", dkimKey_ActionActivate)
+	h.DKIMKey().NewMethod("ActionRotate", dkimKey_ActionRotate)
+	h.DKIMKey().Methods().Create().Extend(dkimKey_Create)
+}
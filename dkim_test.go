@@ -0,0 +1,63 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDKIMKeys(t *testing.T) {
+	Convey("Testing DKIM keys", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			previousSize := DKIMKeySize
+			DKIMKeySize = 1024
+			defer func() { DKIMKeySize = previousSize }()
+			key := h.DKIMKey().Create(env, h.DKIMKey().NewData().SetDomain(" Example.COM "))
+			message := basetypes.OutgoingEmail{
+				From:    "sender@example.com",
+				To:      []string{"rcpt@example.org"},
+				Subject: "Signed",
+				Body:    "Hello",
+			}.Bytes()
+			Convey("Keys and selectors are generated", func() {
+				So(key.Domain(), ShouldEqual, "example.com")
+				So(key.Selector(), ShouldStartWith, "hexya")
+				So(key.DNSName(), ShouldEqual, key.Selector()+"._domainkey.example.com")
+				So(key.DNSRecord(), ShouldStartWith, "v=DKIM1; k=rsa; p=")
+				So(key.Current(), ShouldBeFalse)
+				So(func() { key.SetPrivateKey("invalid") }, ShouldPanic)
+			})
+			Convey("Emails are signed once the key is activated", func() {
+				So(DKIMKeyFor(env, "example.com").IsEmpty(), ShouldBeTrue)
+				So(dkimSignMessage(env, "sender@example.com", message), ShouldResemble, message)
+				key.ActionActivate()
+				So(DKIMKeyFor(env, "EXAMPLE.com").Equals(key), ShouldBeTrue)
+				signed := string(dkimSignMessage(env, "sender@example.com", message))
+				So(signed, ShouldStartWith, "DKIM-Signature: ")
+				So(signed, ShouldContainSubstring, "d=example.com; s="+key.Selector()+";")
+				So(strings.HasSuffix(signed, string(message)), ShouldBeTrue)
+				So(dkimSignMessage(env, "sender@example.org", message), ShouldResemble, message)
+			})
+			Convey("Rotated keys replace the current key once activated", func() {
+				key.ActionActivate()
+				action := key.ActionRotate()
+				newKey := h.DKIMKey().BrowseOne(env, action.ResID)
+				So(newKey.Selector(), ShouldNotEqual, key.Selector())
+				So(newKey.Domain(), ShouldEqual, "example.com")
+				So(DKIMKeyFor(env, "example.com").Equals(key), ShouldBeTrue)
+				newKey.ActionActivate()
+				So(DKIMKeyFor(env, "example.com").Equals(newKey), ShouldBeTrue)
+				So(key.Current(), ShouldBeFalse)
+				So(key.Active(), ShouldBeFalse)
+			})
+		}), ShouldBeNil)
+	})
+}
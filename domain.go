@@ -0,0 +1,295 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/erlangs/okoo/src/models"
+)
+
+// Prefix operators of domains
+const (
+	DomainAnd = "&"
+	DomainOr  = "|"
+	DomainNot = "!"
+)
+
+// ParseDomainString parses the given string domain, either in the client
+// syntax, e.g. [('name', 'ilike', 'agrolait'), '|', ('active', '=', True)],
+// or in JSON, e.g. [["name", "ilike", "agrolait"]]. Tuples and lists are both
+// returned as []interface{}, integers as int64 and decimals as float64.
+//
+// An empty string is an empty domain.
+func ParseDomainString(str string) (models.Domain, error) {
+	if strings.TrimSpace(str) == "" {
+		return models.Domain{}, nil
+	}
+	p := &domainParser{input: []rune(str)}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected trailing characters")
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("domain must be a list, got %v", value)
+	}
+	for _, elem := range list {
+		if err := checkDomainElement(elem); err != nil {
+			return nil, err
+		}
+	}
+	return models.Domain(list), nil
+}
+
+// ConditionFromDomainString returns the condition of the given string domain.
+// It returns an empty condition for an empty domain.
+func ConditionFromDomainString(str string) (*models.Condition, error) {
+	dom, err := ParseDomainString(str)
+	if err != nil {
+		return nil, err
+	}
+	return models.ParseDomain(dom), nil
+}
+
+// FormatDomain returns the given domain in the client syntax, e.g.
+// [('record_id', '=', 1)]. It is typically called with the Serialize()
+// result of a q condition.
+func FormatDomain(dom models.Domain) string {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, elem := range dom {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		term := reflect.ValueOf(elem)
+		if elem != nil && term.Kind() == reflect.Slice && term.Type().Elem().Kind() != reflect.Uint8 {
+			b.WriteString("(")
+			for j := 0; j < term.Len(); j++ {
+				if j > 0 {
+					b.WriteString(", ")
+				}
+				formatDomainValue(&b, term.Index(j).Interface())
+			}
+			b.WriteString(")")
+			continue
+		}
+		formatDomainValue(&b, elem)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// checkDomainElement returns an error if elem is neither a prefix operator
+// nor a (field, operator, value) term.
+func checkDomainElement(elem interface{}) error {
+	switch e := elem.(type) {
+	case string:
+		if e != DomainAnd && e != DomainOr && e != DomainNot {
+			return fmt.Errorf("invalid domain operator '%s'", e)
+		}
+		return nil
+	case []interface{}:
+		if len(e) != 3 {
+			return fmt.Errorf("invalid domain term %v: expected 3 elements", e)
+		}
+		if _, ok := e[0].(string); !ok {
+			return fmt.Errorf("invalid domain term %v: field must be a string", e)
+		}
+		if _, ok := e[1].(string); !ok {
+			return fmt.Errorf("invalid domain term %v: operator must be a string", e)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid domain element %v", elem)
+}
+
+// formatDomainValue writes the given value in the client syntax to b
+func formatDomainValue(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		b.WriteString("False")
+		return
+	case string:
+		b.WriteString("'")
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v))
+		b.WriteString("'")
+		return
+	case bool:
+		if v {
+			b.WriteString("True")
+		} else {
+			b.WriteString("False")
+		}
+		return
+	case fmt.Stringer:
+		formatDomainValue(b, v.String())
+		return
+	}
+	val := reflect.ValueOf(value)
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		b.WriteString(strconv.FormatInt(val.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		b.WriteString(strconv.FormatUint(val.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		b.WriteString(strconv.FormatFloat(val.Float(), 'f', -1, 64))
+	case reflect.Slice, reflect.Array:
+		b.WriteString("[")
+		for i := 0; i < val.Len(); i++ {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			formatDomainValue(b, val.Index(i).Interface())
+		}
+		b.WriteString("]")
+	default:
+		formatDomainValue(b, fmt.Sprintf("%v", value))
+	}
+}
+
+// A domainParser is a recursive descent parser of string domains
+type domainParser struct {
+	input []rune
+	pos   int
+}
+
+// errorf returns an error at the current position
+func (p *domainParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid domain at position %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpaces advances the position to the next non space character
+func (p *domainParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// parseValue parses a list, tuple, string, number or constant
+func (p *domainParser) parseValue() (interface{}, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("unexpected end of domain")
+	}
+	switch c := p.input[p.pos]; {
+	case c == '[':
+		return p.parseList(']')
+	case c == '(':
+		return p.parseList(')')
+	case c == '\'' || c == '"':
+		return p.parseString(c)
+	case c == '-' || c == '+' || c == '.' || unicode.IsDigit(c):
+		return p.parseNumber()
+	case unicode.IsLetter(c):
+		return p.parseConstant()
+	}
+	return nil, p.errorf("unexpected character '%c'", p.input[p.pos])
+}
+
+// parseList parses a comma separated list of values until the given closing character
+func (p *domainParser) parseList(closing rune) (interface{}, error) {
+	p.pos++
+	res := []interface{}{}
+	for {
+		p.skipSpaces()
+		if p.pos < len(p.input) && p.input[p.pos] == closing {
+			p.pos++
+			return res, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, value)
+		p.skipSpaces()
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("missing '%c'", closing)
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case closing:
+		default:
+			return nil, p.errorf("expected ',' or '%c'", closing)
+		}
+	}
+}
+
+// parseString parses a string delimited by the given quote
+func (p *domainParser) parseString(quote rune) (interface{}, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		p.pos++
+		switch c {
+		case quote:
+			return b.String(), nil
+		case '\\':
+			if p.pos >= len(p.input) {
+				return nil, p.errorf("unterminated string")
+			}
+			e := p.input[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				b.WriteRune('\n')
+			case 't':
+				b.WriteRune('\t')
+			case 'r':
+				b.WriteRune('\r')
+			default:
+				b.WriteRune(e)
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return nil, p.errorf("unterminated string")
+}
+
+// parseNumber parses an integer or a decimal number
+func (p *domainParser) parseNumber() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.input) && strings.ContainsRune("+-.0123456789eE", p.input[p.pos]) {
+		p.pos++
+	}
+	str := string(p.input[start:p.pos])
+	if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number '%s'", str)
+	}
+	return f, nil
+}
+
+// parseConstant parses True, False and None, or their JSON equivalents
+func (p *domainParser) parseConstant() (interface{}, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || p.input[p.pos] == '_') {
+		p.pos++
+	}
+	switch word := string(p.input[start:p.pos]); word {
+	case "True", "true":
+		return true, nil
+	case "False", "false":
+		return false, nil
+	case "None", "null":
+		return nil, nil
+	default:
+		p.pos = start
+		return nil, p.errorf("unknown identifier '%s'", word)
+	}
+}
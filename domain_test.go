@@ -0,0 +1,79 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDomainParser(t *testing.T) {
+	Convey("Testing string domains", t, func() {
+		Convey("Client syntax domains are parsed", func() {
+			dom, err := ParseDomainString(`['|', ('name', 'ilike', 'O\'Brien'), ("active", "=", True), ('id', 'in', [1, 2]), ('x', '>', -1.5), ('y', '=', None)]`)
+			So(err, ShouldBeNil)
+			So(dom, ShouldResemble, models.Domain{
+				"|",
+				[]interface{}{"name", "ilike", "O'Brien"},
+				[]interface{}{"active", "=", true},
+				[]interface{}{"id", "in", []interface{}{int64(1), int64(2)}},
+				[]interface{}{"x", ">", -1.5},
+				[]interface{}{"y", "=", nil},
+			})
+		})
+		Convey("JSON domains are parsed", func() {
+			dom, err := ParseDomainString(`[["name", "=", "Agrolait"], ["active", "=", false]]`)
+			So(err, ShouldBeNil)
+			So(dom, ShouldResemble, models.Domain{
+				[]interface{}{"name", "=", "Agrolait"},
+				[]interface{}{"active", "=", false},
+			})
+			dom, err = ParseDomainString("  ")
+			So(err, ShouldBeNil)
+			So(dom, ShouldBeEmpty)
+		})
+		Convey("Invalid domains are rejected", func() {
+			for _, str := range []string{
+				`[('name', '=')]`,
+				`[('name', '=', 'a')`,
+				`['x', ('name', '=', 'a')]`,
+				`[('name', '=', foo)]`,
+				`('name', '=', 'a')`,
+				`[('name', '=', 'a')] x`,
+			} {
+				_, err := ParseDomainString(str)
+				So(err, ShouldNotBeNil)
+			}
+		})
+		Convey("Domains are formatted in the client syntax and parsed back", func() {
+			dom := models.Domain{
+				"!",
+				[]interface{}{"name", "ilike", "O'Brien"},
+				[]interface{}{"id", "in", []int64{1, 2}},
+				[]interface{}{"active", "=", true},
+				[]interface{}{"parent_id", "=", nil},
+			}
+			str := FormatDomain(dom)
+			So(str, ShouldEqual, `['!', ('name', 'ilike', 'O\'Brien'), ('id', 'in', [1, 2]), ('active', '=', True), ('parent_id', '=', False)]`)
+			parsed, err := ParseDomainString(str)
+			So(err, ShouldBeNil)
+			So(FormatDomain(parsed), ShouldEqual, str)
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Domain Partner").SetRef("DOMAIN"))
+			Convey("Conditions are serialized to string domains and back", func() {
+				str := FormatDomain(models.Domain(q.Partner().Ref().Equals("DOMAIN").Serialize()))
+				So(str, ShouldEqual, `[('ref', '=', 'DOMAIN')]`)
+				cond, err := ConditionFromDomainString(str)
+				So(err, ShouldBeNil)
+				So(h.Partner().Search(env, q.PartnerCondition{Condition: cond}).Equals(partner), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/server"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+)
+
+// An ErrorReport describes an error recovered from a panic, with the context
+// in which it happened.
+type ErrorReport struct {
+	// Error is the value with which the code panicked
+	Error interface{}
+	// Stack is the stack trace of the panic
+	Stack []byte
+	// UserID is the ID of the user who triggered the error
+	UserID int64
+	// Model, Method and RecordIDs identify the called method, if any
+	Model     string
+	Method    string
+	RecordIDs []int64
+	// URL is the requested URL for errors in controllers
+	URL  string
+	Time time.Time
+}
+
+// Message returns the error message of this report
+func (r ErrorReport) Message() string {
+	if err, ok := r.Error.(error); ok {
+		return err.Error()
+	}
+	return fmt.Sprintf("%v", r.Error)
+}
+
+// An ErrorReporter sends error reports to an error tracking service.
+// Report must not panic and should not block.
+type ErrorReporter interface {
+	Report(report ErrorReport)
+}
+
+// errorReportDedupDelay is the delay during which the same error is not reported
+// again, since a panic is recovered and reported by each nested method call.
+const errorReportDedupDelay = time.Second
+
+var errorReporters = struct {
+	sync.Mutex
+	reporters   []ErrorReporter
+	lastMessage string
+	lastTime    time.Time
+}{}
+
+// RegisterErrorReporter adds the given reporter to the reporters that
+// receive the reports of the errors recovered by the base module.
+func RegisterErrorReporter(reporter ErrorReporter) {
+	errorReporters.Lock()
+	defer errorReporters.Unlock()
+	errorReporters.reporters = append(errorReporters.reporters, reporter)
+}
+
+// ReportError sends the given report to all the registered reporters.
+// User errors are not reported.
+func ReportError(report ErrorReport) {
+	if _, ok := UserErrorData(report.Error); ok {
+		return
+	}
+	if report.Time.IsZero() {
+		report.Time = time.Now()
+	}
+	errorReporters.Lock()
+	message := report.Message()
+	if message == errorReporters.lastMessage && report.Time.Sub(errorReporters.lastTime) < errorReportDedupDelay {
+		errorReporters.Unlock()
+		return
+	}
+	errorReporters.lastMessage = message
+	errorReporters.lastTime = report.Time
+	reporters := make([]ErrorReporter, len(errorReporters.reporters))
+	copy(reporters, errorReporters.reporters)
+	errorReporters.Unlock()
+	for _, reporter := range reporters {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Warn("Error reporter panicked", "error", r)
+				}
+			}()
+			reporter.Report(report)
+		}()
+	}
+}
+
+// reportMethodPanic reports the panic being recovered, if any, and panics again
+// with the same value. It must be deferred at the beginning of the method.
+//
+// The database is not queried since the transaction may be aborted.
+func reportMethodPanic(rs models.RecordSet, method string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	ReportError(ErrorReport{
+		Error:     r,
+		Stack:     debug.Stack(),
+		UserID:    rs.Env().Uid(),
+		Model:     rs.ModelName(),
+		Method:    method,
+		RecordIDs: rs.Ids(),
+	})
+	panic(r)
+}
+
+func baseMixin_CreateReportErrors(rs m.BaseMixinSet, data m.BaseMixinData) m.BaseMixinSet {
+	defer reportMethodPanic(rs, "Create")
+	return rs.Super().Create(data)
+}
+
+func baseMixin_WriteReportErrors(rs m.BaseMixinSet, data m.BaseMixinData) bool {
+	defer reportMethodPanic(rs, "Write")
+	return rs.Super().Write(data)
+}
+
+func baseMixin_UnlinkReportErrors(rs m.BaseMixinSet) int64 {
+	defer reportMethodPanic(rs, "Unlink")
+	return rs.Super().Unlink()
+}
+
+// reportControllerErrors wraps the given controller so that its panics are
+// reported before being handled by the server. User errors are sent to the
+// client as a JSON object with a 400 status.
+func reportControllerErrors(handler func(*server.Context)) func(*server.Context) {
+	return func(c *server.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if data, ok := UserErrorData(r); ok {
+				c.JSON(http.StatusBadRequest, map[string]interface{}{"error": data})
+				return
+			}
+			uid, _ := c.Session().Get("uid").(int64)
+			ReportError(ErrorReport{
+				Error:  r,
+				Stack:  debug.Stack(),
+				UserID: uid,
+				URL:    c.Request.URL.String(),
+			})
+			panic(r)
+		}()
+		handler(c)
+	}
+}
+
+// SentryReporter is an ErrorReporter that sends reports to a Sentry server
+type SentryReporter struct {
+	// Environment and Release are sent with each event, if set
+	Environment string
+	Release     string
+	storeURL    string
+	auth        string
+	client      *http.Client
+}
+
+// NewSentryReporter returns a SentryReporter for the given Sentry DSN,
+// e.g. https://public_key@sentry.example.com/42
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %s", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing public key")
+	}
+	lastSlash := strings.LastIndex(u.Path, "/")
+	projectID := u.Path[lastSlash+1:]
+	if projectID == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing project ID")
+	}
+	return &SentryReporter{
+		storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:lastSlash], projectID),
+		auth: fmt.Sprintf("Sentry sentry_version=7, sentry_client=hexya-base/1.0, sentry_key=%s",
+			u.User.Username()),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// sentryEvent returns the Sentry event of the given report
+func (s *SentryReporter) sentryEvent(report ErrorReport) map[string]interface{} {
+	tags := map[string]string{}
+	if report.Model != "" {
+		tags["model"] = report.Model
+	}
+	if report.Method != "" {
+		tags["method"] = report.Method
+	}
+	event := map[string]interface{}{
+		"event_id":  strings.Replace(uuid.New().String(), "-", "", -1),
+		"timestamp": report.Time.UTC().Format("2006-01-02T15:04:05"),
+		"level":     "error",
+		"platform":  "go",
+		"logger":    "hexya",
+		"message":   report.Message(),
+		"exception": map[string]interface{}{
+			"values": []map[string]string{{"type": fmt.Sprintf("%T", report.Error), "value": report.Message()}},
+		},
+		"user":  map[string]string{"id": fmt.Sprintf("%d", report.UserID)},
+		"tags":  tags,
+		"extra": map[string]interface{}{"records": report.RecordIDs, "url": report.URL, "stack": string(report.Stack)},
+	}
+	if s.Environment != "" {
+		event["environment"] = s.Environment
+	}
+	if s.Release != "" {
+		event["release"] = s.Release
+	}
+	return event
+}
+
+// Report sends the given report to Sentry in the background
+func (s *SentryReporter) Report(report ErrorReport) {
+	body, err := json.Marshal(s.sentryEvent(report))
+	if err != nil {
+		log.Warn("Unable to encode Sentry event", "error", err)
+		return
+	}
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, s.storeURL, bytes.NewReader(body))
+		if err != nil {
+			log.Warn("Unable to create Sentry request", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", s.auth)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			log.Warn("Unable to send error report to Sentry", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Warn("Sentry rejected error report", "status", resp.Status)
+		}
+	}()
+}
+
+// configureErrorReporting registers a SentryReporter if the Sentry.DSN
+// configuration key is set.
+func configureErrorReporting() {
+	dsn := viper.GetString("Sentry.DSN")
+	if dsn == "" {
+		return
+	}
+	reporter, err := NewSentryReporter(dsn)
+	if err != nil {
+		log.Warn("Unable to configure Sentry error reporting", "error", err)
+		return
+	}
+	reporter.Environment = viper.GetString("Sentry.Environment")
+	reporter.Release = viper.GetString("Sentry.Release")
+	RegisterErrorReporter(reporter)
+}
+
+func init() {
+	h.BaseMixin().Methods().Create().Extend(baseMixin_CreateReportErrors)
+	h.BaseMixin().Methods().Write().Extend(baseMixin_WriteReportErrors)
+	h.BaseMixin().Methods().Unlink().Extend(baseMixin_UnlinkReportErrors)
+}
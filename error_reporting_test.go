@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testErrorReporter records the reports it receives
+type testErrorReporter struct {
+	reports []ErrorReport
+}
+
+// Report records the given report
+func (r *testErrorReporter) Report(report ErrorReport) {
+	r.reports = append(r.reports, report)
+}
+
+func TestErrorReporting(t *testing.T) {
+	Convey("Testing error reporting", t, func() {
+		reporter := new(testErrorReporter)
+		RegisterErrorReporter(reporter)
+		defer func() {
+			errorReporters.Lock()
+			errorReporters.reporters = errorReporters.reporters[:len(errorReporters.reporters)-1]
+			errorReporters.Unlock()
+		}()
+		Convey("Panics in Create are reported with their context", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				So(func() {
+					h.Alias().Create(env, h.Alias().NewData().SetName("Invalid Name").SetModel("Partner"))
+				}, ShouldPanic)
+				So(reporter.reports, ShouldHaveLength, 1)
+				So(reporter.reports[0].Model, ShouldEqual, "Alias")
+				So(reporter.reports[0].Method, ShouldEqual, "Create")
+				So(reporter.reports[0].UserID, ShouldEqual, security.SuperUserID)
+				So(reporter.reports[0].Stack, ShouldNotBeEmpty)
+			}), ShouldBeNil)
+		})
+		Convey("The same error is reported once", func() {
+			now := time.Now()
+			ReportError(ErrorReport{Error: errors.New("duplicate error"), Time: now})
+			ReportError(ErrorReport{Error: errors.New("duplicate error"), Time: now.Add(time.Millisecond)})
+			So(reporter.reports, ShouldHaveLength, 1)
+			So(reporter.reports[0].Message(), ShouldEqual, "duplicate error")
+		})
+		Convey("Sentry DSNs are parsed", func() {
+			sentry, err := NewSentryReporter("https://abc123@sentry.example.com/prefix/42")
+			So(err, ShouldBeNil)
+			So(sentry.storeURL, ShouldEqual, "https://sentry.example.com/prefix/api/42/store/")
+			So(sentry.auth, ShouldContainSubstring, "sentry_key=abc123")
+			event := sentry.sentryEvent(ErrorReport{Error: "boom", Model: "Partner", Method: "Write", UserID: 2})
+			So(event["message"], ShouldEqual, "boom")
+			So(event["tags"], ShouldResemble, map[string]string{"model": "Partner", "method": "Write"})
+			_, err = NewSentryReporter("https://sentry.example.com/42")
+			So(err, ShouldNotBeNil)
+			_, err = NewSentryReporter("https://abc123@sentry.example.com/")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
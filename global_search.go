@@ -0,0 +1,264 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/operator"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+)
+
+// Limits of the number of results returned by GlobalSearch
+const (
+	// GlobalSearchDefaultLimit is the number of results returned when no limit is given
+	GlobalSearchDefaultLimit = 20
+	// GlobalSearchMaxLimit is the maximum number of results that can be requested
+	GlobalSearchMaxLimit = 100
+)
+
+// globalSearchSnippetRadius is the number of characters kept on each side
+// of the first match in the snippets of the results
+const globalSearchSnippetRadius = 40
+
+// A GlobalSearchResult is a record matching a global search
+type GlobalSearchResult struct {
+	Model   string  `json:"model"`
+	ID      int64   `json:"id"`
+	Name    string  `json:"name"`
+	Label   string  `json:"label"`
+	Snippet string  `json:"snippet,omitempty"`
+	Score   float64 `json:"score"`
+}
+
+// A GlobalSearchFunc returns at most limit records of a model matching the given query.
+// The Score and Label of the results are set by GlobalSearch if left empty.
+type GlobalSearchFunc func(env models.Environment, query string, limit int) []GlobalSearchResult
+
+type globalSearchProvider struct {
+	model string
+	label string
+	limit int
+	fnct  GlobalSearchFunc
+}
+
+var globalSearchProviders = struct {
+	sync.RWMutex
+	providers map[string]globalSearchProvider
+}{
+	providers: make(map[string]globalSearchProvider),
+}
+
+// RegisterGlobalSearch registers fnct to search the given model in GlobalSearch.
+// label is the name of the result category in the UI and limit the maximum
+// number of results of this model. The model is only searched for users
+// allowed to execute its SearchByName method.
+//
+// It panics if a search is already registered for this model.
+func RegisterGlobalSearch(model, label string, limit int, fnct GlobalSearchFunc) {
+	globalSearchProviders.Lock()
+	defer globalSearchProviders.Unlock()
+	if _, exists := globalSearchProviders.providers[model]; exists {
+		log.Panic("Global search already registered for model", "model", model)
+	}
+	globalSearchProviders.providers[model] = globalSearchProvider{model: model, label: label, limit: limit, fnct: fnct}
+}
+
+// GlobalSearch searches the query in all the registered models and returns
+// at most limit results, ranked by decreasing relevance.
+//
+// Models the current user cannot search are skipped, as well as providers
+// that panic, so that a faulty model does not break the whole search.
+func GlobalSearch(env models.Environment, query string, limit int) []GlobalSearchResult {
+	query = strings.TrimSpace(query)
+	res := []GlobalSearchResult{}
+	if query == "" {
+		return res
+	}
+	switch {
+	case limit <= 0:
+		limit = GlobalSearchDefaultLimit
+	case limit > GlobalSearchMaxLimit:
+		limit = GlobalSearchMaxLimit
+	}
+	globalSearchProviders.RLock()
+	providers := make([]globalSearchProvider, 0, len(globalSearchProviders.providers))
+	for _, provider := range globalSearchProviders.providers {
+		providers = append(providers, provider)
+	}
+	globalSearchProviders.RUnlock()
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i].model < providers[j].model
+	})
+	for _, provider := range providers {
+		if !globalSearchAllowed(env, provider.model) {
+			continue
+		}
+		modelLimit := provider.limit
+		if modelLimit <= 0 || modelLimit > limit {
+			modelLimit = limit
+		}
+		for _, result := range runGlobalSearch(env, provider, query, modelLimit) {
+			if result.Model == "" {
+				result.Model = provider.model
+			}
+			if result.Label == "" {
+				result.Label = provider.label
+			}
+			if result.Score == 0 {
+				result.Score = globalSearchScore(result.Name, query)
+			}
+			res = append(res, result)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return strings.ToLower(res[i].Name) < strings.ToLower(res[j].Name)
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// globalSearchAllowed returns true if the current user can search the given model
+func globalSearchAllowed(env models.Environment, model string) bool {
+	mi, ok := models.Registry.Get(model)
+	if !ok {
+		return false
+	}
+	meth, ok := mi.Methods().Get("SearchByName")
+	if !ok {
+		return false
+	}
+	return env.Pool(model).CheckExecutionPermission(meth.Underlying(), true)
+}
+
+// runGlobalSearch runs the given provider inside a savepoint and recovers its
+// panics, so that an SQL error of the provider does not abort the transaction
+// of the following providers.
+func runGlobalSearch(env models.Environment, provider globalSearchProvider, query string, limit int) (res []GlobalSearchResult) {
+	env.Cr().Execute("SAVEPOINT global_search")
+	defer func() {
+		if r := recover(); r != nil {
+			env.Cr().Execute("ROLLBACK TO SAVEPOINT global_search")
+			log.Warn("Global search failed", "model", provider.model, "error", r)
+			res = nil
+			return
+		}
+		env.Cr().Execute("RELEASE SAVEPOINT global_search")
+	}()
+	return provider.fnct(env, query, limit)
+}
+
+// globalSearchScore returns the relevance of a record with the given name for query.
+// Exact matches rank first, then prefixes, word prefixes, substrings and finally
+// records matching on another field than their name.
+func globalSearchScore(name, query string) float64 {
+	name, query = strings.ToLower(name), strings.ToLower(query)
+	switch {
+	case name == query:
+		return 100
+	case strings.HasPrefix(name, query):
+		return 75
+	case strings.Contains(" "+name, " "+query):
+		return 50
+	case strings.Contains(name, query):
+		return 25
+	default:
+		return 10
+	}
+}
+
+// globalSearchSnippet returns an extract of text around the first occurrence of query,
+// or an empty string if text does not contain query.
+func globalSearchSnippet(text, query string) string {
+	pos := strings.Index(strings.ToLower(text), strings.ToLower(query))
+	if pos < 0 {
+		return ""
+	}
+	start, end := pos-globalSearchSnippetRadius, pos+len(query)+globalSearchSnippetRadius
+	prefix, suffix := "…", "…"
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= len(text) {
+		end, suffix = len(text), ""
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	snippet := strings.Join(strings.Fields(text[start:end]), " ")
+	return prefix + snippet + suffix
+}
+
+// globalSearchRecords returns the given records as global search results
+func globalSearchRecords(rs models.RecordSet) []GlobalSearchResult {
+	var res []GlobalSearchResult
+	for _, rec := range rs.Collection().Records() {
+		res = append(res, GlobalSearchResult{
+			Model: rec.ModelName(),
+			ID:    rec.ID(),
+			Name:  rec.Call("NameGet").(string),
+		})
+	}
+	return res
+}
+
+// searchPartners is the global search function of the Partner model
+func searchPartners(env models.Environment, query string, limit int) []GlobalSearchResult {
+	return globalSearchRecords(h.Partner().NewSet(env).SearchByName(query, operator.IContains, q.PartnerCondition{}, limit))
+}
+
+// searchCompanies is the global search function of the Company model
+func searchCompanies(env models.Environment, query string, limit int) []GlobalSearchResult {
+	return globalSearchRecords(h.Company().NewSet(env).SearchByName(query, operator.IContains, q.CompanyCondition{}, limit))
+}
+
+// searchUsers is the global search function of the User model
+func searchUsers(env models.Environment, query string, limit int) []GlobalSearchResult {
+	return globalSearchRecords(h.User().NewSet(env).SearchByName(query, operator.IContains, q.UserCondition{}, limit))
+}
+
+// searchAttachments is the global search function of the Attachment model.
+// It searches both in the name and in the indexed content of the documents.
+func searchAttachments(env models.Environment, query string, limit int) []GlobalSearchResult {
+	attachments := h.Attachment().Search(env, q.Attachment().Name().IContains(query).
+		Or().IndexContent().IContains(query)).Limit(limit)
+	var res []GlobalSearchResult
+	for _, attachment := range attachments.Records() {
+		result := GlobalSearchResult{
+			Model: "Attachment",
+			ID:    attachment.ID(),
+			Name:  attachment.Name(),
+			Score: globalSearchScore(attachment.Name(), query),
+		}
+		if !strings.Contains(strings.ToLower(attachment.Name()), strings.ToLower(query)) {
+			result.Snippet = globalSearchSnippet(attachment.IndexContent(), query)
+		}
+		if attachment.ResName() != "" {
+			result.Name = fmt.Sprintf("%s (%s)", attachment.Name(), attachment.ResName())
+		}
+		res = append(res, result)
+	}
+	return res
+}
+
+func init() {
+	RegisterGlobalSearch("Partner", "Contacts", 10, searchPartners)
+	RegisterGlobalSearch("Company", "Companies", 5, searchCompanies)
+	RegisterGlobalSearch("User", "Users", 5, searchUsers)
+	RegisterGlobalSearch("Attachment", "Documents", 10, searchAttachments)
+}
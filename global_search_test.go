@@ -0,0 +1,81 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"os"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/viper"
+)
+
+func TestGlobalSearch(t *testing.T) {
+	Convey("Testing global search", t, func() {
+		Convey("Scores rank exact matches, prefixes and substrings", func() {
+			So(globalSearchScore("Zorglub", "zorglub"), ShouldEqual, 100)
+			So(globalSearchScore("Zorglub Inc", "zorglub"), ShouldEqual, 75)
+			So(globalSearchScore("Mr Zorglub", "zorglub"), ShouldEqual, 50)
+			So(globalSearchScore("TheZorglub", "zorglub"), ShouldEqual, 25)
+			So(globalSearchScore("Other", "zorglub"), ShouldEqual, 10)
+		})
+		Convey("Snippets are extracted around the first match", func() {
+			So(globalSearchSnippet("short text about zorglub", "Zorglub"), ShouldEqual, "short text about zorglub")
+			So(globalSearchSnippet("no match here", "zorglub"), ShouldBeEmpty)
+			long := "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa zorglub bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+			snippet := globalSearchSnippet(long, "zorglub")
+			So(snippet, ShouldStartWith, "…")
+			So(snippet, ShouldEndWith, "…")
+			So(snippet, ShouldContainSubstring, "zorglub")
+		})
+		Convey("Registering a model twice panics", func() {
+			So(func() { RegisterGlobalSearch("Partner", "Contacts", 10, searchPartners) }, ShouldPanic)
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			viper.Set("DataDir", os.TempDir())
+			exact := h.Partner().Create(env, h.Partner().NewData().SetName("Zorglub"))
+			prefix := h.Partner().Create(env, h.Partner().NewData().SetName("Zorglub Industries"))
+			attachment := h.Attachment().Create(env, h.Attachment().NewData().
+				SetName("contract.pdf"))
+			attachment.WithContext("attachment_set_datas", true).
+				SetIndexContent("This contract is signed between ACME and Zorglub Industries.")
+			Convey("Results of all models are merged and ranked", func() {
+				results := GlobalSearch(env, "zorglub", 0)
+				So(len(results), ShouldBeGreaterThanOrEqualTo, 3)
+				So(results[0].Model, ShouldEqual, "Partner")
+				So(results[0].ID, ShouldEqual, exact.ID())
+				So(results[0].Label, ShouldEqual, "Contacts")
+				So(results[1].ID, ShouldEqual, prefix.ID())
+				var found bool
+				for _, result := range results {
+					if result.Model == "Attachment" && result.ID == attachment.ID() {
+						found = true
+						So(result.Snippet, ShouldContainSubstring, "Zorglub Industries")
+					}
+				}
+				So(found, ShouldBeTrue)
+			})
+			Convey("The limit is applied to the merged results", func() {
+				results := GlobalSearch(env, "zorglub", 1)
+				So(results, ShouldHaveLength, 1)
+				So(results[0].ID, ShouldEqual, exact.ID())
+			})
+			Convey("A provider failing with an SQL error does not abort the transaction", func() {
+				failing := globalSearchProvider{model: "Partner", fnct: func(env models.Environment, query string, limit int) []GlobalSearchResult {
+					env.Cr().Execute("SELECT no_such_column FROM partner")
+					return nil
+				}}
+				So(runGlobalSearch(env, failing, "zorglub", 10), ShouldBeEmpty)
+				So(h.Partner().Search(env, q.Partner().Name().Equals("Zorglub")).Equals(exact), ShouldBeTrue)
+			})
+			Convey("Empty queries return no results", func() {
+				So(GlobalSearch(env, "  ", 0), ShouldBeEmpty)
+			})
+		}), ShouldBeNil)
+	})
+}
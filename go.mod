@@ -1,6 +1,6 @@
 module github.com/erlangs/hexya-base
 
-go 1.13
+go 1.16
 
 require (
 	github.com/google/uuid v1.1.1
@@ -0,0 +1,283 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// GroupMembershipRetentionDays is the default number of days during which
+// expired group memberships are kept for auditing before being deleted.
+// It can be changed with the 'base.group_membership_retention_days' config parameter.
+const GroupMembershipRetentionDays = 90
+
+// GroupMembershipSyncPeriod is the delay between two reloads of the
+// temporary group memberships from the database into the security registry
+// of this process, so that grants and revocations made by other processes
+// are applied.
+const GroupMembershipSyncPeriod = 30 * time.Second
+
+// Group membership states
+const (
+	GroupMembershipPending = "pending"
+	GroupMembershipActive  = "active"
+	GroupMembershipExpired = "expired"
+)
+
+// groupMembershipExpirations holds for each user the earliest end of the
+// temporary memberships pushed to the security registry, so that HasGroup
+// can revoke them as soon as they expire without querying the database.
+// A timer is also scheduled for each user to revoke them from the registry
+// at this date, since access rights checks do not go through HasGroup.
+var groupMembershipExpirations = struct {
+	sync.RWMutex
+	dates  map[int64]time.Time
+	timers map[int64]*time.Timer
+}{
+	dates:  make(map[int64]time.Time),
+	timers: make(map[int64]*time.Timer),
+}
+
+// setGroupMembershipExpiration records the earliest expiration of the
+// temporary memberships of the given user and schedules their revocation
+// at this date. A zero time clears it.
+func setGroupMembershipExpiration(uid int64, expiration time.Time) {
+	groupMembershipExpirations.Lock()
+	defer groupMembershipExpirations.Unlock()
+	if timer, ok := groupMembershipExpirations.timers[uid]; ok {
+		timer.Stop()
+		delete(groupMembershipExpirations.timers, uid)
+	}
+	if expiration.IsZero() {
+		delete(groupMembershipExpirations.dates, uid)
+		return
+	}
+	groupMembershipExpirations.dates[uid] = expiration
+	groupMembershipExpirations.timers[uid] = time.AfterFunc(time.Until(expiration), func() {
+		revokeExpiredMemberships(uid)
+	})
+}
+
+// revokeExpiredMemberships removes the expired temporary memberships of the
+// given user from the security registry.
+func revokeExpiredMemberships(uid int64) {
+	if !groupMembershipExpired(uid, time.Now()) {
+		return
+	}
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		h.User().BrowseOne(env, uid).SyncMemberships()
+	})
+	if err != nil {
+		log.Warn("Unable to revoke expired group memberships", "uid", uid, "error", err)
+	}
+}
+
+// syncGroupMemberships is registered in the core Hexya loop to reload in
+// the security registry of this process the temporary memberships of the
+// users which have or had one recently.
+func syncGroupMemberships() {
+	groupMembershipExpirations.RLock()
+	uids := make([]int64, 0, len(groupMembershipExpirations.dates))
+	for uid := range groupMembershipExpirations.dates {
+		uids = append(uids, uid)
+	}
+	groupMembershipExpirations.RUnlock()
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		users := h.User().Browse(env, uids)
+		memberships := h.GroupMembership().Search(env,
+			q.GroupMembership().DateTo().Greater(dates.Now().Add(-2*GroupMembershipSyncPeriod)))
+		for _, membership := range memberships.Records() {
+			users = users.Union(membership.User())
+		}
+		users.SyncMemberships()
+	})
+	if err != nil {
+		log.Warn("Unable to reload group memberships", "error", err)
+	}
+}
+
+// groupMembershipExpired returns true if a temporary membership of the given
+// user has expired since the last synchronisation. The expiration is cleared
+// so that the caller resynchronises the memberships only once.
+func groupMembershipExpired(uid int64, now time.Time) bool {
+	groupMembershipExpirations.RLock()
+	expiration, ok := groupMembershipExpirations.dates[uid]
+	groupMembershipExpirations.RUnlock()
+	if !ok || now.Before(expiration) {
+		return false
+	}
+	setGroupMembershipExpiration(uid, time.Time{})
+	return true
+}
+
+// groupMembershipState returns the state of a membership valid from
+// dateFrom until dateTo at the given time.
+func groupMembershipState(dateFrom, dateTo, now time.Time) string {
+	switch {
+	case now.Before(dateFrom):
+		return GroupMembershipPending
+	case now.Before(dateTo):
+		return GroupMembershipActive
+	default:
+		return GroupMembershipExpired
+	}
+}
+
+var fields_GroupMembership = map[string]models.FieldDefinition{
+	"User": fields.Many2One{RelationModel: h.User(), Required: true, Index: true, OnDelete: models.Cascade},
+	"Group": fields.Many2One{RelationModel: h.Group(), Required: true, OnDelete: models.Cascade,
+		Constraint: h.GroupMembership().Methods().CheckGroup()},
+	"DateFrom": fields.DateTime{String: "Valid From", Required: true,
+		Constraint: h.GroupMembership().Methods().CheckDates(),
+		Default: func(env models.Environment) interface{} {
+			return dates.Now()
+		}},
+	"DateTo": fields.DateTime{String: "Valid Until", Required: true,
+		Constraint: h.GroupMembership().Methods().CheckDates(),
+		Help:       "The user loses the group's access rights at this date."},
+	"Reason": fields.Text{Help: "Why this access was granted, e.g. the audit or mission reference."},
+	"GrantedBy": fields.Many2One{RelationModel: h.User(), ReadOnly: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		}},
+	"State": fields.Selection{Selection: types.Selection{
+		GroupMembershipPending: "Pending",
+		GroupMembershipActive:  "Active",
+		GroupMembershipExpired: "Expired",
+	}, Required: true, ReadOnly: true, Default: models.DefaultValue(GroupMembershipPending)},
+}
+
+// CheckDates checks that memberships do not end before they start
+func groupMembership_CheckDates(rs m.GroupMembershipSet) {
+	for _, membership := range rs.Records() {
+		if !membership.DateTo().Greater(membership.DateFrom()) {
+			log.Panic(rs.T("The end of a temporary membership must be after its start"))
+		}
+	}
+}
+
+// CheckGroup checks that no membership is granted to the group of all users
+func groupMembership_CheckGroup(rs m.GroupMembershipSet) {
+	for _, membership := range rs.Records() {
+		if membership.Group().GroupID() == security.GroupEveryoneID {
+			log.Panic(rs.T("All users already belong to the group %s", membership.Group().Name()))
+		}
+	}
+}
+
+// NameGet returns "User: Group (until DateTo)", with DateTo in the current user's timezone
+func groupMembership_NameGet(rs m.GroupMembershipSet) string {
+	return rs.T("%s: %s (until %s)", rs.User().Name(), rs.Group().Name(), rs.FormatInUserTZ(rs.DateTo()))
+}
+
+func groupMembership_Create(rs m.GroupMembershipSet, data m.GroupMembershipData) m.GroupMembershipSet {
+	res := rs.Super().Create(data)
+	res.UpdateStates()
+	return res
+}
+
+func groupMembership_Write(rs m.GroupMembershipSet, data m.GroupMembershipData) bool {
+	users := rs.User()
+	res := rs.Super().Write(data)
+	if data.HasUser() || data.HasGroup() || data.HasDateFrom() || data.HasDateTo() {
+		rs.UpdateStates()
+		users.AsSuperUser("sync group memberships").SyncMemberships()
+	}
+	return res
+}
+
+func groupMembership_Unlink(rs m.GroupMembershipSet) int64 {
+	users := rs.User()
+	res := rs.Super().Unlink()
+	users.AsSuperUser("sync group memberships").SyncMemberships()
+	return res
+}
+
+// UpdateStates sets the state of these memberships according to the current
+// time and pushes the memberships of the users whose grants started or
+// expired to the security registry.
+func groupMembership_UpdateStates(rs m.GroupMembershipSet) {
+	now := dates.Now()
+	users := h.User().NewSet(rs.Env())
+	for _, membership := range rs.Records() {
+		state := groupMembershipState(membership.DateFrom().Time, membership.DateTo().Time, now.Time)
+		if state == membership.State() {
+			continue
+		}
+		membership.AsSuperUser("update group membership state").Write(h.GroupMembership().NewData().SetState(state))
+		users = users.Union(membership.User())
+	}
+	users.AsSuperUser("sync group memberships").SyncMemberships()
+}
+
+// ProcessMemberships activates the memberships which started, revokes
+// the ones which expired and deletes expired memberships older than the
+// retention period. It is called by the base_cron_group_memberships cron.
+func groupMembership_ProcessMemberships(rs m.GroupMembershipSet) {
+	h.GroupMembership().NewSet(rs.Env()).AsSuperUser("process group memberships").Search(
+		q.GroupMembership().State().NotEquals(GroupMembershipExpired)).UpdateStates()
+
+	param := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read membership retention").GetParam("base.group_membership_retention_days",
+		strconv.Itoa(GroupMembershipRetentionDays))
+	days, err := strconv.Atoi(param)
+	if err != nil {
+		log.Warn("Invalid group membership retention", "value", param)
+		days = GroupMembershipRetentionDays
+	}
+	limit := dates.Now().AddDate(0, 0, -days)
+	h.GroupMembership().NewSet(rs.Env()).AsSuperUser("purge expired group memberships").Search(
+		q.GroupMembership().State().Equals(GroupMembershipExpired).
+			And().DateTo().Lower(limit)).Unlink()
+}
+
+// ActiveGroupMemberships returns the temporary group memberships of this user
+// which are valid now.
+func user_ActiveGroupMemberships(rs m.UserSet) m.GroupMembershipSet {
+	now := dates.Now()
+	return h.GroupMembership().NewSet(rs.Env()).AsSuperUser("read active group memberships").Search(
+		q.GroupMembership().User().Equals(rs).
+			And().DateFrom().LowerOrEqual(now).
+			And().DateTo().Greater(now))
+}
+
+// EffectiveGroups returns the groups of this user, including the groups
+// granted by temporary memberships which are valid now.
+func user_EffectiveGroups(rs m.UserSet) m.GroupSet {
+	rs.EnsureOne()
+	groups := rs.Groups()
+	for _, membership := range rs.ActiveGroupMemberships().Records() {
+		groups = groups.Union(membership.Group())
+	}
+	return groups
+}
+
+func init() {
+	models.NewModel("GroupMembership")
+	h.GroupMembership().SetDefaultOrder("DateTo desc", "ID desc")
+	h.GroupMembership().AddFields(fields_GroupMembership)
+	h.GroupMembership().NewMethod("CheckDates", groupMembership_CheckDates)
+	h.GroupMembership().NewMethod("CheckGroup", groupMembership_CheckGroup)
+	h.GroupMembership().NewMethod("UpdateStates", groupMembership_UpdateStates)
+	h.GroupMembership().NewMethod("ProcessMemberships", groupMembership_ProcessMemberships)
+	h.GroupMembership().Methods().NameGet().Extend(groupMembership_NameGet)
+	h.GroupMembership().Methods().Create().Extend(groupMembership_Create)
+	h.GroupMembership().Methods().Write().Extend(groupMembership_Write)
+	h.GroupMembership().Methods().Unlink().Extend(groupMembership_Unlink)
+
+	h.User().NewMethod("ActiveGroupMemberships", user_ActiveGroupMemberships)
+	h.User().NewMethod("EffectiveGroups", user_EffectiveGroups)
+
+	models.RegisterWorker(models.NewWorkerFunction(syncGroupMemberships, GroupMembershipSyncPeriod))
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGroupMembershipState(t *testing.T) {
+	Convey("Testing group membership states", t, func() {
+		from := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+		to := time.Date(2020, 3, 31, 0, 0, 0, 0, time.UTC)
+		So(groupMembershipState(from, to, from.Add(-time.Hour)), ShouldEqual, GroupMembershipPending)
+		So(groupMembershipState(from, to, from), ShouldEqual, GroupMembershipActive)
+		So(groupMembershipState(from, to, to), ShouldEqual, GroupMembershipExpired)
+	})
+}
+
+func TestGroupMembershipExpiration(t *testing.T) {
+	Convey("Testing the scheduling of membership revocations", t, func() {
+		uid := int64(-1)
+		setGroupMembershipExpiration(uid, time.Now().Add(time.Hour))
+		groupMembershipExpirations.RLock()
+		_, scheduled := groupMembershipExpirations.timers[uid]
+		groupMembershipExpirations.RUnlock()
+		So(scheduled, ShouldBeTrue)
+		So(groupMembershipExpired(uid, time.Now()), ShouldBeFalse)
+		So(groupMembershipExpired(uid, time.Now().Add(2*time.Hour)), ShouldBeTrue)
+		setGroupMembershipExpiration(uid, time.Time{})
+		groupMembershipExpirations.RLock()
+		_, scheduled = groupMembershipExpirations.timers[uid]
+		groupMembershipExpirations.RUnlock()
+		So(scheduled, ShouldBeFalse)
+	})
+}
+
+func TestGroupMembership(t *testing.T) {
+	Convey("Testing temporary group memberships", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			systemGroup := h.Group().Search(env, q.Group().GroupID().Equals(GroupSystem.ID()))
+			auditor := h.User().Create(env, h.User().NewData().
+				SetName("Auditor").
+				SetLogin("temp_auditor"))
+			So(auditor.HasGroup(GroupSystem.ID()), ShouldBeFalse)
+			Convey("Active memberships grant the group", func() {
+				membership := h.GroupMembership().Create(env, h.GroupMembership().NewData().
+					SetUser(auditor).
+					SetGroup(systemGroup).
+					SetDateFrom(dates.Now().Add(-time.Hour)).
+					SetDateTo(dates.Now().Add(time.Hour)).
+					SetReason("Yearly audit"))
+				So(membership.State(), ShouldEqual, GroupMembershipActive)
+				So(auditor.HasGroup(GroupSystem.ID()), ShouldBeTrue)
+				So(auditor.Groups().Intersect(systemGroup).IsEmpty(), ShouldBeTrue)
+				Convey("Expired memberships are revoked in HasGroup", func() {
+					membership.Write(h.GroupMembership().NewData().
+						SetDateFrom(dates.Now().Add(-2 * time.Hour)).
+						SetDateTo(dates.Now().Add(-time.Hour)))
+					So(auditor.HasGroup(GroupSystem.ID()), ShouldBeFalse)
+					h.GroupMembership().NewSet(env).ProcessMemberships()
+					So(membership.State(), ShouldEqual, GroupMembershipExpired)
+				})
+				Convey("Deleting a membership revokes it", func() {
+					membership.Unlink()
+					So(auditor.HasGroup(GroupSystem.ID()), ShouldBeFalse)
+				})
+			})
+			Convey("Pending memberships do not grant the group", func() {
+				membership := h.GroupMembership().Create(env, h.GroupMembership().NewData().
+					SetUser(auditor).
+					SetGroup(systemGroup).
+					SetDateFrom(dates.Now().Add(time.Hour)).
+					SetDateTo(dates.Now().Add(2*time.Hour)))
+				So(membership.State(), ShouldEqual, GroupMembershipPending)
+				So(auditor.HasGroup(GroupSystem.ID()), ShouldBeFalse)
+			})
+			Convey("Memberships cannot end before they start", func() {
+				So(func() {
+					h.GroupMembership().Create(env, h.GroupMembership().NewData().
+						SetUser(auditor).
+						SetGroup(systemGroup).
+						SetDateFrom(dates.Now()).
+						SetDateTo(dates.Now().Add(-time.Hour)))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
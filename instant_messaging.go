@@ -0,0 +1,238 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// IMReceived is the state of incoming instant messages
+const IMReceived = "received"
+
+// IMMessageStates is the selection of the states of instant messages.
+// Outgoing messages follow the same states as SMS messages.
+var IMMessageStates = types.Selection{
+	SMSOutgoing:  "In Queue",
+	SMSSent:      "Sent",
+	SMSDelivered: "Delivered",
+	SMSError:     "Error",
+	SMSCancelled: "Cancelled",
+	IMReceived:   "Received",
+}
+
+// IMDirections is the selection of the directions of instant messages
+var IMDirections = types.Selection{
+	"incoming": "Incoming",
+	"outgoing": "Outgoing",
+}
+
+// An IMChannel sends and receives messages through an instant messaging
+// service such as WhatsApp or Telegram, where users are identified by their
+// phone number.
+type IMChannel interface {
+	// Send hands over the given message to the service and returns the
+	// service's reference of the message.
+	Send(env models.Environment, message basetypes.OutgoingIM) (string, error)
+	// ParseWebhook parses a request sent by the service to the
+	// /im/webhook/:channel URL and returns the messages it contains.
+	// Channels must authenticate the request and return an error if
+	// it does not come from the service.
+	ParseWebhook(r *http.Request) ([]basetypes.IncomingIM, error)
+}
+
+// An IMWebhookVerifier is an IMChannel whose service checks the webhook URL
+// with a GET request before sending messages to it.
+type IMWebhookVerifier interface {
+	// VerifyWebhook returns the body of the response to the given verification
+	// request, or false if the request is not a valid verification request.
+	VerifyWebhook(r *http.Request) (string, bool)
+}
+
+var imChannels = struct {
+	sync.RWMutex
+	channels map[string]IMChannel
+	labels   types.Selection
+}{
+	channels: make(map[string]IMChannel),
+	labels:   make(types.Selection),
+}
+
+// RegisterIMChannel registers the given IMChannel under the given name,
+// e.g. "whatsapp". It panics if a channel is already registered with this name.
+func RegisterIMChannel(name, label string, channel IMChannel) {
+	imChannels.Lock()
+	defer imChannels.Unlock()
+	if _, exists := imChannels.channels[name]; exists {
+		log.Panic("Instant messaging channel already registered", "name", name)
+	}
+	imChannels.channels[name] = channel
+	imChannels.labels[name] = label
+}
+
+// GetIMChannel returns the IMChannel registered with the given name.
+func GetIMChannel(name string) (IMChannel, bool) {
+	imChannels.RLock()
+	defer imChannels.RUnlock()
+	channel, ok := imChannels.channels[name]
+	return channel, ok
+}
+
+// IMChannelsSelection returns the selection of the registered instant messaging channels
+func IMChannelsSelection() types.Selection {
+	imChannels.RLock()
+	defer imChannels.RUnlock()
+	res := make(types.Selection)
+	for name, label := range imChannels.labels {
+		res[name] = label
+	}
+	return res
+}
+
+var fields_IMMessage = map[string]models.FieldDefinition{
+	"Channel":     fields.Selection{SelectionFunc: IMChannelsSelection, Required: true, ReadOnly: true, Index: true},
+	"Direction":   fields.Selection{Selection: IMDirections, Required: true, ReadOnly: true},
+	"Phone":       fields.Char{Required: true, Index: true},
+	"Body":        fields.Text{Required: true},
+	"Partner":     fields.Many2One{RelationModel: h.Partner(), Index: true, OnDelete: models.SetNull},
+	"ProviderRef": fields.Char{String: "Provider Reference", ReadOnly: true, Index: true, NoCopy: true},
+	"State": fields.Selection{Selection: IMMessageStates, Required: true, Index: true, ReadOnly: true, NoCopy: true,
+		Default: models.DefaultValue(SMSOutgoing)},
+	"ErrorMessage": fields.Text{ReadOnly: true, NoCopy: true},
+}
+
+// Send hands over these outgoing messages to their channel. Messages that
+// cannot be sent are set in error with the reason of the failure.
+func imMessage_Send(rs m.IMMessageSet) {
+	for _, message := range rs.Records() {
+		if message.Direction() != "outgoing" || message.State() != SMSOutgoing {
+			continue
+		}
+		channel, ok := GetIMChannel(message.Channel())
+		if !ok {
+			message.Write(h.IMMessage().NewData().
+				SetState(SMSError).
+				SetErrorMessage(rs.T("Unknown instant messaging channel '%s'", message.Channel())))
+			continue
+		}
+		ref, err := channel.Send(rs.Env(), basetypes.OutgoingIM{
+			Reference: strconv.FormatInt(message.ID(), 10),
+			Phone:     message.Phone(),
+			Body:      message.Body(),
+		})
+		if err != nil {
+			log.Warn("Unable to send instant message", "message", message.ID(), "channel", message.Channel(), "error", err)
+			message.Write(h.IMMessage().NewData().
+				SetState(SMSError).
+				SetErrorMessage(err.Error()))
+			continue
+		}
+		message.Write(h.IMMessage().NewData().
+			SetState(SMSSent).
+			SetProviderRef(ref).
+			SetErrorMessage(""))
+	}
+}
+
+// Receive records the given messages received on the given channel. The
+// sender of each message is resolved with Partner.FindOrCreateByPhone and
+// ProcessIncoming is then called on the created messages.
+func imMessage_Receive(rs m.IMMessageSet, channel string, messages []basetypes.IncomingIM) m.IMMessageSet {
+	res := h.IMMessage().NewSet(rs.Env())
+	for _, msg := range messages {
+		if strings.TrimSpace(msg.Body) == "" {
+			continue
+		}
+		partner := h.Partner().NewSet(rs.Env()).FindOrCreateByPhone(msg.Phone, msg.SenderName)
+		res = res.Union(h.IMMessage().Create(rs.Env(), h.IMMessage().NewData().
+			SetChannel(channel).
+			SetDirection("incoming").
+			SetPhone(msg.Phone).
+			SetBody(msg.Body).
+			SetPartner(partner).
+			SetProviderRef(msg.ProviderRef).
+			SetState(IMReceived)))
+	}
+	res.ProcessIncoming()
+	return res
+}
+
+// ProcessIncoming is called on newly received messages. It does nothing
+// by default and is meant to be extended by addons, for instance to post
+// the messages in a discussion thread or to answer them automatically.
+func imMessage_ProcessIncoming(_ m.IMMessageSet) {}
+
+// FindOrCreateByPhone returns the partner whose sanitized mobile number
+// matches the given phone number. If none is found, a partner is created
+// with the given name, or the phone number if name is empty. National
+// numbers are interpreted in the country of the current user's company.
+// It returns an empty PartnerSet if the phone number is not valid.
+func partner_FindOrCreateByPhone(rs m.PartnerSet, phone, name string) m.PartnerSet {
+	countryCode := h.User().NewSet(rs.Env()).CurrentUser().Company().Partner().Country().PhoneCode()
+	sanitized := SanitizePhoneNumber(phone, countryCode)
+	if sanitized == "" {
+		return h.Partner().NewSet(rs.Env())
+	}
+	partner := h.Partner().Search(rs.Env(), q.Partner().MobileSanitized().Equals(sanitized)).Limit(1)
+	if partner.IsNotEmpty() {
+		return partner
+	}
+	if strings.TrimSpace(name) == "" {
+		name = sanitized
+	}
+	return h.Partner().Create(rs.Env(), h.Partner().NewData().
+		SetName(name).
+		SetMobile(sanitized))
+}
+
+// SendIM queues an instant message with the given body on the given channel
+// to the mobile number of each of these partners. Partners without a valid
+// mobile number are skipped. It returns the created messages.
+func partner_SendIM(rs m.PartnerSet, channel, body string) m.IMMessageSet {
+	if _, ok := GetIMChannel(channel); !ok {
+		log.Panic(rs.T("Unknown instant messaging channel '%s'", channel))
+	}
+	if strings.TrimSpace(body) == "" {
+		log.Panic(rs.T("Cannot send an empty message"))
+	}
+	res := h.IMMessage().NewSet(rs.Env())
+	for _, partner := range rs.Records() {
+		if partner.MobileSanitized() == "" {
+			log.Warn("Partner has no valid mobile number, skipping instant message", "partner", partner.ID())
+			continue
+		}
+		res = res.Union(h.IMMessage().Create(rs.Env(), h.IMMessage().NewData().
+			SetChannel(channel).
+			SetDirection("outgoing").
+			SetPhone(partner.MobileSanitized()).
+			SetBody(body).
+			SetPartner(partner)))
+	}
+	if res.IsNotEmpty() {
+		res.Enqueue(rs.T("Send instant messages"), h.IMMessage().Methods().Send())
+	}
+	return res
+}
+
+func init() {
+	models.NewModel("IMMessage")
+	h.IMMessage().SetDefaultOrder("ID desc")
+	h.IMMessage().AddFields(fields_IMMessage)
+	h.IMMessage().NewMethod("Send", imMessage_Send)
+	h.IMMessage().NewMethod("Receive", imMessage_Receive)
+	h.IMMessage().NewMethod("ProcessIncoming", imMessage_ProcessIncoming)
+
+	h.Partner().NewMethod("FindOrCreateByPhone", partner_FindOrCreateByPhone)
+	h.Partner().NewMethod("SendIM", partner_SendIM)
+}
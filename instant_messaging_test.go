@@ -0,0 +1,72 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testIMChannel records the messages it is asked to send
+type testIMChannel struct {
+	sent []basetypes.OutgoingIM
+}
+
+// Send records the given message
+func (c *testIMChannel) Send(_ models.Environment, message basetypes.OutgoingIM) (string, error) {
+	c.sent = append(c.sent, message)
+	return "IM-" + message.Reference, nil
+}
+
+// ParseWebhook is not used in tests
+func (c *testIMChannel) ParseWebhook(_ *http.Request) ([]basetypes.IncomingIM, error) {
+	return nil, errors.New("not implemented")
+}
+
+var testIM = new(testIMChannel)
+
+func init() {
+	RegisterIMChannel("test", "Test Messenger", testIM)
+}
+
+func TestInstantMessaging(t *testing.T) {
+	Convey("Testing instant messaging channels", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			testIM.sent = nil
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("IM Contact").
+				SetMobile("+32 470 12 34 56"))
+			Convey("Messages are sent to the partner's mobile number", func() {
+				messages := partner.SendIM("test", "Hello!")
+				So(messages.Len(), ShouldEqual, 1)
+				messages.Send()
+				So(messages.State(), ShouldEqual, SMSSent)
+				So(testIM.sent, ShouldHaveLength, 1)
+				So(testIM.sent[0].Phone, ShouldEqual, "+32470123456")
+				So(func() { partner.SendIM("unknown", "Hello!") }, ShouldPanic)
+			})
+			Convey("Incoming messages are matched to partners by phone", func() {
+				received := h.IMMessage().NewSet(env).Receive("test", []basetypes.IncomingIM{
+					{Phone: "+32470123456", SenderName: "Whoever", Body: "Hi there"},
+					{Phone: "+32 499 99 99 99", SenderName: "New Contact", Body: "Who are you?"},
+				})
+				So(received.Len(), ShouldEqual, 2)
+				records := received.Records()
+				So(records[0].Partner().Equals(partner) || records[1].Partner().Equals(partner), ShouldBeTrue)
+				newContact := h.Partner().NewSet(env).FindOrCreateByPhone("+32499999999", "")
+				So(newContact.Name(), ShouldEqual, "New Contact")
+			})
+			Convey("Invalid phone numbers do not match any partner", func() {
+				So(h.Partner().NewSet(env).FindOrCreateByPhone("not a number", "").IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Priorities of the KanbanMixin, displayed as stars
+const (
+	PriorityNormal    = "0"
+	PriorityImportant = "1"
+	PriorityHigh      = "2"
+	PriorityUrgent    = "3"
+)
+
+// Priorities is the selection of the priorities of the KanbanMixin
+var Priorities = types.Selection{
+	PriorityNormal:    "Normal",
+	PriorityImportant: "Important",
+	PriorityHigh:      "High",
+	PriorityUrgent:    "Urgent",
+}
+
+// Kanban states of the KanbanMixin
+const (
+	KanbanStateNormal  = "normal"
+	KanbanStateBlocked = "blocked"
+	KanbanStateDone    = "done"
+)
+
+// KanbanStates is the selection of the kanban states of the KanbanMixin
+var KanbanStates = types.Selection{
+	KanbanStateNormal:  "In Progress",
+	KanbanStateBlocked: "Blocked",
+	KanbanStateDone:    "Ready",
+}
+
+var fields_KanbanMixin = map[string]models.FieldDefinition{
+	"Priority": fields.Selection{Selection: Priorities, Index: true, NoCopy: true,
+		Default: models.DefaultValue(PriorityNormal)},
+	"KanbanState": fields.Selection{String: "Kanban State", Selection: KanbanStates, Required: true, NoCopy: true,
+		Default: models.DefaultValue(KanbanStateNormal),
+		Help: `In Progress: the record is being processed.
+Blocked: the record cannot be processed further for now.
+Ready: the record is ready for the next step.`},
+}
+
+// TogglePriority switches the priority of each record between normal and
+// important, as when clicking on the star in a kanban card.
+func kanbanMixin_TogglePriority(rs m.KanbanMixinSet) {
+	for _, rec := range rs.Records() {
+		priority := PriorityImportant
+		if rec.Priority() != PriorityNormal && rec.Priority() != "" {
+			priority = PriorityNormal
+		}
+		rec.SetPriority(priority)
+	}
+}
+
+// ToggleBlocked switches the kanban state of each record between blocked and in progress
+func kanbanMixin_ToggleBlocked(rs m.KanbanMixinSet) {
+	for _, rec := range rs.Records() {
+		state := KanbanStateBlocked
+		if rec.KanbanState() == KanbanStateBlocked {
+			state = KanbanStateNormal
+		}
+		rec.SetKanbanState(state)
+	}
+}
+
+// ToggleDone switches the kanban state of each record between ready and in progress
+func kanbanMixin_ToggleDone(rs m.KanbanMixinSet) {
+	for _, rec := range rs.Records() {
+		state := KanbanStateDone
+		if rec.KanbanState() == KanbanStateDone {
+			state = KanbanStateNormal
+		}
+		rec.SetKanbanState(state)
+	}
+}
+
+// ResetKanbanState sets the kanban state of these records back to in progress,
+// typically when they move to another stage.
+func kanbanMixin_ResetKanbanState(rs m.KanbanMixinSet) {
+	rs.SetKanbanState(KanbanStateNormal)
+}
+
+func init() {
+	models.NewMixinModel("KanbanMixin")
+	h.KanbanMixin().AddFields(fields_KanbanMixin)
+	h.KanbanMixin().NewMethod("TogglePriority", kanbanMixin_TogglePriority)
+	h.KanbanMixin().NewMethod("ToggleBlocked", kanbanMixin_ToggleBlocked)
+	h.KanbanMixin().NewMethod("ToggleDone", kanbanMixin_ToggleDone)
+	h.KanbanMixin().NewMethod("ResetKanbanState", kanbanMixin_ResetKanbanState)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKanbanMixin(t *testing.T) {
+	Convey("Testing the kanban mixin", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Kanban Partner"))
+			activity := h.Activity().Create(env, h.Activity().NewData().
+				SetSummary("Kanban activity").
+				SetUser(h.User().NewSet(env).CurrentUser()).
+				SetDateDeadline(dates.Today()).
+				SetResModel("Partner").
+				SetResID(partner.ID()))
+			Convey("Records are created with normal priority and in progress", func() {
+				So(activity.Priority(), ShouldEqual, PriorityNormal)
+				So(activity.KanbanState(), ShouldEqual, KanbanStateNormal)
+			})
+			Convey("Priority toggles between normal and important", func() {
+				activity.TogglePriority()
+				So(activity.Priority(), ShouldEqual, PriorityImportant)
+				activity.TogglePriority()
+				So(activity.Priority(), ShouldEqual, PriorityNormal)
+				activity.SetPriority(PriorityUrgent)
+				activity.TogglePriority()
+				So(activity.Priority(), ShouldEqual, PriorityNormal)
+			})
+			Convey("Kanban state toggles and resets", func() {
+				activity.ToggleBlocked()
+				So(activity.KanbanState(), ShouldEqual, KanbanStateBlocked)
+				activity.ToggleDone()
+				So(activity.KanbanState(), ShouldEqual, KanbanStateDone)
+				activity.ToggleDone()
+				So(activity.KanbanState(), ShouldEqual, KanbanStateNormal)
+				activity.ToggleBlocked()
+				activity.ResetKanbanState()
+				So(activity.KanbanState(), ShouldEqual, KanbanStateNormal)
+			})
+			Convey("Change requests have kanban fields too", func() {
+				request := partner.ProposeChanges(map[string]string{"City": "Paris"}, PartnerChangeSourcePortal, "")
+				So(request.KanbanState(), ShouldEqual, KanbanStateNormal)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -4,10 +4,16 @@
 package base
 
 import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
 	"github.com/erlangs/okoo/src/models"
 	"github.com/erlangs/okoo/src/models/fields"
 	"github.com/erlangs/okoo/src/models/types"
 	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
 )
 
 var fields_Lang = map[string]models.FieldDefinition{
@@ -30,7 +36,90 @@ Provided ',' as the thousand separator in each case.`},
 	"ThousandsSep": fields.Char{String: "Thousands Separator", Default: models.DefaultValue(",")},
 }
 
+// groupDigits inserts sep in the given string of digits according to the given
+// grouping, which has the same semantics as the Grouping field of Lang.
+func groupDigits(digits string, grouping []int, sep string) string {
+	var groups []string
+	last := 0
+	for i := 0; len(digits) > 0; i++ {
+		size := last
+		if i < len(grouping) {
+			size = grouping[i]
+		}
+		switch {
+		case size == -1 || (size == 0 && last == 0):
+			size = len(digits)
+		case size == 0:
+			size = last
+		}
+		if size >= len(digits) {
+			size = len(digits)
+		}
+		groups = append([]string{digits[len(digits)-size:]}, groups...)
+		digits = digits[:len(digits)-size]
+		last = size
+	}
+	return strings.Join(groups, sep)
+}
+
+// FormatNumber returns the given value formatted with the given number of
+// digits according to the conventions of this language. Thousands separators
+// are only inserted if grouping is true.
+//
+// If this LangSet is empty, the default "." decimal separator and "," thousands
+// separator are used.
+func lang_FormatNumber(rs m.LangSet, value float64, digits int, grouping bool) string {
+	decimalPoint, thousandsSep, groupingFormat := ".", ",", "[3,0]"
+	if rs.IsNotEmpty() {
+		rs.EnsureOne()
+		decimalPoint, thousandsSep, groupingFormat = rs.DecimalPoint(), rs.ThousandsSep(), rs.Grouping()
+	}
+	str := strconv.FormatFloat(value, 'f', digits, 64)
+	var sign string
+	if strings.HasPrefix(str, "-") {
+		sign, str = "-", str[1:]
+	}
+	intPart, decPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, decPart = str[:i], str[i+1:]
+	}
+	if grouping {
+		var groups []int
+		if err := json.Unmarshal([]byte(groupingFormat), &groups); err != nil {
+			log.Warn("Invalid grouping format", "lang", rs.Code(), "grouping", groupingFormat, "error", err)
+		}
+		intPart = groupDigits(intPart, groups, thousandsSep)
+	}
+	if decPart != "" {
+		return sign + intPart + decimalPoint + decPart
+	}
+	return sign + intPart
+}
+
+// GetLang returns the Lang with the given code, including inactive ones.
+// It returns an empty LangSet if there is none.
+//
+// The ID of the language is kept in the reference cache.
+func lang_GetLang(rs m.LangSet, code string) m.LangSet {
+	id := CachedReference(rs.Env(), "Lang", code, func() interface{} {
+		return h.Lang().NewSet(rs.Env()).
+			WithContext("active_test", false).
+			Search(q.Lang().Code().Equals(code)).
+			Limit(1).
+			ID()
+	}).(int64)
+	if id == 0 {
+		return h.Lang().NewSet(rs.Env())
+	}
+	return h.Lang().Browse(rs.Env(), []int64{id})
+}
+
 func init() {
 	models.NewModel("Lang")
+	h.Lang().InheritModel(h.ReferenceCacheMixin())
+	h.Lang().InheritModel(h.ReferenceDataMixin())
 	h.Lang().AddFields(fields_Lang)
+
+	h.Lang().NewMethod("FormatNumber", lang_FormatNumber)
+	h.Lang().NewMethod("GetLang", lang_GetLang)
 }
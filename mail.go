@@ -0,0 +1,223 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// A MailSender sends outgoing emails
+type MailSender interface {
+	Send(env models.Environment, email basetypes.OutgoingEmail) error
+}
+
+// SMTPMailSender sends emails through the MailServer given by the
+// mail_server_id context key or, if it is not set, through the SMTP server
+// defined by the mail.smtp_host, mail.smtp_port, mail.smtp_user and
+// mail.smtp_password configuration parameters.
+//
+// Emails are signed with the current DKIMKey of the sender domain, if any.
+type SMTPMailSender struct{}
+
+// smtpSettings returns the host, port, user and password of the SMTP server to use
+func smtpSettings(env models.Environment) (string, string, string, string) {
+	if serverID := ContextGetInteger(env, ContextKeyMailServer); serverID != 0 {
+		server := h.MailServer().BrowseOne(env, serverID).AsSuperUser("read SMTP credentials")
+		return server.Host(), strconv.Itoa(server.Port()), server.User(), server.Password()
+	}
+	params := h.ConfigParameter().NewSet(env).AsSuperUser("read SMTP credentials")
+	return params.GetParam("mail.smtp_host", "localhost"), params.GetParam("mail.smtp_port", "25"),
+		params.GetParam("mail.smtp_user", ""), params.GetParam("mail.smtp_password", "")
+}
+
+// Send the given email through the configured SMTP server
+func (s SMTPMailSender) Send(env models.Environment, email basetypes.OutgoingEmail) error {
+	host, port, user, password := smtpSettings(env)
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, password, host)
+	}
+	from, err := mail.ParseAddress(email.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address '%s': %s", email.From, err)
+	}
+	to := make([]string, len(email.To))
+	for i, recipient := range email.To {
+		addr, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return fmt.Errorf("invalid recipient address '%s': %s", recipient, err)
+		}
+		to[i] = addr.Address
+	}
+	message := dkimSignMessage(env, from.Address, email.Bytes())
+	return smtp.SendMail(fmt.Sprintf("%s:%s", host, port), auth, from.Address, to, message)
+}
+
+var mailSender = struct {
+	sync.RWMutex
+	sender MailSender
+}{
+	sender: SMTPMailSender{},
+}
+
+// SetMailSender replaces the MailSender used by SendEmail and returns the previous one.
+func SetMailSender(sender MailSender) MailSender {
+	mailSender.Lock()
+	defer mailSender.Unlock()
+	previous := mailSender.sender
+	mailSender.sender = sender
+	return previous
+}
+
+// prepareEmail checks that the given email can be sent and returns it with
+// the mail.default_from parameter as sender if it has none.
+func prepareEmail(env models.Environment, email basetypes.OutgoingEmail) (basetypes.OutgoingEmail, error) {
+	if len(email.To) == 0 {
+		return email, fmt.Errorf("email '%s' has no recipient", email.Subject)
+	}
+	if email.From == "" {
+		email.From = h.ConfigParameter().NewSet(env).AsSuperUser("read default sender").GetParam("mail.default_from", "")
+	}
+	if email.From == "" {
+		return email, fmt.Errorf("email '%s' has no sender and mail.default_from is not set", email.Subject)
+	}
+	return email, nil
+}
+
+// SendEmail sends the given email immediately with the current MailSender.
+// If the email has no sender, the mail.default_from parameter is used.
+//
+// Use QueueEmail instead to send emails from user transactions.
+func SendEmail(env models.Environment, email basetypes.OutgoingEmail) error {
+	email, err := prepareEmail(env, email)
+	if err != nil {
+		return err
+	}
+	mailSender.RLock()
+	sender := mailSender.sender
+	mailSender.RUnlock()
+	if err := sender.Send(env, email); err != nil {
+		metricMails.Inc("error")
+		return err
+	}
+	metricMails.Inc("sent")
+	return nil
+}
+
+var fields_MailTemplate = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Translate: true},
+	"Model": fields.Char{String: "Applies To", Constraint: h.MailTemplate().Methods().CheckModel(),
+		Help: "Model of the records this template is rendered for, if any"},
+	"EmailFrom": fields.Char{String: "From",
+		Help: "Sender address template. If empty, the mail.default_from parameter is used."},
+	"EmailTo": fields.Char{String: "To", Help: "Comma-separated recipient addresses template"},
+	"Subject": fields.Char{Translate: true, Constraint: h.MailTemplate().Methods().CheckTemplates()},
+	"BodyHTML": fields.Text{String: "Body", Translate: true, Constraint: h.MailTemplate().Methods().CheckTemplates(),
+		Help: "HTML body of the email, rendered with the template sandbox"},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckModel checks that the model of the template exists
+func mailTemplate_CheckModel(rs m.MailTemplateSet) {
+	for _, tmpl := range rs.Records() {
+		if tmpl.Model() == "" {
+			continue
+		}
+		if _, exists := models.Registry.Get(tmpl.Model()); !exists {
+			log.Panic(rs.T("Unknown model '%s'", tmpl.Model()))
+		}
+	}
+}
+
+// CheckTemplates checks that all the templates of this mail template can be parsed
+func mailTemplate_CheckTemplates(rs m.MailTemplateSet) {
+	for _, tmpl := range rs.Records() {
+		if _, err := DefaultTemplateSandbox.ParseHTML(tmpl.BodyHTML()); err != nil {
+			log.Panic(rs.T("Invalid template in mail template '%s': %s", tmpl.Name(), err))
+		}
+		for _, source := range []string{tmpl.EmailFrom(), tmpl.EmailTo(), tmpl.Subject()} {
+			if _, err := DefaultTemplateSandbox.Parse(source); err != nil {
+				log.Panic(rs.T("Invalid template in mail template '%s': %s", tmpl.Name(), err))
+			}
+		}
+	}
+}
+
+// GenerateEmail renders this template with the given data and returns the
+// resulting email. Templates are rendered in the language of the context.
+// The values interpolated in the HTML body are escaped.
+func mailTemplate_GenerateEmail(rs m.MailTemplateSet, data interface{}) (basetypes.OutgoingEmail, error) {
+	rs.EnsureOne()
+	var res basetypes.OutgoingEmail
+	rendered := make([]string, 4)
+	for i, source := range []string{rs.EmailFrom(), rs.EmailTo(), rs.Subject(), rs.BodyHTML()} {
+		execute := DefaultTemplateSandbox.Execute
+		if i == 3 {
+			execute = DefaultTemplateSandbox.ExecuteHTML
+		}
+		value, err := execute(source, data)
+		if err != nil {
+			return res, fmt.Errorf("unable to render mail template '%s': %s", rs.Name(), err)
+		}
+		rendered[i] = value
+	}
+	res.From = strings.TrimSpace(rendered[0])
+	for _, addr := range strings.Split(rendered[1], ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			res.To = append(res.To, addr)
+		}
+	}
+	res.Subject = strings.TrimSpace(rendered[2])
+	res.Body = rendered[3]
+	return res, nil
+}
+
+// SendMail renders this template with the given data and queues the resulting
+// email to the given recipients in addition to those of the template. The email
+// is sent by the mail queue cron.
+func mailTemplate_SendMail(rs m.MailTemplateSet, data interface{}, recipients ...string) error {
+	email, err := rs.GenerateEmail(data)
+	if err != nil {
+		return err
+	}
+	email.To = append(email.To, recipients...)
+	_, err = QueueEmail(rs.Env(), email)
+	return err
+}
+
+// SendRecordMail renders this template with the given data and queues the
+// resulting email about the given record, like SendMail. Replies to this email
+// are routed back to the record.
+func mailTemplate_SendRecordMail(rs m.MailTemplateSet, model string, resID int64, data interface{}, recipients ...string) error {
+	email, err := rs.GenerateEmail(data)
+	if err != nil {
+		return err
+	}
+	email.To = append(email.To, recipients...)
+	_, err = QueueRecordEmail(rs.Env(), email, model, resID)
+	return err
+}
+
+func init() {
+	models.NewModel("MailTemplate")
+	h.MailTemplate().SetDefaultOrder("Name")
+	h.MailTemplate().AddFields(fields_MailTemplate)
+
+	h.MailTemplate().NewMethod("CheckModel", mailTemplate_CheckModel)
+	h.MailTemplate().NewMethod("CheckTemplates", mailTemplate_CheckTemplates)
+	h.MailTemplate().NewMethod("GenerateEmail", mailTemplate_GenerateEmail)
+	h.MailTemplate().NewMethod("SendMail", mailTemplate_SendMail)
+	h.MailTemplate().NewMethod("SendRecordMail", mailTemplate_SendRecordMail)
+}
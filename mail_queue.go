@@ -0,0 +1,235 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// States of queued mail messages
+const (
+	// MailMessageOutgoing messages are waiting to be sent
+	MailMessageOutgoing = "outgoing"
+	// MailMessageSent messages have been sent
+	MailMessageSent = "sent"
+	// MailMessageException messages could not be sent after MailMaxAttempts attempts
+	MailMessageException = "exception"
+	// MailMessageCancelled messages will not be sent
+	MailMessageCancelled = "cancelled"
+)
+
+// MailMessageStates is the selection of the states of mail messages
+var MailMessageStates = types.Selection{
+	MailMessageOutgoing:  "Outgoing",
+	MailMessageSent:      "Sent",
+	MailMessageException: "Delivery Failed",
+	MailMessageCancelled: "Cancelled",
+}
+
+// MailMaxAttempts is the number of attempts to send a message before it is set in exception
+const MailMaxAttempts = 5
+
+// MailRetryDelay is the delay before retrying to send a message after its first failure.
+// It is multiplied by the number of failed attempts for the next ones.
+const MailRetryDelay = 10 * time.Minute
+
+var fields_MailServer = map[string]models.FieldDefinition{
+	"Name":     fields.Char{Required: true},
+	"Host":     fields.Char{String: "SMTP Server", Required: true},
+	"Port":     fields.Integer{String: "SMTP Port", GoType: new(int), Default: models.DefaultValue(25)},
+	"User":     fields.Char{String: "Username"},
+	"Password": fields.Char{NoCopy: true},
+	"Sequence": fields.Integer{Default: models.DefaultValue(10),
+		Help: "Messages without server are sent through the first active server"},
+	"MaxPerHour": fields.Integer{String: "Hourly Limit", GoType: new(int),
+		Help: "Maximum number of emails sent through this server per hour. Leave 0 for no limit."},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+var fields_MailMessage = map[string]models.FieldDefinition{
+	"Subject":   fields.Char{},
+	"EmailFrom": fields.Char{String: "From", Required: true},
+	"EmailTo":   fields.Text{String: "To", Required: true, Help: "Comma-separated recipient addresses"},
+	"BodyHTML":  fields.Text{String: "Body"},
+	"Headers":   fields.Text{Help: "Additional headers of the email, as a JSON object"},
+	"State": fields.Selection{Selection: MailMessageStates, Required: true, Index: true,
+		Default: models.DefaultValue(MailMessageOutgoing)},
+	"ScheduledDate": fields.DateTime{String: "Scheduled Send Date", Index: true,
+		Default: func(env models.Environment) interface{} {
+			return dates.Now()
+		}, Help: "The message is not sent before this date"},
+	"DateSent": fields.DateTime{String: "Sent On", ReadOnly: true},
+	"MailServer": fields.Many2One{RelationModel: h.MailServer(), OnDelete: models.SetNull,
+		Help: "Server used to send the message. If empty, the first active server is used."},
+	"Attempts":      fields.Integer{GoType: new(int), ReadOnly: true},
+	"FailureReason": fields.Text{ReadOnly: true},
+}
+
+// QueueEmail adds the given email to the outgoing mail queue and returns the
+// created message. The message is sent by the mail queue cron, so that user
+// transactions are not blocked by SMTP calls. If the email has no sender, the
+// mail.default_from parameter is used.
+func QueueEmail(env models.Environment, email basetypes.OutgoingEmail) (m.MailMessageSet, error) {
+	email, err := prepareEmail(env, email)
+	if err != nil {
+		return h.MailMessage().NewSet(env), err
+	}
+	return h.MailMessage().NewSet(env).AsSuperUser("queue outgoing email").Create(h.MailMessage().NewData().
+		SetSubject(email.Subject).
+		SetEmailFrom(email.From).
+		SetEmailTo(strings.Join(email.To, ", ")).
+		SetBodyHTML(email.Body).
+		SetHeaders(marshalHeaders(email.Headers))), nil
+}
+
+// marshalHeaders returns the given email headers as a JSON object, or an
+// empty string if there are none.
+func marshalHeaders(headers map[string]string) string {
+	if len(headers) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(headers)
+	return string(data)
+}
+
+// OutgoingEmail returns the email of this message
+func mailMessage_OutgoingEmail(rs m.MailMessageSet) basetypes.OutgoingEmail {
+	rs.EnsureOne()
+	res := basetypes.OutgoingEmail{
+		From:    rs.EmailFrom(),
+		Subject: rs.Subject(),
+		Body:    rs.BodyHTML(),
+	}
+	for _, addr := range strings.Split(rs.EmailTo(), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			res.To = append(res.To, addr)
+		}
+	}
+	if rs.Headers() != "" {
+		if err := json.Unmarshal([]byte(rs.Headers()), &res.Headers); err != nil {
+			log.Warn("Invalid headers in mail message", "message", rs.ID(), "error", err)
+		}
+	}
+	return res
+}
+
+// Send sends these messages now through their mail server. Failed messages
+// are scheduled again after MailRetryDelay times the number of attempts, and
+// set in exception after MailMaxAttempts attempts.
+func mailMessage_Send(rs m.MailMessageSet) {
+	for _, message := range rs.AsSuperUser("send queued emails").Records() {
+		sendRS := message
+		if message.MailServer().IsNotEmpty() {
+			sendRS = message.WithContext(ContextKeyMailServer, message.MailServer().ID())
+		}
+		err := SendEmail(sendRS.Env(), message.OutgoingEmail())
+		if err == nil {
+			message.Write(h.MailMessage().NewData().
+				SetState(MailMessageSent).
+				SetDateSent(dates.Now()).
+				SetFailureReason(""))
+			continue
+		}
+		log.Warn("Unable to send mail message", "message", message.ID(), "error", err)
+		attempts := message.Attempts() + 1
+		vals := h.MailMessage().NewData().
+			SetAttempts(attempts).
+			SetFailureReason(err.Error())
+		if attempts >= MailMaxAttempts {
+			vals.SetState(MailMessageException)
+		} else {
+			vals.SetScheduledDate(dates.DateTime{Time: dates.Now().Time.Add(time.Duration(attempts) * MailRetryDelay)})
+		}
+		message.Write(vals)
+	}
+}
+
+// RemainingQuota returns the number of emails that can still be sent through
+// this server in the current hour, or -1 if the server has no limit.
+func mailServer_RemainingQuota(rs m.MailServerSet) int {
+	rs.EnsureOne()
+	if rs.MaxPerHour() <= 0 {
+		return -1
+	}
+	sent := h.MailMessage().NewSet(rs.Env()).AsSuperUser("count sent emails").Search(
+		q.MailMessage().MailServer().Equals(rs).
+			And().State().Equals(MailMessageSent).
+			And().DateSent().Greater(dates.DateTime{Time: dates.Now().Time.Add(-time.Hour)})).SearchCount()
+	if sent >= rs.MaxPerHour() {
+		return 0
+	}
+	return rs.MaxPerHour() - sent
+}
+
+// ProcessQueue sends the outgoing messages whose scheduled date is reached,
+// within the hourly limit of their mail server. Messages exceeding the limit
+// are kept in the queue for the next run. Due messages are locked with SKIP
+// LOCKED, so that concurrent runs share the queue instead of sending the same
+// messages. It is called by the base_cron_mail_queue cron.
+func mailMessage_ProcessQueue(rs m.MailMessageSet) {
+	defaultServer := h.MailServer().NewSet(rs.Env()).AsSuperUser("read default mail server").SearchAll().Limit(1)
+	quotas := make(map[int64]int)
+	// Due messages are locked, so that concurrent workers do not send them twice
+	var ids []int64
+	rs.Env().Cr().Select(&ids, `SELECT id FROM mail_message WHERE state = ? AND scheduled_date <= ?
+		ORDER BY scheduled_date, id FOR UPDATE SKIP LOCKED`, MailMessageOutgoing, dates.Now())
+	due := h.MailMessage().Browse(rs.Env(), ids).AsSuperUser("read due emails")
+	for _, message := range due.Records() {
+		server := message.MailServer()
+		if server.IsEmpty() && defaultServer.IsNotEmpty() {
+			server = defaultServer
+			message.SetMailServer(server)
+		}
+		if server.IsNotEmpty() {
+			quota, ok := quotas[server.ID()]
+			if !ok {
+				// Concurrent workers wait for each other to count the quota of a server
+				rs.Env().Cr().Execute(`SELECT id FROM mail_server WHERE id = ? FOR UPDATE`, server.ID())
+				quota = server.RemainingQuota()
+			}
+			if quota == 0 {
+				continue
+			}
+			if quota > 0 {
+				quota--
+			}
+			quotas[server.ID()] = quota
+		}
+		message.Send()
+	}
+}
+
+// ActionRetry puts these messages back in the outgoing queue
+func mailMessage_ActionRetry(rs m.MailMessageSet) {
+	rs.Write(h.MailMessage().NewData().
+		SetState(MailMessageOutgoing).
+		SetAttempts(0).
+		SetScheduledDate(dates.Now()))
+}
+
+func init() {
+	models.NewModel("MailServer")
+	h.MailServer().SetDefaultOrder("Sequence", "ID")
+	h.MailServer().AddFields(fields_MailServer)
+	h.MailServer().NewMethod("RemainingQuota", mailServer_RemainingQuota)
+
+	models.NewModel("MailMessage")
+	h.MailMessage().SetDefaultOrder("ID desc")
+	h.MailMessage().AddFields(fields_MailMessage)
+	h.MailMessage().NewMethod("OutgoingEmail", mailMessage_OutgoingEmail)
+	h.MailMessage().NewMethod("Send", mailMessage_Send)
+	h.MailMessage().NewMethod("ProcessQueue", mailMessage_ProcessQueue)
+	h.MailMessage().NewMethod("ActionRetry", mailMessage_ActionRetry)
+}
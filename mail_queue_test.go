@@ -0,0 +1,101 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// failingMailSender fails to send any email
+type failingMailSender struct{}
+
+// Send returns an error
+func (s failingMailSender) Send(env models.Environment, email basetypes.OutgoingEmail) error {
+	return errors.New("connection refused")
+}
+
+func TestMailQueue(t *testing.T) {
+	Convey("Testing the outgoing mail queue", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			sender := new(testMailSender)
+			previous := SetMailSender(sender)
+			defer SetMailSender(previous)
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			email := basetypes.OutgoingEmail{
+				To:      []string{"first@example.com", "second@example.com"},
+				Subject: "Queued",
+				Body:    "<p>Hello</p>",
+				Headers: map[string]string{"X-Queue": "test"},
+			}
+			Convey("Queued emails are sent by the queue processing", func() {
+				message, err := QueueEmail(env, email)
+				So(err, ShouldBeNil)
+				So(message.State(), ShouldEqual, MailMessageOutgoing)
+				So(message.EmailFrom(), ShouldEqual, "noreply@example.com")
+				So(sender.sent, ShouldBeEmpty)
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(message.State(), ShouldEqual, MailMessageSent)
+				So(message.DateSent().IsZero(), ShouldBeFalse)
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].To, ShouldResemble, email.To)
+				So(sender.sent[0].Headers["X-Queue"], ShouldEqual, "test")
+			})
+			Convey("Scheduled emails are not sent before their date", func() {
+				message, _ := QueueEmail(env, email)
+				message.SetScheduledDate(dates.DateTime{Time: dates.Now().Time.AddDate(0, 0, 1)})
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(message.State(), ShouldEqual, MailMessageOutgoing)
+				So(sender.sent, ShouldBeEmpty)
+			})
+			Convey("Hourly limits of mail servers are enforced", func() {
+				server := h.MailServer().Create(env, h.MailServer().NewData().
+					SetName("Throttled").
+					SetHost("localhost").
+					SetMaxPerHour(1))
+				first, _ := QueueEmail(env, email)
+				second, _ := QueueEmail(env, email)
+				So(server.RemainingQuota(), ShouldEqual, 1)
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(sender.sent, ShouldHaveLength, 1)
+				So(first.MailServer().Equals(server), ShouldBeTrue)
+				So(first.State(), ShouldEqual, MailMessageSent)
+				So(second.State(), ShouldEqual, MailMessageOutgoing)
+				So(server.RemainingQuota(), ShouldEqual, 0)
+				server.SetMaxPerHour(0)
+				So(server.RemainingQuota(), ShouldEqual, -1)
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(second.State(), ShouldEqual, MailMessageSent)
+			})
+			Convey("Failed emails are retried then set in exception", func() {
+				SetMailSender(failingMailSender{})
+				message, _ := QueueEmail(env, email)
+				message.Send()
+				So(message.State(), ShouldEqual, MailMessageOutgoing)
+				So(message.Attempts(), ShouldEqual, 1)
+				So(message.FailureReason(), ShouldContainSubstring, "connection refused")
+				So(dates.Now().Lower(message.ScheduledDate()), ShouldBeTrue)
+				for i := 1; i < MailMaxAttempts; i++ {
+					message.Send()
+				}
+				So(message.State(), ShouldEqual, MailMessageException)
+				So(message.Attempts(), ShouldEqual, MailMaxAttempts)
+				message.ActionRetry()
+				So(message.State(), ShouldEqual, MailMessageOutgoing)
+				So(message.Attempts(), ShouldEqual, 0)
+				SetMailSender(sender)
+				h.MailMessage().NewSet(env).ProcessQueue()
+				So(message.State(), ShouldEqual, MailMessageSent)
+				So(sender.sent, ShouldHaveLength, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/fieldtype"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// mailTemplateRecordData returns the values of the given record to render mail
+// templates, keyed by field name. Relational fields are given by the display
+// names of the related records and binary fields are left out.
+func mailTemplateRecordData(record *models.RecordCollection) map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, fi := range record.Model().FieldsGet() {
+		field := record.Model().FieldName(fi.Name)
+		switch fi.Type {
+		case fieldtype.Binary:
+			continue
+		case fieldtype.Many2One, fieldtype.One2One, fieldtype.Rev2One:
+			value, ok := record.Get(field).(models.RecordSet)
+			if !ok || len(value.Ids()) == 0 {
+				res[fi.Name] = ""
+				continue
+			}
+			res[fi.Name] = value.Collection().Call("NameGet").(string)
+		case fieldtype.One2Many, fieldtype.Many2Many:
+			value, ok := record.Get(field).(models.RecordSet)
+			if !ok {
+				continue
+			}
+			var names []string
+			for _, rec := range value.Collection().Records() {
+				names = append(names, rec.Call("NameGet").(string))
+			}
+			res[fi.Name] = strings.Join(names, ", ")
+		default:
+			res[fi.Name] = record.Get(field)
+		}
+	}
+	return res
+}
+
+// templateFieldNames adds to res the names of the fields of the data given to
+// the template that are used in the given node. Fields used inside range and
+// with blocks, where the data is not the template data, are ignored.
+func templateFieldNames(node parse.Node, res map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFieldNames(child, res)
+		}
+	case *parse.ActionNode:
+		templateFieldNames(n.Pipe, res)
+	case *parse.IfNode:
+		templateFieldNames(n.Pipe, res)
+		templateFieldNames(n.List, res)
+		templateFieldNames(n.ElseList, res)
+	case *parse.RangeNode:
+		templateFieldNames(n.Pipe, res)
+		templateFieldNames(n.ElseList, res)
+	case *parse.WithNode:
+		templateFieldNames(n.Pipe, res)
+		templateFieldNames(n.ElseList, res)
+	case *parse.TemplateNode:
+		templateFieldNames(n.Pipe, res)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateFieldNames(cmd, res)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFieldNames(arg, res)
+		}
+	case *parse.ChainNode:
+		templateFieldNames(n.Node, res)
+	case *parse.FieldNode:
+		res[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			res[n.Ident[1]] = true
+		}
+	}
+}
+
+// unresolvedPlaceholders returns the sorted names of the fields used in the
+// given template source which are not keys of data.
+func unresolvedPlaceholders(source string, data map[string]interface{}) []string {
+	tmpl, err := DefaultTemplateSandbox.Parse(source)
+	if err != nil {
+		return nil
+	}
+	used := make(map[string]bool)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			templateFieldNames(t.Tree.Root, used)
+		}
+	}
+	var res []string
+	for name := range used {
+		if _, ok := data[name]; !ok {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// RenderRecord renders this template for the record of the template model with
+// the given ID and returns the resulting email, as well as warnings about the
+// placeholders of the template that could not be resolved. The record values
+// are given to the template by field name.
+func mailTemplate_RenderRecord(rs m.MailTemplateSet, resID int64) (basetypes.OutgoingEmail, []string, error) {
+	rs.EnsureOne()
+	data := make(map[string]interface{})
+	if rs.Model() != "" {
+		if err := CheckReference(rs.Env(), rs.Model(), resID); err != nil {
+			return basetypes.OutgoingEmail{}, nil, err
+		}
+		data = mailTemplateRecordData(ReferencedRecord(rs.Env(), rs.Model(), resID))
+	}
+	var warnings []string
+	labels := []string{rs.T("From"), rs.T("To"), rs.T("Subject"), rs.T("Body")}
+	for i, source := range []string{rs.EmailFrom(), rs.EmailTo(), rs.Subject(), rs.BodyHTML()} {
+		if missing := unresolvedPlaceholders(source, data); len(missing) > 0 {
+			warnings = append(warnings, rs.T("%s: unresolved placeholders %s", labels[i], strings.Join(missing, ", ")))
+		}
+	}
+	email, err := rs.GenerateEmail(data)
+	if err != nil {
+		return email, warnings, err
+	}
+	if len(email.To) == 0 {
+		warnings = append(warnings, rs.T("The email has no recipient"))
+	}
+	return email, warnings, nil
+}
+
+var fields_MailTemplatePreview = map[string]models.FieldDefinition{
+	"Template": fields.Many2One{RelationModel: h.MailTemplate(), Required: true, OnDelete: models.Cascade,
+		Default: func(env models.Environment) interface{} {
+			return h.MailTemplate().BrowseOne(env, env.Context().GetInteger(ContextKeyActiveID))
+		}},
+	"Model": fields.Char{Related: "Template.Model"},
+	"ResID": fields.Integer{String: "Record ID",
+		Help: "ID of the record of the template model to render the template for"},
+	"Lang": fields.Selection{String: "Language", SelectionFunc: langSelection,
+		Help: "Language in which the template is rendered. If empty, the language of the user is used."},
+	"EmailFrom": fields.Char{String: "From", Compute: h.MailTemplatePreview().Methods().ComputePreview(),
+		Depends: []string{"Template", "ResID", "Lang"}},
+	"EmailTo": fields.Char{String: "To", Compute: h.MailTemplatePreview().Methods().ComputePreview(),
+		Depends: []string{"Template", "ResID", "Lang"}},
+	"Subject": fields.Char{Compute: h.MailTemplatePreview().Methods().ComputePreview(),
+		Depends: []string{"Template", "ResID", "Lang"}},
+	"BodyHTML": fields.Text{String: "Body", Compute: h.MailTemplatePreview().Methods().ComputePreview(),
+		Depends: []string{"Template", "ResID", "Lang"}},
+	"Warnings": fields.Text{Compute: h.MailTemplatePreview().Methods().ComputePreview(),
+		Depends: []string{"Template", "ResID", "Lang"}},
+}
+
+// mailTemplatePreviewRender renders the template of the given preview for its record in its language
+func mailTemplatePreviewRender(rs m.MailTemplatePreviewSet) (basetypes.OutgoingEmail, []string, error) {
+	template := rs.Template()
+	if rs.Lang() != "" {
+		template = template.WithLang(rs.Lang())
+	}
+	return template.RenderRecord(rs.ResID())
+}
+
+// ComputePreview renders the template for the selected record
+func mailTemplatePreview_ComputePreview(rs m.MailTemplatePreviewSet) m.MailTemplatePreviewData {
+	res := h.MailTemplatePreview().NewData()
+	if rs.Template().IsEmpty() {
+		return res
+	}
+	email, warnings, err := mailTemplatePreviewRender(rs)
+	if err != nil {
+		warnings = append(warnings, err.Error())
+	}
+	return res.
+		SetEmailFrom(email.From).
+		SetEmailTo(strings.Join(email.To, ", ")).
+		SetSubject(email.Subject).
+		SetBodyHTML(email.Body).
+		SetWarnings(strings.Join(warnings, "\n"))
+}
+
+// ActionSendTest sends the rendered template to the current user instead of its recipients
+func mailTemplatePreview_ActionSendTest(rs m.MailTemplatePreviewSet) *actions.Action {
+	rs.EnsureOne()
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	if user.Email() == "" {
+		log.Panic(rs.T("Please set your email address in your preferences to receive test emails"))
+	}
+	email, _, err := mailTemplatePreviewRender(rs)
+	if err != nil {
+		log.Panic(rs.T("Unable to render the template: %s", err))
+	}
+	email.To = []string{user.Partner().EmailFormatted()}
+	email.Subject = rs.T("[TEST] %s", email.Subject)
+	if err := SendEmail(rs.Env(), email); err != nil {
+		log.Panic(rs.T("Unable to send the test email: %s", err))
+	}
+	LogEvent(rs.Env(), LogEntry{
+		Level:   LoggingInfo,
+		Logger:  "mail",
+		Message: fmt.Sprintf("Test email of template '%s' sent to %s", rs.Template().Name(), user.Email()),
+		Func:    "MailTemplatePreview.ActionSendTest",
+		Model:   "MailTemplate",
+		ResID:   rs.Template().ID(),
+	})
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+func init() {
+	h.MailTemplate().NewMethod("RenderRecord", mailTemplate_RenderRecord)
+
+	models.NewTransientModel("MailTemplatePreview")
+	h.MailTemplatePreview().AddFields(fields_MailTemplatePreview)
+	h.MailTemplatePreview().NewMethod("ComputePreview", mailTemplatePreview_ComputePreview)
+	h.MailTemplatePreview().NewMethod("ActionSendTest", mailTemplatePreview_ActionSendTest)
+}
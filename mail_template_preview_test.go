@@ -0,0 +1,58 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMailTemplatePreview(t *testing.T) {
+	Convey("Testing mail template preview and test emails", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			sender := new(testMailSender)
+			previous := SetMailSender(sender)
+			defer SetMailSender(previous)
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			admin := h.User().NewSet(env).CurrentUser()
+			admin.SetEmail("admin@example.com")
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Preview Partner").
+				SetEmail("preview@example.com"))
+			tmpl := h.MailTemplate().Create(env, h.MailTemplate().NewData().
+				SetName("Partner Template").
+				SetModel("Partner").
+				SetEmailTo("{{ .Email }}").
+				SetSubject("Hello {{ .Name }}").
+				SetBodyHTML("<p>{{ .Nickname }}</p>"))
+			Convey("Templates are rendered against a record with warnings", func() {
+				email, warnings, err := tmpl.RenderRecord(partner.ID())
+				So(err, ShouldBeNil)
+				So(email.To, ShouldResemble, []string{"preview@example.com"})
+				So(email.Subject, ShouldEqual, "Hello Preview Partner")
+				So(warnings, ShouldHaveLength, 1)
+				So(warnings[0], ShouldContainSubstring, "Nickname")
+				_, _, err = tmpl.RenderRecord(0)
+				So(err, ShouldNotBeNil)
+			})
+			Convey("The preview wizard sends test emails to the current user", func() {
+				preview := h.MailTemplatePreview().Create(env, h.MailTemplatePreview().NewData().
+					SetTemplate(tmpl).
+					SetResID(partner.ID()))
+				So(preview.Subject(), ShouldEqual, "Hello Preview Partner")
+				So(preview.Warnings(), ShouldContainSubstring, "Nickname")
+				preview.ActionSendTest()
+				So(sender.sent, ShouldHaveLength, 1)
+				So(sender.sent[0].To, ShouldHaveLength, 1)
+				So(sender.sent[0].To[0], ShouldContainSubstring, "admin@example.com")
+				So(strings.HasSuffix(sender.sent[0].Subject, "Hello Preview Partner"), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,227 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/google/uuid"
+)
+
+// messageIDRegex matches the message ids of the In-Reply-To and References headers
+var messageIDRegex = regexp.MustCompile(`<[^<>\s]+>`)
+
+// A ThreadHandler processes an incoming reply to an email sent about the
+// given record, e.g. by posting it in the record history.
+type ThreadHandler func(record *models.RecordCollection, msg *mail.Message) error
+
+var threadHandlers = struct {
+	sync.RWMutex
+	handlers map[string]ThreadHandler
+}{
+	handlers: make(map[string]ThreadHandler),
+}
+
+// RegisterThreadHandler registers the handler of the replies to the emails
+// sent about records of the given model. It panics if a handler is already
+// registered for this model.
+func RegisterThreadHandler(model string, handler ThreadHandler) {
+	threadHandlers.Lock()
+	defer threadHandlers.Unlock()
+	if _, exists := threadHandlers.handlers[model]; exists {
+		log.Panic("Thread handler already registered", "model", model)
+	}
+	threadHandlers.handlers[model] = handler
+}
+
+// GetThreadHandler returns the handler registered for the given model
+func GetThreadHandler(model string) (ThreadHandler, bool) {
+	threadHandlers.RLock()
+	defer threadHandlers.RUnlock()
+	handler, ok := threadHandlers.handlers[model]
+	return handler, ok
+}
+
+var fields_MailMessageThread = map[string]models.FieldDefinition{
+	"MessageID": fields.Char{String: "Message-Id", Index: true, ReadOnly: true, NoCopy: true},
+	"References": fields.Text{ReadOnly: true, NoCopy: true,
+		Help: "Message ids of the previous emails of the thread"},
+	"ResModel": fields.Char{String: "Related Model", Index: true, ReadOnly: true},
+	"ResID":    fields.Integer{String: "Related Record ID", Index: true, ReadOnly: true},
+}
+
+// replySignature returns the signature of the reply address of the given message
+func replySignature(env models.Environment, messageID int64) string {
+	mac := hmac.New(sha256.New, []byte(databaseSecret(env)))
+	fmt.Fprintf(mac, "reply:%d", messageID)
+	return hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// replyAddress returns the encoded reply address of the message with the
+// given ID for the given company, i.e.
+// <catchall>+<message id>-<signature>@<alias domain>. It returns an empty
+// string if the company has no catchall address.
+func replyAddress(env models.Environment, company m.CompanySet, messageID int64) string {
+	if company.IsEmpty() || company.CatchallEmail() == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s+%d-%s@%s", company.CatchallAlias(), messageID, replySignature(env, messageID), company.AliasDomain())
+}
+
+// ReplyAddress returns the encoded address to which replies to this message
+// must be sent so that they are routed to its record, or an empty string if
+// the company of the current user has no catchall address.
+func mailMessage_ReplyAddress(rs m.MailMessageSet) string {
+	rs.EnsureOne()
+	return replyAddress(rs.Env(), h.User().NewSet(rs.Env()).CurrentUser().Company(), rs.ID())
+}
+
+// MessageForReplyAddress returns the message whose encoded reply address is
+// the given address, or an empty set if the address is not a valid reply
+// address.
+func MessageForReplyAddress(env models.Environment, address string) m.MailMessageSet {
+	res := h.MailMessage().NewSet(env)
+	local, _ := splitEmailAddress(address)
+	plus := strings.LastIndex(local, "+")
+	dash := strings.LastIndex(local, "-")
+	if plus < 0 || dash < plus {
+		return res
+	}
+	id, err := strconv.ParseInt(local[plus+1:dash], 10, 64)
+	if err != nil || !hmac.Equal([]byte(local[dash+1:]), []byte(replySignature(env, id))) {
+		return res
+	}
+	return res.AsSuperUser("resolve mail thread").Search(q.MailMessage().ID().Equals(id))
+}
+
+// QueueRecordEmail adds the given email about the given record to the
+// outgoing mail queue, like QueueEmail. The email gets a Message-Id and
+// references the previous emails sent about the record, so that mail
+// clients display them as a thread. Its Reply-To is set to the encoded reply
+// address of the message, so that replies are routed back to the record by
+// RouteReplyMessage.
+func QueueRecordEmail(env models.Environment, email basetypes.OutgoingEmail, model string, resID int64) (m.MailMessageSet, error) {
+	company := h.User().NewSet(env).CurrentUser().Company()
+	message, err := QueueEmail(env, email)
+	if err != nil {
+		return message, err
+	}
+	_, domain := splitEmailAddress(message.EmailFrom())
+	if domain == "" {
+		domain = "localhost"
+	}
+	messageID := fmt.Sprintf("<%s.%d@%s>", uuid.New().String(), message.ID(), domain)
+	var references []string
+	previous := h.MailMessage().NewSet(env).AsSuperUser("resolve mail thread").Search(
+		q.MailMessage().ResModel().Equals(model).
+			And().ResID().Equals(resID).
+			And().ID().NotEquals(message.ID()).
+			And().MessageID().IsNotNull()).
+		OrderBy("ID desc").Limit(1)
+	if previous.IsNotEmpty() {
+		references = append(strings.Fields(previous.References()), previous.MessageID())
+	}
+	outgoing := message.OutgoingEmail()
+	if outgoing.Headers == nil {
+		outgoing.Headers = make(map[string]string)
+	}
+	outgoing.Headers["Message-Id"] = messageID
+	if len(references) > 0 {
+		outgoing.Headers["In-Reply-To"] = references[len(references)-1]
+		outgoing.Headers["References"] = strings.Join(references, " ")
+	}
+	if _, exists := outgoing.Headers["Reply-To"]; !exists {
+		if replyTo := replyAddress(env, company, message.ID()); replyTo != "" {
+			outgoing.Headers["Reply-To"] = replyTo
+		}
+	}
+	message.Write(h.MailMessage().NewData().
+		SetMessageID(messageID).
+		SetReferences(strings.Join(references, " ")).
+		SetResModel(model).
+		SetResID(resID).
+		SetHeaders(marshalHeaders(outgoing.Headers)))
+	return message, nil
+}
+
+// RouteReplyMessage is meant to be called by the inbound mail gateway for
+// each incoming email, before routing it to aliases. If msg is a reply to an
+// email sent about a record, either through the encoded reply address or
+// through its In-Reply-To and References headers, it is processed by the
+// ThreadHandler of the record model and RouteReplyMessage returns true.
+// Replies to records without handler are recorded in the application log of
+// the record. It returns false if msg is not a reply.
+func RouteReplyMessage(env models.Environment, msg *mail.Message) (bool, error) {
+	original := replyOriginalMessage(env, msg)
+	if original.IsEmpty() || original.ResModel() == "" {
+		return false, nil
+	}
+	record := ReferencedRecord(env, original.ResModel(), original.ResID())
+	if record == nil || record.IsEmpty() {
+		return true, fmt.Errorf("record %s(%d) of the replied message does not exist anymore",
+			original.ResModel(), original.ResID())
+	}
+	if handler, ok := GetThreadHandler(original.ResModel()); ok {
+		return true, handler(record, msg)
+	}
+	LogEvent(env, LogEntry{
+		Level:   LoggingInfo,
+		Logger:  "mail.gateway",
+		Message: fmt.Sprintf("Reply from %s: %s", msg.Header.Get("From"), decodeHeader(msg.Header.Get("Subject"))),
+		Func:    "RouteReplyMessage",
+		Model:   original.ResModel(),
+		ResID:   original.ResID(),
+	})
+	return true, nil
+}
+
+// decodeHeader returns the given header value with its RFC 2047 encoded words decoded
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// replyOriginalMessage returns the message that msg replies to, or an empty set
+func replyOriginalMessage(env models.Environment, msg *mail.Message) m.MailMessageSet {
+	for _, header := range []string{"To", "Cc", "Delivered-To"} {
+		addresses, err := msg.Header.AddressList(header)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addresses {
+			if original := MessageForReplyAddress(env, addr.Address); original.IsNotEmpty() {
+				return original
+			}
+		}
+	}
+	ids := messageIDRegex.FindAllString(msg.Header.Get("In-Reply-To")+" "+msg.Header.Get("References"), -1)
+	if len(ids) == 0 {
+		return h.MailMessage().NewSet(env)
+	}
+	return h.MailMessage().NewSet(env).AsSuperUser("resolve mail thread").Search(q.MailMessage().MessageID().In(ids)).
+		OrderBy("ID desc").Limit(1)
+}
+
+func init() {
+	h.MailMessage().AddFields(fields_MailMessageThread)
+	h.MailMessage().NewMethod("ReplyAddress", mailMessage_ReplyAddress)
+}
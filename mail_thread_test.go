@@ -0,0 +1,94 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMailThread(t *testing.T) {
+	Convey("Testing email reply threading", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			company := h.User().NewSet(env).CurrentUser().Company()
+			company.SetAliasDomain("example.com")
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Threaded Partner"))
+			email := basetypes.OutgoingEmail{
+				To:      []string{"customer@example.org"},
+				Subject: "Notification",
+				Body:    "Hello",
+			}
+			first, err := QueueRecordEmail(env, email, "Partner", partner.ID())
+			So(err, ShouldBeNil)
+			second, err := QueueRecordEmail(env, email, "Partner", partner.ID())
+			So(err, ShouldBeNil)
+			reply := func(headers string) *mail.Message {
+				msg, err := mail.ReadMessage(strings.NewReader(headers + "From: customer@example.org\r\nSubject: Re: Notification\r\n\r\nThanks"))
+				So(err, ShouldBeNil)
+				return msg
+			}
+			Convey("Queued emails are threaded", func() {
+				So(first.ResModel(), ShouldEqual, "Partner")
+				So(first.ResID(), ShouldEqual, partner.ID())
+				So(first.MessageID(), ShouldEndWith, "@example.com>")
+				So(first.References(), ShouldBeEmpty)
+				So(second.References(), ShouldEqual, first.MessageID())
+				headers := second.OutgoingEmail().Headers
+				So(headers["Message-Id"], ShouldEqual, second.MessageID())
+				So(headers["In-Reply-To"], ShouldEqual, first.MessageID())
+				So(headers["Reply-To"], ShouldStartWith, fmt.Sprintf("catchall+%d-", second.ID()))
+				So(headers["Reply-To"], ShouldEndWith, "@example.com")
+			})
+			Convey("Reply addresses are decoded and checked", func() {
+				replyTo := second.OutgoingEmail().Headers["Reply-To"]
+				So(MessageForReplyAddress(env, replyTo).Equals(second), ShouldBeTrue)
+				So(MessageForReplyAddress(env, strings.ToUpper(replyTo)).Equals(second), ShouldBeTrue)
+				So(MessageForReplyAddress(env, fmt.Sprintf("catchall+%d-000000000000@example.com", second.ID())).IsEmpty(), ShouldBeTrue)
+				So(MessageForReplyAddress(env, "catchall@example.com").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Replies are routed to the thread handler of the record model", func() {
+				var routed []int64
+				RegisterThreadHandler("Partner", func(record *models.RecordCollection, msg *mail.Message) error {
+					routed = append(routed, record.Ids()...)
+					return nil
+				})
+				defer func() {
+					threadHandlers.Lock()
+					delete(threadHandlers.handlers, "Partner")
+					threadHandlers.Unlock()
+				}()
+				ok, err := RouteReplyMessage(env, reply("To: "+second.OutgoingEmail().Headers["Reply-To"]+"\r\n"))
+				So(ok, ShouldBeTrue)
+				So(err, ShouldBeNil)
+				ok, err = RouteReplyMessage(env, reply("To: sales@example.com\r\nIn-Reply-To: "+first.MessageID()+"\r\n"))
+				So(ok, ShouldBeTrue)
+				So(err, ShouldBeNil)
+				So(routed, ShouldResemble, []int64{partner.ID(), partner.ID()})
+				ok, err = RouteReplyMessage(env, reply("To: sales@example.com\r\nIn-Reply-To: <unknown@example.com>\r\n"))
+				So(ok, ShouldBeFalse)
+				So(err, ShouldBeNil)
+			})
+			Convey("Replies to records without handler are logged on the record", func() {
+				ok, err := RouteReplyMessage(env, reply("To: sales@example.com\r\nReferences: <x@y> "+first.MessageID()+"\r\n"))
+				So(ok, ShouldBeTrue)
+				So(err, ShouldBeNil)
+				logs := h.Logging().Search(env, q.Logging().Model().Equals("Partner").
+					And().ResID().Equals(partner.ID()).
+					And().Logger().Equals("mail.gateway"))
+				So(logs.Len(), ShouldEqual, 1)
+				So(logs.Message(), ShouldContainSubstring, "Re: Notification")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+)
+
+// Kinds of method parameters and return values
+const (
+	// MethodValueRecordSet is a recordset of the model given in the Model field
+	MethodValueRecordSet = "recordset"
+	// MethodValueData is a record data of the model given in the Model field
+	MethodValueData = "data"
+	// MethodValueOther is any other Go value
+	MethodValueOther = "value"
+)
+
+var (
+	recordSetType  = reflect.TypeOf((*models.RecordSet)(nil)).Elem()
+	recordDataType = reflect.TypeOf((*models.RecordData)(nil)).Elem()
+)
+
+// A MethodValue describes the type of a parameter or of a return value of a model method
+type MethodValue struct {
+	Type     string `json:"type"`
+	Kind     string `json:"kind"`
+	Model    string `json:"model,omitempty"`
+	Variadic bool   `json:"variadic,omitempty"`
+}
+
+// A MethodSignature describes a model method, as served to API clients and
+// code generators. Parameters do not include the recordset the method is
+// called on.
+type MethodSignature struct {
+	Name    string        `json:"name"`
+	Params  []MethodValue `json:"params"`
+	Returns []MethodValue `json:"returns"`
+}
+
+// ModelMethodSignatures returns the signatures of the methods of the given
+// model that the current user of env is allowed to execute, sorted by name.
+// Types are those of the pool layer, e.g. m.PartnerSet for a recordset of
+// partners. The second returned value is false if the model does not exist.
+func ModelMethodSignatures(env models.Environment, model string) ([]MethodSignature, bool) {
+	mi, exists := models.Registry.Get(model)
+	if !exists {
+		return nil, false
+	}
+	rc := env.Pool(model)
+	wrapped := reflect.TypeOf(rc.Wrap())
+	res := []MethodSignature{}
+	for i := 0; i < wrapped.NumMethod(); i++ {
+		name := wrapped.Method(i).Name
+		meth, ok := mi.Methods().Get(name)
+		if !ok || !rc.CheckExecutionPermission(meth.Underlying(), true) {
+			continue
+		}
+		res = append(res, methodSignature(name, meth.MethodType()))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res, true
+}
+
+// methodSignature returns the signature of the method with the given name and
+// function type, whose first parameter is the recordset the method is called on.
+func methodSignature(name string, fnType reflect.Type) MethodSignature {
+	res := MethodSignature{
+		Name:    name,
+		Params:  []MethodValue{},
+		Returns: []MethodValue{},
+	}
+	for i := 1; i < fnType.NumIn(); i++ {
+		typ := fnType.In(i)
+		variadic := fnType.IsVariadic() && i == fnType.NumIn()-1
+		if variadic {
+			typ = typ.Elem()
+		}
+		param := methodValue(typ)
+		param.Variadic = variadic
+		res.Params = append(res.Params, param)
+	}
+	for i := 0; i < fnType.NumOut(); i++ {
+		res.Returns = append(res.Returns, methodValue(fnType.Out(i)))
+	}
+	return res
+}
+
+// methodValue returns the description of the given parameter or return type.
+// The model of recordset and data types is derived from their pool type name,
+// e.g. Partner for m.PartnerSet and m.PartnerData.
+func methodValue(typ reflect.Type) MethodValue {
+	res := MethodValue{
+		Type: typ.String(),
+		Kind: MethodValueOther,
+	}
+	switch {
+	case typ.Implements(recordSetType):
+		res.Kind = MethodValueRecordSet
+		res.Model = strings.TrimSuffix(typ.Name(), "Set")
+	case typ.Implements(recordDataType):
+		res.Kind = MethodValueData
+		res.Model = strings.TrimSuffix(typ.Name(), "Data")
+	}
+	if _, exists := models.Registry.Get(res.Model); !exists {
+		res.Model = ""
+	}
+	return res
+}
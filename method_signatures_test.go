@@ -0,0 +1,58 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMethodSignatures(t *testing.T) {
+	Convey("Testing method signatures", t, func() {
+		Convey("Signatures are derived from the pool layer types", func() {
+			sig := methodSignature("AddressGet", reflect.TypeOf(partner_AddressGet))
+			So(sig.Params, ShouldHaveLength, 1)
+			So(sig.Params[0].Type, ShouldEqual, "[]string")
+			So(sig.Params[0].Kind, ShouldEqual, MethodValueOther)
+			So(sig.Returns, ShouldHaveLength, 1)
+			So(sig.Returns[0].Type, ShouldEqual, "map[string]m.PartnerSet")
+			sig = methodSignature("CreateCompany", reflect.TypeOf(partner_CreateCompany))
+			So(sig.Params, ShouldBeEmpty)
+			So(sig.Returns[0].Type, ShouldEqual, "bool")
+		})
+		Convey("Recordset and data parameters reference their model", func() {
+			sig := methodSignature("IsTargeting", reflect.TypeOf(announcement_IsTargeting))
+			So(sig.Params[0].Kind, ShouldEqual, MethodValueRecordSet)
+			So(sig.Params[0].Model, ShouldEqual, "User")
+			sig = methodSignature("ReflectFields", reflect.TypeOf(model_ReflectFields))
+			So(sig.Params[0].Kind, ShouldEqual, MethodValueOther)
+			So(sig.Params[0].Model, ShouldBeEmpty)
+		})
+		Convey("Variadic parameters are flagged", func() {
+			sig := methodSignature("Load", reflect.TypeOf(attachment_Load))
+			So(sig.Params, ShouldHaveLength, 1)
+			So(sig.Params[0].Variadic, ShouldBeTrue)
+			So(sig.Params[0].Type, ShouldEqual, "models.FieldName")
+		})
+		Convey("Model methods are listed by name", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				sigs, ok := ModelMethodSignatures(env, "Partner")
+				So(ok, ShouldBeTrue)
+				names := make(map[string]bool)
+				for _, sig := range sigs {
+					names[sig.Name] = true
+				}
+				So(names["AddressGet"], ShouldBeTrue)
+				So(names["CreateCompany"], ShouldBeTrue)
+				So(names["Call"], ShouldBeFalse)
+				_, ok = ModelMethodSignatures(env, "NonExistentModel")
+				So(ok, ShouldBeFalse)
+			}), ShouldBeNil)
+		})
+	})
+}
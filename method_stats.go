@@ -0,0 +1,271 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/spf13/viper"
+)
+
+// A MethodStat holds the statistics of the calls of a model method since the
+// server started or since the last call to ResetMethodStats.
+//
+// Durations include the time spent in nested method calls.
+type MethodStat struct {
+	Model  string
+	Method string
+	Calls  int64
+	// Errors is the number of calls that panicked
+	Errors int64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// Average returns the average duration of the calls of this method
+func (s MethodStat) Average() time.Duration {
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Calls)
+}
+
+// methodStatsEnabled is 1 when method statistics are recorded
+var methodStatsEnabled int32
+
+type methodStatKey struct {
+	model  string
+	method string
+}
+
+var methodStats = struct {
+	sync.Mutex
+	stats map[methodStatKey]*MethodStat
+}{
+	stats: make(map[methodStatKey]*MethodStat),
+}
+
+// EnableMethodStats starts or stops recording method statistics. Statistics
+// are disabled by default and enabled at startup if the Profiling.Methods
+// configuration key is set.
+func EnableMethodStats(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&methodStatsEnabled, value)
+}
+
+// MethodStatsEnabled returns true if method statistics are being recorded
+func MethodStatsEnabled() bool {
+	return atomic.LoadInt32(&methodStatsEnabled) == 1
+}
+
+// TrackMethod starts measuring a call to the given method of the model of rs
+// and returns the function recording it, to be deferred at the beginning of
+// the method:
+//
+//	defer TrackMethod(rs, "FieldsSync")()
+//
+// Base instruments Create, Write, Unlink, Load and NameGet of all models as
+// well as Partner.FieldsSync. Modules can track their own hotspots the same way.
+func TrackMethod(rs models.RecordSet, method string) func() {
+	if !MethodStatsEnabled() {
+		return func() {}
+	}
+	model := rs.ModelName()
+	start := time.Now()
+	return func() {
+		r := recover()
+		recordMethodCall(model, method, time.Since(start), r != nil)
+		if r != nil {
+			panic(r)
+		}
+	}
+}
+
+// recordMethodCall adds a call of the given duration to the statistics of the given method
+func recordMethodCall(model, method string, duration time.Duration, failed bool) {
+	methodStats.Lock()
+	defer methodStats.Unlock()
+	key := methodStatKey{model: model, method: method}
+	stat, ok := methodStats.stats[key]
+	if !ok {
+		stat = &MethodStat{Model: model, Method: method}
+		methodStats.stats[key] = stat
+	}
+	stat.Calls++
+	if failed {
+		stat.Errors++
+	}
+	stat.Total += duration
+	if duration > stat.Max {
+		stat.Max = duration
+	}
+}
+
+// MethodStats returns the statistics of all the tracked methods, the most
+// time consuming first.
+func MethodStats() []MethodStat {
+	methodStats.Lock()
+	res := make([]MethodStat, 0, len(methodStats.stats))
+	for _, stat := range methodStats.stats {
+		res = append(res, *stat)
+	}
+	methodStats.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Total == res[j].Total {
+			return res[i].Model+"."+res[i].Method < res[j].Model+"."+res[j].Method
+		}
+		return res[i].Total > res[j].Total
+	})
+	return res
+}
+
+// ResetMethodStats clears the recorded method statistics
+func ResetMethodStats() {
+	methodStats.Lock()
+	defer methodStats.Unlock()
+	methodStats.stats = make(map[methodStatKey]*MethodStat)
+}
+
+// writeMethodStatsMetrics writes the given method statistics to w in the
+// Prometheus text exposition format.
+func writeMethodStatsMetrics(w io.Writer, stats []MethodStat) {
+	metrics := []struct {
+		name, typ, help string
+		value           func(MethodStat) string
+	}{
+		{"hexya_method_calls_total", "counter", "Number of calls of model methods",
+			func(s MethodStat) string { return fmt.Sprintf("%d", s.Calls) }},
+		{"hexya_method_errors_total", "counter", "Number of calls of model methods that panicked",
+			func(s MethodStat) string { return fmt.Sprintf("%d", s.Errors) }},
+		{"hexya_method_duration_seconds_total", "counter", "Total time spent in model methods",
+			func(s MethodStat) string { return fmt.Sprintf("%g", s.Total.Seconds()) }},
+		{"hexya_method_duration_seconds_max", "gauge", "Longest call of model methods",
+			func(s MethodStat) string { return fmt.Sprintf("%g", s.Max.Seconds()) }},
+	}
+	for _, metric := range metrics {
+		writeMetricHeader(w, metric.name, metric.typ, metric.help)
+		for _, stat := range stats {
+			fmt.Fprintf(w, "%s{model=%q,method=%q} %s\n", metric.name, stat.Model, stat.Method, metric.value(stat))
+		}
+	}
+}
+
+// configureMethodStats enables method statistics if the Profiling.Methods
+// configuration key is set.
+func configureMethodStats() {
+	if viper.GetBool("Profiling.Methods") {
+		log.Info("Recording model method statistics")
+		EnableMethodStats(true)
+	}
+}
+
+var fields_MethodStats = map[string]models.FieldDefinition{
+	"Model":           fields.Char{Required: true, ReadOnly: true, Index: true},
+	"Method":          fields.Char{Required: true, ReadOnly: true},
+	"Calls":           fields.Integer{ReadOnly: true},
+	"Errors":          fields.Integer{ReadOnly: true},
+	"TotalDuration":   fields.Float{String: "Total Time (ms)", ReadOnly: true},
+	"AverageDuration": fields.Float{String: "Average Time (ms)", ReadOnly: true},
+	"MaxDuration":     fields.Float{String: "Max Time (ms)", ReadOnly: true},
+}
+
+// milliseconds returns the given duration in milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ActionRefresh replaces all MethodStats records by the statistics recorded
+// in this process and returns the action to display them.
+func methodStats_ActionRefresh(rs m.MethodStatsSet) *actions.Action {
+	stats := MethodStats()
+	h.MethodStats().NewSet(rs.Env()).SearchAll().Unlink()
+	for _, stat := range stats {
+		h.MethodStats().Create(rs.Env(), h.MethodStats().NewData().
+			SetModel(stat.Model).
+			SetMethod(stat.Method).
+			SetCalls(stat.Calls).
+			SetErrors(stat.Errors).
+			SetTotalDuration(milliseconds(stat.Total)).
+			SetAverageDuration(milliseconds(stat.Average())).
+			SetMaxDuration(milliseconds(stat.Max)))
+	}
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Name:     rs.T("Method Statistics"),
+		Model:    "MethodStats",
+		ViewMode: "tree",
+	}
+}
+
+// ActionReset clears the statistics recorded in this process and all MethodStats records
+func methodStats_ActionReset(rs m.MethodStatsSet) *actions.Action {
+	ResetMethodStats()
+	h.MethodStats().NewSet(rs.Env()).SearchAll().Unlink()
+	return &actions.Action{
+		Type: actions.ActionClient,
+		Tag:  "reload",
+	}
+}
+
+func baseMixin_CreateStats(rs m.BaseMixinSet, data m.BaseMixinData) m.BaseMixinSet {
+	defer TrackMethod(rs, "Create")()
+	return rs.Super().Create(data)
+}
+
+func baseMixin_WriteStats(rs m.BaseMixinSet, data m.BaseMixinData) bool {
+	defer TrackMethod(rs, "Write")()
+	return rs.Super().Write(data)
+}
+
+func baseMixin_UnlinkStats(rs m.BaseMixinSet) int64 {
+	defer TrackMethod(rs, "Unlink")()
+	return rs.Super().Unlink()
+}
+
+func baseMixin_LoadStats(rs m.BaseMixinSet, fields ...models.FieldName) m.BaseMixinSet {
+	defer TrackMethod(rs, "Load")()
+	return rs.Super().Load(fields...)
+}
+
+func baseMixin_NameGetStats(rs m.BaseMixinSet) string {
+	defer TrackMethod(rs, "NameGet")()
+	return rs.Super().NameGet()
+}
+
+func partner_FieldsSyncStats(rs m.PartnerSet, vals m.PartnerData) {
+	defer TrackMethod(rs, "FieldsSync")()
+	rs.Super().FieldsSync(vals)
+}
+
+func init() {
+	RegisterMetricsCollector("methods", func(_ models.Environment, w io.Writer) {
+		writeMethodStatsMetrics(w, MethodStats())
+	})
+
+	models.NewModel("MethodStats")
+	h.MethodStats().SetDefaultOrder("TotalDuration desc")
+	h.MethodStats().AddFields(fields_MethodStats)
+	h.MethodStats().NewMethod("ActionRefresh", methodStats_ActionRefresh)
+	h.MethodStats().NewMethod("ActionReset", methodStats_ActionReset)
+
+	h.BaseMixin().Methods().Create().Extend(baseMixin_CreateStats)
+	h.BaseMixin().Methods().Write().Extend(baseMixin_WriteStats)
+	h.BaseMixin().Methods().Unlink().Extend(baseMixin_UnlinkStats)
+	h.BaseMixin().Methods().Load().Extend(baseMixin_LoadStats)
+	h.BaseMixin().Methods().NameGet().Extend(baseMixin_NameGetStats)
+	h.Partner().Methods().FieldsSync().Extend(partner_FieldsSyncStats)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// findMethodStat returns the statistics of the given method, if recorded
+func findMethodStat(model, method string) (MethodStat, bool) {
+	for _, stat := range MethodStats() {
+		if stat.Model == model && stat.Method == method {
+			return stat, true
+		}
+	}
+	return MethodStat{}, false
+}
+
+func TestMethodStats(t *testing.T) {
+	Convey("Testing method statistics", t, func() {
+		ResetMethodStats()
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Nothing is recorded when statistics are disabled", func() {
+				h.Partner().Create(env, h.Partner().NewData().SetName("Untracked"))
+				_, ok := findMethodStat("Partner", "Create")
+				So(ok, ShouldBeFalse)
+			})
+			Convey("Calls are recorded when statistics are enabled", func() {
+				EnableMethodStats(true)
+				defer EnableMethodStats(false)
+				h.Partner().Create(env, h.Partner().NewData().SetName("Tracked 1"))
+				h.Partner().Create(env, h.Partner().NewData().SetName("Tracked 2"))
+				stat, ok := findMethodStat("Partner", "Create")
+				So(ok, ShouldBeTrue)
+				So(stat.Calls, ShouldEqual, 2)
+				So(stat.Errors, ShouldEqual, 0)
+				So(stat.Max, ShouldBeLessThanOrEqualTo, stat.Total)
+				_, ok = findMethodStat("Partner", "FieldsSync")
+				So(ok, ShouldBeTrue)
+				Convey("Statistics are exposed as MethodStats records and Prometheus metrics", func() {
+					EnableMethodStats(false)
+					h.MethodStats().NewSet(env).ActionRefresh()
+					So(h.MethodStats().NewSet(env).SearchAll().Len(), ShouldEqual, len(MethodStats()))
+					var b strings.Builder
+					writeMethodStatsMetrics(&b, MethodStats())
+					So(b.String(), ShouldContainSubstring,
+						`hexya_method_calls_total{model="Partner",method="Create"} 2`)
+				})
+			})
+			Convey("Panicking calls are recorded as errors", func() {
+				EnableMethodStats(true)
+				defer EnableMethodStats(false)
+				partner := h.Partner().Create(env, h.Partner().NewData().SetName("Failing"))
+				So(func() {
+					defer TrackMethod(partner, "Failing")()
+					panic("failure")
+				}, ShouldPanicWith, "failure")
+				stat, _ := findMethodStat("Partner", "Failing")
+				So(stat.Errors, ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+		ResetMethodStats()
+	})
+}
+
+func TestMethodStatAverage(t *testing.T) {
+	Convey("Average durations", t, func() {
+		So(MethodStat{}.Average(), ShouldEqual, 0)
+		So(MethodStat{Calls: 4, Total: 10 * time.Millisecond}.Average(), ShouldEqual, 2500*time.Microsecond)
+	})
+}
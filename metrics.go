@@ -0,0 +1,197 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/server"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	"github.com/spf13/viper"
+)
+
+// A MetricsCollector writes metrics to w in the Prometheus text exposition
+// format. Collectors are called with a superuser environment each time the
+// /metrics URL is scraped.
+type MetricsCollector func(env models.Environment, w io.Writer)
+
+var metricsCollectors = struct {
+	sync.RWMutex
+	names      []string
+	collectors map[string]MetricsCollector
+}{
+	collectors: make(map[string]MetricsCollector),
+}
+
+// RegisterMetricsCollector adds the given collector to the /metrics endpoint.
+// Collectors are called in the order of their registration. It panics if a
+// collector with the same name is already registered.
+func RegisterMetricsCollector(name string, collector MetricsCollector) {
+	metricsCollectors.Lock()
+	defer metricsCollectors.Unlock()
+	if _, exists := metricsCollectors.collectors[name]; exists {
+		log.Panic("Metrics collector already registered", "name", name)
+	}
+	metricsCollectors.names = append(metricsCollectors.names, name)
+	metricsCollectors.collectors[name] = collector
+}
+
+// CollectMetrics writes the metrics of all registered collectors to w.
+// Collectors that panic are skipped and reported in the log.
+func CollectMetrics(env models.Environment, w io.Writer) {
+	metricsCollectors.RLock()
+	names := make([]string, len(metricsCollectors.names))
+	copy(names, metricsCollectors.names)
+	collectors := make([]MetricsCollector, len(names))
+	for i, name := range names {
+		collectors[i] = metricsCollectors.collectors[name]
+	}
+	metricsCollectors.RUnlock()
+	for i, collector := range collectors {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Warn("Metrics collector failed", "name", names[i], "error", r)
+				}
+			}()
+			collector(env, w)
+		}()
+	}
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of the given metric to w
+func writeMetricHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatMetricLabels returns the given labels in the Prometheus syntax, e.g. {state="pending"}
+func formatMetricLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, values[i])
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+// A MetricCounter is an in-process counter with labels, exposed on the
+// /metrics endpoint. It is safe for concurrent use.
+type MetricCounter struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+// NewMetricCounter returns a new counter with the given name, help and label
+// names, and registers it as a metrics collector.
+func NewMetricCounter(name, help string, labels ...string) *MetricCounter {
+	counter := &MetricCounter{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]int64),
+	}
+	RegisterMetricsCollector(name, func(_ models.Environment, w io.Writer) {
+		counter.write(w)
+	})
+	return counter
+}
+
+// Inc increments the counter for the given label values, which must be
+// given in the order of the counter's labels.
+func (c *MetricCounter) Inc(labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		log.Panic("Wrong number of metric label values", "metric", c.name, "labels", c.labels, "values", labelValues)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\x00")]++
+}
+
+// Value returns the value of the counter for the given label values
+func (c *MetricCounter) Value(labelValues ...string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\x00")]
+}
+
+// write writes this counter to w in the Prometheus text exposition format
+func (c *MetricCounter) write(w io.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		values[i] = c.values[key]
+	}
+	c.mu.Unlock()
+	writeMetricHeader(w, c.name, "counter", c.help)
+	for i, key := range keys {
+		var labelValues []string
+		if len(c.labels) > 0 {
+			labelValues = strings.Split(key, "\x00")
+		}
+		fmt.Fprintf(w, "%s%s %d\n", c.name, formatMetricLabels(c.labels, labelValues), values[i])
+	}
+}
+
+// Counters of the base subsystems
+var (
+	metricCronRuns = NewMetricCounter("hexya_cron_runs_total",
+		"Number of cron executions enqueued", "cron")
+	metricMails = NewMetricCounter("hexya_mails_total",
+		"Number of outgoing emails by result", "result")
+	metricWebhookDeliveries = NewMetricCounter("hexya_webhook_deliveries_total",
+		"Number of outgoing webhook notifications by webhook and result", "webhook", "result")
+	metricLogins = NewMetricCounter("hexya_logins_total",
+		"Number of login attempts by result", "result")
+	metricReferenceCache = NewMetricCounter("hexya_reference_cache_requests_total",
+		"Number of reference cache lookups by model and result (hit, miss or bypass)", "model", "result")
+)
+
+// collectQueueMetrics writes the number of unfinished queue jobs by state
+func collectQueueMetrics(env models.Environment, w io.Writer) {
+	writeMetricHeader(w, "hexya_queue_jobs", "gauge", "Number of unfinished queue jobs by state")
+	for _, state := range []string{"pending", "enqueued", "started"} {
+		count := h.QueueJob().Search(env, q.QueueJob().State().Equals(state)).SearchCount()
+		fmt.Fprintf(w, "hexya_queue_jobs{state=%q} %d\n", state, count)
+	}
+}
+
+// checkMetricsAuth returns true if the request is allowed to read metrics.
+// If the Metrics.User configuration key is set, requests must be
+// authenticated with this user and the Metrics.Password key using HTTP basic
+// authentication. Otherwise, metrics are denied unless the Metrics.Public
+// configuration key is explicitly set.
+func checkMetricsAuth(c *server.Context) bool {
+	wantUser := viper.GetString("Metrics.User")
+	if wantUser == "" {
+		return viper.GetBool("Metrics.Public")
+	}
+	user, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(viper.GetString("Metrics.Password"))) == 1
+	return userOK && passwordOK
+}
+
+func init() {
+	RegisterMetricsCollector("queue", collectQueueMetrics)
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testMetricCounter = NewMetricCounter("hexya_test_events_total", "Number of test events", "kind")
+
+func init() {
+	RegisterMetricsCollector("test_failing", func(_ models.Environment, _ io.Writer) {
+		panic("collector failure")
+	})
+	RegisterMetricsCollector("test_gauge", func(_ models.Environment, w io.Writer) {
+		writeMetricHeader(w, "hexya_test_gauge", "gauge", "Test gauge")
+		fmt.Fprintln(w, "hexya_test_gauge 42")
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	Convey("Testing metrics", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Counters are incremented per label values", func() {
+				before := testMetricCounter.Value("a")
+				testMetricCounter.Inc("a")
+				testMetricCounter.Inc("a")
+				testMetricCounter.Inc("b")
+				So(testMetricCounter.Value("a"), ShouldEqual, before+2)
+				So(func() { testMetricCounter.Inc("a", "b") }, ShouldPanic)
+			})
+			Convey("Collectors cannot be registered twice", func() {
+				So(func() {
+					RegisterMetricsCollector("test_gauge", func(_ models.Environment, _ io.Writer) {})
+				}, ShouldPanic)
+			})
+			Convey("All collectors are written, failing ones being skipped", func() {
+				testMetricCounter.Inc("c")
+				france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+				cachedCountry(france)
+				cachedCountry(france)
+				var b strings.Builder
+				CollectMetrics(env, &b)
+				metrics := b.String()
+				So(metrics, ShouldContainSubstring, "# TYPE hexya_test_events_total counter")
+				So(metrics, ShouldContainSubstring, `hexya_test_events_total{kind="c"}`)
+				So(metrics, ShouldContainSubstring, "hexya_test_gauge 42")
+				So(metrics, ShouldContainSubstring, `hexya_queue_jobs{state="pending"}`)
+				So(metrics, ShouldContainSubstring, "hexya_reference_cache_requests_total")
+				So(metrics, ShouldContainSubstring, "# TYPE hexya_method_calls_total counter")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+var fields_Model = map[string]models.FieldDefinition{
+	"Name":      fields.Char{String: "Model", Required: true, ReadOnly: true, Index: true, NoCopy: true},
+	"TableName": fields.Char{ReadOnly: true},
+	"Transient": fields.Boolean{String: "Transient Model", ReadOnly: true},
+	"Mixin":     fields.Boolean{ReadOnly: true},
+	"ModelFields": fields.One2Many{String: "Fields", RelationModel: h.ModelField(), ReverseFK: "Model",
+		JSON: "field_ids", ReadOnly: true},
+}
+
+var fields_ModelField = map[string]models.FieldDefinition{
+	"Name":        fields.Char{String: "Field Name", Required: true, ReadOnly: true, Index: true},
+	"JSON":        fields.Char{String: "JSON Name", Required: true, ReadOnly: true, Index: true},
+	"Model":       fields.Many2One{RelationModel: h.Model(), Required: true, ReadOnly: true, OnDelete: models.Cascade, Index: true},
+	"Description": fields.Char{ReadOnly: true},
+	"Help":        fields.Text{ReadOnly: true},
+	"Type":        fields.Char{String: "Field Type", ReadOnly: true},
+	"Relation": fields.Char{String: "Related Model", ReadOnly: true,
+		Help: "Name of the related model for relational fields"},
+	"Required":  fields.Boolean{ReadOnly: true},
+	"ReadOnly":  fields.Boolean{String: "Read Only", ReadOnly: true},
+	"Stored":    fields.Boolean{ReadOnly: true},
+	"Selection": fields.Text{ReadOnly: true, Help: "Selection values, one 'key: label' per line"},
+}
+
+// ModelExternalID returns the external ID of the Model record of the given model
+func ModelExternalID(modelName string) string {
+	return fmt.Sprintf("base_model_%s", modelName)
+}
+
+// ModelFieldExternalID returns the external ID of the ModelField record of the given field
+func ModelFieldExternalID(modelName, fieldName string) string {
+	return fmt.Sprintf("base_field_%s_%s", modelName, fieldName)
+}
+
+// modelFieldData returns the ModelField values describing the given field
+func modelFieldData(jsonName string, fi *models.FieldInfo) m.ModelFieldData {
+	res := h.ModelField().NewData().
+		SetName(fi.Name).
+		SetJSON(jsonName).
+		SetDescription(fi.String).
+		SetHelp(fi.Help).
+		SetType(string(fi.Type)).
+		SetRelation(fi.Relation).
+		SetRequired(fi.Required).
+		SetReadOnly(fi.ReadOnly).
+		SetStored(fi.Store)
+	if len(fi.Selection) > 0 {
+		keys := make([]string, 0, len(fi.Selection))
+		for key := range fi.Selection {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		lines := make([]string, len(keys))
+		for i, key := range keys {
+			lines[i] = fmt.Sprintf("%s: %s", key, fi.Selection[key])
+		}
+		res.SetSelection(strings.Join(lines, "\n"))
+	}
+	return res
+}
+
+// ReflectModels synchronizes Model and ModelField records with the models
+// registry. Records are created for new models and fields, updated for
+// existing ones and deleted for models and fields that do not exist anymore.
+//
+// It is called at startup so that admins can browse models and fields and
+// records can reference them by their external IDs (see ModelExternalID and
+// ModelFieldExternalID).
+func model_ReflectModels(rs m.ModelSet) {
+	existing := make(map[string]m.ModelSet)
+	for _, rec := range h.Model().Search(rs.Env(), q.Model().Name().IsNotNull()).Records() {
+		existing[rec.Name()] = rec
+	}
+	seen := make(map[string]bool)
+	for _, model := range models.Registry.All() {
+		modelName := model.Name()
+		seen[modelName] = true
+		data := h.Model().NewData().
+			SetName(modelName).
+			SetTableName(model.TableName()).
+			SetTransient(model.IsTransient()).
+			SetMixin(model.IsMixin())
+		rec, ok := existing[modelName]
+		if ok {
+			rec.Write(data)
+		} else {
+			rec = h.Model().Create(rs.Env(), data.SetHexyaExternalID(ModelExternalID(modelName)))
+		}
+		rec.ReflectFields(model.FieldsGet())
+	}
+	for name, rec := range existing {
+		if !seen[name] {
+			rec.Unlink()
+		}
+	}
+}
+
+// ReflectFields synchronizes the ModelField records of this model
+// with the given field infos, as returned by FieldsGet.
+func model_ReflectFields(rs m.ModelSet, fInfos map[string]*models.FieldInfo) {
+	rs.EnsureOne()
+	existing := make(map[string]m.ModelFieldSet)
+	for _, rec := range rs.ModelFields().Records() {
+		existing[rec.JSON()] = rec
+	}
+	for jsonName, fi := range fInfos {
+		data := modelFieldData(jsonName, fi)
+		if rec, ok := existing[jsonName]; ok {
+			rec.Write(data)
+			delete(existing, jsonName)
+			continue
+		}
+		h.ModelField().Create(rs.Env(), data.
+			SetModel(rs).
+			SetHexyaExternalID(ModelFieldExternalID(rs.Name(), fi.Name)))
+	}
+	for _, rec := range existing {
+		rec.Unlink()
+	}
+}
+
+// NameGet returns the model name followed by the field name
+func modelField_NameGet(rs m.ModelFieldSet) string {
+	return fmt.Sprintf("%s.%s", rs.Model().Name(), rs.Name())
+}
+
+func init() {
+	models.NewModel("Model")
+	h.Model().SetDefaultOrder("Name")
+	h.Model().AddFields(fields_Model)
+	h.Model().AddSQLConstraint("name_uniq", "unique(name)", "Each model must be unique!")
+
+	h.Model().NewMethod("ReflectModels", model_ReflectModels)
+	h.Model().NewMethod("ReflectFields", model_ReflectFields)
+
+	models.NewModel("ModelField")
+	h.ModelField().SetDefaultOrder("Model", "Name")
+	h.ModelField().AddFields(fields_ModelField)
+	h.ModelField().AddSQLConstraint("name_uniq", "unique(model_id, name)", "Field names must be unique per model!")
+
+	h.ModelField().Methods().NameGet().Extend(modelField_NameGet)
+}
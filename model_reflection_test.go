@@ -0,0 +1,44 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestModelReflection(t *testing.T) {
+	Convey("Testing model reflection", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.Model().NewSet(env).ReflectModels()
+			partnerModel := h.Model().Search(env, q.Model().Name().Equals("Partner"))
+			Convey("Models are reflected with their external ID", func() {
+				So(partnerModel.Len(), ShouldEqual, 1)
+				So(partnerModel.HexyaExternalID(), ShouldEqual, ModelExternalID("Partner"))
+				So(partnerModel.Transient(), ShouldBeFalse)
+				So(h.Model().Search(env, q.Model().Name().Equals("DateRangeGenerator")).Transient(), ShouldBeTrue)
+			})
+			Convey("Fields are reflected with their definition", func() {
+				name := h.ModelField().Search(env, q.ModelField().HexyaExternalID().Equals(ModelFieldExternalID("Partner", "Name")))
+				So(name.Len(), ShouldEqual, 1)
+				So(name.Model().Equals(partnerModel), ShouldBeTrue)
+				So(name.JSON(), ShouldEqual, "name")
+				So(name.Type(), ShouldEqual, "char")
+				So(name.NameGet(), ShouldEqual, "Partner.Name")
+				country := h.ModelField().Search(env, q.ModelField().Model().Equals(partnerModel).And().Name().Equals("Country"))
+				So(country.Relation(), ShouldEqual, "Country")
+			})
+			Convey("Reflecting twice does not duplicate records", func() {
+				count := h.ModelField().NewSet(env).SearchAll().Len()
+				h.Model().NewSet(env).ReflectModels()
+				So(h.ModelField().NewSet(env).SearchAll().Len(), ShouldEqual, count)
+			})
+		}), ShouldBeNil)
+	})
+}
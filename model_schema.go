@@ -0,0 +1,99 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fieldtype"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// JSONSchemaVersion is the JSON Schema draft used by GetModelSchema
+const JSONSchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// fieldJSONSchema returns the JSON Schema of the field described by fi
+func fieldJSONSchema(fi *models.FieldInfo) map[string]interface{} {
+	res := make(map[string]interface{})
+	switch fi.Type {
+	case fieldtype.Boolean:
+		res["type"] = "boolean"
+	case fieldtype.Integer:
+		res["type"] = "integer"
+	case fieldtype.Float:
+		res["type"] = "number"
+	case fieldtype.Date:
+		res["type"] = "string"
+		res["format"] = "date"
+	case fieldtype.DateTime:
+		res["type"] = "string"
+		res["format"] = "date-time"
+	case fieldtype.Binary:
+		res["type"] = "string"
+		res["contentEncoding"] = "base64"
+	case fieldtype.Selection:
+		res["type"] = "string"
+		keys := make([]string, 0, len(fi.Selection))
+		for key := range fi.Selection {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		options := make([]map[string]interface{}, len(keys))
+		for i, key := range keys {
+			options[i] = map[string]interface{}{"const": key, "title": fi.Selection[key]}
+		}
+		res["oneOf"] = options
+	case fieldtype.Many2One, fieldtype.One2One, fieldtype.Rev2One:
+		res["type"] = "integer"
+		res["x-relation"] = fi.Relation
+	case fieldtype.One2Many, fieldtype.Many2Many:
+		res["type"] = "array"
+		res["items"] = map[string]interface{}{"type": "integer"}
+		res["x-relation"] = fi.Relation
+	default:
+		res["type"] = "string"
+	}
+	if fi.String != "" {
+		res["title"] = fi.String
+	}
+	if fi.Help != "" {
+		res["description"] = fi.Help
+	}
+	if fi.ReadOnly {
+		res["readOnly"] = true
+	}
+	res["x-field-type"] = string(fi.Type)
+	return res
+}
+
+// GetModelSchema returns the JSON Schema of this model, generated from its
+// field definitions, so that external tools (form builders, REST/OpenAPI
+// layers) can stay in sync with the model.
+//
+// Relational fields are described by the IDs of the related records and
+// have an 'x-relation' keyword holding the name of the related model.
+func baseMixin_GetModelSchema(rs m.BaseMixinSet) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for name, fi := range rs.FieldsGet(models.FieldsGetArgs{}) {
+		properties[name] = fieldJSONSchema(fi)
+		if fi.Required {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+	return map[string]interface{}{
+		"$schema":    JSONSchemaVersion,
+		"title":      rs.ModelName(),
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func init() {
+	h.BaseMixin().NewMethod("GetModelSchema", baseMixin_GetModelSchema)
+}
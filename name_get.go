@@ -0,0 +1,72 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// NameGetBatchSize is the number of records whose fields are prefetched at
+// once by NameGetMulti.
+const NameGetBatchSize = 1000
+
+// NameGetPaths returns the field paths read by NameGet, so that NameGetMulti
+// can prefetch them. Default implementation returns the Name field if it exists.
+//
+// Override this method when you override NameGet.
+func baseMixin_NameGetPaths(rs m.BaseMixinSet) []string {
+	if _, exists := rs.Collection().Model().Fields().Get("name"); !exists {
+		return nil
+	}
+	return []string{"Name"}
+}
+
+// NameGetMulti returns the ID and display name of each record of this set,
+// in the order of the set. It should be preferred over calling NameGet on
+// each record for large sets, such as in lists and autocompletion.
+//
+// Records are processed in batches of NameGetBatchSize: the NameGetPaths of
+// each batch are prefetched before calling NameGet, and the cache of the
+// batch is cleared afterwards.
+func baseMixin_NameGetMulti(rs m.BaseMixinSet) []basetypes.RecordName {
+	model := models.Registry.MustGet(rs.ModelName())
+	paths := rs.NameGetPaths()
+	ids := rs.Ids()
+	res := make([]basetypes.RecordName, 0, len(ids))
+	for start := 0; start < len(ids); start += NameGetBatchSize {
+		end := start + NameGetBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := model.Browse(rs.Env(), ids[start:end])
+		prefetchPaths(batch, paths)
+		for _, rec := range batch.Records() {
+			res = append(res, basetypes.RecordName{ID: rec.ID(), Name: rec.Call("NameGet").(string)})
+		}
+		batch.InvalidateCache()
+	}
+	return res
+}
+
+// NameGetPaths returns the fields read by the partner NameGet in the current context
+func partner_NameGetPaths(rs m.PartnerSet) []string {
+	res := []string{"Name", "CompanyName", "IsCompany", "Type", "Parent", "CommercialCompanyName"}
+	if ContextGetBool(rs.Env(), ContextKeyShowAddress) || ContextGetBool(rs.Env(), ContextKeyShowAddressOnly) {
+		res = append(res, "Street", "Street2", "Zip", "City", "State", "Country")
+	}
+	if ContextGetBool(rs.Env(), ContextKeyShowEmail) {
+		res = append(res, "Email")
+	}
+	return res
+}
+
+func init() {
+	h.BaseMixin().NewMethod("NameGetPaths", baseMixin_NameGetPaths)
+	h.BaseMixin().NewMethod("NameGetMulti", baseMixin_NameGetMulti)
+
+	h.Partner().Methods().NameGetPaths().Extend(partner_NameGetPaths)
+}
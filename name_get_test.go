@@ -0,0 +1,67 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestNameGetMulti(t *testing.T) {
+	Convey("Testing batched NameGet", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			company := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Multi Corp").
+				SetIsCompany(true).
+				SetCity("Lyon"))
+			contact := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Jane").
+				SetParent(company).
+				SetEmail("jane@multi.example"))
+			invoice := h.Partner().Create(env, h.Partner().NewData().
+				SetParent(company).
+				SetType("invoice"))
+			partners := contact.Union(company).Union(invoice)
+			expected := func(set m.PartnerSet) []string {
+				var res []string
+				for _, rec := range set.Records() {
+					res = append(res, rec.NameGet())
+				}
+				return res
+			}
+			names := func(set m.PartnerSet) []string {
+				var res []string
+				for _, rn := range set.NameGetMulti() {
+					res = append(res, rn.Name)
+				}
+				return res
+			}
+			Convey("Names are the same as NameGet, in the order of the set", func() {
+				res := partners.NameGetMulti()
+				So(res, ShouldHaveLength, 3)
+				So(res[0].ID, ShouldEqual, contact.ID())
+				So(res[0].Name, ShouldEqual, "Multi Corp, Jane")
+				So(names(partners), ShouldResemble, expected(partners))
+			})
+			Convey("The context of the set is used", func() {
+				withAddress := partners.WithContext(ContextKeyShowAddress, true)
+				So(names(withAddress), ShouldResemble, expected(withAddress))
+				withEmail := partners.WithContext(ContextKeyShowEmail, true)
+				So(names(withEmail), ShouldResemble, expected(withEmail))
+			})
+			Convey("Models without NameGetPaths override fall back on Name", func() {
+				tags := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Multi Tag"))
+				res := tags.NameGetMulti()
+				So(res, ShouldHaveLength, 1)
+				So(res[0].Name, ShouldEqual, "Multi Tag")
+				So(h.PartnerCategory().NewSet(env).NameGetMulti(), ShouldBeEmpty)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// CheckOutOfOffice checks that out of office periods do not end before they start
+func user_CheckOutOfOffice(rs m.UserSet) {
+	for _, user := range rs.Records() {
+		if user.OutOfOfficeFrom().IsZero() || user.OutOfOfficeTo().IsZero() {
+			continue
+		}
+		if user.OutOfOfficeTo().Lower(user.OutOfOfficeFrom()) {
+			log.Panic(rs.T("The end of the out of office period must be after its start"))
+		}
+	}
+}
+
+// IsOutOfOfficeOn returns true if this user is out of office on the given date.
+// A period without start date starts immediately and a period without end date
+// lasts until the user comes back and clears it.
+func user_IsOutOfOfficeOn(rs m.UserSet, date dates.Date) bool {
+	rs.EnsureOne()
+	from, to := rs.OutOfOfficeFrom(), rs.OutOfOfficeTo()
+	if from.IsZero() && to.IsZero() {
+		return false
+	}
+	if !from.IsZero() && date.Lower(from) {
+		return false
+	}
+	if !to.IsZero() && date.Greater(to) {
+		return false
+	}
+	return true
+}
+
+// ComputeIsOutOfOffice returns whether this user is out of office today, in the user's timezone
+func user_ComputeIsOutOfOffice(rs m.UserSet) m.UserData {
+	return h.User().NewData().SetIsOutOfOffice(rs.IsOutOfOfficeOn(userToday(rs)))
+}
+
+// OutOfOfficeNotice returns a notice telling that this user is out of office
+// on the given date, followed by the user's out of office message. It returns
+// an empty string if the user is not out of office on this date. Internal
+// notifications and mail templates should display it to the sender.
+func user_OutOfOfficeNotice(rs m.UserSet, date dates.Date) string {
+	rs.EnsureOne()
+	if !rs.IsOutOfOfficeOn(date) {
+		return ""
+	}
+	notice := rs.T("%s is out of office.", rs.Name())
+	if !rs.OutOfOfficeTo().IsZero() {
+		notice = rs.T("%s is out of office until %s.", rs.Name(), rs.OutOfOfficeTo().String())
+	}
+	if msg := strings.TrimSpace(rs.OutOfOfficeMessage()); msg != "" {
+		notice += "\n" + msg
+	}
+	return notice
+}
+
+// AvailableUser returns the user who should receive the work assigned to this
+// user on the given date: this user if present, otherwise the out of office
+// backup, following the chain of backups who are themselves out of office.
+// It returns this user if no present backup is found.
+func user_AvailableUser(rs m.UserSet, date dates.Date) m.UserSet {
+	rs.EnsureOne()
+	visited := make(map[int64]bool)
+	user := rs
+	for user.IsOutOfOfficeOn(date) && !visited[user.ID()] {
+		visited[user.ID()] = true
+		if user.OutOfOfficeBackup().IsEmpty() {
+			return rs
+		}
+		user = user.OutOfOfficeBackup()
+	}
+	if user.IsOutOfOfficeOn(date) {
+		return rs
+	}
+	return user
+}
+
+// OnchangeUserWarning warns that the assigned user is out of office on the deadline
+func activity_OnchangeUserWarning(rs m.ActivitySet) string {
+	if rs.User().IsEmpty() || rs.DateDeadline().IsZero() {
+		return ""
+	}
+	return rs.User().OutOfOfficeNotice(rs.DateDeadline())
+}
+
+// Create redirects activities assigned to a user who delegated their
+// activities or who is out of office on their deadline to the delegate or
+// to the user's backup.
+func activity_Create(rs m.ActivitySet, data m.ActivityData) m.ActivitySet {
+	if data.User().IsEmpty() || data.DateDeadline().IsZero() || ContextGetBool(rs.Env(), ContextKeyActivityNoRedirect) {
+		return rs.Super().Create(data)
+	}
+	user := data.User()
+	if delegate := user.DelegateFor(DelegationScopeActivities, data.DateDeadline()); !delegate.Equals(user) {
+		data.SetUser(delegate)
+		data.SetNote(strings.TrimSpace(data.Note() + "\n" +
+			rs.T("Delegated by %s.", user.Name())))
+		return rs.Super().Create(data)
+	}
+	if available := user.AvailableUser(data.DateDeadline()); !available.Equals(user) {
+		data.SetUser(available)
+		data.SetNote(strings.TrimSpace(data.Note() + "\n" +
+			rs.T("Redirected from %s who is out of office.", user.Name())))
+	}
+	return rs.Super().Create(data)
+}
+
+func init() {
+	h.User().NewMethod("CheckOutOfOffice", user_CheckOutOfOffice)
+	h.User().NewMethod("IsOutOfOfficeOn", user_IsOutOfOfficeOn)
+	h.User().NewMethod("ComputeIsOutOfOffice", user_ComputeIsOutOfOffice)
+	h.User().NewMethod("OutOfOfficeNotice", user_OutOfOfficeNotice)
+	h.User().NewMethod("AvailableUser", user_AvailableUser)
+
+	h.Activity().NewMethod("OnchangeUserWarning", activity_OnchangeUserWarning)
+	h.Activity().Methods().Create().Extend(activity_Create)
+}
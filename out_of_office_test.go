@@ -0,0 +1,65 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOutOfOffice(t *testing.T) {
+	Convey("Testing out of office", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			today := dates.Today()
+			backup := h.User().Create(env, h.User().NewData().
+				SetName("Backup User").
+				SetLogin("ooo_backup").
+				SetTZ("UTC"))
+			user := h.User().Create(env, h.User().NewData().
+				SetName("Absent User").
+				SetLogin("ooo_absent").
+				SetTZ("UTC").
+				SetOutOfOfficeFrom(today.AddDate(0, 0, -1)).
+				SetOutOfOfficeTo(today.AddDate(0, 0, 7)).
+				SetOutOfOfficeMessage("Back next week").
+				SetOutOfOfficeBackup(backup))
+			Convey("Users are out of office during their period", func() {
+				So(user.IsOutOfOffice(), ShouldBeTrue)
+				So(backup.IsOutOfOffice(), ShouldBeFalse)
+				So(user.IsOutOfOfficeOn(today.AddDate(0, 0, 8)), ShouldBeFalse)
+				notice := user.OutOfOfficeNotice(today)
+				So(notice, ShouldContainSubstring, "Absent User is out of office until")
+				So(notice, ShouldContainSubstring, "Back next week")
+				So(user.OutOfOfficeNotice(today.AddDate(0, 0, 8)), ShouldBeBlank)
+			})
+			Convey("Periods cannot end before they start", func() {
+				So(func() { user.SetOutOfOfficeTo(today.AddDate(0, 0, -5)) }, ShouldPanic)
+			})
+			Convey("Activities are redirected to the backup", func() {
+				activity := h.Activity().Create(env, h.Activity().NewData().
+					SetSummary("Review contract").
+					SetUser(user).
+					SetDateDeadline(today.AddDate(0, 0, 2)))
+				So(activity.User().Equals(backup), ShouldBeTrue)
+				So(activity.Note(), ShouldContainSubstring, "Absent User")
+				later := h.Activity().Create(env, h.Activity().NewData().
+					SetSummary("Follow up").
+					SetUser(user).
+					SetDateDeadline(today.AddDate(0, 0, 10)))
+				So(later.User().Equals(user), ShouldBeTrue)
+			})
+			Convey("Activities stay assigned if the backup is away too", func() {
+				backup.Write(h.User().NewData().
+					SetOutOfOfficeFrom(today).
+					SetOutOfOfficeBackup(user))
+				So(user.AvailableUser(today).Equals(user), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -4,18 +4,17 @@
 package base
 
 import (
-	"bytes"
 	"crypto/md5"
 	"encoding/base64"
 	"fmt"
+	"html"
 	"image/color"
 	"io/ioutil"
 	"net/http"
 	"net/mail"
 	"net/url"
-	"path/filepath"
+	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/erlangs/hexya-base/basetypes"
@@ -27,8 +26,8 @@ import (
 	"github.com/erlangs/okoo/src/models/operator"
 	"github.com/erlangs/okoo/src/models/types"
 	"github.com/erlangs/okoo/src/models/types/dates"
-	"github.com/erlangs/okoo/src/server"
 	"github.com/erlangs/okoo/src/tools/b64image"
+	"github.com/erlangs/okoo/src/tools/emailutils"
 	"github.com/erlangs/okoo/src/tools/nbutils"
 	"github.com/erlangs/okoo/src/tools/typesutils"
 	"github.com/erlangs/okoo/src/views"
@@ -51,34 +50,124 @@ var (
 	WarningHelp = `Selecting the "Warning" option will notify user with the message,
 Selecting "Blocking Message" will throw an exception with the message and block the flow.
 The Message has to be written in the next field.`
+	// ColorPicker gives the color indexes available in the web client color picker.
+	ColorPicker = colorPaletteSelection()
 )
 
+// TitleAfterNameLangs lists the languages (by their two letters prefix) in which
+// titles are placed after the name by default (e.g. "Tanaka-sama" in Japanese).
+var TitleAfterNameLangs = map[string]bool{
+	"ja": true,
+	"ko": true,
+	"zh": true,
+}
+
 var fields_PartnerTitle = map[string]models.FieldDefinition{
 	"Name":     fields.Char{String: "Title", Required: true, Translate: true, Unique: true},
 	"Shortcut": fields.Char{String: "Abbreviation", Translate: true},
+	"Position": fields.Selection{Selection: types.Selection{
+		"auto":   "Language Convention",
+		"before": "Before Name",
+		"after":  "After Name",
+	}, String: "Title Position", Required: true, Default: models.DefaultValue("auto"),
+		Help: "Where to place this title relatively to the partner's name. 'Language Convention' depends on the partner's language."},
 }
 
 var fields_PartnerCategory = map[string]models.FieldDefinition{
-	"Name":  fields.Char{String: "Tag Name", Required: true, Translate: true},
-	"Color": fields.Integer{String: "Color Index"},
+	"Name": fields.Char{String: "Tag Name", Required: true, Translate: true},
 	"Parent": fields.Many2One{RelationModel: h.PartnerCategory(),
-		String: "Parent Tag", Index: true, OnDelete: models.Cascade},
+		String: "Parent Tag", Index: true, OnDelete: models.Cascade,
+		Constraint: h.PartnerCategory().Methods().CheckParent()},
 	"Children": fields.One2Many{RelationModel: h.PartnerCategory(), Copy: true,
 		ReverseFK: "Parent", String: "Children Tags"},
 	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true,
 		Help: "The active field allows you to hide the category without removing it."},
 	"Partners": fields.Many2Many{RelationModel: h.Partner()},
+	"PartnerCount": fields.Integer{String: "# Partners", GoType: new(int),
+		Compute: h.PartnerCategory().Methods().ComputePartnerCount(), Stored: true, Depends: []string{"Partners"},
+		Help: "Number of partners tagged with this tag"},
+}
+
+// ComputePartnerCount returns the number of active partners tagged with this tag
+func partnerCategory_ComputePartnerCount(rs m.PartnerCategorySet) m.PartnerCategoryData {
+	count := h.Partner().NewSet(rs.Env()).WithContext(ContextKeyCategorySearchDescendants, false).
+		Search(q.Partner().Categories().Equals(rs)).SearchCount()
+	return h.PartnerCategory().NewData().SetPartnerCount(count)
+}
+
+// RefreshPartnerCount updates the stored PartnerCount of these tags.
+//
+// It must be called when the relation is modified from the Partner side,
+// since the dependency is only declared on the PartnerCategory side.
+func partnerCategory_RefreshPartnerCount(rs m.PartnerCategorySet) {
+	for _, tag := range rs.Records() {
+		tag.WithContext(ContextKeyForceComputeWrite, true).Write(tag.ComputePartnerCount())
+	}
 }
 
 // CheckParent checks if we have a recursion in the parent tree.
 func partnerCategory_CheckParent(rs m.PartnerCategorySet) {
-	if !rs.CheckRecursion() {
-		log.Panic(rs.T("Error ! You can not create recursive tags."))
+	if cycle := rs.ParentCyclePath(h.PartnerCategory().Fields().Parent()); len(cycle) > 0 {
+		log.Panic(rs.T("Error ! You can not create recursive tags: %s", strings.Join(cycle, " → ")))
+	}
+}
+
+// Merge merges these tags into the given target tag. Partners tagged with
+// any of these tags are tagged with target instead, children tags are moved
+// under target, and these tags are then deleted.
+//
+// This is typically used to consolidate duplicate tags created by imports.
+func partnerCategory_Merge(rs m.PartnerCategorySet, target m.PartnerCategorySet) m.PartnerCategorySet {
+	target.EnsureOne()
+	toMerge := rs.Subtract(target)
+	if toMerge.IsEmpty() {
+		return target
+	}
+	for _, tag := range toMerge.Records() {
+		descendants := h.PartnerCategory().Search(rs.Env(), q.PartnerCategory().ID().ChildOf(tag.ID()))
+		if !descendants.Intersect(target).IsEmpty() {
+			log.Panic(rs.T("You cannot merge a tag into one of its descendants."))
+		}
+	}
+	tagged := h.Partner().NewSet(rs.Env()).WithContext("active_test", false).
+		WithContext(ContextKeyCategorySearchDescendants, false).
+		Search(q.Partner().Categories().In(toMerge))
+	for _, partner := range tagged.Records() {
+		partner.SetCategories(partner.Categories().Subtract(toMerge).Union(target))
+	}
+	toMerge.Children().Subtract(toMerge).Write(h.PartnerCategory().NewData().SetParent(target))
+	toMerge.Unlink()
+	target.RefreshPartnerCount()
+	return target
+}
+
+// WithDescendants returns these tags and all their descendants (i.e. the
+// closure of the tags hierarchy from these tags). The hierarchy is walked
+// in a single recursive SQL query.
+func partnerCategory_WithDescendants(rs m.PartnerCategorySet) m.PartnerCategorySet {
+	if rs.IsEmpty() {
+		return rs
+	}
+	var ids []int64
+	rs.Env().Cr().Select(&ids, `
+WITH RECURSIVE closure(id) AS (
+	SELECT id FROM partner_category WHERE id IN (?)
+  UNION
+	SELECT c.id FROM partner_category c JOIN closure ON c.parent_id = closure.id
+)
+SELECT id FROM closure`, rs.Ids())
+	return h.PartnerCategory().Browse(rs.Env(), ids)
+}
+
+func partnerCategory_Create(rs m.PartnerCategorySet, vals m.PartnerCategoryData) m.PartnerCategorySet {
+	if !vals.HasColor() {
+		vals.SetColor(rs.NextColor())
 	}
+	return rs.Super().Create(vals)
 }
 
 func partnerCategory_NAmeGet(rs m.PartnerCategorySet) string {
-	if rs.Env().Context().GetString("partner_category_display") == "short" {
+	if ContextGetString(rs.Env(), ContextKeyPartnerCategoryDisplay) == "short" {
 		return rs.Super().NameGet()
 	}
 	var names []string
@@ -97,6 +186,16 @@ func partnerCategory_SearchByName(rs m.PartnerCategorySet, name string, op opera
 	return rs.Super().SearchByName(name, op, additionalCond, limit)
 }
 
+// langSelection returns the selection of the languages of the application
+func langSelection() types.Selection {
+	out := make(types.Selection)
+	for _, lang := range i18n.Langs {
+		l := i18n.GetLocale(lang)
+		out[lang] = l.Name
+	}
+	return out
+}
+
 var fields_Partner = map[string]models.FieldDefinition{
 	"Name":  fields.Char{Required: true, Index: true, NoCopy: true},
 	"Date":  fields.Date{Index: true},
@@ -113,23 +212,21 @@ var fields_Partner = map[string]models.FieldDefinition{
 	"Lang": fields.Selection{
 		String: "Language",
 		Default: func(env models.Environment) interface{} {
-			return env.Context().GetString("lang")
-		},
-		SelectionFunc: func() types.Selection {
-			out := make(types.Selection)
-			for _, lang := range i18n.Langs {
-				l := i18n.GetLocale(lang)
-				out[lang] = l.Name
-			}
-			return out
+			return ContextGetString(env, ContextKeyLang)
 		},
+		SelectionFunc: langSelection,
 		Help: `If the selected language is loaded in the system, all documents related to
 this contact will be printed in this language. If not, it will be English.`},
 	"ActiveLangCount": fields.Integer{Compute: h.Partner().Methods().ComputeActiveLangCount(), GoType: new(int)},
+	"AddressValidationStatus": fields.Selection{Selection: AddressValidationStatuses, ReadOnly: true, NoCopy: true,
+		Default: models.DefaultValue(AddressUnverified), String: "Address Verification"},
+	"AddressValidationMessage": fields.Char{ReadOnly: true, NoCopy: true, String: "Address Verification Message"},
+	"KeepAddress": fields.Boolean{String: "Keep Own Address",
+		Help: "Never overwrite the address of this contact when the address of its company changes"},
 	"TZ": fields.Char{
 		String: "Timezone",
 		Default: func(env models.Environment) interface{} {
-			return env.Context().GetString("tz")
+			return ContextGetString(env, ContextKeyTZ)
 		},
 		Help: `"The partner's timezone, used to output proper date and time values
 inside printed reports. It is important to set a value for this field.
@@ -141,14 +238,24 @@ render date and time values: your computer's timezone.`},
 	"User": fields.Many2One{
 		RelationModel: h.User(),
 		String:        "Salesperson", Help: "The internal user that is in charge of communicating with this contact if any."},
+	"Team": fields.Many2One{RelationModel: h.Team(), OnDelete: models.SetNull, Index: true,
+		Help: "Team in charge of this contact, set by the team assignment rules when the contact is created"},
 	"VAT": fields.Char{String: "TIN", Help: `Tax Identification Number.
 Fill it if the company is subjected to taxes.
 Used by the some of the legal statements.`},
+	"LEI": fields.Char{String: "LEI", Index: true, NoCopy: true, Constraint: h.Partner().Methods().CheckLEI(),
+		Help: "Legal Entity Identifier (ISO 17442) of the company"},
+	"GLN": fields.Char{String: "GLN", Index: true, NoCopy: true, Constraint: h.Partner().Methods().CheckGLN(),
+		Help: "GS1 Global Location Number of the company or address, used in EDI messages"},
+	"DUNS": fields.Char{String: "DUNS Number", Index: true, NoCopy: true, Constraint: h.Partner().Methods().CheckDUNS(),
+		Help: "Dun & Bradstreet Data Universal Numbering System identifier of the company"},
 	"SameVATPartner": fields.Many2One{String: "Partner with same Tax ID",
 		RelationModel: h.Partner(),
 		Compute:       h.Partner().Methods().ComputeSameVATPartner()},
 	"Banks": fields.One2Many{
 		String: "Bank Accounts", RelationModel: h.BankAccount(), ReverseFK: "Partner"},
+	"ExternalCodes": fields.One2Many{RelationModel: h.PartnerCode(), ReverseFK: "Partner", JSON: "code_ids",
+		Help: "Identifiers of this partner in external systems, such as accounting or EDI"},
 	"Website": fields.Char{
 		Help: "Website of Partner or Company"},
 	"Comment": fields.Char{
@@ -156,7 +263,7 @@ Used by the some of the legal statements.`},
 	"Categories": fields.Many2Many{
 		RelationModel: h.PartnerCategory(), String: "Tags", JSON: "category_ids",
 		Default: func(env models.Environment) interface{} {
-			return h.PartnerCategory().Browse(env, []int64{env.Context().GetInteger("category_id")})
+			return h.PartnerCategory().Browse(env, []int64{ContextGetInteger(env, ContextKeyCategoryID)})
 		}},
 	"CreditLimit": fields.Float{},
 	"Barcode":     fields.Char{},
@@ -164,12 +271,12 @@ Used by the some of the legal statements.`},
 	"Employee":    fields.Boolean{Help: "Check this box if this contact is an Employee."},
 	"Function":    fields.Char{String: "Job Position"},
 	"Type": fields.Selection{
-		Selection: types.Selection{
+		SelectionFunc: DynamicSelection("Partner", "Type", types.Selection{
 			"contact":  "Contact",
 			"invoice":  "Invoice Address",
 			"delivery": "Shipping Address",
 			"other":    "Other Address",
-			"private":  "Private Address"},
+			"private":  "Private Address"}),
 		Default: models.DefaultValue("contact"), Required: true,
 		Help: `Used to select automatically the right address according to the context in sales and purchases documents. 
 Private addresses are only visible by authorized users.`,
@@ -179,28 +286,52 @@ Private addresses are only visible by authorized users.`,
 	"Zip":     fields.Char{},
 	"City":    fields.Char{},
 	"State": fields.Many2One{RelationModel: h.CountryState(),
-		Filter: q.CountryState().Country().EqualsEval("country_id"), OnDelete: models.Restrict},
+		Filter: q.CountryState().Country().EqualsEval("country_id"), OnDelete: models.Restrict,
+		OnChange: h.Partner().Methods().OnchangeCountryTimezone()},
 	"Country": fields.Many2One{RelationModel: h.Country(),
-		OnDelete: models.Restrict, OnChangeFilters: h.Partner().Methods().OnchangeCountryFilters()},
+		OnDelete: models.Restrict, OnChangeFilters: h.Partner().Methods().OnchangeCountryFilters(),
+		OnChange: h.Partner().Methods().OnchangeCountryTimezone()},
 	"Latitude":  fields.Float{String: "Geo Latitude", Digits: nbutils.Digits{Precision: 16, Scale: 5}},
 	"Longitude": fields.Float{String: "Geo Longitude", Digits: nbutils.Digits{Precision: 16, Scale: 5}},
 	"Email":     fields.Char{OnChange: h.Partner().Methods().OnchangeEmail()},
+	"NameWithTitle": fields.Char{Compute: h.Partner().Methods().ComputeNameWithTitle(),
+		Depends: []string{"Name", "Title", "Title.Shortcut", "Title.Position", "Lang"},
+		Help:    "Name of the contact with its abbreviated title, e.g. for salutations in mails and reports"},
 	"EmailFormatted": fields.Char{Compute: h.Partner().Methods().ComputeEmailFormatted(),
 		Help: "Formatted email address 'Name <email@domain>'", Depends: []string{"Name", "Email"}},
+	"EmailBounceCount": fields.Integer{String: "Hard Bounces", NoCopy: true, ReadOnly: true,
+		Help: "Number of permanent delivery failures to the email address of this partner"},
+	"EmailSoftBounceCount": fields.Integer{String: "Soft Bounces", NoCopy: true, ReadOnly: true,
+		Help: "Number of temporary delivery failures to the email address of this partner"},
+	"EmailLastBounce": fields.DateTime{String: "Last Bounce", NoCopy: true, ReadOnly: true},
+	"EmailBlacklisted": fields.Boolean{String: "Email Blacklisted", NoCopy: true, Index: true,
+		Help: "Set automatically after too many hard bounces. Mass mailings are not sent to blacklisted partners."},
+	"EmailScore": fields.Integer{String: "Email Quality", Compute: h.Partner().Methods().ComputeEmailScore(),
+		Stored: true, Depends: []string{"Email", "EmailBounceCount", "EmailSoftBounceCount", "EmailBlacklisted"},
+		Help: "Deliverability score of the email address, from 0 (undeliverable) to 100"},
+	"CompletenessScore": fields.Integer{String: "Completeness", Compute: h.Partner().Methods().ComputeCompletenessScore(),
+		Stored: true, Index: true, Depends: []string{"Email", "Phone", "Mobile", "VAT", "Website", "Function",
+			"Street", "Zip", "City", "State", "Country", "Lang"},
+		Help: "Share of the key data of this partner which is filled in, weighted as configured in the completeness weights"},
+	"CompletenessMissing": fields.Char{String: "Missing Data", Compute: h.Partner().Methods().ComputeCompletenessMissing(),
+		Depends: []string{"CompletenessScore"}},
 	"Phone":  fields.Char{},
 	"Mobile": fields.Char{},
+	"MobileSanitized": fields.Char{String: "Sanitized Mobile", Compute: h.Partner().Methods().ComputeMobileSanitized(),
+		Stored: true, Index: true, Depends: []string{"Mobile", "Country", "Country.PhoneCode"},
+		Help: "Mobile number in international E.164 format, used to send text messages"},
 	"IsCompany": fields.Boolean{Default: models.DefaultValue(false),
 		Help: "Check if the contact is a company, otherwise it is a person"},
 	"Industry": fields.Many2One{RelationModel: h.PartnerIndustry()},
 	// CompanyType is only an interface field, do not use it in business logic
 	"CompanyType": fields.Selection{
-		Selection: types.Selection{"person": "Individual", "company": "Company"},
-		Compute:   h.Partner().Methods().ComputeCompanyType(),
-		Depends:   []string{"IsCompany"}, Inverse: h.Partner().Methods().InverseCompanyType(),
+		SelectionFunc: DynamicSelection("Partner", "CompanyType",
+			types.Selection{"person": "Individual", "company": "Company"}),
+		Compute: h.Partner().Methods().ComputeCompanyType(),
+		Depends: []string{"IsCompany"}, Inverse: h.Partner().Methods().InverseCompanyType(),
 		OnChange: h.Partner().Methods().OnchangeCompanyType(),
 		Default:  models.DefaultValue("person")},
 	"Company": fields.Many2One{RelationModel: h.Company()},
-	"Color":   fields.Integer{},
 	"Users":   fields.One2Many{RelationModel: h.User(), ReverseFK: "Partner", JSON: "user_ids"},
 	"PartnerShare": fields.Boolean{String: "Share Partner",
 		Compute: h.Partner().Methods().ComputePartnerShare(), Stored: true, Depends: []string{"Users", "Users.Share"},
@@ -231,11 +362,11 @@ Use this field anywhere a small image is required.`},
 
 func partner_ComputeDisplayName(rs m.PartnerSet) *models.ModelData {
 	rSet := rs.
-		WithContext("show_address", false).
-		WithContext("show_address_only", false).
-		WithContext("show_email", false).
-		WithContext("html_format", false).
-		WithContext("show_vat", false)
+		WithContext(ContextKeyShowAddress, false).
+		WithContext(ContextKeyShowAddressOnly, false).
+		WithContext(ContextKeyShowEmail, false).
+		WithContext(ContextKeyHTMLFormat, false).
+		WithContext(ContextKeyShowVAT, false)
 	return rSet.Super().ComputeDisplayName()
 }
 
@@ -304,9 +435,16 @@ func partner_ComputeCommercialCompanyName(rs m.PartnerSet) m.PartnerData {
 	return h.Partner().NewData().SetCommercialCompanyName(commPartnerName)
 }
 
-// GetDefaultImage returns a default image for the partner (base64 encoded)
+// GetDefaultImage returns a default image for the partner (base64 encoded).
+// Placeholders are taken from the DefaultPartnerImage method of the company
+// of the current user, and no image is returned if this company has disabled
+// default images.
 func partner_GetDefaultImage(rs m.PartnerSet, partnerType string, isCompany bool, Parent m.PartnerSet) string {
-	if rs.Env().Context().HasKey("install_mode") {
+	if ContextHasKey(rs.Env(), ContextKeyInstallMode) {
+		return ""
+	}
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	if company.IsNotEmpty() && company.AsSuperUser("read default partner images policy").DefaultPartnerImages() == DefaultPartnerImagesNone {
 		return ""
 	}
 	var img string
@@ -332,13 +470,8 @@ func partner_GetDefaultImage(rs m.PartnerSet, partnerType string, isCompany bool
 			imgFileName = "avatar.png"
 			colorize = true
 		}
-		path := filepath.Join(server.ResourceDir, "static", "base", "src", "img", imgFileName)
-		content, err := ioutil.ReadFile(path)
-		if err != nil {
-			log.Warn("error while loading ressource", "image", path, "error", err)
-		}
-		img = base64.StdEncoding.EncodeToString(content)
-		if colorize {
+		img = company.DefaultPartnerImage(imgFileName)
+		if colorize && img != "" {
 			img = b64image.Colorize(img, color.RGBA{})
 		}
 	}
@@ -347,8 +480,8 @@ func partner_GetDefaultImage(rs m.PartnerSet, partnerType string, isCompany bool
 
 // CheckParent checks for recursion in the partners parenthood
 func partner_CheckParent(rs m.PartnerSet) {
-	if !rs.CheckRecursion() {
-		log.Panic(rs.T("You cannot create recursive Partner hierarchies."))
+	if cycle := rs.ParentCyclePath(h.Partner().Fields().Parent()); len(cycle) > 0 {
+		panic(NewValidationError(rs, []string{"Parent"}, "You cannot create recursive Partner hierarchies: %s", strings.Join(cycle, " → ")))
 	}
 }
 
@@ -385,7 +518,7 @@ func partner_OnchangeParent(rs m.PartnerSet) m.PartnerData {
 
 // OnchangeParentWarning issues a warning when trying to change a contact to another parent company
 func partner_OnchangeParentWarning(rs m.PartnerSet) string {
-	origin, ok := rs.Env().Context().Get("hexya_onchange_origin").(m.PartnerData)
+	origin, ok := ContextGet(rs.Env(), ContextKeyOnchangeOrigin).(m.PartnerData)
 	if ok && origin.Parent().IsNotEmpty() && !origin.Parent().Equals(rs.Parent()) {
 		return rs.T(`Changing the company of a contact should only be done if it
 was never correctly set. If an existing contact starts working for a new
@@ -407,12 +540,44 @@ func partner_OnchangeCountryFilters(rs m.PartnerSet) map[models.FieldName]models
 
 // OnchangeEmail updates the user Gravatar image
 func partner_OnchangeEmail(rs m.PartnerSet) m.PartnerData {
-	if rs.Image() != "" || rs.Email() == "" || rs.Env().Context().HasKey("no_gravatar") {
+	if rs.Image() != "" || rs.Email() == "" || ContextHasKey(rs.Env(), ContextKeyNoGravatar) {
 		return h.Partner().NewData()
 	}
 	return h.Partner().NewData().SetImage(rs.GetGravatarImage(rs.Email()))
 }
 
+// FormatNameWithTitle returns the name of this partner with its title placed
+// according to the title's position or to the conventions of the partner's language.
+// If abbreviated is true, the title's Shortcut is used when it is set.
+func partner_FormatNameWithTitle(rs m.PartnerSet, abbreviated bool) string {
+	rs.EnsureOne()
+	if rs.Title().IsEmpty() || rs.IsCompany() {
+		return rs.Name()
+	}
+	lang := rs.Lang()
+	if lang == "" {
+		lang = ContextGetString(rs.Env(), ContextKeyLang)
+	}
+	title := cachedTitle(rs.Title().WithContext(ContextKeyLang, lang))
+	titleName := title.Name
+	if abbreviated && title.Shortcut != "" {
+		titleName = title.Shortcut
+	}
+	after := title.Position == "after"
+	if title.Position == "auto" && len(lang) >= 2 {
+		after = TitleAfterNameLangs[lang[:2]]
+	}
+	if after {
+		return fmt.Sprintf("%s %s", rs.Name(), titleName)
+	}
+	return fmt.Sprintf("%s %s", titleName, rs.Name())
+}
+
+// ComputeNameWithTitle computes the name of the partner with its abbreviated title
+func partner_ComputeNameWithTitle(rs m.PartnerSet) m.PartnerData {
+	return h.Partner().NewData().SetNameWithTitle(rs.FormatNameWithTitle(true))
+}
+
 // ComputeEmailFormatted returns a 'Name <email@domain>' formatted string
 func partner_ComputeEmailFormatted(rs m.PartnerSet) m.PartnerData {
 	addr := mail.Address{Name: rs.Name(), Address: rs.Email()}
@@ -466,6 +631,9 @@ func partner_AddressFields(_ m.PartnerSet) []models.FieldName {
 
 // UpdateAddress updates this PartnerSet only with the address fields of
 // the given vals. Other values passed are discarded.
+//
+// Partners whose address must not be synchronised (see AcceptsAddressSync)
+// are left unchanged.
 func partner_UpdateAddress(rs m.PartnerSet, vals m.PartnerData) bool {
 	res := h.Partner().NewData()
 	for _, addrField := range rs.AddressFields() {
@@ -476,7 +644,20 @@ func partner_UpdateAddress(rs m.PartnerSet, vals m.PartnerData) bool {
 	if len(res.Keys()) == 0 {
 		return false
 	}
-	return rs.WithContext("goto_super", true).Write(res)
+	targets := rs.Filtered(func(r m.PartnerSet) bool {
+		return r.AcceptsAddressSync()
+	})
+	if targets.IsEmpty() {
+		return false
+	}
+	return targets.WithoutSync().Write(res)
+}
+
+// WithoutSync returns a copy of this PartnerSet on which Write will not
+// trigger the synchronisation of commercial and address fields with
+// parents and children.
+func partner_WithoutSync(rs m.PartnerSet) m.PartnerSet {
+	return rs.WithContext(ContextKeyPartnerSkipSync, true)
 }
 
 // CommercialFields returns the list of fields that are managed by the commercial entity
@@ -501,20 +682,85 @@ func partner_CommercialSyncFromCompany(rs m.PartnerSet) bool {
 	return rs.Write(values)
 }
 
-// CommercialSyncToChildren handle sync of commercial fields to descendants
-func partner_CommercialSyncToChildren(rs m.PartnerSet) bool {
-	partnerData := rs.CommercialPartner().UpdateFieldValues(rs.CommercialFields()...)
-	syncChildren := rs.Children().Filtered(func(rs m.PartnerSet) bool {
-		return !rs.IsCompany()
+// commercialDescendants returns the descendants of this partner that belong
+// to its commercial entity.
+//
+// Descendants are walked breadth-first within the company boundaries (i.e. we
+// do not go down into children that are companies themselves). Partners that
+// are met twice (cycles that slipped past CheckParent) are skipped and
+// reported in the log.
+func commercialDescendants(rs m.PartnerSet) m.PartnerSet {
+	res := h.Partner().NewSet(rs.Env())
+	visited := map[int64]bool{rs.ID(): true}
+	// Walk the hierarchy level by level, so that each level is fetched at once
+	level := rs.Children()
+	for level.IsNotEmpty() {
+		level.Prefetch("IsCompany", "CommercialPartner", "Children")
+		nextLevel := h.Partner().NewSet(rs.Env())
+		for _, child := range level.Records() {
+			if child.IsCompany() {
+				continue
+			}
+			if visited[child.ID()] {
+				log.Warn("Cycle detected in partner hierarchy while syncing commercial fields",
+					"partner", rs.ID(), "child", child.ID())
+				continue
+			}
+			visited[child.ID()] = true
+			res = res.Union(child)
+			nextLevel = nextLevel.Union(child.Children())
+		}
+		level = nextLevel
+	}
+	return res
+}
+
+// CommercialInconsistencies returns the descendants of this partner within
+// its commercial entity whose CommercialPartner is not the commercial entity
+// of this partner.
+func partner_CommercialInconsistencies(rs m.PartnerSet) m.PartnerSet {
+	rs.EnsureOne()
+	return commercialInconsistencies(commercialDescendants(rs), rs.CommercialPartner())
+}
+
+// commercialInconsistencies returns the partners of descendants that have a
+// CommercialPartner which is not the given commercialPartner. Partners with
+// no CommercialPartner yet are not considered inconsistent.
+func commercialInconsistencies(descendants, commercialPartner m.PartnerSet) m.PartnerSet {
+	return descendants.Filtered(func(r m.PartnerSet) bool {
+		return r.CommercialPartner().IsNotEmpty() && !r.CommercialPartner().Equals(commercialPartner)
 	})
+}
+
+// CommercialSyncToChildren handle sync of commercial fields to descendants.
+//
+// All descendants within the company boundaries are written in a single call.
+// Descendants whose CommercialPartner was inconsistent with this partner's
+// are reported as a warning in the application log before being fixed, so
+// that the data that led to them can be reviewed.
+func partner_CommercialSyncToChildren(rs m.PartnerSet) bool {
+	commercialPartner := rs.CommercialPartner()
+	partnerData := commercialPartner.UpdateFieldValues(rs.CommercialFields()...)
+	partnerData.SetCommercialPartner(commercialPartner)
+
+	syncChildren := commercialDescendants(rs)
 	if syncChildren.IsEmpty() {
 		return false
 	}
-	for _, child := range syncChildren.Records() {
-		child.CommercialSyncToChildren()
+	for _, partner := range commercialInconsistencies(syncChildren, commercialPartner).Records() {
+		LogEvent(rs.Env(), LogEntry{
+			Level:  LoggingWarning,
+			Logger: "partner.commercial_sync",
+			Message: fmt.Sprintf("Commercial partner of %s was %s instead of %s",
+				partner.Name(), partner.CommercialPartner().Name(), commercialPartner.Name()),
+			Func:  "Partner.CommercialSyncToChildren",
+			Model: "Partner",
+			ResID: partner.ID(),
+		})
 	}
-	partnerData.SetCommercialPartner(rs.CommercialPartner())
-	return syncChildren.WithContext("hexya_force_compute_write", true).Write(partnerData)
+
+	// All descendants are written here, so we must not let Write sync them again.
+	return syncChildren.WithoutSync().WithContext(ContextKeyForceComputeWrite, true).Write(partnerData)
 }
 
 // FieldsSync syncs commercial fields and address fields from company and to children after create/update,
@@ -543,7 +789,7 @@ func partner_FieldsSync(rs m.PartnerSet, vals m.PartnerData) {
 			}
 		}
 	}
-	personChildren := rs.Children().Filtered(func(rs m.PartnerSet) bool {
+	personChildren := rs.Children().Prefetch("IsCompany", "CommercialPartner").Filtered(func(rs m.PartnerSet) bool {
 		return !rs.IsCompany()
 	})
 	for _, child := range personChildren.Records() {
@@ -590,10 +836,10 @@ func partner_HandleFirsrtContactCreation(rs m.PartnerSet) {
 }
 
 // CleanWebsite returns a cleaned website url including scheme.
-func partner_CleanWebsite(_ m.PartnerSet, website string) string {
+func partner_CleanWebsite(rs m.PartnerSet, website string) string {
 	websiteURL, err := url.Parse(website)
 	if err != nil {
-		panic(fmt.Errorf("invalid URL for website: %s. %s", website, err))
+		panic(NewValidationError(rs, []string{"Website"}, "Invalid URL for website: %s. %s", website, err))
 	}
 	if websiteURL.Scheme == "" {
 		websiteURL.Scheme = "http"
@@ -601,8 +847,80 @@ func partner_CleanWebsite(_ m.PartnerSet, website string) string {
 	return websiteURL.String()
 }
 
+// SearchCategoriesWithDescendants returns true if searching partners by
+// category should also match partners tagged with any descendant category.
+//
+// This is enabled by default and can be disabled with the
+// 'base.partner_category_search_descendants' config parameter or for a
+// single search with the 'category_search_descendants' context key. The
+// config parameter is read through the reference cache, since this method
+// is called by each partner search on categories.
+func partner_SearchCategoriesWithDescendants(rs m.PartnerSet) bool {
+	if ContextHasKey(rs.Env(), ContextKeyCategorySearchDescendants) {
+		return ContextGetBool(rs.Env(), ContextKeyCategorySearchDescendants)
+	}
+	param := CachedReference(rs.Env(), "ConfigParameter", "base.partner_category_search_descendants", func() interface{} {
+		return h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read partner category search setting").
+			GetParam("base.partner_category_search_descendants", "true")
+	}).(string)
+	res, err := strconv.ParseBool(param)
+	if err != nil {
+		log.Warn("Invalid value for config parameter", "key", "base.partner_category_search_descendants", "value", param)
+		return true
+	}
+	return res
+}
+
+func partner_Search(rs m.PartnerSet, cond q.PartnerCondition) m.PartnerSet {
+	if !cond.HasField(h.Partner().Fields().Categories()) || !rs.SearchCategoriesWithDescendants() {
+		return rs.Super().Search(cond)
+	}
+	predicates := cond.PredicatesWithField(h.Partner().Fields().Categories())
+	for i, pred := range predicates {
+		switch pred.Operator() {
+		case operator.Equals, operator.In:
+		default:
+			continue
+		}
+		var ids []int64
+		switch arg := pred.Argument().(type) {
+		case int64:
+			ids = []int64{arg}
+		case []int64:
+			ids = arg
+		case models.RecordSet:
+			ids = arg.Ids()
+		default:
+			continue
+		}
+		tags := h.PartnerCategory().Browse(rs.Env(), ids).WithDescendants()
+		predicates[i].AlterOperator(operator.In)
+		predicates[i].AlterArgument(tags.Ids())
+	}
+	return rs.Super().Search(cond)
+}
+
+// partnerUsersCompanyConflicts returns a ValidationError listing the users of the
+// given partners whose company is not the given company, or nil if there is
+// none. The users are fetched with a single query for all the partners.
+func partnerUsersCompanyConflicts(rs m.PartnerSet, company m.CompanySet) error {
+	conflicting := h.User().NewSet(rs.Env()).AsSuperUser("check partner users company").Search(
+		q.User().Partner().In(rs).And().Company().NotEquals(company)).
+		OrderBy("Company", "Name")
+	if conflicting.IsEmpty() {
+		return nil
+	}
+	conflicts := make([]string, len(conflicting.Records()))
+	for i, user := range conflicting.Records() {
+		conflicts[i] = fmt.Sprintf("%s (%s)", user.Name(), user.Company().Name())
+	}
+	return NewValidationError(rs, []string{"Company"},
+		"You can not set the company %s on these partners as they are linked to users of other companies: %s",
+		company.Name(), strings.Join(conflicts, ", "))
+}
+
 func partner_Write(rs m.PartnerSet, vals m.PartnerData) bool {
-	if rs.Env().Context().HasKey("goto_super") {
+	if ContextGetBool(rs.Env(), ContextKeyPartnerSkipSync) {
 		return rs.Super().Write(vals)
 	}
 	if vals.Website() != "" {
@@ -611,21 +929,31 @@ func partner_Write(rs m.PartnerSet, vals m.PartnerData) bool {
 	if !vals.Parent().IsEmpty() {
 		vals.SetCompanyName("")
 	}
+	if vals.HasEmail() {
+		resetEmailBounces(vals)
+	}
 	// Partner must only allow to set the Company of a partner if it
 	// is the same as the Company of all users that inherit from this partner
 	// (this is to allow the code from User to write to the Partner!) or
 	// if setting the Company to nil (this is compatible with any user
 	// company)
 	if !vals.Company().IsEmpty() {
+		if err := partnerUsersCompanyConflicts(rs, vals.Company()); err != nil {
+			panic(err)
+		}
+	}
+	categories := h.PartnerCategory().NewSet(rs.Env())
+	if vals.HasCategories() || vals.HasActive() {
 		for _, partner := range rs.Records() {
-			for _, user := range partner.Users().Records() {
-				if !user.Company().Equals(vals.Company()) {
-					log.Panic(rs.T("You can not change the company as the partner/user has multiple users linked with different companies.", "company", vals.Company().Name()))
-				}
-			}
+			categories = categories.Union(partner.Categories())
 		}
+		categories = categories.Union(vals.Categories())
 	}
 	res := rs.Super().Write(vals)
+	categories.RefreshPartnerCount()
+	if addressChanged(rs, vals) {
+		rs.EnqueueAddressValidation()
+	}
 	for _, partner := range rs.Records() {
 		for _, user := range partner.Users().Records() {
 			if user.HasGroup("base_group_user") {
@@ -648,12 +976,41 @@ func partner_Create(rs m.PartnerSet, vals m.PartnerData) m.PartnerSet {
 	if vals.Image() == "" {
 		vals.SetImage(rs.GetDefaultImage(vals.Type(), vals.IsCompany(), vals.Parent()))
 	}
+	if !vals.HasLang() {
+		if lang := detectPartnerLang(rs.Env()); lang != "" {
+			vals.SetLang(lang)
+		}
+	}
+	if vals.TZ() == "" {
+		if tz := suggestTimezone(vals.Country(), vals.State()); tz != "" {
+			vals.SetTZ(tz)
+		}
+	}
 	partner := rs.Super().Create(vals)
+	if vals.HasCategories() {
+		partner.Categories().RefreshPartnerCount()
+	}
+	if addressChanged(partner, vals) {
+		partner.EnqueueAddressValidation()
+	}
 	partner.FieldsSync(vals)
 	partner.HandleFirsrtContactCreation()
+	if vals.User().IsEmpty() && !ContextGetBool(rs.Env(), ContextKeyInstallMode) {
+		partner.ApplyAssignmentRules()
+	}
 	return partner
 }
 
+func partner_Unlink(rs m.PartnerSet) int64 {
+	categories := h.PartnerCategory().NewSet(rs.Env())
+	for _, partner := range rs.Records() {
+		categories = categories.Union(partner.Categories())
+	}
+	res := rs.Super().Unlink()
+	categories.RefreshPartnerCount()
+	return res
+}
+
 // CreateCompany creates the parent company of this partner if it has been given a CompanyName.
 func partner_CreateCompany(rs m.PartnerSet) bool {
 	rs.EnsureOne()
@@ -712,33 +1069,65 @@ func partner_NameGet(rs m.PartnerSet) string {
 			name = fmt.Sprintf("%s, %s", rs.CommercialCompanyName(), name)
 		}
 	}
-	if rs.Env().Context().GetBool("show_address_only") {
-		name = rs.DisplayAddress(true)
+	htmlFormat := ContextGetBool(rs.Env(), ContextKeyHTMLFormat)
+	mode, sep := AddressModePlain, "\n"
+	if htmlFormat {
+		name = html.EscapeString(name)
+		mode, sep = AddressModeHTML, "<br/>"
 	}
-	if rs.Env().Context().GetBool("show_address") {
-		name = name + "\n" + rs.DisplayAddress(true)
+	if ContextGetBool(rs.Env(), ContextKeyShowAddressOnly) {
+		name = rs.DisplayAddressMode(mode, true)
+	}
+	if ContextGetBool(rs.Env(), ContextKeyShowAddress) {
+		name = name + sep + rs.DisplayAddressMode(mode, true)
 	}
 	name = strings.Replace(name, "\n\n", "\n", -1)
 	name = strings.Replace(name, "\n\n", "\n", -1)
-	if rs.Env().Context().GetBool("show_email") && rs.Email() != "" {
+	if ContextGetBool(rs.Env(), ContextKeyShowEmail) && rs.Email() != "" {
 		name = rs.EmailFormatted()
-	}
-	if rs.Env().Context().GetBool("html_format") {
-		name = strings.Replace(name, "\n", "<br/>", -1)
+		if htmlFormat {
+			name = html.EscapeString(name)
+		}
 	}
 	return name
 }
 
+// NameSearchFields returns the fields in which SearchByName looks for each word of the searched name
+func partner_NameSearchFields(_ m.PartnerSet) models.FieldNames {
+	return models.FieldNames{
+		h.Partner().Fields().Name(),
+		h.Partner().Fields().Email(),
+		h.Partner().Fields().Ref(),
+		h.Partner().Fields().CommercialCompanyName(),
+		h.Partner().Fields().GLN(),
+		h.Partner().Fields().DUNS(),
+	}
+}
+
 func partner_SearchByName(rs m.PartnerSet, name string, op operator.Operator, additionalCond q.PartnerCondition, limit int) m.PartnerSet {
 	if name == "" {
 		return rs.Super().SearchByName(name, op, additionalCond, limit)
 	}
 	var cond q.PartnerCondition
 	switch op {
-	case operator.Equals, operator.Contains, operator.IContains, operator.Like, operator.ILike:
+	case operator.Contains, operator.IContains:
+		// Multi-word substring searches are handled word by word by BaseMixin
+		if len(strings.Fields(name)) > 1 {
+			return rs.Super().SearchByName(name, op, additionalCond, limit)
+		}
+		fallthrough
+	case operator.Equals, operator.Like, operator.ILike:
+		// Exact searches must match the whole Email or Ref, even with several words
 		cond = q.Partner().Name().AddOperator(op, name).Or().
 			Email().AddOperator(op, name).Or().
-			Ref().AddOperator(op, name)
+			Ref().AddOperator(op, name).Or().
+			GLN().AddOperator(op, name).Or().
+			DUNS().AddOperator(op, name)
+	default:
+		return rs.Super().SearchByName(name, op, additionalCond, limit)
+	}
+	if !additionalCond.Underlying().IsEmpty() {
+		cond = cond.AndCond(additionalCond)
 	}
 	return rs.Search(cond).Limit(limit)
 }
@@ -758,25 +1147,9 @@ func partner_ParsePartnerName(_ m.PartnerSet, email string) (string, string) {
 }
 
 // NameCreate creates a partner from a single string which may be a name and/or an email.
-//
-// If only an email address is received and that the regex cannot find
-// a name, the name will have the email value.
-// If 'force_email' key in context: must find the email address.
+// The partner data is built by NameCreateData.
 func partner_NameCreate(rs m.PartnerSet, name string) m.PartnerSet {
-	name, email := rs.ParsePartnerName(name)
-	if email == "" && rs.Env().Context().GetBool("force_email") {
-		panic(rs.T("Couldn't create contact without email address!"))
-	}
-	if name == "" && email != "" {
-		name = email
-	}
-	if email == "" {
-		email = rs.Env().Context().GetString("default_email")
-	}
-	partner := h.Partner().Create(rs.Env(), h.Partner().NewData().
-		SetName(name).
-		SetEmail(email))
-	return partner
+	return h.Partner().Create(rs.Env(), rs.NameCreateData(name))
 }
 
 // FindOrCreate finds a partner with the given 'email' or creates one.
@@ -812,6 +1185,53 @@ func partner_GetGravatarImage(_ m.PartnerSet, email string) string {
 	return base64.StdEncoding.EncodeToString(img)
 }
 
+// EmailBlacklist returns the set of (lower case) email addresses that must
+// never receive mass mailings. Base implementation returns the addresses of
+// the partners blacklisted after too many hard bounces.
+//
+// Extend this method in mailing addons to plug in an opt-out list.
+func partner_EmailBlacklist(rs m.PartnerSet) map[string]bool {
+	res := make(map[string]bool)
+	for _, partner := range h.Partner().NewSet(rs.Env()).AsSuperUser("read blacklisted emails").Search(q.Partner().EmailBlacklisted().Equals(true)).Records() {
+		res[strings.ToLower(strings.TrimSpace(partner.Email()))] = true
+	}
+	return res
+}
+
+// GetEmailRecipients returns the formatted email addresses of these partners
+// grouped by partner language, for use by mass mailing addons.
+//
+// Partners without valid email or whose email is blacklisted are discarded.
+// Each email address is returned only once. When several partners share the
+// same address (typically contacts using their company's email), the
+// commercial entity is preferred.
+func partner_GetEmailRecipients(rs m.PartnerSet) map[string][]string {
+	blacklist := rs.EmailBlacklist()
+	var emails []string
+	recipients := make(map[string]m.PartnerSet)
+	for _, partner := range rs.Records() {
+		email := strings.ToLower(strings.TrimSpace(partner.Email()))
+		if email == "" || blacklist[email] || !emailutils.IsValidAddress(email) {
+			continue
+		}
+		existing, ok := recipients[email]
+		if !ok {
+			emails = append(emails, email)
+			recipients[email] = partner
+			continue
+		}
+		if !existing.Equals(existing.CommercialPartner()) && partner.Equals(partner.CommercialPartner()) {
+			recipients[email] = partner
+		}
+	}
+	res := make(map[string][]string)
+	for _, email := range emails {
+		partner := recipients[email]
+		res[partner.Lang()] = append(res[partner.Lang()], partner.EmailFormatted())
+	}
+	return res
+}
+
 // AddressGet finds contacts/addresses of the right type(s) by doing a depth-first-search
 // through descendants within company boundaries (stop at entities flagged 'IsCompany')
 // then continuing the search at the ancestors that are within the same company boundaries.
@@ -827,6 +1247,7 @@ func partner_AddressGet(rs m.PartnerSet, addrTypes []string) map[string]m.Partne
 	atMap["contact"] = true
 	result := make(map[string]m.PartnerSet)
 	visited := make(map[int64]bool)
+	rs.Prefetch("Type", "IsCompany", "Parent", "Children.Type", "Children.IsCompany", "Children.Children")
 	for _, partner := range rs.Records() {
 		currentPartner := partner
 		for !currentPartner.IsEmpty() {
@@ -867,22 +1288,44 @@ func partner_AddressGet(rs m.PartnerSet, addrTypes []string) map[string]m.Partne
 	return result
 }
 
+// Address display modes of DisplayAddressMode
+const (
+	// AddressModePlain renders the address on several lines of plain text
+	AddressModePlain = "plain"
+	// AddressModeHTML renders the address as HTML, with escaped data and <br/> line breaks
+	AddressModeHTML = "html"
+	// AddressModeOneLine renders the address on a single comma separated line
+	AddressModeOneLine = "oneline"
+)
+
 // DisplayAddress builds and returns an address formatted accordingly to the
 // standards of the country where it belongs.`,
 func partner_DisplayAddress(rs m.PartnerSet, withoutCompany bool) string {
-	addressFormat := rs.Country().AddressFormat()
+	return rs.DisplayAddressMode(AddressModePlain, withoutCompany)
+}
+
+// DisplayAddressMode builds and returns an address formatted accordingly to the
+// standards of the country where it belongs, rendered with the given mode
+// (AddressModePlain, AddressModeHTML or AddressModeOneLine).
+//
+// Blank lines are removed. In HTML mode, the address is escaped, so that it can
+// safely be embedded in HTML documents.
+func partner_DisplayAddressMode(rs m.PartnerSet, mode string, withoutCompany bool) string {
+	country := cachedCountry(rs.Country())
+	state := cachedState(rs.State())
+	addressFormat := country.AddressFormat
 	if addressFormat == "" {
-		addressFormat = "{{ .Street }}\n{{ .Street2 }}\n{{ .City }} {{ .StateCode }} {{ .Zip }}\n{{ .CountryName}}"
+		addressFormat = DefaultAddressFormat
 	}
 	data := basetypes.AddressData{
 		Street:      rs.Street(),
 		Street2:     rs.Street2(),
 		City:        rs.City(),
 		Zip:         rs.Zip(),
-		StateCode:   rs.State().Code(),
-		StateName:   rs.State().Name(),
-		CountryCode: rs.Country().Code(),
-		CountryName: rs.Country().Name(),
+		StateCode:   state.Code,
+		StateName:   state.Name,
+		CountryCode: country.Code,
+		CountryName: country.Name,
 		CompanyName: rs.CommercialCompanyName(),
 	}
 	if withoutCompany {
@@ -891,13 +1334,29 @@ func partner_DisplayAddress(rs m.PartnerSet, withoutCompany bool) string {
 	if data.CompanyName != "" {
 		addressFormat = "{{ .CompanyName }}\n" + addressFormat
 	}
-	addressTemplate := template.Must(template.New("").Parse(addressFormat))
-	var buf bytes.Buffer
-	err := addressTemplate.Execute(&buf, data)
+	address, err := DefaultTemplateSandbox.Execute(addressFormat, data)
 	if err != nil {
-		log.Panic("Error while parsing address", "format", addressFormat, "data", data)
+		// Address formats are user editable, so we fall back on the default format
+		log.Warn("Error while rendering address", "format", addressFormat, "partner", rs.ID(), "error", err)
+		address, _ = DefaultTemplateSandbox.Execute(DefaultAddressFormat, data)
+	}
+	var lines []string
+	for _, line := range strings.Split(address, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	switch mode {
+	case AddressModeHTML:
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+		return strings.Join(lines, "<br/>")
+	case AddressModeOneLine:
+		return strings.Join(lines, ", ")
+	default:
+		return RemoveBlankLines(address)
 	}
-	return buf.String()
 }
 
 var fields_PartnerIndustry = map[string]models.FieldDefinition{
@@ -908,18 +1367,30 @@ var fields_PartnerIndustry = map[string]models.FieldDefinition{
 
 func init() {
 	models.NewModel("PartnerTitle")
-	h.PartnerTitle().SetDefaultOrder("Name")
+	h.PartnerTitle().InheritModel(h.SequenceMixin())
+	h.PartnerTitle().InheritModel(h.ReferenceCacheMixin())
+	h.PartnerTitle().SetDefaultOrder("Sequence", "Name")
 	h.PartnerTitle().AddFields(fields_PartnerTitle)
 
 	models.NewModel("PartnerCategory")
+	h.PartnerCategory().InheritModel(h.SequenceMixin())
+	h.PartnerCategory().InheritModel(h.ColorMixin())
+	h.PartnerCategory().SetDefaultOrder("Sequence", "Name")
 	h.PartnerCategory().AddFields(fields_PartnerCategory)
 
 	h.PartnerCategory().NewMethod("CheckParent", partnerCategory_CheckParent)
+	h.PartnerCategory().NewMethod("ComputePartnerCount", partnerCategory_ComputePartnerCount)
+	h.PartnerCategory().NewMethod("RefreshPartnerCount", partnerCategory_RefreshPartnerCount)
+	h.PartnerCategory().NewMethod("Merge", partnerCategory_Merge)
+	h.PartnerCategory().NewMethod("WithDescendants", partnerCategory_WithDescendants)
+	h.PartnerCategory().Methods().Create().Extend(partnerCategory_Create)
 	h.PartnerCategory().Methods().NameGet().Extend(partnerCategory_NAmeGet)
 	h.PartnerCategory().Methods().SearchByName().Extend(partnerCategory_SearchByName)
 
 	models.NewModel("Partner")
 	h.Partner().InheritModel(h.ImageMixin())
+	h.Partner().InheritModel(h.ColorMixin())
+	h.Partner().InheritModel(h.CustomFieldMixin())
 	h.Partner().SetDefaultOrder("DisplayName")
 
 	h.Partner().AddFields(fields_Partner)
@@ -946,31 +1417,42 @@ func init() {
 	h.Partner().NewMethod("OnchangeCountryFilters", partner_OnchangeCountryFilters)
 	h.Partner().NewMethod("OnchangeEmail", partner_OnchangeEmail)
 	h.Partner().NewMethod("ComputeEmailFormatted", partner_ComputeEmailFormatted)
+	h.Partner().NewMethod("FormatNameWithTitle", partner_FormatNameWithTitle)
+	h.Partner().NewMethod("ComputeNameWithTitle", partner_ComputeNameWithTitle)
 	h.Partner().NewMethod("ComputeCompanyType", partner_ComputeIsCompany)
 	h.Partner().NewMethod("InverseCompanyType", partner_InverseCompanyType)
 	h.Partner().NewMethod("OnchangeCompanyType", partner_OnchangeCompanyType)
 	h.Partner().NewMethod("UpdateFieldValues", partner_UpdateFieldValues)
 	h.Partner().NewMethod("AddressFields", partner_AddressFields)
 	h.Partner().NewMethod("UpdateAddress", partner_UpdateAddress)
+	h.Partner().NewMethod("WithoutSync", partner_WithoutSync)
 	h.Partner().NewMethod("CommercialFields", partner_CommercialFields)
 	h.Partner().NewMethod("CommercialSyncFromCompany", partner_CommercialSyncFromCompany)
 	h.Partner().NewMethod("CommercialSyncToChildren", partner_CommercialSyncToChildren)
+	h.Partner().NewMethod("CommercialInconsistencies", partner_CommercialInconsistencies)
 	h.Partner().NewMethod("FieldsSync", partner_FieldsSync)
 	h.Partner().NewMethod("HandleFirsrtContactCreation", partner_HandleFirsrtContactCreation)
 	h.Partner().NewMethod("CleanWebsite", partner_CleanWebsite)
+	h.Partner().NewMethod("SearchCategoriesWithDescendants", partner_SearchCategoriesWithDescendants)
+	h.Partner().Methods().Search().Extend(partner_Search)
 	h.Partner().Methods().Write().Extend(partner_Write)
+	h.Partner().Methods().Unlink().Extend(partner_Unlink)
 	h.Partner().Methods().Create().Extend(partner_Create)
 	h.Partner().NewMethod("CreateCompany", partner_CreateCompany)
 	h.Partner().NewMethod("OpenCommercialEntity", partner_OpenCommercialEntity)
 	h.Partner().NewMethod("OpenParent", partner_OpenParent)
 	h.Partner().Methods().NameGet().Extend(partner_NameGet)
+	h.Partner().NewMethod("NameSearchFields", partner_NameSearchFields)
 	h.Partner().Methods().SearchByName().Extend(partner_SearchByName)
 	h.Partner().NewMethod("ParsePartnerName", partner_ParsePartnerName)
 	h.Partner().NewMethod("NameCreate", partner_NameCreate)
 	h.Partner().NewMethod("FindOrCreate", partner_FindOrCreate)
 	h.Partner().NewMethod("GetGravatarImage", partner_GetGravatarImage)
+	h.Partner().NewMethod("EmailBlacklist", partner_EmailBlacklist)
+	h.Partner().NewMethod("GetEmailRecipients", partner_GetEmailRecipients)
 	h.Partner().NewMethod("AddressGet", partner_AddressGet)
 	h.Partner().NewMethod("DisplayAddress", partner_DisplayAddress)
+	h.Partner().NewMethod("DisplayAddressMode", partner_DisplayAddressMode)
 
 	models.NewModel("PartnerIndustry")
 	h.PartnerIndustry().AddFields(fields_PartnerIndustry)
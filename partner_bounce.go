@@ -0,0 +1,189 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/okoo/src/tools/emailutils"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// EmailBounceLimit is the default number of hard bounces after which a partner
+// is blacklisted. It can be changed with the 'base.email_bounce_limit' config parameter.
+const EmailBounceLimit = 3
+
+// A DSNRecipient is the delivery status of a recipient of a Delivery Status
+// Notification (RFC 3464).
+type DSNRecipient struct {
+	Recipient string
+	Action    string
+	Status    string
+}
+
+// IsHard returns true if this recipient permanently failed
+func (r DSNRecipient) IsHard() bool {
+	return r.Action == "failed" && strings.HasPrefix(r.Status, "5")
+}
+
+// IsSoft returns true if this recipient temporarily failed
+func (r DSNRecipient) IsSoft() bool {
+	return r.Action == "delayed" || (r.Action == "failed" && strings.HasPrefix(r.Status, "4"))
+}
+
+// ParseDSN returns the recipients of the given Delivery Status Notification.
+// It returns false if msg is not a DSN, i.e. a multipart/report message
+// with a message/delivery-status part.
+func ParseDSN(msg *mail.Message) ([]DSNRecipient, bool) {
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["boundary"] == "" {
+		return nil, false
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, false
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType != "message/delivery-status" {
+			continue
+		}
+		return parseDeliveryStatus(part), true
+	}
+}
+
+// parseDeliveryStatus parses the fields of a message/delivery-status part.
+// The first group of fields is about the message and the next ones are
+// about each recipient.
+func parseDeliveryStatus(r io.Reader) []DSNRecipient {
+	tp := textproto.NewReader(bufio.NewReader(r))
+	var res []DSNRecipient
+	for {
+		header, err := tp.ReadMIMEHeader()
+		if recipient := header.Get("Final-Recipient"); recipient != "" {
+			if semicolon := strings.Index(recipient, ";"); semicolon >= 0 {
+				recipient = recipient[semicolon+1:]
+			}
+			res = append(res, DSNRecipient{
+				Recipient: strings.ToLower(strings.Trim(strings.TrimSpace(recipient), "<>")),
+				Action:    strings.ToLower(strings.TrimSpace(header.Get("Action"))),
+				Status:    strings.TrimSpace(header.Get("Status")),
+			})
+		}
+		if err != nil {
+			return res
+		}
+	}
+}
+
+// resetEmailBounces resets the bounce counters in the given data,
+// typically because the email address is changed.
+func resetEmailBounces(vals m.PartnerData) {
+	if !vals.HasEmailBounceCount() {
+		vals.SetEmailBounceCount(0)
+	}
+	if !vals.HasEmailSoftBounceCount() {
+		vals.SetEmailSoftBounceCount(0)
+	}
+	if !vals.HasEmailBlacklisted() {
+		vals.SetEmailBlacklisted(false)
+	}
+}
+
+// emailScore returns the deliverability score of an address with the given bounces
+func emailScore(email string, hard, soft int64, blacklisted bool) int64 {
+	if blacklisted || !emailutils.IsValidAddress(strings.TrimSpace(email)) {
+		return 0
+	}
+	score := 100 - 30*hard - 5*soft
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// ComputeEmailScore computes the deliverability score of the email address of the partner
+func partner_ComputeEmailScore(rs m.PartnerSet) m.PartnerData {
+	return h.Partner().NewData().SetEmailScore(
+		emailScore(rs.Email(), rs.EmailBounceCount(), rs.EmailSoftBounceCount(), rs.EmailBlacklisted()))
+}
+
+// EmailBounceLimit returns the number of hard bounces after which a partner is blacklisted
+func partner_EmailBounceLimit(rs m.PartnerSet) int64 {
+	param := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read email bounce limit").GetParam("base.email_bounce_limit",
+		strconv.Itoa(EmailBounceLimit))
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil {
+		log.Warn("Invalid email bounce limit", "value", param)
+		limit = EmailBounceLimit
+	}
+	return limit
+}
+
+// RegisterEmailBounce records a delivery failure to the email address of these
+// partners. Partners are blacklisted when their hard bounces reach EmailBounceLimit.
+func partner_RegisterEmailBounce(rs m.PartnerSet, hard bool) {
+	limit := rs.EmailBounceLimit()
+	for _, partner := range rs.AsSuperUser("record email bounce").Records() {
+		data := h.Partner().NewData().SetEmailLastBounce(dates.Now())
+		if !hard {
+			partner.Write(data.SetEmailSoftBounceCount(partner.EmailSoftBounceCount() + 1))
+			continue
+		}
+		count := partner.EmailBounceCount() + 1
+		data.SetEmailBounceCount(count)
+		if count >= limit && !partner.EmailBlacklisted() {
+			log.Info("Blacklisting bouncing email address", "partner", partner.ID(), "bounces", count)
+			data.SetEmailBlacklisted(true)
+		}
+		partner.Write(data)
+	}
+}
+
+// ActionResetEmailBounce resets the bounce counters of these partners and
+// removes them from the blacklist.
+func partner_ActionResetEmailBounce(rs m.PartnerSet) {
+	rs.Write(h.Partner().NewData().
+		SetEmailBounceCount(0).
+		SetEmailSoftBounceCount(0).
+		SetEmailBlacklisted(false))
+}
+
+// ProcessBounceMessage is meant to be called by the inbound mail gateway for
+// each incoming email. If msg is a Delivery Status Notification, it registers
+// the bounces of the failed recipients on the partners with this email address
+// and returns true. It returns false if msg is not a DSN.
+func ProcessBounceMessage(env models.Environment, msg *mail.Message) bool {
+	recipients, ok := ParseDSN(msg)
+	if !ok {
+		return false
+	}
+	for _, recipient := range recipients {
+		if recipient.Recipient == "" || !(recipient.IsHard() || recipient.IsSoft()) {
+			continue
+		}
+		partners := h.Partner().NewSet(env).AsSuperUser("match bounced recipient").Search(q.Partner().Email().ILike(recipient.Recipient))
+		partners.RegisterEmailBounce(recipient.IsHard())
+	}
+	return true
+}
+
+func init() {
+	h.Partner().NewMethod("ComputeEmailScore", partner_ComputeEmailScore)
+	h.Partner().NewMethod("EmailBounceLimit", partner_EmailBounceLimit)
+	h.Partner().NewMethod("RegisterEmailBounce", partner_RegisterEmailBounce)
+	h.Partner().NewMethod("ActionResetEmailBounce", partner_ActionResetEmailBounce)
+}
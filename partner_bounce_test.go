@@ -0,0 +1,87 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testBounceEmail = "From: MAILER-DAEMON@mx.example\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=\"BOUNDARY\"\r\n" +
+	"\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Delivery has failed to these recipients.\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; Bounce@Example.com\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; <slow@example.com>\r\n" +
+	"Action: delayed\r\n" +
+	"Status: 4.4.1\r\n" +
+	"--BOUNDARY--\r\n"
+
+func TestEmailBounces(t *testing.T) {
+	Convey("Testing email bounce handling", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			bouncing := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Bouncing").
+				SetEmail("bounce@example.com"))
+			slow := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Slow").
+				SetEmail("slow@example.com"))
+			So(bouncing.EmailScore(), ShouldEqual, 100)
+			Convey("Delivery status notifications are parsed", func() {
+				msg, err := mail.ReadMessage(strings.NewReader(testBounceEmail))
+				So(err, ShouldBeNil)
+				recipients, ok := ParseDSN(msg)
+				So(ok, ShouldBeTrue)
+				So(recipients, ShouldHaveLength, 2)
+				So(recipients[0].Recipient, ShouldEqual, "bounce@example.com")
+				So(recipients[0].IsHard(), ShouldBeTrue)
+				So(recipients[1].IsSoft(), ShouldBeTrue)
+			})
+			Convey("Other emails are not bounces", func() {
+				msg, err := mail.ReadMessage(strings.NewReader(testSignatureEmail))
+				So(err, ShouldBeNil)
+				So(ProcessBounceMessage(env, msg), ShouldBeFalse)
+			})
+			Convey("Bounces lower the score and blacklist after the limit", func() {
+				for i := 0; i < EmailBounceLimit; i++ {
+					msg, err := mail.ReadMessage(strings.NewReader(testBounceEmail))
+					So(err, ShouldBeNil)
+					So(ProcessBounceMessage(env, msg), ShouldBeTrue)
+				}
+				So(bouncing.EmailBounceCount(), ShouldEqual, EmailBounceLimit)
+				So(bouncing.EmailBlacklisted(), ShouldBeTrue)
+				So(bouncing.EmailScore(), ShouldEqual, 0)
+				So(slow.EmailSoftBounceCount(), ShouldEqual, EmailBounceLimit)
+				So(slow.EmailBlacklisted(), ShouldBeFalse)
+				So(slow.EmailScore(), ShouldEqual, 85)
+				blacklist := h.Partner().NewSet(env).EmailBlacklist()
+				So(blacklist["bounce@example.com"], ShouldBeTrue)
+				So(blacklist["slow@example.com"], ShouldBeFalse)
+				Convey("Changing the email address resets the bounces", func() {
+					bouncing.SetEmail("new@example.com")
+					So(bouncing.EmailBounceCount(), ShouldEqual, 0)
+					So(bouncing.EmailBlacklisted(), ShouldBeFalse)
+					So(bouncing.EmailScore(), ShouldEqual, 100)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+var fields_PartnerCategoryCleanupWizard = map[string]models.FieldDefinition{
+	"UnusedCategories": fields.Many2Many{RelationModel: h.PartnerCategory(), String: "Unused Tags",
+		M2MLinkModelName: "PartnerCategoryCleanupUnusedRel",
+		Default: func(env models.Environment) interface{} {
+			return h.PartnerCategory().Search(env,
+				q.PartnerCategory().PartnerCount().IsNull().Or().PartnerCount().Equals(0))
+		}, Help: "Active tags that are not set on any partner"},
+	"DuplicateCategories": fields.Many2Many{RelationModel: h.PartnerCategory(), String: "Duplicate Tags",
+		M2MLinkModelName: "PartnerCategoryCleanupDuplicateRel",
+		Default: func(env models.Environment) interface{} {
+			return h.PartnerCategoryCleanupWizard().NewSet(env).FindDuplicates()
+		}, Help: "Tags having the same name (case insensitive) as another tag with the same parent"},
+}
+
+// FindDuplicates returns all active tags that have the same name (case insensitive)
+// and parent as at least one other active tag.
+func partnerCategoryCleanupWizard_FindDuplicates(rs m.PartnerCategoryCleanupWizardSet) m.PartnerCategorySet {
+	res := h.PartnerCategory().NewSet(rs.Env())
+	for _, group := range rs.DuplicateGroups() {
+		res = res.Union(group)
+	}
+	return res
+}
+
+// DuplicateGroups returns the active tags grouped by duplicates. Each
+// returned PartnerCategorySet has at least two tags, ordered by ID.
+func partnerCategoryCleanupWizard_DuplicateGroups(rs m.PartnerCategoryCleanupWizardSet) []m.PartnerCategorySet {
+	type tagKey struct {
+		name     string
+		parentID int64
+	}
+	var keys []tagKey
+	groups := make(map[tagKey]m.PartnerCategorySet)
+	for _, tag := range h.PartnerCategory().NewSet(rs.Env()).SearchAll().OrderBy("ID").Records() {
+		key := tagKey{name: strings.ToLower(strings.TrimSpace(tag.Name())), parentID: tag.Parent().ID()}
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
+			groups[key] = h.PartnerCategory().NewSet(rs.Env())
+		}
+		groups[key] = groups[key].Union(tag)
+	}
+	var res []m.PartnerCategorySet
+	for _, key := range keys {
+		if groups[key].Len() > 1 {
+			res = append(res, groups[key])
+		}
+	}
+	return res
+}
+
+// ActionArchiveUnused archives all the unused tags listed in this wizard
+func partnerCategoryCleanupWizard_ActionArchiveUnused(rs m.PartnerCategoryCleanupWizardSet) *actions.Action {
+	rs.EnsureOne()
+	rs.UnusedCategories().ActionArchive()
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+// ActionMergeDuplicates merges each group of duplicate tags listed in this
+// wizard into the oldest tag of the group.
+func partnerCategoryCleanupWizard_ActionMergeDuplicates(rs m.PartnerCategoryCleanupWizardSet) *actions.Action {
+	rs.EnsureOne()
+	for _, group := range rs.DuplicateGroups() {
+		group = group.Intersect(rs.DuplicateCategories())
+		if group.Len() < 2 {
+			continue
+		}
+		target := group.Records()[0]
+		group.Merge(target)
+	}
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+func init() {
+	models.NewTransientModel("PartnerCategoryCleanupWizard")
+	h.PartnerCategoryCleanupWizard().AddFields(fields_PartnerCategoryCleanupWizard)
+	h.PartnerCategoryCleanupWizard().NewMethod("FindDuplicates", partnerCategoryCleanupWizard_FindDuplicates)
+	h.PartnerCategoryCleanupWizard().NewMethod("DuplicateGroups", partnerCategoryCleanupWizard_DuplicateGroups)
+	h.PartnerCategoryCleanupWizard().NewMethod("ActionArchiveUnused", partnerCategoryCleanupWizard_ActionArchiveUnused)
+	h.PartnerCategoryCleanupWizard().NewMethod("ActionMergeDuplicates", partnerCategoryCleanupWizard_ActionMergeDuplicates)
+}
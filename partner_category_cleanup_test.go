@@ -0,0 +1,45 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerCategoryCleanup(t *testing.T) {
+	Convey("Testing the contact tags cleanup wizard", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			used := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Cleanup Used"))
+			unused := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Cleanup Unused"))
+			legacy := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Cleanup Legacy"))
+			duplicate := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName(" cleanup used"))
+			h.Partner().Create(env, h.Partner().NewData().
+				SetName("Tagged Partner").
+				SetCategories(used))
+			env.Cr().Execute(`UPDATE partner_category SET partner_count = NULL WHERE id = ?`, legacy.ID())
+			wizard := h.PartnerCategoryCleanupWizard().Create(env, h.PartnerCategoryCleanupWizard().NewData())
+			Convey("Unused tags include tags with no partner count", func() {
+				So(wizard.UnusedCategories().Intersect(unused).IsNotEmpty(), ShouldBeTrue)
+				So(wizard.UnusedCategories().Intersect(legacy).IsNotEmpty(), ShouldBeTrue)
+				So(wizard.UnusedCategories().Intersect(used).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Duplicates are found case and space insensitively", func() {
+				So(wizard.DuplicateCategories().Intersect(used).IsNotEmpty(), ShouldBeTrue)
+				So(wizard.DuplicateCategories().Intersect(duplicate).IsNotEmpty(), ShouldBeTrue)
+				So(wizard.DuplicateCategories().Intersect(unused).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Archiving unused tags keeps the used ones", func() {
+				wizard.ActionArchiveUnused()
+				So(unused.Active(), ShouldBeFalse)
+				So(legacy.Active(), ShouldBeFalse)
+				So(used.Active(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+var fields_ChangeParentWizard = map[string]models.FieldDefinition{
+	"Partners": fields.Many2Many{RelationModel: h.Partner(), String: "Contacts", Required: true, JSON: "partner_ids",
+		M2MLinkModelName: "ChangeParentWizardPartnerRel",
+		Default: func(env models.Environment) interface{} {
+			return h.Partner().Search(env, q.Partner().ID().In(env.Context().GetIntegerSlice(ContextKeyActiveIDs)))
+		}},
+	"NewParent": fields.Many2One{RelationModel: h.Partner(), String: "New Company", Required: true,
+		Filter: q.Partner().IsCompany().Equals(true)},
+	"SyncCommercialFields": fields.Boolean{String: "Update Commercial Fields", Default: models.DefaultValue(true),
+		Help: "Copy the commercial fields (e.g. VAT) of the new company to the moved contacts. Otherwise, their current values are kept."},
+	"SyncAddress": fields.Boolean{String: "Update Addresses", Default: models.DefaultValue(true),
+		Help: "Copy the address of the new company to the moved contacts of type 'contact'. Otherwise, their current address is kept."},
+	"Reason": fields.Char{Help: "Recorded in the application log with each move"},
+}
+
+// ActionApply moves the contacts of this wizard under the new company.
+//
+// Contacts are written without the automatic synchronisation of commercial
+// and address fields, which is then performed only if requested. Each move
+// is recorded in the application log.
+func changeParentWizard_ActionApply(rs m.ChangeParentWizardSet) *actions.Action {
+	rs.EnsureOne()
+	newParent := rs.NewParent()
+	for _, partner := range rs.Partners().Records() {
+		if partner.Parent().Equals(newParent) {
+			continue
+		}
+		oldParentName := partner.Parent().Name()
+		partner.WithoutSync().Write(h.Partner().NewData().
+			SetParent(newParent).
+			SetCompanyName(""))
+		if rs.SyncCommercialFields() {
+			partner.CommercialSyncFromCompany()
+			if partner.Children().IsNotEmpty() {
+				partner.CommercialSyncToChildren()
+			}
+		}
+		if rs.SyncAddress() && partner.Type() == "contact" {
+			partner.UpdateAddress(newParent.UpdateFieldValues(partner.AddressFields()...))
+		}
+		message := fmt.Sprintf("Contact '%s' moved from '%s' to '%s'", partner.Name(), oldParentName, newParent.Name())
+		if rs.Reason() != "" {
+			message = fmt.Sprintf("%s: %s", message, rs.Reason())
+		}
+		LogEvent(rs.Env(), LogEntry{
+			Level:   LoggingInfo,
+			Logger:  "partner",
+			Message: message,
+			Func:    "ChangeParentWizard.ActionApply",
+			Model:   "Partner",
+			ResID:   partner.ID(),
+		})
+	}
+	return &actions.Action{Type: actions.ActionCloseWindow}
+}
+
+func init() {
+	models.NewTransientModel("ChangeParentWizard")
+	h.ChangeParentWizard().AddFields(fields_ChangeParentWizard)
+	h.ChangeParentWizard().NewMethod("ActionApply", changeParentWizard_ActionApply)
+}
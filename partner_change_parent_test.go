@@ -0,0 +1,76 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChangeParentWizard(t *testing.T) {
+	Convey("Testing the contact relocation wizard", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+			oldCompany := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Old Company").
+				SetIsCompany(true).
+				SetVAT("FR11111111111").
+				SetCity("Lyon"))
+			newCompany := h.Partner().Create(env, h.Partner().NewData().
+				SetName("New Company").
+				SetIsCompany(true).
+				SetVAT("FR22222222222").
+				SetCity("Paris").
+				SetCountry(france))
+			alice := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Alice").
+				SetParent(oldCompany))
+			bob := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Bob").
+				SetParent(oldCompany))
+			contacts := alice.Union(bob)
+			Convey("Contacts are moved and synced with their new company", func() {
+				wizard := h.ChangeParentWizard().Create(env, h.ChangeParentWizard().NewData().
+					SetPartners(contacts).
+					SetNewParent(newCompany).
+					SetReason("Reorganization"))
+				wizard.ActionApply()
+				for _, contact := range contacts.Records() {
+					So(contact.Parent().Equals(newCompany), ShouldBeTrue)
+					So(contact.CommercialPartner().Equals(newCompany), ShouldBeTrue)
+					So(contact.VAT(), ShouldEqual, "FR22222222222")
+					So(contact.City(), ShouldEqual, "Paris")
+					So(contact.Country().Equals(france), ShouldBeTrue)
+				}
+				logs := h.Logging().Search(env, q.Logging().Model().Equals("Partner").
+					And().ResID().Equals(alice.ID()).
+					And().Message().Contains("Reorganization"))
+				So(logs.IsNotEmpty(), ShouldBeTrue)
+			})
+			Convey("Commercial fields and addresses can be preserved", func() {
+				wizard := h.ChangeParentWizard().Create(env, h.ChangeParentWizard().NewData().
+					SetPartners(alice).
+					SetNewParent(newCompany).
+					SetSyncCommercialFields(false).
+					SetSyncAddress(false))
+				wizard.ActionApply()
+				So(alice.Parent().Equals(newCompany), ShouldBeTrue)
+				So(alice.VAT(), ShouldEqual, "FR11111111111")
+				So(alice.City(), ShouldEqual, "Lyon")
+				So(bob.Parent().Equals(oldCompany), ShouldBeTrue)
+			})
+			Convey("Selected contacts are the default", func() {
+				wizard := h.ChangeParentWizard().NewSet(env).
+					WithContext(ContextKeyActiveIDs, contacts.Ids()).
+					Create(h.ChangeParentWizard().NewData().SetNewParent(newCompany))
+				So(wizard.Partners().Equals(contacts), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/fieldtype"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// States of partner change requests
+const (
+	PartnerChangePending  = "pending"
+	PartnerChangeApplied  = "applied"
+	PartnerChangeRejected = "rejected"
+)
+
+// PartnerChangeStates is the selection of the states of partner change requests
+var PartnerChangeStates = types.Selection{
+	PartnerChangePending:  "To Review",
+	PartnerChangeApplied:  "Applied",
+	PartnerChangeRejected: "Rejected",
+}
+
+// Sources of partner change requests
+const (
+	PartnerChangeSourcePortal = "portal"
+	PartnerChangeSourceEmail  = "email"
+	PartnerChangeSourceImport = "import"
+	PartnerChangeSourceOther  = "other"
+)
+
+// PartnerChangeSources is the selection of the sources of partner change requests
+var PartnerChangeSources = types.Selection{
+	PartnerChangeSourcePortal: "Portal",
+	PartnerChangeSourceEmail:  "Email Signature",
+	PartnerChangeSourceImport: "Import",
+	PartnerChangeSourceOther:  "Other",
+}
+
+var fields_PartnerChangeRequest = map[string]models.FieldDefinition{
+	"Partner": fields.Many2One{RelationModel: h.Partner(), Required: true, Index: true,
+		OnDelete: models.Cascade, ReadOnly: true},
+	"Source": fields.Selection{Selection: PartnerChangeSources, Required: true, ReadOnly: true,
+		Default: models.DefaultValue(PartnerChangeSourceOther)},
+	"SourceRef": fields.Char{String: "Source Reference", ReadOnly: true,
+		Help: "Reference of the origin of the proposal, e.g. the Message-ID of the email"},
+	"RequestedBy": fields.Many2One{RelationModel: h.User(), ReadOnly: true, OnDelete: models.SetNull,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser()
+		}},
+	"Lines": fields.One2Many{RelationModel: h.PartnerChangeRequestLine(), ReverseFK: "Request",
+		JSON: "line_ids"},
+	"State": fields.Selection{Selection: PartnerChangeStates, Required: true, Index: true, ReadOnly: true,
+		Default: models.DefaultValue(PartnerChangePending)},
+	"ReviewedBy":   fields.Many2One{RelationModel: h.User(), ReadOnly: true, OnDelete: models.SetNull},
+	"DateReviewed": fields.DateTime{String: "Reviewed On", ReadOnly: true},
+	"Note":         fields.Text{String: "Review Note"},
+}
+
+var fields_PartnerChangeRequestLine = map[string]models.FieldDefinition{
+	"Request": fields.Many2One{RelationModel: h.PartnerChangeRequest(), Required: true, Index: true,
+		OnDelete: models.Cascade},
+	"Field":           fields.Char{Required: true, ReadOnly: true, Help: "Name of the changed Partner field"},
+	"FieldLabel":      fields.Char{String: "Field", ReadOnly: true},
+	"OldValue":        fields.Char{String: "Current Value", ReadOnly: true},
+	"NewValue":        fields.Char{String: "Proposed Raw Value", ReadOnly: true},
+	"NewValueDisplay": fields.Char{String: "Proposed Value", ReadOnly: true},
+	"Apply": fields.Boolean{Default: models.DefaultValue(true),
+		Help: "Uncheck to discard this change when applying the request"},
+}
+
+// ChangeRequestFields returns the fields of the partner for which changes
+// can be proposed with ProposeChanges. Other fields are ignored.
+// Many2One fields are proposed with the ID of the related record.
+func partner_ChangeRequestFields(_ m.PartnerSet) []models.FieldName {
+	return []models.FieldName{
+		h.Partner().Fields().Name(), h.Partner().Fields().Email(), h.Partner().Fields().Phone(),
+		h.Partner().Fields().Mobile(), h.Partner().Fields().Function(), h.Partner().Fields().Website(),
+		h.Partner().Fields().CompanyName(), h.Partner().Fields().Street(), h.Partner().Fields().Street2(),
+		h.Partner().Fields().Zip(), h.Partner().Fields().City(), h.Partner().Fields().State(),
+		h.Partner().Fields().Country(),
+	}
+}
+
+// partnerChangeValue converts the given proposed value of the given field to
+// the value to write on the partner and to its display string. It returns
+// false if the value is not valid. Related records are searched with the
+// rights of the user of env, so that they cannot be probed.
+func partnerChangeValue(env models.Environment, fi *models.FieldInfo, value string) (interface{}, string, bool) {
+	switch fi.Type {
+	case fieldtype.Many2One:
+		if value == "" {
+			return env.Pool(fi.Relation).Wrap(), "", true
+		}
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, "", false
+		}
+		rrs := env.Pool(fi.Relation)
+		rrs = rrs.Search(rrs.Model().Field(models.ID).Equals(id))
+		if rrs.IsEmpty() {
+			return nil, "", false
+		}
+		return rrs.Wrap(), rrs.Call("NameGet").(string), true
+	case fieldtype.Selection:
+		if _, ok := fi.Selection[value]; !ok && value != "" {
+			return nil, "", false
+		}
+		return value, fi.Selection[value], true
+	case fieldtype.Char, fieldtype.Text:
+		return value, value, true
+	}
+	return nil, "", false
+}
+
+// partnerCurrentValue returns the display string of the current value of the given field
+func partnerCurrentValue(partner m.PartnerSet, fi *models.FieldInfo, field models.FieldName) string {
+	value := partner.Collection().Get(field)
+	switch fi.Type {
+	case fieldtype.Many2One:
+		rs, ok := value.(models.RecordSet)
+		if !ok || len(rs.Ids()) == 0 {
+			return ""
+		}
+		return rs.Collection().Call("NameGet").(string)
+	case fieldtype.Selection:
+		return fi.Selection[value.(string)]
+	}
+	res, _ := value.(string)
+	return res
+}
+
+// ProposeChanges files a PartnerChangeRequest with the given values for this
+// partner instead of writing them directly. Values are keyed by field name or
+// JSON name and must be fields returned by ChangeRequestFields. Values equal
+// to the current ones or invalid are ignored. It returns an empty set if
+// nothing would change.
+//
+// Use this method for changes coming from untrusted sources, such as portal
+// users or email signatures, so that they are reviewed before being applied.
+// Portal users can only propose changes to the partners of their own
+// commercial entity.
+func partner_ProposeChanges(rs m.PartnerSet, values map[string]string, source, sourceRef string) m.PartnerChangeRequestSet {
+	rs.EnsureOne()
+	partner := rs.AsSuperUser("propose partner changes")
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	if !user.IsSuperUser() && !user.HasGroup(GroupUser.ID()) &&
+		!partner.CommercialPartner().Equals(user.Partner().CommercialPartner()) {
+		panic(NewUserError(rs, "You can only propose changes to the contacts of your own company"))
+	}
+	model := partner.Collection().Model()
+	fInfos := modelFieldInfos(model)
+	allowed := make(map[string]bool)
+	for _, field := range partner.ChangeRequestFields() {
+		allowed[field.Name()] = true
+	}
+	lines := h.PartnerChangeRequestLine().NewSet(rs.Env()).AsSuperUser("propose partner changes")
+	var data []m.PartnerChangeRequestLineData
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := values[name]
+		fi, ok := fInfos[name]
+		if !ok || !allowed[fi.Name] {
+			log.Warn("Ignoring change proposal of unknown or forbidden field", "partner", rs.ID(), "field", name)
+			continue
+		}
+		field := model.FieldName(fi.Name)
+		value = strings.TrimSpace(value)
+		_, display, valid := partnerChangeValue(rs.Env(), fi, value)
+		if !valid {
+			log.Warn("Ignoring invalid change proposal", "partner", rs.ID(), "field", name, "value", value)
+			continue
+		}
+		current := partnerCurrentValue(partner, fi, field)
+		if display == current {
+			continue
+		}
+		data = append(data, h.PartnerChangeRequestLine().NewData().
+			SetField(fi.Name).
+			SetFieldLabel(fi.String).
+			SetOldValue(current).
+			SetNewValue(value).
+			SetNewValueDisplay(display))
+	}
+	if len(data) == 0 {
+		return h.PartnerChangeRequest().NewSet(rs.Env())
+	}
+	request := h.PartnerChangeRequest().NewSet(rs.Env()).AsSuperUser("propose partner changes").Create(h.PartnerChangeRequest().NewData().
+		SetPartner(rs).
+		SetSource(source).
+		SetSourceRef(sourceRef))
+	for _, d := range data {
+		lines.Create(d.SetRequest(request))
+	}
+	return request
+}
+
+// NameGet returns the partner name and the source of the request
+func partnerChangeRequest_NameGet(rs m.PartnerChangeRequestSet) string {
+	return rs.T("%s (%s)", rs.Partner().Name(), PartnerChangeSources[rs.Source()])
+}
+
+// checkPendingChangeRequests panics if one of the given requests has already been reviewed
+func checkPendingChangeRequests(rs m.PartnerChangeRequestSet) {
+	for _, request := range rs.Records() {
+		if request.State() != PartnerChangePending {
+			log.Panic(rs.T("The change request %s has already been reviewed", request.DisplayName()))
+		}
+	}
+}
+
+// ActionApply writes the lines of these requests which are checked to be
+// applied on their partner and marks the requests as applied.
+func partnerChangeRequest_ActionApply(rs m.PartnerChangeRequestSet) {
+	checkPendingChangeRequests(rs)
+	for _, request := range rs.Records() {
+		partner := request.Partner()
+		model := partner.Collection().Model()
+		fInfos := modelFieldInfos(model)
+		data := h.Partner().NewData()
+		for _, line := range request.Lines().Records() {
+			if !line.Apply() {
+				continue
+			}
+			fi, ok := fInfos[line.Field()]
+			if !ok {
+				log.Panic(rs.T("Unknown field %s", line.Field()))
+			}
+			value, _, valid := partnerChangeValue(rs.Env(), fi, line.NewValue())
+			if !valid {
+				log.Panic(rs.T("The proposed value of %s is not valid anymore", line.FieldLabel()))
+			}
+			data.Set(model.FieldName(fi.Name), value)
+		}
+		partner.Write(data)
+		request.Write(h.PartnerChangeRequest().NewData().
+			SetState(PartnerChangeApplied).
+			SetReviewedBy(h.User().NewSet(rs.Env()).CurrentUser()).
+			SetDateReviewed(dates.Now()))
+	}
+}
+
+// ActionReject marks these requests as rejected without changing their partner
+func partnerChangeRequest_ActionReject(rs m.PartnerChangeRequestSet) {
+	checkPendingChangeRequests(rs)
+	rs.Write(h.PartnerChangeRequest().NewData().
+		SetState(PartnerChangeRejected).
+		SetReviewedBy(h.User().NewSet(rs.Env()).CurrentUser()).
+		SetDateReviewed(dates.Now()))
+}
+
+func init() {
+	models.NewModel("PartnerChangeRequest")
+	h.PartnerChangeRequest().SetDefaultOrder("ID desc")
+	h.PartnerChangeRequest().AddFields(fields_PartnerChangeRequest)
+	h.PartnerChangeRequest().InheritModel(h.KanbanMixin())
+	h.PartnerChangeRequest().Methods().NameGet().Extend(partnerChangeRequest_NameGet)
+	h.PartnerChangeRequest().NewMethod("ActionApply", partnerChangeRequest_ActionApply)
+	h.PartnerChangeRequest().NewMethod("ActionReject", partnerChangeRequest_ActionReject)
+
+	models.NewModel("PartnerChangeRequestLine")
+	h.PartnerChangeRequestLine().AddFields(fields_PartnerChangeRequestLine)
+
+	h.Partner().NewMethod("ChangeRequestFields", partner_ChangeRequestFields)
+	h.Partner().NewMethod("ProposeChanges", partner_ProposeChanges)
+}
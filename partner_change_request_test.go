@@ -0,0 +1,78 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerChangeRequest(t *testing.T) {
+	Convey("Testing partner change requests", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Change Me").
+				SetPhone("0102030405").
+				SetCity("Lyon"))
+			france := h.Country().NewSet(env).GetRecord("base_fr")
+			Convey("Only actual changes of allowed fields are proposed", func() {
+				request := partner.ProposeChanges(map[string]string{
+					"Phone":       "0102030405",
+					"city":        "Paris",
+					"Function":    "CFO",
+					"country_id":  strconv.FormatInt(france.ID(), 10),
+					"CreditLimit": "1000000",
+				}, PartnerChangeSourcePortal, "")
+				So(request.Len(), ShouldEqual, 1)
+				So(request.State(), ShouldEqual, PartnerChangePending)
+				So(request.Lines().Len(), ShouldEqual, 3)
+				So(partner.City(), ShouldEqual, "Lyon")
+				Convey("Applying writes the checked lines", func() {
+					for _, line := range request.Lines().Records() {
+						if line.Field() == "Function" {
+							line.SetApply(false)
+						}
+					}
+					request.ActionApply()
+					So(request.State(), ShouldEqual, PartnerChangeApplied)
+					So(partner.City(), ShouldEqual, "Paris")
+					So(partner.Country().Equals(france), ShouldBeTrue)
+					So(partner.Function(), ShouldBeBlank)
+					So(func() { request.ActionReject() }, ShouldPanic)
+				})
+				Convey("Rejecting does not change the partner", func() {
+					request.ActionReject()
+					So(request.State(), ShouldEqual, PartnerChangeRejected)
+					So(partner.City(), ShouldEqual, "Lyon")
+				})
+			})
+			Convey("No request is filed if nothing changes", func() {
+				request := partner.ProposeChanges(map[string]string{"City": "Lyon"}, PartnerChangeSourceEmail, "")
+				So(request.IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Portal users can only propose changes to their own company", func() {
+				customer := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Portal Customer").
+					SetIsCompany(true))
+				portalUser := h.User().Create(env, h.User().NewData().
+					SetName("Portal Contact").
+					SetLogin("change_request_portal").
+					SetGroups(h.Group().Search(env, q.Group().GroupID().Equals(GroupPortal.ID()))))
+				portalUser.SyncMemberships()
+				portalUser.Partner().SetParent(customer)
+				So(func() {
+					partner.Sudo(portalUser.ID()).ProposeChanges(map[string]string{"City": "Paris"}, PartnerChangeSourcePortal, "")
+				}, ShouldPanic)
+				request := customer.Sudo(portalUser.ID()).ProposeChanges(map[string]string{"City": "Paris"}, PartnerChangeSourcePortal, "")
+				So(request.Len(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
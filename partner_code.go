@@ -0,0 +1,122 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+var partnerCodeTypes = struct {
+	sync.RWMutex
+	types types.Selection
+}{
+	types: make(types.Selection),
+}
+
+// RegisterPartnerCodeType declares a type of external partner identifier that
+// integrations can store in the PartnerCode model, e.g. "datev_account" for
+// the DATEV account number of a partner. It panics if the type already exists.
+func RegisterPartnerCodeType(name, label string) {
+	partnerCodeTypes.Lock()
+	defer partnerCodeTypes.Unlock()
+	if _, exists := partnerCodeTypes.types[name]; exists {
+		log.Panic("Partner code type already registered", "name", name)
+	}
+	partnerCodeTypes.types[name] = label
+}
+
+// PartnerCodeTypes returns the selection of the registered partner code types
+func PartnerCodeTypes() types.Selection {
+	partnerCodeTypes.RLock()
+	defer partnerCodeTypes.RUnlock()
+	res := make(types.Selection, len(partnerCodeTypes.types))
+	for name, label := range partnerCodeTypes.types {
+		res[name] = label
+	}
+	return res
+}
+
+var fields_PartnerCode = map[string]models.FieldDefinition{
+	"Partner": fields.Many2One{RelationModel: h.Partner(), Required: true, OnDelete: models.Cascade, Index: true},
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+	"CodeType": fields.Selection{String: "Code Type", SelectionFunc: PartnerCodeTypes, Required: true, Index: true},
+	"Code":     fields.Char{Required: true, Index: true},
+}
+
+// NameGet returns the code with its type
+func partnerCode_NameGet(rs m.PartnerCodeSet) string {
+	return PartnerCodeTypes()[rs.CodeType()] + ": " + rs.Code()
+}
+
+// FindPartner returns the partner that has the given code of the given type
+// in the current company, or an empty PartnerSet.
+func partnerCode_FindPartner(rs m.PartnerCodeSet, codeType, code string) m.PartnerSet {
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	partnerCode := h.PartnerCode().Search(rs.Env(), q.PartnerCode().CodeType().Equals(codeType).
+		And().Code().Equals(strings.TrimSpace(code)).
+		And().Company().Equals(company)).Limit(1)
+	return partnerCode.Partner()
+}
+
+// ExternalCode returns the code of the given type of this partner in the current
+// company, or an empty string if it has none.
+func partner_ExternalCode(rs m.PartnerSet, codeType string) string {
+	rs.EnsureOne()
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	return h.PartnerCode().Search(rs.Env(), q.PartnerCode().Partner().Equals(rs).
+		And().CodeType().Equals(codeType).
+		And().Company().Equals(company)).Limit(1).Code()
+}
+
+// SetExternalCode sets the code of the given type of this partner in the current
+// company. An empty code removes the existing one.
+func partner_SetExternalCode(rs m.PartnerSet, codeType, code string) {
+	rs.EnsureOne()
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	code = strings.TrimSpace(code)
+	existing := h.PartnerCode().Search(rs.Env(), q.PartnerCode().Partner().Equals(rs).
+		And().CodeType().Equals(codeType).
+		And().Company().Equals(company))
+	switch {
+	case code == "":
+		existing.Unlink()
+	case existing.IsNotEmpty():
+		existing.SetCode(code)
+	default:
+		h.PartnerCode().Create(rs.Env(), h.PartnerCode().NewData().
+			SetPartner(rs).
+			SetCompany(company).
+			SetCodeType(codeType).
+			SetCode(code))
+	}
+}
+
+func init() {
+	models.NewModel("PartnerCode")
+	h.PartnerCode().SetDefaultOrder("Partner", "CodeType")
+	h.PartnerCode().AddFields(fields_PartnerCode)
+	h.PartnerCode().AddSQLConstraint("partner_code_type_uniq", "unique(partner_id, company_id, code_type)",
+		"A partner can only have one code of each type per company!")
+	h.PartnerCode().AddSQLConstraint("code_uniq", "unique(company_id, code_type, code)",
+		"This code is already used by another partner of the company!")
+	h.PartnerCode().Methods().NameGet().Extend(partnerCode_NameGet)
+	h.PartnerCode().NewMethod("FindPartner", partnerCode_FindPartner)
+
+	h.Partner().NewMethod("ExternalCode", partner_ExternalCode)
+	h.Partner().NewMethod("SetExternalCode", partner_SetExternalCode)
+
+	RegisterPartnerCodeType("accounting", "Accounting Export Code")
+	RegisterPartnerCodeType("edi", "EDI Identifier")
+}
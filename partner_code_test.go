@@ -0,0 +1,46 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerCode(t *testing.T) {
+	Convey("Testing partner external codes", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Coded Partner"))
+			other := h.Partner().Create(env, h.Partner().NewData().SetName("Other Partner"))
+			Convey("Codes can be set, read and removed", func() {
+				So(partner.ExternalCode("accounting"), ShouldEqual, "")
+				partner.SetExternalCode("accounting", " 10001 ")
+				So(partner.ExternalCode("accounting"), ShouldEqual, "10001")
+				So(partner.ExternalCodes().Len(), ShouldEqual, 1)
+				partner.SetExternalCode("accounting", "10002")
+				So(partner.ExternalCode("accounting"), ShouldEqual, "10002")
+				So(partner.ExternalCodes().Len(), ShouldEqual, 1)
+				partner.SetExternalCode("accounting", "")
+				So(partner.ExternalCodes().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Partners are found by code", func() {
+				partner.SetExternalCode("edi", "ABC")
+				So(h.PartnerCode().NewSet(env).FindPartner("edi", "ABC").Equals(partner), ShouldBeTrue)
+				So(h.PartnerCode().NewSet(env).FindPartner("accounting", "ABC").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Codes are unique per type and company", func() {
+				partner.SetExternalCode("accounting", "10001")
+				So(func() { other.SetExternalCode("accounting", "10001") }, ShouldPanic)
+			})
+			Convey("Code types must be registered", func() {
+				So(PartnerCodeTypes(), ShouldContainKey, "accounting")
+				So(func() { RegisterPartnerCodeType("accounting", "Accounting") }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// PartnerCompletenessFields is the selection of the partner fields which can
+// be weighted in the completeness score. The CompletenessScore field depends
+// on these fields.
+var PartnerCompletenessFields = types.Selection{
+	"Email":    "Email",
+	"Phone":    "Phone",
+	"Mobile":   "Mobile",
+	"VAT":      "Tax ID",
+	"Website":  "Website",
+	"Function": "Job Position",
+	"Street":   "Street",
+	"Zip":      "Zip",
+	"City":     "City",
+	"State":    "State",
+	"Country":  "Country",
+	"Lang":     "Language",
+}
+
+var fields_PartnerCompletenessWeight = map[string]models.FieldDefinition{
+	"Field": fields.Selection{Selection: PartnerCompletenessFields, Required: true},
+	"Weight": fields.Integer{Required: true, Default: models.DefaultValue(1),
+		Constraint: h.PartnerCompletenessWeight().Methods().CheckWeight(),
+		Help:       "Relative importance of this field in the completeness score of partners"},
+}
+
+// CheckWeight checks that weights are not negative
+func partnerCompletenessWeight_CheckWeight(rs m.PartnerCompletenessWeightSet) {
+	for _, weight := range rs.Records() {
+		if weight.Weight() < 0 {
+			log.Panic(rs.T("The weight of a field cannot be negative"))
+		}
+	}
+}
+
+// Weights returns the weight of each weighted partner field
+func partnerCompletenessWeight_Weights(rs m.PartnerCompletenessWeightSet) map[string]int64 {
+	res := make(map[string]int64)
+	for _, weight := range h.PartnerCompletenessWeight().NewSet(rs.Env()).AsSuperUser("read completeness weights").SearchAll().Records() {
+		res[weight.Field()] = weight.Weight()
+	}
+	return res
+}
+
+// isEmptyFieldValue returns true if the given field value is not set
+func isEmptyFieldValue(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case models.RecordSet:
+		return len(v.Ids()) == 0
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
+
+// completenessScore returns the completeness score in percent of a partner
+// with the given filled fields, and the missing fields sorted by name.
+func completenessScore(weights map[string]int64, filled map[string]bool) (int64, []string) {
+	var total, score int64
+	var missing []string
+	for field, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		total += weight
+		if filled[field] {
+			score += weight
+			continue
+		}
+		missing = append(missing, field)
+	}
+	if total == 0 {
+		return 100, nil
+	}
+	sort.Strings(missing)
+	return score * 100 / total, missing
+}
+
+// partnerFilledFields returns the weighted fields of the given partner which are set
+func partnerFilledFields(partner m.PartnerSet, weights map[string]int64) map[string]bool {
+	model := partner.Collection().Model()
+	res := make(map[string]bool)
+	for field := range weights {
+		res[field] = !isEmptyFieldValue(partner.Collection().Get(model.FieldName(field)))
+	}
+	return res
+}
+
+// ComputeCompletenessScore computes the share of the weighted key data of the partner which is set
+func partner_ComputeCompletenessScore(rs m.PartnerSet) m.PartnerData {
+	weights := h.PartnerCompletenessWeight().NewSet(rs.Env()).Weights()
+	score, _ := completenessScore(weights, partnerFilledFields(rs, weights))
+	return h.Partner().NewData().SetCompletenessScore(score)
+}
+
+// ComputeCompletenessMissing computes the labels of the weighted key data of the partner which are missing
+func partner_ComputeCompletenessMissing(rs m.PartnerSet) m.PartnerData {
+	weights := h.PartnerCompletenessWeight().NewSet(rs.Env()).Weights()
+	_, missing := completenessScore(weights, partnerFilledFields(rs, weights))
+	labels := make([]string, len(missing))
+	for i, field := range missing {
+		labels[i] = rs.T(PartnerCompletenessFields[field])
+	}
+	return h.Partner().NewData().SetCompletenessMissing(strings.Join(labels, ", "))
+}
+
+// RecomputeCompleteness recomputes the completeness score of all partners,
+// typically after the weights have been changed.
+func partner_RecomputeCompleteness(rs m.PartnerSet) {
+	rs.AsSuperUser("recompute partner completeness").WithContext("active_test", false).SearchIter(q.Partner().ID().IsNotNull(), 0, func(batch m.PartnerSet) bool {
+		for _, partner := range batch.Records() {
+			partner.WithContext(ContextKeyForceComputeWrite, true).Write(partner.ComputeCompletenessScore())
+		}
+		return true
+	})
+}
+
+// enqueueCompletenessRecompute enqueues the recomputation of the completeness scores
+func enqueueCompletenessRecompute(env models.Environment) {
+	h.Partner().NewSet(env).Enqueue(h.Partner().NewSet(env).T("Recompute partner completeness"),
+		h.Partner().Methods().RecomputeCompleteness())
+}
+
+func partnerCompletenessWeight_Create(rs m.PartnerCompletenessWeightSet, data m.PartnerCompletenessWeightData) m.PartnerCompletenessWeightSet {
+	res := rs.Super().Create(data)
+	enqueueCompletenessRecompute(rs.Env())
+	return res
+}
+
+func partnerCompletenessWeight_Write(rs m.PartnerCompletenessWeightSet, data m.PartnerCompletenessWeightData) bool {
+	res := rs.Super().Write(data)
+	enqueueCompletenessRecompute(rs.Env())
+	return res
+}
+
+func partnerCompletenessWeight_Unlink(rs m.PartnerCompletenessWeightSet) int64 {
+	res := rs.Super().Unlink()
+	enqueueCompletenessRecompute(rs.Env())
+	return res
+}
+
+func init() {
+	models.NewModel("PartnerCompletenessWeight")
+	h.PartnerCompletenessWeight().SetDefaultOrder("Weight desc", "Field")
+	h.PartnerCompletenessWeight().AddFields(fields_PartnerCompletenessWeight)
+	h.PartnerCompletenessWeight().AddSQLConstraint("field_uniq", "unique(field)", "Each field can only be weighted once!")
+	h.PartnerCompletenessWeight().NewMethod("CheckWeight", partnerCompletenessWeight_CheckWeight)
+	h.PartnerCompletenessWeight().NewMethod("Weights", partnerCompletenessWeight_Weights)
+	h.PartnerCompletenessWeight().Methods().Create().Extend(partnerCompletenessWeight_Create)
+	h.PartnerCompletenessWeight().Methods().Write().Extend(partnerCompletenessWeight_Write)
+	h.PartnerCompletenessWeight().Methods().Unlink().Extend(partnerCompletenessWeight_Unlink)
+
+	h.Partner().NewMethod("ComputeCompletenessScore", partner_ComputeCompletenessScore)
+	h.Partner().NewMethod("ComputeCompletenessMissing", partner_ComputeCompletenessMissing)
+	h.Partner().NewMethod("RecomputeCompleteness", partner_RecomputeCompleteness)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerCompleteness(t *testing.T) {
+	Convey("Testing partner completeness scoring", t, func() {
+		Convey("The score is the weighted share of filled fields", func() {
+			weights := map[string]int64{"Email": 3, "Phone": 1, "City": 0}
+			score, missing := completenessScore(weights, map[string]bool{"Email": true})
+			So(score, ShouldEqual, 75)
+			So(missing, ShouldResemble, []string{"Phone"})
+			score, missing = completenessScore(weights, map[string]bool{"Email": true, "Phone": true})
+			So(score, ShouldEqual, 100)
+			So(missing, ShouldBeEmpty)
+			score, _ = completenessScore(map[string]int64{}, map[string]bool{})
+			So(score, ShouldEqual, 100)
+		})
+		Convey("Blank strings and empty relations are missing", func() {
+			So(isEmptyFieldValue(""), ShouldBeTrue)
+			So(isEmptyFieldValue("  "), ShouldBeTrue)
+			So(isEmptyFieldValue(nil), ShouldBeTrue)
+			So(isEmptyFieldValue("x"), ShouldBeFalse)
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.PartnerCompletenessWeight().NewSet(env).SearchAll().Unlink()
+			h.PartnerCompletenessWeight().Create(env, h.PartnerCompletenessWeight().NewData().
+				SetField("Email").SetWeight(1))
+			h.PartnerCompletenessWeight().Create(env, h.PartnerCompletenessWeight().NewData().
+				SetField("City").SetWeight(1))
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Incomplete Partner").
+				SetEmail("incomplete@example.com"))
+			Convey("The score of partners is computed from the configured weights", func() {
+				So(partner.CompletenessScore(), ShouldEqual, 50)
+				So(partner.CompletenessMissing(), ShouldEqual, "City")
+				partner.SetCity("Lyon")
+				So(partner.CompletenessScore(), ShouldEqual, 100)
+				So(partner.CompletenessMissing(), ShouldBeEmpty)
+			})
+			Convey("Incomplete partners can be searched", func() {
+				incomplete := h.Partner().Search(env, q.Partner().CompletenessScore().Lower(100))
+				So(incomplete.Intersect(partner).IsNotEmpty(), ShouldBeTrue)
+			})
+			Convey("Scores are recomputed when the weights change", func() {
+				h.PartnerCompletenessWeight().Search(env, q.PartnerCompletenessWeight().Field().Equals("City")).
+					SetWeight(3)
+				h.Partner().NewSet(env).RecomputeCompleteness()
+				So(partner.CompletenessScore(), ShouldEqual, 25)
+			})
+			Convey("Negative weights are rejected", func() {
+				So(func() {
+					h.PartnerCompletenessWeight().Create(env, h.PartnerCompletenessWeight().NewData().
+						SetField("Phone").SetWeight(-1))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
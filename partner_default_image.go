@@ -0,0 +1,162 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"embed"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Policies for the default images of new partners
+const (
+	// DefaultPartnerImagesStandard uses the placeholders shipped with Hexya
+	DefaultPartnerImagesStandard = "standard"
+	// DefaultPartnerImagesCustom uses the placeholders of the company directory
+	DefaultPartnerImagesCustom = "custom"
+	// DefaultPartnerImagesNone does not set any default image, to save database space
+	DefaultPartnerImagesNone = "none"
+)
+
+// DefaultPartnerImagesPolicies is the selection of default partner image policies
+var DefaultPartnerImagesPolicies = types.Selection{
+	DefaultPartnerImagesStandard: "Hexya Placeholders",
+	DefaultPartnerImagesCustom:   "Custom Placeholders",
+	DefaultPartnerImagesNone:     "No Default Image",
+}
+
+// placeholderImages holds the Hexya placeholders, so that they are available
+// even when the static resources are not deployed with the server.
+//
+//go:embed static/src/img/avatar.png static/src/img/company_image.png static/src/img/money.png static/src/img/truck.png
+var placeholderImages embed.FS
+
+// embeddedImagesKey prefixes the cache keys of the embedded placeholders
+const embeddedImagesKey = "embed:"
+
+// defaultImages caches the base64 payloads of the placeholder images, keyed
+// by file path for custom placeholders and by file name for embedded ones.
+var defaultImages = struct {
+	sync.RWMutex
+	images map[string]string
+}{
+	images: make(map[string]string),
+}
+
+// ResetDefaultImagesCache empties the cache of the placeholder images, so
+// that custom placeholders are read again from disk.
+func ResetDefaultImagesCache() {
+	defaultImages.Lock()
+	defer defaultImages.Unlock()
+	defaultImages.images = make(map[string]string)
+}
+
+// cachedDefaultImage returns the base64 payload of the image cached with the
+// given key, calling read to get its content only the first time. It returns
+// false if the image cannot be read.
+func cachedDefaultImage(key string, read func() ([]byte, error)) (string, bool) {
+	defaultImages.RLock()
+	img, ok := defaultImages.images[key]
+	defaultImages.RUnlock()
+	if ok {
+		return img, true
+	}
+	content, err := read()
+	if err != nil {
+		return "", false
+	}
+	img = base64.StdEncoding.EncodeToString(content)
+	defaultImages.Lock()
+	defaultImages.images[key] = img
+	defaultImages.Unlock()
+	return img, true
+}
+
+// loadDefaultImage returns the base64 payload of the image file at the given path
+func loadDefaultImage(filePath string) (string, bool) {
+	return cachedDefaultImage(filePath, func() ([]byte, error) {
+		return ioutil.ReadFile(filePath)
+	})
+}
+
+// embeddedDefaultImage returns the base64 payload of the Hexya placeholder
+// with the given file name
+func embeddedDefaultImage(fileName string) (string, bool) {
+	return cachedDefaultImage(embeddedImagesKey+fileName, func() ([]byte, error) {
+		return placeholderImages.ReadFile(path.Join("static", "src", "img", fileName))
+	})
+}
+
+var fields_CompanyDefaultImages = map[string]models.FieldDefinition{
+	"DefaultPartnerImages": fields.Selection{Selection: DefaultPartnerImagesPolicies, Required: true,
+		Default: models.DefaultValue(DefaultPartnerImagesStandard), String: "Default Partner Images",
+		Help: "Image given to new partners without image"},
+	"PartnerImageDir": fields.Char{String: "Placeholder Directory",
+		Constraint: h.Company().Methods().CheckPartnerImageDir(),
+		Help: `Server directory of the custom placeholders: avatar.png, company_image.png, money.png and truck.png.
+Missing files are replaced by Hexya placeholders.`},
+}
+
+// CheckPartnerImageDir checks that the placeholder directory of companies
+// with custom placeholders exists.
+func company_CheckPartnerImageDir(rs m.CompanySet) {
+	for _, company := range rs.Records() {
+		if company.DefaultPartnerImages() != DefaultPartnerImagesCustom {
+			continue
+		}
+		info, err := os.Stat(company.PartnerImageDir())
+		if company.PartnerImageDir() == "" || err != nil || !info.IsDir() {
+			log.Panic(rs.T("Placeholder directory '%s' of company %s does not exist", company.PartnerImageDir(), company.Name()))
+		}
+	}
+}
+
+// DefaultPartnerImage returns the base64 payload of the placeholder image
+// with the given file name for the partners of this company, or an empty
+// string if the company does not use default images.
+func company_DefaultPartnerImage(rs m.CompanySet, fileName string) string {
+	policy := DefaultPartnerImagesStandard
+	if rs.IsNotEmpty() {
+		rs.EnsureOne()
+		policy = rs.AsSuperUser("read default partner images policy").DefaultPartnerImages()
+	}
+	switch policy {
+	case DefaultPartnerImagesNone:
+		return ""
+	case DefaultPartnerImagesCustom:
+		if img, ok := loadDefaultImage(filepath.Join(rs.AsSuperUser("read partner image directory").PartnerImageDir(), fileName)); ok {
+			return img
+		}
+	}
+	img, ok := embeddedDefaultImage(fileName)
+	if !ok {
+		log.Warn("Unknown placeholder image", "image", fileName)
+	}
+	return img
+}
+
+func company_WriteDefaultImages(rs m.CompanySet, vals m.CompanyData) bool {
+	res := rs.Super().Write(vals)
+	if vals.HasPartnerImageDir() {
+		ResetDefaultImagesCache()
+	}
+	return res
+}
+
+func init() {
+	h.Company().AddFields(fields_CompanyDefaultImages)
+	h.Company().NewMethod("CheckPartnerImageDir", company_CheckPartnerImageDir)
+	h.Company().NewMethod("DefaultPartnerImage", company_DefaultPartnerImage)
+	h.Company().Methods().Write().Extend(company_WriteDefaultImages)
+}
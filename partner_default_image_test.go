@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerDefaultImages(t *testing.T) {
+	Convey("Testing default partner images", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			dir, err := ioutil.TempDir("", "hexya-placeholders-test")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			defer ResetDefaultImagesCache()
+			placeholder := filepath.Join(dir, "company_image.png")
+			So(ioutil.WriteFile(placeholder, []byte("first placeholder"), 0644), ShouldBeNil)
+			company := h.User().NewSet(env).CurrentUser().Company()
+			newCompanyPartner := func() string {
+				return h.Partner().Create(env, h.Partner().NewData().
+					SetName("Placeholder Corp").
+					SetIsCompany(true)).Image()
+			}
+			Convey("Hexya placeholders are embedded in the binary", func() {
+				img := company.DefaultPartnerImage("company_image.png")
+				content, err := base64.StdEncoding.DecodeString(img)
+				So(err, ShouldBeNil)
+				So(string(content[1:4]), ShouldEqual, "PNG")
+				So(newCompanyPartner(), ShouldEqual, img)
+				So(company.DefaultPartnerImage("unknown.png"), ShouldBeEmpty)
+			})
+			Convey("Default images can be disabled", func() {
+				company.SetDefaultPartnerImages(DefaultPartnerImagesNone)
+				So(newCompanyPartner(), ShouldBeEmpty)
+				So(company.DefaultPartnerImage("avatar.png"), ShouldBeEmpty)
+			})
+			Convey("Custom placeholder directories must exist", func() {
+				So(func() { company.SetDefaultPartnerImages(DefaultPartnerImagesCustom) }, ShouldPanic)
+				So(func() {
+					company.Write(h.Company().NewData().
+						SetDefaultPartnerImages(DefaultPartnerImagesCustom).
+						SetPartnerImageDir(filepath.Join(dir, "missing")))
+				}, ShouldPanic)
+			})
+			Convey("Custom placeholders are used and cached", func() {
+				company.Write(h.Company().NewData().
+					SetDefaultPartnerImages(DefaultPartnerImagesCustom).
+					SetPartnerImageDir(dir))
+				first := base64.StdEncoding.EncodeToString([]byte("first placeholder"))
+				So(newCompanyPartner(), ShouldEqual, first)
+				So(ioutil.WriteFile(placeholder, []byte("second placeholder"), 0644), ShouldBeNil)
+				So(newCompanyPartner(), ShouldEqual, first)
+				ResetDefaultImagesCache()
+				So(newCompanyPartner(), ShouldEqual, base64.StdEncoding.EncodeToString([]byte("second placeholder")))
+				So(company.DefaultPartnerImage("money.png"), ShouldEqual,
+					h.Company().NewSet(env).DefaultPartnerImage("money.png"))
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"regexp"
+
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+var (
+	glnRegex  = regexp.MustCompile(`^[0-9]{13}$`)
+	dunsRegex = regexp.MustCompile(`^[0-9]{9}$`)
+)
+
+// gs1CheckDigit returns the GS1 mod 10 check digit of the given digits
+func gs1CheckDigit(digits string) byte {
+	var sum int
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		// Weights are 3 and 1 alternately, starting with 3 from the right
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// ValidateGLN returns true if the given string is a valid GS1 Global Location Number
+func ValidateGLN(gln string) bool {
+	return glnRegex.MatchString(gln) && gs1CheckDigit(gln[:12]) == gln[12]
+}
+
+// ValidateDUNS returns true if the given string is a well formed Dun & Bradstreet DUNS number
+func ValidateDUNS(duns string) bool {
+	return dunsRegex.MatchString(duns)
+}
+
+// CheckGLN checks that the GLN of the partners is valid
+func partner_CheckGLN(rs m.PartnerSet) {
+	for _, partner := range rs.Records() {
+		if partner.GLN() != "" && !ValidateGLN(partner.GLN()) {
+			log.Panic(rs.T("The GLN '%s' of partner %s is invalid: it must have 13 digits with a valid check digit",
+				partner.GLN(), partner.Name()))
+		}
+	}
+}
+
+// CheckDUNS checks that the DUNS number of the partners is valid
+func partner_CheckDUNS(rs m.PartnerSet) {
+	for _, partner := range rs.Records() {
+		if partner.DUNS() != "" && !ValidateDUNS(partner.DUNS()) {
+			log.Panic(rs.T("The DUNS number '%s' of partner %s is invalid: it must have 9 digits",
+				partner.DUNS(), partner.Name()))
+		}
+	}
+}
+
+func init() {
+	h.Partner().NewMethod("CheckGLN", partner_CheckGLN)
+	h.Partner().NewMethod("CheckDUNS", partner_CheckDUNS)
+}
@@ -0,0 +1,45 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/operator"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerIdentifiers(t *testing.T) {
+	Convey("Testing GLN and DUNS validation", t, func() {
+		So(ValidateGLN("4006381333931"), ShouldBeTrue)
+		So(ValidateGLN("5901234123457"), ShouldBeTrue)
+		So(ValidateGLN("4006381333932"), ShouldBeFalse)
+		So(ValidateGLN("400638133393"), ShouldBeFalse)
+		So(ValidateGLN("40063813339AB"), ShouldBeFalse)
+		So(ValidateDUNS("150483782"), ShouldBeTrue)
+		So(ValidateDUNS("15-048-3782"), ShouldBeFalse)
+		So(ValidateDUNS("1504837"), ShouldBeFalse)
+	})
+	Convey("Testing GLN and DUNS on partners", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("EDI Partner").
+				SetIsCompany(true).
+				SetGLN("4006381333931").
+				SetDUNS("150483782"))
+			Convey("Invalid identifiers are rejected", func() {
+				So(func() { partner.SetGLN("4006381333932") }, ShouldPanic)
+				So(func() { partner.SetDUNS("15048378") }, ShouldPanic)
+			})
+			Convey("Partners can be searched by identifier", func() {
+				So(h.Partner().NewSet(env).SearchByName("4006381333931", operator.Equals, q.PartnerCondition{}, 10).Equals(partner), ShouldBeTrue)
+				So(h.Partner().NewSet(env).SearchByName("150483782", operator.ILike, q.PartnerCondition{}, 10).Equals(partner), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
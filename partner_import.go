@@ -0,0 +1,158 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// Matchers used to find existing partners when importing partners
+const (
+	PartnerMatchVAT        = "vat"
+	PartnerMatchEmail      = "email"
+	PartnerMatchRef        = "ref"
+	PartnerMatchExternalID = "external_id"
+)
+
+// PartnerImportMatchers is the selection of the available partner import matchers
+var PartnerImportMatchers = types.Selection{
+	PartnerMatchVAT:        "Tax ID",
+	PartnerMatchEmail:      "Email",
+	PartnerMatchRef:        "Internal Reference",
+	PartnerMatchExternalID: "External ID",
+}
+
+// Policies applied when an imported partner matches an existing one
+const (
+	PartnerImportUpdate    = "update"
+	PartnerImportSkip      = "skip"
+	PartnerImportDuplicate = "duplicate"
+)
+
+// PartnerImportPolicies is the selection of the available partner import policies
+var PartnerImportPolicies = types.Selection{
+	PartnerImportUpdate:    "Update existing partner",
+	PartnerImportSkip:      "Skip line",
+	PartnerImportDuplicate: "Create a new partner",
+}
+
+// Outcomes of ImportPartner
+const (
+	PartnerImportCreated = "created"
+	PartnerImportUpdated = "updated"
+	PartnerImportSkipped = "skipped"
+)
+
+// vatSeparatorsRegex matches the characters that are ignored when comparing VAT numbers
+var vatSeparatorsRegex = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// NormalizeVAT returns the given VAT number in upper case without separators
+func NormalizeVAT(vat string) string {
+	return strings.ToUpper(vatSeparatorsRegex.ReplaceAllString(vat, ""))
+}
+
+// NormalizeEmail returns the address part of the given email in lower case.
+// It accepts both 'john@example.com' and 'John <john@example.com>' syntaxes.
+func NormalizeEmail(email string) string {
+	if addr, err := mail.ParseAddress(email); err == nil {
+		email = addr.Address
+	}
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ImportMatch returns the existing partner matching the given values with the
+// first of the given matchers that finds one, or an empty PartnerSet.
+//
+// Matchers are PartnerMatchVAT, PartnerMatchEmail, PartnerMatchRef and
+// PartnerMatchExternalID. If a matcher finds several partners, the first one
+// is returned, commercial entities first.
+func partner_ImportMatch(rs m.PartnerSet, vals m.PartnerData, matchers []string) m.PartnerSet {
+	table := rs.Collection().Model().Table()
+	for _, matcher := range matchers {
+		var ids []int64
+		switch matcher {
+		case PartnerMatchVAT:
+			vat := NormalizeVAT(vals.VAT())
+			if vat == "" {
+				continue
+			}
+			rs.Env().Cr().Select(&ids, fmt.Sprintf(
+				`SELECT id FROM %s WHERE UPPER(REGEXP_REPLACE(vat, '[^A-Za-z0-9]', '', 'g')) = ?`, table), vat)
+		case PartnerMatchEmail:
+			email := NormalizeEmail(vals.Email())
+			if email == "" {
+				continue
+			}
+			rs.Env().Cr().Select(&ids, fmt.Sprintf(`SELECT id FROM %s WHERE LOWER(TRIM(email)) = ?`, table), email)
+		case PartnerMatchRef:
+			if vals.Ref() == "" {
+				continue
+			}
+			ids = h.Partner().Search(rs.Env(), q.Partner().Ref().Equals(vals.Ref())).Ids()
+		case PartnerMatchExternalID:
+			if vals.HexyaExternalID() == "" {
+				continue
+			}
+			ids = h.Partner().Search(rs.Env(), q.Partner().HexyaExternalID().Equals(vals.HexyaExternalID())).Ids()
+		default:
+			log.Panic(rs.T("Unknown partner import matcher '%s'", matcher))
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		// Search again to apply active filter and access rules
+		partners := h.Partner().Search(rs.Env(), q.Partner().ID().In(ids)).
+			OrderBy("IsCompany desc", "ID")
+		if partners.IsEmpty() {
+			continue
+		}
+		if partners.Len() > 1 {
+			log.Warn("Several partners match imported values", "matcher", matcher, "ids", partners.Ids())
+		}
+		return partners.Records()[0]
+	}
+	return h.Partner().NewSet(rs.Env())
+}
+
+// ImportPartner creates or updates a partner from the given imported values.
+// The existing partner is looked up with the given matchers (see ImportMatch)
+// and policy decides what to do when one is found:
+//
+//   - PartnerImportUpdate: the existing partner is updated with vals
+//   - PartnerImportSkip: the existing partner is left untouched
+//   - PartnerImportDuplicate: a new partner is created anyway
+//
+// It returns the created, updated or skipped partner and the outcome
+// (PartnerImportCreated, PartnerImportUpdated or PartnerImportSkipped).
+func partner_ImportPartner(rs m.PartnerSet, vals m.PartnerData, matchers []string, policy string) (m.PartnerSet, string) {
+	if _, ok := PartnerImportPolicies[policy]; !ok {
+		log.Panic(rs.T("Unknown partner import policy '%s'", policy))
+	}
+	existing := rs.ImportMatch(vals, matchers)
+	if existing.IsEmpty() || policy == PartnerImportDuplicate {
+		if existing.IsNotEmpty() {
+			// The external ID identifies the existing partner
+			vals.UnsetHexyaExternalID()
+		}
+		return h.Partner().Create(rs.Env(), vals), PartnerImportCreated
+	}
+	if policy == PartnerImportSkip {
+		return existing, PartnerImportSkipped
+	}
+	existing.Write(vals)
+	return existing, PartnerImportUpdated
+}
+
+func init() {
+	h.Partner().NewMethod("ImportMatch", partner_ImportMatch)
+	h.Partner().NewMethod("ImportPartner", partner_ImportPartner)
+}
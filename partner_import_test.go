@@ -0,0 +1,59 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerImport(t *testing.T) {
+	Convey("Testing partner import matching", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			existing := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Import Corp").
+				SetIsCompany(true).
+				SetVAT("BE 0477.472.701").
+				SetEmail("Contact@Import-Corp.example").
+				SetRef("IMP001"))
+			Convey("Values are normalized", func() {
+				So(NormalizeVAT("be 0477.472-701"), ShouldEqual, "BE0477472701")
+				So(NormalizeEmail(" John <John@Example.COM>"), ShouldEqual, "john@example.com")
+			})
+			Convey("Partners are matched by VAT, email and reference", func() {
+				pSet := h.Partner().NewSet(env)
+				So(pSet.ImportMatch(h.Partner().NewData().SetVAT("BE0477472701"),
+					[]string{PartnerMatchVAT}).Equals(existing), ShouldBeTrue)
+				So(pSet.ImportMatch(h.Partner().NewData().SetEmail("contact@import-corp.example "),
+					[]string{PartnerMatchEmail}).Equals(existing), ShouldBeTrue)
+				So(pSet.ImportMatch(h.Partner().NewData().SetRef("IMP001"),
+					[]string{PartnerMatchRef}).Equals(existing), ShouldBeTrue)
+				So(pSet.ImportMatch(h.Partner().NewData().SetRef("IMP002").SetVAT("BE0477472701"),
+					[]string{PartnerMatchRef, PartnerMatchVAT}).Equals(existing), ShouldBeTrue)
+				So(pSet.ImportMatch(h.Partner().NewData().SetEmail("other@import-corp.example"),
+					[]string{PartnerMatchEmail}).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Policies decide what to do with matched partners", func() {
+				pSet := h.Partner().NewSet(env)
+				vals := h.Partner().NewData().SetName("Import Corp SA").SetRef("IMP001")
+				partner, outcome := pSet.ImportPartner(vals, []string{PartnerMatchRef}, PartnerImportSkip)
+				So(outcome, ShouldEqual, PartnerImportSkipped)
+				So(partner.Name(), ShouldEqual, "Import Corp")
+				partner, outcome = pSet.ImportPartner(vals, []string{PartnerMatchRef}, PartnerImportUpdate)
+				So(outcome, ShouldEqual, PartnerImportUpdated)
+				So(partner.Equals(existing), ShouldBeTrue)
+				So(existing.Name(), ShouldEqual, "Import Corp SA")
+				partner, outcome = pSet.ImportPartner(vals, []string{PartnerMatchRef}, PartnerImportDuplicate)
+				So(outcome, ShouldEqual, PartnerImportCreated)
+				So(partner.Equals(existing), ShouldBeFalse)
+				So(h.Partner().Search(env, q.Partner().Ref().Equals("IMP001")).Len(), ShouldEqual, 2)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// LangDetectionMinConfidence is the confidence below which a detected
+// language is discarded.
+var LangDetectionMinConfidence = 0.5
+
+// A LanguageDetector guesses the language of a text. It returns the ISO 639-1
+// code of the language, e.g. "fr", and its confidence between 0 and 1, or an
+// empty code if the language is unknown.
+type LanguageDetector interface {
+	DetectLanguage(text string) (string, float64)
+}
+
+// stopwords are frequent words of each language used by StopwordLanguageDetector
+var stopwords = map[string][]string{
+	"en": {"the", "and", "you", "that", "with", "for", "this", "have", "are", "your", "please", "thanks", "regards", "would", "will", "from"},
+	"fr": {"le", "la", "les", "et", "vous", "nous", "est", "une", "pour", "avec", "merci", "bonjour", "cordialement", "des", "dans", "votre"},
+	"de": {"der", "die", "das", "und", "sie", "ich", "ist", "nicht", "mit", "für", "danke", "grüße", "bitte", "ein", "eine", "ihre"},
+	"es": {"el", "los", "las", "y", "usted", "que", "por", "para", "con", "una", "gracias", "saludos", "hola", "su", "del", "es"},
+	"it": {"il", "gli", "di", "che", "per", "con", "una", "sono", "grazie", "saluti", "buongiorno", "della", "non", "è", "lei", "cordiali"},
+	"nl": {"de", "het", "een", "en", "van", "ik", "je", "niet", "met", "voor", "bedankt", "groeten", "graag", "uw", "wij", "zijn"},
+	"pt": {"o", "os", "as", "e", "que", "não", "para", "com", "uma", "obrigado", "obrigada", "cumprimentos", "olá", "seu", "do", "da"},
+}
+
+// StopwordLanguageDetector is a simple LanguageDetector which counts the
+// occurrences of the most frequent words of a few European languages.
+// It is the default LanguageDetector.
+type StopwordLanguageDetector struct{}
+
+// DetectLanguage returns the language with the most stopwords in text.
+// The confidence is the share of the stopwords of text belonging to this language.
+func (d StopwordLanguageDetector) DetectLanguage(text string) (string, float64) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	scores := make(map[string]int)
+	var total int
+	for _, word := range words {
+		for lang, list := range stopwords {
+			for _, stopword := range list {
+				if word == stopword {
+					scores[lang]++
+					total++
+					break
+				}
+			}
+		}
+	}
+	var best string
+	for lang, score := range scores {
+		if score > scores[best] || (score == scores[best] && lang < best) {
+			best = lang
+		}
+	}
+	if best == "" {
+		return "", 0
+	}
+	return best, float64(scores[best]) / float64(total)
+}
+
+var languageDetector = struct {
+	sync.RWMutex
+	detector LanguageDetector
+}{
+	detector: StopwordLanguageDetector{},
+}
+
+// SetLanguageDetector replaces the LanguageDetector used to guess the language
+// of new partners from a text and returns the previous one. Setting a nil
+// detector disables detection from texts.
+func SetLanguageDetector(detector LanguageDetector) LanguageDetector {
+	languageDetector.Lock()
+	defer languageDetector.Unlock()
+	previous := languageDetector.detector
+	languageDetector.detector = detector
+	return previous
+}
+
+// ParseAcceptLanguage returns the language tags of the given Accept-Language
+// HTTP header sorted by decreasing quality, e.g. ["fr-CH", "fr", "en"] for
+// "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5".
+func ParseAcceptLanguage(header string) []string {
+	type langQ struct {
+		tag string
+		q   float64
+	}
+	var tags []langQ
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ";")
+		tag := strings.TrimSpace(parts[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = v
+				}
+			}
+		}
+		if quality > 0 {
+			tags = append(tags, langQ{tag: tag, q: quality})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+	res := make([]string, len(tags))
+	for i, t := range tags {
+		res[i] = t.tag
+	}
+	return res
+}
+
+// MatchLang returns the active language best matching the given language
+// tag, such as "fr", "fr-CA" or "fr_CA". A language with the same region is
+// preferred, then the main region of the language (fr_FR for fr), then any
+// language with the same prefix. It returns an empty LangSet if no active
+// language matches.
+func lang_MatchLang(rs m.LangSet, tag string) m.LangSet {
+	tag = strings.Replace(strings.TrimSpace(tag), "-", "_", 1)
+	prefix := strings.ToLower(strings.SplitN(tag, "_", 2)[0])
+	if prefix == "" {
+		return h.Lang().NewSet(rs.Env())
+	}
+	var candidates []m.LangSet
+	for _, lang := range h.Lang().NewSet(rs.Env()).SearchAll().Records() {
+		code := lang.Code()
+		switch {
+		case strings.EqualFold(code, tag):
+			return lang
+		case strings.EqualFold(strings.SplitN(code, "_", 2)[0], prefix):
+			candidates = append(candidates, lang)
+		}
+	}
+	for _, lang := range candidates {
+		if strings.EqualFold(lang.Code(), prefix+"_"+prefix) {
+			return lang
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0]
+	}
+	return h.Lang().NewSet(rs.Env())
+}
+
+// detectPartnerLang returns the code of the active language detected from the
+// Accept-Language header or the text given in the context, or an empty string.
+func detectPartnerLang(env models.Environment) string {
+	langs := h.Lang().NewSet(env)
+	for _, tag := range ParseAcceptLanguage(ContextGetString(env, ContextKeyAcceptLanguage)) {
+		if lang := langs.MatchLang(tag); lang.IsNotEmpty() {
+			return lang.Code()
+		}
+	}
+	text := ContextGetString(env, ContextKeyDetectLangText)
+	languageDetector.RLock()
+	detector := languageDetector.detector
+	languageDetector.RUnlock()
+	if text == "" || detector == nil {
+		return ""
+	}
+	code, confidence := detector.DetectLanguage(text)
+	if code == "" || confidence < LangDetectionMinConfidence {
+		return ""
+	}
+	return langs.MatchLang(code).Code()
+}
+
+func init() {
+	h.Lang().NewMethod("MatchLang", lang_MatchLang)
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerLangDetection(t *testing.T) {
+	Convey("Testing partner language detection", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			langs := h.Lang().NewSet(env)
+			langs.GetLang("fr_FR").SetActive(true)
+			langs.GetLang("de_DE").SetActive(true)
+			Convey("Accept-Language headers are sorted by quality", func() {
+				So(ParseAcceptLanguage("en;q=0.5, fr-CH, de;q=0.8, *;q=0.1"), ShouldResemble, []string{"fr-CH", "de", "en"})
+			})
+			Convey("Language tags are matched with active languages", func() {
+				So(langs.MatchLang("fr-CH").Code(), ShouldEqual, "fr_FR")
+				So(langs.MatchLang("de").Code(), ShouldEqual, "de_DE")
+				So(langs.MatchLang("xx").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("The language of new partners is set from the browser", func() {
+				partner := h.Partner().NewSet(env).
+					WithContext(ContextKeyAcceptLanguage, "de-AT, en;q=0.8").
+					Create(h.Partner().NewData().SetName("Portal Signup"))
+				So(partner.Lang(), ShouldEqual, "de_DE")
+			})
+			Convey("The language of new partners is detected from their email", func() {
+				partner := h.Partner().NewSet(env).
+					WithContext(ContextKeyDetectLangText, "Bonjour, merci pour votre devis. Cordialement").
+					FindOrCreate("client@example.fr")
+				So(partner.Lang(), ShouldEqual, "fr_FR")
+			})
+			Convey("An explicit language is kept", func() {
+				partner := h.Partner().NewSet(env).
+					WithContext(ContextKeyAcceptLanguage, "de").
+					Create(h.Partner().NewData().SetName("Explicit").SetLang("en_US"))
+				So(partner.Lang(), ShouldEqual, "en_US")
+			})
+		}), ShouldBeNil)
+	})
+}
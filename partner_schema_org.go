@@ -0,0 +1,138 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// schemaOrgContactTypes maps partner address types to schema.org contact point types
+var schemaOrgContactTypes = map[string]string{
+	"contact":  "customer service",
+	"invoice":  "billing support",
+	"delivery": "shipping",
+}
+
+// schemaOrgImageURL returns the absolute URL of the image of the given partner
+func schemaOrgImageURL(rs m.PartnerSet) string {
+	baseURL := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read base URL").GetParam("web.base.url", "")
+	return fmt.Sprintf("%s/web/image?model=Partner&id=%d&field=image", strings.TrimRight(baseURL, "/"), rs.ID())
+}
+
+// SchemaOrgAddress returns the address of this partner as a schema.org
+// PostalAddress, or nil if the partner has no address.
+func partner_SchemaOrgAddress(rs m.PartnerSet) map[string]interface{} {
+	rs.EnsureOne()
+	res := map[string]interface{}{"@type": "PostalAddress"}
+	street := strings.TrimSpace(strings.Join([]string{rs.Street(), rs.Street2()}, "\n"))
+	for key, value := range map[string]string{
+		"streetAddress":   street,
+		"addressLocality": rs.City(),
+		"postalCode":      rs.Zip(),
+		"addressRegion":   cachedState(rs.State()).Name,
+		"addressCountry":  cachedCountry(rs.Country()).Code,
+	} {
+		if value != "" {
+			res[key] = value
+		}
+	}
+	if len(res) == 1 {
+		return nil
+	}
+	return res
+}
+
+// ToSchemaOrg returns this partner as a schema.org Organization (for
+// companies) or Person JSON-LD object, suitable for structured data in web
+// pages. Empty values are omitted.
+//
+// Partners are also exported as organizations if the schema_org_organization
+// context key is set. Organizations list the phone and email of their active
+// contacts as contactPoint, and persons of a company reference it as worksFor.
+func partner_ToSchemaOrg(rs m.PartnerSet) map[string]interface{} {
+	rs.EnsureOne()
+	organization := rs.IsCompany() || ContextGetBool(rs.Env(), ContextKeySchemaOrgOrganization)
+	res := map[string]interface{}{
+		"@context": "https://schema.org",
+		"@type":    "Person",
+		"name":     rs.Name(),
+	}
+	if organization {
+		res["@type"] = "Organization"
+	}
+	for key, value := range map[string]string{
+		"email":     rs.Email(),
+		"telephone": rs.Phone(),
+		"url":       rs.Website(),
+		"vatID":     rs.VAT(),
+	} {
+		if value != "" {
+			res[key] = value
+		}
+	}
+	if address := rs.SchemaOrgAddress(); address != nil {
+		res["address"] = address
+	}
+	if rs.Image() != "" {
+		imageKey := "image"
+		if organization {
+			imageKey = "logo"
+		}
+		res[imageKey] = schemaOrgImageURL(rs)
+	}
+	if !organization {
+		if rs.Function() != "" {
+			res["jobTitle"] = rs.Function()
+		}
+		if commercial := rs.CommercialPartner(); commercial.IsNotEmpty() && !commercial.Equals(rs) {
+			res["worksFor"] = map[string]interface{}{
+				"@type": "Organization",
+				"name":  commercial.Name(),
+			}
+		}
+		return res
+	}
+	var contactPoints []map[string]interface{}
+	for _, child := range rs.Children().Records() {
+		contactType, ok := schemaOrgContactTypes[child.Type()]
+		if !ok || (child.Phone() == "" && child.Email() == "") {
+			continue
+		}
+		point := map[string]interface{}{
+			"@type":       "ContactPoint",
+			"contactType": contactType,
+			"name":        child.Name(),
+		}
+		if child.Phone() != "" {
+			point["telephone"] = child.Phone()
+		}
+		if child.Email() != "" {
+			point["email"] = child.Email()
+		}
+		contactPoints = append(contactPoints, point)
+	}
+	if len(contactPoints) > 0 {
+		res["contactPoint"] = contactPoints
+	}
+	return res
+}
+
+// ToSchemaOrg returns this company as a schema.org Organization JSON-LD object.
+// See Partner.ToSchemaOrg.
+func company_ToSchemaOrg(rs m.CompanySet) map[string]interface{} {
+	rs.EnsureOne()
+	res := rs.Partner().WithContext(ContextKeySchemaOrgOrganization, true).ToSchemaOrg()
+	res["name"] = rs.Name()
+	return res
+}
+
+func init() {
+	h.Partner().NewMethod("SchemaOrgAddress", partner_SchemaOrgAddress)
+	h.Partner().NewMethod("ToSchemaOrg", partner_ToSchemaOrg)
+	h.Company().NewMethod("ToSchemaOrg", company_ToSchemaOrg)
+}
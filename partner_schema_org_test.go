@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerSchemaOrg(t *testing.T) {
+	Convey("Testing schema.org export of partners", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+			company := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Acme").
+				SetIsCompany(true).
+				SetStreet("1 rue de la Paix").
+				SetZip("75002").
+				SetCity("Paris").
+				SetCountry(france).
+				SetWebsite("https://acme.example.com"))
+			contact := h.Partner().Create(env, h.Partner().NewData().
+				SetName("John Smith").
+				SetParent(company).
+				SetType("invoice").
+				SetFunction("Accountant").
+				SetEmail("john@acme.example.com"))
+			Convey("Companies are exported as organizations", func() {
+				data := company.ToSchemaOrg()
+				So(data["@context"], ShouldEqual, "https://schema.org")
+				So(data["@type"], ShouldEqual, "Organization")
+				So(data["name"], ShouldEqual, "Acme")
+				So(data["url"], ShouldEqual, "https://acme.example.com")
+				So(data, ShouldNotContainKey, "telephone")
+				So(data["address"], ShouldResemble, map[string]interface{}{
+					"@type":           "PostalAddress",
+					"streetAddress":   "1 rue de la Paix",
+					"addressLocality": "Paris",
+					"postalCode":      "75002",
+					"addressCountry":  "FR",
+				})
+				So(data["contactPoint"], ShouldResemble, []map[string]interface{}{{
+					"@type":       "ContactPoint",
+					"contactType": "billing support",
+					"name":        "John Smith",
+					"email":       "john@acme.example.com",
+				}})
+			})
+			Convey("Individuals are exported as persons", func() {
+				data := contact.ToSchemaOrg()
+				So(data["@type"], ShouldEqual, "Person")
+				So(data["jobTitle"], ShouldEqual, "Accountant")
+				So(data["worksFor"], ShouldResemble, map[string]interface{}{"@type": "Organization", "name": "Acme"})
+			})
+			Convey("Companies of the application are exported as organizations", func() {
+				mainCompany := h.User().NewSet(env).CurrentUser().Company()
+				data := mainCompany.ToSchemaOrg()
+				So(data["@type"], ShouldEqual, "Organization")
+				So(data["name"], ShouldEqual, mainCompany.Name())
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// ShareRecomputeSyncLimit is the maximum number of users whose Share flag is
+// recomputed synchronously when their groups change. The Share flags of larger
+// sets of users and the PartnerShare flags of their partners are recomputed
+// by a queued job.
+var ShareRecomputeSyncLimit = 50
+
+// RecomputePartnerShare recomputes and stores the PartnerShare flag of these partners
+func partner_RecomputePartnerShare(rs m.PartnerSet) {
+	for _, partner := range rs.AsSuperUser("compute partner share").WithContext("active_test", false).Records() {
+		partner.WithContext(ContextKeyForceComputeWrite, true).Write(partner.ComputePartnerShare())
+	}
+}
+
+// RecomputeShare recomputes and stores the Share flag of these users and
+// the PartnerShare flag of their partners.
+func user_RecomputeShare(rs m.UserSet) {
+	users := rs.AsSuperUser("compute partner share").WithContext("active_test", false)
+	for _, user := range users.Records() {
+		user.WithContext(ContextKeyForceComputeWrite, true).Write(user.ComputeShare())
+	}
+	users.Partner().RecomputePartnerShare()
+}
+
+// InvalidateShare recomputes the Share flag of those of these users for which
+// it does not match their groups anymore, as well as the PartnerShare flag of
+// their partners.
+//
+// Share is computed from the groups of the security registry, which may be
+// synchronised after the Groups field has been written. Up to
+// ShareRecomputeSyncLimit users are recomputed immediately, larger sets are
+// queued for recompute in the job queue.
+func user_InvalidateShare(rs m.UserSet) {
+	var staleIds []int64
+	for _, user := range rs.AsSuperUser("compute partner share").WithContext("active_test", false).Records() {
+		if user.Share() == user.HasGroup(GroupUser.ID()) {
+			staleIds = append(staleIds, user.ID())
+		}
+	}
+	if len(staleIds) == 0 {
+		return
+	}
+	stale := h.User().Browse(rs.Env(), staleIds).AsSuperUser("compute partner share")
+	if len(staleIds) <= ShareRecomputeSyncLimit {
+		stale.RecomputeShare()
+		return
+	}
+	stale.Enqueue(rs.T("Recompute share of users"), h.User().Methods().RecomputeShare())
+}
+
+func user_SyncMembershipsShare(rs m.UserSet) {
+	rs.Super().SyncMemberships()
+	rs.InvalidateShare()
+}
+
+func init() {
+	h.Partner().NewMethod("RecomputePartnerShare", partner_RecomputePartnerShare)
+
+	h.User().NewMethod("RecomputeShare", user_RecomputeShare)
+	h.User().NewMethod("InvalidateShare", user_InvalidateShare)
+	h.User().Methods().SyncMemberships().Extend(user_SyncMembershipsShare)
+}
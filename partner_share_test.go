@@ -0,0 +1,46 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartnerShare(t *testing.T) {
+	Convey("Testing the recompute of PartnerShare on group changes", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			groupUser := h.Group().Search(env, q.Group().GroupID().Equals(GroupUser.ID()))
+			user := h.User().Create(env, h.User().NewData().
+				SetName("Share User").
+				SetLogin("share_user").
+				SetGroups(groupUser))
+			user.SyncMemberships()
+			So(user.Share(), ShouldBeFalse)
+			So(user.Partner().PartnerShare(), ShouldBeFalse)
+			Convey("Removing the user group recomputes the share flags immediately", func() {
+				user.SetGroups(h.Group().NewSet(env))
+				So(user.Share(), ShouldBeTrue)
+				So(user.Partner().PartnerShare(), ShouldBeTrue)
+			})
+			Convey("Large group changes queue the recompute", func() {
+				limit := ShareRecomputeSyncLimit
+				ShareRecomputeSyncLimit = 0
+				defer func() { ShareRecomputeSyncLimit = limit }()
+				user.SetGroups(h.Group().NewSet(env))
+				job := h.QueueJob().Search(env, q.QueueJob().Model().Equals("User").
+					And().Method().Equals("RecomputeShare"))
+				So(job.IsNotEmpty(), ShouldBeTrue)
+				user.RecomputeShare()
+				So(user.Share(), ShouldBeTrue)
+				So(user.Partner().PartnerShare(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
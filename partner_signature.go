@@ -0,0 +1,241 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// SignatureMaxLines is the maximum number of lines at the end of an email
+// body which are considered as its signature when it has no delimiter.
+var SignatureMaxLines = 10
+
+// A SignatureRule extracts the value of a partner field from a line of an
+// email signature. The value is the first submatch of the pattern, or the
+// whole match if the pattern has no group.
+type SignatureRule struct {
+	Field   string
+	Pattern *regexp.Regexp
+}
+
+var signatureRules = struct {
+	sync.RWMutex
+	rules []SignatureRule
+}{}
+
+// RegisterSignatureRule registers a rule extracting the given partner field
+// from the lines of email signatures matching pattern. Rules take precedence
+// over the built-in heuristics of ParseSignature. It panics if the pattern
+// is not a valid regular expression.
+func RegisterSignatureRule(field, pattern string) {
+	signatureRules.Lock()
+	defer signatureRules.Unlock()
+	signatureRules.rules = append(signatureRules.rules, SignatureRule{Field: field, Pattern: regexp.MustCompile(pattern)})
+}
+
+// SignatureRules returns the registered signature rules
+func SignatureRules() []SignatureRule {
+	signatureRules.RLock()
+	defer signatureRules.RUnlock()
+	res := make([]SignatureRule, len(signatureRules.rules))
+	copy(res, signatureRules.rules)
+	return res
+}
+
+var (
+	signatureDelimiterRegex = regexp.MustCompile(`(?m)^-- ?$`)
+	signatureReplyRegex     = regexp.MustCompile(`(?i)^(on .+ wrote:|le .+ a écrit ?:|-+ ?original message ?-+)$`)
+	signatureClosingRegex   = regexp.MustCompile(`(?i)^(best|kind|warm)?\s*(regards|wishes)|^(thanks|thank you|cheers|sincerely|cordialement|bien à vous)\b`)
+	signaturePhoneRegex     = regexp.MustCompile(`\+?\(?\d[\d\s().-]{6,}\d`)
+	signaturePhoneLabel     = regexp.MustCompile(`(?i)^(tel|tél|phone|office|direct|t)\b\.?\s*:?`)
+	signatureMobileLabel    = regexp.MustCompile(`(?i)^(mobile|mob|cell|portable|gsm|m)\b\.?\s*:?`)
+	signatureZipCityRegex   = regexp.MustCompile(`^(?:([A-Z]{1,2})-)?(\d{4,5})\s+(\p{L}[\p{L}\s'-]+)$`)
+	signatureStreetRegex    = regexp.MustCompile(`(?i)^\d+[a-z]?,?\s+\p{L}|\b(street|st\.|avenue|ave|road|rd\.|boulevard|blvd|lane|rue|chemin|allée|place|straße|strasse|weg)\b`)
+	signatureCompanyRegex   = regexp.MustCompile(`\b(Inc|Ltd|LLC|LLP|PLC|Corp|Limited|GmbH|AG|SA|SAS|SARL|S\.A\.|BV|NV|SpA|Srl)\.?$`)
+	signatureFunctionRegex  = regexp.MustCompile(`(?i)\b(manager|director|directeur|directrice|head of|ceo|cfo|cto|coo|president|founder|engineer|developer|consultant|officer|assistant|accountant|responsable|gérant|sales|buyer|analyst|partner)\b`)
+	signatureURLRegex       = regexp.MustCompile(`(?i)^(https?://|www\.)\S+$`)
+)
+
+// signatureLines returns the non-empty trimmed lines of the signature of the
+// given email body. The signature is the text after the standard "-- "
+// delimiter or, failing this, the last lines of the body after the closing
+// formula. Quoted text of replies is ignored.
+func signatureLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if signatureReplyRegex.MatchString(line) {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	body := strings.Join(lines, "\n")
+	if locs := signatureDelimiterRegex.FindAllStringIndex(body, -1); len(locs) > 0 {
+		body = body[locs[len(locs)-1][1]:]
+		return nonEmptyLines(body)
+	}
+	lines = nonEmptyLines(body)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if signatureClosingRegex.MatchString(lines[i]) {
+			return lines[i+1:]
+		}
+	}
+	if len(lines) > SignatureMaxLines {
+		lines = lines[len(lines)-SignatureMaxLines:]
+	}
+	return lines
+}
+
+// nonEmptyLines returns the trimmed non-empty lines of text
+func nonEmptyLines(text string) []string {
+	var res []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			res = append(res, line)
+		}
+	}
+	return res
+}
+
+// parseSignature extracts partner values from the given email body, applying
+// first the given rules and then the built-in heuristics.
+func parseSignature(text string, rules []SignatureRule) map[string]string {
+	res := make(map[string]string)
+	set := func(field, value string) {
+		value = strings.TrimSpace(value)
+		if _, exists := res[field]; !exists && value != "" {
+			res[field] = value
+		}
+	}
+	lines := signatureLines(text)
+	used := make(map[int]bool)
+	for _, rule := range rules {
+		for i, line := range lines {
+			match := rule.Pattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			set(rule.Field, value)
+			used[i] = true
+			break
+		}
+	}
+	for i, line := range lines {
+		if used[i] || strings.Contains(line, "@") || signatureURLRegex.MatchString(line) {
+			continue
+		}
+		// A line may hold several items, such as "Tel: ... | Mobile: ..."
+		for _, part := range strings.FieldsFunc(line, func(r rune) bool { return r == '|' || r == '•' || r == '·' }) {
+			part = strings.TrimSpace(part)
+			switch {
+			case signatureMobileLabel.MatchString(part) && signaturePhoneRegex.MatchString(part):
+				set("Mobile", signaturePhoneRegex.FindString(part))
+			case signaturePhoneLabel.MatchString(part) && signaturePhoneRegex.MatchString(part):
+				set("Phone", signaturePhoneRegex.FindString(part))
+			case signatureZipCityRegex.MatchString(part):
+				match := signatureZipCityRegex.FindStringSubmatch(part)
+				set("Zip", match[2])
+				set("City", match[3])
+			case strings.Contains(part, ",") && signatureZipCityRegex.MatchString(strings.TrimSpace(part[strings.LastIndex(part, ",")+1:])):
+				comma := strings.LastIndex(part, ",")
+				match := signatureZipCityRegex.FindStringSubmatch(strings.TrimSpace(part[comma+1:]))
+				set("Street", part[:comma])
+				set("Zip", match[2])
+				set("City", match[3])
+			case signaturePhoneRegex.MatchString(part) && len(signaturePhoneRegex.FindString(part)) >= len(part)-4:
+				set("Phone", signaturePhoneRegex.FindString(part))
+			case signatureCompanyRegex.MatchString(part):
+				set("CompanyName", part)
+			case signatureStreetRegex.MatchString(part):
+				set("Street", part)
+			case signatureFunctionRegex.MatchString(part) && len(part) <= 64:
+				set("Function", part)
+			}
+		}
+	}
+	return res
+}
+
+// ParseSignature extracts the phone, mobile, job position, company and address
+// of the sender from the signature of the given email body. It applies the
+// registered signature rules and the rules of the base.signature_rules
+// parameter of the given environment before the built-in heuristics.
+// The result is keyed by Partner field name and can be passed to ProposeChanges.
+func ParseSignature(env models.Environment, text string) map[string]string {
+	rules := SignatureRules()
+	if param := h.ConfigParameter().NewSet(env).AsSuperUser("read signature rules").GetParam("base.signature_rules", ""); param != "" {
+		var patterns map[string]string
+		if err := json.Unmarshal([]byte(param), &patterns); err != nil {
+			log.Warn("Invalid signature rules parameter", "error", err)
+		}
+		for field, pattern := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				log.Warn("Invalid signature rule", "field", field, "pattern", pattern, "error", err)
+				continue
+			}
+			rules = append(rules, SignatureRule{Field: field, Pattern: re})
+		}
+	}
+	return parseSignature(text, rules)
+}
+
+// ProposeSignatureChanges parses the signature of the given email body and
+// files a PartnerChangeRequest for this partner with the values that differ.
+// sourceRef is typically the Message-ID of the email.
+func partner_ProposeSignatureChanges(rs m.PartnerSet, text, sourceRef string) m.PartnerChangeRequestSet {
+	rs.EnsureOne()
+	values := ParseSignature(rs.Env(), text)
+	if len(values) == 0 {
+		return h.PartnerChangeRequest().NewSet(rs.Env())
+	}
+	return rs.ProposeChanges(values, PartnerChangeSourceEmail, sourceRef)
+}
+
+// ProcessMessageSignature is meant to be called by the inbound mail gateway
+// for each incoming email. It files a PartnerChangeRequest with the data of
+// the signature for the partner matching the sender address, if any. Only
+// plain text emails are parsed.
+func ProcessMessageSignature(env models.Environment, msg *mail.Message) m.PartnerChangeRequestSet {
+	res := h.PartnerChangeRequest().NewSet(env)
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return res
+	}
+	if mediaType, _, err := mime.ParseMediaType(msg.Header.Get("Content-Type")); err == nil && mediaType != "text/plain" {
+		return res
+	}
+	partner := h.Partner().NewSet(env).AsSuperUser("match email signature sender").Search(q.Partner().Email().ILike(from.Address)).Limit(1)
+	if partner.IsEmpty() {
+		return res
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		log.Warn("Unable to read email body", "message", msg.Header.Get("Message-Id"), "error", err)
+		return res
+	}
+	return partner.ProposeSignatureChanges(string(body), msg.Header.Get("Message-Id"))
+}
+
+func init() {
+	h.Partner().NewMethod("ProposeSignatureChanges", partner_ProposeSignatureChanges)
+}
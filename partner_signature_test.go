@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testSignatureEmail = `From: John Smith <john.smith@acme.example>
+Message-Id: <sig-test@acme.example>
+Content-Type: text/plain; charset=utf-8
+
+Hello,
+
+Please find the quotation attached.
+
+Best regards,
+John Smith
+Sales Manager
+Acme Widgets Ltd
+12 Baker Street
+75001 Paris
+Tel: +33 1 23 45 67 89 | Mobile: +33 6 12 34 56 78
+www.acme.example
+
+On Mon, Bob wrote:
+> Tel: 0000000000
+`
+
+func TestParseSignature(t *testing.T) {
+	Convey("Testing email signature parsing", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Heuristics extract the signature data", func() {
+				values := ParseSignature(env, testSignatureEmail)
+				So(values, ShouldResemble, map[string]string{
+					"Function":    "Sales Manager",
+					"CompanyName": "Acme Widgets Ltd",
+					"Street":      "12 Baker Street",
+					"Zip":         "75001",
+					"City":        "Paris",
+					"Phone":       "+33 1 23 45 67 89",
+					"Mobile":      "+33 6 12 34 56 78",
+				})
+			})
+			Convey("Signature delimiter and configured rules are used", func() {
+				h.ConfigParameter().NewSet(env).SetParam("base.signature_rules", `{"Function": "^Role: (.+)$"}`)
+				values := ParseSignature(env, "Hi,\nSee you.\n-- \nJane\nRole: Chief Happiness Officer\n3 rue de la Paix, 75002 Paris")
+				So(values["Function"], ShouldEqual, "Chief Happiness Officer")
+				So(values["Street"], ShouldEqual, "3 rue de la Paix")
+				So(values["Zip"], ShouldEqual, "75002")
+			})
+			Convey("Incoming emails file a change request for the sender", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("John Smith").
+					SetEmail("john.smith@acme.example").
+					SetCity("Paris"))
+				msg, err := mail.ReadMessage(strings.NewReader(testSignatureEmail))
+				So(err, ShouldBeNil)
+				request := ProcessMessageSignature(env, msg)
+				So(request.Len(), ShouldEqual, 1)
+				So(request.Partner().Equals(partner), ShouldBeTrue)
+				So(request.Source(), ShouldEqual, PartnerChangeSourceEmail)
+				So(request.SourceRef(), ShouldEqual, "<sig-test@acme.example>")
+				So(request.Lines().Len(), ShouldEqual, 6)
+				So(partner.Phone(), ShouldBeBlank)
+			})
+		}), ShouldBeNil)
+	})
+}
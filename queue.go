@@ -233,6 +233,14 @@ func runQueueJobs() {
 						SetState("failed").
 						SetDateDone(dates.Now()).
 						SetExcInfo(err.Error()))
+					LogEvent(env, LogEntry{
+						Level:   LoggingError,
+						Logger:  "queue",
+						Message: fmt.Sprintf("Job '%s' failed: %s", job.Name(), err),
+						Func:    fmt.Sprintf("%s.%s", job.Model(), job.Method()),
+						Model:   "QueueJob",
+						ResID:   job.ID(),
+					})
 					return
 				}
 				job.Write(h.QueueJob().NewData().
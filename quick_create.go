@@ -0,0 +1,110 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fieldtype"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// contextIDs returns the record ids held by the given context value, which
+// may be a single id or a list of ids as sent by the client.
+func contextIDs(value interface{}) []int64 {
+	switch val := value.(type) {
+	case int:
+		return []int64{int64(val)}
+	case int64:
+		return []int64{val}
+	case float64:
+		return []int64{int64(val)}
+	case []int64:
+		return val
+	case []interface{}:
+		var res []int64
+		for _, v := range val {
+			res = append(res, contextIDs(v)...)
+		}
+		return res
+	}
+	return nil
+}
+
+// ApplyContextDefaults sets on the given data the values of the
+// "default_<field>" keys of the context, for each field which is not already
+// set in data. Relational fields expect ids in the context, and Many2Many fields
+// also accept a single id with the singular key, e.g. "default_category_id"
+// for "category_ids".
+//
+// Models implementing a NameCreateData hook should call this method so that
+// quick-create from the client honours the defaults of the current view.
+func baseMixin_ApplyContextDefaults(rs m.BaseMixinSet, data models.RecordData) {
+	ctx := rs.Env().Context()
+	model := rs.Collection().Model()
+	res := data.Underlying()
+	for jsonName, fi := range model.FieldsGet() {
+		key := "default_" + jsonName
+		if !ctx.HasKey(key) && fi.Type == fieldtype.Many2Many && strings.HasSuffix(jsonName, "_ids") {
+			key = "default_" + strings.TrimSuffix(jsonName, "s")
+		}
+		if !ctx.HasKey(key) {
+			continue
+		}
+		field := model.FieldName(fi.Name)
+		if res.Has(field) {
+			continue
+		}
+		value := ctx.Get(key)
+		switch fi.Type {
+		case fieldtype.One2Many:
+			continue
+		case fieldtype.Many2One, fieldtype.Many2Many:
+			ids := contextIDs(value)
+			if fi.Type == fieldtype.Many2One && len(ids) > 1 {
+				log.Warn("Several ids given for a Many2One default", "key", key, "value", value)
+				ids = ids[:1]
+			}
+			res.Set(field, models.Registry.MustGet(fi.Relation).Browse(rs.Env(), ids).Wrap())
+		case fieldtype.Integer:
+			if ids := contextIDs(value); len(ids) == 1 {
+				res.Set(field, ids[0])
+			}
+		default:
+			res.Set(field, value)
+		}
+	}
+}
+
+// NameCreateData returns the data used by NameCreate to create a partner
+// from the given string, which may be a name and/or an email. All
+// "default_<field>" keys of the context are applied, so that a partner
+// quick-created from a filtered list gets e.g. the "default_category_id" tag.
+//
+// If only an email address is received and that the regex cannot find
+// a name, the name will have the email value.
+// If 'force_email' key in context: must find the email address.
+func partner_NameCreateData(rs m.PartnerSet, name string) m.PartnerData {
+	name, email := rs.ParsePartnerName(name)
+	if email == "" && ContextGetBool(rs.Env(), ContextKeyForceEmail) {
+		panic(rs.T("Couldn't create contact without email address!"))
+	}
+	if name == "" && email != "" {
+		name = email
+	}
+	res := h.Partner().NewData().SetName(name)
+	if email != "" {
+		res.SetEmail(email)
+	}
+	rs.ApplyContextDefaults(res)
+	return res
+}
+
+func init() {
+	h.BaseMixin().NewMethod("ApplyContextDefaults", baseMixin_ApplyContextDefaults)
+
+	h.Partner().NewMethod("NameCreateData", partner_NameCreateData)
+}
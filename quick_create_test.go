@@ -0,0 +1,45 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestQuickCreate(t *testing.T) {
+	Convey("Testing partner quick-create with context defaults", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			category := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Quick Tag"))
+			company := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Quick Company").
+				SetIsCompany(true))
+			Convey("All default keys of the context are applied", func() {
+				partner := h.Partner().NewSet(env).
+					WithContext("default_category_id", category.ID()).
+					WithContext("default_parent_id", company.ID()).
+					WithContext("default_function", "Buyer").
+					NameCreate("Quick Buyer")
+				So(partner.Name(), ShouldEqual, "Quick Buyer")
+				So(partner.Function(), ShouldEqual, "Buyer")
+				So(partner.Parent().Equals(company), ShouldBeTrue)
+				So(partner.Categories().Equals(category), ShouldBeTrue)
+			})
+			Convey("Parsed values take precedence over context defaults", func() {
+				partner := h.Partner().NewSet(env).
+					WithContext("default_email", "default@example.com").
+					NameCreate("Quick Seller <seller@example.com>")
+				So(partner.Email(), ShouldEqual, "seller@example.com")
+				noEmail := h.Partner().NewSet(env).
+					WithContext("default_email", "default@example.com").
+					NameCreate("Quick Seller")
+				So(noEmail.Email(), ShouldEqual, "default@example.com")
+			})
+		}), ShouldBeNil)
+	})
+}
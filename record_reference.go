@@ -0,0 +1,135 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Policies applied to the records referencing a deleted record through
+// their ResModel and ResID fields.
+const (
+	// ReferenceCascade deletes the referencing records
+	ReferenceCascade = "cascade"
+	// ReferenceSetNull resets the ResID of the referencing records to 0
+	ReferenceSetNull = "set null"
+)
+
+// referenceHolders maps the models referencing records of any model with
+// ResModel and ResID fields to their deletion policy.
+var referenceHolders = struct {
+	sync.RWMutex
+	policies map[string]string
+}{
+	policies: make(map[string]string),
+}
+
+// RegisterReferenceHolder declares that the given model references records of
+// any model with its ResModel and ResID fields. When a referenced record is
+// deleted, the referencing records are deleted or unlinked according to the
+// given policy. It panics if the model is already registered.
+func RegisterReferenceHolder(model, policy string) {
+	referenceHolders.Lock()
+	defer referenceHolders.Unlock()
+	if _, exists := referenceHolders.policies[model]; exists {
+		log.Panic("Reference holder already registered", "model", model)
+	}
+	if policy != ReferenceCascade && policy != ReferenceSetNull {
+		log.Panic("Unknown reference deletion policy", "model", model, "policy", policy)
+	}
+	referenceHolders.policies[model] = policy
+}
+
+// ReferencedRecord returns the record of the given model with the given ID
+// as superuser. It returns nil if the model does not exist and an empty
+// collection if the record does not exist.
+func ReferencedRecord(env models.Environment, model string, id int64) *models.RecordCollection {
+	mi, exists := models.Registry.Get(model)
+	if !exists {
+		return nil
+	}
+	return asSuperUser(env.Pool(model), "resolve record reference").Search(mi.Field(models.ID).Equals(id))
+}
+
+// ReferencedRecordName returns the display name of the record of the given
+// model with the given ID, or an empty string if it does not exist.
+func ReferencedRecordName(env models.Environment, model string, id int64) string {
+	if model == "" || id == 0 {
+		return ""
+	}
+	record := ReferencedRecord(env, model, id)
+	if record == nil || record.IsEmpty() {
+		return ""
+	}
+	return record.Call("NameGet").(string)
+}
+
+// CheckReference returns an error if the given model does not exist or has no
+// record with the given ID.
+func CheckReference(env models.Environment, model string, id int64) error {
+	record := ReferencedRecord(env, model, id)
+	switch {
+	case record == nil:
+		return fmt.Errorf("unknown model '%s'", model)
+	case record.IsEmpty():
+		return fmt.Errorf("record %s(%d) does not exist", model, id)
+	}
+	return nil
+}
+
+// cleanupReferences applies the policy of each reference holder to its
+// records referencing the records of the given model with the given IDs.
+func cleanupReferences(env models.Environment, model string, ids []int64) {
+	referenceHolders.RLock()
+	policies := make(map[string]string, len(referenceHolders.policies))
+	for holder, policy := range referenceHolders.policies {
+		policies[holder] = policy
+	}
+	referenceHolders.RUnlock()
+	for holder, policy := range policies {
+		if holder == model {
+			continue
+		}
+		hm := models.Registry.MustGet(holder)
+		refs := asSuperUser(env.Pool(holder), "find record references").Search(hm.Field(hm.FieldName("ResModel")).Equals(model).
+			And().Field(hm.FieldName("ResID")).In(ids))
+		if refs.IsEmpty() {
+			continue
+		}
+		switch policy {
+		case ReferenceCascade:
+			refs.Call("Unlink")
+		case ReferenceSetNull:
+			data := models.NewModelDataFromRS(refs)
+			data.Set(hm.FieldName("ResID"), int64(0))
+			refs.Call("Write", data)
+		}
+	}
+}
+
+func baseMixin_UnlinkCleanupReferences(rs m.BaseMixinSet) int64 {
+	ids := rs.Ids()
+	env := rs.Env()
+	model := rs.ModelName()
+	res := rs.Super().Unlink()
+	if len(ids) > 0 {
+		cleanupReferences(env, model, ids)
+	}
+	return res
+}
+
+func init() {
+	h.BaseMixin().Methods().Unlink().Extend(baseMixin_UnlinkCleanupReferences)
+
+	RegisterReferenceHolder("Activity", ReferenceCascade)
+	RegisterReferenceHolder("ApprovalRequest", ReferenceCascade)
+	RegisterReferenceHolder("ShareLink", ReferenceCascade)
+	RegisterReferenceHolder("TagLink", ReferenceCascade)
+	RegisterReferenceHolder("Attachment", ReferenceSetNull)
+}
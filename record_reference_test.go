@@ -0,0 +1,59 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRecordReferences(t *testing.T) {
+	Convey("Testing record references", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Referenced Partner"))
+			Convey("References are resolved and checked", func() {
+				So(ReferencedRecordName(env, "Partner", partner.ID()), ShouldEqual, "Referenced Partner")
+				So(ReferencedRecordName(env, "NoSuchModel", partner.ID()), ShouldBeEmpty)
+				So(ReferencedRecordName(env, "Partner", 0), ShouldBeEmpty)
+				So(ReferencedRecord(env, "NoSuchModel", 1), ShouldBeNil)
+				So(CheckReference(env, "Partner", partner.ID()), ShouldBeNil)
+				So(CheckReference(env, "NoSuchModel", partner.ID()), ShouldNotBeNil)
+				So(CheckReference(env, "Partner", -1), ShouldNotBeNil)
+			})
+			Convey("Registering a holder twice or with an unknown policy panics", func() {
+				So(func() { RegisterReferenceHolder("Activity", ReferenceCascade) }, ShouldPanic)
+				So(func() { RegisterReferenceHolder("Partner", "restrict") }, ShouldPanic)
+			})
+			Convey("Referencing records are cleaned up when the target is deleted", func() {
+				activity := h.Activity().Create(env, h.Activity().NewData().
+					SetSummary("Follow up").
+					SetDateDeadline(dates.Today()).
+					SetResModel("Partner").
+					SetResID(partner.ID()))
+				So(activity.ResName(), ShouldEqual, "Referenced Partner")
+				attachment := h.Attachment().Create(env, h.Attachment().NewData().
+					SetName("contract.pdf").
+					SetResModel("Partner").
+					SetResID(partner.ID()))
+				other := h.Partner().Create(env, h.Partner().NewData().SetName("Other Partner"))
+				kept := h.Activity().Create(env, h.Activity().NewData().
+					SetSummary("Keep me").
+					SetDateDeadline(dates.Today()).
+					SetResModel("Partner").
+					SetResID(other.ID()))
+				partner.Unlink()
+				So(h.Activity().Search(env, q.Activity().ID().Equals(activity.ID())).IsEmpty(), ShouldBeTrue)
+				So(h.Activity().Search(env, q.Activity().ID().Equals(kept.ID())).IsNotEmpty(), ShouldBeTrue)
+				So(attachment.ResID(), ShouldEqual, 0)
+				So(attachment.ResModel(), ShouldEqual, "Partner")
+			})
+		}), ShouldBeNil)
+	})
+}
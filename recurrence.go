@@ -0,0 +1,114 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"time"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+var fields_RecurrenceRule = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true},
+	"Rule": fields.Char{String: "RRULE", Required: true, Constraint: h.RecurrenceRule().Methods().CheckRule(),
+		Help: "Recurrence rule in RFC 5545 format, e.g. FREQ=WEEKLY;BYDAY=MO,WE,FR"},
+	"DateStart": fields.DateTime{String: "Start Date", Required: true, Default: func(models.Environment) interface{} {
+		return dates.Now()
+	}, Help: "First occurrence of the recurrence"},
+	"TZ": fields.Char{String: "Timezone",
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Partner().TZ()
+		},
+		Help: `Timezone in which the recurrence is expanded, so that occurrences
+keep the same local time across DST changes.`},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckRule checks that the Rule field is a valid RRULE
+func recurrenceRule_CheckRule(rs m.RecurrenceRuleSet) {
+	for _, rec := range rs.Records() {
+		if _, err := basetypes.ParseRRule(rec.Rule()); err != nil {
+			log.Panic(rs.T("Invalid recurrence rule: %s", err))
+		}
+	}
+}
+
+// ParsedRule returns the parsed RRule of this record
+func recurrenceRule_ParsedRule(rs m.RecurrenceRuleSet) *basetypes.RRule {
+	rs.EnsureOne()
+	rule, err := basetypes.ParseRRule(rs.Rule())
+	if err != nil {
+		log.Panic(rs.T("Invalid recurrence rule: %s", err))
+	}
+	return rule
+}
+
+// TimeLocation returns the time location in which this recurrence is
+// expanded, or UTC if it has no timezone or an invalid one.
+func recurrenceRule_TimeLocation(rs m.RecurrenceRuleSet) *time.Location {
+	rs.EnsureOne()
+	if rs.TZ() == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(rs.TZ())
+	if err != nil {
+		log.Warn("Invalid timezone on recurrence rule", "rule", rs.ID(), "tz", rs.TZ(), "error", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// Between returns all the occurrences of this recurrence
+// between start and end (both included).
+func recurrenceRule_Between(rs m.RecurrenceRuleSet, start, end dates.DateTime) []dates.DateTime {
+	var res []dates.DateTime
+	rs.ParsedRule().Iterate(rs.DateStart().In(rs.TimeLocation()), func(t time.Time) bool {
+		if t.After(end.Time) {
+			return false
+		}
+		if !t.Before(start.Time) {
+			res = append(res, dates.DateTime{Time: t.UTC()})
+		}
+		return true
+	})
+	return res
+}
+
+// NextOccurrences returns the n next occurrences of this recurrence from now.
+func recurrenceRule_NextOccurrences(rs m.RecurrenceRuleSet, n int) []dates.DateTime {
+	return rs.NextOccurrencesAfter(dates.Now(), n)
+}
+
+// NextOccurrencesAfter returns the n first occurrences of this
+// recurrence strictly after the given date.
+func recurrenceRule_NextOccurrencesAfter(rs m.RecurrenceRuleSet, after dates.DateTime, n int) []dates.DateTime {
+	var res []dates.DateTime
+	if n <= 0 {
+		return res
+	}
+	rs.ParsedRule().Iterate(rs.DateStart().In(rs.TimeLocation()), func(t time.Time) bool {
+		if t.After(after.Time) {
+			res = append(res, dates.DateTime{Time: t.UTC()})
+		}
+		return len(res) < n
+	})
+	return res
+}
+
+func init() {
+	models.NewModel("RecurrenceRule")
+	h.RecurrenceRule().AddFields(fields_RecurrenceRule)
+
+	h.RecurrenceRule().NewMethod("CheckRule", recurrenceRule_CheckRule)
+	h.RecurrenceRule().NewMethod("ParsedRule", recurrenceRule_ParsedRule)
+	h.RecurrenceRule().NewMethod("TimeLocation", recurrenceRule_TimeLocation)
+	h.RecurrenceRule().NewMethod("Between", recurrenceRule_Between)
+	h.RecurrenceRule().NewMethod("NextOccurrences", recurrenceRule_NextOccurrences)
+	h.RecurrenceRule().NewMethod("NextOccurrencesAfter", recurrenceRule_NextOccurrencesAfter)
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// ReferenceCacheTTL is the maximum time a value is kept in the reference cache.
+//
+// Entries are invalidated when the records of their model are modified, but
+// only in this process, so this TTL bounds the staleness of values in
+// multi-process deployments or read by concurrent transactions.
+var ReferenceCacheTTL = 10 * time.Minute
+
+type referenceCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// referenceCache is an in-process read-through cache for small and hot
+// reference models (countries, states, currencies, titles and languages).
+//
+// Transactions that modified a model bypass the cache for this model, so
+// that they neither read stale values nor cache uncommitted ones.
+var referenceCache = struct {
+	sync.RWMutex
+	entries map[string]map[string]referenceCacheEntry
+	dirty   map[*models.Cursor]map[string]time.Time
+}{
+	entries: make(map[string]map[string]referenceCacheEntry),
+	dirty:   make(map[*models.Cursor]map[string]time.Time),
+}
+
+// CachedReference returns the value cached for the given model and key. If
+// there is none, or if it has expired, load is called and its result is cached.
+//
+// Cached values must not be RecordSets, since those are bound to an
+// environment. Cache IDs or plain values instead.
+func CachedReference(env models.Environment, model, key string, load func() interface{}) interface{} {
+	referenceCache.RLock()
+	_, dirty := referenceCache.dirty[env.Cr()][model]
+	entry, ok := referenceCache.entries[model][key]
+	referenceCache.RUnlock()
+	if dirty {
+		metricReferenceCache.Inc(model, "bypass")
+		return load()
+	}
+	if ok && time.Now().Before(entry.expires) {
+		metricReferenceCache.Inc(model, "hit")
+		return entry.value
+	}
+	metricReferenceCache.Inc(model, "miss")
+	value := load()
+	referenceCache.Lock()
+	defer referenceCache.Unlock()
+	if referenceCache.entries[model] == nil {
+		referenceCache.entries[model] = make(map[string]referenceCacheEntry)
+	}
+	referenceCache.entries[model][key] = referenceCacheEntry{value: value, expires: time.Now().Add(ReferenceCacheTTL)}
+	return value
+}
+
+// InvalidateReferenceCache removes all the cached values of the given model
+// and makes the transaction of env bypass the cache for this model.
+func InvalidateReferenceCache(env models.Environment, model string) {
+	referenceCache.Lock()
+	defer referenceCache.Unlock()
+	delete(referenceCache.entries, model)
+	now := time.Now()
+	// Forget old transactions so that this map does not grow indefinitely
+	for cr, dirtyModels := range referenceCache.dirty {
+		for mName, date := range dirtyModels {
+			if now.Sub(date) > ReferenceCacheTTL {
+				delete(dirtyModels, mName)
+			}
+		}
+		if len(dirtyModels) == 0 {
+			delete(referenceCache.dirty, cr)
+		}
+	}
+	if referenceCache.dirty[env.Cr()] == nil {
+		referenceCache.dirty[env.Cr()] = make(map[string]time.Time)
+	}
+	referenceCache.dirty[env.Cr()][model] = now
+}
+
+// cacheKey returns the cache key of the given record in the language of its context
+func cacheKey(rs models.RecordSet) string {
+	return fmt.Sprintf("%d/%s", rs.Ids()[0], rs.Env().Context().GetString("lang"))
+}
+
+// countryCacheData holds the cached values of a country
+type countryCacheData struct {
+	Code          string
+	Name          string
+	AddressFormat string
+}
+
+// cachedCountry returns the cached values of the given country
+func cachedCountry(country m.CountrySet) countryCacheData {
+	if country.IsEmpty() {
+		return countryCacheData{}
+	}
+	return CachedReference(country.Env(), "Country", cacheKey(country), func() interface{} {
+		return countryCacheData{
+			Code:          country.Code(),
+			Name:          country.Name(),
+			AddressFormat: country.AddressFormat(),
+		}
+	}).(countryCacheData)
+}
+
+// stateCacheData holds the cached values of a country state
+type stateCacheData struct {
+	Code string
+	Name string
+}
+
+// cachedState returns the cached values of the given state
+func cachedState(state m.CountryStateSet) stateCacheData {
+	if state.IsEmpty() {
+		return stateCacheData{}
+	}
+	return CachedReference(state.Env(), "CountryState", cacheKey(state), func() interface{} {
+		return stateCacheData{
+			Code: state.Code(),
+			Name: state.Name(),
+		}
+	}).(stateCacheData)
+}
+
+// titleCacheData holds the cached values of a partner title
+type titleCacheData struct {
+	Name     string
+	Shortcut string
+	Position string
+}
+
+// cachedTitle returns the cached values of the given partner title
+func cachedTitle(title m.PartnerTitleSet) titleCacheData {
+	if title.IsEmpty() {
+		return titleCacheData{}
+	}
+	return CachedReference(title.Env(), "PartnerTitle", cacheKey(title), func() interface{} {
+		return titleCacheData{
+			Name:     title.Name(),
+			Shortcut: title.Shortcut(),
+			Position: title.Position(),
+		}
+	}).(titleCacheData)
+}
+
+// currencyCacheData holds the cached values of a currency
+type currencyCacheData struct {
+	Name     string
+	Symbol   string
+	Position string
+}
+
+// cachedCurrency returns the cached values of the given currency
+func cachedCurrency(currency m.CurrencySet) currencyCacheData {
+	if currency.IsEmpty() {
+		return currencyCacheData{}
+	}
+	return CachedReference(currency.Env(), "Currency", cacheKey(currency), func() interface{} {
+		return currencyCacheData{
+			Name:     currency.Name(),
+			Symbol:   currency.Symbol(),
+			Position: currency.Position(),
+		}
+	}).(currencyCacheData)
+}
+
+func referenceCacheMixin_Create(rs m.ReferenceCacheMixinSet, vals m.ReferenceCacheMixinData) m.ReferenceCacheMixinSet {
+	res := rs.Super().Create(vals)
+	InvalidateReferenceCache(rs.Env(), rs.ModelName())
+	return res
+}
+
+func referenceCacheMixin_Write(rs m.ReferenceCacheMixinSet, vals m.ReferenceCacheMixinData) bool {
+	res := rs.Super().Write(vals)
+	InvalidateReferenceCache(rs.Env(), rs.ModelName())
+	return res
+}
+
+func referenceCacheMixin_Unlink(rs m.ReferenceCacheMixinSet) int64 {
+	res := rs.Super().Unlink()
+	InvalidateReferenceCache(rs.Env(), rs.ModelName())
+	return res
+}
+
+func init() {
+	models.NewMixinModel("ReferenceCacheMixin")
+	h.ReferenceCacheMixin().Methods().Create().Extend(referenceCacheMixin_Create)
+	h.ReferenceCacheMixin().Methods().Write().Extend(referenceCacheMixin_Write)
+	h.ReferenceCacheMixin().Methods().Unlink().Extend(referenceCacheMixin_Unlink)
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReferenceCache(t *testing.T) {
+	Convey("Testing the reference cache", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Values are loaded once", func() {
+				var calls int
+				load := func() interface{} {
+					calls++
+					return "value"
+				}
+				InvalidateReferenceCache(env, "TestModel")
+				// This transaction modified TestModel, so the cache is bypassed
+				So(CachedReference(env, "TestModel", "key", load), ShouldEqual, "value")
+				So(CachedReference(env, "TestModel", "key", load), ShouldEqual, "value")
+				So(calls, ShouldEqual, 2)
+				So(models.SimulateInNewEnvironment(security.SuperUserID, func(env2 models.Environment) {
+					So(CachedReference(env2, "TestModel", "key", load), ShouldEqual, "value")
+					So(CachedReference(env2, "TestModel", "key", load), ShouldEqual, "value")
+				}), ShouldBeNil)
+				So(calls, ShouldEqual, 3)
+			})
+			Convey("Cached values are invalidated on write", func() {
+				belgium := h.Country().Search(env, q.Country().Code().Equals("BE"))
+				So(cachedCountry(belgium).Code, ShouldEqual, "BE")
+				belgium.SetAddressFormat("{{ .City }}")
+				So(cachedCountry(belgium).AddressFormat, ShouldEqual, "{{ .City }}")
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Cache Partner").
+					SetCity("Brussels").
+					SetCountry(belgium))
+				So(partner.DisplayAddress(true), ShouldEqual, "Brussels")
+			})
+			Convey("Languages are found by code", func() {
+				So(h.Lang().NewSet(env).GetLang("en_US").Code(), ShouldEqual, "en_US")
+				So(h.Lang().NewSet(env).GetLang("xx_XX").IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
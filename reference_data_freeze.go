@@ -0,0 +1,72 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strconv"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// ReferenceDataFreezeParam is the key of the ConfigParameter that freezes
+// reference data, i.e. the models inheriting ReferenceDataMixin.
+const ReferenceDataFreezeParam = "base.reference_data_freeze"
+
+// ReferenceDataFrozen returns true if reference data is frozen
+func ReferenceDataFrozen(env models.Environment) bool {
+	frozen, _ := strconv.ParseBool(h.ConfigParameter().NewSet(env).AsSuperUser("read reference data freeze").GetParam(ReferenceDataFreezeParam, "false"))
+	return frozen
+}
+
+// CheckReferenceDataFreeze panics if reference data is frozen and the current
+// user is not allowed to modify it. Reference data can always be modified by
+// the superuser, members of GroupReferenceDataManager and while installing
+// modules and loading data.
+func referenceDataMixin_CheckReferenceDataFreeze(rs m.ReferenceDataMixinSet) {
+	if rs.Env().Uid() == security.SuperUserID || ContextGetBool(rs.Env(), ContextKeyInstallMode) {
+		return
+	}
+	if !ReferenceDataFrozen(rs.Env()) {
+		return
+	}
+	if h.User().NewSet(rs.Env()).CurrentUser().HasGroup(GroupReferenceDataManager.ID()) {
+		return
+	}
+	log.Panic(rs.T("Reference data is frozen: %s records cannot be modified. Please contact a reference data manager.",
+		rs.ModelName()))
+}
+
+func referenceDataMixin_Write(rs m.ReferenceDataMixinSet, vals m.ReferenceDataMixinData) bool {
+	rs.CheckReferenceDataFreeze()
+	return rs.Super().Write(vals)
+}
+
+func referenceDataMixin_Unlink(rs m.ReferenceDataMixinSet) int64 {
+	rs.CheckReferenceDataFreeze()
+	return rs.Super().Unlink()
+}
+
+func configSettings_ConfigFieldsReferenceData(rs m.ConfigSettingsSet) basetypes.ConfigFieldsMap {
+	res := rs.Super().ConfigFields()
+	res[h.ConfigSettings().Fields().ReferenceDataFreeze()] = ReferenceDataFreezeParam
+	return res
+}
+
+func init() {
+	models.NewMixinModel("ReferenceDataMixin")
+	h.ReferenceDataMixin().NewMethod("CheckReferenceDataFreeze", referenceDataMixin_CheckReferenceDataFreeze)
+	h.ReferenceDataMixin().Methods().Write().Extend(referenceDataMixin_Write)
+	h.ReferenceDataMixin().Methods().Unlink().Extend(referenceDataMixin_Unlink)
+
+	h.ConfigSettings().AddFields(map[string]models.FieldDefinition{
+		"ReferenceDataFreeze": fields.Boolean{String: "Freeze Reference Data",
+			Help: "Countries, currencies, languages and sequences can only be modified by reference data managers"},
+	})
+	h.ConfigSettings().Methods().ConfigFields().Extend(configSettings_ConfigFieldsReferenceData)
+}
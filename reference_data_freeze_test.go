@@ -0,0 +1,44 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReferenceDataFreeze(t *testing.T) {
+	Convey("Testing reference data freeze", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			systemGroup := h.Group().Search(env, q.Group().GroupID().Equals(GroupSystem.ID()))
+			managerGroup := h.Group().Search(env, q.Group().GroupID().Equals(GroupReferenceDataManager.ID()))
+			admin := h.User().Create(env, h.User().NewData().
+				SetName("Settings Admin").
+				SetLogin("freeze_admin").
+				SetGroups(systemGroup))
+			manager := h.User().Create(env, h.User().NewData().
+				SetName("Reference Data Manager").
+				SetLogin("freeze_manager").
+				SetGroups(systemGroup.Union(managerGroup)))
+			currency := h.Currency().Search(env, q.Currency().Name().Equals("EUR"))
+			Convey("Reference data can be modified when not frozen", func() {
+				So(ReferenceDataFrozen(env), ShouldBeFalse)
+				So(func() { currency.Sudo(admin.ID()).SetSymbol("€") }, ShouldNotPanic)
+			})
+			Convey("Frozen reference data can only be modified by managers and the superuser", func() {
+				h.ConfigParameter().NewSet(env).SetParam(ReferenceDataFreezeParam, "true")
+				So(ReferenceDataFrozen(env), ShouldBeTrue)
+				So(func() { currency.Sudo(admin.ID()).SetSymbol("€") }, ShouldPanic)
+				So(func() { currency.Sudo(admin.ID()).Unlink() }, ShouldPanic)
+				So(func() { currency.Sudo(manager.ID()).SetSymbol("€") }, ShouldNotPanic)
+				So(func() { currency.SetSymbol("€") }, ShouldNotPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
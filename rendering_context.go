@@ -0,0 +1,45 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// PartnerRenderingContext returns a copy of the given context with the
+// language and timezone of the given partner, so that mail templates and
+// reports rendered with it are localized for this partner. The language or
+// timezone of ctx is kept if the partner has none.
+func PartnerRenderingContext(ctx *types.Context, partner m.PartnerSet) *types.Context {
+	if partner.IsEmpty() {
+		return ctx
+	}
+	partner.EnsureOne()
+	res := ctx
+	if lang := partner.Lang(); lang != "" {
+		res = res.WithKey(ContextKeyLang, lang)
+	}
+	if tz := partner.TZ(); tz != "" {
+		res = res.WithKey(ContextKeyTZ, tz)
+	}
+	return res
+}
+
+// ForPartner returns a copy of this recordset whose environment uses the
+// language and timezone of the given partner. It should be used to render
+// mail templates and reports sent to this partner, e.g.
+//
+//	template.ForPartner(user.Partner()).SendMail(data)
+//
+// Translated fields are then read in the partner's language, and dates
+// formatted with FormatInUserTZ are given in the partner's timezone.
+func baseMixin_ForPartner(rs m.BaseMixinSet, partner m.PartnerSet) m.BaseMixinSet {
+	return rs.WithNewContext(PartnerRenderingContext(rs.Env().Context(), partner))
+}
+
+func init() {
+	h.BaseMixin().NewMethod("ForPartner", baseMixin_ForPartner)
+}
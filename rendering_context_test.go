@@ -0,0 +1,41 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRenderingContext(t *testing.T) {
+	Convey("Testing the partner rendering context", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Tokyo Partner").
+				SetLang("en_US").
+				SetTZ("Asia/Tokyo"))
+			Convey("The context gets the language and timezone of the partner", func() {
+				ctx := PartnerRenderingContext(env.Context().WithKey(ContextKeyTZ, "Europe/Paris"), partner)
+				So(ctx.GetString(ContextKeyLang), ShouldEqual, "en_US")
+				So(ctx.GetString(ContextKeyTZ), ShouldEqual, "Asia/Tokyo")
+			})
+			Convey("Values of the context are kept if the partner has none", func() {
+				partner.SetTZ("")
+				ctx := PartnerRenderingContext(env.Context().WithKey(ContextKeyTZ, "Europe/Paris"), partner)
+				So(ctx.GetString(ContextKeyTZ), ShouldEqual, "Europe/Paris")
+				So(PartnerRenderingContext(env.Context(), h.Partner().NewSet(env)), ShouldEqual, env.Context())
+			})
+			Convey("Dates are formatted in the timezone of the partner", func() {
+				dt := dates.ParseDateTime("2020-01-31 23:30:00")
+				localized := h.Company().NewSet(env).CompanyDefaultGet().ForPartner(partner)
+				So(localized.FormatInUserTZ(dt), ShouldEqual, partner.FormatInPartnerTZ(partner, dt))
+			})
+		}), ShouldBeNil)
+	})
+}
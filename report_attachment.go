@@ -0,0 +1,144 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// Policies to store the PDF documents generated by a report as attachments
+// of the record they are printed for.
+const (
+	// ReportAttachmentNever does not store the generated documents
+	ReportAttachmentNever = "never"
+	// ReportAttachmentAlways stores each generated document
+	ReportAttachmentAlways = "always"
+	// ReportAttachmentFirst stores the first generated document and
+	// serves it again instead of generating the document next times.
+	ReportAttachmentFirst = "first"
+)
+
+// ReportAttachmentPolicies is the selection of report attachment policies
+var ReportAttachmentPolicies = types.Selection{
+	ReportAttachmentNever:  "Never",
+	ReportAttachmentAlways: "Each time it is printed",
+	ReportAttachmentFirst:  "First time only (reprint the stored document)",
+}
+
+var fields_ReportAttachmentSetting = map[string]models.FieldDefinition{
+	"Report": fields.Char{Required: true, Unique: true,
+		Help: "External ID of the report action these settings apply to"},
+	"Model": fields.Char{Required: true, Constraint: h.ReportAttachmentSetting().Methods().CheckModel(),
+		Help: "Model of the records the report is printed for"},
+	"Policy": fields.Selection{Selection: ReportAttachmentPolicies, Required: true,
+		Default: models.DefaultValue(ReportAttachmentNever)},
+	"NameTemplate": fields.Char{String: "Attachment Name", Required: true,
+		Default:    models.DefaultValue("{{ .DisplayName }}.pdf"),
+		Constraint: h.ReportAttachmentSetting().Methods().CheckNameTemplate(),
+		Help:       "Template of the attachment name, rendered with the fields of the printed record"},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// CheckModel checks that the model of the settings exists
+func reportAttachmentSetting_CheckModel(rs m.ReportAttachmentSettingSet) {
+	for _, setting := range rs.Records() {
+		if _, exists := models.Registry.Get(setting.Model()); !exists {
+			log.Panic(rs.T("Unknown model '%s'", setting.Model()))
+		}
+	}
+}
+
+// CheckNameTemplate checks that the attachment name template can be parsed
+func reportAttachmentSetting_CheckNameTemplate(rs m.ReportAttachmentSettingSet) {
+	for _, setting := range rs.Records() {
+		if _, err := DefaultTemplateSandbox.Parse(setting.NameTemplate()); err != nil {
+			log.Panic(rs.T("Invalid attachment name template for report '%s': %s", setting.Report(), err))
+		}
+	}
+}
+
+// ReportAttachmentSettingFor returns the active attachment settings of the
+// given report, or an empty set if the report has none.
+func ReportAttachmentSettingFor(env models.Environment, report string) m.ReportAttachmentSettingSet {
+	return h.ReportAttachmentSetting().NewSet(env).AsSuperUser("read report attachment settings").Search(
+		q.ReportAttachmentSetting().Report().Equals(report).And().Active().Equals(true)).Limit(1)
+}
+
+// AttachmentName returns the name of the attachment storing the document
+// printed for the given record. If the name template cannot be rendered,
+// the report and record names are used instead.
+func reportAttachmentSetting_AttachmentName(rs m.ReportAttachmentSettingSet, resID int64) string {
+	rs.EnsureOne()
+	record := ReferencedRecord(rs.Env(), rs.Model(), resID)
+	if record == nil || record.IsEmpty() {
+		log.Panic(rs.T("Record %s(%d) does not exist", rs.Model(), resID))
+	}
+	name, err := DefaultTemplateSandbox.Execute(rs.NameTemplate(), mailTemplateRecordData(record))
+	name = strings.Join(strings.Fields(name), " ")
+	if err != nil || name == "" {
+		log.Warn("Unable to render report attachment name", "report", rs.Report(), "resID", resID, "error", err)
+		name = fmt.Sprintf("%s - %s", rs.Report(), record.Call("NameGet").(string))
+	}
+	if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+		name += ".pdf"
+	}
+	return name
+}
+
+// StoredDocument returns the attachment to serve instead of printing the
+// document again for the given record, that is the previously stored
+// document if the policy of these settings is ReportAttachmentFirst.
+// It returns an empty set if the document must be generated.
+func reportAttachmentSetting_StoredDocument(rs m.ReportAttachmentSettingSet, resID int64) m.AttachmentSet {
+	if rs.IsEmpty() || rs.Policy() != ReportAttachmentFirst {
+		return h.Attachment().NewSet(rs.Env())
+	}
+	return h.Attachment().NewSet(rs.Env()).AsSuperUser("read report attachment").Search(
+		q.Attachment().ResModel().Equals(rs.Model()).
+			And().ResID().Equals(resID).
+			And().Name().Equals(rs.AttachmentName(resID))).
+		OrderBy("ID desc").Limit(1)
+}
+
+// StoreDocument stores the given PDF document printed for the given record as
+// an attachment of this record according to the policy of these settings.
+// It returns the created attachment, or an empty set if nothing is stored.
+//
+// Report renderers should call StoredDocument before generating a document
+// and this method after, so that legal documents are retained verbatim.
+func reportAttachmentSetting_StoreDocument(rs m.ReportAttachmentSettingSet, resID int64, pdf []byte) m.AttachmentSet {
+	if rs.IsEmpty() || rs.Policy() == ReportAttachmentNever {
+		return h.Attachment().NewSet(rs.Env())
+	}
+	if existing := rs.StoredDocument(resID); existing.IsNotEmpty() {
+		return existing
+	}
+	return h.Attachment().NewSet(rs.Env()).AsSuperUser("store report attachment").Create(h.Attachment().NewData().
+		SetName(rs.AttachmentName(resID)).
+		SetResModel(rs.Model()).
+		SetResID(resID).
+		SetType("binary").
+		SetDatas(base64.StdEncoding.EncodeToString(pdf)))
+}
+
+func init() {
+	models.NewModel("ReportAttachmentSetting")
+	h.ReportAttachmentSetting().SetDefaultOrder("Report")
+	h.ReportAttachmentSetting().AddFields(fields_ReportAttachmentSetting)
+
+	h.ReportAttachmentSetting().NewMethod("CheckModel", reportAttachmentSetting_CheckModel)
+	h.ReportAttachmentSetting().NewMethod("CheckNameTemplate", reportAttachmentSetting_CheckNameTemplate)
+	h.ReportAttachmentSetting().NewMethod("AttachmentName", reportAttachmentSetting_AttachmentName)
+	h.ReportAttachmentSetting().NewMethod("StoredDocument", reportAttachmentSetting_StoredDocument)
+	h.ReportAttachmentSetting().NewMethod("StoreDocument", reportAttachmentSetting_StoreDocument)
+}
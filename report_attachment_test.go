@@ -0,0 +1,58 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReportAttachment(t *testing.T) {
+	Convey("Testing report attachment archiving", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("Archived Partner"))
+			setting := h.ReportAttachmentSetting().Create(env, h.ReportAttachmentSetting().NewData().
+				SetReport("base_report_partner_statement").
+				SetModel("Partner").
+				SetNameTemplate("Statement {{ .Name }}"))
+			partnerAttachments := func() int {
+				return h.Attachment().Search(env, q.Attachment().ResModel().Equals("Partner").
+					And().ResID().Equals(partner.ID())).Len()
+			}
+			Convey("Settings are checked", func() {
+				So(func() { setting.SetModel("UnknownModel") }, ShouldPanic)
+				So(func() { setting.SetNameTemplate("{{ call .Name }}") }, ShouldPanic)
+			})
+			Convey("Attachment names are rendered from the record", func() {
+				So(setting.AttachmentName(partner.ID()), ShouldEqual, "Statement Archived Partner.pdf")
+				So(ReportAttachmentSettingFor(env, "base_report_partner_statement").Equals(setting), ShouldBeTrue)
+				So(ReportAttachmentSettingFor(env, "unknown_report").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Documents are not stored with the never policy", func() {
+				So(setting.StoreDocument(partner.ID(), []byte("%PDF-1.4")).IsEmpty(), ShouldBeTrue)
+				So(partnerAttachments(), ShouldEqual, 0)
+			})
+			Convey("Documents are stored each time with the always policy", func() {
+				setting.SetPolicy(ReportAttachmentAlways)
+				So(setting.StoreDocument(partner.ID(), []byte("%PDF-1.4")).IsNotEmpty(), ShouldBeTrue)
+				So(setting.StoredDocument(partner.ID()).IsEmpty(), ShouldBeTrue)
+				setting.StoreDocument(partner.ID(), []byte("%PDF-1.4"))
+				So(partnerAttachments(), ShouldEqual, 2)
+			})
+			Convey("The first document is kept with the first time policy", func() {
+				setting.SetPolicy(ReportAttachmentFirst)
+				So(setting.StoredDocument(partner.ID()).IsEmpty(), ShouldBeTrue)
+				first := setting.StoreDocument(partner.ID(), []byte("%PDF-1.4 first"))
+				So(setting.StoredDocument(partner.ID()).Equals(first), ShouldBeTrue)
+				So(setting.StoreDocument(partner.ID(), []byte("%PDF-1.4 second")).Equals(first), ShouldBeTrue)
+				So(partnerAttachments(), ShouldEqual, 1)
+			})
+		}), ShouldBeNil)
+	})
+}
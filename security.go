@@ -27,6 +27,8 @@ var (
 	GroupERPManager *security.Group
 	// GroupTechnicalFeatures can see and modify technical parameters of the ERP
 	GroupTechnicalFeatures *security.Group
+	// GroupReferenceDataManager can modify reference data while it is frozen
+	GroupReferenceDataManager *security.Group
 )
 
 func init() {
@@ -39,12 +41,16 @@ func init() {
 	GroupPartnerManager = security.Registry.NewGroup("base_group_partner_manager", "Contact Creation")
 	GroupPortal = security.Registry.NewGroup("base_group_portal", "Portal")
 	GroupPublic = security.Registry.NewGroup("base_group_public", "Public")
+	GroupReferenceDataManager = security.Registry.NewGroup("base_group_reference_data_manager", "Reference Data Manager")
 
 	h.Attachment().Methods().Load().AllowGroup(security.GroupEveryone)
 	h.Attachment().Methods().AllowAllToGroup(GroupUser)
 
 	h.User().Methods().Load().AllowGroup(security.GroupEveryone)
 	h.User().Methods().HasGroup().AllowGroup(security.GroupEveryone)
+	h.User().Methods().ResetCalendarToken().AllowGroup(security.GroupEveryone)
+	h.User().Methods().CalendarFeed().AllowGroup(security.GroupEveryone)
+	h.User().Methods().CalendarFeedEvents().AllowGroup(security.GroupEveryone)
 	h.User().Methods().AllowAllToGroup(GroupERPManager)
 
 	h.UserLog().Methods().Load().AllowGroup(security.GroupEveryone)
@@ -56,6 +62,9 @@ func init() {
 	h.Currency().Methods().Load().AllowGroup(security.GroupEveryone)
 	h.Currency().Methods().AllowAllToGroup(GroupSystem)
 
+	h.CountryStateLoader().Methods().AllowAllToGroup(GroupSystem)
+	h.CurrencyRateImportWizard().Methods().AllowAllToGroup(GroupSystem)
+
 	h.Partner().Methods().Load().AllowGroup(GroupPublic)
 	h.Partner().Methods().Load().AllowGroup(GroupPortal)
 	h.Partner().Methods().Load().AllowGroup(GroupUser)
@@ -66,6 +75,8 @@ func init() {
 
 	h.PartnerCategory().Methods().Load().AllowGroup(GroupUser)
 	h.PartnerCategory().Methods().AllowAllToGroup(GroupPartnerManager)
+	h.PartnerCategoryCleanupWizard().Methods().AllowAllToGroup(GroupPartnerManager)
+	h.ChangeParentWizard().Methods().AllowAllToGroup(GroupPartnerManager)
 
 	h.Bank().Methods().Load().AllowGroup(GroupUser)
 	h.Bank().Methods().AllowAllToGroup(GroupPartnerManager)
@@ -76,9 +87,145 @@ func init() {
 
 	h.Company().Methods().Load().AllowGroup(security.GroupEveryone)
 	h.Company().Methods().AllowAllToGroup(GroupERPManager)
+	h.CompanyOffboardingWizard().Methods().AllowAllToGroup(GroupERPManager)
 
 	h.Sequence().Methods().Load().AllowGroup(GroupUser)
 	h.Sequence().Methods().AllowAllToGroup(GroupSystem)
 	h.SequenceDateRange().Methods().Load().AllowGroup(GroupUser)
 	h.SequenceDateRange().Methods().AllowAllToGroup(GroupSystem)
+	h.SequencePreview().Methods().AllowAllToGroup(GroupSystem)
+
+	h.DateRangeType().Methods().Load().AllowGroup(GroupUser)
+	h.DateRangeType().Methods().AllowAllToGroup(GroupSystem)
+	h.DateRange().Methods().Load().AllowGroup(GroupUser)
+	h.DateRange().Methods().AllowAllToGroup(GroupSystem)
+	h.DateRangeGenerator().Methods().AllowAllToGroup(GroupSystem)
+
+	h.RecurrenceRule().Methods().AllowAllToGroup(GroupUser)
+
+	h.Model().Methods().Load().AllowGroup(GroupUser)
+	h.Model().Methods().AllowAllToGroup(GroupSystem)
+	h.ModelField().Methods().Load().AllowGroup(GroupUser)
+	h.ModelField().Methods().AllowAllToGroup(GroupSystem)
+
+	h.CalendarEvent().Methods().AllowAllToGroup(GroupUser)
+
+	h.Alias().Methods().Load().AllowGroup(GroupUser)
+	h.Alias().Methods().AllowAllToGroup(GroupSystem)
+
+	h.CustomField().Methods().Load().AllowGroup(security.GroupEveryone)
+	h.CustomField().Methods().AllowAllToGroup(GroupSystem)
+
+	h.MailTemplate().Methods().Load().AllowGroup(GroupUser)
+	h.MailTemplate().Methods().AllowAllToGroup(GroupSystem)
+	h.MailTemplatePreview().Methods().AllowAllToGroup(GroupSystem)
+	h.MailServer().Methods().AllowAllToGroup(GroupSystem)
+	h.MailMessage().Methods().AllowAllToGroup(GroupSystem)
+	h.DKIMKey().Methods().AllowAllToGroup(GroupSystem)
+
+	h.ReportAttachmentSetting().Methods().Load().AllowGroup(GroupUser)
+	h.ReportAttachmentSetting().Methods().AttachmentName().AllowGroup(GroupUser)
+	h.ReportAttachmentSetting().Methods().StoredDocument().AllowGroup(GroupUser)
+	h.ReportAttachmentSetting().Methods().StoreDocument().AllowGroup(GroupUser)
+	h.ReportAttachmentSetting().Methods().AllowAllToGroup(GroupSystem)
+
+	h.Digest().Methods().Load().AllowGroup(GroupUser)
+	h.Digest().Methods().Subscribe().AllowGroup(GroupUser)
+	h.Digest().Methods().Unsubscribe().AllowGroup(GroupUser)
+	h.Digest().Methods().AllowAllToGroup(GroupSystem)
+
+	h.SystemCheck().Methods().AllowAllToGroup(GroupSystem)
+
+	h.Logging().Methods().Load().AllowGroup(GroupSystem)
+	h.Logging().Methods().ActionOpenRecord().AllowGroup(GroupSystem)
+	h.Logging().Methods().Unlink().AllowGroup(GroupSystem)
+
+	h.PartnerCode().Methods().Load().AllowGroup(GroupUser)
+	h.PartnerCode().Methods().AllowAllToGroup(GroupPartnerManager)
+
+	h.Tag().Methods().Load().AllowGroup(GroupUser)
+	h.Tag().Methods().Create().AllowGroup(GroupUser)
+	h.Tag().Methods().AllowAllToGroup(GroupSystem)
+	h.TagLink().Methods().AllowAllToGroup(GroupUser)
+
+	h.Activity().Methods().AllowAllToGroup(GroupUser)
+
+	h.SnailmailLetter().Methods().AllowAllToGroup(GroupUser)
+
+	h.SMSMessage().Methods().AllowAllToGroup(GroupUser)
+
+	h.IMMessage().Methods().AllowAllToGroup(GroupUser)
+
+	h.SignatureRequest().Methods().Load().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().Create().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().Write().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().Unlink().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().ActionSend().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().ActionCancel().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().VerifyIntegrity().AllowGroup(GroupUser)
+	h.SignatureRequest().Methods().AllowAllToGroup(GroupSystem)
+	h.SignatureRequestSigner().Methods().Load().AllowGroup(GroupUser)
+	h.SignatureRequestSigner().Methods().Create().AllowGroup(GroupUser)
+	h.SignatureRequestSigner().Methods().Write().AllowGroup(GroupUser)
+	h.SignatureRequestSigner().Methods().Unlink().AllowGroup(GroupUser)
+	h.SignatureRequestSigner().Methods().AllowAllToGroup(GroupSystem)
+	h.SignatureAuditEntry().Methods().Load().AllowGroup(GroupUser)
+
+	h.ShareLink().Methods().Load().AllowGroup(GroupUser)
+	h.ShareLink().Methods().Share().AllowGroup(GroupUser)
+	h.ShareLink().Methods().ActionRevoke().AllowGroup(GroupUser)
+	h.ShareLink().Methods().AllowAllToGroup(GroupSystem)
+	h.ShareLinkAccess().Methods().Load().AllowGroup(GroupUser)
+
+	h.Delegation().Methods().AllowAllToGroup(GroupUser)
+
+	h.ApprovalRule().Methods().Load().AllowGroup(GroupUser)
+	h.ApprovalRule().Methods().RequestApproval().AllowGroup(GroupUser)
+	h.ApprovalRule().Methods().AllowAllToGroup(GroupSystem)
+	h.ApprovalRuleLine().Methods().Load().AllowGroup(GroupUser)
+	h.ApprovalRuleLine().Methods().AllowAllToGroup(GroupSystem)
+	h.ApprovalRequest().Methods().Load().AllowGroup(GroupUser)
+	h.ApprovalRequest().Methods().Approve().AllowGroup(GroupUser)
+	h.ApprovalRequest().Methods().Refuse().AllowGroup(GroupUser)
+	h.ApprovalRequest().Methods().AllowAllToGroup(GroupSystem)
+	h.ApprovalRequestLine().Methods().Load().AllowGroup(GroupUser)
+	h.ApprovalRequestLine().Methods().AllowAllToGroup(GroupSystem)
+
+	h.GroupMembership().Methods().AllowAllToGroup(GroupERPManager)
+
+	h.MaskDatabase().Methods().AllowAllToGroup(GroupSystem)
+
+	h.DatabaseBackup().Methods().AllowAllToGroup(GroupSystem)
+
+	h.DataSyncProfile().Methods().AllowAllToGroup(GroupSystem)
+	h.DataSyncWizard().Methods().AllowAllToGroup(GroupSystem)
+
+	h.Partner().Methods().ProposeChanges().AllowGroup(GroupUser)
+	h.Partner().Methods().ProposeChanges().AllowGroup(GroupPortal)
+	h.Partner().Methods().ProposeSignatureChanges().AllowGroup(GroupUser)
+	h.PartnerChangeRequest().Methods().Load().AllowGroup(GroupUser)
+	h.PartnerChangeRequest().Methods().AllowAllToGroup(GroupPartnerManager)
+	h.PartnerChangeRequestLine().Methods().Load().AllowGroup(GroupUser)
+	h.PartnerChangeRequestLine().Methods().AllowAllToGroup(GroupPartnerManager)
+
+	h.ContactRequest().Methods().Load().AllowGroup(GroupUser)
+	h.ContactRequest().Methods().AllowAllToGroup(GroupPartnerManager)
+
+	h.ValidationRule().Methods().Load().AllowGroup(GroupUser)
+	h.ValidationRule().Methods().AllowAllToGroup(GroupSystem)
+
+	h.PartnerCompletenessWeight().Methods().Load().AllowGroup(GroupUser)
+	h.PartnerCompletenessWeight().Methods().AllowAllToGroup(GroupPartnerManager)
+
+	h.Team().Methods().Load().AllowGroup(GroupUser)
+	h.Team().Methods().AllowAllToGroup(GroupPartnerManager)
+	h.TeamAssignmentRule().Methods().Load().AllowGroup(GroupUser)
+	h.TeamAssignmentRule().Methods().AllowAllToGroup(GroupPartnerManager)
+
+	h.Announcement().Methods().Load().AllowGroup(GroupUser)
+	h.Announcement().Methods().ForUser().AllowGroup(GroupUser)
+	h.Announcement().Methods().Dismiss().AllowGroup(GroupUser)
+	h.Announcement().Methods().AllowAllToGroup(GroupSystem)
+
+	h.MethodStats().Methods().AllowAllToGroup(GroupSystem)
 }
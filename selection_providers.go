@@ -0,0 +1,178 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models/types"
+)
+
+// A SelectionProvider returns options to add to a dynamic selection field.
+// Providers are called when the options of the field are not in cache.
+type SelectionProvider func() types.Selection
+
+// A SelectionInvalidationHook is called with the model and field names of a
+// dynamic selection field each time its cached options are invalidated.
+type SelectionInvalidationHook func(model, field string)
+
+// dynamicSelection holds the base options and the providers of a dynamic selection field
+type dynamicSelection struct {
+	base          types.Selection
+	providerNames []string
+	providers     map[string]SelectionProvider
+	cache         types.Selection
+	version       int
+}
+
+var dynamicSelections = struct {
+	sync.RWMutex
+	fields map[string]*dynamicSelection
+	hooks  []SelectionInvalidationHook
+}{
+	fields: make(map[string]*dynamicSelection),
+}
+
+// selectionKey returns the registry key of the given field
+func selectionKey(model, field string) string {
+	return fmt.Sprintf("%s.%s", model, field)
+}
+
+// DynamicSelection declares the given field as a dynamic selection field with
+// the given base options, and returns the function to use as SelectionFunc in
+// its definition. Options can then be added at runtime with
+// RegisterSelectionProvider. It panics if the field is already declared.
+func DynamicSelection(model, field string, base types.Selection) func() types.Selection {
+	dynamicSelections.Lock()
+	defer dynamicSelections.Unlock()
+	key := selectionKey(model, field)
+	if _, exists := dynamicSelections.fields[key]; exists {
+		log.Panic("Dynamic selection field already declared", "model", model, "field", field)
+	}
+	dynamicSelections.fields[key] = &dynamicSelection{
+		base:      base,
+		providers: make(map[string]SelectionProvider),
+	}
+	return func() types.Selection {
+		return SelectionOptions(model, field)
+	}
+}
+
+// RegisterSelectionProvider adds the options returned by the given provider to
+// the given dynamic selection field, e.g. to add a value to Partner.Type.
+// Providers are called in the order of their registration and may override
+// the labels of the base options. It panics if the field is not a dynamic
+// selection field or if a provider with the same name is already registered.
+func RegisterSelectionProvider(model, field, name string, provider SelectionProvider) {
+	dynamicSelections.Lock()
+	sel, exists := dynamicSelections.fields[selectionKey(model, field)]
+	if !exists {
+		dynamicSelections.Unlock()
+		log.Panic("Field is not a dynamic selection field", "model", model, "field", field)
+	}
+	if _, exists := sel.providers[name]; exists {
+		dynamicSelections.Unlock()
+		log.Panic("Selection provider already registered", "model", model, "field", field, "name", name)
+	}
+	sel.providerNames = append(sel.providerNames, name)
+	sel.providers[name] = provider
+	dynamicSelections.Unlock()
+	InvalidateSelectionOptions(model, field)
+}
+
+// SelectionOptions returns the options of the given dynamic selection field,
+// i.e. its base options and the options of its providers. The result is
+// cached until InvalidateSelectionOptions is called for this field.
+// It returns nil if the field is not a dynamic selection field.
+func SelectionOptions(model, field string) types.Selection {
+	key := selectionKey(model, field)
+	dynamicSelections.RLock()
+	sel, exists := dynamicSelections.fields[key]
+	if !exists {
+		dynamicSelections.RUnlock()
+		return nil
+	}
+	if sel.cache != nil {
+		defer dynamicSelections.RUnlock()
+		return copySelection(sel.cache)
+	}
+	base, version := sel.base, sel.version
+	providers := make([]SelectionProvider, len(sel.providerNames))
+	for i, name := range sel.providerNames {
+		providers[i] = sel.providers[name]
+	}
+	dynamicSelections.RUnlock()
+	// Providers are called without holding the lock, so that they can call
+	// SelectionOptions for other fields.
+	res := copySelection(base)
+	for _, provider := range providers {
+		for value, label := range provider() {
+			res[value] = label
+		}
+	}
+	dynamicSelections.Lock()
+	defer dynamicSelections.Unlock()
+	if sel.version == version {
+		sel.cache = res
+	}
+	return copySelection(res)
+}
+
+// InvalidateSelectionOptions clears the cached options of the given dynamic
+// selection field, so that its providers are called again on next access,
+// and calls the registered invalidation hooks.
+func InvalidateSelectionOptions(model, field string) {
+	dynamicSelections.Lock()
+	if sel, exists := dynamicSelections.fields[selectionKey(model, field)]; exists {
+		sel.cache = nil
+		sel.version++
+	}
+	hooks := make([]SelectionInvalidationHook, len(dynamicSelections.hooks))
+	copy(hooks, dynamicSelections.hooks)
+	dynamicSelections.Unlock()
+	for _, hook := range hooks {
+		hook(model, field)
+	}
+}
+
+// AddSelectionInvalidationHook registers a function to be called each time the
+// cached options of a dynamic selection field are invalidated, for instance
+// to notify connected clients.
+func AddSelectionInvalidationHook(hook SelectionInvalidationHook) {
+	dynamicSelections.Lock()
+	defer dynamicSelections.Unlock()
+	dynamicSelections.hooks = append(dynamicSelections.hooks, hook)
+}
+
+// copySelection returns a copy of the given selection
+func copySelection(sel types.Selection) types.Selection {
+	res := make(types.Selection, len(sel))
+	for value, label := range sel {
+		res[value] = label
+	}
+	return res
+}
+
+// A SelectionOption is an option of a selection field, as served to clients
+type SelectionOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// sortedSelectionOptions returns the options of the given selection sorted by label
+func sortedSelectionOptions(sel types.Selection) []SelectionOption {
+	res := make([]SelectionOption, 0, len(sel))
+	for value, label := range sel {
+		res = append(res, SelectionOption{Value: value, Label: label})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Label == res[j].Label {
+			return res[i].Value < res[j].Value
+		}
+		return res[i].Label < res[j].Label
+	})
+	return res
+}
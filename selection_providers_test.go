@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testKindSelection = DynamicSelection("TestModel", "Kind", types.Selection{"a": "Alpha"})
+
+func TestDynamicSelection(t *testing.T) {
+	Convey("Testing dynamic selection fields", t, func() {
+		selFunc := testKindSelection
+		Convey("Base options are returned when no provider is registered", func() {
+			So(selFunc(), ShouldResemble, types.Selection{"a": "Alpha"})
+			So(SelectionOptions("TestModel", "Unknown"), ShouldBeNil)
+		})
+		Convey("Provider options are cached until invalidated", func() {
+			var calls int
+			label := "Beta"
+			var invalidated []string
+			AddSelectionInvalidationHook(func(model, field string) {
+				invalidated = append(invalidated, model+"."+field)
+			})
+			RegisterSelectionProvider("TestModel", "Kind", "test", func() types.Selection {
+				calls++
+				return types.Selection{"b": label}
+			})
+			So(invalidated, ShouldContain, "TestModel.Kind")
+			So(selFunc(), ShouldResemble, types.Selection{"a": "Alpha", "b": "Beta"})
+			label = "Bravo"
+			So(selFunc()["b"], ShouldEqual, "Beta")
+			So(calls, ShouldEqual, 1)
+			InvalidateSelectionOptions("TestModel", "Kind")
+			So(selFunc()["b"], ShouldEqual, "Bravo")
+			So(calls, ShouldEqual, 2)
+			So(func() {
+				RegisterSelectionProvider("TestModel", "Kind", "test", func() types.Selection { return nil })
+			}, ShouldPanic)
+		})
+		Convey("Providers cannot be registered on static fields", func() {
+			So(func() {
+				RegisterSelectionProvider("TestModel", "Static", "test", func() types.Selection { return nil })
+			}, ShouldPanic)
+			So(func() {
+				DynamicSelection("TestModel", "Kind", nil)
+			}, ShouldPanic)
+		})
+		Convey("Options are sorted by label for clients", func() {
+			So(sortedSelectionOptions(types.Selection{"z": "A", "a": "B"}), ShouldResemble, []SelectionOption{
+				{Value: "z", Label: "A"},
+				{Value: "a", Label: "B"},
+			})
+		})
+		Convey("Partner types can be extended", func() {
+			So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+				So(SelectionOptions("Partner", "Type"), ShouldContainKey, "invoice")
+				fi := h.Partner().NewSet(env).FieldGet(h.Partner().Fields().Type())
+				So(fi.Selection, ShouldContainKey, "delivery")
+			}), ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+var (
+	leiRegex  = regexp.MustCompile(`^[A-Z0-9]{18}[0-9]{2}$`)
+	ibanRegex = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+	bicRegex  = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+)
+
+// sepaAddressLineMaxLength is the maximum length of an address line in ISO 20022 messages
+const sepaAddressLineMaxLength = 70
+
+// ClearingSystems is the selection of the most common ISO 20022 external
+// clearing system identification codes.
+var ClearingSystems = types.Selection{
+	"ATBLZ": "Austrian Bankleitzahl",
+	"AUBSB": "Australian Bank State Branch Code (BSB)",
+	"CACPA": "Canadian Payments Association Payment Routing Number",
+	"CHBCC": "Swiss Financial Institution Identification (short)",
+	"CHSIC": "Swiss Financial Institution Identification (long)",
+	"CNAPS": "CNAPS Identifier",
+	"DEBLZ": "German Bankleitzahl",
+	"ESNCC": "Spanish Domestic Interbanking Code",
+	"GBDSC": "UK Domestic Sort Code",
+	"HKNCC": "Hong Kong Bank Code",
+	"IENCC": "Irish National Clearing Code",
+	"INFSC": "Indian Financial System Code",
+	"ITNCC": "Italian Domestic Identification Code",
+	"JPZGN": "Japan Zengin Clearing Code",
+	"NZNCC": "New Zealand National Clearing Code",
+	"PLKNR": "Polish National Clearing Code",
+	"PTNCC": "Portuguese National Clearing Code",
+	"RUCBC": "Russian Central Bank Identification Code",
+	"SESBA": "Sweden Bankgiro Clearing Code",
+	"SGIBG": "IBG Sort Code",
+	"THCBC": "Thai Central Bank Identification Code",
+	"TWNCC": "Financial Institution Code",
+	"USABA": "United States Routing Number (Fedwire, NACHA)",
+	"USPID": "CHIPS Participant Identifier",
+	"ZANCC": "South African National Clearing Code",
+}
+
+// iso7064Mod97 returns the ISO 7064 MOD 97-10 remainder of the given
+// alphanumeric string, letters being converted to numbers from A=10 to Z=35.
+func iso7064Mod97(value string) int64 {
+	var digits strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		default:
+			return -1
+		}
+	}
+	num, ok := new(big.Int).SetString(digits.String(), 10)
+	if !ok {
+		return -1
+	}
+	return new(big.Int).Mod(num, big.NewInt(97)).Int64()
+}
+
+// ValidateLEI returns true if the given string is a valid ISO 17442 Legal Entity Identifier
+func ValidateLEI(lei string) bool {
+	return leiRegex.MatchString(lei) && iso7064Mod97(lei) == 1
+}
+
+// ValidateIBAN returns true if the given sanitized account number is a valid IBAN
+func ValidateIBAN(iban string) bool {
+	if !ibanRegex.MatchString(iban) {
+		return false
+	}
+	return iso7064Mod97(iban[4:]+iban[:4]) == 1
+}
+
+// ValidateBIC returns true if the given string is a well formed ISO 9362 Business Identifier Code
+func ValidateBIC(bic string) bool {
+	return bicRegex.MatchString(bic)
+}
+
+// looksLikeIBAN returns true if the given sanitized account number starts like an IBAN
+func looksLikeIBAN(accNumber string) bool {
+	return len(accNumber) > 4 &&
+		accNumber[0] >= 'A' && accNumber[0] <= 'Z' && accNumber[1] >= 'A' && accNumber[1] <= 'Z' &&
+		accNumber[2] >= '0' && accNumber[2] <= '9' && accNumber[3] >= '0' && accNumber[3] <= '9'
+}
+
+// CheckLEI checks that the LEI of the partners is valid
+func partner_CheckLEI(rs m.PartnerSet) {
+	for _, partner := range rs.Records() {
+		if partner.LEI() != "" && !ValidateLEI(partner.LEI()) {
+			log.Panic(rs.T("The LEI '%s' of partner %s is invalid", partner.LEI(), partner.Name()))
+		}
+	}
+}
+
+// GetSEPAIdentity returns the ISO 20022 identification of this bank account,
+// its holder and its bank. It returns an error if this identification is
+// incomplete or invalid, so that payment addons can report it before
+// generating their files.
+func bankAccount_GetSEPAIdentity(rs m.BankAccountSet) (basetypes.SEPAIdentity, error) {
+	rs.EnsureOne()
+	holder := rs.Partner().CommercialPartner()
+	res := basetypes.SEPAIdentity{
+		Name:             rs.HolderName(),
+		LEI:              holder.LEI(),
+		BIC:              strings.ToUpper(strings.TrimSpace(rs.Bank().BIC())),
+		ClearingSystem:   rs.Bank().ClearingSystem(),
+		ClearingMemberID: strings.TrimSpace(rs.Bank().ClearingMemberID()),
+	}
+	if res.Name == "" {
+		res.Name = holder.Name()
+	}
+	if res.Name == "" {
+		return res, errors.New(rs.T("Bank account %s has no holder", rs.Name()))
+	}
+	country := rs.HolderCountry()
+	if country.IsEmpty() {
+		country = rs.Partner().Country()
+	}
+	res.Country = cachedCountry(country).Code
+	for _, line := range []string{
+		strings.TrimSpace(fmt.Sprintf("%s %s", rs.Partner().Street(), rs.Partner().Street2())),
+		strings.TrimSpace(fmt.Sprintf("%s %s", rs.Partner().Zip(), rs.Partner().City())),
+	} {
+		if line == "" {
+			continue
+		}
+		if len([]rune(line)) > sepaAddressLineMaxLength {
+			line = string([]rune(line)[:sepaAddressLineMaxLength])
+		}
+		res.AddressLines = append(res.AddressLines, line)
+	}
+	accNumber := rs.SanitizedAccountNumber()
+	if looksLikeIBAN(accNumber) {
+		if !ValidateIBAN(accNumber) {
+			return res, errors.New(rs.T("Bank account %s is not a valid IBAN", rs.Name()))
+		}
+		res.IBAN = accNumber
+	} else {
+		res.AccountNumber = accNumber
+	}
+	if res.BIC != "" && !ValidateBIC(res.BIC) {
+		return res, errors.New(rs.T("The BIC '%s' of bank %s is invalid", res.BIC, rs.Bank().Name()))
+	}
+	if res.ClearingSystem != "" && res.ClearingMemberID == "" {
+		return res, errors.New(rs.T("Bank %s has a clearing system but no clearing member ID", rs.Bank().Name()))
+	}
+	if res.IBAN == "" && res.BIC == "" && res.ClearingSystem == "" {
+		return res, errors.New(rs.T("Bank account %s has no IBAN and its bank has neither a BIC nor a clearing system", rs.Name()))
+	}
+	return res, nil
+}
+
+func init() {
+	h.Partner().NewMethod("CheckLEI", partner_CheckLEI)
+	h.BankAccount().NewMethod("GetSEPAIdentity", bankAccount_GetSEPAIdentity)
+}
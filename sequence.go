@@ -415,6 +415,7 @@ func sequenceDateRange_UpdateNoGap(rs m.SequenceDateRangeSet) int64 {
 func init() {
 	models.NewModel("Sequence")
 	h.Sequence().AddFields(fields_Sequence)
+	h.Sequence().InheritModel(h.ReferenceDataMixin())
 
 	h.Sequence().NewMethod("ComputeNumberNextActual", sequence_ComputeNumberNextActual)
 	h.Sequence().NewMethod("InverseNumberNextActual", sequence_InverseNumberNextActual)
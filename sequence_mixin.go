@@ -0,0 +1,80 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+var fields_SequenceMixin = map[string]models.FieldDefinition{
+	"Sequence": fields.Integer{Index: true, GoType: new(int),
+		Help: "Gives the order in which records are displayed. Records with a lower sequence come first."},
+}
+
+// NextSequence returns the sequence to give to a new record so that it is
+// placed at the end of the list.
+func sequenceMixin_NextSequence(rs m.SequenceMixinSet) int {
+	var maxSeq int
+	rs.Env().Cr().Get(&maxSeq, fmt.Sprintf(`SELECT COALESCE(MAX(sequence), 0) FROM %s`,
+		rs.Collection().Model().Table()))
+	return maxSeq + 1
+}
+
+// Resequence renumbers the records with the given ids so that their
+// sequences follow the order of ids (starting at 1). This is typically
+// called after a drag & drop in a list view.
+//
+// All records are updated with a single SQL statement. It panics if the
+// current user is not allowed to write on this model, and ids of records
+// that the user cannot see are ignored.
+func sequenceMixin_Resequence(rs m.SequenceMixinSet, ids []int64) {
+	if len(ids) == 0 {
+		return
+	}
+	model := rs.Collection().Model()
+	rs.CheckExecutionPermission(model.Methods().MustGet("Write").Underlying())
+	allowed := make(map[int64]bool)
+	for _, id := range rs.Collection().Search(model.Field(models.ID).In(ids)).Ids() {
+		allowed[id] = true
+	}
+	var (
+		cases      strings.Builder
+		allowedIds []int64
+	)
+	for i, id := range ids {
+		if !allowed[id] {
+			continue
+		}
+		fmt.Fprintf(&cases, " WHEN %d THEN %d", id, i+1)
+		allowedIds = append(allowedIds, id)
+	}
+	if len(allowedIds) == 0 {
+		return
+	}
+	rs.Env().Cr().Execute(fmt.Sprintf(`UPDATE %s SET sequence = CASE id%s END, write_date = ?, write_uid = ? WHERE id IN (?)`,
+		model.Table(), cases.String()), dates.Now(), rs.Env().Uid(), allowedIds)
+	rs.Collection().InvalidateCache()
+}
+
+func sequenceMixin_Create(rs m.SequenceMixinSet, vals m.SequenceMixinData) m.SequenceMixinSet {
+	if !vals.HasSequence() {
+		vals.SetSequence(rs.NextSequence())
+	}
+	return rs.Super().Create(vals)
+}
+
+func init() {
+	models.NewMixinModel("SequenceMixin")
+	h.SequenceMixin().AddFields(fields_SequenceMixin)
+	h.SequenceMixin().NewMethod("NextSequence", sequenceMixin_NextSequence)
+	h.SequenceMixin().NewMethod("Resequence", sequenceMixin_Resequence)
+	h.SequenceMixin().Methods().Create().Extend(sequenceMixin_Create)
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// A SequencePreviewNumber is a number that a sequence would give to a
+// document of the given date.
+type SequencePreviewNumber struct {
+	Date   dates.Date
+	Number string
+	// Rollover is true if the number is the first one of a new date range
+	Rollover bool
+}
+
+// sequencePreviewRange returns the bounds and the next number of the date
+// range of the given sequence that contains the given date. If this date
+// range does not exist yet, the values it would be created with by
+// CreateDateRangeSeq are returned.
+func sequencePreviewRange(rs m.SequenceSet, date dates.Date) (dates.Date, dates.Date, int64) {
+	dateRange := h.SequenceDateRange().Search(rs.Env(),
+		q.SequenceDateRange().Sequence().Equals(rs).
+			And().DateFrom().LowerOrEqual(date).
+			And().DateTo().GreaterOrEqual(date)).
+		Limit(1)
+	if dateRange.IsNotEmpty() {
+		return dateRange.DateFrom(), dateRange.DateTo(), dateRange.NumberNextActual()
+	}
+	dateFrom := dates.ParseDate(fmt.Sprintf("%d-01-01", date.Year()))
+	dateTo := dates.ParseDate(fmt.Sprintf("%d-12-31", date.Year()))
+	nextRange := h.SequenceDateRange().Search(rs.Env(),
+		q.SequenceDateRange().Sequence().Equals(rs).
+			And().DateFrom().GreaterOrEqual(date).
+			And().DateFrom().LowerOrEqual(dateTo)).
+		OrderBy("DateFrom").
+		Limit(1)
+	if nextRange.IsNotEmpty() {
+		dateTo = nextRange.DateFrom().AddDate(0, 0, -1)
+	}
+	return dateFrom, dateTo, 1
+}
+
+// PreviewNumbers returns the next count numbers of this sequence for
+// documents of the given date, without consuming them. If the sequence uses
+// date ranges, the first number of the next date range is appended, so that
+// the rollover behaviour can be checked.
+func sequence_PreviewNumbers(rs m.SequenceSet, date dates.Date, count int) []SequencePreviewNumber {
+	rs.EnsureOne()
+	var res []SequencePreviewNumber
+	seq := rs.WithContext("sequence_date", date)
+	if !rs.UseDateRange() {
+		next := rs.NumberNextActual()
+		for i := 0; i < count; i++ {
+			res = append(res, SequencePreviewNumber{Date: date, Number: seq.GetNextChar(next)})
+			next += rs.NumberIncrement()
+		}
+		return res
+	}
+	rangeFrom, rangeTo, next := sequencePreviewRange(rs, date)
+	for i := 0; i < count; i++ {
+		res = append(res, SequencePreviewNumber{
+			Date:   date,
+			Number: seq.WithContext("sequence_date_range", rangeFrom).GetNextChar(next),
+		})
+		next += rs.NumberIncrement()
+	}
+	rollover := rangeTo.AddDate(0, 0, 1)
+	rangeFrom, _, next = sequencePreviewRange(rs, rollover)
+	res = append(res, SequencePreviewNumber{
+		Date: rollover,
+		Number: rs.WithContext("sequence_date", rollover).
+			WithContext("sequence_date_range", rangeFrom).GetNextChar(next),
+		Rollover: true,
+	})
+	return res
+}
+
+// sequenceNumberCollisions returns the given numbers that are already used
+// in the given field of the documents of the given model. If the model has
+// a Company field and company is not empty, only the documents of this
+// company are taken into account.
+func sequenceNumberCollisions(env models.Environment, model, field string, company m.CompanySet, numbers []string) ([]string, error) {
+	mi, exists := models.Registry.Get(model)
+	if !exists {
+		return nil, fmt.Errorf("unknown model '%s'", model)
+	}
+	infos := modelFieldInfos(mi)
+	fi, ok := infos[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field '%s' in model '%s'", field, model)
+	}
+	fieldName := mi.FieldName(fi.Name)
+	_, hasCompany := infos["Company"]
+	documents := asSuperUser(env.Pool(model), "find used sequence numbers").WithContext("active_test", false).Search(mi.Field(fieldName).In(numbers))
+	used := make(map[string]bool)
+	for _, doc := range documents.Records() {
+		if hasCompany && company.IsNotEmpty() {
+			docCompany, ok := doc.Get(mi.FieldName("Company")).(models.RecordSet)
+			if ok && len(docCompany.Ids()) > 0 && docCompany.Ids()[0] != company.ID() {
+				continue
+			}
+		}
+		used[fmt.Sprintf("%v", doc.Get(fieldName))] = true
+	}
+	var res []string
+	for number := range used {
+		res = append(res, number)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+var fields_SequencePreview = map[string]models.FieldDefinition{
+	"Sequence": fields.Many2One{RelationModel: h.Sequence(), Required: true, OnDelete: models.Cascade,
+		Default: func(env models.Environment) interface{} {
+			return h.Sequence().BrowseOne(env, env.Context().GetInteger(ContextKeyActiveID))
+		}},
+	"Date": fields.Date{String: "Document Date", Required: true,
+		Default: func(env models.Environment) interface{} {
+			return dates.Today()
+		}},
+	"Count": fields.Integer{String: "Numbers", GoType: new(int), Default: models.DefaultValue(10)},
+	"DocumentModel": fields.Char{String: "Documents Model",
+		Help: "Model of the documents numbered with this sequence, e.g. AccountMove, to detect collisions"},
+	"DocumentField": fields.Char{String: "Number Field", Default: models.DefaultValue("Name"),
+		Help: "Field of the documents holding their number"},
+	"Preview": fields.Text{Compute: h.SequencePreview().Methods().ComputePreview(),
+		Depends: []string{"Sequence", "Date", "Count", "DocumentModel", "DocumentField"}},
+	"Collisions": fields.Text{Compute: h.SequencePreview().Methods().ComputePreview(),
+		Depends: []string{"Sequence", "Date", "Count", "DocumentModel", "DocumentField"}},
+}
+
+// ComputePreview lists the next numbers of the sequence and the ones that
+// are already used by documents.
+func sequencePreview_ComputePreview(rs m.SequencePreviewSet) m.SequencePreviewData {
+	res := h.SequencePreview().NewData()
+	if rs.Sequence().IsEmpty() {
+		return res
+	}
+	count := rs.Count()
+	if count <= 0 {
+		count = 10
+	}
+	numbers := rs.Sequence().PreviewNumbers(rs.Date(), count)
+	values := make([]string, len(numbers))
+	for i, number := range numbers {
+		values[i] = number.Number
+	}
+	var collisions []string
+	if rs.DocumentModel() != "" {
+		used, err := sequenceNumberCollisions(rs.Env(), rs.DocumentModel(), rs.DocumentField(), rs.Sequence().Company(), values)
+		if err != nil {
+			collisions = append(collisions, err.Error())
+		}
+		collisions = append(collisions, used...)
+	}
+	usedNumbers := make(map[string]bool)
+	for _, number := range collisions {
+		usedNumbers[number] = true
+	}
+	lines := make([]string, len(numbers))
+	for i, number := range numbers {
+		line := fmt.Sprintf("%s  %s", number.Date.String(), number.Number)
+		if number.Rollover {
+			line += "  " + rs.T("(new date range)")
+		}
+		if usedNumbers[number.Number] {
+			line += "  " + rs.T("ALREADY USED")
+		}
+		lines[i] = line
+	}
+	return res.
+		SetPreview(strings.Join(lines, "\n")).
+		SetCollisions(strings.Join(collisions, "\n"))
+}
+
+func init() {
+	h.Sequence().NewMethod("PreviewNumbers", sequence_PreviewNumbers)
+
+	models.NewTransientModel("SequencePreview")
+	h.SequencePreview().AddFields(fields_SequencePreview)
+	h.SequencePreview().NewMethod("ComputePreview", sequencePreview_ComputePreview)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSequencePreview(t *testing.T) {
+	Convey("Testing sequence numbering preview", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			march := dates.ParseDate("2024-03-01")
+			seq := h.Sequence().Create(env, h.Sequence().NewData().
+				SetName("Preview Invoices").
+				SetImplementation("no_gap").
+				SetPrefix("INV/%(year)s/").
+				SetPadding(4).
+				SetNumberNext(5).
+				SetNumberIncrement(2))
+			Convey("Next numbers are previewed without being consumed", func() {
+				numbers := seq.PreviewNumbers(march, 3)
+				So(numbers, ShouldHaveLength, 3)
+				So(numbers[0].Number, ShouldEqual, "INV/2024/0005")
+				So(numbers[2].Number, ShouldEqual, "INV/2024/0009")
+				So(numbers[2].Rollover, ShouldBeFalse)
+				So(seq.NumberNextActual(), ShouldEqual, 5)
+			})
+			Convey("Date range rollover is previewed", func() {
+				seq.Write(h.Sequence().NewData().
+					SetUseDateRange(true).
+					SetPrefix("%(range_year)s/%(month)s/").
+					SetPadding(0).
+					SetNumberIncrement(1))
+				h.SequenceDateRange().Create(env, h.SequenceDateRange().NewData().
+					SetSequence(seq).
+					SetDateFrom(dates.ParseDate("2024-01-01")).
+					SetDateTo(dates.ParseDate("2024-06-30")).
+					SetNumberNext(42))
+				numbers := seq.PreviewNumbers(march, 2)
+				So(numbers, ShouldHaveLength, 3)
+				So(numbers[0].Number, ShouldEqual, "2024/03/42")
+				So(numbers[1].Number, ShouldEqual, "2024/03/43")
+				So(numbers[2].Rollover, ShouldBeTrue)
+				So(numbers[2].Date.Equal(dates.ParseDate("2024-07-01")), ShouldBeTrue)
+				So(numbers[2].Number, ShouldEqual, "2024/07/1")
+			})
+			Convey("Collisions with existing documents are detected", func() {
+				h.Partner().Create(env, h.Partner().NewData().SetName("INV/2024/0007"))
+				wizard := h.SequencePreview().Create(env, h.SequencePreview().NewData().
+					SetSequence(seq).
+					SetDate(march).
+					SetCount(3).
+					SetDocumentModel("Partner"))
+				So(wizard.Collisions(), ShouldEqual, "INV/2024/0007")
+				So(wizard.Preview(), ShouldContainSubstring, "2024-03-01  INV/2024/0005\n")
+				So(wizard.Preview(), ShouldContainSubstring, "INV/2024/0007  ALREADY USED")
+				wizard.SetDocumentModel("UnknownModel")
+				So(wizard.Collisions(), ShouldContainSubstring, "unknown model")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -311,3 +311,35 @@ func TestSequenceDateRangeChangeImplementation(t *testing.T) {
 		}), ShouldBeNil)
 	})
 }
+
+func TestSequenceMixin(t *testing.T) {
+	Convey("Testing Sequence Mixin", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			t1 := h.PartnerTitle().Create(env, h.PartnerTitle().NewData().SetName("Title 1"))
+			t2 := h.PartnerTitle().Create(env, h.PartnerTitle().NewData().SetName("Title 2"))
+			t3 := h.PartnerTitle().Create(env, h.PartnerTitle().NewData().SetName("Title 3"))
+			Convey("New records are put at the end", func() {
+				So(t2.Sequence(), ShouldBeGreaterThan, t1.Sequence())
+				So(t3.Sequence(), ShouldBeGreaterThan, t2.Sequence())
+			})
+			Convey("Resequence renumbers records in the given order", func() {
+				h.PartnerTitle().NewSet(env).Resequence([]int64{t3.ID(), t1.ID(), t2.ID()})
+				So(t3.Sequence(), ShouldEqual, 1)
+				So(t1.Sequence(), ShouldEqual, 2)
+				So(t2.Sequence(), ShouldEqual, 3)
+				So(t3.WriteUID(), ShouldEqual, security.SuperUserID)
+			})
+			Convey("Resequence requires write access", func() {
+				groupUser := h.Group().Search(env, q.Group().GroupID().Equals(GroupUser.ID()))
+				user := h.User().Create(env, h.User().NewData().
+					SetName("Resequence User").
+					SetLogin("resequence_user").
+					SetGroups(groupUser))
+				user.SyncMemberships()
+				So(func() {
+					h.PartnerTitle().NewSet(env).Sudo(user.ID()).Resequence([]int64{t3.ID(), t1.ID(), t2.ID()})
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
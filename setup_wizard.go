@@ -0,0 +1,262 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/views"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+// Configuration parameters of the setup wizard
+const (
+	// SetupDoneParam is set to true once the setup wizard has been completed or skipped
+	SetupDoneParam = "base.setup_done"
+	// SetupPreviousHomeActionParam holds the home action of the administrator
+	// replaced by the setup wizard until it is completed.
+	SetupPreviousHomeActionParam = "base.setup_previous_home_action"
+)
+
+// setupWizardActionID is the XML ID of the action opening the setup wizard
+const setupWizardActionID = "base_action_setup_wizard"
+
+// A SetupStep is a step of the setup wizard run on new databases.
+//
+// Each step is a form view of ConfigSettings whose validation button calls
+// ActionSetupNext with the step name in the setup_step context key.
+type SetupStep struct {
+	// Name identifies the step in the setup_step context key
+	Name string
+	// Sequence orders the steps
+	Sequence int
+	// View is the XML ID of the ConfigSettings form view of the step
+	View string
+	// Apply applies the values of the step entered in the given ConfigSettings record
+	Apply func(rs m.ConfigSettingsSet)
+}
+
+var setupSteps = struct {
+	sync.RWMutex
+	steps map[string]SetupStep
+}{
+	steps: make(map[string]SetupStep),
+}
+
+// RegisterSetupStep adds a step to the setup wizard.
+// It panics if a step is already registered with the same name.
+func RegisterSetupStep(step SetupStep) {
+	setupSteps.Lock()
+	defer setupSteps.Unlock()
+	if _, exists := setupSteps.steps[step.Name]; exists {
+		log.Panic("Setup step already registered", "name", step.Name)
+	}
+	setupSteps.steps[step.Name] = step
+}
+
+// orderedSetupSteps returns the registered setup steps sorted by sequence
+func orderedSetupSteps() []SetupStep {
+	setupSteps.RLock()
+	defer setupSteps.RUnlock()
+	res := make([]SetupStep, 0, len(setupSteps.steps))
+	for _, step := range setupSteps.steps {
+		res = append(res, step)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Sequence != res[j].Sequence {
+			return res[i].Sequence < res[j].Sequence
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// SetupDone returns true if the setup wizard has been completed or skipped
+func SetupDone(env models.Environment) bool {
+	done, _ := strconv.ParseBool(h.ConfigParameter().NewSet(env).AsSuperUser("read setup state").GetParam(SetupDoneParam, "false"))
+	return done
+}
+
+// setupAdmin returns the administrator user of the database
+func setupAdmin(env models.Environment) m.UserSet {
+	return h.User().NewSet(env).AsSuperUser("read admin user").GetRecord("base_admin")
+}
+
+// startSetupWizard sets the setup wizard as home action of the administrator
+// if the setup of the database has not been done yet, so that it is displayed
+// at the first login.
+func startSetupWizard(env models.Environment) {
+	if SetupDone(env) {
+		return
+	}
+	admin := setupAdmin(env)
+	if admin.IsEmpty() || admin.ActionID().ID() == setupWizardActionID {
+		return
+	}
+	h.ConfigParameter().NewSet(env).AsSuperUser("save admin home action").SetParam(SetupPreviousHomeActionParam, admin.ActionID().ID())
+	admin.SetActionID(actions.MakeActionRef(setupWizardActionID))
+}
+
+// finishSetupWizard marks the setup as done and restores the home action of the administrator
+func finishSetupWizard(env models.Environment) {
+	params := h.ConfigParameter().NewSet(env).AsSuperUser("complete setup")
+	params.SetParam(SetupDoneParam, "true")
+	admin := setupAdmin(env)
+	if admin.IsNotEmpty() && admin.ActionID().ID() == setupWizardActionID {
+		admin.SetActionID(actions.MakeActionRef(params.GetParam(SetupPreviousHomeActionParam, "")))
+	}
+}
+
+// applySetupCompany writes the company details of the setup wizard on the current company
+func applySetupCompany(rs m.ConfigSettingsSet) {
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	vals := h.Company().NewData().
+		SetName(rs.SetupCompanyName()).
+		SetCountry(rs.SetupCountry()).
+		SetCurrency(rs.SetupCurrency())
+	if rs.SetupCompanyLogo() != "" {
+		vals.SetLogo(rs.SetupCompanyLogo())
+	}
+	company.Write(vals)
+}
+
+// applySetupLocalization sets the language and timezone of the administrator
+// and loads the states of the company country if requested.
+func applySetupLocalization(rs m.ConfigSettingsSet) {
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	vals := h.User().NewData()
+	if rs.SetupLang() != "" {
+		vals.SetLang(rs.SetupLang())
+	}
+	if rs.SetupTZ() != "" {
+		vals.SetTZ(rs.SetupTZ())
+	}
+	user.Write(vals)
+	if country := user.Company().Country(); rs.SetupLoadStates() && country.IsNotEmpty() {
+		rs.LoadSetupCountryStates(country)
+	}
+}
+
+// GetValues returns the current company and administrator settings as
+// default values of the setup wizard.
+func configSettings_GetValuesSetup(rs m.ConfigSettingsSet) m.ConfigSettingsData {
+	res := rs.Super().GetValues()
+	user := h.User().NewSet(rs.Env()).CurrentUser()
+	company := user.Company()
+	return res.
+		SetSetupCompanyName(company.Name()).
+		SetSetupCompanyLogo(company.Logo()).
+		SetSetupCountry(company.Country()).
+		SetSetupCurrency(company.Currency()).
+		SetSetupLang(user.Lang()).
+		SetSetupTZ(user.TZ())
+}
+
+// LoadSetupCountryStates loads the states of the given country during the
+// setup of the database.
+func configSettings_LoadSetupCountryStates(_ m.ConfigSettingsSet, country m.CountrySet) {
+	country.LoadStates()
+}
+
+// CheckSetupAccess panics if the current user is not allowed to run the setup wizard
+func configSettings_CheckSetupAccess(rs m.ConfigSettingsSet) {
+	if rs.Env().Uid() != security.SuperUserID && !h.User().NewSet(rs.Env()).CurrentUser().HasGroup(GroupSystem.ID()) {
+		log.Panic(rs.T("Only administrators can run the setup wizard"))
+	}
+}
+
+// setupStepAction returns the action opening the given setup step
+func setupStepAction(rs m.ConfigSettingsSet, step SetupStep) *actions.Action {
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Name:     rs.T("Setup"),
+		Model:    "ConfigSettings",
+		ViewMode: "form",
+		Views:    []views.ViewTuple{{ID: step.View, Type: views.ViewTypeForm}},
+		Target:   "new",
+		Context:  types.NewContext().WithKey(ContextKeySetupStep, step.Name),
+	}
+}
+
+// ActionSetupStart opens the first step of the setup wizard
+func configSettings_ActionSetupStart(rs m.ConfigSettingsSet) *actions.Action {
+	rs.CheckSetupAccess()
+	steps := orderedSetupSteps()
+	if len(steps) == 0 {
+		finishSetupWizard(rs.Env())
+		return &actions.Action{Type: actions.ActionClient, Tag: "reload"}
+	}
+	return setupStepAction(rs, steps[0])
+}
+
+// ActionSetupNext applies the values of the current setup step, given by
+// the setup_step context key, and opens the next step. After the last step,
+// the setup is marked as done and the client is reloaded.
+func configSettings_ActionSetupNext(rs m.ConfigSettingsSet) *actions.Action {
+	rs.EnsureOne()
+	rs.CheckSetupAccess()
+	current := ContextGetString(rs.Env(), ContextKeySetupStep)
+	steps := orderedSetupSteps()
+	for i, step := range steps {
+		if step.Name != current {
+			continue
+		}
+		if step.Apply != nil {
+			step.Apply(rs)
+		}
+		if i+1 < len(steps) {
+			return setupStepAction(rs, steps[i+1])
+		}
+		break
+	}
+	finishSetupWizard(rs.Env())
+	return &actions.Action{Type: actions.ActionClient, Tag: "reload"}
+}
+
+// ActionSetupSkip marks the setup as done without applying the current step
+func configSettings_ActionSetupSkip(rs m.ConfigSettingsSet) *actions.Action {
+	rs.CheckSetupAccess()
+	finishSetupWizard(rs.Env())
+	return &actions.Action{Type: actions.ActionClient, Tag: "reload"}
+}
+
+func init() {
+	h.ConfigSettings().AddFields(map[string]models.FieldDefinition{
+		"SetupCompanyName": fields.Char{String: "Company Name"},
+		"SetupCompanyLogo": fields.Binary{String: "Company Logo"},
+		"SetupCountry":     fields.Many2One{RelationModel: h.Country(), String: "Country"},
+		"SetupCurrency":    fields.Many2One{RelationModel: h.Currency(), String: "Currency"},
+		"SetupLang":        fields.Selection{String: "Language", SelectionFunc: langSelection},
+		"SetupTZ":          fields.Char{String: "Timezone"},
+		"SetupLoadStates": fields.Boolean{String: "Load Country States", Default: models.DefaultValue(true),
+			Help: "Load the states of the country of the company, used in addresses"},
+	})
+	h.ConfigSettings().Methods().GetValues().Extend(configSettings_GetValuesSetup)
+	h.ConfigSettings().NewMethod("LoadSetupCountryStates", configSettings_LoadSetupCountryStates)
+	h.ConfigSettings().NewMethod("CheckSetupAccess", configSettings_CheckSetupAccess)
+	h.ConfigSettings().NewMethod("ActionSetupStart", configSettings_ActionSetupStart)
+	h.ConfigSettings().NewMethod("ActionSetupNext", configSettings_ActionSetupNext)
+	h.ConfigSettings().NewMethod("ActionSetupSkip", configSettings_ActionSetupSkip)
+
+	RegisterSetupStep(SetupStep{
+		Name:     "company",
+		Sequence: 10,
+		View:     "base_view_setup_company_form",
+		Apply:    applySetupCompany,
+	})
+	RegisterSetupStep(SetupStep{
+		Name:     "localization",
+		Sequence: 20,
+		View:     "base_view_setup_localization_form",
+		Apply:    applySetupLocalization,
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSetupWizard(t *testing.T) {
+	Convey("Testing the setup wizard", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.ConfigParameter().NewSet(env).SetParam(SetupDoneParam, "false")
+			admin := setupAdmin(env)
+			homeAction := admin.ActionID().ID()
+			company := h.User().NewSet(env).CurrentUser().Company()
+			belgium := h.Country().Search(env, q.Country().Code().Equals("BE"))
+			Convey("Registering a step twice panics", func() {
+				So(func() { RegisterSetupStep(SetupStep{Name: "company"}) }, ShouldPanic)
+			})
+			Convey("The wizard is the home action of the administrator until the setup is done", func() {
+				startSetupWizard(env)
+				So(admin.ActionID().ID(), ShouldEqual, setupWizardActionID)
+				settings := h.ConfigSettings().NewSet(env).Create(h.ConfigSettings().NewData())
+				settings.ActionSetupSkip()
+				So(SetupDone(env), ShouldBeTrue)
+				So(admin.ActionID().ID(), ShouldEqual, homeAction)
+				startSetupWizard(env)
+				So(admin.ActionID().ID(), ShouldEqual, homeAction)
+			})
+			Convey("Steps are chained and applied in sequence", func() {
+				settings := h.ConfigSettings().NewSet(env).Create(h.ConfigSettings().NewData())
+				So(settings.SetupCompanyName(), ShouldEqual, company.Name())
+				action := settings.ActionSetupStart()
+				So(action.Views[0].ID, ShouldEqual, "base_view_setup_company_form")
+				settings.SetSetupCompanyName("Setup Company")
+				settings.SetSetupCountry(belgium)
+				action = settings.WithContext(ContextKeySetupStep, "company").ActionSetupNext()
+				So(action.Type, ShouldEqual, actions.ActionActWindow)
+				So(action.Views[0].ID, ShouldEqual, "base_view_setup_localization_form")
+				So(company.Name(), ShouldEqual, "Setup Company")
+				So(company.Country().Equals(belgium), ShouldBeTrue)
+				So(SetupDone(env), ShouldBeFalse)
+
+				settings = h.ConfigSettings().NewSet(env).Create(h.ConfigSettings().NewData())
+				settings.SetSetupTZ("Europe/Brussels")
+				action = settings.WithContext(ContextKeySetupStep, "localization").ActionSetupNext()
+				So(action.Type, ShouldEqual, actions.ActionClient)
+				So(h.User().NewSet(env).CurrentUser().TZ(), ShouldEqual, "Europe/Brussels")
+				So(SetupDone(env), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
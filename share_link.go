@@ -0,0 +1,267 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/google/uuid"
+)
+
+// ShareLinkDefaultValidityDays is the number of days a share link is valid
+// when the base.share_link_validity_days parameter is not set.
+const ShareLinkDefaultValidityDays = 7
+
+// databaseSecret returns the database.secret config parameter, generating
+// it if it does not exist yet.
+func databaseSecret(env models.Environment) string {
+	params := h.ConfigParameter().NewSet(env).AsSuperUser("read the database secret")
+	secret := params.GetParam("database.secret", "")
+	if secret == "" {
+		secret = uuid.New().String()
+		params.SetParam("database.secret", secret)
+	}
+	return secret
+}
+
+var fields_ShareLink = map[string]models.FieldDefinition{
+	"ResModel": fields.Char{String: "Shared Model", Required: true, Index: true, ReadOnly: true},
+	"ResID":    fields.Integer{String: "Shared Record ID", Required: true, Index: true, ReadOnly: true},
+	"ResName": fields.Char{String: "Shared Record", Compute: h.ShareLink().Methods().ComputeResName(),
+		Depends: []string{"ResModel", "ResID"}},
+	"SharedBy": fields.Many2One{RelationModel: h.User(), Required: true, ReadOnly: true, Index: true,
+		OnDelete: models.Cascade},
+	"Partner": fields.Many2One{String: "Shared With", RelationModel: h.Partner(), Index: true,
+		OnDelete: models.Cascade},
+	"Token": fields.Char{ReadOnly: true, NoCopy: true, Index: true,
+		Default: func(env models.Environment) interface{} {
+			return uuid.New().String()
+		}},
+	"Expiration": fields.DateTime{Required: true, Index: true},
+	"Revoked":    fields.Boolean{ReadOnly: true, NoCopy: true, Index: true},
+	"URL": fields.Char{String: "Share URL", Compute: h.ShareLink().Methods().ComputeURL(),
+		Depends: []string{"Token", "Expiration"}},
+	"AccessCount": fields.Integer{ReadOnly: true, NoCopy: true},
+	"LastAccess":  fields.DateTime{ReadOnly: true, NoCopy: true},
+	"AccessLogs": fields.One2Many{RelationModel: h.ShareLinkAccess(), ReverseFK: "Link", JSON: "access_log_ids",
+		ReadOnly: true},
+}
+
+var fields_ShareLinkAccess = map[string]models.FieldDefinition{
+	"Link": fields.Many2One{RelationModel: h.ShareLink(), Required: true, OnDelete: models.Cascade,
+		Index: true},
+	"IPAddress": fields.Char{String: "IP Address"},
+	"UserAgent": fields.Char{},
+}
+
+// ComputeResName returns the display name of the shared record
+func shareLink_ComputeResName(rs m.ShareLinkSet) m.ShareLinkData {
+	return h.ShareLink().NewData().SetResName(rs.SharedRecordName())
+}
+
+// Signature returns the signature of the URL of this link, which binds
+// its token to its expiration date.
+func shareLink_Signature(rs m.ShareLinkSet) string {
+	rs.EnsureOne()
+	mac := hmac.New(sha256.New, []byte(databaseSecret(rs.Env())))
+	mac.Write([]byte(rs.Token() + "|" + strconv.FormatInt(rs.Expiration().Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ComputeURL returns the public URL of this link
+func shareLink_ComputeURL(rs m.ShareLinkSet) m.ShareLinkData {
+	res := h.ShareLink().NewData()
+	if rs.Token() == "" {
+		return res.SetURL("")
+	}
+	baseURL := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read the base URL").
+		GetParam("web.base.url", "")
+	return res.SetURL(fmt.Sprintf("%s/share/%s/%s", strings.TrimRight(baseURL, "/"), rs.Token(), rs.Signature()))
+}
+
+// sharedRecord returns the record shared by the given link as superuser,
+// or nil if the model does not exist anymore.
+func sharedRecord(link m.ShareLinkSet) *models.RecordCollection {
+	return ReferencedRecord(link.Env(), link.ResModel(), link.ResID())
+}
+
+// SharedRecordName returns the display name of the shared record, or an
+// empty string if the record does not exist anymore.
+func shareLink_SharedRecordName(rs m.ShareLinkSet) string {
+	return ReferencedRecordName(rs.Env(), rs.ResModel(), rs.ResID())
+}
+
+// Share creates a link granting read-only access to the record of the given
+// model with the given ID to the given partner, which may be empty for an
+// anonymous link. The current user must be allowed to read the record.
+//
+// Users cannot create links directly, so that a link never points to a
+// record its author cannot read: links are created here as the super user.
+func shareLink_Share(rs m.ShareLinkSet, resModel string, resID int64, partner m.PartnerSet) m.ShareLinkSet {
+	model, exists := models.Registry.Get(resModel)
+	if !exists {
+		panic(NewUserError(rs, "Unknown model %s", resModel))
+	}
+	records := rs.Env().Pool(resModel)
+	records.CheckExecutionPermission(model.Methods().MustGet("Load").Underlying())
+	if records.Search(model.Field(models.ID).Equals(resID)).IsEmpty() {
+		panic(NewUserError(rs, "The record to share does not exist or you are not allowed to read it"))
+	}
+	days, err := strconv.Atoi(h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read the share link validity").
+		GetParam("base.share_link_validity_days", strconv.Itoa(ShareLinkDefaultValidityDays)))
+	if err != nil || days <= 0 {
+		days = ShareLinkDefaultValidityDays
+	}
+	link := rs.AsSuperUser("create a share link").Create(h.ShareLink().NewData().
+		SetResModel(resModel).
+		SetResID(resID).
+		SetPartner(partner).
+		SetExpiration(dates.Now().Add(time.Duration(days) * 24 * time.Hour)).
+		SetSharedBy(h.User().NewSet(rs.Env()).CurrentUser()))
+	return h.ShareLink().Browse(rs.Env(), link.Ids())
+}
+
+// shareLinksOwnedByCurrentUser returns true if the current user is an
+// administrator or if the current user shared all the given links.
+func shareLinksOwnedByCurrentUser(rs m.ShareLinkSet) bool {
+	if h.User().NewSet(rs.Env()).CurrentUser().IsAdmin() {
+		return true
+	}
+	return rs.Search(q.ShareLink().ID().In(rs.Ids())).Len() == rs.Len()
+}
+
+// Search restricts the links seen by users who are not administrators to
+// the links they created.
+func shareLink_Search(rs m.ShareLinkSet, cond q.ShareLinkCondition) m.ShareLinkSet {
+	if h.User().NewSet(rs.Env()).CurrentUser().IsAdmin() {
+		return rs.Super().Search(cond)
+	}
+	return rs.Super().Search(cond.And().SharedBy().Equals(h.User().NewSet(rs.Env()).CurrentUser()))
+}
+
+// Load checks that users who are not administrators only read the links
+// they created.
+func shareLink_Load(rs m.ShareLinkSet, fields ...models.FieldName) m.ShareLinkSet {
+	if !shareLinksOwnedByCurrentUser(rs) {
+		panic(NewUserError(rs, "You can only access the share links you created"))
+	}
+	return rs.Super().Load(fields...)
+}
+
+// Search restricts the accesses seen by users who are not administrators
+// to the accesses to the links they created.
+func shareLinkAccess_Search(rs m.ShareLinkAccessSet, cond q.ShareLinkAccessCondition) m.ShareLinkAccessSet {
+	if h.User().NewSet(rs.Env()).CurrentUser().IsAdmin() {
+		return rs.Super().Search(cond)
+	}
+	return rs.Super().Search(cond.And().LinkFilteredOn(
+		q.ShareLink().SharedBy().Equals(h.User().NewSet(rs.Env()).CurrentUser())))
+}
+
+// FindValid returns the link with the given token if the given signature
+// matches and the link is neither revoked nor expired. It returns an empty
+// recordset otherwise.
+func shareLink_FindValid(rs m.ShareLinkSet, token, signature string) m.ShareLinkSet {
+	res := h.ShareLink().NewSet(rs.Env())
+	if token == "" {
+		return res
+	}
+	link := h.ShareLink().Search(rs.Env(), q.ShareLink().Token().Equals(token).
+		And().Revoked().IsFalse().
+		And().Expiration().Greater(dates.Now())).Limit(1)
+	if link.IsEmpty() || !hmac.Equal([]byte(link.Signature()), []byte(signature)) {
+		return res
+	}
+	return link
+}
+
+// ActionRevoke revokes these links so that they cannot be used anymore.
+// Users who are not administrators can only revoke the links they created.
+func shareLink_ActionRevoke(rs m.ShareLinkSet) {
+	if !shareLinksOwnedByCurrentUser(rs) {
+		panic(NewUserError(rs, "You can only revoke the share links you created"))
+	}
+	rs.AsSuperUser("revoke share links").SetRevoked(true)
+}
+
+// LogAccess records an access to this link from the given IP address and user agent
+func shareLink_LogAccess(rs m.ShareLinkSet, ipAddress, userAgent string) {
+	rs.EnsureOne()
+	h.ShareLinkAccess().Create(rs.Env(), h.ShareLinkAccess().NewData().
+		SetLink(rs).
+		SetIPAddress(ipAddress).
+		SetUserAgent(userAgent))
+	rs.Write(h.ShareLink().NewData().
+		SetAccessCount(rs.AccessCount() + 1).
+		SetLastAccess(dates.Now()))
+}
+
+// Content returns the content type and the content served to the visitors
+// of this link. Shared attachments are served as is and other records as a
+// JSON object with their model, ID and display name. Addons may extend this
+// method to render other records, e.g. as a PDF report.
+func shareLink_Content(rs m.ShareLinkSet) (string, []byte) {
+	rs.EnsureOne()
+	if rs.ResModel() == "Attachment" {
+		attachment := h.Attachment().NewSet(rs.Env()).AsSuperUser("serve a shared attachment").Search(q.Attachment().ID().Equals(rs.ResID()))
+		if attachment.IsEmpty() {
+			return "", nil
+		}
+		content, err := base64.StdEncoding.DecodeString(attachment.Datas())
+		if err != nil {
+			log.Warn("Unable to decode shared attachment", "attachment", attachment.ID(), "error", err)
+			return "", nil
+		}
+		return attachment.MimeType(), content
+	}
+	record := sharedRecord(rs)
+	if record == nil || record.IsEmpty() {
+		return "", nil
+	}
+	content, err := json.Marshal(map[string]interface{}{
+		"model": rs.ResModel(),
+		"id":    rs.ResID(),
+		"name":  rs.SharedRecordName(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return "application/json", content
+}
+
+func init() {
+	models.NewModel("ShareLink")
+	h.ShareLink().SetDefaultOrder("ID desc")
+	h.ShareLink().AddFields(fields_ShareLink)
+	h.ShareLink().NewMethod("ComputeResName", shareLink_ComputeResName)
+	h.ShareLink().NewMethod("Signature", shareLink_Signature)
+	h.ShareLink().NewMethod("ComputeURL", shareLink_ComputeURL)
+	h.ShareLink().NewMethod("SharedRecordName", shareLink_SharedRecordName)
+	h.ShareLink().NewMethod("Share", shareLink_Share)
+	h.ShareLink().Methods().Search().Extend(shareLink_Search)
+	h.ShareLink().Methods().Load().Extend(shareLink_Load)
+	h.ShareLink().NewMethod("FindValid", shareLink_FindValid)
+	h.ShareLink().NewMethod("ActionRevoke", shareLink_ActionRevoke)
+	h.ShareLink().NewMethod("LogAccess", shareLink_LogAccess)
+	h.ShareLink().NewMethod("Content", shareLink_Content)
+
+	models.NewModel("ShareLinkAccess")
+	h.ShareLinkAccess().SetDefaultOrder("ID desc")
+	h.ShareLinkAccess().AddFields(fields_ShareLinkAccess)
+	h.ShareLinkAccess().Methods().Search().Extend(shareLinkAccess_Search)
+}
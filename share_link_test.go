@@ -0,0 +1,91 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShareLink(t *testing.T) {
+	Convey("Testing share links", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partner := h.Partner().Create(env, h.Partner().NewData().SetName("External Reviewer"))
+			document := h.Attachment().Create(env, h.Attachment().NewData().
+				SetName("report.txt").
+				SetDatas(base64.StdEncoding.EncodeToString([]byte("quarterly report"))))
+			link := h.ShareLink().NewSet(env).Share("Attachment", document.ID(), partner)
+			Convey("Links are signed and expiring", func() {
+				So(link.Token(), ShouldNotBeBlank)
+				So(link.ResName(), ShouldEqual, "report.txt")
+				So(link.Expiration().Greater(link.CreateDate()), ShouldBeTrue)
+				So(strings.HasSuffix(link.URL(), "/share/"+link.Token()+"/"+link.Signature()), ShouldBeTrue)
+			})
+			Convey("Valid links give access to the shared attachment", func() {
+				found := h.ShareLink().NewSet(env).FindValid(link.Token(), link.Signature())
+				So(found.Equals(link), ShouldBeTrue)
+				_, content := found.Content()
+				So(string(content), ShouldEqual, "quarterly report")
+				found.LogAccess("10.0.0.1", "test-agent")
+				So(link.AccessCount(), ShouldEqual, 1)
+				So(link.AccessLogs().Len(), ShouldEqual, 1)
+				So(link.AccessLogs().IPAddress(), ShouldEqual, "10.0.0.1")
+			})
+			Convey("Wrong signatures are rejected", func() {
+				So(h.ShareLink().NewSet(env).FindValid(link.Token(), "forged").IsEmpty(), ShouldBeTrue)
+				So(h.ShareLink().NewSet(env).FindValid("", "").IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Extending a link invalidates its previous URL", func() {
+				signature := link.Signature()
+				link.SetExpiration(link.Expiration().AddDate(0, 0, 1))
+				So(h.ShareLink().NewSet(env).FindValid(link.Token(), signature).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Revoked and expired links are rejected", func() {
+				signature := link.Signature()
+				link.ActionRevoke()
+				So(h.ShareLink().NewSet(env).FindValid(link.Token(), signature).IsEmpty(), ShouldBeTrue)
+				other := h.ShareLink().NewSet(env).Share("Partner", partner.ID(), h.Partner().NewSet(env))
+				other.SetExpiration(other.CreateDate().AddDate(0, 0, -1))
+				So(h.ShareLink().NewSet(env).FindValid(other.Token(), other.Signature()).IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Records are served as JSON", func() {
+				other := h.ShareLink().NewSet(env).Share("Partner", partner.ID(), h.Partner().NewSet(env))
+				contentType, content := other.Content()
+				So(contentType, ShouldEqual, "application/json")
+				So(string(content), ShouldContainSubstring, "External Reviewer")
+			})
+			Convey("Unknown records cannot be shared", func() {
+				So(func() { h.ShareLink().NewSet(env).Share("Partner", -1, partner) }, ShouldPanic)
+			})
+			Convey("Users only see and revoke the links they shared", func() {
+				groupUser := h.Group().Search(env, q.Group().GroupID().Equals(GroupUser.ID()))
+				user := h.User().Create(env, h.User().NewData().
+					SetName("Share Link User").
+					SetLogin("share_link_user").
+					SetGroups(groupUser))
+				user.SyncMemberships()
+				So(link.SharedBy().ID(), ShouldEqual, security.SuperUserID)
+				So(h.ShareLink().NewSet(env).Sudo(user.ID()).Search(q.ShareLink().ID().Equals(link.ID())).IsEmpty(), ShouldBeTrue)
+				So(func() { link.Sudo(user.ID()).Load() }, ShouldPanic)
+				So(func() { link.Sudo(user.ID()).ActionRevoke() }, ShouldPanic)
+				So(func() {
+					h.ShareLink().NewSet(env).Sudo(user.ID()).Create(h.ShareLink().NewData().
+						SetResModel("Attachment").
+						SetResID(document.ID()))
+				}, ShouldPanic)
+				own := h.ShareLink().NewSet(env).Sudo(user.ID()).Share("Partner", partner.ID(), h.Partner().NewSet(env))
+				So(own.SharedBy().Equals(user), ShouldBeTrue)
+				own.ActionRevoke()
+				So(own.Revoked(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,510 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+	"github.com/google/uuid"
+)
+
+// SignatureRequestDefaultValidityDays is the number of days signers have to sign
+// a request when the base.signature_validity_days parameter is not set.
+const SignatureRequestDefaultValidityDays = 30
+
+// SignatureWebhookURLParam is the config parameter holding the URL to which
+// signature requests are POSTed as JSON once they are fully signed. It is
+// set by administrators only, since the server POSTs to it.
+const SignatureWebhookURLParam = "base.signature_webhook_url"
+
+// SignatureRequestStates is the selection of the states of signature requests
+var SignatureRequestStates = types.Selection{
+	"draft":     "Draft",
+	"sent":      "Waiting for Signatures",
+	"signed":    "Fully Signed",
+	"refused":   "Refused",
+	"expired":   "Expired",
+	"cancelled": "Cancelled",
+}
+
+// SignatureSignerStates is the selection of the states of signers
+var SignatureSignerStates = types.Selection{
+	"pending": "To Sign",
+	"signed":  "Signed",
+	"refused": "Refused",
+}
+
+// SignatureAuditEvents is the selection of the events of the audit trail of signature requests
+var SignatureAuditEvents = types.Selection{
+	"sent":      "Sent",
+	"viewed":    "Viewed",
+	"signed":    "Signed",
+	"refused":   "Refused",
+	"completed": "Completed",
+	"expired":   "Expired",
+	"cancelled": "Cancelled",
+}
+
+// sha256Hex returns the hexadecimal SHA-256 hash of the given parts separated by '|'
+func sha256Hex(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(hash[:])
+}
+
+var fields_SignatureRequest = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true},
+	"Document": fields.Many2One{RelationModel: h.Attachment(), Required: true, OnDelete: models.Restrict,
+		Help: "Document to be signed. It must not be modified once the request is sent."},
+	"Signers": fields.One2Many{RelationModel: h.SignatureRequestSigner(), ReverseFK: "Request", JSON: "signer_ids",
+		Copy: true},
+	"State": fields.Selection{Selection: SignatureRequestStates, Required: true, Index: true, ReadOnly: true,
+		NoCopy: true, Default: models.DefaultValue("draft")},
+	"DocumentHash": fields.Char{ReadOnly: true, NoCopy: true,
+		Help: "SHA-256 hash of the document at the time the request was sent"},
+	"SignedHash": fields.Char{ReadOnly: true, NoCopy: true,
+		Help: "SHA-256 hash sealing the document hash and all the signatures"},
+	"Expiration":    fields.DateTime{ReadOnly: true, NoCopy: true, Index: true},
+	"DateCompleted": fields.DateTime{ReadOnly: true, NoCopy: true},
+	"AuditTrail": fields.One2Many{RelationModel: h.SignatureAuditEntry(), ReverseFK: "Request", JSON: "audit_trail_ids",
+		ReadOnly: true},
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+}
+
+var fields_SignatureRequestSigner = map[string]models.FieldDefinition{
+	"Request": fields.Many2One{RelationModel: h.SignatureRequest(), Required: true, OnDelete: models.Cascade,
+		Index: true},
+	"Partner":  fields.Many2One{RelationModel: h.Partner(), Required: true, OnDelete: models.Restrict},
+	"Sequence": fields.Integer{Default: models.DefaultValue(10)},
+	"State": fields.Selection{Selection: SignatureSignerStates, Required: true, ReadOnly: true, NoCopy: true,
+		Default: models.DefaultValue("pending")},
+	"TokenHash": fields.Char{ReadOnly: true, NoCopy: true, Index: true,
+		Help: "SHA-256 hash of the signing token sent to the signer. The token itself is not stored."},
+	"Signature": fields.Text{ReadOnly: true, NoCopy: true,
+		Help: "Signature given by the signer, e.g. a base64 encoded image or typed name"},
+	"SignatureHash": fields.Char{ReadOnly: true, NoCopy: true},
+	"DateSigned":    fields.DateTime{ReadOnly: true, NoCopy: true},
+}
+
+var fields_SignatureAuditEntry = map[string]models.FieldDefinition{
+	"Request": fields.Many2One{RelationModel: h.SignatureRequest(), Required: true, OnDelete: models.Cascade,
+		Index: true},
+	"Signer":    fields.Many2One{RelationModel: h.SignatureRequestSigner(), OnDelete: models.SetNull},
+	"Event":     fields.Selection{Selection: SignatureAuditEvents, Required: true},
+	"IPAddress": fields.Char{String: "IP Address"},
+	"Details":   fields.Text{},
+}
+
+// documentHash returns the SHA-256 hash of the content of the given attachment
+func documentHash(document m.AttachmentSet) string {
+	content, err := base64.StdEncoding.DecodeString(document.Datas())
+	if err != nil {
+		log.Panic("Unable to decode document", "attachment", document.ID(), "error", err)
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// signatureRequestProtectedFields are the fields of signature requests that
+// are only written by the signature workflow.
+var signatureRequestProtectedFields = []models.FieldName{
+	h.SignatureRequest().Fields().State(),
+	h.SignatureRequest().Fields().DocumentHash(),
+	h.SignatureRequest().Fields().SignedHash(),
+	h.SignatureRequest().Fields().Expiration(),
+	h.SignatureRequest().Fields().DateCompleted(),
+}
+
+// signatureSignerProtectedFields are the fields of signers that are only
+// written by the signature workflow.
+var signatureSignerProtectedFields = []models.FieldName{
+	h.SignatureRequestSigner().Fields().State(),
+	h.SignatureRequestSigner().Fields().TokenHash(),
+	h.SignatureRequestSigner().Fields().Signature(),
+	h.SignatureRequestSigner().Fields().SignatureHash(),
+	h.SignatureRequestSigner().Fields().DateSigned(),
+}
+
+// checkSignatureWorkflowValues panics if the given values set a field that
+// only the signature workflow may write, unless running as the super user.
+func checkSignatureWorkflowValues(rs userErrorSource, env models.Environment, vals models.RecordData, protected []models.FieldName) {
+	if env.Uid() == security.SuperUserID {
+		return
+	}
+	for _, field := range protected {
+		if vals.Underlying().Has(field) {
+			panic(NewUserError(rs, "The field %s is set by the signature workflow and cannot be modified", field.Name()))
+		}
+	}
+}
+
+// checkDraftRequests panics if one of the given requests is not a draft,
+// unless running as the super user, since sent requests must not change.
+func checkDraftRequests(rs m.SignatureRequestSet) {
+	if rs.Env().Uid() == security.SuperUserID {
+		return
+	}
+	for _, request := range rs.Records() {
+		if request.State() != "draft" {
+			panic(NewUserError(rs, "Signature request %s has been sent and cannot be modified anymore", request.Name()))
+		}
+	}
+}
+
+// Create checks that users do not set the fields of the signature workflow
+func signatureRequest_Create(rs m.SignatureRequestSet, vals m.SignatureRequestData) m.SignatureRequestSet {
+	checkSignatureWorkflowValues(rs, rs.Env(), vals, signatureRequestProtectedFields)
+	return rs.Super().Create(vals)
+}
+
+// Write checks that users only modify draft requests and do not set the
+// fields of the signature workflow.
+func signatureRequest_Write(rs m.SignatureRequestSet, vals m.SignatureRequestData) bool {
+	checkSignatureWorkflowValues(rs, rs.Env(), vals, signatureRequestProtectedFields)
+	checkDraftRequests(rs)
+	return rs.Super().Write(vals)
+}
+
+// Unlink checks that users only delete draft requests, so that the audit
+// trail of sent requests is kept.
+func signatureRequest_Unlink(rs m.SignatureRequestSet) int64 {
+	checkDraftRequests(rs)
+	return rs.Super().Unlink()
+}
+
+// Create checks that users only add signers to draft requests and do not
+// set the fields of the signature workflow.
+func signatureRequestSigner_Create(rs m.SignatureRequestSignerSet, vals m.SignatureRequestSignerData) m.SignatureRequestSignerSet {
+	checkSignatureWorkflowValues(rs, rs.Env(), vals, signatureSignerProtectedFields)
+	res := rs.Super().Create(vals)
+	checkDraftRequests(res.Request())
+	return res
+}
+
+// Write checks that users only modify the signers of draft requests and do
+// not set the fields of the signature workflow.
+func signatureRequestSigner_Write(rs m.SignatureRequestSignerSet, vals m.SignatureRequestSignerData) bool {
+	checkSignatureWorkflowValues(rs, rs.Env(), vals, signatureSignerProtectedFields)
+	checkDraftRequests(rs.Request())
+	return rs.Super().Write(vals)
+}
+
+// Unlink checks that users only remove the signers of draft requests
+func signatureRequestSigner_Unlink(rs m.SignatureRequestSignerSet) int64 {
+	checkDraftRequests(rs.Request())
+	return rs.Super().Unlink()
+}
+
+// LogEvent adds an entry to the audit trail of this request. Entries are
+// created as the super user since users cannot write the audit trail.
+func signatureRequest_LogEvent(rs m.SignatureRequestSet, event string, signer m.SignatureRequestSignerSet, ipAddress, details string) {
+	rs.EnsureOne()
+	h.SignatureAuditEntry().NewSet(rs.Env()).AsSuperUser("log a signature event").Create(h.SignatureAuditEntry().NewData().
+		SetRequest(rs).
+		SetSigner(signer).
+		SetEvent(event).
+		SetIPAddress(ipAddress).
+		SetDetails(details))
+}
+
+// signatureInvitationData is the data of the signature request email template
+type signatureInvitationData struct {
+	UserName  string
+	UserEmail string
+	Request   string
+	Document  string
+	URL       string
+}
+
+// signingURL returns the public URL at which the signer with the given
+// token signs its document.
+func signingURL(env models.Environment, token string) string {
+	baseURL := h.ConfigParameter().NewSet(env).AsSuperUser("read the base URL").GetParam("web.base.url", "")
+	return fmt.Sprintf("%s/sign/%s", strings.TrimSuffix(baseURL, "/"), token)
+}
+
+// ActionSend freezes the document hash, generates the signing tokens of the
+// signers and sends them their signing URL by email. Only the hashes of the
+// tokens are stored. Signatures are then awaited until the expiration date.
+func signatureRequest_ActionSend(rs m.SignatureRequestSet) {
+	days, err := strconv.Atoi(h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read the signature validity").
+		GetParam("base.signature_validity_days", strconv.Itoa(SignatureRequestDefaultValidityDays)))
+	if err != nil || days <= 0 {
+		days = SignatureRequestDefaultValidityDays
+	}
+	template := h.MailTemplate().NewSet(rs.Env()).AsSuperUser("send signature requests").
+		GetRecord("base_mail_template_signature_request")
+	for _, request := range rs.Records() {
+		if request.State() != "draft" {
+			panic(NewUserError(rs, "Only draft signature requests can be sent"))
+		}
+		if request.Signers().IsEmpty() {
+			panic(NewUserError(rs, "Signature request %s has no signers", request.Name()))
+		}
+		sudoRequest := request.AsSuperUser("send a signature request")
+		sudoRequest.Write(h.SignatureRequest().NewData().
+			SetState("sent").
+			SetDocumentHash(documentHash(request.Document())).
+			SetExpiration(dates.Now().Add(time.Duration(days) * 24 * time.Hour)))
+		for _, signer := range request.Signers().Records() {
+			token := uuid.New().String()
+			signer.AsSuperUser("send a signature request").SetTokenHash(sha256Hex(token))
+			partner := signer.Partner()
+			if partner.Email() == "" {
+				log.Warn("Signer has no email address to receive its signing URL", "request", request.ID(), "signer", signer.ID())
+				continue
+			}
+			err := template.ForPartner(partner).SendRecordMail(request.ModelName(), request.ID(), signatureInvitationData{
+				UserName:  partner.Name(),
+				UserEmail: partner.EmailFormatted(),
+				Request:   request.Name(),
+				Document:  request.Document().Name(),
+				URL:       signingURL(rs.Env(), token),
+			})
+			if err != nil {
+				log.Warn("Unable to send signature request", "request", request.ID(), "signer", signer.ID(), "error", err)
+			}
+		}
+		sudoRequest.LogEvent("sent", h.SignatureRequestSigner().NewSet(rs.Env()), "", "")
+	}
+}
+
+// ActionCancel cancels these requests if they are not completed
+func signatureRequest_ActionCancel(rs m.SignatureRequestSet) {
+	for _, request := range rs.Records() {
+		if request.State() != "draft" && request.State() != "sent" {
+			continue
+		}
+		sudoRequest := request.AsSuperUser("cancel a signature request")
+		sudoRequest.SetState("cancelled")
+		sudoRequest.Signers().SetTokenHash("")
+		sudoRequest.LogEvent("cancelled", h.SignatureRequestSigner().NewSet(rs.Env()), "", "")
+	}
+}
+
+// ComputeSignedHash returns the hash sealing the document hash and the
+// signature hashes of all the signers of this request.
+func signatureRequest_ComputeSignedHash(rs m.SignatureRequestSet) string {
+	rs.EnsureOne()
+	parts := []string{rs.DocumentHash()}
+	for _, signer := range rs.Signers().OrderBy("Sequence", "ID").Records() {
+		parts = append(parts, signer.SignatureHash())
+	}
+	return sha256Hex(parts...)
+}
+
+// VerifyIntegrity returns true if the document and the signatures of this
+// fully signed request have not been modified since they were signed.
+func signatureRequest_VerifyIntegrity(rs m.SignatureRequestSet) bool {
+	rs.EnsureOne()
+	if rs.State() != "signed" || documentHash(rs.Document()) != rs.DocumentHash() {
+		return false
+	}
+	for _, signer := range rs.Signers().Records() {
+		if signer.ComputeSignatureHash() != signer.SignatureHash() {
+			return false
+		}
+	}
+	return rs.ComputeSignedHash() == rs.SignedHash()
+}
+
+// Complete seals this request once all the signers have signed and
+// notifies the completion webhook, if one is configured.
+func signatureRequest_Complete(rs m.SignatureRequestSet) {
+	rs.EnsureOne()
+	sudoRequest := rs.AsSuperUser("complete a signature request")
+	sudoRequest.Write(h.SignatureRequest().NewData().
+		SetState("signed").
+		SetSignedHash(rs.ComputeSignedHash()).
+		SetDateCompleted(dates.Now()))
+	sudoRequest.Signers().SetTokenHash("")
+	sudoRequest.LogEvent("completed", h.SignatureRequestSigner().NewSet(rs.Env()), "", "")
+	if signatureWebhookURL(rs.Env()) != "" {
+		sudoRequest.Enqueue(rs.T("Notify completion of signature request %s", rs.Name()),
+			h.SignatureRequest().Methods().NotifyWebhook())
+	}
+}
+
+// signatureWebhookURL returns the URL of the signature completion webhook,
+// or an empty string if none is configured.
+func signatureWebhookURL(env models.Environment) string {
+	return h.ConfigParameter().NewSet(env).AsSuperUser("read the signature webhook URL").
+		GetParam(SignatureWebhookURLParam, "")
+}
+
+// NotifyWebhook POSTs the completion of these requests to the signature
+// webhook URL. It panics if the notification fails, so that the queue job
+// is retried.
+func signatureRequest_NotifyWebhook(rs m.SignatureRequestSet) {
+	url := signatureWebhookURL(rs.Env())
+	if url == "" {
+		return
+	}
+	for _, request := range rs.Records() {
+		body, err := json.Marshal(map[string]interface{}{
+			"id":            request.ID(),
+			"name":          request.Name(),
+			"state":         request.State(),
+			"document_hash": request.DocumentHash(),
+			"signed_hash":   request.SignedHash(),
+		})
+		if err != nil {
+			panic(err)
+		}
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			metricWebhookDeliveries.Inc("signature_request", "failure")
+			panic(fmt.Errorf("unable to notify signature request webhook: %s", err))
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			metricWebhookDeliveries.Inc("signature_request", "failure")
+			panic(fmt.Errorf("signature request webhook returned status %d", resp.StatusCode))
+		}
+		metricWebhookDeliveries.Inc("signature_request", "success")
+	}
+}
+
+// ExpireRequests sets the sent requests whose expiration date is passed as expired.
+//
+// This is the entry point of the signature requests expiration cron job.
+func signatureRequest_ExpireRequests(rs m.SignatureRequestSet) {
+	requests := h.SignatureRequest().NewSet(rs.Env()).AsSuperUser("expire signature requests").
+		Search(q.SignatureRequest().State().Equals("sent").And().Expiration().Lower(dates.Now()))
+	for _, request := range requests.Records() {
+		request.SetState("expired")
+		request.Signers().SetTokenHash("")
+		request.LogEvent("expired", h.SignatureRequestSigner().NewSet(rs.Env()), "", "")
+	}
+}
+
+// FindByToken returns the signer with the given signing token, provided
+// that the request is waiting for signatures and has not expired. It
+// returns an empty recordset otherwise.
+func signatureRequestSigner_FindByToken(rs m.SignatureRequestSignerSet, token string) m.SignatureRequestSignerSet {
+	if token == "" {
+		return h.SignatureRequestSigner().NewSet(rs.Env())
+	}
+	return h.SignatureRequestSigner().Search(rs.Env(), q.SignatureRequestSigner().TokenHash().Equals(sha256Hex(token)).
+		And().State().Equals("pending").
+		And().RequestFilteredOn(q.SignatureRequest().State().Equals("sent").
+		And().Expiration().Greater(dates.Now())))
+}
+
+// ComputeSignatureHash returns the hash binding the signature of this signer
+// to the document, the signer and the signing date.
+func signatureRequestSigner_ComputeSignatureHash(rs m.SignatureRequestSignerSet) string {
+	rs.EnsureOne()
+	return sha256Hex(rs.Request().DocumentHash(), strconv.FormatInt(rs.Partner().ID(), 10),
+		rs.DateSigned().Format("2006-01-02 15:04:05"), rs.Signature())
+}
+
+// checkSignable panics if the given token is not the signing token of this
+// signer or if this signer cannot sign or refuse anymore.
+func checkSignable(rs m.SignatureRequestSignerSet, token string) {
+	rs.EnsureOne()
+	if token == "" || rs.TokenHash() == "" ||
+		subtle.ConstantTimeCompare([]byte(sha256Hex(token)), []byte(rs.TokenHash())) != 1 {
+		panic(NewUserError(rs, "Invalid signing token"))
+	}
+	if rs.State() != "pending" || rs.Request().State() != "sent" || rs.Request().Expiration().Lower(dates.Now()) {
+		panic(NewUserError(rs, "This signature request is not waiting for your signature anymore"))
+	}
+	if documentHash(rs.Request().Document()) != rs.Request().DocumentHash() {
+		panic(NewUserError(rs, "The document has been modified since the signature request was sent"))
+	}
+}
+
+// MarkViewed records in the audit trail that the signer opened the document
+func signatureRequestSigner_MarkViewed(rs m.SignatureRequestSignerSet, ipAddress string) {
+	rs.EnsureOne()
+	rs.Request().LogEvent("viewed", rs, ipAddress, "")
+}
+
+// Sign records the given signature of this signer, who must give its signing
+// token. The request is completed when all its signers have signed.
+func signatureRequestSigner_Sign(rs m.SignatureRequestSignerSet, token, signature, ipAddress string) {
+	checkSignable(rs, token)
+	if strings.TrimSpace(signature) == "" {
+		panic(NewUserError(rs, "The signature is empty"))
+	}
+	signer := rs.AsSuperUser("sign a signature request")
+	signer.Write(h.SignatureRequestSigner().NewData().
+		SetState("signed").
+		SetSignature(signature).
+		SetDateSigned(dates.Now()))
+	signer.SetSignatureHash(signer.ComputeSignatureHash())
+	signer.Request().LogEvent("signed", signer, ipAddress, signer.Partner().Name())
+	pending := signer.Request().Signers().Filtered(func(r m.SignatureRequestSignerSet) bool {
+		return r.State() != "signed"
+	})
+	if pending.IsEmpty() {
+		signer.Request().Complete()
+	}
+}
+
+// Refuse records that this signer, who must give its signing token, refuses
+// to sign, which ends the request.
+func signatureRequestSigner_Refuse(rs m.SignatureRequestSignerSet, token, reason, ipAddress string) {
+	checkSignable(rs, token)
+	signer := rs.AsSuperUser("refuse a signature request")
+	signer.SetState("refused")
+	signer.Request().SetState("refused")
+	signer.Request().Signers().SetTokenHash("")
+	signer.Request().LogEvent("refused", signer, ipAddress, reason)
+}
+
+func init() {
+	models.NewModel("SignatureRequest")
+	h.SignatureRequest().SetDefaultOrder("ID desc")
+	h.SignatureRequest().AddFields(fields_SignatureRequest)
+	h.SignatureRequest().Methods().Create().Extend(signatureRequest_Create)
+	h.SignatureRequest().Methods().Write().Extend(signatureRequest_Write)
+	h.SignatureRequest().Methods().Unlink().Extend(signatureRequest_Unlink)
+	h.SignatureRequest().NewMethod("LogEvent", signatureRequest_LogEvent)
+	h.SignatureRequest().NewMethod("ActionSend", signatureRequest_ActionSend)
+	h.SignatureRequest().NewMethod("ActionCancel", signatureRequest_ActionCancel)
+	h.SignatureRequest().NewMethod("ComputeSignedHash", signatureRequest_ComputeSignedHash)
+	h.SignatureRequest().NewMethod("VerifyIntegrity", signatureRequest_VerifyIntegrity)
+	h.SignatureRequest().NewMethod("Complete", signatureRequest_Complete)
+	h.SignatureRequest().NewMethod("NotifyWebhook", signatureRequest_NotifyWebhook)
+	h.SignatureRequest().NewMethod("ExpireRequests", signatureRequest_ExpireRequests)
+
+	models.NewModel("SignatureRequestSigner")
+	h.SignatureRequestSigner().SetDefaultOrder("Sequence", "ID")
+	h.SignatureRequestSigner().AddFields(fields_SignatureRequestSigner)
+	h.SignatureRequestSigner().Methods().Create().Extend(signatureRequestSigner_Create)
+	h.SignatureRequestSigner().Methods().Write().Extend(signatureRequestSigner_Write)
+	h.SignatureRequestSigner().Methods().Unlink().Extend(signatureRequestSigner_Unlink)
+	h.SignatureRequestSigner().NewMethod("FindByToken", signatureRequestSigner_FindByToken)
+	h.SignatureRequestSigner().NewMethod("ComputeSignatureHash", signatureRequestSigner_ComputeSignatureHash)
+	h.SignatureRequestSigner().NewMethod("MarkViewed", signatureRequestSigner_MarkViewed)
+	h.SignatureRequestSigner().NewMethod("Sign", signatureRequestSigner_Sign)
+	h.SignatureRequestSigner().NewMethod("Refuse", signatureRequestSigner_Refuse)
+
+	models.NewModel("SignatureAuditEntry")
+	h.SignatureAuditEntry().SetDefaultOrder("ID")
+	h.SignatureAuditEntry().AddFields(fields_SignatureAuditEntry)
+}
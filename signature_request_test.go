@@ -0,0 +1,144 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// signingTokenRegexp extracts the signing token from the signing URL of an email
+var signingTokenRegexp = regexp.MustCompile(`/sign/([0-9a-f-]+)`)
+
+// sentSigningTokens returns the signing tokens sent by email by the given
+// sender, indexed by recipient.
+func sentSigningTokens(sender *testMailSender) map[string]string {
+	res := make(map[string]string)
+	for _, email := range sender.sent {
+		if match := signingTokenRegexp.FindStringSubmatch(email.Body); match != nil {
+			res[email.To[0]] = match[1]
+		}
+	}
+	return res
+}
+
+func TestSignatureRequest(t *testing.T) {
+	Convey("Testing signature requests", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			sender := new(testMailSender)
+			previous := SetMailSender(sender)
+			defer SetMailSender(previous)
+			h.ConfigParameter().NewSet(env).SetParam("mail.default_from", "noreply@example.com")
+			document := h.Attachment().Create(env, h.Attachment().NewData().
+				SetName("contract.pdf").
+				SetDatas(base64.StdEncoding.EncodeToString([]byte("%PDF-1.4 contract"))))
+			alice := h.Partner().Create(env, h.Partner().NewData().SetName("Alice").SetEmail("alice@example.com"))
+			bob := h.Partner().Create(env, h.Partner().NewData().SetName("Bob").SetEmail("bob@example.com"))
+			request := h.SignatureRequest().Create(env, h.SignatureRequest().NewData().
+				SetName("Contract").
+				SetDocument(document))
+			aliceSigner := h.SignatureRequestSigner().Create(env, h.SignatureRequestSigner().NewData().
+				SetRequest(request).
+				SetPartner(alice))
+			bobSigner := h.SignatureRequestSigner().Create(env, h.SignatureRequestSigner().NewData().
+				SetRequest(request).
+				SetPartner(bob))
+			request.ActionSend()
+			h.MailMessage().NewSet(env).ProcessQueue()
+			tokens := sentSigningTokens(sender)
+			aliceToken, bobToken := tokens[alice.EmailFormatted()], tokens[bob.EmailFormatted()]
+			Convey("Sending emails tokens and freezes the document hash", func() {
+				So(request.State(), ShouldEqual, "sent")
+				So(request.DocumentHash(), ShouldNotBeBlank)
+				So(aliceToken, ShouldNotBeBlank)
+				So(aliceToken, ShouldNotEqual, bobToken)
+				So(aliceSigner.TokenHash(), ShouldEqual, sha256Hex(aliceToken))
+				So(h.SignatureRequestSigner().NewSet(env).FindByToken(aliceToken).Equals(aliceSigner), ShouldBeTrue)
+				So(h.SignatureRequestSigner().NewSet(env).FindByToken("unknown").IsEmpty(), ShouldBeTrue)
+				So(func() { request.ActionSend() }, ShouldPanic)
+			})
+			Convey("Signers must give their own token", func() {
+				So(func() { aliceSigner.Sign("", "Alice", "10.0.0.1") }, ShouldPanic)
+				So(func() { aliceSigner.Sign(bobToken, "Alice", "10.0.0.1") }, ShouldPanic)
+				So(func() { aliceSigner.Refuse("forged", "No", "10.0.0.1") }, ShouldPanic)
+				So(aliceSigner.State(), ShouldEqual, "pending")
+			})
+			Convey("Users cannot forge signatures or the audit trail", func() {
+				user := h.User().Create(env, h.User().NewData().
+					SetName("Signature User").
+					SetLogin("signature_user").
+					SetGroups(h.Group().Search(env, q.Group().GroupID().Equals(GroupUser.ID()))))
+				user.SyncMemberships()
+				So(func() { aliceSigner.Sudo(user.ID()).SetState("signed") }, ShouldPanic)
+				So(func() { request.Sudo(user.ID()).SetState("signed") }, ShouldPanic)
+				So(func() { request.Sudo(user.ID()).SetName("Forged") }, ShouldPanic)
+				So(func() {
+					h.SignatureAuditEntry().NewSet(env).Sudo(user.ID()).Create(h.SignatureAuditEntry().NewData().
+						SetRequest(request).
+						SetEvent("signed"))
+				}, ShouldPanic)
+			})
+			Convey("The request is sealed when all signers have signed", func() {
+				aliceSigner.Sign(aliceToken, "Alice", "10.0.0.1")
+				So(request.State(), ShouldEqual, "sent")
+				So(func() { aliceSigner.Sign(aliceToken, "Alice", "10.0.0.1") }, ShouldPanic)
+				bobSigner.Sign(bobToken, "Bob", "10.0.0.2")
+				So(request.State(), ShouldEqual, "signed")
+				So(request.SignedHash(), ShouldNotBeBlank)
+				So(request.VerifyIntegrity(), ShouldBeTrue)
+				So(aliceSigner.TokenHash(), ShouldBeBlank)
+				events := request.AuditTrail().Search(q.SignatureAuditEntry().Event().Equals("signed"))
+				So(events.Len(), ShouldEqual, 2)
+				Convey("Tampering with a signature breaks the integrity", func() {
+					bobSigner.SetSignature("Mallory")
+					So(request.VerifyIntegrity(), ShouldBeFalse)
+				})
+			})
+			Convey("Signing fails if the document has been modified", func() {
+				document.SetDatas(base64.StdEncoding.EncodeToString([]byte("%PDF-1.4 forged")))
+				So(func() { aliceSigner.Sign(aliceToken, "Alice", "10.0.0.1") }, ShouldPanic)
+			})
+			Convey("A refusal ends the request", func() {
+				bobSigner.Refuse(bobToken, "Wrong amount", "10.0.0.2")
+				So(request.State(), ShouldEqual, "refused")
+				So(func() { aliceSigner.Sign(aliceToken, "Alice", "10.0.0.1") }, ShouldPanic)
+			})
+			Convey("Expired requests cannot be signed", func() {
+				request.SetExpiration(request.Expiration().AddDate(0, 0, -60))
+				So(h.SignatureRequestSigner().NewSet(env).FindByToken(aliceToken).IsEmpty(), ShouldBeTrue)
+				h.SignatureRequest().NewSet(env).ExpireRequests()
+				So(request.State(), ShouldEqual, "expired")
+			})
+		}), ShouldBeNil)
+	})
+	Convey("Testing signature request completion webhook", t, func() {
+		var payload map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewDecoder(r.Body).Decode(&payload)
+		}))
+		defer server.Close()
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			h.ConfigParameter().NewSet(env).SetParam(SignatureWebhookURLParam, server.URL)
+			request := h.SignatureRequest().Create(env, h.SignatureRequest().NewData().
+				SetName("Webhook").
+				SetSignedHash("abcd").
+				SetDocument(h.Attachment().Create(env, h.Attachment().NewData().
+					SetName("doc.pdf").
+					SetDatas(base64.StdEncoding.EncodeToString([]byte("%PDF-1.4"))))))
+			request.NotifyWebhook()
+			So(payload["name"], ShouldEqual, "Webhook")
+			So(payload["signed_hash"], ShouldEqual, "abcd")
+		}), ShouldBeNil)
+	})
+}
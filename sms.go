@@ -0,0 +1,273 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// States of SMS messages
+const (
+	SMSOutgoing  = "outgoing"
+	SMSSent      = "sent"
+	SMSDelivered = "delivered"
+	SMSError     = "error"
+	SMSCancelled = "cancelled"
+)
+
+// SMSStates is the selection of the states of SMS messages
+var SMSStates = types.Selection{
+	SMSOutgoing:  "In Queue",
+	SMSSent:      "Sent",
+	SMSDelivered: "Delivered",
+	SMSError:     "Error",
+	SMSCancelled: "Cancelled",
+}
+
+// phoneNumberMinDigits is the minimum number of digits of a valid phone number
+const phoneNumberMinDigits = 6
+
+// SanitizePhoneNumber returns the given phone number in E.164 format
+// (e.g. +33612345678), using the given country calling code for national
+// numbers. It returns an empty string if the number is not valid.
+func SanitizePhoneNumber(number string, countryCode int64) string {
+	// The national trunk prefix is often written in international numbers, e.g. +33 (0)6...
+	number = strings.Replace(strings.TrimSpace(number), "(0)", "", 1)
+	var digits strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			digits.WriteRune(r)
+		case r == ' ', r == '-', r == '.', r == '(', r == ')', r == '/':
+		default:
+			return ""
+		}
+	}
+	res := digits.String()
+	switch {
+	case strings.HasPrefix(res, "+"):
+	case strings.HasPrefix(res, "00"):
+		res = "+" + res[2:]
+	case countryCode == 0:
+		return ""
+	case strings.HasPrefix(res, "0"):
+		res = "+" + strconv.FormatInt(countryCode, 10) + res[1:]
+	default:
+		res = "+" + strconv.FormatInt(countryCode, 10) + res
+	}
+	if len(res)-1 < phoneNumberMinDigits || len(res)-1 > 15 {
+		return ""
+	}
+	return res
+}
+
+// An SMS is a text message to be sent by an SMSProvider
+type SMS struct {
+	// Reference is the unique reference of the message in the database
+	Reference string
+	// Number is the recipient's phone number in E.164 format
+	Number string
+	Body   string
+}
+
+// An SMSProvider sends text messages through an SMS gateway
+type SMSProvider interface {
+	// Send hands over the given message to the gateway and returns the
+	// gateway's reference of the message.
+	Send(env models.Environment, sms SMS) (string, error)
+	// ParseStatusCallback parses a delivery report sent by the gateway to
+	// the /sms/status/:provider URL. It returns the reference of the message,
+	// its new state (SMSSent, SMSDelivered or SMSError) and an optional
+	// message. Providers must authenticate the request and return an error
+	// if it does not come from the gateway.
+	ParseStatusCallback(r *http.Request) (string, string, string, error)
+}
+
+var smsProviders = struct {
+	sync.RWMutex
+	providers map[string]SMSProvider
+	labels    types.Selection
+}{
+	providers: make(map[string]SMSProvider),
+	labels:    make(types.Selection),
+}
+
+// RegisterSMSProvider registers the given SMSProvider under the given name,
+// so that it can be selected in the sms.provider config parameter.
+// It panics if a provider is already registered with this name.
+func RegisterSMSProvider(name, label string, provider SMSProvider) {
+	smsProviders.Lock()
+	defer smsProviders.Unlock()
+	if _, exists := smsProviders.providers[name]; exists {
+		log.Panic("SMS provider already registered", "name", name)
+	}
+	smsProviders.providers[name] = provider
+	smsProviders.labels[name] = label
+}
+
+// GetSMSProvider returns the SMSProvider registered with the given name.
+func GetSMSProvider(name string) (SMSProvider, bool) {
+	smsProviders.RLock()
+	defer smsProviders.RUnlock()
+	provider, ok := smsProviders.providers[name]
+	return provider, ok
+}
+
+// SMSProvidersSelection returns the selection of the registered SMS providers
+func SMSProvidersSelection() types.Selection {
+	smsProviders.RLock()
+	defer smsProviders.RUnlock()
+	res := make(types.Selection)
+	for name, label := range smsProviders.labels {
+		res[name] = label
+	}
+	return res
+}
+
+var fields_SMSMessage = map[string]models.FieldDefinition{
+	"Number":      fields.Char{Required: true, Index: true},
+	"Body":        fields.Text{Required: true},
+	"Partner":     fields.Many2One{String: "Recipient", RelationModel: h.Partner(), Index: true, OnDelete: models.SetNull},
+	"Provider":    fields.Selection{SelectionFunc: SMSProvidersSelection, Required: true, ReadOnly: true},
+	"ProviderRef": fields.Char{String: "Provider Reference", ReadOnly: true, Index: true, NoCopy: true},
+	"State": fields.Selection{Selection: SMSStates, Required: true, Index: true, ReadOnly: true, NoCopy: true,
+		Default: models.DefaultValue(SMSOutgoing)},
+	"ErrorMessage": fields.Text{ReadOnly: true, NoCopy: true},
+}
+
+// ConfiguredProvider returns the name of the SMSProvider set in the sms.provider
+// config parameter. It panics if no valid provider is configured.
+func smsMessage_ConfiguredProvider(rs m.SMSMessageSet) string {
+	name := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read SMS provider").GetParam("sms.provider", "")
+	if _, ok := GetSMSProvider(name); !ok {
+		log.Panic(rs.T("No SMS provider is configured. Set the sms.provider parameter to one of the installed providers."))
+	}
+	return name
+}
+
+// Send hands over these outgoing messages to their provider. Messages that
+// cannot be sent are set in error with the reason of the failure.
+func smsMessage_Send(rs m.SMSMessageSet) {
+	for _, sms := range rs.Records() {
+		if sms.State() != SMSOutgoing {
+			continue
+		}
+		provider, ok := GetSMSProvider(sms.Provider())
+		if !ok {
+			sms.Write(h.SMSMessage().NewData().
+				SetState(SMSError).
+				SetErrorMessage(rs.T("Unknown SMS provider '%s'", sms.Provider())))
+			continue
+		}
+		ref, err := provider.Send(rs.Env(), SMS{
+			Reference: strconv.FormatInt(sms.ID(), 10),
+			Number:    sms.Number(),
+			Body:      sms.Body(),
+		})
+		if err != nil {
+			log.Warn("Unable to send SMS", "sms", sms.ID(), "error", err)
+			sms.Write(h.SMSMessage().NewData().
+				SetState(SMSError).
+				SetErrorMessage(err.Error()))
+			continue
+		}
+		sms.Write(h.SMSMessage().NewData().
+			SetState(SMSSent).
+			SetProviderRef(ref).
+			SetErrorMessage(""))
+	}
+}
+
+// ActionRetry queues again these messages in error
+func smsMessage_ActionRetry(rs m.SMSMessageSet) {
+	toRetry := rs.Search(q.SMSMessage().State().Equals(SMSError))
+	if toRetry.IsEmpty() {
+		return
+	}
+	toRetry.Write(h.SMSMessage().NewData().
+		SetState(SMSOutgoing).
+		SetErrorMessage(""))
+	toRetry.Enqueue(rs.T("Send SMS"), h.SMSMessage().Methods().Send())
+}
+
+// ActionCancel cancels these messages if they have not been sent yet
+func smsMessage_ActionCancel(rs m.SMSMessageSet) {
+	rs.Search(q.SMSMessage().State().In([]string{SMSOutgoing, SMSError})).
+		SetState(SMSCancelled)
+}
+
+// UpdateDeliveryState sets the state of the message of the given provider
+// with the given reference. It is called when the provider reports the
+// delivery of a message. It returns false if there is no such message.
+func smsMessage_UpdateDeliveryState(rs m.SMSMessageSet, provider, providerRef, state, message string) bool {
+	if _, ok := SMSStates[state]; !ok || providerRef == "" {
+		return false
+	}
+	sms := h.SMSMessage().Search(rs.Env(),
+		q.SMSMessage().Provider().Equals(provider).And().ProviderRef().Equals(providerRef))
+	if sms.IsEmpty() {
+		return false
+	}
+	sms.Write(h.SMSMessage().NewData().
+		SetState(state).
+		SetErrorMessage(message))
+	return true
+}
+
+// ComputeMobileSanitized returns the mobile number of the partner in E.164 format
+func partner_ComputeMobileSanitized(rs m.PartnerSet) m.PartnerData {
+	return h.Partner().NewData().SetMobileSanitized(SanitizePhoneNumber(rs.Mobile(), rs.Country().PhoneCode()))
+}
+
+// SendSMS queues a text message with the given body to the mobile number
+// of each of these partners. Partners without a valid mobile number are
+// skipped. It returns the created messages.
+func partner_SendSMS(rs m.PartnerSet, body string) m.SMSMessageSet {
+	res := h.SMSMessage().NewSet(rs.Env())
+	if strings.TrimSpace(body) == "" {
+		log.Panic(rs.T("Cannot send an empty text message"))
+	}
+	provider := res.ConfiguredProvider()
+	for _, partner := range rs.Records() {
+		if partner.MobileSanitized() == "" {
+			log.Warn("Partner has no valid mobile number, skipping SMS", "partner", partner.ID())
+			continue
+		}
+		res = res.Union(h.SMSMessage().Create(rs.Env(), h.SMSMessage().NewData().
+			SetNumber(partner.MobileSanitized()).
+			SetBody(body).
+			SetPartner(partner).
+			SetProvider(provider)))
+	}
+	if res.IsNotEmpty() {
+		res.Enqueue(rs.T("Send SMS"), h.SMSMessage().Methods().Send())
+	}
+	return res
+}
+
+func init() {
+	models.NewModel("SMSMessage")
+	h.SMSMessage().SetDefaultOrder("ID desc")
+	h.SMSMessage().AddFields(fields_SMSMessage)
+	h.SMSMessage().NewMethod("ConfiguredProvider", smsMessage_ConfiguredProvider)
+	h.SMSMessage().NewMethod("Send", smsMessage_Send)
+	h.SMSMessage().NewMethod("ActionRetry", smsMessage_ActionRetry)
+	h.SMSMessage().NewMethod("ActionCancel", smsMessage_ActionCancel)
+	h.SMSMessage().NewMethod("UpdateDeliveryState", smsMessage_UpdateDeliveryState)
+
+	h.Partner().NewMethod("ComputeMobileSanitized", partner_ComputeMobileSanitized)
+	h.Partner().NewMethod("SendSMS", partner_SendSMS)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testSMSProvider records the messages it is asked to send
+type testSMSProvider struct {
+	sent []SMS
+}
+
+// Send records the given message
+func (p *testSMSProvider) Send(_ models.Environment, sms SMS) (string, error) {
+	p.sent = append(p.sent, sms)
+	return "SMS-" + sms.Reference, nil
+}
+
+// ParseStatusCallback is not used in tests
+func (p *testSMSProvider) ParseStatusCallback(_ *http.Request) (string, string, string, error) {
+	return "", "", "", errors.New("not implemented")
+}
+
+var testSMS = new(testSMSProvider)
+
+func init() {
+	RegisterSMSProvider("test", "Test Provider", testSMS)
+}
+
+func TestSMS(t *testing.T) {
+	Convey("Testing phone number sanitization", t, func() {
+		So(SanitizePhoneNumber("06 12 34 56 78", 33), ShouldEqual, "+33612345678")
+		So(SanitizePhoneNumber("+33 (0)6 12 34 56 78", 33), ShouldEqual, "+33612345678")
+		So(SanitizePhoneNumber("+32 470 12.34.56", 33), ShouldEqual, "+32470123456")
+		So(SanitizePhoneNumber("0032-470-123456", 0), ShouldEqual, "+32470123456")
+		So(SanitizePhoneNumber("0612345678", 0), ShouldEqual, "")
+		So(SanitizePhoneNumber("call me", 33), ShouldEqual, "")
+		So(SanitizePhoneNumber("123", 33), ShouldEqual, "")
+	})
+	Convey("Testing SMS sending", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			testSMS.sent = nil
+			h.ConfigParameter().NewSet(env).SetParam("sms.provider", "test")
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("SMS Recipient").
+				SetMobile("06 12 34 56 78").
+				SetCountry(h.Country().NewSet(env).GetRecord("base_fr")))
+			noMobile := h.Partner().Create(env, h.Partner().NewData().SetName("No Mobile"))
+			Convey("The mobile number is sanitized", func() {
+				So(partner.MobileSanitized(), ShouldEqual, "+33612345678")
+				So(noMobile.MobileSanitized(), ShouldEqual, "")
+			})
+			Convey("Messages are sent to partners with a mobile number", func() {
+				messages := partner.Union(noMobile).SendSMS("Your order has shipped")
+				So(messages.Len(), ShouldEqual, 1)
+				So(messages.State(), ShouldEqual, SMSOutgoing)
+				messages.Send()
+				So(messages.State(), ShouldEqual, SMSSent)
+				So(testSMS.sent, ShouldHaveLength, 1)
+				So(testSMS.sent[0].Number, ShouldEqual, "+33612345678")
+				So(messages.ProviderRef(), ShouldEqual, "SMS-"+testSMS.sent[0].Reference)
+				Convey("Delivery reports update the message state", func() {
+					So(h.SMSMessage().NewSet(env).UpdateDeliveryState("test", messages.ProviderRef(), SMSDelivered, ""), ShouldBeTrue)
+					So(messages.State(), ShouldEqual, SMSDelivered)
+					So(h.SMSMessage().NewSet(env).UpdateDeliveryState("test", "unknown", SMSDelivered, ""), ShouldBeFalse)
+				})
+			})
+		}), ShouldBeNil)
+	})
+}
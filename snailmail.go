@@ -0,0 +1,296 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// States of snailmail letters
+const (
+	LetterPending   = "pending"
+	LetterSent      = "sent"
+	LetterDelivered = "delivered"
+	LetterError     = "error"
+	LetterCancelled = "cancelled"
+)
+
+// LetterStates is the selection of the states of snailmail letters
+var LetterStates = types.Selection{
+	LetterPending:   "In Queue",
+	LetterSent:      "Sent",
+	LetterDelivered: "Delivered",
+	LetterError:     "Error",
+	LetterCancelled: "Cancelled",
+}
+
+// A Letter is a document to be printed and posted by a LetterProvider
+type Letter struct {
+	// Reference is the unique reference of the letter in the database
+	Reference string
+	// Document is the PDF document to print
+	Document []byte
+	// RecipientName is the name of the addressee
+	RecipientName string
+	// Address is the normalized postal address of the addressee and
+	// AddressLines the same address formatted for the recipient's country.
+	Address      basetypes.AddressData
+	AddressLines []string
+	Color        bool
+	Duplex       bool
+}
+
+// A LetterProvider prints and posts letters, typically through the API of a
+// postal service or of a print shop.
+type LetterProvider interface {
+	// Send hands over the given letter to the provider and returns the
+	// provider's reference of the letter.
+	Send(env models.Environment, letter Letter) (string, error)
+	// Status returns the state of the letter with the given provider
+	// reference, as one of LetterSent, LetterDelivered or LetterError,
+	// with an optional message.
+	Status(env models.Environment, providerRef string) (string, string, error)
+}
+
+var letterProviders = struct {
+	sync.RWMutex
+	providers map[string]LetterProvider
+	labels    types.Selection
+}{
+	providers: make(map[string]LetterProvider),
+	labels:    make(types.Selection),
+}
+
+// RegisterLetterProvider registers the given LetterProvider under the given
+// name, so that it can be selected in the snailmail.provider config parameter.
+// It panics if a provider is already registered with this name.
+func RegisterLetterProvider(name, label string, provider LetterProvider) {
+	letterProviders.Lock()
+	defer letterProviders.Unlock()
+	if _, exists := letterProviders.providers[name]; exists {
+		log.Panic("Letter provider already registered", "name", name)
+	}
+	letterProviders.providers[name] = provider
+	letterProviders.labels[name] = label
+}
+
+// GetLetterProvider returns the LetterProvider registered with the given name.
+func GetLetterProvider(name string) (LetterProvider, bool) {
+	letterProviders.RLock()
+	defer letterProviders.RUnlock()
+	provider, ok := letterProviders.providers[name]
+	return provider, ok
+}
+
+// LetterProvidersSelection returns the selection of the registered letter providers
+func LetterProvidersSelection() types.Selection {
+	letterProviders.RLock()
+	defer letterProviders.RUnlock()
+	res := make(types.Selection)
+	for name, label := range letterProviders.labels {
+		res[name] = label
+	}
+	return res
+}
+
+var fields_SnailmailLetter = map[string]models.FieldDefinition{
+	"Partner": fields.Many2One{String: "Recipient", RelationModel: h.Partner(), Required: true, Index: true,
+		OnDelete: models.Restrict},
+	"Document": fields.Many2One{RelationModel: h.Attachment(), Required: true, OnDelete: models.Restrict,
+		Help: "PDF document to print and post"},
+	"Provider":    fields.Selection{SelectionFunc: LetterProvidersSelection, Required: true, ReadOnly: true},
+	"ProviderRef": fields.Char{String: "Provider Reference", ReadOnly: true, Index: true, NoCopy: true},
+	"State": fields.Selection{Selection: LetterStates, Required: true, Index: true, ReadOnly: true, NoCopy: true,
+		Default: models.DefaultValue(LetterPending)},
+	"ErrorMessage": fields.Text{ReadOnly: true, NoCopy: true},
+	"Color":        fields.Boolean{Help: "Print the document in color"},
+	"Duplex":       fields.Boolean{String: "Both Sides", Help: "Print the document on both sides of the paper"},
+	"Company": fields.Many2One{RelationModel: h.Company(), Required: true,
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+}
+
+// ConfiguredProvider returns the name of the LetterProvider set in the
+// snailmail.provider config parameter. It panics if no valid provider is configured.
+func snailmailLetter_ConfiguredProvider(rs m.SnailmailLetterSet) string {
+	name := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read snail mail provider").GetParam("snailmail.provider", "")
+	if _, ok := GetLetterProvider(name); !ok {
+		log.Panic(rs.T("No postal mail provider is configured. Set the snailmail.provider parameter to one of the installed providers."))
+	}
+	return name
+}
+
+// letterAddress returns the postal address of the given partner
+func letterAddress(partner m.PartnerSet) basetypes.AddressData {
+	return basetypes.AddressData{
+		Street:      partner.Street(),
+		Street2:     partner.Street2(),
+		City:        partner.City(),
+		Zip:         partner.Zip(),
+		StateCode:   partner.State().Code(),
+		StateName:   partner.State().Name(),
+		CountryCode: partner.Country().Code(),
+		CountryName: partner.Country().Name(),
+	}
+}
+
+// letterAddressLines returns the non blank lines of the display address of the given partner
+func letterAddressLines(partner m.PartnerSet) []string {
+	var lines []string
+	for _, line := range strings.Split(partner.DisplayAddress(false), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// AddressError returns why a letter cannot be posted to the given partner,
+// or an empty string if the address of the partner is complete and has not
+// been found invalid by the address validator of the partner's company.
+func snailmailLetter_AddressError(rs m.SnailmailLetterSet, partner m.PartnerSet) string {
+	partner.EnsureOne()
+	if partner.AddressValidationStatus() == AddressInvalid {
+		return rs.T("The address of %s is invalid: %s", partner.Name(), partner.AddressValidationMessage())
+	}
+	result, _ := StrictAddressValidator{}.Validate(rs.Env(), letterAddress(partner))
+	if !result.Valid {
+		return rs.T("The address of %s is incomplete: %s", partner.Name(), result.Message)
+	}
+	return ""
+}
+
+// SendLetter queues the given document, typically a report rendered as a PDF
+// attachment, to be printed and posted to the given partner through the
+// configured letter provider. It panics if the address of the partner is not
+// suitable for postal mail.
+func snailmailLetter_SendLetter(rs m.SnailmailLetterSet, document m.AttachmentSet, partner m.PartnerSet) m.SnailmailLetterSet {
+	document.EnsureOne()
+	if msg := rs.AddressError(partner); msg != "" {
+		log.Panic(msg)
+	}
+	letter := h.SnailmailLetter().Create(rs.Env(), h.SnailmailLetter().NewData().
+		SetPartner(partner).
+		SetDocument(document).
+		SetProvider(rs.ConfiguredProvider()))
+	letter.Enqueue(rs.T("Post letter to %s", partner.Name()), h.SnailmailLetter().Methods().Post())
+	return letter
+}
+
+// setLetterError sets the given letter in error with the given message
+func setLetterError(letter m.SnailmailLetterSet, message string) {
+	letter.Write(h.SnailmailLetter().NewData().
+		SetState(LetterError).
+		SetErrorMessage(message))
+}
+
+// Post hands over these pending letters to their provider. Letters that
+// cannot be posted are set in error with the reason of the failure.
+func snailmailLetter_Post(rs m.SnailmailLetterSet) {
+	for _, letter := range rs.Records() {
+		if letter.State() != LetterPending {
+			continue
+		}
+		provider, ok := GetLetterProvider(letter.Provider())
+		if !ok {
+			setLetterError(letter, rs.T("Unknown postal mail provider '%s'", letter.Provider()))
+			continue
+		}
+		if msg := letter.AddressError(letter.Partner()); msg != "" {
+			setLetterError(letter, msg)
+			continue
+		}
+		document, err := base64.StdEncoding.DecodeString(letter.Document().Datas())
+		if err != nil {
+			setLetterError(letter, err.Error())
+			continue
+		}
+		ref, err := provider.Send(rs.Env(), Letter{
+			Reference:     strconv.FormatInt(letter.ID(), 10),
+			Document:      document,
+			RecipientName: letter.Partner().Name(),
+			Address:       letterAddress(letter.Partner()),
+			AddressLines:  letterAddressLines(letter.Partner()),
+			Color:         letter.Color(),
+			Duplex:        letter.Duplex(),
+		})
+		if err != nil {
+			log.Warn("Unable to post letter", "letter", letter.ID(), "error", err)
+			setLetterError(letter, err.Error())
+			continue
+		}
+		letter.Write(h.SnailmailLetter().NewData().
+			SetState(LetterSent).
+			SetProviderRef(ref).
+			SetErrorMessage(""))
+	}
+}
+
+// ActionRetry queues again these letters in error
+func snailmailLetter_ActionRetry(rs m.SnailmailLetterSet) {
+	for _, letter := range rs.Records() {
+		if letter.State() != LetterError {
+			continue
+		}
+		letter.Write(h.SnailmailLetter().NewData().
+			SetState(LetterPending).
+			SetErrorMessage(""))
+		letter.Enqueue(rs.T("Post letter to %s", letter.Partner().Name()), h.SnailmailLetter().Methods().Post())
+	}
+}
+
+// ActionCancel cancels these letters if they have not been sent yet
+func snailmailLetter_ActionCancel(rs m.SnailmailLetterSet) {
+	rs.Search(q.SnailmailLetter().State().In([]string{LetterPending, LetterError})).
+		SetState(LetterCancelled)
+}
+
+// UpdateStatus asks their provider the delivery status of the sent letters.
+//
+// This is the entry point of the letter status cron job.
+func snailmailLetter_UpdateStatus(rs m.SnailmailLetterSet) {
+	letters := h.SnailmailLetter().Search(rs.Env(), q.SnailmailLetter().State().Equals(LetterSent))
+	for _, letter := range letters.Records() {
+		provider, ok := GetLetterProvider(letter.Provider())
+		if !ok {
+			continue
+		}
+		state, message, err := provider.Status(rs.Env(), letter.ProviderRef())
+		if err != nil {
+			log.Warn("Unable to get letter status", "letter", letter.ID(), "error", err)
+			continue
+		}
+		if _, ok := LetterStates[state]; !ok || state == letter.State() {
+			continue
+		}
+		letter.Write(h.SnailmailLetter().NewData().
+			SetState(state).
+			SetErrorMessage(message))
+	}
+}
+
+func init() {
+	models.NewModel("SnailmailLetter")
+	h.SnailmailLetter().SetDefaultOrder("ID desc")
+	h.SnailmailLetter().AddFields(fields_SnailmailLetter)
+	h.SnailmailLetter().NewMethod("ConfiguredProvider", snailmailLetter_ConfiguredProvider)
+	h.SnailmailLetter().NewMethod("AddressError", snailmailLetter_AddressError)
+	h.SnailmailLetter().NewMethod("SendLetter", snailmailLetter_SendLetter)
+	h.SnailmailLetter().NewMethod("Post", snailmailLetter_Post)
+	h.SnailmailLetter().NewMethod("ActionRetry", snailmailLetter_ActionRetry)
+	h.SnailmailLetter().NewMethod("ActionCancel", snailmailLetter_ActionCancel)
+	h.SnailmailLetter().NewMethod("UpdateStatus", snailmailLetter_UpdateStatus)
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// testLetterProvider records the letters it is asked to post
+type testLetterProvider struct {
+	sent []Letter
+	fail bool
+}
+
+// Send records the given letter
+func (p *testLetterProvider) Send(_ models.Environment, letter Letter) (string, error) {
+	if p.fail {
+		return "", errors.New("printer on fire")
+	}
+	p.sent = append(p.sent, letter)
+	return "TEST-" + letter.Reference, nil
+}
+
+// Status reports all letters as delivered
+func (p *testLetterProvider) Status(_ models.Environment, _ string) (string, string, error) {
+	return LetterDelivered, "", nil
+}
+
+var testLetters = new(testLetterProvider)
+
+func init() {
+	RegisterLetterProvider("test", "Test Provider", testLetters)
+}
+
+func TestSnailmail(t *testing.T) {
+	Convey("Testing postal letters", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			testLetters.sent, testLetters.fail = nil, false
+			h.ConfigParameter().NewSet(env).SetParam("snailmail.provider", "test")
+			partner := h.Partner().Create(env, h.Partner().NewData().
+				SetName("Letter Recipient").
+				SetStreet("12 rue de la Paix").
+				SetZip("75002").
+				SetCity("Paris").
+				SetCountry(h.Country().NewSet(env).GetRecord("base_fr")))
+			document := h.Attachment().Create(env, h.Attachment().NewData().
+				SetName("letter.pdf").
+				SetDatas(base64.StdEncoding.EncodeToString([]byte("%PDF-1.4"))))
+			Convey("Letters are posted through the provider", func() {
+				letter := h.SnailmailLetter().NewSet(env).SendLetter(document, partner)
+				So(letter.State(), ShouldEqual, LetterPending)
+				letter.Post()
+				So(letter.State(), ShouldEqual, LetterSent)
+				So(testLetters.sent, ShouldHaveLength, 1)
+				So(string(testLetters.sent[0].Document), ShouldEqual, "%PDF-1.4")
+				So(testLetters.sent[0].AddressLines, ShouldContain, "12 rue de la Paix")
+				So(letter.ProviderRef(), ShouldEqual, "TEST-"+testLetters.sent[0].Reference)
+				h.SnailmailLetter().NewSet(env).UpdateStatus()
+				So(letter.State(), ShouldEqual, LetterDelivered)
+			})
+			Convey("Provider errors are recorded", func() {
+				testLetters.fail = true
+				letter := h.SnailmailLetter().NewSet(env).SendLetter(document, partner)
+				letter.Post()
+				So(letter.State(), ShouldEqual, LetterError)
+				So(letter.ErrorMessage(), ShouldEqual, "printer on fire")
+				letter.ActionCancel()
+				So(letter.State(), ShouldEqual, LetterCancelled)
+			})
+			Convey("Incomplete addresses are rejected", func() {
+				partner.SetStreet("")
+				So(func() { h.SnailmailLetter().NewSet(env).SendLetter(document, partner) }, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
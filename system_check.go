@@ -0,0 +1,317 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/erlangs/okoo/src/actions"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/okoo/src/models/types"
+	"github.com/erlangs/okoo/src/models/types/dates"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+// Statuses of system checks, from the best to the worst
+const (
+	SystemCheckOK      = "ok"
+	SystemCheckWarning = "warning"
+	SystemCheckError   = "error"
+)
+
+// SystemCheckStatuses is the selection of the statuses of system checks
+var SystemCheckStatuses = types.Selection{
+	SystemCheckOK:      "OK",
+	SystemCheckWarning: "Warning",
+	SystemCheckError:   "Error",
+}
+
+// systemCheckSeverity orders the statuses of system checks
+var systemCheckSeverity = map[string]int{
+	SystemCheckOK:      0,
+	SystemCheckWarning: 1,
+	SystemCheckError:   2,
+}
+
+// Warning thresholds of the system checks of the base module
+var (
+	// SystemCheckDBLatencyWarning is the database round trip time above which a warning is raised
+	SystemCheckDBLatencyWarning = 100 * time.Millisecond
+	// SystemCheckCronDelay is the delay after which a cron that has not run is considered late
+	SystemCheckCronDelay = 10 * time.Minute
+	// SystemCheckPendingJobsWarning is the number of pending queue jobs above which a warning is raised
+	SystemCheckPendingJobsWarning = 100
+	// SystemCheckFilestoreFreeWarning is the free space ratio of the filestore disk below which a warning is raised
+	SystemCheckFilestoreFreeWarning = 0.1
+	// SystemCheckCurrencyRateMaxAge is the age of the last currency rate above which
+	// a warning is raised in multi-currency databases
+	SystemCheckCurrencyRateMaxAge = 7 * 24 * time.Hour
+	// SystemCheckBackupMaxAge is the age of the last database backup above which a warning is raised
+	SystemCheckBackupMaxAge = 48 * time.Hour
+	// SystemCheckMailQueueWarning is the number of emails waiting to be sent above which a warning is raised
+	SystemCheckMailQueueWarning = 500
+	// SystemChecksCacheDuration is the duration during which the results of
+	// CachedSystemChecks are reused
+	SystemChecksCacheDuration = 30 * time.Second
+)
+
+// A SystemCheckResult is the result of a system check
+type SystemCheckResult struct {
+	Name    string `json:"name"`
+	Label   string `json:"label"`
+	Status  string `json:"status"`
+	Value   string `json:"value,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// A SystemCheckFunc checks a part of the system. It only needs to set the
+// Status, Value and Message of the returned result.
+type SystemCheckFunc func(env models.Environment) SystemCheckResult
+
+type systemCheck struct {
+	name  string
+	label string
+	fnct  SystemCheckFunc
+}
+
+var systemChecks = struct {
+	sync.RWMutex
+	checks map[string]systemCheck
+}{
+	checks: make(map[string]systemCheck),
+}
+
+// RegisterSystemCheck registers a check that is run by RunSystemChecks.
+// Modules can register their own checks, such as the size of a queue.
+// It panics if a check is already registered with the same name.
+func RegisterSystemCheck(name, label string, fnct SystemCheckFunc) {
+	systemChecks.Lock()
+	defer systemChecks.Unlock()
+	if _, exists := systemChecks.checks[name]; exists {
+		log.Panic("System check already registered", "name", name)
+	}
+	systemChecks.checks[name] = systemCheck{name: name, label: label, fnct: fnct}
+}
+
+// RunSystemChecks runs all the registered system checks, sorted by name.
+// A check that panics is reported with the error status.
+func RunSystemChecks(env models.Environment) []SystemCheckResult {
+	systemChecks.RLock()
+	checks := make([]systemCheck, 0, len(systemChecks.checks))
+	for _, check := range systemChecks.checks {
+		checks = append(checks, check)
+	}
+	systemChecks.RUnlock()
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	res := make([]SystemCheckResult, len(checks))
+	for i, check := range checks {
+		res[i] = runSystemCheck(env, check)
+	}
+	return res
+}
+
+// systemChecksCache holds the last results of CachedSystemChecks
+var systemChecksCache = struct {
+	sync.Mutex
+	results []SystemCheckResult
+	date    time.Time
+}{}
+
+// CachedSystemChecks runs all the registered system checks as the super user
+// and returns their results. Results are cached for SystemChecksCacheDuration
+// so that frequent monitoring probes do not load the database.
+func CachedSystemChecks() ([]SystemCheckResult, error) {
+	systemChecksCache.Lock()
+	defer systemChecksCache.Unlock()
+	if systemChecksCache.results != nil && time.Since(systemChecksCache.date) < SystemChecksCacheDuration {
+		return systemChecksCache.results, nil
+	}
+	var results []SystemCheckResult
+	err := models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+		results = RunSystemChecks(env)
+	})
+	if err != nil {
+		return nil, err
+	}
+	systemChecksCache.results = results
+	systemChecksCache.date = time.Now()
+	return results, nil
+}
+
+// runSystemCheck runs the given check and recovers its panics
+func runSystemCheck(env models.Environment, check systemCheck) (res SystemCheckResult) {
+	defer func() {
+		if r := recover(); r != nil {
+			res.Status = SystemCheckError
+			res.Message = fmt.Sprintf("%v", r)
+		}
+		res.Name = check.name
+		res.Label = check.label
+		if res.Status == "" {
+			res.Status = SystemCheckOK
+		}
+	}()
+	return check.fnct(env)
+}
+
+// SystemCheckStatus returns the worst status of the given results
+func SystemCheckStatus(results []SystemCheckResult) string {
+	status := SystemCheckOK
+	for _, result := range results {
+		if systemCheckSeverity[result.Status] > systemCheckSeverity[status] {
+			status = result.Status
+		}
+	}
+	return status
+}
+
+// checkDBLatency measures the round trip time of a trivial query
+func checkDBLatency(env models.Environment) SystemCheckResult {
+	var one int
+	start := time.Now()
+	env.Cr().Get(&one, "SELECT 1")
+	latency := time.Since(start)
+	res := SystemCheckResult{Status: SystemCheckOK, Value: latency.String()}
+	if latency > SystemCheckDBLatencyWarning {
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("Database latency is above %s", SystemCheckDBLatencyWarning)
+	}
+	return res
+}
+
+// checkCronBacklog counts the late crons and the pending queue jobs
+func checkCronBacklog(env models.Environment) SystemCheckResult {
+	lateCrons := h.Cron().Search(env, q.Cron().NextCall().Lower(dates.Now().Add(-SystemCheckCronDelay))).SearchCount()
+	pendingJobs := h.QueueJob().Search(env, q.QueueJob().State().In([]string{"pending", "enqueued"})).SearchCount()
+	res := SystemCheckResult{
+		Status: SystemCheckOK,
+		Value:  fmt.Sprintf("%d late crons, %d pending jobs", lateCrons, pendingJobs),
+	}
+	switch {
+	case lateCrons > 0:
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("Some crons have not run for more than %s", SystemCheckCronDelay)
+	case pendingJobs > SystemCheckPendingJobsWarning:
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("More than %d jobs are waiting in the queue", SystemCheckPendingJobsWarning)
+	}
+	return res
+}
+
+// checkFilestore checks the free space of the disk of the filestore
+func checkFilestore(env models.Environment) SystemCheckResult {
+	dir := h.Attachment().NewSet(env).FileStore()
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		return SystemCheckResult{Status: SystemCheckWarning, Value: "unknown",
+			Message: fmt.Sprintf("Unable to get the free space of %s: %s", dir, err)}
+	}
+	res := SystemCheckResult{
+		Status: SystemCheckOK,
+		Value:  fmt.Sprintf("%d MiB free of %d MiB", free>>20, total>>20),
+	}
+	if total > 0 && float64(free)/float64(total) < SystemCheckFilestoreFreeWarning {
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("Less than %.0f%% of the filestore disk is free", SystemCheckFilestoreFreeWarning*100)
+	}
+	return res
+}
+
+// checkCurrencyRates checks the date of the last currency rate update
+func checkCurrencyRates(env models.Environment) SystemCheckResult {
+	lastRate := h.CurrencyRate().NewSet(env).SearchAll().OrderBy("Name desc").Limit(1)
+	if lastRate.IsEmpty() {
+		return SystemCheckResult{Status: SystemCheckOK, Value: "never"}
+	}
+	res := SystemCheckResult{Status: SystemCheckOK, Value: lastRate.Name().String()}
+	activeCurrencies := h.Currency().NewSet(env).SearchAll().SearchCount()
+	if activeCurrencies > 1 && time.Since(lastRate.Name().Time) > SystemCheckCurrencyRateMaxAge {
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("Currency rates have not been updated for more than %s", SystemCheckCurrencyRateMaxAge)
+	}
+	return res
+}
+
+// checkMailQueue counts the emails waiting to be sent and the failed ones
+func checkMailQueue(env models.Environment) SystemCheckResult {
+	outgoing := h.MailMessage().Search(env, q.MailMessage().State().Equals(MailMessageOutgoing)).SearchCount()
+	failed := h.MailMessage().Search(env, q.MailMessage().State().Equals(MailMessageException)).SearchCount()
+	res := SystemCheckResult{
+		Status: SystemCheckOK,
+		Value:  fmt.Sprintf("%d outgoing, %d failed", outgoing, failed),
+	}
+	if outgoing > SystemCheckMailQueueWarning {
+		res.Status = SystemCheckWarning
+		res.Message = fmt.Sprintf("More than %d emails are waiting to be sent", SystemCheckMailQueueWarning)
+	}
+	return res
+}
+
+var fields_SystemCheck = map[string]models.FieldDefinition{
+	"Name":    fields.Char{Required: true},
+	"Label":   fields.Char{String: "Check", Required: true},
+	"Status":  fields.Selection{Selection: SystemCheckStatuses, Required: true},
+	"Value":   fields.Char{},
+	"Message": fields.Text{},
+}
+
+// RunChecks runs all the registered system checks and returns one SystemCheck record per check
+func systemCheck_RunChecks(rs m.SystemCheckSet) m.SystemCheckSet {
+	res := h.SystemCheck().NewSet(rs.Env())
+	for _, result := range RunSystemChecks(rs.Env()) {
+		res = res.Union(h.SystemCheck().Create(rs.Env(), h.SystemCheck().NewData().
+			SetName(result.Name).
+			SetLabel(result.Label).
+			SetStatus(result.Status).
+			SetValue(result.Value).
+			SetMessage(result.Message)))
+	}
+	return res
+}
+
+// ActionOpenDashboard runs the system checks and opens their results
+func systemCheck_ActionOpenDashboard(rs m.SystemCheckSet) *actions.Action {
+	checks := rs.RunChecks()
+	return &actions.Action{
+		Type:     actions.ActionActWindow,
+		Name:     rs.T("System Health"),
+		Model:    "SystemCheck",
+		ViewMode: "tree",
+		Domain:   fmt.Sprintf("[('id', 'in', %s)]", idsToPythonList(checks.Ids())),
+	}
+}
+
+// idsToPythonList returns the given ids as a list literal for action domains
+func idsToPythonList(ids []int64) string {
+	res := "["
+	for i, id := range ids {
+		if i > 0 {
+			res += ", "
+		}
+		res += fmt.Sprintf("%d", id)
+	}
+	return res + "]"
+}
+
+func init() {
+	models.NewTransientModel("SystemCheck")
+	h.SystemCheck().AddFields(fields_SystemCheck)
+	h.SystemCheck().NewMethod("RunChecks", systemCheck_RunChecks)
+	h.SystemCheck().NewMethod("ActionOpenDashboard", systemCheck_ActionOpenDashboard)
+
+	RegisterSystemCheck("db_latency", "Database Latency", checkDBLatency)
+	RegisterSystemCheck("cron_backlog", "Cron Backlog", checkCronBacklog)
+	RegisterSystemCheck("filestore_space", "Filestore Free Space", checkFilestore)
+	RegisterSystemCheck("currency_rates", "Last Currency Rate Update", checkCurrencyRates)
+	RegisterSystemCheck("mail_queue", "Mail Queue", checkMailQueue)
+}
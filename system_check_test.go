@@ -0,0 +1,59 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSystemCheck(t *testing.T) {
+	Convey("Testing system checks", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Base checks are run", func() {
+				results := RunSystemChecks(env)
+				names := make([]string, len(results))
+				for i, result := range results {
+					names[i] = result.Name
+					So(SystemCheckStatuses, ShouldContainKey, result.Status)
+				}
+				So(names, ShouldContain, "db_latency")
+				So(names, ShouldContain, "cron_backlog")
+				So(names, ShouldContain, "filestore_space")
+				So(names, ShouldContain, "currency_rates")
+				So(names, ShouldContain, "mail_queue")
+			})
+			Convey("Mail queue check reports the outgoing emails", func() {
+				So(checkMailQueue(env).Value, ShouldContainSubstring, "outgoing")
+			})
+			Convey("Panicking checks are reported as errors", func() {
+				result := runSystemCheck(env, systemCheck{name: "broken", label: "Broken", fnct: func(env models.Environment) SystemCheckResult {
+					panic("something is broken")
+				}})
+				So(result.Name, ShouldEqual, "broken")
+				So(result.Status, ShouldEqual, SystemCheckError)
+				So(result.Message, ShouldEqual, "something is broken")
+			})
+			Convey("Global status is the worst status", func() {
+				So(SystemCheckStatus(nil), ShouldEqual, SystemCheckOK)
+				So(SystemCheckStatus([]SystemCheckResult{
+					{Status: SystemCheckOK}, {Status: SystemCheckWarning}, {Status: SystemCheckOK},
+				}), ShouldEqual, SystemCheckWarning)
+				So(SystemCheckStatus([]SystemCheckResult{
+					{Status: SystemCheckError}, {Status: SystemCheckWarning},
+				}), ShouldEqual, SystemCheckError)
+			})
+			Convey("Dashboard lists one record per check", func() {
+				checks := h.SystemCheck().NewSet(env).RunChecks()
+				So(checks.Len(), ShouldEqual, len(RunSystemChecks(env)))
+				action := h.SystemCheck().NewSet(env).ActionOpenDashboard()
+				So(action.Model, ShouldEqual, "SystemCheck")
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -0,0 +1,191 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+var fields_Tag = map[string]models.FieldDefinition{
+	"Name": fields.Char{String: "Tag Name", Required: true, Translate: true},
+	"Model": fields.Char{Index: true, Constraint: h.Tag().Methods().CheckModel(),
+		Help: "Name of the model this tag is restricted to, e.g. Attachment. Leave empty to share the tag between all models."},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true,
+		Help: "The active field allows you to hide the tag without removing it."},
+}
+
+// CheckModel checks that the model of the tags exists and supports tags
+func tag_CheckModel(rs m.TagSet) {
+	for _, tag := range rs.Records() {
+		if tag.Model() == "" {
+			continue
+		}
+		model, exists := models.Registry.Get(tag.Model())
+		if !exists {
+			log.Panic(rs.T("Unknown model '%s' for tag '%s'.", tag.Model(), tag.Name()))
+		}
+		if _, ok := model.Fields().Get("tag_ids"); !ok {
+			log.Panic(rs.T("Model '%s' of tag '%s' does not support tags.", tag.Model(), tag.Name()))
+		}
+	}
+}
+
+func tag_Create(rs m.TagSet, data m.TagData) m.TagSet {
+	if !data.HasColor() {
+		data.SetColor(rs.NextColor())
+	}
+	return rs.Super().Create(data)
+}
+
+// ForModel returns the active tags that can be set on records of the
+// given model, that is the tags of this model and the shared tags.
+func tag_ForModel(rs m.TagSet, modelName string) m.TagSet {
+	return h.Tag().Search(rs.Env(), q.Tag().Model().Equals(modelName).Or().Model().IsNull())
+}
+
+// FindOrCreate returns the tag with the given name that can be set on
+// records of the given model. If no such tag exists, it is created for
+// this model only.
+func tag_FindOrCreate(rs m.TagSet, modelName, name string) m.TagSet {
+	tag := rs.ForModel(modelName).Search(q.Tag().Name().Equals(name)).Limit(1)
+	if tag.IsNotEmpty() {
+		return tag
+	}
+	return h.Tag().Create(rs.Env(), h.Tag().NewData().
+		SetName(name).
+		SetModel(modelName))
+}
+
+var fields_TagLink = map[string]models.FieldDefinition{
+	"Tag": fields.Many2One{RelationModel: h.Tag(), Required: true, OnDelete: models.Cascade, Index: true,
+		Constraint: h.TagLink().Methods().CheckTagModel()},
+	"ResModel": fields.Char{String: "Resource Model", Required: true, Index: true,
+		Constraint: h.TagLink().Methods().CheckTagModel()},
+	"ResID": fields.Integer{String: "Resource ID", Required: true, Index: true},
+}
+
+// CheckTagModel checks that the tag of the links can be set on their model
+func tagLink_CheckTagModel(rs m.TagLinkSet) {
+	for _, link := range rs.Records() {
+		if link.Tag().Model() != "" && link.Tag().Model() != link.ResModel() {
+			log.Panic(rs.T("Tag '%s' cannot be set on records of model '%s'.", link.Tag().Name(), link.ResModel()))
+		}
+	}
+}
+
+var fields_TagMixin = map[string]models.FieldDefinition{
+	"Tags": fields.Many2Many{RelationModel: h.Tag(),
+		Compute: h.TagMixin().Methods().ComputeTags(),
+		Inverse: h.TagMixin().Methods().InverseTags()},
+}
+
+// tagLinksCondition returns the condition on TagLink for the links of the given records
+func tagLinksCondition(rs m.TagMixinSet) q.TagLinkCondition {
+	return q.TagLink().ResModel().Equals(rs.ModelName()).And().ResID().In(rs.Ids())
+}
+
+// ComputeTags returns the tags of this record from the tag links table
+func tagMixin_ComputeTags(rs m.TagMixinSet) m.TagMixinData {
+	var ids []int64
+	rs.Env().Cr().Select(&ids, `SELECT tag_id FROM tag_link WHERE res_model = ? AND res_id = ?`, rs.ModelName(), rs.ID())
+	return h.TagMixin().NewData().SetTags(h.Tag().Browse(rs.Env(), ids))
+}
+
+// InverseTags replaces the tags of these records by the given tags
+func tagMixin_InverseTags(rs m.TagMixinSet, tags m.TagSet) {
+	h.TagLink().Search(rs.Env(), tagLinksCondition(rs).And().Tag().NotIn(tags)).Unlink()
+	rs.AddTags(tags)
+}
+
+// AddTags adds the given tags to these records
+func tagMixin_AddTags(rs m.TagMixinSet, tags m.TagSet) {
+	for _, rec := range rs.Records() {
+		existing := h.TagLink().Search(rs.Env(), tagLinksCondition(rec))
+		for _, tag := range tags.Records() {
+			if existing.Search(q.TagLink().Tag().Equals(tag)).IsNotEmpty() {
+				continue
+			}
+			h.TagLink().Create(rs.Env(), h.TagLink().NewData().
+				SetTag(tag).
+				SetResModel(rec.ModelName()).
+				SetResID(rec.ID()))
+		}
+	}
+}
+
+// AddTagsByName adds the tags with the given names to these records,
+// creating the missing tags for this model.
+func tagMixin_AddTagsByName(rs m.TagMixinSet, names ...string) {
+	tags := h.Tag().NewSet(rs.Env())
+	for _, name := range names {
+		tags = tags.Union(h.Tag().NewSet(rs.Env()).FindOrCreate(rs.ModelName(), name))
+	}
+	rs.AddTags(tags)
+}
+
+// RemoveTags removes the given tags from these records
+func tagMixin_RemoveTags(rs m.TagMixinSet, tags m.TagSet) {
+	h.TagLink().Search(rs.Env(), tagLinksCondition(rs).And().Tag().In(tags)).Unlink()
+}
+
+// SearchByTags returns the records of this model that have at least one of the given tags
+func tagMixin_SearchByTags(rs m.TagMixinSet, tags m.TagSet) m.TagMixinSet {
+	var ids []int64
+	links := h.TagLink().Search(rs.Env(), q.TagLink().ResModel().Equals(rs.ModelName()).And().Tag().In(tags))
+	for _, link := range links.Records() {
+		ids = append(ids, link.ResID())
+	}
+	model := models.Registry.MustGet(rs.ModelName())
+	return rs.Search(q.TagMixinCondition{Condition: model.Field(models.ID).In(ids)})
+}
+
+// tagMixin_Unlink removes the tag links of the deleted records, since they
+// are not bound to them by a foreign key.
+func tagMixin_Unlink(rs m.TagMixinSet) int64 {
+	if rs.IsNotEmpty() {
+		h.TagLink().NewSet(rs.Env()).AsSuperUser("delete tag links").Search(tagLinksCondition(rs)).Unlink()
+	}
+	return rs.Super().Unlink()
+}
+
+// tagMixin_Copy copies the tags of the original record on the new one
+func tagMixin_Copy(rs m.TagMixinSet, overrides m.TagMixinData) m.TagMixinSet {
+	res := rs.Super().Copy(overrides)
+	if !overrides.HasTags() {
+		res.AddTags(rs.Tags())
+	}
+	return res
+}
+
+func init() {
+	models.NewModel("Tag")
+	h.Tag().SetDefaultOrder("Name")
+	h.Tag().AddFields(fields_Tag)
+	h.Tag().InheritModel(h.ColorMixin())
+	h.Tag().AddSQLConstraint("name_model_uniq", "unique(name, model)", "A tag with the same name already exists for this model!")
+	h.Tag().NewMethod("CheckModel", tag_CheckModel)
+	h.Tag().Methods().Create().Extend(tag_Create)
+	h.Tag().NewMethod("ForModel", tag_ForModel)
+	h.Tag().NewMethod("FindOrCreate", tag_FindOrCreate)
+
+	models.NewModel("TagLink")
+	h.TagLink().AddFields(fields_TagLink)
+	h.TagLink().AddSQLConstraint("tag_res_uniq", "unique(tag_id, res_model, res_id)", "This record already has this tag!")
+	h.TagLink().NewMethod("CheckTagModel", tagLink_CheckTagModel)
+
+	models.NewMixinModel("TagMixin")
+	h.TagMixin().AddFields(fields_TagMixin)
+	h.TagMixin().NewMethod("ComputeTags", tagMixin_ComputeTags)
+	h.TagMixin().NewMethod("InverseTags", tagMixin_InverseTags)
+	h.TagMixin().NewMethod("AddTags", tagMixin_AddTags)
+	h.TagMixin().NewMethod("AddTagsByName", tagMixin_AddTagsByName)
+	h.TagMixin().NewMethod("RemoveTags", tagMixin_RemoveTags)
+	h.TagMixin().NewMethod("SearchByTags", tagMixin_SearchByTags)
+	h.TagMixin().Methods().Unlink().Extend(tagMixin_Unlink)
+	h.TagMixin().Methods().Copy().Extend(tagMixin_Copy)
+}
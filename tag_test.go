@@ -0,0 +1,63 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTagMixin(t *testing.T) {
+	Convey("Testing tags mixin", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			datas := base64.StdEncoding.EncodeToString([]byte("tagged document"))
+			doc1 := h.Attachment().Create(env, h.Attachment().NewData().SetName("doc1").SetDatas(datas))
+			doc2 := h.Attachment().Create(env, h.Attachment().NewData().SetName("doc2").SetDatas(datas))
+			shared := h.Tag().Create(env, h.Tag().NewData().SetName("Important"))
+			Convey("New tags get a color", func() {
+				So(shared.Color(), ShouldBeGreaterThan, 0)
+			})
+			Convey("Tags can be added, read and removed", func() {
+				doc1.AddTags(shared)
+				doc1.AddTagsByName("Invoices", "Important")
+				So(doc1.Tags().Len(), ShouldEqual, 2)
+				invoices := h.Tag().Search(env, q.Tag().Name().Equals("Invoices"))
+				So(invoices.Model(), ShouldEqual, "Attachment")
+				doc1.RemoveTags(shared)
+				So(doc1.Tags().Equals(invoices), ShouldBeTrue)
+				So(doc2.Tags().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Tags can be written through the Tags field", func() {
+				doc1.SetTags(shared)
+				So(doc1.Tags().Equals(shared), ShouldBeTrue)
+				doc1.SetTags(h.Tag().NewSet(env))
+				So(doc1.Tags().IsEmpty(), ShouldBeTrue)
+			})
+			Convey("Records can be searched by tag", func() {
+				doc2.AddTags(shared)
+				So(h.Attachment().NewSet(env).SearchByTags(shared).Equals(doc2), ShouldBeTrue)
+			})
+			Convey("Model specific tags cannot be set on other models", func() {
+				attachmentTag := h.Tag().Create(env, h.Tag().NewData().SetName("Scanned").SetModel("Attachment"))
+				So(func() {
+					h.TagLink().Create(env, h.TagLink().NewData().SetTag(attachmentTag).SetResModel("Partner").SetResID(1))
+				}, ShouldPanic)
+				So(func() {
+					h.Tag().Create(env, h.Tag().NewData().SetName("Partner Tag").SetModel("Partner"))
+				}, ShouldPanic)
+			})
+			Convey("Tag links are removed with their records", func() {
+				doc1.AddTags(shared)
+				doc1.Unlink()
+				So(h.TagLink().Search(env, q.TagLink().Tag().Equals(shared)).IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
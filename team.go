@@ -0,0 +1,119 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+)
+
+var fields_Team = map[string]models.FieldDefinition{
+	"Name":   fields.Char{Required: true, Translate: true},
+	"Leader": fields.Many2One{String: "Team Leader", RelationModel: h.User(), OnDelete: models.SetNull},
+	"Members": fields.Many2Many{RelationModel: h.User(), JSON: "member_ids",
+		Help: "New contacts matching the assignment rules of this team are assigned to its members in turn"},
+	"LastAssigned": fields.Many2One{String: "Last Assigned Member", RelationModel: h.User(),
+		OnDelete: models.SetNull, ReadOnly: true, NoCopy: true},
+	"AssignmentRules": fields.One2Many{RelationModel: h.TeamAssignmentRule(), ReverseFK: "Team",
+		JSON: "assignment_rule_ids", Copy: true},
+	"Company": fields.Many2One{RelationModel: h.Company(),
+		Default: func(env models.Environment) interface{} {
+			return h.User().NewSet(env).CurrentUser().Company()
+		}},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+var fields_TeamAssignmentRule = map[string]models.FieldDefinition{
+	"Team": fields.Many2One{RelationModel: h.Team(), Required: true, OnDelete: models.Cascade,
+		Index: true},
+	"Sequence": fields.Integer{Default: models.DefaultValue(10)},
+	"Countries": fields.Many2Many{RelationModel: h.Country(), JSON: "country_ids",
+		Help: "Contacts of these countries match this rule. Leave empty to match all countries."},
+	"Categories": fields.Many2Many{String: "Tags", RelationModel: h.PartnerCategory(), JSON: "category_ids",
+		Help: "Contacts with at least one of these tags match this rule. Leave empty to match all tags."},
+}
+
+// Matches returns true if the given partner matches this rule
+func teamAssignmentRule_Matches(rs m.TeamAssignmentRuleSet, partner m.PartnerSet) bool {
+	rs.EnsureOne()
+	if rs.Countries().IsNotEmpty() && rs.Countries().Intersect(partner.Country()).IsEmpty() {
+		return false
+	}
+	if rs.Categories().IsNotEmpty() && rs.Categories().Intersect(partner.Categories()).IsEmpty() {
+		return false
+	}
+	return true
+}
+
+// NextMember returns the member of this team following the last assigned one,
+// and records it as the last assigned member. It returns an empty UserSet if
+// the team has no members.
+func team_NextMember(rs m.TeamSet) m.UserSet {
+	rs.EnsureOne()
+	rs.Env().Cr().Execute(`SELECT id FROM team WHERE id=? FOR UPDATE`, rs.ID())
+	members := rs.Members().OrderBy("ID").Records()
+	if len(members) == 0 {
+		return h.User().NewSet(rs.Env())
+	}
+	next := members[0]
+	for _, member := range members {
+		if member.ID() > rs.LastAssigned().ID() {
+			next = member
+			break
+		}
+	}
+	rs.SetLastAssigned(next)
+	return next
+}
+
+// FindTeam returns the team of the first active assignment rule matching the
+// given partner for the current company, or an empty TeamSet.
+func teamAssignmentRule_FindTeam(rs m.TeamAssignmentRuleSet, partner m.PartnerSet) m.TeamSet {
+	company := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	for _, rule := range h.TeamAssignmentRule().NewSet(rs.Env()).AsSuperUser("read team assignment rules").SearchAll().Records() {
+		team := rule.Team()
+		if !team.Active() || (team.Company().IsNotEmpty() && team.Company().ID() != company.ID()) {
+			continue
+		}
+		if rule.Matches(partner) {
+			return team
+		}
+	}
+	return h.Team().NewSet(rs.Env())
+}
+
+// ApplyAssignmentRules assigns the partners of this set without salesperson to
+// the team of the first matching assignment rule, and to the next member of
+// this team. Contacts of a company are left alone, as they follow their company.
+func partner_ApplyAssignmentRules(rs m.PartnerSet) {
+	for _, partner := range rs.Records() {
+		if partner.User().IsNotEmpty() || partner.Parent().IsNotEmpty() {
+			continue
+		}
+		team := h.TeamAssignmentRule().NewSet(rs.Env()).FindTeam(partner)
+		if team.IsEmpty() {
+			continue
+		}
+		partner.AsSuperUser("assign partner to team").Write(h.Partner().NewData().
+			SetTeam(team).
+			SetUser(team.AsSuperUser("assign partner to team").NextMember()))
+	}
+}
+
+func init() {
+	models.NewModel("Team")
+	h.Team().SetDefaultOrder("Name")
+	h.Team().AddFields(fields_Team)
+	h.Team().NewMethod("NextMember", team_NextMember)
+
+	models.NewModel("TeamAssignmentRule")
+	h.TeamAssignmentRule().SetDefaultOrder("Sequence", "ID")
+	h.TeamAssignmentRule().AddFields(fields_TeamAssignmentRule)
+	h.TeamAssignmentRule().NewMethod("Matches", teamAssignmentRule_Matches)
+	h.TeamAssignmentRule().NewMethod("FindTeam", teamAssignmentRule_FindTeam)
+
+	h.Partner().NewMethod("ApplyAssignmentRules", partner_ApplyAssignmentRules)
+}
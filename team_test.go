@@ -0,0 +1,80 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/q"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTeamAssignment(t *testing.T) {
+	Convey("Testing team assignment rules", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			first := h.User().Create(env, h.User().NewData().
+				SetName("First Salesperson").
+				SetLogin("team_first"))
+			second := h.User().Create(env, h.User().NewData().
+				SetName("Second Salesperson").
+				SetLogin("team_second"))
+			france := h.Country().Search(env, q.Country().Code().Equals("FR"))
+			belgium := h.Country().Search(env, q.Country().Code().Equals("BE"))
+			vip := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("VIP"))
+			vipTeam := h.Team().Create(env, h.Team().NewData().
+				SetName("VIP Team").
+				SetMembers(second))
+			h.TeamAssignmentRule().Create(env, h.TeamAssignmentRule().NewData().
+				SetTeam(vipTeam).
+				SetSequence(1).
+				SetCategories(vip))
+			frTeam := h.Team().Create(env, h.Team().NewData().
+				SetName("France Team").
+				SetMembers(first.Union(second)))
+			h.TeamAssignmentRule().Create(env, h.TeamAssignmentRule().NewData().
+				SetTeam(frTeam).
+				SetSequence(2).
+				SetCountries(france))
+			Convey("New contacts are assigned round-robin among the members of the matching team", func() {
+				p1 := h.Partner().Create(env, h.Partner().NewData().SetName("Partner 1").SetCountry(france))
+				p2 := h.Partner().Create(env, h.Partner().NewData().SetName("Partner 2").SetCountry(france))
+				p3 := h.Partner().Create(env, h.Partner().NewData().SetName("Partner 3").SetCountry(france))
+				So(p1.Team().Equals(frTeam), ShouldBeTrue)
+				So(p1.User().Equals(first), ShouldBeTrue)
+				So(p2.User().Equals(second), ShouldBeTrue)
+				So(p3.User().Equals(first), ShouldBeTrue)
+			})
+			Convey("Rules are applied in sequence order", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().
+					SetName("VIP Partner").
+					SetCountry(france).
+					SetCategories(vip))
+				So(partner.Team().Equals(vipTeam), ShouldBeTrue)
+				So(partner.User().Equals(second), ShouldBeTrue)
+			})
+			Convey("Contacts matching no rule or with a salesperson are not assigned", func() {
+				partner := h.Partner().Create(env, h.Partner().NewData().SetName("Belgian").SetCountry(belgium))
+				So(partner.Team().IsEmpty(), ShouldBeTrue)
+				So(partner.User().IsEmpty(), ShouldBeTrue)
+				partner = h.Partner().Create(env, h.Partner().NewData().
+					SetName("Owned").
+					SetCountry(france).
+					SetUser(second))
+				So(partner.Team().IsEmpty(), ShouldBeTrue)
+				So(partner.User().Equals(second), ShouldBeTrue)
+			})
+			Convey("Partners of new users are not assigned", func() {
+				user := h.User().Create(env, h.User().NewData().
+					SetName("French User").
+					SetLogin("team_french").
+					SetCountry(france))
+				So(user.Partner().Team().IsEmpty(), ShouldBeTrue)
+				So(user.Partner().User().IsEmpty(), ShouldBeTrue)
+			})
+		}), ShouldBeNil)
+	})
+}
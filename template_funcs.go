@@ -0,0 +1,90 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"strings"
+	"sync"
+	"text/template"
+	"unicode/utf8"
+)
+
+// templateFuncs holds the functions available in address and report format templates
+var templateFuncs = struct {
+	sync.RWMutex
+	funcs template.FuncMap
+}{
+	funcs: template.FuncMap{
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"title": strings.Title,
+		"trim":  strings.TrimSpace,
+		// truncate returns the n first characters of s
+		"truncate": func(n int, s string) string {
+			if utf8.RuneCountInString(s) <= n {
+				return s
+			}
+			return string([]rune(s)[:n])
+		},
+		// stateOrCountry returns the state if it is set or the country otherwise
+		"stateOrCountry": func(state, country string) string {
+			if state != "" {
+				return state
+			}
+			return country
+		},
+		// prefix returns p followed by s if s is not empty, and an empty string otherwise
+		"prefix": func(p, s string) string {
+			if s == "" {
+				return ""
+			}
+			return p + s
+		},
+		// join joins the non empty given parts with sep
+		"join": func(sep string, parts ...string) string {
+			var res []string
+			for _, p := range parts {
+				if strings.TrimSpace(p) != "" {
+					res = append(res, p)
+				}
+			}
+			return strings.Join(res, sep)
+		},
+	},
+}
+
+// RegisterTemplateFunc registers the given function under the given name so
+// that it can be used in address and report format templates. fnct must follow
+// the text/template FuncMap rules. It panics if a function is already registered
+// with this name.
+func RegisterTemplateFunc(name string, fnct interface{}) {
+	templateFuncs.Lock()
+	defer templateFuncs.Unlock()
+	if _, exists := templateFuncs.funcs[name]; exists {
+		log.Panic("Template function already registered", "name", name)
+	}
+	templateFuncs.funcs[name] = fnct
+}
+
+// TemplateFuncs returns a copy of the registered template functions
+func TemplateFuncs() template.FuncMap {
+	templateFuncs.RLock()
+	defer templateFuncs.RUnlock()
+	res := make(template.FuncMap, len(templateFuncs.funcs))
+	for name, fnct := range templateFuncs.funcs {
+		res[name] = fnct
+	}
+	return res
+}
+
+// RemoveBlankLines removes the empty or whitespace only lines of s
+func RemoveBlankLines(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
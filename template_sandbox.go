@@ -0,0 +1,428 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"reflect"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// safeTemplateBuiltins are the text/template builtin functions allowed in sandboxed templates.
+// The 'call' builtin is not part of it, so that templates cannot call arbitrary functions.
+var safeTemplateBuiltins = map[string]bool{
+	"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true,
+	"eq": true, "ne": true, "lt": true, "le": true, "gt": true, "ge": true,
+	"print": true, "printf": true, "println": true, "html": true, "js": true, "urlquery": true,
+}
+
+// ErrTemplateTimeout is returned when the execution of a sandboxed template takes too long
+var ErrTemplateTimeout = errors.New("template execution timed out")
+
+// ErrTemplateOutputTooLarge is returned when a sandboxed template output exceeds the sandbox limit
+var ErrTemplateOutputTooLarge = errors.New("template output is too large")
+
+// maxTemplateDataDepth is the maximum nesting depth of the data given to
+// sandboxed templates.
+const maxTemplateDataDepth = 32
+
+// A TemplateSandbox executes user-editable templates (address formats, mail
+// or report templates) safely: only whitelisted functions can be used, the
+// data is restricted to plain values, and execution time and output size are
+// limited. Errors are returned instead of panicking, so that they can be
+// reported to the user.
+type TemplateSandbox struct {
+	// Timeout is the maximum execution time of a template. Zero means no limit.
+	Timeout time.Duration
+	// MaxOutputSize is the maximum size in bytes of the output. Zero means no limit.
+	MaxOutputSize int
+	// AllowedFuncs is the whitelist of registered template functions (see
+	// RegisterTemplateFunc) that can be used. If nil, all registered functions are allowed.
+	AllowedFuncs []string
+}
+
+// DefaultTemplateSandbox is the sandbox used to render address formats
+var DefaultTemplateSandbox = TemplateSandbox{
+	Timeout:       time.Second,
+	MaxOutputSize: 64 * 1024,
+}
+
+// funcs returns the template functions allowed in this sandbox. If state is
+// not nil, the functions fail once the execution of state is cancelled.
+func (s TemplateSandbox) funcs(state *sandboxState) template.FuncMap {
+	all := TemplateFuncs()
+	res := all
+	if s.AllowedFuncs != nil {
+		res = make(template.FuncMap)
+		for _, name := range s.AllowedFuncs {
+			if fnct, ok := all[name]; ok {
+				res[name] = fnct
+			}
+		}
+	}
+	if state != nil {
+		for name, fnct := range res {
+			res[name] = state.wrap(fnct)
+		}
+	}
+	return res
+}
+
+// Parse parses the given template source and checks that it
+// only uses functions allowed in this sandbox.
+func (s TemplateSandbox) Parse(source string) (*template.Template, error) {
+	return s.parse(source, nil)
+}
+
+// parse parses the given template source with the functions of this sandbox
+// bound to the given execution state.
+func (s TemplateSandbox) parse(source string, state *sandboxState) (tmpl *template.Template, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to parse template: %v", r)
+		}
+	}()
+	funcs := s.funcs(state)
+	tmpl, err = template.New("").Funcs(funcs).Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if err = checkTemplateNode(t.Tree.Root, funcs); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
+
+// ParseHTML parses the given template source like Parse, but returns an
+// html/template template, whose output is escaped according to its HTML
+// context. It must be used for templates rendering HTML, such as email
+// bodies, so that the values given to the template cannot inject markup.
+func (s TemplateSandbox) ParseHTML(source string) (*htmltemplate.Template, error) {
+	return s.parseHTML(source, nil)
+}
+
+// parseHTML parses the given HTML template source with the functions of this
+// sandbox bound to the given execution state.
+func (s TemplateSandbox) parseHTML(source string, state *sandboxState) (tmpl *htmltemplate.Template, err error) {
+	if _, err = s.parse(source, nil); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to parse template: %v", r)
+		}
+	}()
+	return htmltemplate.New("").Funcs(htmltemplate.FuncMap(s.funcs(state))).Parse(source)
+}
+
+// checkTemplateNode returns an error if the given node or its
+// children call a function that is not in funcs or in safeTemplateBuiltins.
+func checkTemplateNode(node parse.Node, funcs template.FuncMap) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkTemplateNode(child, funcs); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkTemplateNode(n.Pipe, funcs)
+	case *parse.IfNode:
+		return checkTemplateBranch(&n.BranchNode, funcs)
+	case *parse.RangeNode:
+		// Ranging over a number literal would loop without being bounded by the data
+		if cmds := n.Pipe.Cmds; len(cmds) == 1 && len(cmds[0].Args) == 1 {
+			if _, ok := cmds[0].Args[0].(*parse.NumberNode); ok {
+				return errors.New("range over a number is not allowed")
+			}
+		}
+		return checkTemplateBranch(&n.BranchNode, funcs)
+	case *parse.WithNode:
+		return checkTemplateBranch(&n.BranchNode, funcs)
+	case *parse.TemplateNode:
+		return checkTemplateNode(n.Pipe, funcs)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := checkTemplateNode(cmd, funcs); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := checkTemplateNode(arg, funcs); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return checkTemplateNode(n.Node, funcs)
+	case *parse.IdentifierNode:
+		if _, ok := funcs[n.Ident]; !ok && !safeTemplateBuiltins[n.Ident] {
+			return fmt.Errorf("function '%s' is not allowed", n.Ident)
+		}
+	}
+	return nil
+}
+
+// checkTemplateBranch checks the nodes of an if, range or with node
+func checkTemplateBranch(n *parse.BranchNode, funcs template.FuncMap) error {
+	for _, child := range []parse.Node{n.Pipe, n.List, n.ElseList} {
+		if err := checkTemplateNode(child, funcs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sandboxState is the state of the execution of a sandboxed template, which
+// is shared with the writer and the functions of the template, so that they
+// fail as soon as the execution is cancelled.
+type sandboxState struct {
+	cancelled int32
+	err       atomic.Value
+}
+
+// cancel makes the writer and the functions of the execution fail with err
+func (st *sandboxState) cancel(err error) {
+	st.err.Store(err)
+	atomic.StoreInt32(&st.cancelled, 1)
+}
+
+// check returns the cancellation error of the execution, if any
+func (st *sandboxState) check() error {
+	if atomic.LoadInt32(&st.cancelled) == 0 {
+		return nil
+	}
+	return st.err.Load().(error)
+}
+
+// wrap returns the given template function that panics before being
+// called if the execution is cancelled.
+func (st *sandboxState) wrap(fnct interface{}) interface{} {
+	fv := reflect.ValueOf(fnct)
+	if fv.Kind() != reflect.Func {
+		return fnct
+	}
+	return reflect.MakeFunc(fv.Type(), func(args []reflect.Value) []reflect.Value {
+		if err := st.check(); err != nil {
+			panic(err)
+		}
+		if fv.Type().IsVariadic() {
+			return fv.CallSlice(args)
+		}
+		return fv.Call(args)
+	}).Interface()
+}
+
+// sandboxWriter is a buffer that fails when the sandbox limits are reached
+type sandboxWriter struct {
+	buf     bytes.Buffer
+	maxSize int
+	state   *sandboxState
+}
+
+// Write the given bytes to the buffer
+func (w *sandboxWriter) Write(p []byte) (int, error) {
+	if err := w.state.check(); err != nil {
+		return 0, err
+	}
+	if w.maxSize > 0 && w.buf.Len()+len(p) > w.maxSize {
+		return 0, ErrTemplateOutputTooLarge
+	}
+	return w.buf.Write(p)
+}
+
+// An executableTemplate is a parsed text/template or html/template template
+type executableTemplate interface {
+	Execute(io.Writer, interface{}) error
+}
+
+// plainTemplateData returns a copy of data made only of plain values (maps
+// with string keys, slices and basic values), so that templates cannot call
+// the methods of the given data. Structs are turned into maps of their
+// exported fields and values implementing fmt.Stringer into strings.
+func plainTemplateData(data interface{}) (interface{}, error) {
+	return plainTemplateValue(reflect.ValueOf(data), 0)
+}
+
+// plainTemplateValue returns the plain value of v (see plainTemplateData)
+func plainTemplateValue(v reflect.Value, depth int) (interface{}, error) {
+	if depth > maxTemplateDataDepth {
+		return nil, errors.New("template data is too deeply nested")
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, nil
+	}
+	if v.CanInterface() {
+		if stringer, ok := v.Interface().(fmt.Stringer); ok {
+			return stringer.String(), nil
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return plainTemplateValue(v.Elem(), depth+1)
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return nil, errors.New("binary template data is not allowed")
+		}
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			val, err := plainTemplateValue(v.Index(i), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = val
+		}
+		return res, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("template data maps must have string keys, not %s", v.Type().Key())
+		}
+		res := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			val, err := plainTemplateValue(iter.Value(), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			res[iter.Key().String()] = val
+		}
+		return res, nil
+	case reflect.Struct:
+		res := make(map[string]interface{}, v.NumField())
+		if err := addPlainStructFields(res, v, depth); err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("template data of type %s is not allowed", v.Type())
+}
+
+// addPlainStructFields adds to res the plain values of the exported fields of
+// the struct v. Fields of embedded structs are promoted, as in templates.
+func addPlainStructFields(res map[string]interface{}, v reflect.Value, depth int) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := addPlainStructFields(res, v.Field(i), depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		val, err := plainTemplateValue(v.Field(i), depth+1)
+		if err != nil {
+			return err
+		}
+		res[field.Name] = val
+	}
+	return nil
+}
+
+// Execute parses and executes the given template source with the given
+// data and returns the result.
+func (s TemplateSandbox) Execute(source string, data interface{}) (string, error) {
+	return s.ExecuteContext(context.Background(), source, data)
+}
+
+// ExecuteContext is like Execute, but the execution is stopped as soon as
+// the given context is done.
+func (s TemplateSandbox) ExecuteContext(ctx context.Context, source string, data interface{}) (string, error) {
+	state := new(sandboxState)
+	tmpl, err := s.parse(source, state)
+	if err != nil {
+		return "", err
+	}
+	return s.execute(ctx, tmpl, state, data)
+}
+
+// ExecuteHTML parses and executes the given HTML template source with the
+// given data and returns the result, in which the values of data are escaped.
+func (s TemplateSandbox) ExecuteHTML(source string, data interface{}) (string, error) {
+	return s.ExecuteHTMLContext(context.Background(), source, data)
+}
+
+// ExecuteHTMLContext is like ExecuteHTML, but the execution is stopped as
+// soon as the given context is done.
+func (s TemplateSandbox) ExecuteHTMLContext(ctx context.Context, source string, data interface{}) (string, error) {
+	state := new(sandboxState)
+	tmpl, err := s.parseHTML(source, state)
+	if err != nil {
+		return "", err
+	}
+	return s.execute(ctx, tmpl, state, data)
+}
+
+// execute executes the given parsed template with the given data within
+// the limits of this sandbox and returns the result. When the sandbox
+// timeout is reached or ctx is done, the execution is cancelled: the
+// template fails at its next write or function call.
+func (s TemplateSandbox) execute(ctx context.Context, tmpl executableTemplate, state *sandboxState, data interface{}) (string, error) {
+	plainData, err := plainTemplateData(data)
+	if err != nil {
+		return "", err
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	writer := sandboxWriter{maxSize: s.MaxOutputSize, state: state}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("error while executing template: %v", r)
+			}
+		}()
+		done <- tmpl.Execute(&writer, plainData)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		cancelErr := ctx.Err()
+		if cancelErr == context.DeadlineExceeded {
+			cancelErr = ErrTemplateTimeout
+		}
+		state.cancel(cancelErr)
+		return "", cancelErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return writer.buf.String(), nil
+}
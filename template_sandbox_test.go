@@ -0,0 +1,98 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// sandboxTestData is template data with a method
+type sandboxTestData struct {
+	Name string
+}
+
+// Secret must not be callable from sandboxed templates
+func (sandboxTestData) Secret() string {
+	return "secret"
+}
+
+func TestTemplateSandbox(t *testing.T) {
+	Convey("Testing the template sandbox", t, func() {
+		sandbox := TemplateSandbox{
+			Timeout:       200 * time.Millisecond,
+			MaxOutputSize: 100,
+			AllowedFuncs:  []string{"upper"},
+		}
+		Convey("Whitelisted functions and safe builtins can be used", func() {
+			out, err := sandbox.Execute(`{{ upper .Name }} {{ printf "%03d" 7 }}`, map[string]string{"Name": "hexya"})
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "HEXYA 007")
+		})
+		Convey("Other functions are rejected", func() {
+			_, err := sandbox.Execute(`{{ lower .Name }}`, nil)
+			So(err, ShouldNotBeNil)
+			_, err = sandbox.Execute(`{{ if true }}{{ call .Func }}{{ end }}`, nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Output size is limited", func() {
+			_, err := sandbox.Execute(`{{ range .L }}0123456789{{ end }}`, map[string][]int{"L": make([]int, 20)})
+			So(err, ShouldEqual, ErrTemplateOutputTooLarge)
+		})
+		Convey("Execution time is limited", func() {
+			sandbox.MaxOutputSize = 0
+			_, err := sandbox.Execute(`{{ range .L }}{{ range $.L }}{{ range $.L }}x{{ end }}{{ end }}{{ end }}`,
+				map[string][]int{"L": make([]int, 2000)})
+			So(err, ShouldEqual, ErrTemplateTimeout)
+		})
+		Convey("Rendering can be cancelled", func() {
+			sandbox.Timeout = 0
+			sandbox.MaxOutputSize = 0
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := sandbox.ExecuteContext(ctx, `{{ range .L }}{{ range $.L }}{{ range $.L }}x{{ end }}{{ end }}{{ end }}`,
+				map[string][]int{"L": make([]int, 2000)})
+			So(err, ShouldEqual, context.Canceled)
+		})
+		Convey("Ranging over a number is rejected", func() {
+			_, err := sandbox.Execute(`{{ range 1000000000 }}x{{ end }}`, nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Data is restricted to plain values", func() {
+			out, err := sandbox.Execute(`{{ .Name }}{{ .Secret }}`, sandboxTestData{Name: "hexya"})
+			So(err, ShouldBeNil)
+			So(out, ShouldStartWith, "hexya")
+			So(out, ShouldNotContainSubstring, "secret")
+			_, err = sandbox.Execute(`{{ .F }}`, map[string]interface{}{"F": func() string { return "" }})
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Values are escaped in HTML templates", func() {
+			out, err := sandbox.ExecuteHTML(`<p>{{ upper .Name }}</p>`, map[string]string{"Name": "<script>x</script>"})
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "<p>&lt;SCRIPT&gt;X&lt;/SCRIPT&gt;</p>")
+			_, err = sandbox.ExecuteHTML(`{{ lower .Name }}`, nil)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Execution errors are returned", func() {
+			_, err := sandbox.Execute(`{{ .A.B }}`, map[string]int{"A": 1})
+			So(err, ShouldNotBeNil)
+		})
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			Convey("Invalid address formats cannot be saved", func() {
+				So(func() {
+					h.Country().Create(env, h.Country().NewData().
+						SetName("Broken Country").
+						SetCode("XB").
+						SetAddressFormat("{{ .Street "))
+				}, ShouldPanic)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -5,29 +5,140 @@ package base
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/erlangs/okoo/src/actions"
 	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/operator"
 	"github.com/erlangs/okoo/src/models/types"
 	"github.com/erlangs/pool/h"
 	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
 )
 
+// translationModels maps "Model.Field" to the translation model of the
+// translatable fields of the registry. It is built at first use, once the
+// registry is bootstrapped.
+var translationModels struct {
+	sync.Once
+	models map[string]*models.Model
+}
+
+// isTranslationModel returns true if the given model has the fields of a
+// translation model.
+func isTranslationModel(model *models.Model) bool {
+	for _, field := range []string{"record_id", "lang", "value"} {
+		if _, exists := model.Fields().Get(field); !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// translationModel returns the model holding the translations of the given
+// field of the given model, and false if the field is not translated.
+func translationModel(model *models.Model, fieldName models.FieldName) (*models.Model, bool) {
+	translationModels.Do(func() {
+		translationModels.models = make(map[string]*models.Model)
+		for _, mi := range models.Registry.All() {
+			for _, fi := range mi.FieldsGet() {
+				if !fi.Translate {
+					continue
+				}
+				transModel, exists := models.Registry.Get(fmt.Sprintf("%sHexya%s", mi.Name(), fi.Name))
+				if !exists || !isTranslationModel(transModel) {
+					continue
+				}
+				translationModels.models[fmt.Sprintf("%s.%s", mi.Name(), fi.Name)] = transModel
+			}
+		}
+	})
+	transModel, exists := translationModels.models[fmt.Sprintf("%s.%s", model.Name(), fieldName.Name())]
+	return transModel, exists
+}
+
 // TranslateFields opens the translation window for the given field
 func translation_TranslateFields(rs m.TranslationSet, modelName string, id int64, fieldName models.FieldName) *actions.Action {
-	fi := models.Registry.MustGet(modelName).FieldsGet(fieldName)[fieldName.JSON()]
-	model := fmt.Sprintf("%sHexya%s", modelName, fi.Name)
+	transModel, exists := translationModel(models.Registry.MustGet(modelName), fieldName)
+	if !exists {
+		log.Panic(rs.T("Field %s of %s is not translatable", fieldName.Name(), modelName))
+	}
+	model := transModel.Name()
+	cond := transModel.Field(transModel.FieldName("RecordID")).Equals(id)
 	return &actions.Action{
 		Name:     rs.T("Translate"),
 		Type:     actions.ActionActWindow,
 		Model:    model,
 		ViewMode: "list",
-		Domain:   fmt.Sprintf("[('record_id', '=', %d)]", id),
+		Domain:   FormatDomain(models.Domain(cond.Serialize())),
 		Context:  types.NewContext().WithKey("default_record_id", id),
 	}
 }
 
+// SearchTranslatedIds returns the ids of the records of this model for which the
+// translation of the given field in the context language matches op and value.
+//
+// It returns nil if the field is not translatable or if there is no lang in the context.
+func baseMixin_SearchTranslatedIds(rs m.BaseMixinSet, fieldName models.FieldName, op operator.Operator, value string) []int64 {
+	lang := rs.Env().Context().GetString("lang")
+	if lang == "" {
+		return nil
+	}
+	transModel, exists := translationModel(rs.Collection().Model(), fieldName)
+	if !exists {
+		return nil
+	}
+	recordIDField := transModel.FieldName("RecordID")
+	cond := transModel.Field(transModel.FieldName("Lang")).Equals(lang).
+		And().Field(transModel.FieldName("Value")).AddOperator(op, value)
+	var res []int64
+	for _, trans := range rs.Env().Pool(transModel.Name()).Search(cond).Records() {
+		res = append(res, trans.Get(recordIDField).(int64))
+	}
+	return res
+}
+
+// SearchByName also matches records whose translated name in the context
+// language matches, so that users can search records in their own language.
+func baseMixin_SearchByName(rs m.BaseMixinSet, name string, op operator.Operator, additionalCond q.BaseMixinCondition, limit int) m.BaseMixinSet {
+	res := rs.Super().SearchByName(name, op, additionalCond, limit)
+	if name == "" || (limit > 0 && res.Len() >= limit) {
+		return res
+	}
+	if _, exists := rs.Collection().Model().Fields().Get("name"); !exists {
+		return res
+	}
+	model := models.Registry.MustGet(rs.ModelName())
+	found := make(map[int64]bool)
+	for _, id := range res.Ids() {
+		found[id] = true
+	}
+	var ids []int64
+	for _, id := range rs.SearchTranslatedIds(model.FieldName("Name"), op, name) {
+		if !found[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return res
+	}
+	cond := q.BaseMixinCondition{
+		Condition: model.Field(models.ID).In(ids),
+	}
+	if !additionalCond.Underlying().IsEmpty() {
+		cond = cond.AndCond(additionalCond)
+	}
+	translated := rs.Search(cond)
+	if limit > 0 {
+		translated = translated.Limit(limit - res.Len())
+	}
+	return res.Union(translated)
+}
+
 func init() {
 	models.NewModel("Translation")
 	h.Translation().NewMethod("TranslateFields", translation_TranslateFields)
+
+	h.BaseMixin().NewMethod("SearchTranslatedIds", baseMixin_SearchTranslatedIds)
+	h.BaseMixin().Methods().SearchByName().Extend(baseMixin_SearchByName)
 }
@@ -0,0 +1,46 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/operator"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTranslatedSearch(t *testing.T) {
+	Convey("Testing search on translated fields", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			categoryModel := models.Registry.MustGet("PartnerCategory")
+			Convey("Translation models are found in the registry", func() {
+				transModel, exists := translationModel(categoryModel, h.PartnerCategory().Fields().Name())
+				So(exists, ShouldBeTrue)
+				So(isTranslationModel(transModel), ShouldBeTrue)
+				_, exists = translationModel(models.Registry.MustGet("Partner"), h.Partner().Fields().Email())
+				So(exists, ShouldBeFalse)
+			})
+			Convey("Records are found by their translated value", func() {
+				category := h.PartnerCategory().Create(env, h.PartnerCategory().NewData().SetName("Translated Tag"))
+				transModel, _ := translationModel(categoryModel, h.PartnerCategory().Fields().Name())
+				data := models.NewModelDataFromRS(env.Pool(transModel.Name()))
+				data.Set(transModel.FieldName("RecordID"), category.ID())
+				data.Set(transModel.FieldName("Lang"), "fr_FR")
+				data.Set(transModel.FieldName("Value"), "Étiquette traduite")
+				env.Pool(transModel.Name()).Call("Create", data)
+
+				frCategories := h.PartnerCategory().NewSet(env).WithContext("lang", "fr_FR")
+				ids := frCategories.SearchTranslatedIds(h.PartnerCategory().Fields().Name(), operator.IContains, "traduite")
+				So(ids, ShouldContain, category.ID())
+				So(frCategories.SearchTranslatedIds(h.PartnerCategory().Fields().Name(), operator.IContains, "Translated"),
+					ShouldNotContain, category.ID())
+				So(h.PartnerCategory().NewSet(env).WithContext("lang", "").
+					SearchTranslatedIds(h.PartnerCategory().Fields().Name(), operator.IContains, "traduite"), ShouldBeNil)
+			})
+		}), ShouldBeNil)
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/erlangs/okoo/src/actions"
 	"github.com/erlangs/okoo/src/models"
@@ -22,6 +23,7 @@ import (
 	"github.com/erlangs/pool/h"
 	"github.com/erlangs/pool/m"
 	"github.com/erlangs/pool/q"
+	"github.com/google/uuid"
 )
 
 // AuthBackend is the authentication backend of the Base module
@@ -33,6 +35,11 @@ func (bab *AuthBackend) Authenticate(login, secret string, context *types.Contex
 	err = models.ExecuteInNewEnvironment(security.SuperUserID, func(env models.Environment) {
 		uid = h.User().NewSet(env).WithNewContext(context).Authenticate(login, secret)
 	})
+	if err != nil {
+		metricLogins.Inc("failure")
+	} else {
+		metricLogins.Inc("success")
+	}
 	return
 }
 
@@ -106,6 +113,23 @@ a change of password, the user has to login again.`},
 		}, Constraint: h.User().Methods().CheckCompany()},
 	"GroupsCount": fields.Integer{String: "# Groups", Compute: h.User().Methods().ComputeAccessesCount(),
 		Help: "Number of groups that apply to the current user", GoType: new(int)},
+	"CalendarToken": fields.Char{NoCopy: true, Index: true, ReadOnly: true,
+		Help: "Secret token giving access to the user's calendar feed. Reset it to revoke existing subscriptions."},
+	"CalendarFeedURL": fields.Char{String: "Calendar Feed URL", Compute: h.User().Methods().ComputeCalendarFeedURL(),
+		Depends: []string{"CalendarToken"},
+		Help:    "Subscribe to this URL from your calendar application to see your events."},
+	"ActivityReminder": fields.Boolean{String: "Activity Reminders", Default: models.DefaultValue(true),
+		Help: "Receive a daily email listing your activities that are due today or overdue."},
+	"OutOfOfficeFrom": fields.Date{String: "Out of Office From",
+		Constraint: h.User().Methods().CheckOutOfOffice()},
+	"OutOfOfficeTo": fields.Date{String: "Out of Office To",
+		Constraint: h.User().Methods().CheckOutOfOffice()},
+	"OutOfOfficeMessage": fields.Text{String: "Out of Office Message",
+		Help: "Message shown to colleagues who notify you or assign you activities while you are away."},
+	"OutOfOfficeBackup": fields.Many2One{String: "Backup", RelationModel: h.User(), OnDelete: models.SetNull,
+		Help: "Activities assigned to you while you are away are redirected to this user."},
+	"IsOutOfOffice": fields.Boolean{String: "Out of Office", Compute: h.User().Methods().ComputeIsOutOfOffice(),
+		Depends: []string{"OutOfOfficeFrom", "OutOfOfficeTo"}},
 }
 
 // SelfReadableFields returns the list of its own fields that a user can read.
@@ -113,7 +137,9 @@ func user_SelfReadableFields(_ m.UserSet) map[string]bool {
 	return map[string]bool{
 		"Signature": true, "Company": true, "Login": true, "Email": true, "Name": true, "Image": true,
 		"ImageMedium": true, "ImageSmall": true, "Lang": true, "TZ": true, "TZOffset": true, "Groups": true,
-		"Partner": true, "LastUpdate": true, "ActionID": true,
+		"Partner": true, "LastUpdate": true, "ActionID": true, "CalendarToken": true, "CalendarFeedURL": true,
+		"ActivityReminder": true, "OutOfOfficeFrom": true, "OutOfOfficeTo": true, "OutOfOfficeMessage": true,
+		"OutOfOfficeBackup": true, "IsOutOfOffice": true,
 	}
 }
 
@@ -122,6 +148,8 @@ func user_SelfWritableFields(_ m.UserSet) map[string]bool {
 	return map[string]bool{
 		"Signature": true, "ActionID": true, "Company": true, "Email": true, "Name": true,
 		"Image": true, "ImageMedium": true, "ImageSmall": true, "Lang": true, "TZ": true,
+		"ActivityReminder": true, "OutOfOfficeFrom": true, "OutOfOfficeTo": true, "OutOfOfficeMessage": true,
+		"OutOfOfficeBackup": true,
 	}
 }
 
@@ -306,7 +334,7 @@ func user_Read(rs m.UserSet, fields models.FieldNames) []models.RecordData {
 			}
 		}
 		if !hasUnsafeFields {
-			rSet = rs.Sudo()
+			rSet = rs.AsSuperUser("read own user safe fields")
 		}
 	}
 	result := rSet.Super().Read(fields)
@@ -331,7 +359,17 @@ func user_Search(rs m.UserSet, cond q.UserCondition) m.UserSet {
 }
 
 func user_Create(rs m.UserSet, vals m.UserData) m.UserSet {
+	if vals.HasCalendarToken() && rs.Env().Uid() != security.SuperUserID {
+		// Calendar tokens are only generated by ResetCalendarToken
+		vals.UnsetCalendarToken()
+	}
 	user := rs.Super().Create(vals)
+	if !vals.HasTeam() && user.Partner().Team().IsNotEmpty() {
+		// The partner of a user is not a contact to be followed by a team
+		user.Partner().Write(h.Partner().NewData().
+			SetTeam(h.Team().NewSet(rs.Env())).
+			SetUser(h.User().NewSet(rs.Env())))
+	}
 	user.Partner().SetActive(user.Active())
 	if !user.Partner().Company().IsEmpty() {
 		user.Partner().SetCompany(user.Company())
@@ -340,6 +378,10 @@ func user_Create(rs m.UserSet, vals m.UserData) m.UserSet {
 }
 
 func user_Write(rs m.UserSet, data m.UserData) bool {
+	if data.HasCalendarToken() && rs.Env().Uid() != security.SuperUserID {
+		// Calendar tokens are only generated by ResetCalendarToken
+		data.UnsetCalendarToken()
+	}
 	if data.HasActive() && !data.Active() {
 		for _, user := range rs.Records() {
 			if user.ID() == security.SuperUserID {
@@ -366,7 +408,7 @@ func user_Write(rs m.UserSet, data m.UserData) bool {
 				}
 			}
 			// safe fields only, so we write as super-user to bypass access rights
-			rSet = rs.Sudo()
+			rSet = rs.AsSuperUser("write own user safe fields")
 		}
 	}
 	res := rSet.Super().Write(data)
@@ -532,6 +574,9 @@ func user_HasGroup(rs m.UserSet, groupID string) bool {
 	if userID == 0 {
 		userID = rs.Env().Uid()
 	}
+	if groupMembershipExpired(userID, time.Now()) {
+		h.User().BrowseOne(rs.Env(), userID).AsSuperUser("revoke expired group memberships").SyncMemberships()
+	}
 	group := security.Registry.GetGroup(groupID)
 	return security.Registry.HasMembership(userID, group)
 }
@@ -600,24 +645,36 @@ func user_AddMandatoryGroups(rs m.UserSet) {
 // SyncMemberships synchronises the users memberships with the Hexya internal registry
 func user_SyncMemberships(rs m.UserSet) {
 	for _, user := range rs.Records() {
-		if user.CheckGroupsSync() {
-			continue
+		if !user.CheckGroupsSync() {
+			if debugLogging() {
+				log.Debug("Updating user groups", "user", user.Name(), "uid", user.ID(), "groups", user.Groups().Ids())
+			}
+			// Push memberships to registry
+			security.Registry.RemoveAllMembershipsForUser(user.ID())
+			for _, dbGroup := range user.EffectiveGroups().Records() {
+				security.Registry.AddMembership(user.ID(), security.Registry.GetGroup(dbGroup.GroupID()))
+			}
 		}
-		log.Debug("Updating user groups", "user", rs.Name(), "uid", rs.ID(), "groups", rs.Groups())
-		// Push memberships to registry
-		security.Registry.RemoveAllMembershipsForUser(user.ID())
-		for _, dbGroup := range user.Groups().Records() {
-			security.Registry.AddMembership(user.ID(), security.Registry.GetGroup(dbGroup.GroupID()))
+		// Record the first expiration of temporary memberships for HasGroup
+		// and schedule their revocation
+		var expiration time.Time
+		for _, membership := range user.ActiveGroupMemberships().Records() {
+			if expiration.IsZero() || membership.DateTo().Time.Before(expiration) {
+				expiration = membership.DateTo().Time
+			}
 		}
+		setGroupMembershipExpiration(user.ID(), expiration)
 	}
 }
 
 // CheckGroupSync returns true if the groups in the internal registry match exactly
-// database groups of the given users. This method must be called on a singleton
+// database groups of the given users, including valid temporary memberships.
+// This method must be called on a singleton
 func user_CheckGroupSync(rs m.UserSet) bool {
 	rs.EnsureOne()
+	dbGroups := rs.EffectiveGroups()
 dbLoop:
-	for _, dbGroup := range rs.Groups().Records() {
+	for _, dbGroup := range dbGroups.Records() {
 		for grp := range security.Registry.UserGroups(rs.ID()) {
 			if grp.ID() == dbGroup.GroupID() {
 				continue dbLoop
@@ -627,7 +684,7 @@ dbLoop:
 	}
 rLoop:
 	for grp := range security.Registry.UserGroups(rs.ID()) {
-		for _, dbGroup := range rs.Groups().Records() {
+		for _, dbGroup := range dbGroups.Records() {
 			if grp.ID() == dbGroup.GroupID() {
 				continue rLoop
 			}
@@ -652,6 +709,57 @@ func user_CurrentUser(rs m.UserSet) m.UserSet {
 	return h.User().Browse(rs.Env(), []int64{rs.Env().Uid()})
 }
 
+// ResetCalendarToken generates a new calendar feed token for the users of this
+// recordset, revoking the previous feed URLs. Users can only reset their own
+// token, unless they are administrators.
+func user_ResetCalendarToken(rs m.UserSet) {
+	currentUser := h.User().NewSet(rs.Env()).CurrentUser()
+	for _, user := range rs.Records() {
+		if !user.Equals(currentUser) && !currentUser.IsAdmin() {
+			panic(NewUserError(rs, "You can only reset your own calendar token"))
+		}
+		user.AsSuperUser("reset calendar token").Write(h.User().NewData().SetCalendarToken(uuid.New().String()))
+	}
+}
+
+// ComputeCalendarFeedURL computes the URL of the ICS feed of this user
+func user_ComputeCalendarFeedURL(rs m.UserSet) m.UserData {
+	res := h.User().NewData()
+	if rs.CalendarToken() == "" {
+		return res.SetCalendarFeedURL("")
+	}
+	baseURL := h.ConfigParameter().NewSet(rs.Env()).AsSuperUser("read base URL").GetParam("web.base.url", "")
+	return res.SetCalendarFeedURL(fmt.Sprintf("%s/calendar/feed/%s/calendar.ics", strings.TrimSuffix(baseURL, "/"), rs.CalendarToken()))
+}
+
+// CalendarFeedEvents returns the iCalendar components (e.g. VEVENT or VTODO)
+// to include in this user's calendar feed. Default implementation returns
+// the calendar events organized or attended by this user and the deadlines
+// of the activities assigned to this user.
+//
+// Addons should extend this method to add their own components.
+func user_CalendarFeedEvents(rs m.UserSet) []string {
+	rs.EnsureOne()
+	events := h.CalendarEvent().Search(rs.Env(),
+		q.CalendarEvent().User().Equals(rs).
+			Or().Attendees().Equals(rs.Partner()))
+	activities := h.Activity().Search(rs.Env(), q.Activity().User().Equals(rs))
+	res := events.ICSTimezones()
+	for _, event := range events.Records() {
+		res = append(res, event.ICSEvent())
+	}
+	for _, activity := range activities.Records() {
+		res = append(res, activity.ICSEvent())
+	}
+	return res
+}
+
+// CalendarFeed returns the full iCalendar feed of this user
+func user_CalendarFeed(rs m.UserSet) string {
+	rs.EnsureOne()
+	return ICSCalendar(rs.Name(), rs.CalendarFeedEvents()...)
+}
+
 // init
 func init() {
 	models.NewTransientModel("UserChangePasswordWizard")
@@ -698,6 +806,10 @@ func init() {
 	h.User().NewMethod("ActionGet", user_ActionGet)
 	h.User().NewMethod("UpdateLastLogin", user_UpdateLastLogin)
 	h.User().NewMethod("GetLoginDomain", user_GetLoginDomain)
+	h.User().NewMethod("ResetCalendarToken", user_ResetCalendarToken)
+	h.User().NewMethod("ComputeCalendarFeedURL", user_ComputeCalendarFeedURL)
+	h.User().NewMethod("CalendarFeedEvents", user_CalendarFeedEvents)
+	h.User().NewMethod("CalendarFeed", user_CalendarFeed)
 	h.User().NewMethod("Authenticate", user_Authenticate)
 	h.User().NewMethod("GetSessionTokenFields", user_GetSessionTokenFields)
 	h.User().NewMethod("ComputeSessionToken", user_ComputeSessionToken)
@@ -718,4 +830,12 @@ func init() {
 	h.User().NewMethod("CurrentUser", user_CurrentUser)
 
 	security.AuthenticationRegistry.RegisterBackend(new(AuthBackend))
+
+	RegisterIndex(IndexDefinition{
+		Name:        "user_calendar_token_uniq",
+		Model:       "User",
+		Expressions: "calendar_token",
+		Where:       "calendar_token IS NOT NULL AND calendar_token != ''",
+		Unique:      true,
+	})
 }
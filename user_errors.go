@@ -0,0 +1,93 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+)
+
+// A UserError is an error caused by an invalid user action, whose message is
+// translated and meant to be displayed as is to the user. Methods panic with
+// a UserError so that the transaction is rolled back, and the client gets
+// the structured error returned by Data instead of a server error.
+//
+// UserErrors are not sent to the error reporters since they are not bugs.
+type UserError struct {
+	Message string
+}
+
+// Error returns the message of the error
+func (e *UserError) Error() string {
+	return e.Message
+}
+
+// Data returns the description of the error sent to the client
+func (e *UserError) Data() map[string]interface{} {
+	return map[string]interface{}{
+		"name":    "UserError",
+		"message": e.Message,
+	}
+}
+
+// A ValidationError is a UserError raised when the values of some fields of
+// a record do not satisfy a constraint.
+type ValidationError struct {
+	UserError
+	// Model and Fields identify the invalid values
+	Model  string
+	Fields []string
+}
+
+// Data returns the description of the error sent to the client
+func (e *ValidationError) Data() map[string]interface{} {
+	res := e.UserError.Data()
+	res["name"] = "ValidationError"
+	res["model"] = e.Model
+	res["fields"] = e.Fields
+	return res
+}
+
+// A userErrorSource is a RecordSet from which user errors are raised
+type userErrorSource interface {
+	ModelName() string
+	T(string, ...interface{}) string
+}
+
+// NewUserError returns a UserError whose message is the given format
+// translated in the language of rs, and formatted with args.
+func NewUserError(rs userErrorSource, format string, args ...interface{}) *UserError {
+	return &UserError{Message: rs.T(format, args...)}
+}
+
+// NewValidationError returns a ValidationError for the given fields of the
+// model of rs, whose message is the given format translated in the language
+// of rs, and formatted with args.
+func NewValidationError(rs userErrorSource, fields []string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{
+		UserError: UserError{Message: rs.T(format, args...)},
+		Model:     rs.ModelName(),
+		Fields:    fields,
+	}
+}
+
+// A userFacingError is an error whose message is meant for the user
+type userFacingError interface {
+	error
+	Data() map[string]interface{}
+}
+
+// UserErrorData returns the description of the given panic value to send to
+// the client and true if it is a UserError or a ValidationError, or nil and
+// false otherwise.
+func UserErrorData(r interface{}) (map[string]interface{}, bool) {
+	err, ok := r.(error)
+	if !ok {
+		return nil, false
+	}
+	var userErr userFacingError
+	if !errors.As(err, &userErr) {
+		return nil, false
+	}
+	return userErr.Data(), true
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// recoverPanic calls f and returns the value it panicked with, if any
+func recoverPanic(f func()) (r interface{}) {
+	defer func() {
+		r = recover()
+	}()
+	f()
+	return nil
+}
+
+func TestUserErrors(t *testing.T) {
+	Convey("Testing user errors", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			partners := h.Partner().NewSet(env)
+			Convey("User errors are described for the client", func() {
+				data, ok := UserErrorData(NewUserError(partners, "Wrong %s", "value"))
+				So(ok, ShouldBeTrue)
+				So(data, ShouldResemble, map[string]interface{}{"name": "UserError", "message": "Wrong value"})
+				data, ok = UserErrorData(fmt.Errorf("wrapped: %w", NewValidationError(partners, []string{"Name"}, "Invalid name")))
+				So(ok, ShouldBeTrue)
+				So(data["name"], ShouldEqual, "ValidationError")
+				So(data["model"], ShouldEqual, "Partner")
+				So(data["fields"], ShouldResemble, []string{"Name"})
+				_, ok = UserErrorData(errors.New("internal error"))
+				So(ok, ShouldBeFalse)
+				_, ok = UserErrorData("not an error")
+				So(ok, ShouldBeFalse)
+			})
+			Convey("Partner validation raises validation errors", func() {
+				parent := h.Partner().Create(env, h.Partner().NewData().SetName("Error Parent"))
+				child := h.Partner().Create(env, h.Partner().NewData().SetName("Error Child").SetParent(parent))
+				r := recoverPanic(func() { parent.SetParent(child) })
+				verr, ok := r.(*ValidationError)
+				So(ok, ShouldBeTrue)
+				So(verr.Fields, ShouldResemble, []string{"Parent"})
+				So(verr.Error(), ShouldContainSubstring, "recursive Partner hierarchies")
+				r = recoverPanic(func() { partners.CleanWebsite("http://[invalid") })
+				verr, ok = r.(*ValidationError)
+				So(ok, ShouldBeTrue)
+				So(verr.Fields, ShouldResemble, []string{"Website"})
+			})
+			Convey("User errors are not reported", func() {
+				reporter := new(testErrorReporter)
+				RegisterErrorReporter(reporter)
+				defer func() {
+					errorReporters.Lock()
+					errorReporters.reporters = errorReporters.reporters[:len(errorReporters.reporters)-1]
+					errorReporters.Unlock()
+				}()
+				ReportError(ErrorReport{Error: NewUserError(partners, "Not a bug")})
+				So(reporter.reports, ShouldBeEmpty)
+			})
+		}), ShouldBeNil)
+	})
+}
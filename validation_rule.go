@@ -0,0 +1,206 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/erlangs/hexya-base/basetypes"
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/fields"
+	"github.com/erlangs/pool/h"
+	"github.com/erlangs/pool/m"
+	"github.com/erlangs/pool/q"
+)
+
+var fields_ValidationRule = map[string]models.FieldDefinition{
+	"Name": fields.Char{Required: true, Translate: true},
+	"Model": fields.Char{Required: true, Index: true, Constraint: h.ValidationRule().Methods().CheckDomains(),
+		Help: "Name of the model whose records are checked, e.g. Partner"},
+	"FilterDomain": fields.Text{String: "Applies To", Default: models.DefaultValue("[]"),
+		Constraint: h.ValidationRule().Methods().CheckDomains(),
+		Help:       `Domain of the records this rule applies to, e.g. [('customer_rank', '>', 0)]. Leave empty to apply to all records.`},
+	"Domain": fields.Text{String: "Condition", Required: true, Constraint: h.ValidationRule().Methods().CheckDomains(),
+		Help: `Domain that the records must match, e.g. [('country_id', '!=', False)]`},
+	"Message": fields.Char{String: "Error Message", Required: true, Translate: true,
+		Help: "Message displayed to users when a record does not match the condition"},
+	"Companies": fields.Many2Many{RelationModel: h.Company(), JSON: "company_ids",
+		Help: "Companies for which this rule is enforced. Leave empty to enforce it for all companies."},
+	"Active": fields.Boolean{Default: models.DefaultValue(true), Required: true},
+}
+
+// validationRuleModels caches the names of the models having active validation
+// rules. It is loaded lazily and cleared each time a rule is modified, so that
+// creating and writing records of other models does not query the rules table.
+// The rules themselves are always read in the current transaction.
+var validationRuleModels = struct {
+	sync.RWMutex
+	models map[string]bool
+}{}
+
+// invalidateValidationRules clears the validation rule models cache
+func invalidateValidationRules() {
+	validationRuleModels.Lock()
+	defer validationRuleModels.Unlock()
+	validationRuleModels.models = nil
+}
+
+// hasValidationRules returns true if the given model may have active validation rules
+func hasValidationRules(env models.Environment, model string) bool {
+	validationRuleModels.RLock()
+	if validationRuleModels.models != nil {
+		defer validationRuleModels.RUnlock()
+		return validationRuleModels.models[model]
+	}
+	validationRuleModels.RUnlock()
+	res := make(map[string]bool)
+	for _, rule := range h.ValidationRule().NewSet(env).AsSuperUser("load validation rule models").SearchAll().Records() {
+		res[rule.Model()] = true
+	}
+	validationRuleModels.Lock()
+	defer validationRuleModels.Unlock()
+	validationRuleModels.models = res
+	return res[model]
+}
+
+// checkDomain returns an error if the given domain refers to unknown fields
+// or operators of the given model.
+func checkDomain(env models.Environment, model string, dom models.Domain) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if len(dom) > 0 {
+		asSuperUser(env.Pool(model), "check validation rule domain").Search(models.ParseDomain(dom)).SearchCount()
+	}
+	return nil
+}
+
+// CheckDomains checks that the model of the rule exists and that its
+// domains are valid for this model.
+func validationRule_CheckDomains(rs m.ValidationRuleSet) {
+	for _, rule := range rs.Records() {
+		if _, exists := models.Registry.Get(rule.Model()); !exists {
+			log.Panic(rs.T("Unknown model '%s'", rule.Model()))
+		}
+		for _, str := range []string{rule.FilterDomain(), rule.Domain()} {
+			dom, err := ParseDomainString(str)
+			if err == nil {
+				err = checkDomain(rs.Env(), rule.Model(), dom)
+			}
+			if err != nil {
+				log.Panic(rs.T("Invalid domain %s for rule %s: %s", str, rule.Name(), err))
+			}
+		}
+	}
+}
+
+// CheckValidationRules checks that these records match the active validation
+// rules of their model for their company. It panics with the message
+// of the first rule which is not matched.
+//
+// Records without company are checked against the rules of the current
+// user's company.
+func baseMixin_CheckValidationRules(rs m.BaseMixinSet) {
+	model := rs.ModelName()
+	if model == "ValidationRule" || rs.IsEmpty() || ContextGetBool(rs.Env(), ContextKeyInstallMode) {
+		return
+	}
+	if !hasValidationRules(rs.Env(), model) {
+		return
+	}
+	userCompany := h.User().NewSet(rs.Env()).CurrentUser().Company()
+	records := asSuperUser(rs.Collection(), "evaluate validation rules")
+	_, hasCompany := records.Model().Fields().Get("Company")
+	idCond := records.Model().Field(models.ID).In(rs.Ids())
+	rules := h.ValidationRule().NewSet(rs.Env()).AsSuperUser("evaluate validation rules").Search(q.ValidationRule().Model().Equals(model))
+	for _, rule := range rules.Records() {
+		cond := idCond
+		if rule.Companies().IsNotEmpty() {
+			userCompanyActive := rule.Companies().Intersect(userCompany).IsNotEmpty()
+			switch {
+			case hasCompany:
+				companyField := records.Model().FieldName("Company")
+				companyCond := records.Model().Field(companyField).In(rule.Companies().Ids())
+				if userCompanyActive {
+					companyCond = companyCond.Or().Field(companyField).IsNull()
+				}
+				cond = cond.AndCond(companyCond)
+			case !userCompanyActive:
+				continue
+			}
+		}
+		filter, err := ParseDomainString(rule.FilterDomain())
+		if err != nil {
+			log.Panic(rs.T("Invalid domain %s for rule %s: %s", rule.FilterDomain(), rule.Name(), err))
+		}
+		domain, err := ParseDomainString(rule.Domain())
+		if err != nil {
+			log.Panic(rs.T("Invalid domain %s for rule %s: %s", rule.Domain(), rule.Name(), err))
+		}
+		if len(filter) > 0 {
+			cond = cond.AndCond(models.ParseDomain(filter))
+		}
+		applicable := records.Search(cond)
+		if applicable.IsEmpty() {
+			continue
+		}
+		matching := records.Search(cond.AndCond(models.ParseDomain(domain)))
+		if matching.Len() == applicable.Len() {
+			continue
+		}
+		var names []string
+		for _, rn := range applicable.Subtract(matching).Call("NameGetMulti").([]basetypes.RecordName) {
+			names = append(names, rn.Name)
+		}
+		log.Panic(rs.T("%s\nRecords: %s", rule.Message(), strings.Join(names, ", ")))
+	}
+}
+
+func baseMixin_CreateCheckValidationRules(rs m.BaseMixinSet, data m.BaseMixinData) m.BaseMixinSet {
+	res := rs.Super().Create(data)
+	res.CheckValidationRules()
+	return res
+}
+
+func baseMixin_WriteCheckValidationRules(rs m.BaseMixinSet, data m.BaseMixinData) bool {
+	res := rs.Super().Write(data)
+	rs.CheckValidationRules()
+	return res
+}
+
+func validationRule_Create(rs m.ValidationRuleSet, data m.ValidationRuleData) m.ValidationRuleSet {
+	res := rs.Super().Create(data)
+	invalidateValidationRules()
+	return res
+}
+
+func validationRule_Write(rs m.ValidationRuleSet, data m.ValidationRuleData) bool {
+	res := rs.Super().Write(data)
+	invalidateValidationRules()
+	return res
+}
+
+func validationRule_Unlink(rs m.ValidationRuleSet) int64 {
+	res := rs.Super().Unlink()
+	invalidateValidationRules()
+	return res
+}
+
+func init() {
+	models.NewModel("ValidationRule")
+	h.ValidationRule().SetDefaultOrder("Model", "Name")
+	h.ValidationRule().AddFields(fields_ValidationRule)
+	h.ValidationRule().NewMethod("CheckDomains", validationRule_CheckDomains)
+	h.ValidationRule().Methods().Create().Extend(validationRule_Create)
+	h.ValidationRule().Methods().Write().Extend(validationRule_Write)
+	h.ValidationRule().Methods().Unlink().Extend(validationRule_Unlink)
+
+	h.BaseMixin().NewMethod("CheckValidationRules", baseMixin_CheckValidationRules)
+	h.BaseMixin().Methods().Create().Extend(baseMixin_CreateCheckValidationRules)
+	h.BaseMixin().Methods().Write().Extend(baseMixin_WriteCheckValidationRules)
+}
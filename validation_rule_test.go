@@ -0,0 +1,77 @@
+// Copyright 2020 NDP Systèmes. All Rights Reserved.
+// See LICENSE file for full licensing details.
+
+package base
+
+import (
+	"testing"
+
+	"github.com/erlangs/okoo/src/models"
+	"github.com/erlangs/okoo/src/models/security"
+	"github.com/erlangs/pool/h"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidationRules(t *testing.T) {
+	Convey("Testing validation rules", t, func() {
+		So(models.SimulateInNewEnvironment(security.SuperUserID, func(env models.Environment) {
+			france := h.Country().NewSet(env).GetRecord("base_fr")
+			rule := h.ValidationRule().Create(env, h.ValidationRule().NewData().
+				SetName("Companies need a country").
+				SetModel("Partner").
+				SetFilterDomain(`[["is_company", "=", true]]`).
+				SetDomain(`[["country_id", "!=", false]]`).
+				SetMessage("Companies must have a country"))
+			Convey("Invalid rules are rejected", func() {
+				So(func() {
+					h.ValidationRule().Create(env, h.ValidationRule().NewData().
+						SetName("Unknown field").
+						SetModel("Partner").
+						SetDomain(`[["no_such_field", "=", 1]]`).
+						SetMessage("Error"))
+				}, ShouldPanic)
+				So(func() {
+					h.ValidationRule().Create(env, h.ValidationRule().NewData().
+						SetName("Not a domain").
+						SetModel("Partner").
+						SetDomain(`country_id != False`).
+						SetMessage("Error"))
+				}, ShouldPanic)
+			})
+			Convey("Records outside the filter are not checked", func() {
+				So(func() {
+					h.Partner().Create(env, h.Partner().NewData().SetName("Individual"))
+				}, ShouldNotPanic)
+			})
+			Convey("Records not matching the condition are rejected on create and write", func() {
+				So(func() {
+					h.Partner().Create(env, h.Partner().NewData().SetName("Nowhere Inc").SetIsCompany(true))
+				}, ShouldPanic)
+				company := h.Partner().Create(env, h.Partner().NewData().
+					SetName("Somewhere Inc").
+					SetIsCompany(true).
+					SetCountry(france))
+				So(func() { company.SetCountry(h.Country().NewSet(env)) }, ShouldPanic)
+			})
+			Convey("Rules can be restricted to some companies", func() {
+				other := h.Company().Create(env, h.Company().NewData().SetName("Other Company"))
+				rule.SetCompanies(other)
+				So(func() {
+					h.Partner().Create(env, h.Partner().NewData().SetName("Nowhere Inc").SetIsCompany(true))
+				}, ShouldNotPanic)
+				So(func() {
+					h.Partner().Create(env, h.Partner().NewData().
+						SetName("Nowhere Inc").
+						SetIsCompany(true).
+						SetCompany(other))
+				}, ShouldPanic)
+			})
+			Convey("Archived rules are not enforced", func() {
+				rule.SetActive(false)
+				So(func() {
+					h.Partner().Create(env, h.Partner().NewData().SetName("Nowhere Inc").SetIsCompany(true))
+				}, ShouldNotPanic)
+			})
+		}), ShouldBeNil)
+	})
+}